@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"chirm/internal/auth"
+	"chirm/internal/backup"
+	"chirm/internal/db"
+	"chirm/internal/handlers"
+)
+
+// ─── chirm admin ──────────────────────────────────────────────────────────────
+//
+// Maintenance commands that work on the database directly, for an owner
+// locked out of their server or scripts that shouldn't need the HTTP API.
+// They read DATA_DIR the same way the server does, and can run while it's up.
+
+const adminUsage = `Usage: chirm [--config file] admin <command> [options]
+
+Commands:
+  create-admin --username NAME --email EMAIL [--owner] [--password-stdin]
+                               add a user with the Admin role (or as an owner)
+  reset-password [--password-stdin] USER
+                               set a new password for USER (username or email)
+  recover [--password-stdin] CODE
+                               set a new password for the owner whose
+                               recovery code CODE is, using it up
+  recovery-codes USER          make a new set of recovery codes for owner
+                               USER, replacing the old
+  list-users                   list every account and its roles
+  backup [FILE]                copy the database to FILE
+                               (default chirm-backup-<time>.db)
+  backup --remote              push an encrypted backup to BACKUP_TARGET now
+  restore-remote [--list] [--into DIR] [NAME]
+                               unpack backup NAME (default the newest) from
+                               BACKUP_TARGET into DIR (default DATA_DIR)
+  migrate                      bring the database schema up to date
+  vacuum                       compact the database file
+  clear-ip-rules               delete every IP allow and deny rule
+  list-jwt-keys                list the keys session tokens are signed with
+  add-jwt-key                  add a key to sign session tokens with from now on
+  retire-jwt-key ID            stop accepting tokens signed with key ID
+
+Without --password-stdin a random password is made up and printed.
+`
+
+// runAdmin runs `chirm admin args...` and returns the exit status.
+func runAdmin(args []string) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Fprint(os.Stderr, adminUsage)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+	commands := map[string]func(*db.DB, []string) error{
+		"create-admin":   adminCreateAdmin,
+		"reset-password": adminResetPassword,
+		"recover":        adminRecover,
+		"recovery-codes": adminRecoveryCodes,
+		"list-users":     adminListUsers,
+		"backup":         adminBackup,
+		"migrate":        adminMigrate,
+		"vacuum":         adminVacuum,
+		"clear-ip-rules": adminClearIPRules,
+		"list-jwt-keys":  adminListJWTKeys,
+		"add-jwt-key":    adminAddJWTKey,
+		"retire-jwt-key": adminRetireJWTKey,
+	}
+	if args[0] == "restore-remote" {
+		// Run on a new machine, there's no database yet.
+		if err := adminRestoreRemote(args[1:]); err != nil {
+			if !errors.Is(err, flag.ErrHelp) {
+				fmt.Fprintf(os.Stderr, "chirm admin %s: %v\n", args[0], err)
+			}
+			return 1
+		}
+		return 0
+	}
+	run, known := commands[args[0]]
+	if !known {
+		fmt.Fprintf(os.Stderr, "chirm admin: unknown command %q\n\n%s", args[0], adminUsage)
+		return 2
+	}
+
+	path := filepath.Join(getEnv("DATA_DIR", "./data"), "chirm.db")
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintf(os.Stderr, "chirm admin: no database at %s (set DATA_DIR)\n", path)
+		return 1
+	}
+	database, err := db.Open(path, dbPoolFromEnv())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "chirm admin: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+
+	if err := run(database, args[1:]); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "chirm admin %s: %v\n", args[0], err)
+		}
+		return 1
+	}
+	return 0
+}
+
+// adminPassword returns the password to set: one line read from stdin with
+// --password-stdin, or else a random one, which is printed.
+func adminPassword(fromStdin bool) (string, error) {
+	if !fromStdin {
+		const letters = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+		b := make([]byte, 16)
+		for i := range b {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(letters))))
+			if err != nil {
+				return "", err
+			}
+			b[i] = letters[n.Int64()]
+		}
+		fmt.Printf("Password: %s\n", b)
+		return string(b), nil
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if len(password) < 8 {
+		return "", errors.New("password must be at least 8 characters")
+	}
+	return password, nil
+}
+
+func adminCreateAdmin(database *db.DB, args []string) error {
+	fs := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	username := fs.String("username", "", "username")
+	email := fs.String("email", "", "email address")
+	owner := fs.Bool("owner", false, "make the user an owner rather than giving them the Admin role")
+	fromStdin := fs.Bool("password-stdin", false, "read the password from stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	*username, *email = strings.TrimSpace(*username), strings.TrimSpace(*email)
+	if *username == "" || *email == "" {
+		return errors.New("--username and --email are required")
+	}
+	if !handlers.ValidUsername(*username) {
+		return errors.New("username must be 2-32 letters, digits, _ . or -")
+	}
+	if _, err := database.GetUserByUsername(*username); err == nil {
+		return fmt.Errorf("username %s is taken", *username)
+	}
+	if _, err := database.GetUserByEmail(*email); err == nil {
+		return fmt.Errorf("email %s is already registered", *email)
+	}
+
+	var role *db.Role
+	if !*owner {
+		var err error
+		if role, err = adminRole(database); err != nil {
+			return err
+		}
+	}
+	password, err := adminPassword(*fromStdin)
+	if err != nil {
+		return err
+	}
+	hash, err := auth.New("").HashPassword(password)
+	if err != nil {
+		return err
+	}
+	u, err := database.CreateUser(*username, *email, hash, *owner)
+	if err != nil {
+		return err
+	}
+	what := "owner"
+	if role != nil {
+		if err := database.AssignRole(u.ID, role.ID); err != nil {
+			return err
+		}
+		what = "admin"
+	}
+	database.AddAuditEntry(db.AuditEntry{
+		Action:   "user.create",
+		TargetID: u.ID,
+		Details:  "created " + what + " " + u.Username + " from the command line",
+	})
+	fmt.Printf("Created %s %s (%s)\n", what, u.Username, u.ID)
+	return nil
+}
+
+// adminRole returns a role with the Administrator permission, making an
+// "Admin" role if there's none.
+func adminRole(database *db.DB) (*db.Role, error) {
+	roles, err := database.ListRoles(db.DefaultGuild)
+	if err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		if roles[i].Permissions&db.PermAdministrator != 0 {
+			return &roles[i], nil
+		}
+	}
+	return database.CreateRole(db.DefaultGuild, "Admin", "#e05252", db.PermAdministrator)
+}
+
+func adminResetPassword(database *db.DB, args []string) error {
+	fs := flag.NewFlagSet("reset-password", flag.ContinueOnError)
+	fromStdin := fs.Bool("password-stdin", false, "read the new password from stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("give one username or email")
+	}
+	name := fs.Arg(0)
+	u, err := database.GetUserByUsername(name)
+	if err != nil {
+		if u, err = database.GetUserByEmail(name); err != nil {
+			return fmt.Errorf("no user %s", name)
+		}
+	}
+	password, err := adminPassword(*fromStdin)
+	if err != nil {
+		return err
+	}
+	hash, err := auth.New("").HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := database.SetPasswordHash(u.ID, hash); err != nil {
+		return err
+	}
+	database.AddAuditEntry(db.AuditEntry{
+		Action:   "user.password_reset",
+		TargetID: u.ID,
+		Details:  "reset the password of " + u.Username + " from the command line",
+	})
+	fmt.Printf("Password for %s changed. Sessions already signed in stay signed in until they expire.\n", u.Username)
+	return nil
+}
+
+func adminRecover(database *db.DB, args []string) error {
+	fs := flag.NewFlagSet("recover", flag.ContinueOnError)
+	fromStdin := fs.Bool("password-stdin", false, "read the new password from stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("give one recovery code")
+	}
+	userID, err := database.UseRecoveryCode(fs.Arg(0))
+	if err != nil {
+		return errors.New("no such unused recovery code")
+	}
+	u, err := database.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	password, err := adminPassword(*fromStdin)
+	if err != nil {
+		return err
+	}
+	hash, err := auth.New("").HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := database.SetPasswordHash(u.ID, hash); err != nil {
+		return err
+	}
+	left := database.RecoveryCodesLeft(u.ID)
+	database.AddAuditEntry(db.AuditEntry{
+		Action:   "user.password_reset",
+		TargetID: u.ID,
+		Details:  fmt.Sprintf("reset the password of %s with a recovery code from the command line; %d left", u.Username, left),
+	})
+	fmt.Printf("Password for %s changed. %d recovery codes left.\n", u.Username, left)
+	return nil
+}
+
+func adminRecoveryCodes(database *db.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("give one username or email")
+	}
+	u, err := database.GetUserByUsername(args[0])
+	if err != nil {
+		if u, err = database.GetUserByEmail(args[0]); err != nil {
+			return fmt.Errorf("no user %s", args[0])
+		}
+	}
+	if !u.IsOwner {
+		return fmt.Errorf("%s isn't an owner; only owners have recovery codes", u.Username)
+	}
+	codes, err := database.NewRecoveryCodes(u.ID)
+	if err != nil {
+		return err
+	}
+	database.AddAuditEntry(db.AuditEntry{
+		Action:   "user.recovery_codes",
+		TargetID: u.ID,
+		Details:  "made new recovery codes for " + u.Username + " from the command line",
+	})
+	fmt.Printf("New recovery codes for %s; each works once, and the old ones no longer do:\n\n", u.Username)
+	for _, c := range codes {
+		fmt.Println("  " + c)
+	}
+	return nil
+}
+
+func adminListUsers(database *db.DB, args []string) error {
+	if len(args) > 0 {
+		return errors.New("takes no arguments")
+	}
+	users, err := database.ListUsers()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSERNAME\tEMAIL\tROLES\tCREATED")
+	for _, u := range users {
+		var roles []string
+		if u.IsOwner {
+			roles = append(roles, "(owner)")
+		}
+		for _, r := range u.Roles {
+			roles = append(roles, r.Name)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", u.ID, u.Username, u.Email,
+			strings.Join(roles, ", "), u.CreatedAt.Format("2006-01-02"))
+	}
+	return tw.Flush()
+}
+
+func adminBackup(database *db.DB, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	remote := fs.Bool("remote", false, "push an encrypted backup to BACKUP_TARGET")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *remote {
+		if fs.NArg() > 0 {
+			return errors.New("--remote takes no file")
+		}
+		return adminBackupRemote(database)
+	}
+	if fs.NArg() > 1 {
+		return errors.New("give at most one file")
+	}
+	path := "chirm-backup-" + time.Now().Format("20060102-150405") + ".db"
+	if fs.NArg() == 1 {
+		path = fs.Arg(0)
+	}
+	if err := database.Backup(path); err != nil {
+		return err
+	}
+	fmt.Printf("Database backed up to %s (uploads in DATA_DIR/uploads aren't included)\n", path)
+	return nil
+}
+
+func adminBackupRemote(database *db.DB) error {
+	remote, err := backupRemoteFromEnv()
+	if err != nil {
+		return err
+	}
+	if remote == nil {
+		return errors.New("BACKUP_TARGET is not set")
+	}
+	cfg, err := backupConfig(database, getEnv("DATA_DIR", "./data"), remote)
+	if err != nil {
+		return err
+	}
+	name, err := pushBackup(database, cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backup %s pushed to %s\n", name, remote.Describe())
+	return nil
+}
+
+func adminRestoreRemote(args []string) error {
+	fs := flag.NewFlagSet("restore-remote", flag.ContinueOnError)
+	list := fs.Bool("list", false, "list the backups instead")
+	into := fs.String("into", getEnv("DATA_DIR", "./data"), "directory to unpack into")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return errors.New("give at most one backup name")
+	}
+	remote, err := backupRemoteFromEnv()
+	if err != nil {
+		return err
+	}
+	if remote == nil {
+		return errors.New("BACKUP_TARGET is not set")
+	}
+	if *list {
+		names, err := backup.Archives(remote)
+		if err != nil {
+			return err
+		}
+		for _, n := range names {
+			fmt.Println(n)
+		}
+		return nil
+	}
+	name, err := backup.Restore(remote, os.Getenv("BACKUP_PASSPHRASE"), fs.Arg(0), *into)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s into %s\n", name, *into)
+	return nil
+}
+
+func adminMigrate(database *db.DB, args []string) error {
+	if len(args) > 0 {
+		return errors.New("takes no arguments")
+	}
+	// Opening the database already ran the migrations.
+	fmt.Println("Database schema is up to date")
+	return nil
+}
+
+func adminVacuum(database *db.DB, args []string) error {
+	if len(args) > 0 {
+		return errors.New("takes no arguments")
+	}
+	path := filepath.Join(getEnv("DATA_DIR", "./data"), "chirm.db")
+	before := fileSize(path) + fileSize(path+"-wal")
+	if err := database.Vacuum(); err != nil {
+		return err
+	}
+	after := fileSize(path) + fileSize(path+"-wal")
+	fmt.Printf("Database compacted: %d KB → %d KB\n", before/1024, after/1024)
+	return nil
+}
+
+func adminClearIPRules(database *db.DB, args []string) error {
+	if len(args) > 0 {
+		return errors.New("takes no arguments")
+	}
+	n, err := database.ClearIPRules()
+	if err != nil {
+		return err
+	}
+	// The server keeps the rules in memory.
+	fmt.Printf("Deleted %d IP rules; restart Chirm if it's running\n", n)
+	return nil
+}
+
+func adminListJWTKeys(database *db.DB, args []string) error {
+	if len(args) > 0 {
+		return errors.New("takes no arguments")
+	}
+	keys, err := database.ListJWTKeys()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tADDED\tSTATE")
+	signing := false
+	for _, k := range keys {
+		added := "(JWT_SECRET)"
+		if k.CreatedAt != nil {
+			added = k.CreatedAt.Local().Format("2006-01-02 15:04")
+		}
+		state := "accepted"
+		switch {
+		case k.RetiredAt != nil:
+			state = "retired " + k.RetiredAt.Local().Format("2006-01-02 15:04")
+		case !signing:
+			state, signing = "signing", true
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", k.ID, added, state)
+	}
+	return tw.Flush()
+}
+
+func adminAddJWTKey(database *db.DB, args []string) error {
+	if len(args) > 0 {
+		return errors.New("takes no arguments")
+	}
+	k, err := database.AddJWTKey()
+	if err != nil {
+		return err
+	}
+	database.AddAuditEntry(db.AuditEntry{
+		Action:   "jwt_key.add",
+		TargetID: k.ID,
+		Details:  "added token signing key " + k.ID + " from the command line",
+	})
+	// The server keeps the keys in memory.
+	fmt.Printf("Added key %s; restart Chirm if it's running, and it signs new session tokens with it\n", k.ID)
+	fmt.Println("Once sessions have moved over to it, retire the old key with chirm admin retire-jwt-key.")
+	return nil
+}
+
+func adminRetireJWTKey(database *db.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("give one key ID (see list-jwt-keys)")
+	}
+	switch err := database.RetireJWTKey(args[0]); {
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("no key %s in use", args[0])
+	case errors.Is(err, db.ErrLastJWTKey):
+		return errors.New("it's the only key left; add another first")
+	case err != nil:
+		return err
+	}
+	database.AddAuditEntry(db.AuditEntry{
+		Action:   "jwt_key.retire",
+		TargetID: args[0],
+		Details:  "retired token signing key " + args[0] + " from the command line",
+	})
+	// The server keeps the keys in memory.
+	fmt.Printf("Retired key %s; restart Chirm if it's running\n", args[0])
+	return nil
+}
+
+func fileSize(path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
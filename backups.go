@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"chirm/internal/backup"
+	"chirm/internal/db"
+	"chirm/internal/jobs"
+	"chirm/internal/storage"
+)
+
+// ─── Off-site backups ────────────────────────────────────────────────────────
+//
+// With BACKUP_TARGET set, the server pushes an encrypted archive of DATA_DIR
+// there every BACKUP_INTERVAL and keeps the newest BACKUP_KEEP.  `chirm admin
+// backup --remote` pushes one at once and `chirm admin restore-remote` gets
+// one back.
+
+// backupRemoteFromEnv returns the remote BACKUP_TARGET names, or nil when
+// it's unset.  S3 settings left out fall back to the uploads bucket's.
+func backupRemoteFromEnv() (backup.Remote, error) {
+	target := os.Getenv("BACKUP_TARGET")
+	if target == "" {
+		return nil, nil
+	}
+	if os.Getenv("BACKUP_PASSPHRASE") == "" {
+		return nil, errors.New("BACKUP_TARGET is set but BACKUP_PASSPHRASE is empty; backups are always encrypted")
+	}
+	s3 := storage.S3Config{
+		Endpoint:      getEnv("BACKUP_S3_ENDPOINT", os.Getenv("S3_ENDPOINT")),
+		Region:        getEnv("BACKUP_S3_REGION", os.Getenv("S3_REGION")),
+		AccessKey:     getEnv("BACKUP_S3_ACCESS_KEY", os.Getenv("S3_ACCESS_KEY")),
+		SecretKey:     getEnv("BACKUP_S3_SECRET_KEY", os.Getenv("S3_SECRET_KEY")),
+		VirtualHosted: getEnv("BACKUP_S3_VIRTUAL_HOSTED", os.Getenv("S3_VIRTUAL_HOSTED")) == "1",
+	}
+	return backup.ParseTarget(target, s3, getEnv("RCLONE_PATH", "rclone"))
+}
+
+// backupConfig describes backing up database and dataDir to remote.
+func backupConfig(database *db.DB, dataDir string, remote backup.Remote) (backup.Config, error) {
+	keep := envInt("BACKUP_KEEP", 7)
+	if keep < 0 {
+		return backup.Config{}, fmt.Errorf("invalid BACKUP_KEEP %d", keep)
+	}
+	return backup.Config{
+		Remote:     remote,
+		Passphrase: os.Getenv("BACKUP_PASSPHRASE"),
+		Keep:       keep,
+		DataDir:    dataDir,
+		Snapshot:   database.Backup,
+	}, nil
+}
+
+// backupJob is the name backups are scheduled under.
+const backupJob = "backups"
+
+// pushBackup pushes a backup now, for `chirm admin backup --remote`, and
+// records it as a run of backupJob, so the schedule counts from it.
+func pushBackup(database *db.DB, cfg backup.Config) (string, error) {
+	start := time.Now()
+	name, err := backup.Push(cfg, start)
+	if name != "" {
+		database.RecordJobRun(backupJob, "chirm admin", start, time.Since(start))
+	}
+	return name, err
+}
+
+// startBackups schedules a backup every BACKUP_INTERVAL, counting from the
+// last one on any instance, so restarts don't keep putting it off.
+func startBackups(scheduler *jobs.Scheduler, database *db.DB, cfg backup.Config) {
+	every, err := time.ParseDuration(getEnv("BACKUP_INTERVAL", "24h"))
+	if err != nil || every < 0 {
+		fatal("invalid BACKUP_INTERVAL (want e.g. 24h, or 0 for only on demand)", "value", os.Getenv("BACKUP_INTERVAL"))
+	}
+	// Before the scheduler, the last backup's time was kept in a setting.
+	if last, _ := database.GetJobRun(backupJob); last.LastRun.IsZero() {
+		if v, _ := database.GetSetting("backup_last_at"); v != "" {
+			if t, err := time.Parse(time.RFC3339, v); err == nil {
+				database.RecordJobRun(backupJob, "", t, 0)
+			}
+		}
+	}
+	if every == 0 {
+		slog.Info("backups: on demand only", "target", cfg.Remote.Describe())
+	} else {
+		slog.Info("backups: scheduled", "target", cfg.Remote.Describe(), "every", every, "keep", cfg.Keep)
+	}
+	scheduler.Add(jobs.Job{
+		Name:   backupJob,
+		Every:  every,
+		Retry:  time.Hour,
+		Delay:  time.Minute, // first backup soon after the first start
+		Jitter: 5 * time.Minute,
+		Shared: true,
+		Run: func() error {
+			start := time.Now()
+			name, err := backup.Push(cfg, start)
+			if name == "" {
+				return err // tried again in an hour
+			}
+			if err != nil {
+				// Pushed, but the old ones weren't pruned.
+				slog.Error("backup failed", "target", cfg.Remote.Describe(), "err", err)
+			}
+			slog.Info("backup pushed", "name", name, "target", cfg.Remote.Describe(), "took", time.Since(start).Round(time.Second))
+			return nil
+		},
+	})
+}
@@ -1,7 +1,13 @@
 package auth
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -9,18 +15,146 @@ import (
 )
 
 type Service struct {
-	secret []byte
+	env []byte // JWT_SECRET
+
+	mu         sync.RWMutex
+	keys       []Key  // accepted; the first signs
+	reload     func() // see OnUnknownKey
+	lastReload time.Time
+	bots       BotLookup // see OnBotToken
 }
 
+// BotLookup returns the user ID and name of the bot whose token is token,
+// or an error if it's no bot's.
+type BotLookup func(token string) (userID, username string, err error)
+
+// reloadEvery limits how often a token naming a key the service doesn't
+// know makes it reload its keys.
+const reloadEvery = 10 * time.Second
+
+// Key is a secret that signs tokens, named in their "kid" header.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// EnvKeyID names the key made from JWT_SECRET.  Tokens from before keys
+// had IDs were signed with it.
+const EnvKeyID = "env"
+
 type Claims struct {
 	UserID   string `json:"user_id"`
 	Username string `json:"username"`
 	IsOwner  bool   `json:"is_owner"`
+	Bot      bool   `json:"bot,omitempty"` // from a bot token, not a session
 	jwt.RegisteredClaims
+
+	kid string // of the key that signed it
+}
+
+// KeyID is the ID of the key the token was signed with.
+func (c *Claims) KeyID() string {
+	return c.kid
 }
 
 func New(secret string) *Service {
-	return &Service{secret: []byte(secret)}
+	env := []byte(secret)
+	return &Service{env: env, keys: []Key{{ID: EnvKeyID, Secret: env}}}
+}
+
+// SetKeys replaces the keys tokens may be signed with.  The first signs
+// new tokens; tokens signed with a key not in keys stop working.  The
+// secret of a key with ID EnvKeyID is JWT_SECRET's, whatever it's given as.
+func (s *Service) SetKeys(keys []Key) {
+	if len(keys) == 0 {
+		return
+	}
+	for i := range keys {
+		if keys[i].ID == EnvKeyID {
+			keys[i].Secret = s.env
+		}
+	}
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+}
+
+// OnUnknownKey sets a function for the service to call, at most every ten
+// seconds, when a token names a key it doesn't know, before refusing it.
+// It should call SetKeys, so a key added by another process sharing the
+// database is picked up.
+func (s *Service) OnUnknownKey(reload func()) {
+	s.mu.Lock()
+	s.reload = reload
+	s.mu.Unlock()
+}
+
+// OnBotToken sets how the service looks up bot tokens; until it's set,
+// none are accepted.
+func (s *Service) OnBotToken(lookup BotLookup) {
+	s.mu.Lock()
+	s.bots = lookup
+	s.mu.Unlock()
+}
+
+// ValidateBotToken checks a bot's token, from an Authorization: Bot
+// header.  Unlike a session token it doesn't expire, and stops working
+// when an admin resets it or deletes the bot.
+func (s *Service) ValidateBotToken(token string) (*Claims, error) {
+	s.mu.RLock()
+	lookup := s.bots
+	s.mu.RUnlock()
+	if lookup == nil {
+		return nil, errors.New("bot tokens are not accepted")
+	}
+	userID, username, err := lookup(token)
+	if err != nil {
+		return nil, err
+	}
+	return &Claims{UserID: userID, Username: username, Bot: true}, nil
+}
+
+// SigningKeyID is the ID of the key new tokens are signed with.
+func (s *Service) SigningKeyID() string {
+	return s.signingKey().ID
+}
+
+func (s *Service) signingKey() Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys[0]
+}
+
+func (s *Service) key(id string) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.keys {
+		if k.ID == id {
+			return k.Secret, true
+		}
+	}
+	return nil, false
+}
+
+// reloadKeys calls the OnUnknownKey function, unless it was called
+// recently, and reports whether it did.
+func (s *Service) reloadKeys() bool {
+	s.mu.Lock()
+	reload := s.reload
+	if reload == nil || time.Since(s.lastReload) < reloadEvery {
+		s.mu.Unlock()
+		return false
+	}
+	s.lastReload = time.Now()
+	s.mu.Unlock()
+	reload()
+	return true
+}
+
+func (s *Service) allKeys() []Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys
 }
 
 func (s *Service) HashPassword(password string) (string, error) {
@@ -42,16 +176,30 @@ func (s *Service) GenerateToken(userID, username string, isOwner bool) (string,
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
+	k := s.signingKey()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.secret)
+	token.Header["kid"] = k.ID
+	return token.SignedString(k.Secret)
 }
 
 func (s *Service) ValidateToken(tokenStr string) (*Claims, error) {
+	var kid string
 	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(t *jwt.Token) (interface{}, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
 		}
-		return s.secret, nil
+		kid, _ = t.Header["kid"].(string)
+		if kid == "" {
+			kid = EnvKeyID
+		}
+		secret, ok := s.key(kid)
+		if !ok && s.reloadKeys() {
+			secret, ok = s.key(kid)
+		}
+		if !ok {
+			return nil, errors.New("unknown or retired signing key")
+		}
+		return secret, nil
 	})
 	if err != nil {
 		return nil, err
@@ -60,5 +208,44 @@ func (s *Service) ValidateToken(tokenStr string) (*Claims, error) {
 	if !ok || !token.Valid {
 		return nil, errors.New("invalid token")
 	}
+	claims.kid = kid
 	return claims, nil
 }
+
+// ─── Signed URLs ─────────────────────────────────────────────────────────────
+
+// urlKey derives the key for signed URLs from a token key, so a URL
+// signature can never pass as a token signature or the other way round.
+func urlKey(secret []byte) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte("chirm signed urls"))
+	return m.Sum(nil)
+}
+
+func urlSignature(secret []byte, path string, expires int64) string {
+	m := hmac.New(sha256.New, urlKey(secret))
+	fmt.Fprintf(m, "%s\n%d", path, expires)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// SignURL returns path with an expiry time and a signature that VerifyURL
+// accepts until then, or until the key that made it is retired.
+func (s *Service) SignURL(path string, expires time.Time) string {
+	exp := expires.Unix()
+	return fmt.Sprintf("%s?exp=%d&sig=%s", path, exp, urlSignature(s.signingKey().Secret, path, exp))
+}
+
+// VerifyURL checks the exp and sig query parameters of a URL made by
+// SignURL for path.
+func (s *Service) VerifyURL(path, exp, sig string) bool {
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	for _, k := range s.allKeys() {
+		if hmac.Equal([]byte(sig), []byte(urlSignature(k.Secret, path, expires))) {
+			return true
+		}
+	}
+	return false
+}
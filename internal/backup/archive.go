@@ -0,0 +1,140 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// writeArchive writes a gzipped tar of the database copy at dbPath, as
+// chirm.db, and every regular file in dirs, under each dir's base name.
+// Directories that don't exist are skipped.
+func writeArchive(w io.Writer, dbPath string, dirs []string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := addFile(tw, dbPath, "chirm.db"); err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+		base := filepath.Base(dir)
+		err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			return addFile(tw, p, base+"/"+filepath.ToSlash(rel))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func addFile(tw *tar.Writer, src, name string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     fi.Size(),
+		Mode:     0o640,
+		ModTime:  fi.ModTime(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	// A file that grows while it's copied is cut at the size in the
+	// header; one that shrinks fails the archive rather than corrupting it.
+	_, err = io.CopyN(tw, f, fi.Size())
+	return err
+}
+
+// extractArchive unpacks an archive from writeArchive into dir.  It only
+// writes chirm.db and files under the data directories, and never replaces
+// an existing file.  If it fails, it removes the files it wrote.
+func extractArchive(r io.Reader, dir string) error {
+	var written []string
+	err := unpack(r, dir, &written)
+	if err != nil {
+		for _, p := range written {
+			os.Remove(p)
+		}
+	}
+	return err
+}
+
+func unpack(r io.Reader, dir string, written *[]string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || !allowedName(hdr.Name) {
+			return fmt.Errorf("unexpected entry %q", hdr.Name)
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o750); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+		if err != nil {
+			return err
+		}
+		*written = append(*written, dst)
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+}
+
+// allowedName reports whether an archive entry may be unpacked: chirm.db,
+// or a clean path inside one of dataDirs.
+func allowedName(name string) bool {
+	if name == "chirm.db" {
+		return true
+	}
+	if path.Clean(name) != name || strings.Contains(name, "\\") {
+		return false
+	}
+	for _, d := range dataDirs {
+		if strings.HasPrefix(name, d+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+// Package backup pushes encrypted archives of Chirm's data to off-site
+// storage — an S3-compatible bucket, a WebDAV server or any rclone remote —
+// keeps the newest few, and restores them.
+//
+// An archive is a gzipped tar of a consistent copy of the database plus the
+// uploads and recordings directories, encrypted with a key derived from a
+// passphrase (see crypt.go).  Archives are named by the time they were made,
+// so sorting their names sorts them oldest first.
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Remote is somewhere archives are kept.  Names are flat, like
+// "chirm-backup-20240101-030000.tar.gz.enc".
+type Remote interface {
+	Put(name string, r io.Reader, size int64) error
+	Open(name string) (io.ReadCloser, error)
+	// List returns the names of everything at the remote, archives or not.
+	List() ([]string, error)
+	Delete(name string) error
+	// Describe names the remote for logs, without credentials.
+	Describe() string
+}
+
+const (
+	namePrefix = "chirm-backup-"
+	nameSuffix = ".tar.gz.enc"
+	timeFormat = "20060102-150405"
+)
+
+// dataDirs are the directories under DATA_DIR archives include, when they
+// exist.  Caches and quarantined uploads aren't worth keeping.
+var dataDirs = []string{"uploads", "recordings"}
+
+// Config says what to back up and where.
+type Config struct {
+	Remote     Remote
+	Passphrase string
+	Keep       int // archives kept at the remote, newest first; 0 keeps every one
+	DataDir    string
+
+	// Snapshot writes a consistent copy of the database to path, which
+	// doesn't exist yet.
+	Snapshot func(path string) error
+}
+
+// Push makes an archive, sends it to the remote and then deletes the
+// archives beyond cfg.Keep.  It returns the new archive's name.
+func Push(cfg Config, now time.Time) (string, error) {
+	if cfg.Passphrase == "" {
+		return "", errors.New("no passphrase to encrypt backups with")
+	}
+	tmp, err := os.MkdirTemp(cfg.DataDir, ".backup-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	dbCopy := filepath.Join(tmp, "chirm.db")
+	if err := cfg.Snapshot(dbCopy); err != nil {
+		return "", fmt.Errorf("copying the database: %w", err)
+	}
+	f, err := os.Create(filepath.Join(tmp, "archive"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	enc, err := newEncrypter(f, cfg.Passphrase)
+	if err != nil {
+		return "", err
+	}
+	var dirs []string
+	for _, d := range dataDirs {
+		dirs = append(dirs, filepath.Join(cfg.DataDir, d))
+	}
+	if err := writeArchive(enc, dbCopy, dirs); err != nil {
+		return "", fmt.Errorf("writing the archive: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	name := namePrefix + now.UTC().Format(timeFormat) + nameSuffix
+	if err := cfg.Remote.Put(name, f, size); err != nil {
+		return "", fmt.Errorf("uploading %s: %w", name, err)
+	}
+	if cfg.Keep > 0 {
+		if _, err := Prune(cfg.Remote, cfg.Keep); err != nil {
+			return name, fmt.Errorf("pushed %s but couldn't delete old archives: %w", name, err)
+		}
+	}
+	return name, nil
+}
+
+// Archives returns the names of the archives at r, oldest first.
+func Archives(r Remote) ([]string, error) {
+	all, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, n := range all {
+		if strings.HasPrefix(n, namePrefix) && strings.HasSuffix(n, nameSuffix) {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Prune deletes all but the newest keep archives at r and returns how many
+// it deleted.
+func Prune(r Remote, keep int) (int, error) {
+	names, err := Archives(r)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for len(names)-n > keep {
+		if err := r.Delete(names[n]); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Restore fetches archive name from r ("" for the newest), decrypts it and
+// unpacks it into dir, which must not have a database in it already.  It
+// returns the name of the archive restored.
+func Restore(r Remote, passphrase, name, dir string) (string, error) {
+	if name == "" {
+		names, err := Archives(r)
+		if err != nil {
+			return "", err
+		}
+		if len(names) == 0 {
+			return "", fmt.Errorf("no backups at %s", r.Describe())
+		}
+		name = names[len(names)-1]
+	}
+	if _, err := os.Stat(filepath.Join(dir, "chirm.db")); err == nil {
+		return "", fmt.Errorf("%s already has a database; restore somewhere else and swap them over", dir)
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", err
+	}
+	rc, err := r.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("downloading %s: %w", name, err)
+	}
+	defer rc.Close()
+	dec, err := newDecrypter(rc, passphrase)
+	if err != nil {
+		return "", err
+	}
+	if err := extractArchive(dec, dir); err != nil {
+		return "", fmt.Errorf("unpacking %s: %w", name, err)
+	}
+	return name, nil
+}
@@ -0,0 +1,189 @@
+package backup
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// ─── Encryption ──────────────────────────────────────────────────────────────
+//
+// An encrypted archive starts with a header of magic, a random scrypt salt
+// and a random nonce prefix.  The rest is the archive in 64 KiB chunks, each
+// sealed with AES-256-GCM under a nonce made of the prefix, the chunk's
+// number and a flag marking the last chunk, so chunks can't be reordered,
+// dropped or cut off the end without decryption failing.
+
+const (
+	magic      = "CHIRMBK1"
+	saltSize   = 16
+	prefixSize = 7
+	chunkSize  = 64 << 10
+)
+
+var errDecrypt = errors.New("can't decrypt the backup: wrong passphrase, or the archive is damaged")
+
+func deriveKey(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce for chunk n.
+func chunkNonce(prefix []byte, n uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], n)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encrypter encrypts what's written to it.  Close must be called to write
+// the last chunk.
+type encrypter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	n      uint32
+	buf    []byte
+}
+
+func newEncrypter(w io.Writer, passphrase string) (*encrypter, error) {
+	header := make([]byte, len(magic)+saltSize+prefixSize)
+	copy(header, magic)
+	if _, err := rand.Read(header[len(magic):]); err != nil {
+		return nil, err
+	}
+	salt := header[len(magic) : len(magic)+saltSize]
+	aead, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encrypter{
+		w:      w,
+		aead:   aead,
+		prefix: header[len(magic)+saltSize:],
+		buf:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (e *encrypter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		// A full chunk waits for more data, since only then is it
+		// known not to be the last.
+		if len(e.buf) == chunkSize {
+			if err := e.flush(false); err != nil {
+				return 0, err
+			}
+		}
+		k := min(chunkSize-len(e.buf), len(p))
+		e.buf = append(e.buf, p[:k]...)
+		p = p[k:]
+	}
+	return written, nil
+}
+
+func (e *encrypter) flush(last bool) error {
+	if e.n == 1<<32-1 {
+		return errors.New("backup too large to encrypt")
+	}
+	sealed := e.aead.Seal(nil, chunkNonce(e.prefix, e.n, last), e.buf, nil)
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.n++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// Close writes the last chunk.  It doesn't close the underlying writer.
+func (e *encrypter) Close() error {
+	return e.flush(true)
+}
+
+// decrypter decrypts an archive written by an encrypter.
+type decrypter struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	n      uint32
+	plain  []byte
+	done   bool
+}
+
+func newDecrypter(r io.Reader, passphrase string) (*decrypter, error) {
+	header := make([]byte, len(magic)+saltSize+prefixSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if err != nil || string(header[:len(magic)]) != magic {
+		return nil, errors.New("not a Chirm backup")
+	}
+	aead, err := deriveKey(passphrase, header[len(magic):len(magic)+saltSize])
+	if err != nil {
+		return nil, err
+	}
+	return &decrypter{
+		r:      bufio.NewReaderSize(r, chunkSize+aead.Overhead()),
+		aead:   aead,
+		prefix: header[len(magic)+saltSize:],
+	}, nil
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk.
+func (d *decrypter) next() error {
+	sealed := make([]byte, chunkSize+d.aead.Overhead())
+	n, err := io.ReadFull(d.r, sealed)
+	last := false
+	switch err {
+	case nil:
+		_, err := d.r.Peek(1)
+		last = err == io.EOF
+	case io.ErrUnexpectedEOF:
+		last = true
+	case io.EOF:
+		return errDecrypt // cut off after a chunk boundary
+	default:
+		return err
+	}
+	plain, err := d.aead.Open(sealed[:0], chunkNonce(d.prefix, d.n, last), sealed[:n], nil)
+	if err != nil {
+		return errDecrypt
+	}
+	d.n++
+	d.plain, d.done = plain, last
+	return nil
+}
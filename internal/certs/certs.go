@@ -0,0 +1,173 @@
+// Package certs keeps the TLS certificates Chirm serves up to date without
+// a restart.  A Store holds one or more certificates, picks one for each
+// connection by the name the client asked for (SNI), and reloads them when
+// their files change or when told to, say on SIGHUP.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Source is somewhere a certificate comes from.
+type Source struct {
+	Name  string   // for logs, e.g. the certificate's path
+	Files []string // watched: a change to any of them reloads it
+	Load  func() (tls.Certificate, error)
+}
+
+// Files is the Source for a certificate chain and key in PEM files.
+func Files(certFile, keyFile string) Source {
+	return Source{
+		Name:  certFile,
+		Files: []string{certFile, keyFile},
+		Load: func() (tls.Certificate, error) {
+			return tls.LoadX509KeyPair(certFile, keyFile)
+		},
+	}
+}
+
+// Store serves the certificates from its sources.  The first source's is
+// the default, for clients that send no server name, such as browsers
+// opening the server by IP address, or one no other certificate covers.
+type Store struct {
+	sources []Source
+
+	mu     sync.RWMutex
+	certs  []*tls.Certificate   // by source; nil until one has loaded
+	mtimes map[string]time.Time // of the watched files, when last loaded
+}
+
+// NewStore loads every source.  Only the default has to load: another that
+// fails is logged and left out until a reload manages it.
+func NewStore(def Source, others ...Source) (*Store, error) {
+	s := &Store{
+		sources: append([]Source{def}, others...),
+		mtimes:  make(map[string]time.Time),
+	}
+	s.certs = make([]*tls.Certificate, len(s.sources))
+	for i := range s.sources {
+		if err := s.load(i); err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			slog.Warn("TLS: could not load certificate", "cert", s.sources[i].Name, "err", err)
+		}
+	}
+	return s, nil
+}
+
+// load (re)loads source i, keeping what it had if that fails.
+func (s *Store) load(i int) error {
+	src := s.sources[i]
+	mtimes := make(map[string]time.Time, len(src.Files))
+	for _, f := range src.Files {
+		if fi, err := os.Stat(f); err == nil {
+			mtimes[f] = fi.ModTime()
+		}
+	}
+	cert, err := src.Load()
+	if err != nil {
+		return err
+	}
+	if cert.Leaf == nil && len(cert.Certificate) > 0 {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+	if cert.Leaf == nil {
+		return errors.New("no certificate")
+	}
+	// A file the source wrote itself, as the built-in CA does on first
+	// run, counts as seen.
+	for _, f := range src.Files {
+		if _, seen := mtimes[f]; !seen {
+			if fi, err := os.Stat(f); err == nil {
+				mtimes[f] = fi.ModTime()
+			}
+		}
+	}
+	s.mu.Lock()
+	s.certs[i] = &cert
+	for f, t := range mtimes {
+		s.mtimes[f] = t
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// GetCertificate is for tls.Config: it returns the first certificate valid
+// for the name the client asked for, or else the default.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if hello.ServerName != "" {
+		for _, c := range s.certs[1:] {
+			if c != nil && c.Leaf.VerifyHostname(hello.ServerName) == nil {
+				return c, nil
+			}
+		}
+	}
+	if s.certs[0] != nil {
+		return s.certs[0], nil
+	}
+	return nil, errors.New("no certificate")
+}
+
+// Reload loads every certificate again.  One that fails keeps being
+// served as it was; the error says which did.
+func (s *Store) Reload() error {
+	var errs []error
+	for i, src := range s.sources {
+		if err := s.load(i); err != nil {
+			slog.Warn("TLS: reload failed; still serving the old certificate", "cert", src.Name, "err", err)
+			errs = append(errs, fmt.Errorf("%s: %w", src.Name, err))
+			continue
+		}
+		s.mu.RLock()
+		expires := s.certs[i].Leaf.NotAfter
+		s.mu.RUnlock()
+		slog.Info("TLS: certificate loaded", "cert", src.Name, "expires", expires.Format("2006-01-02"))
+	}
+	return errors.Join(errs...)
+}
+
+// ReloadChanged reloads the certificates whose files have changed since
+// they were loaded.  Renewal tools replace the files in place, which is all
+// it looks for; call it every so often.
+func (s *Store) ReloadChanged() error {
+	for i, src := range s.sources {
+		if !s.changed(src) {
+			continue
+		}
+		if err := s.load(i); err != nil {
+			// Probably caught halfway through being replaced; the
+			// next look will tell.
+			slog.Debug("TLS: changed certificate did not load", "cert", src.Name, "err", err)
+			continue
+		}
+		slog.Info("TLS: certificate changed on disk; reloaded", "cert", src.Name)
+	}
+	return nil
+}
+
+func (s *Store) changed(src Source) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, f := range src.Files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if !fi.ModTime().Equal(s.mtimes[f]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,336 @@
+// Package config reads Chirm's optional config file, chirm.yaml or
+// chirm.toml.  Each setting in it stands for one of the environment
+// variables Chirm is configured with, and is only used where that variable
+// isn't set, so the environment (and .env) always wins.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFiles are looked for in the working directory when no config file
+// is named.
+var DefaultFiles = []string{"chirm.yaml", "chirm.yml", "chirm.toml"}
+
+// option is a setting a config file may give: its dotted key, the
+// environment variable it sets and how its value is checked and written.
+type option struct {
+	key   string
+	env   string
+	value func(v interface{}) (string, error)
+}
+
+var options = []option{
+	{"jwt_secret", "JWT_SECRET", text},
+	{"port", "PORT", port},
+	{"https_port", "HTTPS_PORT", port},
+	{"listen", "LISTEN", list},
+	{"https_listen", "HTTPS_LISTEN", list},
+	{"unix_socket_mode", "UNIX_SOCKET_MODE", text},
+	{"irc_listen", "IRC_LISTEN", text},
+	{"irc_tls_listen", "IRC_TLS_LISTEN", text},
+	{"data_dir", "DATA_DIR", text},
+
+	{"database.max_open_conns", "DB_MAX_OPEN_CONNS", positive},
+	{"database.max_idle_conns", "DB_MAX_IDLE_CONNS", positive},
+	{"database.conn_max_idle_time", "DB_CONN_MAX_IDLE_TIME", duration},
+	{"database.conn_max_lifetime", "DB_CONN_MAX_LIFETIME", duration},
+	{"database.busy_timeout", "DB_BUSY_TIMEOUT", duration},
+	{"max_body_kb", "MAX_BODY_KB", positive},
+	{"max_header_kb", "MAX_HEADER_KB", positive},
+	{"allowed_origin", "ALLOWED_ORIGIN", text},
+	{"public_url", "PUBLIC_URL", text},
+	{"federation", "FEDERATION", boolean},
+	{"trusted_proxies", "TRUSTED_PROXIES", list},
+	{"api_docs", "API_DOCS", boolean},
+	{"debug_endpoints", "DEBUG_ENDPOINTS", oneOf("admin", "local", "off")},
+
+	{"cors.origins", "CORS_ORIGINS", list},
+	{"cors.credentials", "CORS_CREDENTIALS", boolean},
+	{"cors.headers", "CORS_HEADERS", list},
+	{"cors.max_age", "CORS_MAX_AGE", positive},
+
+	{"tls.cert", "CHIRM_TLS_CERT", text},
+	{"tls.key", "CHIRM_TLS_KEY", text},
+	{"tls.sni_certs", "TLS_SNI_CERTS", list},
+	{"tls.redirect", "HTTPS_REDIRECT", boolean},
+	{"tls.hsts_max_age", "HSTS_MAX_AGE", positive},
+	{"tls.acme_webroot", "ACME_WEBROOT", text},
+
+	{"tailscale.auth_key", "TS_AUTHKEY", text},
+	{"tailscale.hostname", "TS_HOSTNAME", text},
+	{"tailscale.control_url", "TS_CONTROL_URL", text},
+
+	{"discovery.mdns", "MDNS", boolean},
+	{"discovery.hostname", "MDNS_HOSTNAME", text},
+
+	{"log.format", "LOG_FORMAT", oneOf("text", "json")},
+	{"log.level", "LOG_LEVEL", oneOf("debug", "info", "warn", "error")},
+	{"log.file", "LOG_FILE", text},
+	{"log.file_max_mb", "LOG_FILE_MAX_MB", positive},
+	{"log.file_max_age", "LOG_FILE_MAX_AGE", duration},
+	{"log.file_keep", "LOG_FILE_KEEP", positive},
+	{"log.file_compress", "LOG_FILE_COMPRESS", boolean},
+
+	{"rate_limits.auth_per_minute", "AUTH_RATE_PER_MIN", positive},
+	{"rate_limits.auth_burst", "AUTH_RATE_BURST", positive},
+	{"rate_limits.webhook_per_minute", "WEBHOOK_RATE_PER_MIN", positive},
+	{"rate_limits.webhook_burst", "WEBHOOK_RATE_BURST", positive},
+	{"rate_limits.message_per_minute", "MESSAGE_RATE_PER_MIN", positive},
+	{"rate_limits.message_burst", "MESSAGE_RATE_BURST", positive},
+	{"rate_limits.upload_per_minute", "UPLOAD_RATE_PER_MIN", positive},
+	{"rate_limits.upload_burst", "UPLOAD_RATE_BURST", positive},
+	{"rate_limits.preview_per_minute", "PREVIEW_RATE_PER_MIN", positive},
+	{"rate_limits.preview_burst", "PREVIEW_RATE_BURST", positive},
+	{"rate_limits.image_proxy_per_minute", "IMAGE_PROXY_RATE_PER_MIN", positive},
+	{"rate_limits.image_proxy_burst", "IMAGE_PROXY_RATE_BURST", positive},
+	{"rate_limits.server_preview_per_minute", "SERVER_PREVIEW_RATE_PER_MIN", positive},
+	{"rate_limits.server_preview_burst", "SERVER_PREVIEW_RATE_BURST", positive},
+	{"rate_limits.gif_per_minute", "GIF_RATE_PER_MIN", positive},
+	{"rate_limits.gif_burst", "GIF_RATE_BURST", positive},
+	{"rate_limits.translate_per_minute", "TRANSLATE_RATE_PER_MIN", positive},
+	{"rate_limits.translate_burst", "TRANSLATE_RATE_BURST", positive},
+	{"rate_limits.oauth_per_minute", "OAUTH_RATE_PER_MIN", positive},
+	{"rate_limits.oauth_burst", "OAUTH_RATE_BURST", positive},
+	{"rate_limits.federation_per_minute", "FEDERATION_RATE_PER_MIN", positive},
+	{"rate_limits.federation_burst", "FEDERATION_RATE_BURST", positive},
+	{"rate_limits.clients", "RATE_LIMIT_CLIENTS", positive},
+
+	{"uploads.max_size_mb", "MAX_UPLOAD_MB", positive},
+	{"uploads.transcode", "TRANSCODE", boolean},
+	{"uploads.transcode_workers", "TRANSCODE_WORKERS", positive},
+	{"uploads.clamd_address", "CLAMD_ADDRESS", text},
+	{"uploads.scan_command", "AV_SCAN_COMMAND", text},
+	{"uploads.s3.bucket", "S3_BUCKET", text},
+	{"uploads.s3.endpoint", "S3_ENDPOINT", text},
+	{"uploads.s3.region", "S3_REGION", text},
+	{"uploads.s3.access_key", "S3_ACCESS_KEY", text},
+	{"uploads.s3.secret_key", "S3_SECRET_KEY", text},
+	{"uploads.s3.prefix", "S3_PREFIX", text},
+	{"uploads.s3.virtual_hosted", "S3_VIRTUAL_HOSTED", boolean},
+	{"uploads.s3.presign", "S3_PRESIGN", boolean},
+
+	{"push.default_language", "DEFAULT_LANGUAGE", text},
+	{"push.fcm_credentials", "FCM_CREDENTIALS", text},
+	{"push.apns.key_file", "APNS_KEY_FILE", text},
+	{"push.apns.key_id", "APNS_KEY_ID", text},
+	{"push.apns.team_id", "APNS_TEAM_ID", text},
+	{"push.apns.topic", "APNS_TOPIC", text},
+	{"push.apns.sandbox", "APNS_SANDBOX", boolean},
+	{"email.smtp_host", "SMTP_HOST", text},
+	{"email.smtp_port", "SMTP_PORT", port},
+	{"email.smtp_user", "SMTP_USER", text},
+	{"email.smtp_password", "SMTP_PASSWORD", text},
+	{"email.from", "SMTP_FROM", text},
+	{"email.delay", "EMAIL_NOTIFY_DELAY", duration},
+	{"backup.target", "BACKUP_TARGET", text},
+	{"backup.passphrase", "BACKUP_PASSPHRASE", text},
+	{"backup.interval", "BACKUP_INTERVAL", duration},
+	{"backup.keep", "BACKUP_KEEP", positive},
+	{"backup.rclone_path", "RCLONE_PATH", text},
+	{"backup.s3.endpoint", "BACKUP_S3_ENDPOINT", text},
+	{"backup.s3.region", "BACKUP_S3_REGION", text},
+	{"backup.s3.access_key", "BACKUP_S3_ACCESS_KEY", text},
+	{"backup.s3.secret_key", "BACKUP_S3_SECRET_KEY", text},
+	{"backup.s3.virtual_hosted", "BACKUP_S3_VIRTUAL_HOSTED", boolean},
+}
+
+// File is a config file that has been read and checked.
+type File struct {
+	Path string
+	env  map[string]string // environment variable → value
+}
+
+// Find returns the config file to use: path if given, or else the first of
+// DefaultFiles that exists, or "" for none.
+func Find(path string) string {
+	if path != "" {
+		return path
+	}
+	for _, name := range DefaultFiles {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// Load reads and checks the config file at path, which is YAML unless its
+// name ends in .toml.  The error lists every problem found.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tree := map[string]interface{}{}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		tree, err = parseTOML(string(data))
+	} else {
+		err = yaml.Unmarshal(data, &tree)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	byKey := make(map[string]option, len(options))
+	for _, o := range options {
+		byKey[o.key] = o
+	}
+	f := &File{Path: path, env: map[string]string{}}
+	var errs []error
+	flat := map[string]interface{}{}
+	flatten("", tree, flat)
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		o, known := byKey[k]
+		if !known {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", path, k))
+			continue
+		}
+		v, err := o.value(flat[k])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", path, k, err))
+			continue
+		}
+		f.env[o.env] = v
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return f, nil
+}
+
+// Apply sets the environment variables the file gives that aren't set
+// already, and returns how many it set.
+func (f *File) Apply() int {
+	n := 0
+	for k, v := range f.env {
+		if _, set := os.LookupEnv(k); !set {
+			os.Setenv(k, v)
+			n++
+		}
+	}
+	return n
+}
+
+// flatten adds tree's values to out under dotted keys.
+func flatten(prefix string, tree map[string]interface{}, out map[string]interface{}) {
+	for k, v := range tree {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		if v == nil {
+			continue // an empty section, or a setting left blank
+		}
+		if sub, ok := v.(map[string]interface{}); ok {
+			flatten(k, sub, out)
+			continue
+		}
+		out[k] = v
+	}
+}
+
+// ─── Value checks ────────────────────────────────────────────────────────────
+
+func text(v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", errors.New("must be a string")
+	}
+	return s, nil
+}
+
+func integer(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case uint64:
+		if n <= 1<<62 {
+			return int64(n), nil
+		}
+	}
+	return 0, errors.New("must be a whole number")
+}
+
+func port(v interface{}) (string, error) {
+	n, err := integer(v)
+	if err != nil || n < 1 || n > 65535 {
+		return "", errors.New("must be a port number (1-65535)")
+	}
+	return strconv.FormatInt(n, 10), nil
+}
+
+func positive(v interface{}) (string, error) {
+	n, err := integer(v)
+	if err != nil || n < 1 {
+		return "", errors.New("must be a whole number above 0")
+	}
+	return strconv.FormatInt(n, 10), nil
+}
+
+func boolean(v interface{}) (string, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return "", errors.New("must be true or false")
+	}
+	if b {
+		return "1", nil
+	}
+	return "0", nil
+}
+
+func duration(v interface{}) (string, error) {
+	s, ok := v.(string)
+	if d, err := time.ParseDuration(s); !ok || err != nil || d < 0 {
+		return "", errors.New(`must be a duration such as "15m"`)
+	}
+	return s, nil
+}
+
+// list takes a list of strings, or a single comma-separated one.
+func list(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	items, ok := v.([]interface{})
+	if !ok {
+		return "", errors.New("must be a list of strings")
+	}
+	out := make([]string, len(items))
+	for i, item := range items {
+		s, ok := item.(string)
+		if !ok || strings.Contains(s, ",") {
+			return "", errors.New("must be a list of strings")
+		}
+		out[i] = s
+	}
+	return strings.Join(out, ","), nil
+}
+
+func oneOf(choices ...string) func(v interface{}) (string, error) {
+	return func(v interface{}) (string, error) {
+		if s, ok := v.(string); ok {
+			for _, c := range choices {
+				if strings.EqualFold(s, c) {
+					return c, nil
+				}
+			}
+		}
+		return "", fmt.Errorf("must be one of %s", strings.Join(choices, ", "))
+	}
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML reads the part of TOML a config file needs: [tables] (dotted
+// names allowed), and key = value lines whose values are strings, whole
+// numbers or booleans, with # comments.  Anything else is an error naming
+// the line.
+func parseTOML(src string) (map[string]interface{}, error) {
+	root := map[string]interface{}{}
+	table := root
+	for i, line := range strings.Split(src, "\n") {
+		n := i + 1
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: bad table header", n)
+			}
+			path, err := tomlKey(line[1 : len(line)-1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			if table, err = subTable(root, path); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		path, err := tomlKey(line[:eq])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		v, err := tomlValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		t, err := subTable(table, path[:len(path)-1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		k := path[len(path)-1]
+		if _, dup := t[k]; dup {
+			return nil, fmt.Errorf("line %d: %s is set twice", n, k)
+		}
+		t[k] = v
+	}
+	return root, nil
+}
+
+// stripComment cuts a # comment off line, leaving #s inside strings.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// tomlKey splits a dotted key into its parts; only bare keys are allowed.
+func tomlKey(s string) ([]string, error) {
+	parts := strings.Split(strings.TrimSpace(s), ".")
+	for i, p := range parts {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			return nil, fmt.Errorf("bad key %q", s)
+		}
+		for _, c := range p {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+				return nil, fmt.Errorf("bad key %q", s)
+			}
+		}
+		parts[i] = p
+	}
+	return parts, nil
+}
+
+// subTable returns the table at path under t, making any that are missing.
+func subTable(t map[string]interface{}, path []string) (map[string]interface{}, error) {
+	for _, k := range path {
+		switch next := t[k].(type) {
+		case nil:
+			sub := map[string]interface{}{}
+			t[k] = sub
+			t = sub
+		case map[string]interface{}:
+			t = next
+		default:
+			return nil, fmt.Errorf("%s is a value, not a table", k)
+		}
+	}
+	return t, nil
+}
+
+func tomlValue(s string) (interface{}, error) {
+	switch {
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	case strings.HasPrefix(s, `"`):
+		if len(s) < 2 || !strings.HasSuffix(s, `"`) || strings.HasPrefix(s, `"""`) {
+			return nil, fmt.Errorf("bad string %s", s)
+		}
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad string %s", s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") || strings.HasPrefix(s, "'''") || strings.Contains(s[1:len(s)-1], "'") {
+			return nil, fmt.Errorf("bad string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	}
+	n, err := strconv.ParseInt(strings.ReplaceAll(s, "_", ""), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported value %s (use a string, whole number, true or false)", s)
+	}
+	return n, nil
+}
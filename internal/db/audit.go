@@ -0,0 +1,53 @@
+package db
+
+import "time"
+
+// ─── Audit log ────────────────────────────────────────────────────────────────
+//
+// audit_log records things done to the server that admins may need to
+// account for later, by people or by Chirm itself (ActorID "").
+
+// AuditEntry is one audit log record.  Action is a dotted name such as
+// "attachment.evict"; Details is a human-readable summary.
+type AuditEntry struct {
+	ID        string    `json:"id"`
+	ActorID   string    `json:"actor_id,omitempty"`
+	ActorName string    `json:"actor_name,omitempty"`
+	Action    string    `json:"action"`
+	TargetID  string    `json:"target_id,omitempty"`
+	Details   string    `json:"details,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AddAuditEntry records e.
+func (d *DB) AddAuditEntry(e AuditEntry) error {
+	_, err := d.Exec(`INSERT INTO audit_log (id, actor_id, action, target_id, details) VALUES (?, ?, ?, ?, ?)`,
+		NewID(), e.ActorID, e.Action, e.TargetID, e.Details)
+	return err
+}
+
+// ListAuditLog returns up to limit entries, newest first, older than the
+// entry with ID before if it's given.
+func (d *DB) ListAuditLog(before string, limit int) ([]AuditEntry, error) {
+	query := `SELECT a.id, a.actor_id, COALESCE(u.username, ''), a.action, a.target_id, a.details, a.created_at
+		FROM audit_log a LEFT JOIN users u ON u.id = a.actor_id`
+	args := []interface{}{}
+	if before != "" {
+		query += ` WHERE a.created_at < (SELECT created_at FROM audit_log WHERE id = ?)`
+		args = append(args, before)
+	}
+	query += ` ORDER BY a.created_at DESC LIMIT ?`
+	rows, err := d.Query(query, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.ActorName, &e.Action, &e.TargetID, &e.Details, &e.CreatedAt); err == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
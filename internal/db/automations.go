@@ -0,0 +1,159 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// ─── Automation rules ────────────────────────────────────────────────────────
+//
+// Guild admins set up rules of the form "when this happens, do that": when
+// a message matches a pattern, someone joins or someone reacts, reply, give
+// a role, delete the message or tell another channel.  A rule has one
+// trigger and any number of actions, kept as JSON.  Its posts look like a
+// webhook's: no user, but the rule's ID and name.
+
+// What sets a rule off.
+const (
+	TriggerMessage  = "message"  // a message whose text matches the pattern, a regular expression
+	TriggerJoin     = "join"     // someone joining the guild
+	TriggerReaction = "reaction" // a reaction with the pattern's emoji, or any if there's no pattern
+)
+
+// What a rule can do.
+const (
+	ActionReply         = "reply"
+	ActionAddRole       = "add_role"
+	ActionDeleteMessage = "delete_message"
+	ActionNotifyChannel = "notify_channel"
+)
+
+// AutomationAction is one thing a rule does.
+type AutomationAction struct {
+	Type      string `json:"type"`
+	Content   string `json:"content,omitempty"`    // for reply and notify_channel
+	RoleID    string `json:"role_id,omitempty"`    // for add_role
+	ChannelID string `json:"channel_id,omitempty"` // for notify_channel, and reply on joins
+}
+
+// AutomationRule is a guild's rule.
+type AutomationRule struct {
+	ID        string             `json:"id"`
+	GuildID   string             `json:"guild_id"`
+	Name      string             `json:"name"`
+	Enabled   bool               `json:"enabled"`
+	Trigger   string             `json:"trigger"`
+	Pattern   string             `json:"pattern"`
+	ChannelID string             `json:"channel_id,omitempty"` // only there, if set
+	Actions   []AutomationAction `json:"actions"`
+	Runs      int                `json:"runs"`
+	LastRun   *time.Time         `json:"last_run,omitempty"`
+	CreatedBy string             `json:"created_by"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+const automationRuleColumns = `id, guild_id, name, enabled, trigger_type, pattern, channel_id, actions, runs, last_run, created_by, created_at, updated_at`
+
+func scanAutomationRule(row interface{ Scan(...interface{}) error }) (AutomationRule, error) {
+	var a AutomationRule
+	var actions string
+	var lastRun sql.NullTime
+	err := row.Scan(&a.ID, &a.GuildID, &a.Name, &a.Enabled, &a.Trigger, &a.Pattern, &a.ChannelID, &actions, &a.Runs, &lastRun,
+		&a.CreatedBy, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return a, err
+	}
+	if lastRun.Valid {
+		a.LastRun = &lastRun.Time
+	}
+	json.Unmarshal([]byte(actions), &a.Actions)
+	if a.Actions == nil {
+		a.Actions = []AutomationAction{}
+	}
+	return a, nil
+}
+
+func (d *DB) queryAutomationRules(where string, args ...interface{}) ([]AutomationRule, error) {
+	rows, err := d.Query(`SELECT `+automationRuleColumns+` FROM automation_rules `+where+` ORDER BY created_at ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []AutomationRule{}
+	for rows.Next() {
+		if a, err := scanAutomationRule(rows); err == nil {
+			rules = append(rules, a)
+		}
+	}
+	return rules, rows.Err()
+}
+
+// CreateAutomationRule adds rule a, returning it as stored.
+func (d *DB) CreateAutomationRule(a AutomationRule) (*AutomationRule, error) {
+	id := NewID()
+	actions, _ := json.Marshal(a.Actions)
+	_, err := d.Exec(`INSERT INTO automation_rules (id, guild_id, name, enabled, trigger_type, pattern, channel_id, actions, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, a.GuildID, a.Name, a.Enabled, a.Trigger, a.Pattern, a.ChannelID, string(actions), a.CreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetAutomationRule(id)
+}
+
+func (d *DB) GetAutomationRule(id string) (*AutomationRule, error) {
+	a, err := scanAutomationRule(d.QueryRow(`SELECT `+automationRuleColumns+` FROM automation_rules WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// UpdateAutomationRule saves a's settings.  Its guild, creator and how
+// often it has run stay as they were.
+func (d *DB) UpdateAutomationRule(a AutomationRule) (*AutomationRule, error) {
+	actions, _ := json.Marshal(a.Actions)
+	_, err := d.Exec(`UPDATE automation_rules SET name = ?, enabled = ?, trigger_type = ?, pattern = ?, channel_id = ?, actions = ?,
+		updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		a.Name, a.Enabled, a.Trigger, a.Pattern, a.ChannelID, string(actions), a.ID)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetAutomationRule(a.ID)
+}
+
+func (d *DB) DeleteAutomationRule(id string) error {
+	_, err := d.Exec(`DELETE FROM automation_rules WHERE id = ?`, id)
+	return err
+}
+
+// ListAutomationRules returns guildID's rules, oldest first.
+func (d *DB) ListAutomationRules(guildID string) ([]AutomationRule, error) {
+	return d.queryAutomationRules(`WHERE guild_id = ?`, guildID)
+}
+
+// ActiveAutomationRules returns guildID's enabled rules set off by
+// trigger, oldest first, the order they run in.
+func (d *DB) ActiveAutomationRules(guildID, trigger string) ([]AutomationRule, error) {
+	return d.queryAutomationRules(`WHERE guild_id = ? AND trigger_type = ? AND enabled = 1`, guildID, trigger)
+}
+
+// AutomationRuleRan counts a run of rule id, at at.
+func (d *DB) AutomationRuleRan(id string, at time.Time) error {
+	_, err := d.Exec(`UPDATE automation_rules SET runs = runs + 1, last_run = ? WHERE id = ?`, at.UTC(), id)
+	return err
+}
+
+// CreateAutomationMessage stores a post of rule a in channelID, under its
+// name, replying to replyToID if it's set.
+func (d *DB) CreateAutomationMessage(a *AutomationRule, channelID, content string, replyToID *string) (*Message, error) {
+	id := NewID()
+	_, err := d.Exec(`INSERT INTO messages (id, channel_id, content, webhook_id, author_name, reply_to_id) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, channelID, content, a.ID, a.Name, replyToID)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetMessageByID(id)
+}
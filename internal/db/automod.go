@@ -0,0 +1,251 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// ─── Auto-moderation ─────────────────────────────────────────────────────────
+//
+// Each guild can turn on filters for what its members post: banned words,
+// links, invites to other servers, the same message over and over, and
+// piles of @mentions.  Each filter says what to do about a message it
+// catches: delete it (it's never posted), time its author out, so they
+// can't post for a while, and flag it for moderators to look at.  The
+// settings are kept as JSON, one row per guild.
+
+// AutomodAction is what a filter does about a message it catches.
+type AutomodAction struct {
+	Delete  bool `json:"delete"`
+	Timeout int  `json:"timeout"` // minutes the author can't post for; 0 for none
+	Flag    bool `json:"flag"`
+}
+
+// AutomodFilter is a filter that's on or off, and what it does.
+type AutomodFilter struct {
+	Enabled bool `json:"enabled"`
+	AutomodAction
+}
+
+// AutomodConfig is a guild's auto-moderation settings.
+type AutomodConfig struct {
+	BannedWords struct {
+		AutomodFilter
+		// Words and phrases, matched whole and ignoring case; * stands
+		// for any letters, so "spam*" catches "spammer".
+		Words []string `json:"words"`
+	} `json:"banned_words"`
+	Links struct {
+		AutomodFilter
+		Allow []string `json:"allow"` // domains links may go to, with their subdomains
+	} `json:"links"`
+	Invites AutomodFilter `json:"invites"` // to other servers; this one's are fine
+	Repeats struct {
+		AutomodFilter
+		Count   int `json:"count"`   // the same message this many times…
+		Seconds int `json:"seconds"` // …within this many seconds
+	} `json:"repeats"`
+	Mentions struct {
+		AutomodFilter
+		Max int `json:"max"` // people one message may mention; @everyone counts as one
+	} `json:"mentions"`
+	// Members with these roles, and anyone with Manage Messages, aren't
+	// filtered; nor are messages in these channels.
+	ExemptRoles    []string `json:"exempt_roles"`
+	ExemptChannels []string `json:"exempt_channels"`
+}
+
+// DefaultAutomodConfig is a guild's settings before anyone has changed
+// them: every filter off.
+func DefaultAutomodConfig() *AutomodConfig {
+	c := &AutomodConfig{}
+	c.BannedWords.Words = []string{}
+	c.Links.Allow = []string{}
+	c.Repeats.Count, c.Repeats.Seconds = 3, 60
+	c.Mentions.Max = 5
+	c.ExemptRoles, c.ExemptChannels = []string{}, []string{}
+	for _, f := range []*AutomodFilter{&c.BannedWords.AutomodFilter, &c.Links.AutomodFilter, &c.Invites, &c.Repeats.AutomodFilter, &c.Mentions.AutomodFilter} {
+		f.Delete = true
+	}
+	return c
+}
+
+// GetAutomodConfig returns guildID's settings, the defaults if they've
+// never been changed.
+func (d *DB) GetAutomodConfig(guildID string) (*AutomodConfig, error) {
+	c := DefaultAutomodConfig()
+	var config string
+	err := d.QueryRow(`SELECT config FROM automod_settings WHERE guild_id = ?`, guildID).Scan(&config)
+	if err == sql.ErrNoRows {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, json.Unmarshal([]byte(config), c)
+}
+
+// SetAutomodConfig saves guildID's settings.
+func (d *DB) SetAutomodConfig(guildID string, c *AutomodConfig) error {
+	config, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = d.Exec(`INSERT INTO automod_settings (guild_id, config) VALUES (?, ?)
+		ON CONFLICT (guild_id) DO UPDATE SET config = excluded.config, updated_at = CURRENT_TIMESTAMP`,
+		guildID, string(config))
+	return err
+}
+
+// ─── Timeouts ────────────────────────────────────────────────────────────────
+
+// AutomodTimeout is a member who can't post in a guild for now.
+type AutomodTimeout struct {
+	UserID   string    `json:"user_id"`
+	Username string    `json:"username"`
+	Until    time.Time `json:"until"`
+	Reason   string    `json:"reason"`
+}
+
+// TimeOut stops userID posting in guildID until until, unless they're
+// timed out for longer already.
+func (d *DB) TimeOut(guildID, userID string, until time.Time, reason string) error {
+	_, err := d.Exec(`INSERT INTO automod_timeouts (guild_id, user_id, until, reason) VALUES (?, ?, ?, ?)
+		ON CONFLICT (guild_id, user_id) DO UPDATE SET until = excluded.until, reason = excluded.reason
+		WHERE excluded.until > automod_timeouts.until`,
+		guildID, userID, until.UTC(), reason)
+	return err
+}
+
+// TimedOutUntil returns when userID's timeout in guildID ends, if they're
+// timed out as of now.
+func (d *DB) TimedOutUntil(guildID, userID string, now time.Time) (time.Time, bool) {
+	var until time.Time
+	if err := d.QueryRow(`SELECT until FROM automod_timeouts WHERE guild_id = ? AND user_id = ? AND until > ?`,
+		guildID, userID, now.UTC()).Scan(&until); err != nil {
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+// ListTimeouts returns guildID's timeouts still running at now, the
+// soonest to end first.
+func (d *DB) ListTimeouts(guildID string, now time.Time) ([]AutomodTimeout, error) {
+	rows, err := d.Query(`SELECT t.user_id, COALESCE(u.username, ''), t.until, t.reason FROM automod_timeouts t
+		LEFT JOIN users u ON u.id = t.user_id WHERE t.guild_id = ? AND t.until > ? ORDER BY t.until ASC`, guildID, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	timeouts := []AutomodTimeout{}
+	for rows.Next() {
+		var t AutomodTimeout
+		if rows.Scan(&t.UserID, &t.Username, &t.Until, &t.Reason) == nil {
+			timeouts = append(timeouts, t)
+		}
+	}
+	return timeouts, rows.Err()
+}
+
+// EndTimeout lets userID post in guildID again, reporting whether they
+// were timed out.
+func (d *DB) EndTimeout(guildID, userID string) (bool, error) {
+	res, err := d.Exec(`DELETE FROM automod_timeouts WHERE guild_id = ? AND user_id = ?`, guildID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ─── Flags ───────────────────────────────────────────────────────────────────
+
+// What moderators decided about a flag.
+const (
+	FlagDismissed = "dismissed" // the message was fine
+	FlagDeleted   = "deleted"   // the message was deleted
+)
+
+// AutomodFlag is a message a filter flagged for moderators.  MessageID is
+// empty when the filter deleted it too, so Content is all that's left.
+type AutomodFlag struct {
+	ID         string     `json:"id"`
+	GuildID    string     `json:"guild_id"`
+	ChannelID  string     `json:"channel_id"`
+	MessageID  string     `json:"message_id,omitempty"`
+	UserID     string     `json:"user_id"`
+	Username   string     `json:"username"`
+	Filter     string     `json:"filter"` // banned_words, links, invites, repeats or mentions
+	Reason     string     `json:"reason"`
+	Content    string     `json:"content"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReviewedBy string     `json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+	Outcome    string     `json:"outcome,omitempty"`
+}
+
+const automodFlagColumns = `f.id, f.guild_id, f.channel_id, f.message_id, f.user_id, COALESCE(u.username, ''), f.filter, f.reason, f.content,
+	f.created_at, f.reviewed_by, f.reviewed_at, f.outcome`
+
+func scanAutomodFlag(row interface{ Scan(...interface{}) error }) (AutomodFlag, error) {
+	var f AutomodFlag
+	var reviewed sql.NullTime
+	err := row.Scan(&f.ID, &f.GuildID, &f.ChannelID, &f.MessageID, &f.UserID, &f.Username, &f.Filter, &f.Reason, &f.Content,
+		&f.CreatedAt, &f.ReviewedBy, &reviewed, &f.Outcome)
+	if reviewed.Valid {
+		f.ReviewedAt = &reviewed.Time
+	}
+	return f, err
+}
+
+// CreateAutomodFlag records f for moderators to look at.
+func (d *DB) CreateAutomodFlag(f AutomodFlag) (*AutomodFlag, error) {
+	id := NewID()
+	_, err := d.Exec(`INSERT INTO automod_flags (id, guild_id, channel_id, message_id, user_id, filter, reason, content)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, f.GuildID, f.ChannelID, f.MessageID, f.UserID, f.Filter, f.Reason, f.Content)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetAutomodFlag(id)
+}
+
+func (d *DB) GetAutomodFlag(id string) (*AutomodFlag, error) {
+	f, err := scanAutomodFlag(d.QueryRow(`SELECT `+automodFlagColumns+` FROM automod_flags f
+		LEFT JOIN users u ON u.id = f.user_id WHERE f.id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// ListAutomodFlags returns up to limit of guildID's flags, newest first:
+// only those waiting for review, unless reviewed is set.
+func (d *DB) ListAutomodFlags(guildID string, reviewed bool, limit int) ([]AutomodFlag, error) {
+	where := `WHERE f.guild_id = ?`
+	if !reviewed {
+		where += ` AND f.reviewed_at IS NULL`
+	}
+	rows, err := d.Query(`SELECT `+automodFlagColumns+` FROM automod_flags f LEFT JOIN users u ON u.id = f.user_id
+		`+where+` ORDER BY f.created_at DESC LIMIT ?`, guildID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	flags := []AutomodFlag{}
+	for rows.Next() {
+		if f, err := scanAutomodFlag(rows); err == nil {
+			flags = append(flags, f)
+		}
+	}
+	return flags, rows.Err()
+}
+
+// ReviewAutomodFlag records that reviewerID looked at flag id and decided
+// outcome.
+func (d *DB) ReviewAutomodFlag(id, reviewerID, outcome string) error {
+	_, err := d.Exec(`UPDATE automod_flags SET reviewed_by = ?, reviewed_at = ?, outcome = ? WHERE id = ?`,
+		reviewerID, time.Now().UTC(), outcome, id)
+	return err
+}
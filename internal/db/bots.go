@@ -0,0 +1,147 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ─── Bot accounts ────────────────────────────────────────────────────────────
+//
+// A bot is a user that signs in with a token rather than a password, made
+// by an admin for a moderation or utility bot to run as.  It has roles and
+// permissions like anyone else.  Its token is "<user ID>.<secret>"; only a
+// hash of the secret is stored, and the token is shown once, when the bot
+// is made or its token is reset.
+
+// botEmailDomain fills in bots' emails, which users must have but bots
+// don't; it's reserved, so can't be anyone's real address.
+const botEmailDomain = "@bots.invalid"
+
+// userEmail is u's email as it's shown: none, for a bot.
+func userEmail(u *User) string {
+	if u.Bot {
+		return ""
+	}
+	return u.Email
+}
+
+// Bot is a bot account, for admins.
+type Bot struct {
+	User      *User     `json:"user"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func hashBotSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newBotToken(userID string) (token, hash string) {
+	secret := NewID() + NewID() + NewID() + NewID()
+	return userID + "." + secret, hashBotSecret(secret)
+}
+
+// CreateBot adds a bot named username, returning its user and token.
+func (d *DB) CreateBot(username, createdBy string) (*User, string, error) {
+	id := NewID()
+	token, hash := newBotToken(id)
+	tx, err := d.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+	// No password hash matches no password, so a bot can't log in.
+	if _, err := tx.Exec(`INSERT INTO users (id, username, email, password_hash, is_bot) VALUES (?, ?, ?, '', 1)`,
+		id, username, id+botEmailDomain); err != nil {
+		return nil, "", err
+	}
+	if _, err := tx.Exec(`INSERT INTO bots (user_id, token_hash, created_by) VALUES (?, ?, ?)`, id, hash, createdBy); err != nil {
+		return nil, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, "", err
+	}
+	u, err := d.GetUserByID(id)
+	return u, token, err
+}
+
+// BotByToken returns the bot whose token is token.
+func (d *DB) BotByToken(token string) (*User, error) {
+	id, secret, found := strings.Cut(token, ".")
+	if !found || id == "" || secret == "" {
+		return nil, errors.New("malformed bot token")
+	}
+	var n int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM bots b JOIN users u ON u.id = b.user_id
+		WHERE b.user_id = ? AND b.token_hash = ? AND u.is_bot = 1`, id, hashBotSecret(secret)).Scan(&n); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errors.New("unknown bot token")
+	}
+	return d.GetUserByID(id)
+}
+
+// ListBots returns every bot, oldest first.
+func (d *DB) ListBots() ([]Bot, error) {
+	rows, err := d.Query(`SELECT b.user_id, b.created_by, b.created_at FROM bots b
+		JOIN users u ON u.id = b.user_id ORDER BY b.created_at ASC`)
+	if err != nil {
+		return nil, err
+	}
+	var bots []Bot
+	var ids []string
+	for rows.Next() {
+		var b Bot
+		var id string
+		if rows.Scan(&id, &b.CreatedBy, &b.CreatedAt) == nil {
+			bots = append(bots, b)
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	out := []Bot{}
+	for i, id := range ids {
+		u, err := d.GetUserByID(id)
+		if err != nil {
+			continue
+		}
+		bots[i].User = u
+		out = append(out, bots[i])
+	}
+	return out, nil
+}
+
+// ResetBotToken gives bot id a new token in place of its old one, which
+// stops working, and returns it.
+func (d *DB) ResetBotToken(id string) (string, error) {
+	token, hash := newBotToken(id)
+	res, err := d.Exec(`UPDATE bots SET token_hash = ? WHERE user_id = ?`, hash, id)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", errors.New("no such bot")
+	}
+	return token, nil
+}
+
+// DeleteBot removes bot id and its account.
+func (d *DB) DeleteBot(id string) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM bots WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ? AND is_bot = 1`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ─── Direct calls ─────────────────────────────────────────────────────────────
+//
+// A direct call is a 1:1 voice/video call between two members, outside any
+// voice channel.  Each call is one row that moves from "ringing" to
+// "answered" and then "ended", or ends early as "missed", "declined" or
+// "cancelled".
+
+const (
+	CallRinging   = "ringing"
+	CallAnswered  = "answered"
+	CallEnded     = "ended"
+	CallMissed    = "missed"
+	CallDeclined  = "declined"
+	CallCancelled = "cancelled"
+)
+
+type Call struct {
+	ID         string     `json:"id"`
+	CallerID   string     `json:"caller_id"`
+	CalleeID   string     `json:"callee_id"`
+	Video      bool       `json:"video"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	AnsweredAt *time.Time `json:"answered_at,omitempty"`
+	EndedAt    *time.Time `json:"ended_at,omitempty"`
+}
+
+// Live reports whether the call is still ringing or in progress.
+func (c *Call) Live() bool {
+	return c.Status == CallRinging || c.Status == CallAnswered
+}
+
+// Peer returns the other party of the call.
+func (c *Call) Peer(userID string) string {
+	if userID == c.CallerID {
+		return c.CalleeID
+	}
+	return c.CallerID
+}
+
+func (d *DB) CreateCall(callerID, calleeID string, video bool) (*Call, error) {
+	id := NewID()
+	_, err := d.Exec(`INSERT INTO calls (id, caller_id, callee_id, video, status) VALUES (?, ?, ?, ?, ?)`,
+		id, callerID, calleeID, video, CallRinging)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetCall(id)
+}
+
+func (d *DB) GetCall(id string) (*Call, error) {
+	return scanCall(d.QueryRow(`SELECT id, caller_id, callee_id, video, status, created_at, answered_at, ended_at
+		FROM calls WHERE id = ?`, id))
+}
+
+// AnswerCall marks a ringing call answered.  It reports false if the call
+// was no longer ringing (cancelled, missed, or answered on another device).
+func (d *DB) AnswerCall(id string) (bool, error) {
+	res, err := d.Exec(`UPDATE calls SET status = ?, answered_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		CallAnswered, id, CallRinging)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// EndCall moves a live call to a final status.  It reports false if the
+// call had already ended.
+func (d *DB) EndCall(id, status string) (bool, error) {
+	res, err := d.Exec(`UPDATE calls SET status = ?, ended_at = CURRENT_TIMESTAMP WHERE id = ? AND status IN (?, ?)`,
+		status, id, CallRinging, CallAnswered)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// LiveCallsFor returns the ringing or answered calls userID is part of.
+func (d *DB) LiveCallsFor(userID string) ([]Call, error) {
+	return d.queryCalls(`SELECT id, caller_id, callee_id, video, status, created_at, answered_at, ended_at
+		FROM calls WHERE (caller_id = ? OR callee_id = ?) AND status IN (?, ?)`,
+		userID, userID, CallRinging, CallAnswered)
+}
+
+// ListCalls returns userID's most recent calls, newest first.
+func (d *DB) ListCalls(userID string, limit int) ([]Call, error) {
+	return d.queryCalls(`SELECT id, caller_id, callee_id, video, status, created_at, answered_at, ended_at
+		FROM calls WHERE caller_id = ? OR callee_id = ? ORDER BY created_at DESC LIMIT ?`,
+		userID, userID, limit)
+}
+
+// EndStaleCalls closes calls left live by a previous run of a single-instance
+// server.
+func (d *DB) EndStaleCalls() {
+	d.Exec(`UPDATE calls SET status = CASE status WHEN ? THEN ? ELSE ? END, ended_at = CURRENT_TIMESTAMP
+		WHERE status IN (?, ?)`, CallRinging, CallMissed, CallEnded, CallRinging, CallAnswered)
+}
+
+func (d *DB) queryCalls(query string, args ...interface{}) ([]Call, error) {
+	rows, err := d.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	calls := []Call{}
+	for rows.Next() {
+		c, err := scanCall(rows)
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, *c)
+	}
+	return calls, rows.Err()
+}
+
+func scanCall(row interface{ Scan(...interface{}) error }) (*Call, error) {
+	c := &Call{}
+	var answered, ended sql.NullTime
+	if err := row.Scan(&c.ID, &c.CallerID, &c.CalleeID, &c.Video, &c.Status, &c.CreatedAt, &answered, &ended); err != nil {
+		return nil, err
+	}
+	if answered.Valid {
+		c.AnsweredAt = &answered.Time
+	}
+	if ended.Valid {
+		c.EndedAt = &ended.Time
+	}
+	return c, nil
+}
@@ -0,0 +1,176 @@
+package db
+
+import "time"
+
+// ─── Cluster (multi-instance) state ───────────────────────────────────────────
+//
+// When several Chirm processes share one database file, they coordinate
+// through four small tables: a heartbeat per instance, an append-only event
+// log that every instance polls, and the voice-room membership and connected
+// users each instance currently holds.  Rows belonging to an instance whose heartbeat has gone
+// stale are ignored and eventually swept.
+
+type ClusterEvent struct {
+	Seq      int64
+	Instance string
+	Kind     string
+	Target   string
+	Payload  string
+}
+
+// ClusterHeartbeat records that instanceID is alive.
+func (d *DB) ClusterHeartbeat(instanceID string) error {
+	_, err := d.Exec(`INSERT OR REPLACE INTO cluster_instances (id, last_seen) VALUES (?, ?)`, instanceID, time.Now())
+	return err
+}
+
+// ClusterLeave removes every trace of instanceID (used on clean shutdown and
+// on startup, in case a previous run with the same ID crashed).
+func (d *DB) ClusterLeave(instanceID string) {
+	d.Exec(`DELETE FROM cluster_voice WHERE instance_id = ?`, instanceID)
+	d.Exec(`DELETE FROM cluster_clients WHERE instance_id = ?`, instanceID)
+	d.Exec(`DELETE FROM cluster_instances WHERE id = ?`, instanceID)
+}
+
+// ClusterSweep drops instances (and their voice membership) that have not
+// sent a heartbeat within staleAfter, and prunes old events.  It returns the
+// voice rows that were removed so callers can announce the departures.
+func (d *DB) ClusterSweep(staleAfter, eventTTL time.Duration) ([]ClusterVoiceMember, error) {
+	cutoff := time.Now().Add(-staleAfter)
+	rows, err := d.Query(`
+		SELECT v.instance_id, v.channel_id, v.user_id FROM cluster_voice v
+		LEFT JOIN cluster_instances i ON i.id = v.instance_id
+		WHERE i.id IS NULL OR i.last_seen < ?`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	var gone []ClusterVoiceMember
+	for rows.Next() {
+		var m ClusterVoiceMember
+		if rows.Scan(&m.InstanceID, &m.ChannelID, &m.UserID) == nil {
+			gone = append(gone, m)
+		}
+	}
+	rows.Close()
+
+	for _, m := range gone {
+		d.Exec(`DELETE FROM cluster_voice WHERE instance_id = ? AND channel_id = ? AND user_id = ?`,
+			m.InstanceID, m.ChannelID, m.UserID)
+	}
+	d.Exec(`DELETE FROM cluster_clients WHERE instance_id NOT IN (SELECT id FROM cluster_instances WHERE last_seen >= ?)`, cutoff)
+	d.Exec(`DELETE FROM cluster_instances WHERE last_seen < ?`, cutoff)
+	d.Exec(`DELETE FROM cluster_events WHERE created_at < ?`, time.Now().Add(-eventTTL))
+	return gone, nil
+}
+
+// PublishClusterEvent appends an event for the other instances to pick up.
+func (d *DB) PublishClusterEvent(instanceID, kind, target, payload string) error {
+	_, err := d.Exec(`INSERT INTO cluster_events (instance, kind, target, payload) VALUES (?, ?, ?, ?)`,
+		instanceID, kind, target, payload)
+	return err
+}
+
+// ClusterEventsSince returns events newer than seq that were published by
+// other instances, oldest first.
+func (d *DB) ClusterEventsSince(seq int64, instanceID string) ([]ClusterEvent, error) {
+	rows, err := d.Query(`
+		SELECT seq, instance, kind, target, payload FROM cluster_events
+		WHERE seq > ? AND instance != ?
+		ORDER BY seq ASC LIMIT 500`, seq, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []ClusterEvent
+	for rows.Next() {
+		var e ClusterEvent
+		if rows.Scan(&e.Seq, &e.Instance, &e.Kind, &e.Target, &e.Payload) == nil {
+			events = append(events, e)
+		}
+	}
+	return events, rows.Err()
+}
+
+// LatestClusterEventSeq returns the current head of the event log so a newly
+// started instance doesn't replay history.
+func (d *DB) LatestClusterEventSeq() int64 {
+	var seq int64
+	d.QueryRow(`SELECT COALESCE(MAX(seq), 0) FROM cluster_events`).Scan(&seq)
+	return seq
+}
+
+type ClusterVoiceMember struct {
+	InstanceID string
+	ChannelID  string
+	UserID     string
+}
+
+func (d *DB) AddClusterVoiceMember(instanceID, channelID, userID string) error {
+	_, err := d.Exec(`INSERT OR IGNORE INTO cluster_voice (instance_id, channel_id, user_id) VALUES (?, ?, ?)`,
+		instanceID, channelID, userID)
+	return err
+}
+
+func (d *DB) RemoveClusterVoiceMember(instanceID, channelID, userID string) error {
+	_, err := d.Exec(`DELETE FROM cluster_voice WHERE instance_id = ? AND channel_id = ? AND user_id = ?`,
+		instanceID, channelID, userID)
+	return err
+}
+
+// RemoteVoiceMembers returns voice membership held by live instances other
+// than instanceID.
+func (d *DB) RemoteVoiceMembers(instanceID string, staleAfter time.Duration) ([]ClusterVoiceMember, error) {
+	rows, err := d.Query(`
+		SELECT v.instance_id, v.channel_id, v.user_id FROM cluster_voice v
+		JOIN cluster_instances i ON i.id = v.instance_id
+		WHERE v.instance_id != ? AND i.last_seen >= ?`, instanceID, time.Now().Add(-staleAfter))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ClusterVoiceMember
+	for rows.Next() {
+		var m ClusterVoiceMember
+		if rows.Scan(&m.InstanceID, &m.ChannelID, &m.UserID) == nil {
+			out = append(out, m)
+		}
+	}
+	return out, rows.Err()
+}
+
+// IsRemoteVoiceMember reports whether userID is in channelID's voice room on
+// some other live instance.
+func (d *DB) IsRemoteVoiceMember(instanceID, channelID, userID string, staleAfter time.Duration) bool {
+	var n int
+	d.QueryRow(`
+		SELECT COUNT(*) FROM cluster_voice v
+		JOIN cluster_instances i ON i.id = v.instance_id
+		WHERE v.instance_id != ? AND v.channel_id = ? AND v.user_id = ? AND i.last_seen >= ?`,
+		instanceID, channelID, userID, time.Now().Add(-staleAfter)).Scan(&n)
+	return n > 0
+}
+
+// AddClusterClient records that userID has a connection open on instanceID.
+func (d *DB) AddClusterClient(instanceID, userID string) error {
+	_, err := d.Exec(`INSERT OR IGNORE INTO cluster_clients (instance_id, user_id) VALUES (?, ?)`, instanceID, userID)
+	return err
+}
+
+// RemoveClusterClient records that userID's last connection to instanceID
+// has closed.
+func (d *DB) RemoveClusterClient(instanceID, userID string) error {
+	_, err := d.Exec(`DELETE FROM cluster_clients WHERE instance_id = ? AND user_id = ?`, instanceID, userID)
+	return err
+}
+
+// IsRemoteClient reports whether userID is connected to some other live
+// instance.
+func (d *DB) IsRemoteClient(instanceID, userID string, staleAfter time.Duration) bool {
+	var n int
+	d.QueryRow(`
+		SELECT COUNT(*) FROM cluster_clients c
+		JOIN cluster_instances i ON i.id = c.instance_id
+		WHERE c.instance_id != ? AND c.user_id = ? AND i.last_seen >= ?`,
+		instanceID, userID, time.Now().Add(-staleAfter)).Scan(&n)
+	return n > 0
+}
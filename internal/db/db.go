@@ -6,7 +6,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"os"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -21,22 +21,30 @@ const (
 	PermManageRoles    = 1 << 4
 	PermManageServer   = 1 << 5
 	PermAdministrator  = 1 << 6
+
+	// Voice rooms
+	PermConnect     = 1 << 7
+	PermSpeak       = 1 << 8
+	PermVideo       = 1 << 9
+	PermScreenShare = 1 << 10
+
+	PermVoiceAll = PermConnect | PermSpeak | PermVideo | PermScreenShare
+
+	PermMuteMembers = 1 << 11 // server mute/deafen others in voice
+	PermRecordVoice = 1 << 12 // start/stop voice recordings and listen to them
+	PermSoundboard  = 1 << 13 // play soundboard clips in voice rooms
+	PermBroadcast   = 1 << 14 // go live in broadcast channels
+
+	PermManageEvents = 1 << 15 // schedule, change and cancel server events
 )
 
 type DB struct {
-	*sql.DB
-}
+	*sql.DB // the writer; see pool.go
+	read    *sql.DB
 
-func Init(path string) (*DB, error) {
-	sqldb, err := sql.Open("sqlite", path+"?_foreign_keys=on&_journal_mode=WAL")
-	if err != nil {
-		return nil, err
-	}
-	d := &DB{sqldb}
-	if err := d.migrate(); err != nil {
-		return nil, fmt.Errorf("migration failed: %w", err)
-	}
-	return d, nil
+	// attachmentURL makes the URL clients download a stored file from; see
+	// SetAttachmentURLs.
+	attachmentURL func(filename string) string
 }
 
 func (d *DB) migrate() error {
@@ -122,6 +130,261 @@ CREATE TABLE IF NOT EXISTS invites (
 	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Everyone who signed up or joined a guild through an invite, so admins
+-- can see which links work.
+CREATE TABLE IF NOT EXISTS invite_joins (
+	code       TEXT NOT NULL,
+	user_id    TEXT NOT NULL,
+	registered INTEGER DEFAULT 0,
+	joined_at  DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (code, user_id),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- One-time codes an owner can reset their password with; only hashes are
+-- kept.
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	code_hash  TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	used_at    DATETIME,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Keys that sign session tokens, besides JWT_SECRET.  The newest one not
+-- retired signs new tokens.
+CREATE TABLE IF NOT EXISTS jwt_keys (
+	id         TEXT PRIMARY KEY,
+	secret     TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	retired_at DATETIME
+);
+
+-- Bot accounts' tokens (see bots.go); a bot is a user with is_bot set.
+CREATE TABLE IF NOT EXISTS bots (
+	user_id    TEXT PRIMARY KEY,
+	token_hash TEXT NOT NULL,
+	created_by TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Tokens people make to sign in where a password won't do, such as the
+-- IRC gateway (see tokens.go)
+CREATE TABLE IF NOT EXISTS personal_tokens (
+	id         TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL,
+	name       TEXT NOT NULL,
+	token_hash TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	last_used  DATETIME,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_personal_tokens_user ON personal_tokens(user_id);
+
+-- Channels shared with channels on other Chirm servers (see federation.go),
+-- the people who post from those servers, and events waiting to be sent.
+CREATE TABLE IF NOT EXISTS federation_links (
+	id                TEXT PRIMARY KEY,
+	channel_id        TEXT NOT NULL,
+	server            TEXT NOT NULL,
+	remote_channel_id TEXT NOT NULL,
+	public_key        TEXT NOT NULL,
+	created_by        TEXT NOT NULL DEFAULT '',
+	created_at        DATETIME DEFAULT CURRENT_TIMESTAMP,
+	last_error        TEXT NOT NULL DEFAULT '',
+	UNIQUE (channel_id, server),
+	FOREIGN KEY (channel_id) REFERENCES channels(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS remote_users (
+	id         TEXT PRIMARY KEY,
+	server     TEXT NOT NULL,
+	remote_id  TEXT NOT NULL,
+	username   TEXT NOT NULL,
+	avatar     TEXT NOT NULL DEFAULT '',
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (server, remote_id)
+);
+
+CREATE TABLE IF NOT EXISTS federation_outbox (
+	id           TEXT PRIMARY KEY,
+	link_id      TEXT NOT NULL,
+	event        TEXT NOT NULL,
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	next_attempt DATETIME NOT NULL,
+	created_at   DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_federation_outbox_due ON federation_outbox(next_attempt);
+
+-- Scheduled server events (see events.go) and who's coming.
+CREATE TABLE IF NOT EXISTS server_events (
+	id               TEXT PRIMARY KEY,
+	guild_id         TEXT NOT NULL,
+	channel_id       TEXT NOT NULL DEFAULT '',
+	title            TEXT NOT NULL,
+	description      TEXT NOT NULL DEFAULT '',
+	starts_at        DATETIME NOT NULL,
+	duration         INTEGER NOT NULL DEFAULT 0,
+	recurrence       TEXT NOT NULL DEFAULT '',
+	timezone         TEXT NOT NULL DEFAULT 'UTC',
+	reminder_minutes INTEGER NOT NULL DEFAULT 15,
+	reminded_for     DATETIME,
+	created_by       TEXT NOT NULL DEFAULT '',
+	created_at       DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at       DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_server_events_guild ON server_events(guild_id);
+
+CREATE TABLE IF NOT EXISTS event_rsvps (
+	event_id   TEXT NOT NULL,
+	user_id    TEXT NOT NULL,
+	status     TEXT NOT NULL,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (event_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_event_rsvps_user ON event_rsvps(user_id);
+
+-- Each member's link to the events they're coming to, as an iCal feed
+-- (see events.go)
+CREATE TABLE IF NOT EXISTS calendar_feeds (
+	user_id    TEXT PRIMARY KEY,
+	token_hash TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	last_used  DATETIME
+);
+
+-- Admins' automation rules: when something happens, what to do about it
+-- (see automations.go)
+CREATE TABLE IF NOT EXISTS automation_rules (
+	id           TEXT PRIMARY KEY,
+	guild_id     TEXT NOT NULL,
+	name         TEXT NOT NULL,
+	enabled      INTEGER NOT NULL DEFAULT 1,
+	trigger_type TEXT NOT NULL,
+	pattern      TEXT NOT NULL DEFAULT '',
+	channel_id   TEXT NOT NULL DEFAULT '',
+	actions      TEXT NOT NULL DEFAULT '[]',
+	runs         INTEGER NOT NULL DEFAULT 0,
+	last_run     DATETIME,
+	created_by   TEXT NOT NULL DEFAULT '',
+	created_at   DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at   DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_automation_rules_guild ON automation_rules(guild_id, trigger_type);
+
+-- Auto-moderation (see automod.go): each guild's filters, who's timed out,
+-- and the messages flagged for moderators to look at
+CREATE TABLE IF NOT EXISTS automod_settings (
+	guild_id   TEXT PRIMARY KEY,
+	config     TEXT NOT NULL,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS automod_timeouts (
+	guild_id TEXT NOT NULL,
+	user_id  TEXT NOT NULL,
+	until    DATETIME NOT NULL,
+	reason   TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (guild_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS automod_flags (
+	id          TEXT PRIMARY KEY,
+	guild_id    TEXT NOT NULL,
+	channel_id  TEXT NOT NULL,
+	message_id  TEXT NOT NULL DEFAULT '',
+	user_id     TEXT NOT NULL,
+	filter      TEXT NOT NULL,
+	reason      TEXT NOT NULL,
+	content     TEXT NOT NULL,
+	created_at  DATETIME DEFAULT CURRENT_TIMESTAMP,
+	reviewed_by TEXT NOT NULL DEFAULT '',
+	reviewed_at DATETIME,
+	outcome     TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_automod_flags_guild ON automod_flags(guild_id, reviewed_at);
+
+-- Machine translations of messages (see translations.go), by message and
+-- language.  source_hash is of the text that was translated, so once a
+-- message is edited its old translations no longer match.
+CREATE TABLE IF NOT EXISTS message_translations (
+	message_id  TEXT NOT NULL,
+	target      TEXT NOT NULL,
+	source_hash TEXT NOT NULL,
+	source_lang TEXT NOT NULL DEFAULT '',
+	content     TEXT NOT NULL,
+	provider    TEXT NOT NULL,
+	created_at  DATETIME NOT NULL,
+	PRIMARY KEY (message_id, target)
+);
+CREATE INDEX IF NOT EXISTS idx_message_translations_created ON message_translations(created_at);
+
+-- Chirm as an identity provider for other apps (see oauth.go): the apps,
+-- what each member let each app see, the keys ID tokens are signed with,
+-- and the codes and tokens handed out, only their hashes kept
+CREATE TABLE IF NOT EXISTS oauth_clients (
+	id            TEXT PRIMARY KEY,
+	name          TEXT NOT NULL,
+	secret_hash   TEXT NOT NULL DEFAULT '',
+	redirect_uris TEXT NOT NULL DEFAULT '[]',
+	scopes        TEXT NOT NULL DEFAULT '',
+	allowed_roles TEXT NOT NULL DEFAULT '[]',
+	created_by    TEXT NOT NULL DEFAULT '',
+	created_at    DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS oauth_consents (
+	user_id    TEXT NOT NULL,
+	client_id  TEXT NOT NULL,
+	scope      TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, client_id)
+);
+
+CREATE TABLE IF NOT EXISTS oauth_codes (
+	code_hash      TEXT PRIMARY KEY,
+	client_id      TEXT NOT NULL,
+	user_id        TEXT NOT NULL,
+	redirect_uri   TEXT NOT NULL,
+	scope          TEXT NOT NULL,
+	nonce          TEXT NOT NULL DEFAULT '',
+	code_challenge TEXT NOT NULL DEFAULT '',
+	auth_time      DATETIME NOT NULL,
+	expires_at     DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oauth_tokens (
+	token_hash TEXT PRIMARY KEY,
+	kind       TEXT NOT NULL,
+	client_id  TEXT NOT NULL,
+	user_id    TEXT NOT NULL,
+	scope      TEXT NOT NULL,
+	auth_time  DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_oauth_tokens_user ON oauth_tokens(user_id, client_id);
+
+CREATE TABLE IF NOT EXISTS oauth_keys (
+	id          TEXT PRIMARY KEY,
+	private_key TEXT NOT NULL,
+	created_at  DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Background jobs that one instance at a time runs (see jobs.go): how the
+-- last run went, and which instance has the job now.
+CREATE TABLE IF NOT EXISTS jobs (
+	name          TEXT PRIMARY KEY,
+	runs          INTEGER NOT NULL DEFAULT 0,
+	last_run      DATETIME,
+	last_took_ms  INTEGER NOT NULL DEFAULT 0,
+	last_error    TEXT NOT NULL DEFAULT '',
+	last_instance TEXT NOT NULL DEFAULT '',
+	locked_by     TEXT NOT NULL DEFAULT '',
+	locked_until  INTEGER NOT NULL DEFAULT 0
+);
+
 CREATE TABLE IF NOT EXISTS reactions (
 	message_id TEXT NOT NULL,
 	user_id    TEXT NOT NULL,
@@ -141,6 +404,40 @@ CREATE TABLE IF NOT EXISTS custom_emojis (
 	FOREIGN KEY (uploader_id) REFERENCES users(id) ON DELETE CASCADE
 );
 
+CREATE TABLE IF NOT EXISTS stickers (
+	id              TEXT PRIMARY KEY,
+	name            TEXT UNIQUE NOT NULL,
+	description     TEXT NOT NULL DEFAULT '',
+	filename        TEXT NOT NULL,
+	animated        INTEGER NOT NULL DEFAULT 0,
+	static_filename TEXT NOT NULL DEFAULT '',
+	uploader_id     TEXT NOT NULL,
+	created_at      DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (uploader_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS sounds (
+	id          TEXT PRIMARY KEY,
+	name        TEXT UNIQUE NOT NULL,
+	filename    TEXT NOT NULL,
+	uploader_id TEXT NOT NULL,
+	created_at  DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (uploader_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS calls (
+	id          TEXT PRIMARY KEY,
+	caller_id   TEXT NOT NULL,
+	callee_id   TEXT NOT NULL,
+	video       INTEGER NOT NULL DEFAULT 0,
+	status      TEXT NOT NULL,
+	created_at  DATETIME DEFAULT CURRENT_TIMESTAMP,
+	answered_at DATETIME,
+	ended_at    DATETIME,
+	FOREIGN KEY (caller_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (callee_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
 CREATE TABLE IF NOT EXISTS push_subscriptions (
 	id         TEXT PRIMARY KEY,
 	user_id    TEXT NOT NULL,
@@ -151,11 +448,202 @@ CREATE TABLE IF NOT EXISTS push_subscriptions (
 	UNIQUE(user_id, endpoint)
 );
 
+CREATE TABLE IF NOT EXISTS channel_reads (
+	user_id      TEXT NOT NULL,
+	channel_id   TEXT NOT NULL,
+	last_read_at DATETIME NOT NULL,
+	PRIMARY KEY (user_id, channel_id),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (channel_id) REFERENCES channels(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS notification_settings (
+	user_id           TEXT PRIMARY KEY,
+	default_level     TEXT NOT NULL DEFAULT 'all',
+	suppress_everyone INTEGER NOT NULL DEFAULT 0,
+	updated_at        DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS notification_channel_levels (
+	user_id    TEXT NOT NULL,
+	channel_id TEXT NOT NULL,
+	level      TEXT NOT NULL,
+	PRIMARY KEY (user_id, channel_id),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (channel_id) REFERENCES channels(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS cluster_instances (
+	id        TEXT PRIMARY KEY,
+	last_seen DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS cluster_events (
+	seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+	instance   TEXT NOT NULL,
+	kind       TEXT NOT NULL,
+	target     TEXT NOT NULL DEFAULT '',
+	payload    TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS cluster_voice (
+	instance_id TEXT NOT NULL,
+	channel_id  TEXT NOT NULL,
+	user_id     TEXT NOT NULL,
+	PRIMARY KEY (instance_id, channel_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS cluster_clients (
+	instance_id TEXT NOT NULL,
+	user_id     TEXT NOT NULL,
+	PRIMARY KEY (instance_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS channel_overrides (
+	channel_id TEXT NOT NULL,
+	role_id    TEXT NOT NULL,
+	allow      INTEGER NOT NULL DEFAULT 0,
+	deny       INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (channel_id, role_id),
+	FOREIGN KEY (channel_id) REFERENCES channels(id) ON DELETE CASCADE,
+	FOREIGN KEY (role_id)    REFERENCES roles(id)    ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS recordings (
+	id         TEXT PRIMARY KEY,
+	channel_id TEXT NOT NULL,
+	started_by TEXT NOT NULL,
+	started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	ended_at   DATETIME,
+	files      TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id         TEXT PRIMARY KEY,
+	actor_id   TEXT NOT NULL DEFAULT '',
+	action     TEXT NOT NULL,
+	target_id  TEXT NOT NULL DEFAULT '',
+	details    TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	id         TEXT PRIMARY KEY,
+	channel_id TEXT NOT NULL,
+	name       TEXT NOT NULL,
+	token_hash TEXT NOT NULL,
+	created_by TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (channel_id) REFERENCES channels(id) ON DELETE CASCADE
+);
+
+-- RSS and Atom feeds posted into channels (see feeds.go), and the items
+-- each has posted or passed over, so none is posted twice.
+CREATE TABLE IF NOT EXISTS feeds (
+	id            TEXT PRIMARY KEY,
+	channel_id    TEXT NOT NULL,
+	url           TEXT NOT NULL,
+	title         TEXT NOT NULL DEFAULT '',
+	created_by    TEXT NOT NULL DEFAULT '',
+	created_at    DATETIME DEFAULT CURRENT_TIMESTAMP,
+	last_checked  DATETIME,
+	last_error    TEXT NOT NULL DEFAULT '',
+	etag          TEXT NOT NULL DEFAULT '',
+	last_modified TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY (channel_id) REFERENCES channels(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS feed_items (
+	feed_id TEXT NOT NULL,
+	item    TEXT NOT NULL,
+	seen_at DATETIME NOT NULL,
+	PRIMARY KEY (feed_id, item),
+	FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS ip_rules (
+	id         TEXT PRIMARY KEY,
+	network    TEXT NOT NULL,
+	action     TEXT NOT NULL,
+	note       TEXT NOT NULL DEFAULT '',
+	created_by TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_activity (
+	user_id TEXT NOT NULL,
+	day     TEXT NOT NULL,
+	PRIMARY KEY (day, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS push_deliveries (
+	day    TEXT NOT NULL,
+	kind   TEXT NOT NULL,
+	sent   INTEGER NOT NULL DEFAULT 0,
+	failed INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (day, kind)
+);
+
+CREATE TABLE IF NOT EXISTS daily_stats (
+	day                 TEXT PRIMARY KEY,
+	messages            INTEGER NOT NULL DEFAULT 0,
+	active_users        INTEGER NOT NULL DEFAULT 0,
+	weekly_active_users INTEGER NOT NULL DEFAULT 0,
+	new_users           INTEGER NOT NULL DEFAULT 0,
+	push_sent           INTEGER NOT NULL DEFAULT 0,
+	push_failed         INTEGER NOT NULL DEFAULT 0,
+	storage_bytes       INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS channel_daily_stats (
+	day        TEXT NOT NULL,
+	channel_id TEXT NOT NULL,
+	messages   INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (day, channel_id)
+);
+
+CREATE TABLE IF NOT EXISTS guilds (
+	id          TEXT PRIMARY KEY,
+	name        TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	owner_id    TEXT NOT NULL,
+	created_at  DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS guild_members (
+	guild_id  TEXT NOT NULL,
+	user_id   TEXT NOT NULL,
+	joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (guild_id, user_id),
+	FOREIGN KEY (guild_id) REFERENCES guilds(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS rules_acceptances (
+	user_id     TEXT PRIMARY KEY,
+	accepted_at DATETIME NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS link_previews (
+	url        TEXT PRIMARY KEY,
+	data       TEXT NOT NULL,
+	fetched_at DATETIME NOT NULL
+);
+
 CREATE INDEX IF NOT EXISTS idx_messages_channel ON messages(channel_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log(created_at);
+CREATE INDEX IF NOT EXISTS idx_link_previews_fetched ON link_previews(fetched_at);
 CREATE INDEX IF NOT EXISTS idx_user_roles_user ON user_roles(user_id);
 CREATE INDEX IF NOT EXISTS idx_reactions_message ON reactions(message_id);
 CREATE INDEX IF NOT EXISTS idx_custom_emojis_name ON custom_emojis(name);
 CREATE INDEX IF NOT EXISTS idx_push_subs_user ON push_subscriptions(user_id);
+CREATE INDEX IF NOT EXISTS idx_calls_caller ON calls(caller_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_calls_callee ON calls(callee_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_guild_members_user ON guild_members(user_id);
+CREATE INDEX IF NOT EXISTS idx_invite_joins_code ON invite_joins(code, joined_at);
 `
 	_, err := d.Exec(schema)
 	if err != nil {
@@ -165,6 +653,67 @@ CREATE INDEX IF NOT EXISTS idx_push_subs_user ON push_subscriptions(user_id);
 	d.Exec(`ALTER TABLE messages ADD COLUMN reply_to_id TEXT`)
 	d.Exec(`ALTER TABLE channels ADD COLUMN emoji TEXT DEFAULT ''`)
 	d.Exec(`ALTER TABLE channels ADD COLUMN category_id TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE channels ADD COLUMN voice_log_channel_id TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE channels ADD COLUMN audio_bitrate INTEGER DEFAULT 0`)
+	d.Exec(`ALTER TABLE channels ADD COLUMN video_height INTEGER DEFAULT 0`)
+	d.Exec(`ALTER TABLE channels ADD COLUMN irc INTEGER DEFAULT 0`)
+	d.Exec(`ALTER TABLE messages ADD COLUMN type TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE notification_settings ADD COLUMN quiet_start TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE notification_settings ADD COLUMN quiet_end TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE notification_settings ADD COLUMN quiet_tz TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE notification_settings ADD COLUMN quiet_allow_mentions INTEGER DEFAULT 0`)
+	d.Exec(`ALTER TABLE notification_settings ADD COLUMN email_mentions INTEGER DEFAULT 1`)
+	d.Exec(`ALTER TABLE notification_settings ADD COLUMN digest TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE notification_settings ADD COLUMN digest_sent_at DATETIME`)
+	d.Exec(`ALTER TABLE notification_settings ADD COLUMN language TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE push_subscriptions ADD COLUMN kind TEXT DEFAULT 'webpush'`)
+	d.Exec(`ALTER TABLE push_subscriptions ADD COLUMN level TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE attachments ADD COLUMN width INTEGER DEFAULT 0`)
+	d.Exec(`ALTER TABLE attachments ADD COLUMN height INTEGER DEFAULT 0`)
+	d.Exec(`ALTER TABLE attachments ADD COLUMN duration REAL DEFAULT 0`)
+	d.Exec(`ALTER TABLE attachments ADD COLUMN poster TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE attachments ADD COLUMN status TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE attachments ADD COLUMN kind TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE attachments ADD COLUMN waveform TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE attachments ADD COLUMN preview TEXT DEFAULT ''`)
+	d.Exec(`CREATE INDEX IF NOT EXISTS idx_attachments_filename ON attachments(filename)`)
+	d.Exec(`CREATE INDEX IF NOT EXISTS idx_attachments_poster ON attachments(poster)`)
+	d.Exec(`ALTER TABLE custom_emojis ADD COLUMN animated INTEGER DEFAULT 0`)
+	d.Exec(`ALTER TABLE custom_emojis ADD COLUMN static_filename TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE messages ADD COLUMN sticker_id TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE messages ADD COLUMN embeds TEXT`)
+	d.Exec(`ALTER TABLE messages ADD COLUMN suppress_embeds INTEGER DEFAULT 0`)
+	d.Exec(`ALTER TABLE messages ADD COLUMN webhook_id TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE messages ADD COLUMN author_name TEXT DEFAULT ''`)
+	// Messages from another server have the link they came over and their
+	// ID there, and a remote user for user_id.
+	d.Exec(`ALTER TABLE messages ADD COLUMN federation_id TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE messages ADD COLUMN remote_id TEXT DEFAULT ''`)
+	d.Exec(`CREATE INDEX IF NOT EXISTS idx_messages_remote ON messages(federation_id, remote_id)`)
+	d.Exec(`ALTER TABLE messages ADD COLUMN rich_embeds TEXT`)
+	d.Exec(`ALTER TABLE channels ADD COLUMN guild_id TEXT DEFAULT 'default'`)
+	d.Exec(`ALTER TABLE channel_categories ADD COLUMN guild_id TEXT DEFAULT 'default'`)
+	d.Exec(`ALTER TABLE roles ADD COLUMN guild_id TEXT DEFAULT 'default'`)
+	d.Exec(`ALTER TABLE invites ADD COLUMN guild_id TEXT DEFAULT 'default'`)
+	d.Exec(`ALTER TABLE users ADD COLUMN invite_code TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE users ADD COLUMN invited_by TEXT DEFAULT ''`)
+	d.Exec(`ALTER TABLE users ADD COLUMN is_bot INTEGER DEFAULT 0`)
+
+	// Voice permissions were added after roles existed; grant them to
+	// @everyone once so existing servers keep working as before.
+	if v, _ := d.GetSetting("voice_perms_migrated"); v != "1" {
+		d.Exec(`UPDATE roles SET permissions = permissions | ? WHERE name = '@everyone'`, PermVoiceAll)
+		d.SetSetting("voice_perms_migrated", "1")
+	}
+	if v, _ := d.GetSetting("soundboard_perms_migrated"); v != "1" {
+		d.Exec(`UPDATE roles SET permissions = permissions | ? WHERE name = '@everyone'`, PermSoundboard)
+		d.SetSetting("soundboard_perms_migrated", "1")
+	}
+	// Managing events came later than managing the server, and goes with it.
+	if v, _ := d.GetSetting("event_perms_migrated"); v != "1" {
+		d.Exec(`UPDATE roles SET permissions = permissions | ? WHERE permissions & ? != 0`, PermManageEvents, PermManageServer)
+		d.SetSetting("event_perms_migrated", "1")
+	}
 	return nil
 }
 
@@ -176,6 +725,36 @@ func NewID() string {
 	return hex.EncodeToString(b)
 }
 
+// queryInChunk is the most IDs queryIn puts in one IN (...) list.
+const queryInChunk = 500
+
+// queryIn runs query for ids, a chunk at a time, with the %s in it replaced
+// by the chunk's placeholders, which come after args.  scan is called for
+// each row.
+func (d *DB) queryIn(query string, ids []string, args []interface{}, scan func(*sql.Rows)) error {
+	for len(ids) > 0 {
+		chunk := ids[:min(len(ids), queryInChunk)]
+		ids = ids[len(chunk):]
+		a := append([]interface{}{}, args...)
+		for _, id := range chunk {
+			a = append(a, id)
+		}
+		rows, err := d.Query(fmt.Sprintf(query, strings.TrimSuffix(strings.Repeat("?, ", len(chunk)), ", ")), a...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			scan(rows)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // --- Models ---
 
 type User struct {
@@ -188,10 +767,12 @@ type User struct {
 	CreatedAt    time.Time `json:"created_at"`
 	Roles        []Role    `json:"roles,omitempty"`
 	Permissions  int       `json:"permissions,omitempty"`
+	Bot          bool      `json:"bot,omitempty"` // a bot account, or a webhook standing in as a message's author
 }
 
 type Role struct {
 	ID          string    `json:"id"`
+	GuildID     string    `json:"guild_id"`
 	Name        string    `json:"name"`
 	Color       string    `json:"color"`
 	Permissions int       `json:"permissions"`
@@ -200,18 +781,29 @@ type Role struct {
 }
 
 type Channel struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Type        string    `json:"type"`
-	Position    int       `json:"position"`
-	Emoji       string    `json:"emoji"`
-	CategoryID  string    `json:"category_id"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID          string `json:"id"`
+	GuildID     string `json:"guild_id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Position    int    `json:"position"`
+	Emoji       string `json:"emoji"`
+	CategoryID  string `json:"category_id"`
+	// VoiceLogChannelID is the text channel that receives join/leave
+	// messages for a voice channel ("" = none).
+	VoiceLogChannelID string `json:"voice_log_channel_id"`
+	// Voice quality caps: Opus bitrate in kbit/s and camera/screen height in
+	// pixels (0 = client default).
+	AudioBitrate int `json:"audio_bitrate"`
+	VideoHeight  int `json:"video_height"`
+	// IRC is whether a text channel is open to the IRC gateway.
+	IRC       bool      `json:"irc"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type ChannelCategory struct {
 	ID        string    `json:"id"`
+	GuildID   string    `json:"guild_id"`
 	Name      string    `json:"name"`
 	Position  int       `json:"position"`
 	CreatedAt time.Time `json:"created_at"`
@@ -233,6 +825,7 @@ type Message struct {
 	ID          string       `json:"id"`
 	ChannelID   string       `json:"channel_id"`
 	UserID      string       `json:"user_id"`
+	Type        string       `json:"type,omitempty"` // "" for user messages, "system" or "welcome" for server-generated ones
 	Content     string       `json:"content"`
 	ReplyToID   *string      `json:"reply_to_id,omitempty"`
 	ReplyTo     *MessageRef  `json:"reply_to,omitempty"`
@@ -241,6 +834,45 @@ type Message struct {
 	Author      *User        `json:"author,omitempty"`
 	Attachments []Attachment `json:"attachments,omitempty"`
 	Reactions   []Reaction   `json:"reactions,omitempty"`
+	StickerID   string       `json:"sticker_id,omitempty"`
+	Sticker     *Sticker     `json:"sticker,omitempty"` // nil if the sticker has been deleted
+
+	// Link previews, fetched by the server after the message is sent.
+	// EmbedsPending is set until they've been stored.
+	Embeds        json.RawMessage `json:"embeds,omitempty"`
+	EmbedsPending bool            `json:"embeds_pending,omitempty"`
+	// SuppressEmbeds is set when the sender asked for no link previews.
+	SuppressEmbeds bool `json:"suppress_embeds,omitempty"`
+	// Messages posted through a webhook have its ID instead of a user, and
+	// may carry the integration's own embeds.
+	WebhookID  string          `json:"webhook_id,omitempty"`
+	RichEmbeds json.RawMessage `json:"rich_embeds,omitempty"`
+	// Messages from another server have the federation link they came over
+	// and their ID there; see federation.go.
+	FederationID string `json:"-"`
+	RemoteID     string `json:"-"`
+}
+
+// embedsPendingTimeout is how long a message's embeds are waited for; if
+// the server restarted meanwhile they'd never come, so clients are left to
+// fetch the previews themselves.
+const embedsPendingTimeout = 2 * time.Minute
+
+// setEmbeds fills in m's embeds from the embeds column, which is NULL for
+// messages with no links (or from before embeds were stored) and empty
+// while they're being fetched.
+func (m *Message) setEmbeds(v sql.NullString) {
+	switch {
+	case !v.Valid:
+	case v.String == "":
+		since := m.CreatedAt
+		if m.EditedAt != nil {
+			since = *m.EditedAt
+		}
+		m.EmbedsPending = time.Since(since) < embedsPendingTimeout
+	default:
+		m.Embeds = json.RawMessage(v.String)
+	}
 }
 
 type Attachment struct {
@@ -250,17 +882,29 @@ type Attachment struct {
 	OriginalName string    `json:"original_name"`
 	MimeType     string    `json:"mime_type"`
 	Size         int64     `json:"size"`
+	Width        int       `json:"width,omitempty"` // pixels, for images whose size could be read
+	Height       int       `json:"height,omitempty"`
+	Duration     float64   `json:"duration,omitempty"` // seconds, for video
+	Poster       string    `json:"poster,omitempty"`   // filename of a video's preview frame
+	Status       string    `json:"status,omitempty"`   // transcoding: queued, processing, done or failed; or evicted
+	Kind         string    `json:"kind,omitempty"`     // "voice" for voice notes, otherwise a plain file
+	Waveform     []int     `json:"waveform,omitempty"` // voice notes: loudness peaks, 0-100
+	Preview      string    `json:"preview,omitempty"`  // text and PDFs: the first few lines
+	URL          string    `json:"url,omitempty"`      // where to download the file; may expire
+	PosterURL    string    `json:"poster_url,omitempty"`
 	CreatedAt    time.Time `json:"created_at"`
 }
 
 type Invite struct {
 	Code      string     `json:"code"`
+	GuildID   string     `json:"guild_id"`
 	CreatedBy string     `json:"created_by"`
 	Uses      int        `json:"uses"`
 	MaxUses   int        `json:"max_uses"`
 	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 	CreatedAt time.Time  `json:"created_at"`
 	Creator   *User      `json:"creator,omitempty"`
+	Joins     int        `json:"joins"` // people who signed up or joined through it
 }
 
 // --- Server Settings ---
@@ -319,12 +963,13 @@ func (d *DB) GetUserByID(id string) (*User, error) {
 	u := &User{}
 	var owner int
 	err := d.QueryRow(
-		`SELECT id, username, email, password_hash, avatar, is_owner, created_at FROM users WHERE id = ?`, id,
-	).Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.Avatar, &owner, &u.CreatedAt)
+		`SELECT id, username, email, password_hash, avatar, is_owner, is_bot, created_at FROM users WHERE id = ?`, id,
+	).Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.Avatar, &owner, &u.Bot, &u.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	u.IsOwner = owner == 1
+	u.Email = userEmail(u)
 	u.Roles, _ = d.GetUserRoles(id)
 	u.Permissions = d.ComputePermissions(u)
 	return u, nil
@@ -334,12 +979,13 @@ func (d *DB) GetUserByUsername(username string) (*User, error) {
 	u := &User{}
 	var owner int
 	err := d.QueryRow(
-		`SELECT id, username, email, password_hash, avatar, is_owner, created_at FROM users WHERE username = ?`, username,
-	).Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.Avatar, &owner, &u.CreatedAt)
+		`SELECT id, username, email, password_hash, avatar, is_owner, is_bot, created_at FROM users WHERE username = ?`, username,
+	).Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.Avatar, &owner, &u.Bot, &u.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	u.IsOwner = owner == 1
+	u.Email = userEmail(u)
 	u.Roles, _ = d.GetUserRoles(u.ID)
 	u.Permissions = d.ComputePermissions(u)
 	return u, nil
@@ -349,19 +995,20 @@ func (d *DB) GetUserByEmail(email string) (*User, error) {
 	u := &User{}
 	var owner int
 	err := d.QueryRow(
-		`SELECT id, username, email, password_hash, avatar, is_owner, created_at FROM users WHERE email = ?`, email,
-	).Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.Avatar, &owner, &u.CreatedAt)
+		`SELECT id, username, email, password_hash, avatar, is_owner, is_bot, created_at FROM users WHERE email = ?`, email,
+	).Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.Avatar, &owner, &u.Bot, &u.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	u.IsOwner = owner == 1
+	u.Email = userEmail(u)
 	u.Roles, _ = d.GetUserRoles(u.ID)
 	u.Permissions = d.ComputePermissions(u)
 	return u, nil
 }
 
 func (d *DB) ListUsers() ([]User, error) {
-	rows, err := d.Query(`SELECT id, username, email, avatar, is_owner, created_at FROM users ORDER BY created_at ASC`)
+	rows, err := d.Query(`SELECT id, username, email, avatar, is_owner, is_bot, created_at FROM users ORDER BY created_at ASC`)
 	if err != nil {
 		return nil, err
 	}
@@ -370,8 +1017,9 @@ func (d *DB) ListUsers() ([]User, error) {
 	for rows.Next() {
 		var u User
 		var owner int
-		rows.Scan(&u.ID, &u.Username, &u.Email, &u.Avatar, &owner, &u.CreatedAt)
+		rows.Scan(&u.ID, &u.Username, &u.Email, &u.Avatar, &owner, &u.Bot, &u.CreatedAt)
 		u.IsOwner = owner == 1
+		u.Email = userEmail(&u)
 		u.Roles, _ = d.GetUserRoles(u.ID)
 		users = append(users, u)
 	}
@@ -383,6 +1031,12 @@ func (d *DB) UpdateUser(id, username, avatar string) error {
 	return err
 }
 
+// SetPasswordHash replaces a user's password.
+func (d *DB) SetPasswordHash(id, hash string) error {
+	_, err := d.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, hash, id)
+	return err
+}
+
 func (d *DB) DeleteUser(id string) error {
 	_, err := d.Exec(`DELETE FROM users WHERE id = ?`, id)
 	return err
@@ -396,9 +1050,12 @@ func (d *DB) UserCount() int {
 
 // --- Permissions ---
 
+// ownerPermissions is every permission, what an owner has.
+const ownerPermissions = PermAdministrator | PermManageServer | PermManageRoles | PermManageChannels | PermManageMessages | PermSendMessages | PermReadMessages | PermVoiceAll | PermMuteMembers | PermRecordVoice | PermSoundboard | PermBroadcast | PermManageEvents
+
 func (d *DB) ComputePermissions(u *User) int {
 	if u.IsOwner {
-		return PermAdministrator | PermManageServer | PermManageRoles | PermManageChannels | PermManageMessages | PermSendMessages | PermReadMessages
+		return ownerPermissions
 	}
 	perms := 0
 	// @everyone base permissions
@@ -422,22 +1079,32 @@ func (d *DB) HasPermission(u *User, perm int) bool {
 
 // --- Roles ---
 
+const roleColumns = `id, COALESCE(guild_id, 'default'), name, color, permissions, position, created_at`
+
+func scanRole(row interface{ Scan(...interface{}) error }, r *Role) error {
+	return row.Scan(&r.ID, &r.GuildID, &r.Name, &r.Color, &r.Permissions, &r.Position, &r.CreatedAt)
+}
+
 func (d *DB) GetEveryoneRole() (*Role, error) {
+	return d.GuildEveryoneRole(DefaultGuild)
+}
+
+// GuildEveryoneRole returns the role every member of guildID has.
+func (d *DB) GuildEveryoneRole(guildID string) (*Role, error) {
 	r := &Role{}
-	err := d.QueryRow(`SELECT id, name, color, permissions, position, created_at FROM roles WHERE name = '@everyone' ORDER BY position ASC LIMIT 1`).
-		Scan(&r.ID, &r.Name, &r.Color, &r.Permissions, &r.Position, &r.CreatedAt)
+	err := scanRole(d.QueryRow(`SELECT `+roleColumns+` FROM roles WHERE name = '@everyone' AND COALESCE(guild_id, 'default') = ? ORDER BY position ASC LIMIT 1`, guildID), r)
 	if err != nil {
 		return nil, err
 	}
 	return r, nil
 }
 
-func (d *DB) CreateRole(name, color string, permissions int) (*Role, error) {
+func (d *DB) CreateRole(guildID, name, color string, permissions int) (*Role, error) {
 	id := NewID()
 	var pos int
-	d.QueryRow(`SELECT COALESCE(MAX(position), 0) + 1 FROM roles`).Scan(&pos)
-	_, err := d.Exec(`INSERT INTO roles (id, name, color, permissions, position) VALUES (?, ?, ?, ?, ?)`,
-		id, name, color, permissions, pos)
+	d.QueryRow(`SELECT COALESCE(MAX(position), 0) + 1 FROM roles WHERE COALESCE(guild_id, 'default') = ?`, guildID).Scan(&pos)
+	_, err := d.Exec(`INSERT INTO roles (id, guild_id, name, color, permissions, position) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, guildID, name, color, permissions, pos)
 	if err != nil {
 		return nil, err
 	}
@@ -446,13 +1113,12 @@ func (d *DB) CreateRole(name, color string, permissions int) (*Role, error) {
 
 func (d *DB) GetRoleByID(id string) (*Role, error) {
 	r := &Role{}
-	err := d.QueryRow(`SELECT id, name, color, permissions, position, created_at FROM roles WHERE id = ?`, id).
-		Scan(&r.ID, &r.Name, &r.Color, &r.Permissions, &r.Position, &r.CreatedAt)
+	err := scanRole(d.QueryRow(`SELECT `+roleColumns+` FROM roles WHERE id = ?`, id), r)
 	return r, err
 }
 
-func (d *DB) ListRoles() ([]Role, error) {
-	rows, err := d.Query(`SELECT id, name, color, permissions, position, created_at FROM roles ORDER BY position ASC`)
+func (d *DB) ListRoles(guildID string) ([]Role, error) {
+	rows, err := d.Query(`SELECT `+roleColumns+` FROM roles WHERE COALESCE(guild_id, 'default') = ? ORDER BY position ASC`, guildID)
 	if err != nil {
 		return nil, err
 	}
@@ -460,7 +1126,7 @@ func (d *DB) ListRoles() ([]Role, error) {
 	var roles []Role
 	for rows.Next() {
 		var r Role
-		rows.Scan(&r.ID, &r.Name, &r.Color, &r.Permissions, &r.Position, &r.CreatedAt)
+		scanRole(rows, &r)
 		roles = append(roles, r)
 	}
 	return roles, nil
@@ -476,13 +1142,20 @@ func (d *DB) DeleteRole(id string) error {
 	return err
 }
 
+// GetUserRoles returns userID's roles in the default guild, the ones a
+// User's Roles and Permissions are made from.
 func (d *DB) GetUserRoles(userID string) ([]Role, error) {
+	return d.GetUserGuildRoles(userID, DefaultGuild)
+}
+
+// GetUserGuildRoles returns userID's roles in guildID.
+func (d *DB) GetUserGuildRoles(userID, guildID string) ([]Role, error) {
 	rows, err := d.Query(`
-		SELECT r.id, r.name, r.color, r.permissions, r.position, r.created_at
+		SELECT r.id, COALESCE(r.guild_id, 'default'), r.name, r.color, r.permissions, r.position, r.created_at
 		FROM roles r
 		JOIN user_roles ur ON r.id = ur.role_id
-		WHERE ur.user_id = ?
-		ORDER BY r.position ASC`, userID)
+		WHERE ur.user_id = ? AND COALESCE(r.guild_id, 'default') = ?
+		ORDER BY r.position ASC`, userID, guildID)
 	if err != nil {
 		return nil, err
 	}
@@ -490,7 +1163,7 @@ func (d *DB) GetUserRoles(userID string) ([]Role, error) {
 	var roles []Role
 	for rows.Next() {
 		var r Role
-		rows.Scan(&r.ID, &r.Name, &r.Color, &r.Permissions, &r.Position, &r.CreatedAt)
+		scanRole(rows, &r)
 		roles = append(roles, r)
 	}
 	return roles, nil
@@ -508,12 +1181,12 @@ func (d *DB) RemoveRole(userID, roleID string) error {
 
 // --- Channels ---
 
-func (d *DB) CreateChannel(name, description, chType, emoji, categoryID string) (*Channel, error) {
+func (d *DB) CreateChannel(guildID, name, description, chType, emoji, categoryID string) (*Channel, error) {
 	id := NewID()
 	var pos int
-	d.QueryRow(`SELECT COALESCE(MAX(position), 0) + 1 FROM channels WHERE category_id = ?`, categoryID).Scan(&pos)
-	_, err := d.Exec(`INSERT INTO channels (id, name, description, type, position, emoji, category_id) VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		id, name, description, chType, pos, emoji, categoryID)
+	d.QueryRow(`SELECT COALESCE(MAX(position), 0) + 1 FROM channels WHERE COALESCE(guild_id,'default') = ? AND category_id = ?`, guildID, categoryID).Scan(&pos)
+	_, err := d.Exec(`INSERT INTO channels (id, guild_id, name, description, type, position, emoji, category_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, guildID, name, description, chType, pos, emoji, categoryID)
 	if err != nil {
 		return nil, err
 	}
@@ -522,13 +1195,13 @@ func (d *DB) CreateChannel(name, description, chType, emoji, categoryID string)
 
 func (d *DB) GetChannelByID(id string) (*Channel, error) {
 	c := &Channel{}
-	err := d.QueryRow(`SELECT id, name, description, type, position, COALESCE(emoji,''), COALESCE(category_id,''), created_at FROM channels WHERE id = ?`, id).
-		Scan(&c.ID, &c.Name, &c.Description, &c.Type, &c.Position, &c.Emoji, &c.CategoryID, &c.CreatedAt)
+	err := d.QueryRow(`SELECT id, COALESCE(guild_id,'default'), name, description, type, position, COALESCE(emoji,''), COALESCE(category_id,''), COALESCE(voice_log_channel_id,''), COALESCE(audio_bitrate,0), COALESCE(video_height,0), COALESCE(irc,0), created_at FROM channels WHERE id = ?`, id).
+		Scan(&c.ID, &c.GuildID, &c.Name, &c.Description, &c.Type, &c.Position, &c.Emoji, &c.CategoryID, &c.VoiceLogChannelID, &c.AudioBitrate, &c.VideoHeight, &c.IRC, &c.CreatedAt)
 	return c, err
 }
 
-func (d *DB) ListChannels() ([]Channel, error) {
-	rows, err := d.Query(`SELECT id, name, description, type, position, COALESCE(emoji,''), COALESCE(category_id,''), created_at FROM channels ORDER BY category_id ASC, position ASC`)
+func (d *DB) ListChannels(guildID string) ([]Channel, error) {
+	rows, err := d.Query(`SELECT id, COALESCE(guild_id,'default'), name, description, type, position, COALESCE(emoji,''), COALESCE(category_id,''), COALESCE(voice_log_channel_id,''), COALESCE(audio_bitrate,0), COALESCE(video_height,0), COALESCE(irc,0), created_at FROM channels WHERE COALESCE(guild_id,'default') = ? ORDER BY category_id ASC, position ASC`, guildID)
 	if err != nil {
 		return nil, err
 	}
@@ -536,7 +1209,7 @@ func (d *DB) ListChannels() ([]Channel, error) {
 	var channels []Channel
 	for rows.Next() {
 		var c Channel
-		rows.Scan(&c.ID, &c.Name, &c.Description, &c.Type, &c.Position, &c.Emoji, &c.CategoryID, &c.CreatedAt)
+		rows.Scan(&c.ID, &c.GuildID, &c.Name, &c.Description, &c.Type, &c.Position, &c.Emoji, &c.CategoryID, &c.VoiceLogChannelID, &c.AudioBitrate, &c.VideoHeight, &c.IRC, &c.CreatedAt)
 		channels = append(channels, c)
 	}
 	return channels, nil
@@ -547,7 +1220,55 @@ func (d *DB) UpdateChannel(id, name, description, emoji, categoryID string) erro
 	return err
 }
 
-func (d *DB) ReorderChannels(orders []struct{ ID string; Position int; CategoryID string }) error {
+// SetVoiceQuality sets a voice channel's audio bitrate (kbit/s) and video
+// height caps; zero means no cap.
+func (d *DB) SetVoiceQuality(id string, audioBitrate, videoHeight int) error {
+	_, err := d.Exec(`UPDATE channels SET audio_bitrate = ?, video_height = ? WHERE id = ?`, audioBitrate, videoHeight, id)
+	return err
+}
+
+// SetChannelIRC opens a text channel to the IRC gateway, or closes it.
+func (d *DB) SetChannelIRC(id string, on bool) error {
+	_, err := d.Exec(`UPDATE channels SET irc = ? WHERE id = ?`, on, id)
+	return err
+}
+
+// IRCChannels returns the text channels open to the IRC gateway, in every
+// guild, oldest first.
+func (d *DB) IRCChannels() ([]Channel, error) {
+	rows, err := d.Query(`SELECT id FROM channels WHERE irc = 1 AND type = 'text' ORDER BY created_at ASC`)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	channels := []Channel{}
+	for _, id := range ids {
+		if c, err := d.GetChannelByID(id); err == nil {
+			channels = append(channels, *c)
+		}
+	}
+	return channels, nil
+}
+
+// SetVoiceLogChannel sets the text channel that receives a voice channel's
+// join/leave messages; an empty textChannelID turns them off.
+func (d *DB) SetVoiceLogChannel(id, textChannelID string) error {
+	_, err := d.Exec(`UPDATE channels SET voice_log_channel_id = ? WHERE id = ?`, textChannelID, id)
+	return err
+}
+
+func (d *DB) ReorderChannels(orders []struct {
+	ID         string
+	Position   int
+	CategoryID string
+}) error {
 	tx, err := d.Begin()
 	if err != nil {
 		return err
@@ -560,22 +1281,26 @@ func (d *DB) ReorderChannels(orders []struct{ ID string; Position int; CategoryI
 
 // --- Channel Categories ---
 
-func (d *DB) CreateCategory(name string) (*ChannelCategory, error) {
+func (d *DB) CreateCategory(guildID, name string) (*ChannelCategory, error) {
 	id := NewID()
 	var pos int
-	d.QueryRow(`SELECT COALESCE(MAX(position), 0) + 1 FROM channel_categories`).Scan(&pos)
-	_, err := d.Exec(`INSERT INTO channel_categories (id, name, position) VALUES (?, ?, ?)`, id, name, pos)
+	d.QueryRow(`SELECT COALESCE(MAX(position), 0) + 1 FROM channel_categories WHERE COALESCE(guild_id,'default') = ?`, guildID).Scan(&pos)
+	_, err := d.Exec(`INSERT INTO channel_categories (id, guild_id, name, position) VALUES (?, ?, ?, ?)`, id, guildID, name, pos)
 	if err != nil {
 		return nil, err
 	}
-	cat := &ChannelCategory{}
-	d.QueryRow(`SELECT id, name, position, created_at FROM channel_categories WHERE id = ?`, id).
-		Scan(&cat.ID, &cat.Name, &cat.Position, &cat.CreatedAt)
-	return cat, nil
+	return d.GetCategoryByID(id)
+}
+
+func (d *DB) GetCategoryByID(id string) (*ChannelCategory, error) {
+	c := &ChannelCategory{}
+	err := d.QueryRow(`SELECT id, COALESCE(guild_id,'default'), name, position, created_at FROM channel_categories WHERE id = ?`, id).
+		Scan(&c.ID, &c.GuildID, &c.Name, &c.Position, &c.CreatedAt)
+	return c, err
 }
 
-func (d *DB) ListCategories() ([]ChannelCategory, error) {
-	rows, err := d.Query(`SELECT id, name, position, created_at FROM channel_categories ORDER BY position ASC`)
+func (d *DB) ListCategories(guildID string) ([]ChannelCategory, error) {
+	rows, err := d.Query(`SELECT id, COALESCE(guild_id,'default'), name, position, created_at FROM channel_categories WHERE COALESCE(guild_id,'default') = ? ORDER BY position ASC`, guildID)
 	if err != nil {
 		return nil, err
 	}
@@ -583,7 +1308,7 @@ func (d *DB) ListCategories() ([]ChannelCategory, error) {
 	var cats []ChannelCategory
 	for rows.Next() {
 		var c ChannelCategory
-		rows.Scan(&c.ID, &c.Name, &c.Position, &c.CreatedAt)
+		rows.Scan(&c.ID, &c.GuildID, &c.Name, &c.Position, &c.CreatedAt)
 		cats = append(cats, c)
 	}
 	if cats == nil {
@@ -604,7 +1329,10 @@ func (d *DB) DeleteCategory(id string) error {
 	return err
 }
 
-func (d *DB) ReorderCategories(orders []struct{ ID string; Position int }) error {
+func (d *DB) ReorderCategories(orders []struct {
+	ID       string
+	Position int
+}) error {
 	tx, err := d.Begin()
 	if err != nil {
 		return err
@@ -617,6 +1345,11 @@ func (d *DB) ReorderCategories(orders []struct{ ID string; Position int }) error
 
 func (d *DB) DeleteChannel(id string) error {
 	_, err := d.Exec(`DELETE FROM channels WHERE id = ?`, id)
+	if err == nil {
+		d.Exec(`UPDATE channels SET voice_log_channel_id = '' WHERE voice_log_channel_id = ?`, id)
+		d.Exec(`UPDATE server_events SET channel_id = '' WHERE channel_id = ?`, id)
+		d.Exec(`UPDATE automation_rules SET enabled = 0 WHERE channel_id = ?`, id)
+	}
 	return err
 }
 
@@ -632,38 +1365,77 @@ func (d *DB) CreateMessage(channelID, userID, content string, replyToID *string)
 	return d.GetMessageByID(id)
 }
 
+// CreateSystemMessage stores a server-generated message about userID, such
+// as a voice join/leave notice.
+func (d *DB) CreateSystemMessage(channelID, userID, content string) (*Message, error) {
+	id := NewID()
+	_, err := d.Exec(`INSERT INTO messages (id, channel_id, user_id, type, content) VALUES (?, ?, ?, 'system', ?)`,
+		id, channelID, userID, content)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetMessageByID(id)
+}
+
 func (d *DB) GetMessageByID(id string) (*Message, error) {
 	m := &Message{}
 	var editedAt sql.NullTime
-	var replyToID sql.NullString
-	err := d.QueryRow(`SELECT id, channel_id, user_id, content, reply_to_id, edited_at, created_at FROM messages WHERE id = ?`, id).
-		Scan(&m.ID, &m.ChannelID, &m.UserID, &m.Content, &replyToID, &editedAt, &m.CreatedAt)
+	var replyToID, embeds, richEmbeds sql.NullString
+	var authorName string
+	err := d.QueryRow(`SELECT id, channel_id, COALESCE(user_id,''), COALESCE(type,''), content, reply_to_id, edited_at, created_at, COALESCE(sticker_id,''), embeds, COALESCE(suppress_embeds,0), COALESCE(webhook_id,''), COALESCE(author_name,''), rich_embeds, COALESCE(federation_id,''), COALESCE(remote_id,'') FROM messages WHERE id = ?`, id).
+		Scan(&m.ID, &m.ChannelID, &m.UserID, &m.Type, &m.Content, &replyToID, &editedAt, &m.CreatedAt, &m.StickerID, &embeds, &m.SuppressEmbeds, &m.WebhookID, &authorName, &richEmbeds, &m.FederationID, &m.RemoteID)
 	if err != nil {
 		return nil, err
 	}
 	if editedAt.Valid {
 		m.EditedAt = &editedAt.Time
 	}
+	m.setEmbeds(embeds)
+	if richEmbeds.Valid {
+		m.RichEmbeds = json.RawMessage(richEmbeds.String)
+	}
 	if replyToID.Valid {
 		m.ReplyToID = &replyToID.String
 		m.ReplyTo, _ = d.GetMessageRef(replyToID.String)
 	}
-	m.Author, _ = d.GetUserByID(m.UserID)
+	m.Author = d.messageAuthor(m, authorName)
 	m.Attachments, _ = d.GetAttachments(m.ID)
 	m.Reactions, _ = d.GetReactions(m.ID)
+	if m.StickerID != "" {
+		m.Sticker, _ = d.GetStickerByID(m.StickerID)
+	}
 	return m, nil
 }
 
+// messageAuthor returns who wrote m: its user, or for a webhook's message
+// a stand-in with the name it was posted under.
+func (d *DB) messageAuthor(m *Message, authorName string) *User {
+	if m.WebhookID != "" {
+		return &User{ID: m.WebhookID, Username: authorName, Bot: true}
+	}
+	if m.FederationID != "" {
+		return d.remoteAuthor(m.UserID)
+	}
+	u, _ := d.GetUserByID(m.UserID)
+	return u
+}
+
 func (d *DB) GetMessageRef(id string) (*MessageRef, error) {
 	ref := &MessageRef{ID: id}
 	var authorID string
-	err := d.QueryRow(`SELECT content, user_id FROM messages WHERE id = ?`, id).
-		Scan(&ref.Content, &authorID)
+	var webhookName string
+	err := d.QueryRow(`SELECT content, COALESCE(user_id,''), CASE WHEN COALESCE(webhook_id,'') != '' THEN author_name ELSE '' END FROM messages WHERE id = ?`, id).
+		Scan(&ref.Content, &authorID, &webhookName)
 	if err != nil {
 		return nil, err
 	}
 	u, _ := d.GetUserByID(authorID)
-	if u != nil {
+	if u == nil {
+		u = d.remoteAuthor(authorID)
+	}
+	if webhookName != "" {
+		ref.AuthorName = webhookName
+	} else if u != nil {
 		ref.AuthorName = u.Username
 	} else {
 		ref.AuthorName = "Deleted User"
@@ -680,12 +1452,12 @@ func (d *DB) GetMessages(channelID string, before string, limit int) ([]Message,
 	var err error
 	if before == "" {
 		rows, err = d.Query(`
-			SELECT id, channel_id, user_id, content, reply_to_id, edited_at, created_at 
+			SELECT id, channel_id, COALESCE(user_id,''), COALESCE(type,''), content, reply_to_id, edited_at, created_at, COALESCE(sticker_id,''), embeds, COALESCE(suppress_embeds,0), COALESCE(webhook_id,''), COALESCE(author_name,''), rich_embeds, COALESCE(federation_id,''), COALESCE(remote_id,'')
 			FROM messages WHERE channel_id = ?
 			ORDER BY created_at DESC LIMIT ?`, channelID, limit)
 	} else {
 		rows, err = d.Query(`
-			SELECT id, channel_id, user_id, content, reply_to_id, edited_at, created_at 
+			SELECT id, channel_id, COALESCE(user_id,''), COALESCE(type,''), content, reply_to_id, edited_at, created_at, COALESCE(sticker_id,''), embeds, COALESCE(suppress_embeds,0), COALESCE(webhook_id,''), COALESCE(author_name,''), rich_embeds, COALESCE(federation_id,''), COALESCE(remote_id,'')
 			FROM messages WHERE channel_id = ? AND created_at < (SELECT created_at FROM messages WHERE id = ?)
 			ORDER BY created_at DESC LIMIT ?`, channelID, before, limit)
 	}
@@ -698,18 +1470,26 @@ func (d *DB) GetMessages(channelID string, before string, limit int) ([]Message,
 	for rows.Next() {
 		var m Message
 		var editedAt sql.NullTime
-		var replyToID sql.NullString
-		rows.Scan(&m.ID, &m.ChannelID, &m.UserID, &m.Content, &replyToID, &editedAt, &m.CreatedAt)
+		var replyToID, embeds, richEmbeds sql.NullString
+		var authorName string
+		rows.Scan(&m.ID, &m.ChannelID, &m.UserID, &m.Type, &m.Content, &replyToID, &editedAt, &m.CreatedAt, &m.StickerID, &embeds, &m.SuppressEmbeds, &m.WebhookID, &authorName, &richEmbeds, &m.FederationID, &m.RemoteID)
 		if editedAt.Valid {
 			m.EditedAt = &editedAt.Time
 		}
+		m.setEmbeds(embeds)
+		if richEmbeds.Valid {
+			m.RichEmbeds = json.RawMessage(richEmbeds.String)
+		}
 		if replyToID.Valid {
 			m.ReplyToID = &replyToID.String
 			m.ReplyTo, _ = d.GetMessageRef(replyToID.String)
 		}
-		m.Author, _ = d.GetUserByID(m.UserID)
+		m.Author = d.messageAuthor(&m, authorName)
 		m.Attachments, _ = d.GetAttachments(m.ID)
 		m.Reactions, _ = d.GetReactions(m.ID)
+		if m.StickerID != "" {
+			m.Sticker, _ = d.GetStickerByID(m.StickerID)
+		}
 		msgs = append(msgs, m)
 	}
 	// Reverse so oldest first
@@ -725,6 +1505,35 @@ func (d *DB) EditMessage(id, content string) error {
 	return err
 }
 
+// MarkEmbedsPending notes that a message's link previews are being fetched.
+func (d *DB) MarkEmbedsPending(id string) error {
+	_, err := d.Exec(`UPDATE messages SET embeds = '' WHERE id = ?`, id)
+	return err
+}
+
+// SetMessageEmbeds stores the link previews fetched for a message, or clears
+// them if embeds is nil.  It reports false if the message has been deleted
+// or edited to something other than content in the meantime.
+func (d *DB) SetMessageEmbeds(id, content string, embeds json.RawMessage) (bool, error) {
+	var v interface{}
+	if embeds != nil {
+		v = string(embeds)
+	}
+	res, err := d.Exec(`UPDATE messages SET embeds = ? WHERE id = ? AND content = ?`, v, id, content)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// SetSuppressEmbeds sets whether a message's links are left without
+// previews.
+func (d *DB) SetSuppressEmbeds(id string, suppress bool) error {
+	_, err := d.Exec(`UPDATE messages SET suppress_embeds = ? WHERE id = ?`, suppress, id)
+	return err
+}
+
 func (d *DB) DeleteMessage(id string) error {
 	_, err := d.Exec(`DELETE FROM messages WHERE id = ?`, id)
 	return err
@@ -732,36 +1541,181 @@ func (d *DB) DeleteMessage(id string) error {
 
 // --- Attachments ---
 
-func (d *DB) CreateAttachment(messageID, filename, originalName, mimeType string, size int64) (*Attachment, error) {
-	id := NewID()
+// CreateAttachment records an upload described by a, filling in its ID.
+func (d *DB) CreateAttachment(a Attachment) (*Attachment, error) {
+	a.ID = NewID()
 	var msgID interface{}
-	if messageID != "" {
-		msgID = messageID
+	if a.MessageID != "" {
+		msgID = a.MessageID
+	}
+	var waveform string
+	if len(a.Waveform) > 0 {
+		b, _ := json.Marshal(a.Waveform)
+		waveform = string(b)
 	}
-	_, err := d.Exec(`INSERT INTO attachments (id, message_id, filename, original_name, mime_type, size) VALUES (?, ?, ?, ?, ?, ?)`,
-		id, msgID, filename, originalName, mimeType, size)
+	_, err := d.Exec(`INSERT INTO attachments (id, message_id, filename, original_name, mime_type, size, width, height, duration, poster, status, kind, waveform, preview) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.ID, msgID, a.Filename, a.OriginalName, a.MimeType, a.Size, a.Width, a.Height, a.Duration, a.Poster, a.Status, a.Kind, waveform, a.Preview)
 	if err != nil {
 		return nil, err
 	}
-	a := &Attachment{ID: id, MessageID: messageID, Filename: filename, OriginalName: originalName, MimeType: mimeType, Size: size}
-	return a, nil
+	return &a, nil
+}
+
+const attachmentColumns = `id, COALESCE(message_id, ''), filename, original_name, mime_type, size, COALESCE(width, 0), COALESCE(height, 0), COALESCE(duration, 0), COALESCE(poster, ''), COALESCE(status, ''), COALESCE(kind, ''), COALESCE(waveform, ''), COALESCE(preview, ''), created_at`
+
+func (d *DB) scanAttachment(row interface{ Scan(...interface{}) error }) (Attachment, error) {
+	var a Attachment
+	var waveform string
+	err := row.Scan(&a.ID, &a.MessageID, &a.Filename, &a.OriginalName, &a.MimeType, &a.Size, &a.Width, &a.Height, &a.Duration, &a.Poster, &a.Status, &a.Kind, &waveform, &a.Preview, &a.CreatedAt)
+	if waveform != "" {
+		json.Unmarshal([]byte(waveform), &a.Waveform)
+	}
+	d.FillAttachmentURLs(&a)
+	return a, err
+}
+
+// SetAttachmentURLs sets how attachments' URL fields are made, e.g. to sign
+// them.  By default they are plain /uploads/ paths.
+func (d *DB) SetAttachmentURLs(url func(filename string) string) {
+	d.attachmentURL = url
+}
+
+// FillAttachmentURLs sets a's URL and PosterURL from its filenames.  Evicted
+// files have none.
+func (d *DB) FillAttachmentURLs(a *Attachment) {
+	url := d.attachmentURL
+	if url == nil {
+		url = func(name string) string { return "/uploads/" + name }
+	}
+	a.URL, a.PosterURL = "", ""
+	if a.Status == "evicted" {
+		return
+	}
+	a.URL = url(a.Filename)
+	if a.Poster != "" {
+		a.PosterURL = url(a.Poster)
+	}
+}
+
+// IsAttachmentFile reports whether filename is an attachment or a video
+// poster, rather than an avatar, emoji or other server asset.  If the
+// database can't say, it is, so the file stays behind a signed URL.
+func (d *DB) IsAttachmentFile(filename string) bool {
+	var n int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM attachments WHERE filename = ? OR poster = ?`, filename, filename).Scan(&n); err != nil {
+		return true
+	}
+	return n > 0
 }
 
 func (d *DB) GetAttachments(messageID string) ([]Attachment, error) {
-	rows, err := d.Query(`SELECT id, message_id, filename, original_name, mime_type, size, created_at FROM attachments WHERE message_id = ?`, messageID)
+	rows, err := d.Query(`SELECT `+attachmentColumns+` FROM attachments WHERE message_id = ?`, messageID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var atts []Attachment
 	for rows.Next() {
-		var a Attachment
-		rows.Scan(&a.ID, &a.MessageID, &a.Filename, &a.OriginalName, &a.MimeType, &a.Size, &a.CreatedAt)
+		a, _ := d.scanAttachment(rows)
 		atts = append(atts, a)
 	}
 	return atts, nil
 }
 
+func (d *DB) GetAttachment(id string) (*Attachment, error) {
+	a, err := d.scanAttachment(d.QueryRow(`SELECT `+attachmentColumns+` FROM attachments WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// ClaimAttachment moves an attachment from one transcoding status to
+// another, reporting whether it was still in the expected one.  Workers use
+// it so each job is only picked up once.
+func (d *DB) ClaimAttachment(id, from, to string) (bool, error) {
+	res, err := d.Exec(`UPDATE attachments SET status = ? WHERE id = ? AND status = ?`, to, id, from)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// AttachmentBytes is the total size of stored attachments.
+func (d *DB) AttachmentBytes() (int64, error) {
+	var n int64
+	err := d.QueryRow(`SELECT COALESCE(SUM(size), 0) FROM attachments WHERE COALESCE(status, '') != 'evicted'`).Scan(&n)
+	return n, err
+}
+
+// EvictionCandidates returns up to limit sent attachments that could be
+// evicted to free space, oldest first or, with largest, biggest first.
+// Files still being transcoded are left alone.
+func (d *DB) EvictionCandidates(largest bool, limit int) ([]Attachment, error) {
+	order := "created_at ASC"
+	if largest {
+		order = "size DESC"
+	}
+	rows, err := d.Query(`SELECT `+attachmentColumns+` FROM attachments
+		WHERE message_id IS NOT NULL AND COALESCE(status, '') NOT IN ('queued', 'processing', 'evicted')
+		ORDER BY `+order+` LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var atts []Attachment
+	for rows.Next() {
+		if a, err := d.scanAttachment(rows); err == nil {
+			atts = append(atts, a)
+		}
+	}
+	return atts, nil
+}
+
+// EvictAttachment marks an attachment's file as deleted to free space; the
+// record stays so messages can say what was there.  It reports false if
+// the attachment has gone or started transcoding meanwhile.
+func (d *DB) EvictAttachment(id string) (bool, error) {
+	res, err := d.Exec(`UPDATE attachments SET status = 'evicted'
+		WHERE id = ? AND COALESCE(status, '') NOT IN ('queued', 'processing', 'evicted')`, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ReplaceAttachmentFile points an attachment at a transcoded copy of its
+// file.  It reports false if the attachment has gone or no longer refers to
+// oldFilename, in which case the new file is not referenced.
+func (d *DB) ReplaceAttachmentFile(oldFilename string, a Attachment) (bool, error) {
+	res, err := d.Exec(`UPDATE attachments SET filename = ?, mime_type = ?, size = ?, width = ?, height = ?, duration = ?, poster = ?, status = ? WHERE id = ? AND filename = ?`,
+		a.Filename, a.MimeType, a.Size, a.Width, a.Height, a.Duration, a.Poster, a.Status, a.ID, oldFilename)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// AttachmentIDsWithStatus lists attachments in a transcoding status, oldest
+// first.
+func (d *DB) AttachmentIDsWithStatus(status string) ([]string, error) {
+	rows, err := d.Query(`SELECT id FROM attachments WHERE status = ? ORDER BY created_at`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
 func (d *DB) LinkAttachment(attachmentID, messageID string) error {
 	_, err := d.Exec(`UPDATE attachments SET message_id = ? WHERE id = ?`, messageID, attachmentID)
 	return err
@@ -810,18 +1764,18 @@ func (d *DB) GetReactions(messageID string) ([]Reaction, error) {
 
 // --- Invites ---
 
-func (d *DB) CreateInvite(createdBy string, maxUses int, expiresAt *time.Time) (*Invite, error) {
+func (d *DB) CreateInvite(guildID, createdBy string, maxUses int, expiresAt *time.Time) (*Invite, error) {
 	// Fix #10: Use full 16-char hex code (64-bit entropy) instead of 8-char (32-bit).
 	code := NewID()
 	if expiresAt != nil {
-		_, err := d.Exec(`INSERT INTO invites (code, created_by, max_uses, expires_at) VALUES (?, ?, ?, ?)`,
-			code, createdBy, maxUses, expiresAt)
+		_, err := d.Exec(`INSERT INTO invites (code, guild_id, created_by, max_uses, expires_at) VALUES (?, ?, ?, ?, ?)`,
+			code, guildID, createdBy, maxUses, expiresAt)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		_, err := d.Exec(`INSERT INTO invites (code, created_by, max_uses) VALUES (?, ?, ?)`,
-			code, createdBy, maxUses)
+		_, err := d.Exec(`INSERT INTO invites (code, guild_id, created_by, max_uses) VALUES (?, ?, ?, ?)`,
+			code, guildID, createdBy, maxUses)
 		if err != nil {
 			return nil, err
 		}
@@ -832,8 +1786,10 @@ func (d *DB) CreateInvite(createdBy string, maxUses int, expiresAt *time.Time) (
 func (d *DB) GetInviteByCode(code string) (*Invite, error) {
 	inv := &Invite{}
 	var expires sql.NullTime
-	err := d.QueryRow(`SELECT code, created_by, uses, max_uses, expires_at, created_at FROM invites WHERE code = ?`, code).
-		Scan(&inv.Code, &inv.CreatedBy, &inv.Uses, &inv.MaxUses, &expires, &inv.CreatedAt)
+	err := d.QueryRow(`SELECT code, COALESCE(guild_id,'default'), created_by, uses, max_uses, expires_at, created_at,
+		(SELECT COUNT(*) FROM invite_joins j WHERE j.code = invites.code)
+		FROM invites WHERE code = ?`, code).
+		Scan(&inv.Code, &inv.GuildID, &inv.CreatedBy, &inv.Uses, &inv.MaxUses, &expires, &inv.CreatedAt, &inv.Joins)
 	if err != nil {
 		return nil, err
 	}
@@ -844,8 +1800,10 @@ func (d *DB) GetInviteByCode(code string) (*Invite, error) {
 	return inv, nil
 }
 
-func (d *DB) ListInvites() ([]Invite, error) {
-	rows, err := d.Query(`SELECT code, created_by, uses, max_uses, expires_at, created_at FROM invites ORDER BY created_at DESC`)
+func (d *DB) ListInvites(guildID string) ([]Invite, error) {
+	rows, err := d.Query(`SELECT code, COALESCE(guild_id,'default'), created_by, uses, max_uses, expires_at, created_at,
+		(SELECT COUNT(*) FROM invite_joins j WHERE j.code = invites.code)
+		FROM invites WHERE COALESCE(guild_id,'default') = ? ORDER BY created_at DESC`, guildID)
 	if err != nil {
 		return nil, err
 	}
@@ -854,7 +1812,7 @@ func (d *DB) ListInvites() ([]Invite, error) {
 	for rows.Next() {
 		var inv Invite
 		var expires sql.NullTime
-		rows.Scan(&inv.Code, &inv.CreatedBy, &inv.Uses, &inv.MaxUses, &expires, &inv.CreatedAt)
+		rows.Scan(&inv.Code, &inv.GuildID, &inv.CreatedBy, &inv.Uses, &inv.MaxUses, &expires, &inv.CreatedAt, &inv.Joins)
 		if expires.Valid {
 			inv.ExpiresAt = &expires.Time
 		}
@@ -883,13 +1841,16 @@ func (d *DB) IsInviteValid(inv *Invite) bool {
 
 func (d *DB) DeleteInvite(code string) error {
 	_, err := d.Exec(`DELETE FROM invites WHERE code = ?`, code)
+	if err == nil {
+		d.Exec(`DELETE FROM invite_joins WHERE code = ?`, code)
+	}
 	return err
 }
 
-// CleanOrphanedAttachments deletes attachment records (and their files on disk)
-// that were never linked to a message and are older than maxAge.
+// CleanOrphanedAttachments deletes attachment records that were never linked
+// to a message and are older than maxAge, calling remove to delete each file.
 // Fix #9: prevents unbounded disk growth from abandoned uploads.
-func (d *DB) CleanOrphanedAttachments(uploadsDir string, maxAge time.Duration) error {
+func (d *DB) CleanOrphanedAttachments(maxAge time.Duration, remove func(filename string)) error {
 	cutoff := time.Now().Add(-maxAge)
 	rows, err := d.Query(
 		`SELECT id, filename FROM attachments WHERE message_id IS NULL AND created_at < ?`, cutoff)
@@ -909,7 +1870,7 @@ func (d *DB) CleanOrphanedAttachments(uploadsDir string, maxAge time.Duration) e
 
 	for _, o := range orphans {
 		d.Exec(`DELETE FROM attachments WHERE id = ?`, o.id)
-		os.Remove(uploadsDir + "/" + o.filename)
+		remove(o.filename)
 	}
 	return nil
 }
@@ -917,18 +1878,28 @@ func (d *DB) CleanOrphanedAttachments(uploadsDir string, maxAge time.Duration) e
 // --- Custom Emojis ---
 
 type CustomEmoji struct {
-	ID         string    `json:"id"`
-	Name       string    `json:"name"`
-	Filename   string    `json:"filename"`
-	UploaderID string    `json:"uploader_id"`
-	Uploader   *User     `json:"uploader,omitempty"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	Filename       string    `json:"filename"`
+	Animated       bool      `json:"animated"`
+	StaticFilename string    `json:"static_filename,omitempty"` // first frame of an animated emoji
+	UploaderID     string    `json:"uploader_id"`
+	Uploader       *User     `json:"uploader,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+const customEmojiColumns = `id, name, filename, COALESCE(animated, 0), COALESCE(static_filename, ''), uploader_id, created_at`
+
+func scanCustomEmoji(row interface{ Scan(...interface{}) error }) (CustomEmoji, error) {
+	var e CustomEmoji
+	err := row.Scan(&e.ID, &e.Name, &e.Filename, &e.Animated, &e.StaticFilename, &e.UploaderID, &e.CreatedAt)
+	return e, err
 }
 
-func (d *DB) CreateCustomEmoji(name, filename, uploaderID string) (*CustomEmoji, error) {
+func (d *DB) CreateCustomEmoji(e CustomEmoji) (*CustomEmoji, error) {
 	id := NewID()
-	_, err := d.Exec(`INSERT INTO custom_emojis (id, name, filename, uploader_id) VALUES (?, ?, ?, ?)`,
-		id, name, filename, uploaderID)
+	_, err := d.Exec(`INSERT INTO custom_emojis (id, name, filename, animated, static_filename, uploader_id) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, e.Name, e.Filename, e.Animated, e.StaticFilename, e.UploaderID)
 	if err != nil {
 		return nil, err
 	}
@@ -936,26 +1907,23 @@ func (d *DB) CreateCustomEmoji(name, filename, uploaderID string) (*CustomEmoji,
 }
 
 func (d *DB) GetCustomEmojiByID(id string) (*CustomEmoji, error) {
-	e := &CustomEmoji{}
-	err := d.QueryRow(`SELECT id, name, filename, uploader_id, created_at FROM custom_emojis WHERE id = ?`, id).
-		Scan(&e.ID, &e.Name, &e.Filename, &e.UploaderID, &e.CreatedAt)
+	e, err := scanCustomEmoji(d.QueryRow(`SELECT `+customEmojiColumns+` FROM custom_emojis WHERE id = ?`, id))
 	if err != nil {
 		return nil, err
 	}
 	e.Uploader, _ = d.GetUserByID(e.UploaderID)
-	return e, nil
+	return &e, nil
 }
 
 func (d *DB) ListCustomEmojis() ([]CustomEmoji, error) {
-	rows, err := d.Query(`SELECT id, name, filename, uploader_id, created_at FROM custom_emojis ORDER BY name ASC`)
+	rows, err := d.Query(`SELECT ` + customEmojiColumns + ` FROM custom_emojis ORDER BY name ASC`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var emojis []CustomEmoji
 	for rows.Next() {
-		var e CustomEmoji
-		rows.Scan(&e.ID, &e.Name, &e.Filename, &e.UploaderID, &e.CreatedAt)
+		e, _ := scanCustomEmoji(rows)
 		e.Uploader, _ = d.GetUserByID(e.UploaderID)
 		emojis = append(emojis, e)
 	}
@@ -965,36 +1933,171 @@ func (d *DB) ListCustomEmojis() ([]CustomEmoji, error) {
 	return emojis, nil
 }
 
-func (d *DB) DeleteCustomEmoji(id string) (string, error) {
-	var filename string
-	err := d.QueryRow(`SELECT filename FROM custom_emojis WHERE id = ?`, id).Scan(&filename)
+// DeleteCustomEmoji removes an emoji, returning it so its files can be
+// deleted too.
+func (d *DB) DeleteCustomEmoji(id string) (*CustomEmoji, error) {
+	e, err := scanCustomEmoji(d.QueryRow(`SELECT `+customEmojiColumns+` FROM custom_emojis WHERE id = ?`, id))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	_, err = d.Exec(`DELETE FROM custom_emojis WHERE id = ?`, id)
-	return filename, err
+	return &e, err
 }
 
 func (d *DB) GetCustomEmojiByName(name string) (*CustomEmoji, error) {
-	e := &CustomEmoji{}
-	err := d.QueryRow(`SELECT id, name, filename, uploader_id, created_at FROM custom_emojis WHERE name = ?`, name).
-		Scan(&e.ID, &e.Name, &e.Filename, &e.UploaderID, &e.CreatedAt)
+	e, err := scanCustomEmoji(d.QueryRow(`SELECT `+customEmojiColumns+` FROM custom_emojis WHERE name = ?`, name))
 	if err != nil {
 		return nil, err
 	}
-	return e, nil
+	return &e, nil
 }
 
-// ─── Push Subscriptions ───────────────────────────────────────────────────────
+// --- Stickers ---
 
-type PushSubscription struct {
-	ID       string
-	UserID   string
-	Endpoint string
-	Data     string
+type Sticker struct {
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description,omitempty"`
+	Filename       string    `json:"filename"`
+	Animated       bool      `json:"animated"`
+	StaticFilename string    `json:"static_filename,omitempty"` // first frame of an animated sticker
+	UploaderID     string    `json:"uploader_id"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+const stickerColumns = `id, name, description, filename, animated, static_filename, uploader_id, created_at`
+
+func scanSticker(row interface{ Scan(...interface{}) error }) (Sticker, error) {
+	var s Sticker
+	err := row.Scan(&s.ID, &s.Name, &s.Description, &s.Filename, &s.Animated, &s.StaticFilename, &s.UploaderID, &s.CreatedAt)
+	return s, err
+}
+
+func (d *DB) CreateSticker(s Sticker) (*Sticker, error) {
+	id := NewID()
+	_, err := d.Exec(`INSERT INTO stickers (id, name, description, filename, animated, static_filename, uploader_id) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, s.Name, s.Description, s.Filename, s.Animated, s.StaticFilename, s.UploaderID)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetStickerByID(id)
+}
+
+func (d *DB) GetStickerByID(id string) (*Sticker, error) {
+	s, err := scanSticker(d.QueryRow(`SELECT `+stickerColumns+` FROM stickers WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (d *DB) ListStickers() ([]Sticker, error) {
+	rows, err := d.Query(`SELECT ` + stickerColumns + ` FROM stickers ORDER BY name ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stickers := []Sticker{}
+	for rows.Next() {
+		if s, err := scanSticker(rows); err == nil {
+			stickers = append(stickers, s)
+		}
+	}
+	return stickers, nil
 }
 
-func (d *DB) SavePushSubscription(userID, data string) error {
+// DeleteSticker removes a sticker, returning it so its files can be
+// deleted too.  Messages that used it keep its ID but show nothing.
+func (d *DB) DeleteSticker(id string) (*Sticker, error) {
+	s, err := d.GetStickerByID(id)
+	if err != nil {
+		return nil, err
+	}
+	_, err = d.Exec(`DELETE FROM stickers WHERE id = ?`, id)
+	return s, err
+}
+
+// SetMessageSticker attaches a sticker to a message.
+func (d *DB) SetMessageSticker(messageID, stickerID string) error {
+	_, err := d.Exec(`UPDATE messages SET sticker_id = ? WHERE id = ?`, stickerID, messageID)
+	return err
+}
+
+// --- Soundboard ---
+
+type Sound struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Filename   string    `json:"filename"`
+	UploaderID string    `json:"uploader_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (d *DB) CreateSound(name, filename, uploaderID string) (*Sound, error) {
+	id := NewID()
+	_, err := d.Exec(`INSERT INTO sounds (id, name, filename, uploader_id) VALUES (?, ?, ?, ?)`,
+		id, name, filename, uploaderID)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetSoundByID(id)
+}
+
+func (d *DB) GetSoundByID(id string) (*Sound, error) {
+	s := &Sound{}
+	err := d.QueryRow(`SELECT id, name, filename, uploader_id, created_at FROM sounds WHERE id = ?`, id).
+		Scan(&s.ID, &s.Name, &s.Filename, &s.UploaderID, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (d *DB) ListSounds() ([]Sound, error) {
+	rows, err := d.Query(`SELECT id, name, filename, uploader_id, created_at FROM sounds ORDER BY name ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sounds := []Sound{}
+	for rows.Next() {
+		var s Sound
+		rows.Scan(&s.ID, &s.Name, &s.Filename, &s.UploaderID, &s.CreatedAt)
+		sounds = append(sounds, s)
+	}
+	return sounds, nil
+}
+
+func (d *DB) DeleteSound(id string) (string, error) {
+	var filename string
+	err := d.QueryRow(`SELECT filename FROM sounds WHERE id = ?`, id).Scan(&filename)
+	if err != nil {
+		return "", err
+	}
+	_, err = d.Exec(`DELETE FROM sounds WHERE id = ?`, id)
+	return filename, err
+}
+
+// ─── Push Subscriptions ───────────────────────────────────────────────────────
+
+// PushSubscription is one device's registration for push.  Kind names the
+// transport that delivers to it ("webpush", "fcm" or "apns"); Data is the
+// transport's own JSON (for Web Push, the browser's PushSubscription).
+// Level, when set ("mentions" or "none"), narrows the user's notification
+// settings on this device only.
+type PushSubscription struct {
+	ID        string
+	UserID    string
+	Kind      string
+	Endpoint  string
+	Data      string
+	Level     string
+	CreatedAt time.Time
+}
+
+// SavePushSubscription stores a subscription for userID.  A nil level keeps
+// the device's current level when it re-subscribes.
+func (d *DB) SavePushSubscription(userID, kind, data string, level *string) error {
 	// Parse endpoint from data JSON to use as dedup key
 	var sub struct {
 		Endpoint string `json:"endpoint"`
@@ -1006,12 +2109,19 @@ func (d *DB) SavePushSubscription(userID, data string) error {
 	// This prevents stale entries from account-switching on the same device:
 	// if user A subscribed then logged out without unsubscribing, user B logging
 	// in on the same browser would otherwise leave A's entry pointing at B's device.
+	var lvl string
+	if level != nil {
+		lvl = *level
+	} else {
+		d.QueryRow(`SELECT COALESCE(level, '') FROM push_subscriptions WHERE user_id=? AND endpoint=?`,
+			userID, sub.Endpoint).Scan(&lvl)
+	}
 	_, _ = d.Exec(`DELETE FROM push_subscriptions WHERE endpoint=?`, sub.Endpoint)
 	id := NewID()
 	_, err := d.Exec(`
-		INSERT INTO push_subscriptions (id, user_id, endpoint, data)
-		VALUES (?, ?, ?, ?)`,
-		id, userID, sub.Endpoint, data)
+		INSERT INTO push_subscriptions (id, user_id, kind, endpoint, data, level)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		id, userID, kind, sub.Endpoint, data, lvl)
 	return err
 }
 
@@ -1020,11 +2130,28 @@ func (d *DB) DeletePushSubscription(userID, endpoint string) error {
 	return err
 }
 
+// GetUserPushSubscriptions returns every push subscription belonging to userID.
+func (d *DB) GetUserPushSubscriptions(userID string) ([]PushSubscription, error) {
+	rows, err := d.Query(`SELECT id, user_id, COALESCE(kind, 'webpush'), endpoint, data, COALESCE(level, ''), created_at FROM push_subscriptions WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var subs []PushSubscription
+	for rows.Next() {
+		var s PushSubscription
+		if err := rows.Scan(&s.ID, &s.UserID, &s.Kind, &s.Endpoint, &s.Data, &s.Level, &s.CreatedAt); err == nil {
+			subs = append(subs, s)
+		}
+	}
+	return subs, rows.Err()
+}
+
 // GetChannelPushSubscriptions returns all push subscriptions for users who are
 // NOT the specified channel (all users get pushes — channel-level mute is
 // enforced client-side). The channelName param is unused here but kept for future filtering.
 func (d *DB) GetChannelPushSubscriptions(_ string) ([]PushSubscription, error) {
-	rows, err := d.Query(`SELECT id, user_id, endpoint, data FROM push_subscriptions`)
+	rows, err := d.Query(`SELECT id, user_id, COALESCE(kind, 'webpush'), endpoint, data, COALESCE(level, ''), created_at FROM push_subscriptions`)
 	if err != nil {
 		return nil, err
 	}
@@ -1032,7 +2159,7 @@ func (d *DB) GetChannelPushSubscriptions(_ string) ([]PushSubscription, error) {
 	var subs []PushSubscription
 	for rows.Next() {
 		var s PushSubscription
-		if err := rows.Scan(&s.ID, &s.UserID, &s.Endpoint, &s.Data); err == nil {
+		if err := rows.Scan(&s.ID, &s.UserID, &s.Kind, &s.Endpoint, &s.Data, &s.Level, &s.CreatedAt); err == nil {
 			subs = append(subs, s)
 		}
 	}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ─── Digest emails ────────────────────────────────────────────────────────────
+//
+// Users may ask for a daily or weekly email summarising unread activity.
+// digest_sent_at in notification_settings records when the last one went
+// out; each digest covers unread messages posted since then.
+
+const (
+	DigestOff    = ""
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// ValidDigest reports whether freq is one of the Digest* values.
+func ValidDigest(freq string) bool {
+	return freq == DigestOff || freq == DigestDaily || freq == DigestWeekly
+}
+
+// DigestPeriod is how long one digest covers.
+func DigestPeriod(freq string) time.Duration {
+	if freq == DigestWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// DigestSubscriber is a user who wants digests.
+type DigestSubscriber struct {
+	UserID string
+	Freq   string
+	SentAt time.Time // zero if they've never had one
+}
+
+// DigestSubscribers lists every user with digests turned on.
+func (d *DB) DigestSubscribers() ([]DigestSubscriber, error) {
+	rows, err := d.Query(`SELECT user_id, digest, digest_sent_at FROM notification_settings WHERE digest != ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var subs []DigestSubscriber
+	for rows.Next() {
+		var s DigestSubscriber
+		var sent sql.NullTime
+		if rows.Scan(&s.UserID, &s.Freq, &sent) == nil {
+			s.SentAt = sent.Time
+			subs = append(subs, s)
+		}
+	}
+	return subs, rows.Err()
+}
+
+// ClaimDigest records that userID's digest is being sent now, provided the
+// last one went out before due.  It returns false if another instance got
+// there first, so in cluster mode each digest is sent once.
+func (d *DB) ClaimDigest(userID string, due, now time.Time) bool {
+	res, err := d.Exec(`UPDATE notification_settings SET digest_sent_at = ?
+		WHERE user_id = ? AND (digest_sent_at IS NULL OR digest_sent_at <= ?)`,
+		now.UTC(), userID, due.UTC())
+	if err != nil {
+		return false
+	}
+	n, _ := res.RowsAffected()
+	return n == 1
+}
+
+// DigestMessage is an unread message with its reaction count, for ranking.
+type DigestMessage struct {
+	UnreadMessage
+	Reactions int
+}
+
+// UnreadSince returns up to limit of userID's unread messages in channelID
+// posted after since, newest first.
+func (d *DB) UnreadSince(userID, channelID string, since time.Time, limit int) ([]DigestMessage, error) {
+	rows, err := d.Query(`SELECT m.id, m.channel_id, COALESCE(u.username, ''), m.content, m.created_at,
+			(SELECT COUNT(*) FROM reactions x WHERE x.message_id = m.id)
+		FROM messages m
+		LEFT JOIN users u ON u.id = m.user_id
+		LEFT JOIN channel_reads r ON r.channel_id = m.channel_id AND r.user_id = ?
+		WHERE m.channel_id = ? AND m.type = '' AND m.created_at > ?
+			AND m.created_at > COALESCE(r.last_read_at, (SELECT created_at FROM users WHERE id = ?))
+			AND COALESCE(m.user_id, '') != ?
+		ORDER BY m.created_at DESC LIMIT ?`, userID, channelID, since.UTC(), userID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var msgs []DigestMessage
+	for rows.Next() {
+		var m DigestMessage
+		if rows.Scan(&m.ID, &m.ChannelID, &m.Author, &m.Content, &m.CreatedAt, &m.Reactions) == nil {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs, rows.Err()
+}
@@ -0,0 +1,353 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ─── Server events ───────────────────────────────────────────────────────────
+//
+// An event is something a guild has planned, like game night on Fridays at
+// 8pm: a title, what it's about, the channel it happens in and when.  A
+// repeating event is one row, and its occurrences are worked out from its
+// first start, in its timezone, so "8pm" stays 8pm across daylight saving.
+// Members say whether they're going; those who are, or who might, are
+// reminded before each occurrence, and reminded_for records the occurrence
+// they were last reminded of, so no one is reminded twice.
+
+// How an event repeats.
+const (
+	RecurNone     = ""
+	RecurDaily    = "daily"
+	RecurWeekly   = "weekly"
+	RecurBiweekly = "biweekly"
+	RecurMonthly  = "monthly"
+)
+
+// What members say to an event.
+const (
+	RSVPGoing      = "going"
+	RSVPInterested = "interested"
+	RSVPNotGoing   = "not_going"
+)
+
+// Event is a scheduled server event.
+type Event struct {
+	ID              string    `json:"id"`
+	GuildID         string    `json:"guild_id"`
+	ChannelID       string    `json:"channel_id,omitempty"`
+	Title           string    `json:"title"`
+	Description     string    `json:"description"`
+	StartsAt        time.Time `json:"starts_at"`  // the first occurrence
+	Duration        int       `json:"duration"`   // minutes; 0 for no set end
+	Recurrence      string    `json:"recurrence"` // "", daily, weekly, biweekly or monthly
+	Timezone        string    `json:"timezone"`   // IANA name, which repeats keep the time of day in
+	ReminderMinutes int       `json:"reminder_minutes"`
+	CreatedBy       string    `json:"created_by"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	Going           int       `json:"going"`
+	Interested      int       `json:"interested"`
+
+	RemindedFor *time.Time `json:"-"`
+	// NextStart is the occurrence that's on now or next, if there is one.
+	NextStart *time.Time `json:"next_start,omitempty"`
+	// RSVP is what the member reading it said, if anything.
+	RSVP string `json:"rsvp,omitempty"`
+}
+
+const eventColumns = `e.id, e.guild_id, e.channel_id, e.title, e.description, e.starts_at, e.duration, e.recurrence, e.timezone,
+	e.reminder_minutes, e.reminded_for, e.created_by, e.created_at, e.updated_at,
+	(SELECT COUNT(*) FROM event_rsvps r WHERE r.event_id = e.id AND r.status = 'going'),
+	(SELECT COUNT(*) FROM event_rsvps r WHERE r.event_id = e.id AND r.status = 'interested')`
+
+func scanEvent(row interface{ Scan(...interface{}) error }) (Event, error) {
+	var e Event
+	var reminded sql.NullTime
+	err := row.Scan(&e.ID, &e.GuildID, &e.ChannelID, &e.Title, &e.Description, &e.StartsAt, &e.Duration, &e.Recurrence, &e.Timezone,
+		&e.ReminderMinutes, &reminded, &e.CreatedBy, &e.CreatedAt, &e.UpdatedAt, &e.Going, &e.Interested)
+	if reminded.Valid {
+		e.RemindedFor = &reminded.Time
+	}
+	return e, err
+}
+
+func (d *DB) queryEvents(where string, args ...interface{}) ([]Event, error) {
+	rows, err := d.Query(`SELECT `+eventColumns+` FROM server_events e `+where+` ORDER BY e.starts_at ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []Event{}
+	for rows.Next() {
+		if e, err := scanEvent(rows); err == nil {
+			events = append(events, e)
+		}
+	}
+	return events, rows.Err()
+}
+
+// CreateEvent schedules e, returning it as stored.
+func (d *DB) CreateEvent(e Event) (*Event, error) {
+	id := NewID()
+	_, err := d.Exec(`INSERT INTO server_events (id, guild_id, channel_id, title, description, starts_at, duration, recurrence, timezone, reminder_minutes, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, e.GuildID, e.ChannelID, e.Title, e.Description, e.StartsAt.UTC(), e.Duration, e.Recurrence, e.Timezone, e.ReminderMinutes, e.CreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetEvent(id)
+}
+
+func (d *DB) GetEvent(id string) (*Event, error) {
+	e, err := scanEvent(d.QueryRow(`SELECT `+eventColumns+` FROM server_events e WHERE e.id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// UpdateEvent saves e's details.  Its guild and creator stay as they were.
+func (d *DB) UpdateEvent(e Event) (*Event, error) {
+	_, err := d.Exec(`UPDATE server_events SET channel_id = ?, title = ?, description = ?, starts_at = ?, duration = ?, recurrence = ?,
+		timezone = ?, reminder_minutes = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		e.ChannelID, e.Title, e.Description, e.StartsAt.UTC(), e.Duration, e.Recurrence, e.Timezone, e.ReminderMinutes, e.ID)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetEvent(e.ID)
+}
+
+// DeleteEvent cancels an event, and forgets who was coming.
+func (d *DB) DeleteEvent(id string) error {
+	_, err := d.Exec(`DELETE FROM server_events WHERE id = ?`, id)
+	if err == nil {
+		d.Exec(`DELETE FROM event_rsvps WHERE event_id = ?`, id)
+	}
+	return err
+}
+
+// ListEvents returns guildID's events, by their first start.
+func (d *DB) ListEvents(guildID string) ([]Event, error) {
+	return d.queryEvents(`WHERE e.guild_id = ?`, guildID)
+}
+
+// EventsWithReminders returns every event that reminds people.
+func (d *DB) EventsWithReminders() ([]Event, error) {
+	return d.queryEvents(`WHERE e.reminder_minutes > 0`)
+}
+
+// SetEventReminded records that people were reminded of the occurrence of
+// event id starting at start.
+func (d *DB) SetEventReminded(id string, start time.Time) error {
+	_, err := d.Exec(`UPDATE server_events SET reminded_for = ? WHERE id = ?`, start.UTC(), id)
+	return err
+}
+
+// location is the event's timezone, UTC if it doesn't know it.
+func (e *Event) location() *time.Location {
+	if loc, err := time.LoadLocation(e.Timezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// occurrence returns the start of the event's nth occurrence, counting from
+// 0, with start its first in its timezone.
+func (e *Event) occurrence(start time.Time, n int) time.Time {
+	switch e.Recurrence {
+	case RecurDaily:
+		return start.AddDate(0, 0, n)
+	case RecurWeekly:
+		return start.AddDate(0, 0, 7*n)
+	case RecurBiweekly:
+		return start.AddDate(0, 0, 14*n)
+	case RecurMonthly:
+		return start.AddDate(0, n, 0)
+	}
+	return start
+}
+
+// NextOccurrence returns the start of the first occurrence that hasn't
+// ended by now: the one on now, or the next.  A one-off event that's over
+// has none.
+func (e *Event) NextOccurrence(now time.Time) (time.Time, bool) {
+	length := time.Duration(e.Duration) * time.Minute
+	start := e.StartsAt.In(e.location())
+	if e.Recurrence == RecurNone {
+		return start, !start.Add(length).Before(now)
+	}
+	// Jump close to now, a little short for daylight saving and months'
+	// lengths, and step from there.
+	n := 0
+	if elapsed := now.Sub(start.Add(length)); elapsed > 0 {
+		days := int(elapsed.Hours() / 24)
+		switch e.Recurrence {
+		case RecurDaily:
+			n = days - 1
+		case RecurWeekly:
+			n = days/7 - 1
+		case RecurBiweekly:
+			n = days/14 - 1
+		case RecurMonthly:
+			n = days/31 - 1
+		}
+		if n < 0 {
+			n = 0
+		}
+	}
+	for {
+		t := e.occurrence(start, n)
+		if !t.Add(length).Before(now) {
+			return t, true
+		}
+		n++
+	}
+}
+
+// ─── RSVPs ───────────────────────────────────────────────────────────────────
+
+// EventRSVP is what a member said to an event.
+type EventRSVP struct {
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	Avatar    string    `json:"avatar,omitempty"`
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SetEventRSVP records userID's answer to event eventID; "" takes it back.
+func (d *DB) SetEventRSVP(eventID, userID, status string) error {
+	if status == "" {
+		_, err := d.Exec(`DELETE FROM event_rsvps WHERE event_id = ? AND user_id = ?`, eventID, userID)
+		return err
+	}
+	_, err := d.Exec(`INSERT INTO event_rsvps (event_id, user_id, status) VALUES (?, ?, ?)
+		ON CONFLICT (event_id, user_id) DO UPDATE SET status = excluded.status, updated_at = CURRENT_TIMESTAMP`,
+		eventID, userID, status)
+	return err
+}
+
+// EventRSVPs returns the answers to event eventID from members still here,
+// earliest first.
+func (d *DB) EventRSVPs(eventID string) ([]EventRSVP, error) {
+	rows, err := d.Query(`SELECT r.user_id, u.username, COALESCE(u.avatar, ''), r.status, r.updated_at
+		FROM event_rsvps r JOIN users u ON u.id = r.user_id
+		WHERE r.event_id = ? ORDER BY r.updated_at ASC`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rsvps := []EventRSVP{}
+	for rows.Next() {
+		var r EventRSVP
+		if rows.Scan(&r.UserID, &r.Username, &r.Avatar, &r.Status, &r.UpdatedAt) == nil {
+			rsvps = append(rsvps, r)
+		}
+	}
+	return rsvps, rows.Err()
+}
+
+// UserRSVPs returns userID's answers, by event.
+func (d *DB) UserRSVPs(userID string) (map[string]string, error) {
+	rows, err := d.Query(`SELECT event_id, status FROM event_rsvps WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rsvps := map[string]string{}
+	for rows.Next() {
+		var id, status string
+		if rows.Scan(&id, &status) == nil {
+			rsvps[id] = status
+		}
+	}
+	return rsvps, rows.Err()
+}
+
+// RSVPEvents returns the events userID is going to or interested
+// in, with their answer, by first start.
+func (d *DB) RSVPEvents(userID string) ([]Event, error) {
+	rows, err := d.Query(`SELECT `+eventColumns+`, r.status FROM server_events e
+		JOIN event_rsvps r ON r.event_id = e.id AND r.user_id = ?
+		WHERE r.status IN (?, ?) ORDER BY e.starts_at ASC`, userID, RSVPGoing, RSVPInterested)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []Event{}
+	for rows.Next() {
+		var e Event
+		var reminded sql.NullTime
+		if rows.Scan(&e.ID, &e.GuildID, &e.ChannelID, &e.Title, &e.Description, &e.StartsAt, &e.Duration, &e.Recurrence, &e.Timezone,
+			&e.ReminderMinutes, &reminded, &e.CreatedBy, &e.CreatedAt, &e.UpdatedAt, &e.Going, &e.Interested, &e.RSVP) == nil {
+			events = append(events, e)
+		}
+	}
+	return events, rows.Err()
+}
+
+// ─── Calendar feeds ──────────────────────────────────────────────────────────
+//
+// A member's calendar feed is the events they're going to or interested in,
+// as iCal, at a URL with a token in it, for Google or Apple Calendar to
+// subscribe to.  Calendars can't sign in, so the token is all there is: it's
+// "<user ID>.<secret>", only a hash of the secret is stored, and the URL is
+// shown once.  Making a new one stops the old one working.
+
+// CalendarFeed is a member's feed, as they see it later.
+type CalendarFeed struct {
+	CreatedAt time.Time  `json:"created_at"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+}
+
+// GetCalendarFeed returns userID's feed, if they have one.
+func (d *DB) GetCalendarFeed(userID string) (*CalendarFeed, error) {
+	var f CalendarFeed
+	var lastUsed sql.NullTime
+	if err := d.QueryRow(`SELECT created_at, last_used FROM calendar_feeds WHERE user_id = ?`, userID).
+		Scan(&f.CreatedAt, &lastUsed); err != nil {
+		return nil, err
+	}
+	if lastUsed.Valid {
+		f.LastUsed = &lastUsed.Time
+	}
+	return &f, nil
+}
+
+// ResetCalendarFeed gives userID a new feed in place of any they had,
+// returning its token.
+func (d *DB) ResetCalendarFeed(userID string) (string, error) {
+	token, hash := newBotToken(userID)
+	_, err := d.Exec(`INSERT INTO calendar_feeds (user_id, token_hash) VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET token_hash = excluded.token_hash, created_at = CURRENT_TIMESTAMP, last_used = NULL`,
+		userID, hash)
+	return token, err
+}
+
+// DeleteCalendarFeed turns userID's feed off.
+func (d *DB) DeleteCalendarFeed(userID string) error {
+	_, err := d.Exec(`DELETE FROM calendar_feeds WHERE user_id = ?`, userID)
+	return err
+}
+
+// UserByCalendarToken returns the user whose feed token is token, noting
+// that it was used.
+func (d *DB) UserByCalendarToken(token string) (*User, error) {
+	userID, secret, found := strings.Cut(token, ".")
+	if !found || userID == "" || secret == "" {
+		return nil, errors.New("malformed calendar token")
+	}
+	var n int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM calendar_feeds f JOIN users u ON u.id = f.user_id
+		WHERE f.user_id = ? AND f.token_hash = ?`, userID, hashBotSecret(secret)).Scan(&n); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errors.New("unknown calendar token")
+	}
+	d.Exec(`UPDATE calendar_feeds SET last_used = ? WHERE user_id = ?`, time.Now().UTC(), userID)
+	return d.GetUserByID(userID)
+}
@@ -0,0 +1,235 @@
+package db
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// ─── Federation ──────────────────────────────────────────────────────────────
+//
+// A federation link shares a channel with a channel on another Chirm
+// server: what's posted in either appears in both.  Admins on each side
+// make their half, naming the other server and its channel, and the other
+// server's public key is pinned then, so only it can post over the link.
+// Someone posting from the other server is a remote user: a name, avatar
+// and server, but no account here.  Their messages have the remote user
+// for user_id, and the link and their ID there, to find them by when
+// they're edited or deleted.  Events for the other server wait in the
+// outbox until it has taken them.
+
+// FederationLink is a channel's link to a channel on another server.
+type FederationLink struct {
+	ID              string    `json:"id"`
+	ChannelID       string    `json:"channel_id"`
+	Server          string    `json:"server"` // e.g. https://chat.example.com
+	RemoteChannelID string    `json:"remote_channel_id"`
+	PublicKey       string    `json:"public_key"` // the server's, base64
+	CreatedBy       string    `json:"created_by"`
+	CreatedAt       time.Time `json:"created_at"`
+	LastError       string    `json:"last_error,omitempty"` // of the last delivery, if it failed
+	Pending         int       `json:"pending"`              // events waiting to be delivered
+}
+
+const federationLinkColumns = `l.id, l.channel_id, l.server, l.remote_channel_id, l.public_key, l.created_by, l.created_at, l.last_error,
+	(SELECT COUNT(*) FROM federation_outbox o WHERE o.link_id = l.id)`
+
+func scanFederationLink(row interface{ Scan(...interface{}) error }) (FederationLink, error) {
+	var l FederationLink
+	err := row.Scan(&l.ID, &l.ChannelID, &l.Server, &l.RemoteChannelID, &l.PublicKey, &l.CreatedBy, &l.CreatedAt, &l.LastError, &l.Pending)
+	return l, err
+}
+
+func (d *DB) queryFederationLinks(where string, args ...interface{}) ([]FederationLink, error) {
+	rows, err := d.Query(`SELECT `+federationLinkColumns+` FROM federation_links l `+where+` ORDER BY l.created_at ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	links := []FederationLink{}
+	for rows.Next() {
+		if l, err := scanFederationLink(rows); err == nil {
+			links = append(links, l)
+		}
+	}
+	return links, rows.Err()
+}
+
+// CreateFederationLink links channelID to remoteChannelID on server, whose
+// public key is publicKey.
+func (d *DB) CreateFederationLink(channelID, server, remoteChannelID, publicKey, createdBy string) (*FederationLink, error) {
+	id := NewID()
+	if _, err := d.Exec(`INSERT INTO federation_links (id, channel_id, server, remote_channel_id, public_key, created_by) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, channelID, server, remoteChannelID, publicKey, createdBy); err != nil {
+		return nil, err
+	}
+	return d.GetFederationLink(id)
+}
+
+func (d *DB) GetFederationLink(id string) (*FederationLink, error) {
+	l, err := scanFederationLink(d.QueryRow(`SELECT `+federationLinkColumns+` FROM federation_links l WHERE l.id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// FederationLinkFor returns channelID's link to server, if it has one.
+func (d *DB) FederationLinkFor(channelID, server string) (*FederationLink, error) {
+	l, err := scanFederationLink(d.QueryRow(`SELECT `+federationLinkColumns+` FROM federation_links l WHERE l.channel_id = ? AND l.server = ?`,
+		channelID, server))
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// ListFederationLinks returns channelID's links, oldest first.
+func (d *DB) ListFederationLinks(channelID string) ([]FederationLink, error) {
+	return d.queryFederationLinks(`WHERE l.channel_id = ?`, channelID)
+}
+
+// DeleteFederationLink unlinks a channel, dropping the events waiting to go
+// over the link.  Messages that came over it stay.
+func (d *DB) DeleteFederationLink(id string) error {
+	_, err := d.Exec(`DELETE FROM federation_links WHERE id = ?`, id)
+	if err == nil {
+		d.Exec(`DELETE FROM federation_outbox WHERE link_id = ?`, id)
+	}
+	return err
+}
+
+// SetFederationLinkError records how the last delivery over a link went:
+// "" for fine.
+func (d *DB) SetFederationLinkError(id, msg string) error {
+	_, err := d.Exec(`UPDATE federation_links SET last_error = ? WHERE id = ?`, msg, id)
+	return err
+}
+
+// ─── Remote users ────────────────────────────────────────────────────────────
+
+// RemoteUser is someone who posts from another server.
+type RemoteUser struct {
+	ID       string
+	Server   string
+	RemoteID string // their user ID there
+	Username string // their username there
+	Avatar   string
+}
+
+// SaveRemoteUser returns the remote user remoteID on server, making them
+// the first time, and updating their name and avatar when they've changed.
+func (d *DB) SaveRemoteUser(server, remoteID, username, avatar string) (*RemoteUser, error) {
+	_, err := d.Exec(`INSERT INTO remote_users (id, server, remote_id, username, avatar) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (server, remote_id) DO UPDATE SET username = excluded.username, avatar = excluded.avatar, updated_at = CURRENT_TIMESTAMP
+		WHERE username != excluded.username OR avatar != excluded.avatar`,
+		NewID(), server, remoteID, username, avatar)
+	if err != nil {
+		return nil, err
+	}
+	u := &RemoteUser{Server: server, RemoteID: remoteID}
+	err = d.QueryRow(`SELECT id, username, avatar FROM remote_users WHERE server = ? AND remote_id = ?`, server, remoteID).
+		Scan(&u.ID, &u.Username, &u.Avatar)
+	return u, err
+}
+
+// remoteAuthor returns remote user id as the author of their messages, with
+// their server's host after their name, or nil if there's no such user.
+func (d *DB) remoteAuthor(id string) *User {
+	var server, username, avatar string
+	if d.QueryRow(`SELECT server, username, avatar FROM remote_users WHERE id = ?`, id).Scan(&server, &username, &avatar) != nil {
+		return nil
+	}
+	return &User{ID: id, Username: username + "@" + serverHost(server), Avatar: avatar}
+}
+
+// serverHost is server, a URL, without its scheme.
+func serverHost(server string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+}
+
+// ─── Federated messages ──────────────────────────────────────────────────────
+
+// CreateFederatedMessage stores a message that came over link l from
+// remote user author, whose ID there is remoteID.
+func (d *DB) CreateFederatedMessage(l *FederationLink, author *RemoteUser, remoteID, content string, replyToID *string, richEmbeds json.RawMessage) (*Message, error) {
+	id := NewID()
+	var embeds interface{}
+	if richEmbeds != nil {
+		embeds = string(richEmbeds)
+	}
+	_, err := d.Exec(`INSERT INTO messages (id, channel_id, user_id, content, reply_to_id, rich_embeds, federation_id, remote_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, l.ChannelID, author.ID, content, replyToID, embeds, l.ID, remoteID)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetMessageByID(id)
+}
+
+// FederatedMessage returns the message that came over link linkID with
+// remoteID as its ID on the other server.
+func (d *DB) FederatedMessage(linkID, remoteID string) (*Message, error) {
+	var id string
+	if err := d.QueryRow(`SELECT id FROM messages WHERE federation_id = ? AND remote_id = ?`, linkID, remoteID).Scan(&id); err != nil {
+		return nil, err
+	}
+	return d.GetMessageByID(id)
+}
+
+// ─── Outbox ──────────────────────────────────────────────────────────────────
+
+// FederationEvent is an event waiting to go over a link.
+type FederationEvent struct {
+	ID        string
+	LinkID    string
+	Event     []byte
+	Attempts  int
+	CreatedAt time.Time
+}
+
+// QueueFederationEvent puts event in the outbox for link linkID, due now.
+// Events already waiting for the link are due now too, so they still go
+// first.
+func (d *DB) QueueFederationEvent(linkID string, event []byte) error {
+	now := time.Now().UTC()
+	_, err := d.Exec(`INSERT INTO federation_outbox (id, link_id, event, next_attempt) VALUES (?, ?, ?, ?)`,
+		NewID(), linkID, string(event), now)
+	if err == nil {
+		d.Exec(`UPDATE federation_outbox SET next_attempt = ? WHERE link_id = ? AND next_attempt > ?`, now, linkID, now)
+	}
+	return err
+}
+
+// DueFederationEvents returns up to limit events due by now, oldest first.
+func (d *DB) DueFederationEvents(now time.Time, limit int) ([]FederationEvent, error) {
+	rows, err := d.Query(`SELECT id, link_id, event, attempts, created_at FROM federation_outbox
+		WHERE next_attempt <= ? ORDER BY created_at ASC LIMIT ?`, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []FederationEvent
+	for rows.Next() {
+		var e FederationEvent
+		var event string
+		if rows.Scan(&e.ID, &e.LinkID, &event, &e.Attempts, &e.CreatedAt) == nil {
+			e.Event = []byte(event)
+			events = append(events, e)
+		}
+	}
+	return events, rows.Err()
+}
+
+// DeleteFederationEvent takes a delivered, or abandoned, event out of the
+// outbox.
+func (d *DB) DeleteFederationEvent(id string) error {
+	_, err := d.Exec(`DELETE FROM federation_outbox WHERE id = ?`, id)
+	return err
+}
+
+// RetryFederationEvent counts a failed attempt at delivering event id and
+// makes it due again at next.
+func (d *DB) RetryFederationEvent(id string, next time.Time) error {
+	_, err := d.Exec(`UPDATE federation_outbox SET attempts = attempts + 1, next_attempt = ? WHERE id = ?`, next.UTC(), id)
+	return err
+}
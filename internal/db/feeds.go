@@ -0,0 +1,141 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// ─── Feeds ───────────────────────────────────────────────────────────────────
+//
+// Admins point a channel at an RSS or Atom feed, and new items are posted
+// into it as they appear.  Every item a feed has shown is remembered by its
+// GUID (or link), so an item is posted once however often it's polled, and
+// forgotten a while after it drops out of the feed.  Posts look like a
+// webhook's: no user, but the feed's ID and title.
+
+// Feed is a feed posted into a channel.
+type Feed struct {
+	ID           string     `json:"id"`
+	ChannelID    string     `json:"channel_id"`
+	URL          string     `json:"url"`
+	Title        string     `json:"title"`
+	CreatedBy    string     `json:"created_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastChecked  *time.Time `json:"last_checked,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	ETag         string     `json:"-"`
+	LastModified string     `json:"-"`
+}
+
+const feedColumns = `f.id, f.channel_id, f.url, f.title, f.created_by, f.created_at, f.last_checked, f.last_error, f.etag, f.last_modified`
+
+func scanFeed(row interface{ Scan(...interface{}) error }) (Feed, error) {
+	var f Feed
+	var checked sql.NullTime
+	err := row.Scan(&f.ID, &f.ChannelID, &f.URL, &f.Title, &f.CreatedBy, &f.CreatedAt, &checked, &f.LastError, &f.ETag, &f.LastModified)
+	if checked.Valid {
+		f.LastChecked = &checked.Time
+	}
+	return f, err
+}
+
+func (d *DB) queryFeeds(where string, args ...interface{}) ([]Feed, error) {
+	// A feed whose channel has gone is left out.
+	rows, err := d.Query(`SELECT `+feedColumns+` FROM feeds f JOIN channels c ON c.id = f.channel_id `+where+` ORDER BY f.created_at ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	feeds := []Feed{}
+	for rows.Next() {
+		if f, err := scanFeed(rows); err == nil {
+			feeds = append(feeds, f)
+		}
+	}
+	return feeds, rows.Err()
+}
+
+// CreateFeed adds a feed of url, titled title, to channelID.
+func (d *DB) CreateFeed(channelID, url, title, createdBy string) (*Feed, error) {
+	id := NewID()
+	if _, err := d.Exec(`INSERT INTO feeds (id, channel_id, url, title, created_by) VALUES (?, ?, ?, ?, ?)`,
+		id, channelID, url, title, createdBy); err != nil {
+		return nil, err
+	}
+	return d.GetFeed(id)
+}
+
+func (d *DB) GetFeed(id string) (*Feed, error) {
+	f, err := scanFeed(d.QueryRow(`SELECT `+feedColumns+` FROM feeds f WHERE f.id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// ListFeeds returns channelID's feeds, oldest first.
+func (d *DB) ListFeeds(channelID string) ([]Feed, error) {
+	return d.queryFeeds(`WHERE f.channel_id = ?`, channelID)
+}
+
+// AllFeeds returns every channel's feeds, for polling.
+func (d *DB) AllFeeds() ([]Feed, error) {
+	return d.queryFeeds(``)
+}
+
+// DeleteFeed removes feed id and what it remembers; its posts stay.
+func (d *DB) DeleteFeed(id string) error {
+	if _, err := d.Exec(`DELETE FROM feed_items WHERE feed_id = ?`, id); err != nil {
+		return err
+	}
+	_, err := d.Exec(`DELETE FROM feeds WHERE id = ?`, id)
+	return err
+}
+
+// FeedChecked records a poll of feed id at checked: errText is empty if it
+// went well, and etag and lastModified are for the next poll to send.
+func (d *DB) FeedChecked(id string, checked time.Time, errText, etag, lastModified string) error {
+	_, err := d.Exec(`UPDATE feeds SET last_checked = ?, last_error = ?, etag = ?, last_modified = ? WHERE id = ?`,
+		checked.UTC(), errText, etag, lastModified, id)
+	return err
+}
+
+// SeeFeedItems records that feed id shows items at now, and returns those
+// it hadn't shown before, in the order given.  Items it stopped showing
+// before forgetBefore are forgotten.
+func (d *DB) SeeFeedItems(id string, items []string, now, forgetBefore time.Time) ([]string, error) {
+	tx, err := d.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var fresh []string
+	for _, item := range items {
+		res, err := tx.Exec(`INSERT OR IGNORE INTO feed_items (feed_id, item, seen_at) VALUES (?, ?, ?)`, id, item, now.UTC())
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			fresh = append(fresh, item)
+		} else if _, err := tx.Exec(`UPDATE feed_items SET seen_at = ? WHERE feed_id = ? AND item = ?`, now.UTC(), id, item); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM feed_items WHERE feed_id = ? AND seen_at < ?`, id, forgetBefore.UTC()); err != nil {
+		return nil, err
+	}
+	return fresh, tx.Commit()
+}
+
+// CreateFeedMessage stores a post of feed f, under its title, with its
+// rich embeds.
+func (d *DB) CreateFeedMessage(f *Feed, content string, richEmbeds json.RawMessage) (*Message, error) {
+	id := NewID()
+	_, err := d.Exec(`INSERT INTO messages (id, channel_id, content, webhook_id, author_name, rich_embeds) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, f.ChannelID, content, f.ID, f.Title, string(richEmbeds))
+	if err != nil {
+		return nil, err
+	}
+	return d.GetMessageByID(id)
+}
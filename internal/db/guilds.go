@@ -0,0 +1,266 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ─── Guilds ───────────────────────────────────────────────────────────────────
+//
+// A guild is one community on the instance, with its own channels,
+// categories, roles and invites.  The default guild is the one every
+// instance starts with: everybody is in it, its name and description are
+// the server settings, and its roles make up a User's Permissions.  Other
+// guilds have rows in guilds and a member list in guild_members; their
+// channels are out of bounds to anyone not on it.
+
+// DefaultGuild is the ID of the guild every user belongs to.  Rows from
+// before guilds existed have it as their guild_id.
+const DefaultGuild = "default"
+
+// DefaultEveryonePermissions is what a new guild's @everyone role allows.
+const DefaultEveryonePermissions = PermReadMessages | PermSendMessages | PermVoiceAll | PermSoundboard
+
+type Guild struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	OwnerID     string    `json:"owner_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Permissions int       `json:"permissions,omitempty"` // the caller's, in GET /api/guilds
+}
+
+// CreateGuild makes a guild owned by ownerID, with ownerID as its first
+// member, an @everyone role and a #general channel.
+func (d *DB) CreateGuild(name, description, ownerID string) (*Guild, error) {
+	id := NewID()
+	tx, err := d.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	steps := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO guilds (id, name, description, owner_id) VALUES (?, ?, ?, ?)`, []interface{}{id, name, description, ownerID}},
+		{`INSERT INTO guild_members (guild_id, user_id) VALUES (?, ?)`, []interface{}{id, ownerID}},
+		{`INSERT INTO roles (id, guild_id, name, color, permissions, position) VALUES (?, ?, '@everyone', '#99AAB5', ?, 1)`, []interface{}{NewID(), id, DefaultEveryonePermissions}},
+		{`INSERT INTO channels (id, guild_id, name, description, type, position) VALUES (?, ?, 'general', 'General discussion', 'text', 1)`, []interface{}{NewID(), id}},
+	}
+	for _, s := range steps {
+		if _, err := tx.Exec(s.query, s.args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return d.GetGuild(id)
+}
+
+// GetGuild returns a guild.  The default guild is made up from the server
+// settings and the instance owner.
+func (d *DB) GetGuild(id string) (*Guild, error) {
+	if id == DefaultGuild {
+		g := &Guild{ID: DefaultGuild}
+		g.Name, _ = d.GetSetting("server_name")
+		g.Description, _ = d.GetSetting("server_description")
+		d.QueryRow(`SELECT id, created_at FROM users WHERE is_owner = 1 ORDER BY created_at ASC LIMIT 1`).
+			Scan(&g.OwnerID, &g.CreatedAt)
+		return g, nil
+	}
+	g := &Guild{}
+	err := d.QueryRow(`SELECT id, name, description, owner_id, created_at FROM guilds WHERE id = ?`, id).
+		Scan(&g.ID, &g.Name, &g.Description, &g.OwnerID, &g.CreatedAt)
+	return g, err
+}
+
+// ListUserGuilds returns the guilds userID is in, the default guild first.
+func (d *DB) ListUserGuilds(userID string) ([]Guild, error) {
+	def, _ := d.GetGuild(DefaultGuild)
+	guilds := []Guild{*def}
+	rows, err := d.Query(`SELECT g.id, g.name, g.description, g.owner_id, g.created_at FROM guilds g
+		JOIN guild_members m ON m.guild_id = g.id
+		WHERE m.user_id = ?
+		ORDER BY g.created_at ASC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var g Guild
+		if rows.Scan(&g.ID, &g.Name, &g.Description, &g.OwnerID, &g.CreatedAt) == nil {
+			guilds = append(guilds, g)
+		}
+	}
+	return guilds, rows.Err()
+}
+
+func (d *DB) UpdateGuild(id, name, description string) error {
+	_, err := d.Exec(`UPDATE guilds SET name = ?, description = ? WHERE id = ?`, name, description, id)
+	return err
+}
+
+// DeleteGuild removes a guild and everything in it.  Messages go with their
+// channels, as in DeleteChannel.
+func (d *DB) DeleteGuild(id string) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, q := range []string{
+		`DELETE FROM user_roles WHERE role_id IN (SELECT id FROM roles WHERE guild_id = ?)`,
+		`DELETE FROM channel_overrides WHERE channel_id IN (SELECT id FROM channels WHERE guild_id = ?)`,
+		`DELETE FROM roles WHERE guild_id = ?`,
+		`DELETE FROM channels WHERE guild_id = ?`,
+		`DELETE FROM channel_categories WHERE guild_id = ?`,
+		`DELETE FROM invites WHERE guild_id = ?`,
+		`DELETE FROM guild_members WHERE guild_id = ?`,
+		`DELETE FROM event_rsvps WHERE event_id IN (SELECT id FROM server_events WHERE guild_id = ?)`,
+		`DELETE FROM server_events WHERE guild_id = ?`,
+		`DELETE FROM automation_rules WHERE guild_id = ?`,
+		`DELETE FROM automod_settings WHERE guild_id = ?`,
+		`DELETE FROM automod_timeouts WHERE guild_id = ?`,
+		`DELETE FROM automod_flags WHERE guild_id = ?`,
+		`DELETE FROM guilds WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(q, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *DB) AddGuildMember(guildID, userID string) error {
+	if guildID == DefaultGuild {
+		return nil
+	}
+	_, err := d.Exec(`INSERT OR IGNORE INTO guild_members (guild_id, user_id) VALUES (?, ?)`, guildID, userID)
+	return err
+}
+
+// RemoveGuildMember takes userID out of guildID, along with their roles
+// there.
+func (d *DB) RemoveGuildMember(guildID, userID string) error {
+	_, err := d.Exec(`DELETE FROM user_roles WHERE user_id = ? AND role_id IN (SELECT id FROM roles WHERE guild_id = ?)`, userID, guildID)
+	if err != nil {
+		return err
+	}
+	_, err = d.Exec(`DELETE FROM guild_members WHERE guild_id = ? AND user_id = ?`, guildID, userID)
+	return err
+}
+
+// IsGuildMember reports whether userID is in guildID; everyone is in the
+// default guild.
+func (d *DB) IsGuildMember(guildID, userID string) bool {
+	if guildID == DefaultGuild {
+		return true
+	}
+	var n int
+	d.QueryRow(`SELECT COUNT(*) FROM guild_members WHERE guild_id = ? AND user_id = ?`, guildID, userID).Scan(&n)
+	return n > 0
+}
+
+// ListGuildMembers returns guildID's members, each with their roles in it.
+func (d *DB) ListGuildMembers(guildID string) ([]User, error) {
+	if guildID == DefaultGuild {
+		return d.ListUsers()
+	}
+	rows, err := d.Query(`SELECT u.id, u.username, u.email, u.avatar, u.is_owner, u.is_bot, u.created_at FROM users u
+		JOIN guild_members m ON m.user_id = u.id
+		WHERE m.guild_id = ?
+		ORDER BY m.joined_at ASC`, guildID)
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	for rows.Next() {
+		var u User
+		var owner int
+		if rows.Scan(&u.ID, &u.Username, &u.Email, &u.Avatar, &owner, &u.Bot, &u.CreatedAt) == nil {
+			u.IsOwner = owner == 1
+			u.Email = userEmail(&u)
+			users = append(users, u)
+		}
+	}
+	rows.Close()
+	for i := range users {
+		users[i].Roles, _ = d.GetUserGuildRoles(users[i].ID, guildID)
+	}
+	return users, nil
+}
+
+// GuildMemberIDs returns the IDs of guildID's members.
+func (d *DB) GuildMemberIDs(guildID string) ([]string, error) {
+	rows, err := d.Query(`SELECT user_id FROM guild_members WHERE guild_id = ?`, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
+
+// ChannelGuild returns the guild channelID is in.  A channel that doesn't
+// exist counts as the default guild's, as channels did before guilds.
+func (d *DB) ChannelGuild(channelID string) string {
+	var guildID sql.NullString
+	d.QueryRow(`SELECT guild_id FROM channels WHERE id = ?`, channelID).Scan(&guildID)
+	if !guildID.Valid || guildID.String == "" {
+		return DefaultGuild
+	}
+	return guildID.String
+}
+
+// CanAccessGuild reports whether u may see guildID at all: its members can,
+// and so can the instance owner, who runs every guild on it.
+func (d *DB) CanAccessGuild(u *User, guildID string) bool {
+	return guildID == DefaultGuild || u.IsOwner || d.IsGuildMember(guildID, u.ID)
+}
+
+// GuildPermissions returns u's permissions across guildID.  In the default
+// guild they're u.Permissions; in another, the guild's owner and the
+// instance owner have every permission, other members have those of the
+// guild's @everyone role and their roles in it, and anyone else has none.
+func (d *DB) GuildPermissions(u *User, guildID string) int {
+	if guildID == DefaultGuild {
+		return u.Permissions
+	}
+	if !d.CanAccessGuild(u, guildID) {
+		return 0
+	}
+	if u.IsOwner {
+		return ownerPermissions
+	}
+	if g, err := d.GetGuild(guildID); err != nil {
+		return 0
+	} else if g.OwnerID == u.ID {
+		return ownerPermissions
+	}
+	perms := 0
+	if everyone, _ := d.GuildEveryoneRole(guildID); everyone != nil {
+		perms |= everyone.Permissions
+	}
+	roles, _ := d.GetUserGuildRoles(u.ID, guildID)
+	for _, r := range roles {
+		perms |= r.Permissions
+	}
+	return perms
+}
+
+// HasGuildPermission is HasPermission within guildID.
+func (d *DB) HasGuildPermission(u *User, guildID string, perm int) bool {
+	p := d.GuildPermissions(u, guildID)
+	if p&PermAdministrator != 0 {
+		return true
+	}
+	return p&perm != 0
+}
@@ -0,0 +1,88 @@
+package db
+
+import "time"
+
+// ─── Invite analytics ────────────────────────────────────────────────────────
+//
+// invite_joins has a row for each person who signed up or joined a guild
+// through an invite.  Signing up also marks the account with the invite and
+// who made it, which stays after the invite is deleted.
+
+// InviteJoin is someone who came in through an invite.
+type InviteJoin struct {
+	UserID     string    `json:"user_id"`
+	Username   string    `json:"username"`
+	Avatar     string    `json:"avatar"`
+	Registered bool      `json:"registered"` // signed up with it, rather than joining its guild
+	JoinedAt   time.Time `json:"joined_at"`
+}
+
+// InviteDay is how many people came in through an invite on a UTC day.
+type InviteDay struct {
+	Day   string `json:"day"` // StatsDay
+	Joins int    `json:"joins"`
+}
+
+// RecordInviteJoin notes that userID came in through inv, signing up with
+// it if registered.
+func (d *DB) RecordInviteJoin(inv *Invite, userID string, registered bool) error {
+	reg := 0
+	if registered {
+		reg = 1
+		if _, err := d.Exec(`UPDATE users SET invite_code = ?, invited_by = ? WHERE id = ?`, inv.Code, inv.CreatedBy, userID); err != nil {
+			return err
+		}
+	}
+	_, err := d.Exec(`INSERT OR IGNORE INTO invite_joins (code, user_id, registered) VALUES (?, ?, ?)`, inv.Code, userID, reg)
+	return err
+}
+
+// InviteJoins lists who came in through the invite code, newest first.
+func (d *DB) InviteJoins(code string) ([]InviteJoin, error) {
+	rows, err := d.Query(`SELECT j.user_id, u.username, COALESCE(u.avatar, ''), j.registered, j.joined_at
+		FROM invite_joins j JOIN users u ON u.id = j.user_id
+		WHERE j.code = ? ORDER BY j.joined_at DESC`, code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	joins := []InviteJoin{}
+	for rows.Next() {
+		var j InviteJoin
+		var reg int
+		if err := rows.Scan(&j.UserID, &j.Username, &j.Avatar, &reg, &j.JoinedAt); err == nil {
+			j.Registered = reg == 1
+			joins = append(joins, j)
+		}
+	}
+	return joins, rows.Err()
+}
+
+// InviteJoinsByDay counts who came in through the invite code on each UTC
+// day from since to today, days with nobody included.
+func (d *DB) InviteJoinsByDay(code string, since, now time.Time) ([]InviteDay, error) {
+	rows, err := d.Query(`SELECT date(joined_at), COUNT(*) FROM invite_joins
+		WHERE code = ? AND date(joined_at) >= ? GROUP BY date(joined_at)`,
+		code, since.UTC().Format(StatsDay))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	for rows.Next() {
+		var day string
+		var n int
+		if err := rows.Scan(&day, &n); err == nil {
+			counts[day] = n
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	days := []InviteDay{}
+	for t := since.UTC(); t.Format(StatsDay) <= now.UTC().Format(StatsDay); t = t.AddDate(0, 0, 1) {
+		day := t.Format(StatsDay)
+		days = append(days, InviteDay{Day: day, Joins: counts[day]})
+	}
+	return days, nil
+}
@@ -0,0 +1,76 @@
+package db
+
+import "time"
+
+// ─── IP rules ─────────────────────────────────────────────────────────────────
+//
+// ip_rules are the admins' allow and deny lists of client addresses.  Each
+// rule covers one network in CIDR form; a single address is a /32 or /128.
+
+// IP rule actions.
+const (
+	IPAllow = "allow"
+	IPDeny  = "deny"
+)
+
+// IPRule is one entry in the allow or deny list.
+type IPRule struct {
+	ID        string    `json:"id"`
+	Network   string    `json:"network"`
+	Action    string    `json:"action"`
+	Note      string    `json:"note"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateIPRule adds a rule for network, which the caller has checked.
+func (d *DB) CreateIPRule(network, action, note, createdBy string) (*IPRule, error) {
+	id := NewID()
+	_, err := d.Exec(`INSERT INTO ip_rules (id, network, action, note, created_by) VALUES (?, ?, ?, ?, ?)`,
+		id, network, action, note, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetIPRule(id)
+}
+
+func (d *DB) GetIPRule(id string) (*IPRule, error) {
+	var rule IPRule
+	err := d.QueryRow(`SELECT id, network, action, note, created_by, created_at FROM ip_rules WHERE id = ?`, id).
+		Scan(&rule.ID, &rule.Network, &rule.Action, &rule.Note, &rule.CreatedBy, &rule.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// ListIPRules returns every rule, oldest first.
+func (d *DB) ListIPRules() ([]IPRule, error) {
+	rows, err := d.Query(`SELECT id, network, action, note, created_by, created_at FROM ip_rules ORDER BY created_at ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []IPRule{}
+	for rows.Next() {
+		var rule IPRule
+		if err := rows.Scan(&rule.ID, &rule.Network, &rule.Action, &rule.Note, &rule.CreatedBy, &rule.CreatedAt); err == nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, rows.Err()
+}
+
+func (d *DB) DeleteIPRule(id string) error {
+	_, err := d.Exec(`DELETE FROM ip_rules WHERE id = ?`, id)
+	return err
+}
+
+// ClearIPRules deletes every rule, returning how many there were.
+func (d *DB) ClearIPRules() (int64, error) {
+	res, err := d.Exec(`DELETE FROM ip_rules`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ─── Shared job state ────────────────────────────────────────────────────────
+//
+// Jobs that several instances sharing a database must not all run, such as
+// backups or digests, are claimed here first.  A claim is a lease the
+// instance renews while the job runs, so one that dies mid-run lets go
+// before long.  A claim names how many runs its instance has seen, so two
+// instances that both think a run is due can't both get it.
+
+// JobRun is how a shared job last ran, and who has it now.
+type JobRun struct {
+	Runs        int64
+	LastRun     time.Time // zero if it never has
+	Took        time.Duration
+	Error       string
+	Instance    string // that ran it last
+	LockedBy    string // instance running it now, if LockedUntil is ahead
+	LockedUntil time.Time
+}
+
+func scanJobRun(row interface{ Scan(...interface{}) error }) (string, JobRun, error) {
+	var name string
+	var j JobRun
+	var last sql.NullTime
+	var tookMS, until int64
+	err := row.Scan(&name, &j.Runs, &last, &tookMS, &j.Error, &j.Instance, &j.LockedBy, &until)
+	if last.Valid {
+		j.LastRun = last.Time
+	}
+	j.Took = time.Duration(tookMS) * time.Millisecond
+	if until > 0 {
+		j.LockedUntil = time.Unix(until, 0)
+	}
+	return name, j, err
+}
+
+const jobColumns = `name, runs, last_run, last_took_ms, last_error, last_instance, locked_by, locked_until`
+
+// GetJobRun returns how job name last ran; a zero JobRun if it never has.
+func (d *DB) GetJobRun(name string) (JobRun, error) {
+	_, j, err := scanJobRun(d.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE name = ?`, name))
+	if err == sql.ErrNoRows {
+		return JobRun{}, nil
+	}
+	return j, err
+}
+
+// JobRuns returns how every shared job last ran, by name.
+func (d *DB) JobRuns() (map[string]JobRun, error) {
+	rows, err := d.Query(`SELECT ` + jobColumns + ` FROM jobs`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	runs := map[string]JobRun{}
+	for rows.Next() {
+		name, j, err := scanJobRun(rows)
+		if err != nil {
+			continue
+		}
+		runs[name] = j
+	}
+	return runs, rows.Err()
+}
+
+// ClaimJob gives job name to instance for lease, if no other instance has
+// it and it has run runs times; runs < 0 takes it however many times it
+// has.  It reports whether the claim was had.
+func (d *DB) ClaimJob(name, instance string, runs int64, lease time.Duration) (bool, error) {
+	if _, err := d.Exec(`INSERT OR IGNORE INTO jobs (name) VALUES (?)`, name); err != nil {
+		return false, err
+	}
+	now := time.Now()
+	res, err := d.Exec(`UPDATE jobs SET locked_by = ?, locked_until = ?
+		WHERE name = ? AND (locked_by = '' OR locked_by = ? OR locked_until < ?) AND (? < 0 OR runs = ?)`,
+		instance, now.Add(lease).Unix(), name, instance, now.Unix(), runs, runs)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// RenewJobClaim extends instance's claim on job name by lease from now.
+func (d *DB) RenewJobClaim(name, instance string, lease time.Duration) error {
+	_, err := d.Exec(`UPDATE jobs SET locked_until = ? WHERE name = ? AND locked_by = ?`,
+		time.Now().Add(lease).Unix(), name, instance)
+	return err
+}
+
+// FinishJob records a run of job name that instance started at started,
+// and lets the job go.  errText is empty for a run that went well.
+func (d *DB) FinishJob(name, instance string, started time.Time, took time.Duration, errText string) error {
+	_, err := d.Exec(`UPDATE jobs SET runs = runs + 1, last_run = ?, last_took_ms = ?, last_error = ?,
+		last_instance = ?, locked_by = '', locked_until = 0 WHERE name = ? AND locked_by = ?`,
+		started.UTC(), took.Milliseconds(), errText, instance, name, instance)
+	return err
+}
+
+// RecordJobRun records a run of job name made outside the scheduler, such
+// as from the command line, so its schedule counts from it.
+func (d *DB) RecordJobRun(name, instance string, started time.Time, took time.Duration) error {
+	if _, err := d.Exec(`INSERT OR IGNORE INTO jobs (name) VALUES (?)`, name); err != nil {
+		return err
+	}
+	_, err := d.Exec(`UPDATE jobs SET runs = runs + 1, last_run = ?, last_took_ms = ?, last_error = '',
+		last_instance = ? WHERE name = ?`, started.UTC(), took.Milliseconds(), instance, name)
+	return err
+}
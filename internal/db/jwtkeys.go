@@ -0,0 +1,103 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ─── Token signing keys ──────────────────────────────────────────────────────
+//
+// Session tokens are signed with JWT_SECRET until an admin adds a key here.
+// The newest key that isn't retired signs new tokens, and every key that
+// isn't retired, JWT_SECRET's included, is accepted; so the secret can be
+// rotated by adding a key, letting sessions move over to it, and retiring
+// the old one.  JWT_SECRET's key is called "env", and its retirement is
+// kept in the settings.
+
+// EnvJWTKey is the ID of the key made from JWT_SECRET.
+const EnvJWTKey = "env"
+
+// ErrLastJWTKey is returned for retiring the only key left.
+var ErrLastJWTKey = errors.New("can't retire the only key left")
+
+// JWTKey is a key that signs session tokens.
+type JWTKey struct {
+	ID        string     `json:"id"`
+	Secret    string     `json:"-"` // empty for EnvJWTKey
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+}
+
+// ListJWTKeys lists the keys, retired ones included: newest first, with
+// EnvJWTKey last.
+func (d *DB) ListJWTKeys() ([]JWTKey, error) {
+	rows, err := d.Query(`SELECT id, secret, created_at, retired_at FROM jwt_keys ORDER BY created_at DESC, rowid DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []JWTKey
+	for rows.Next() {
+		var k JWTKey
+		var created time.Time
+		var retired sql.NullTime
+		if err := rows.Scan(&k.ID, &k.Secret, &created, &retired); err != nil {
+			continue
+		}
+		k.CreatedAt = &created
+		if retired.Valid {
+			k.RetiredAt = &retired.Time
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	env := JWTKey{ID: EnvJWTKey}
+	if v, _ := d.GetSetting("jwt_env_retired_at"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			env.RetiredAt = &t
+		}
+	}
+	return append(keys, env), nil
+}
+
+// AddJWTKey makes a new key, which signs new tokens from now on.
+func (d *DB) AddJWTKey() (*JWTKey, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	k := &JWTKey{ID: NewID(), Secret: NewID() + NewID() + NewID() + NewID(), CreatedAt: &now}
+	_, err := d.Exec(`INSERT INTO jwt_keys (id, secret, created_at) VALUES (?, ?, ?)`, k.ID, k.Secret, now)
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// RetireJWTKey stops key id being accepted.  It returns sql.ErrNoRows if
+// there's no such key in use, and ErrLastJWTKey if it's the only one.
+func (d *DB) RetireJWTKey(id string) error {
+	keys, err := d.ListJWTKeys()
+	if err != nil {
+		return err
+	}
+	active, found := 0, false
+	for _, k := range keys {
+		if k.RetiredAt == nil {
+			active++
+			found = found || k.ID == id
+		}
+	}
+	if !found {
+		return sql.ErrNoRows
+	}
+	if active == 1 {
+		return ErrLastJWTKey
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	if id == EnvJWTKey {
+		return d.SetSetting("jwt_env_retired_at", now.Format(time.RFC3339))
+	}
+	_, err = d.Exec(`UPDATE jwt_keys SET retired_at = ? WHERE id = ?`, now, id)
+	return err
+}
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"fmt"
+	"os"
+)
+
+// ─── Maintenance ──────────────────────────────────────────────────────────────
+//
+// Used by the `chirm admin` commands.  Both work while the server is running:
+// SQLite lets them wait their turn for the database.
+
+// Backup writes a consistent copy of the database to path, which mustn't
+// exist yet.
+func (d *DB) Backup(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	return d.vacuumInto(path)
+}
+
+// Vacuum rebuilds the database file, returning the space freed by deleted
+// rows to the filesystem.
+func (d *DB) Vacuum() error {
+	if _, err := d.Exec(`VACUUM`); err != nil {
+		return err
+	}
+	_, err := d.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`)
+	return err
+}
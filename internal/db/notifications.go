@@ -0,0 +1,246 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ─── Notification settings ────────────────────────────────────────────────────
+//
+// Each user picks a default level for push notifications and may override it
+// per channel.  The server applies these before sending Web Push, so muted
+// channels never reach a user's devices at all.  Quiet hours suppress pushes
+// during a daily window in the user's own timezone.
+
+const (
+	NotifyAll      = "all"      // every message
+	NotifyMentions = "mentions" // only @mentions (and @everyone unless suppressed)
+	NotifyNone     = "none"     // nothing
+)
+
+// ValidNotifyLevel reports whether level is one of the Notify* levels.
+func ValidNotifyLevel(level string) bool {
+	return level == NotifyAll || level == NotifyMentions || level == NotifyNone
+}
+
+type NotificationSettings struct {
+	DefaultLevel     string            `json:"default_level"`
+	SuppressEveryone bool              `json:"suppress_everyone"`
+	Channels         map[string]string `json:"channels"` // channelID → level
+	Saved            bool              `json:"saved"`    // false until the user first saves
+
+	// Quiet hours: "HH:MM" local times in QuietTZ (an IANA zone name).  The
+	// window may wrap past midnight; empty times mean no quiet hours.
+	QuietStart         string `json:"quiet_start"`
+	QuietEnd           string `json:"quiet_end"`
+	QuietTZ            string `json:"quiet_tz"`
+	QuietAllowMentions bool   `json:"quiet_allow_mentions"` // direct @mentions still push
+
+	// EmailMentions: email the user about @mentions they missed while
+	// offline with no working push subscription (when SMTP is configured).
+	EmailMentions bool `json:"email_mentions"`
+
+	// Digest is how often to email a summary of unread activity: one of
+	// the Digest* values.
+	Digest string `json:"digest"`
+
+	// Language is the language notifications are written in ("" for the
+	// server default).
+	Language string `json:"language"`
+}
+
+// DefaultNotificationSettings are what a user gets until they save their own.
+func DefaultNotificationSettings() *NotificationSettings {
+	return &NotificationSettings{DefaultLevel: NotifyAll, Channels: map[string]string{}, EmailMentions: true}
+}
+
+// Level returns the effective level for channelID.
+func (s *NotificationSettings) Level(channelID string) string {
+	if l, ok := s.Channels[channelID]; ok {
+		return l
+	}
+	return s.DefaultLevel
+}
+
+// ParseClock parses an "HH:MM" time of day into minutes after midnight.
+func ParseClock(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || len(s) != 5 || h > 23 || m > 59 || h < 0 || m < 0 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// InQuietHours reports whether t falls inside the user's quiet hours.
+func (s *NotificationSettings) InQuietHours(t time.Time) bool {
+	start, err1 := ParseClock(s.QuietStart)
+	end, err2 := ParseClock(s.QuietEnd)
+	if err1 != nil || err2 != nil || start == end {
+		return false
+	}
+	loc, err := time.LoadLocation(s.QuietTZ)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := t.In(loc)
+	now := local.Hour()*60 + local.Minute()
+	if start < end {
+		return now >= start && now < end
+	}
+	return now >= start || now < end // wraps past midnight
+}
+
+// QuietFor reports whether quiet hours hold back a push at t; a direct
+// mention gets through if the user allowed it.
+func (s *NotificationSettings) QuietFor(t time.Time, mentioned bool) bool {
+	return s.InQuietHours(t) && !(mentioned && s.QuietAllowMentions)
+}
+
+// Wants reports whether a message in channelID should be pushed, given
+// whether it @mentions the user or @everyone.
+func (s *NotificationSettings) Wants(channelID string, mentioned, everyone bool) bool {
+	switch s.Level(channelID) {
+	case NotifyAll:
+		return true
+	case NotifyMentions:
+		return mentioned || (everyone && !s.SuppressEveryone)
+	}
+	return false
+}
+
+// Mentions are the @handles parsed out of one message.
+type Mentions struct {
+	Users    map[string]bool // lower-cased usernames
+	Everyone bool            // @everyone or @here
+}
+
+// mentionPattern matches @handles the way the client highlights them, but
+// not inside words, so an email address isn't a mention.
+var mentionPattern = regexp.MustCompile(`(?:^|[^a-zA-Z0-9_])@([a-zA-Z0-9_]{1,32})\b`)
+
+// ParseMentions extracts the @mentions from message content.
+func ParseMentions(content string) Mentions {
+	m := Mentions{Users: map[string]bool{}}
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := strings.ToLower(match[1])
+		if name == "everyone" || name == "here" {
+			m.Everyone = true
+		} else {
+			m.Users[name] = true
+		}
+	}
+	return m
+}
+
+// Has reports whether username was mentioned by name.
+func (m Mentions) Has(username string) bool {
+	return m.Users[strings.ToLower(username)]
+}
+
+// MentionedUserIDs returns the IDs of the users m mentions by name.
+func (d *DB) MentionedUserIDs(m Mentions) ([]string, error) {
+	names := make([]string, 0, len(m.Users))
+	for name := range m.Users {
+		names = append(names, name)
+	}
+	var ids []string
+	err := d.queryIn(`SELECT id FROM users WHERE LOWER(username) IN (%s)`, names, nil, func(rows *sql.Rows) {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	})
+	return ids, err
+}
+
+func (d *DB) GetNotificationSettings(userID string) (*NotificationSettings, error) {
+	s := DefaultNotificationSettings()
+	err := d.QueryRow(`SELECT default_level, suppress_everyone, quiet_start, quiet_end, quiet_tz, quiet_allow_mentions,
+		email_mentions, digest, language FROM notification_settings WHERE user_id = ?`, userID).
+		Scan(&s.DefaultLevel, &s.SuppressEveryone, &s.QuietStart, &s.QuietEnd, &s.QuietTZ, &s.QuietAllowMentions,
+			&s.EmailMentions, &s.Digest, &s.Language)
+	if err == nil {
+		s.Saved = true
+	}
+	rows, err := d.Query(`SELECT channel_id, level FROM notification_channel_levels WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ch, level string
+		if rows.Scan(&ch, &level) == nil {
+			s.Channels[ch] = level
+		}
+	}
+	return s, rows.Err()
+}
+
+// NotificationSettingsFor returns the settings of those of userIDs who have
+// saved any, keyed by user ID.  Users missing from the map use the defaults.
+func (d *DB) NotificationSettingsFor(userIDs []string) (map[string]*NotificationSettings, error) {
+	out := map[string]*NotificationSettings{}
+	err := d.queryIn(`SELECT user_id, default_level, suppress_everyone, quiet_start, quiet_end, quiet_tz, quiet_allow_mentions,
+		email_mentions, digest, language FROM notification_settings WHERE user_id IN (%s)`, userIDs, nil, func(rows *sql.Rows) {
+		var uid string
+		s := DefaultNotificationSettings()
+		s.Saved = true
+		if rows.Scan(&uid, &s.DefaultLevel, &s.SuppressEveryone, &s.QuietStart, &s.QuietEnd, &s.QuietTZ, &s.QuietAllowMentions,
+			&s.EmailMentions, &s.Digest, &s.Language) == nil {
+			out[uid] = s
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = d.queryIn(`SELECT user_id, channel_id, level FROM notification_channel_levels WHERE user_id IN (%s)`, userIDs, nil, func(rows *sql.Rows) {
+		var uid, ch, level string
+		if rows.Scan(&uid, &ch, &level) != nil {
+			return
+		}
+		if out[uid] == nil {
+			out[uid] = DefaultNotificationSettings()
+		}
+		out[uid].Channels[ch] = level
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SaveNotificationSettings replaces userID's settings, including all
+// per-channel levels.
+func (d *DB) SaveNotificationSettings(userID string, s *NotificationSettings) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO notification_settings
+		(user_id, default_level, suppress_everyone, quiet_start, quiet_end, quiet_tz, quiet_allow_mentions, email_mentions, digest, language,
+			updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id) DO UPDATE SET default_level = excluded.default_level,
+			suppress_everyone = excluded.suppress_everyone, quiet_start = excluded.quiet_start,
+			quiet_end = excluded.quiet_end, quiet_tz = excluded.quiet_tz,
+			quiet_allow_mentions = excluded.quiet_allow_mentions, email_mentions = excluded.email_mentions,
+			digest = excluded.digest, language = excluded.language, updated_at = CURRENT_TIMESTAMP`,
+		userID, s.DefaultLevel, s.SuppressEveryone, s.QuietStart, s.QuietEnd, s.QuietTZ, s.QuietAllowMentions,
+		s.EmailMentions, s.Digest, s.Language); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM notification_channel_levels WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for ch, level := range s.Channels {
+		if _, err := tx.Exec(`INSERT INTO notification_channel_levels (user_id, channel_id, level) VALUES (?, ?, ?)`,
+			userID, ch, level); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,343 @@
+package db
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ─── Identity provider ───────────────────────────────────────────────────────
+//
+// Other apps can sign people in with their Chirm accounts, over OAuth 2 and
+// OpenID Connect.  Admins register each app as a client, with the addresses
+// it may send people back to and, optionally, the roles people need to use
+// it.  A member agrees once to what an app may see, and the app gets a code
+// to trade for tokens.  Codes, tokens and client secrets are shown once;
+// only their hashes are kept.
+
+// What a token is for.
+const (
+	OAuthAccess  = "access"  // calls to userinfo
+	OAuthRefresh = "refresh" // new tokens when those run out
+)
+
+// OAuthClient is an app that signs people in with Chirm.
+type OAuthClient struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Public       bool      `json:"public"` // has no secret, so must use PKCE
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`        // what it may ask for
+	AllowedRoles []string  `json:"allowed_roles"` // only members with one of these, if any
+	CreatedBy    string    `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	secretHash string
+}
+
+// CheckSecret reports whether secret is c's.
+func (c *OAuthClient) CheckSecret(secret string) bool {
+	if c.secretHash == "" || secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashBotSecret(secret)), []byte(c.secretHash)) == 1
+}
+
+const oauthClientColumns = `id, name, secret_hash, redirect_uris, scopes, allowed_roles, created_by, created_at`
+
+func scanOAuthClient(row interface{ Scan(...interface{}) error }) (OAuthClient, error) {
+	var c OAuthClient
+	var redirects, scopes, roles string
+	if err := row.Scan(&c.ID, &c.Name, &c.secretHash, &redirects, &scopes, &roles, &c.CreatedBy, &c.CreatedAt); err != nil {
+		return c, err
+	}
+	c.Public = c.secretHash == ""
+	json.Unmarshal([]byte(redirects), &c.RedirectURIs)
+	json.Unmarshal([]byte(roles), &c.AllowedRoles)
+	c.Scopes = strings.Fields(scopes)
+	if c.RedirectURIs == nil {
+		c.RedirectURIs = []string{}
+	}
+	if c.AllowedRoles == nil {
+		c.AllowedRoles = []string{}
+	}
+	return c, nil
+}
+
+func newOAuthSecret() (secret, hash string) {
+	secret = NewID() + NewID() + NewID() + NewID()
+	return secret, hashBotSecret(secret)
+}
+
+// CreateOAuthClient registers c, returning it as stored and its secret,
+// which is "" for a public client.
+func (d *DB) CreateOAuthClient(c OAuthClient) (*OAuthClient, string, error) {
+	id := NewID()
+	secret, hash := "", ""
+	if !c.Public {
+		secret, hash = newOAuthSecret()
+	}
+	redirects, _ := json.Marshal(c.RedirectURIs)
+	roles, _ := json.Marshal(c.AllowedRoles)
+	_, err := d.Exec(`INSERT INTO oauth_clients (id, name, secret_hash, redirect_uris, scopes, allowed_roles, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, c.Name, hash, string(redirects), strings.Join(c.Scopes, " "), string(roles), c.CreatedBy)
+	if err != nil {
+		return nil, "", err
+	}
+	stored, err := d.GetOAuthClient(id)
+	return stored, secret, err
+}
+
+func (d *DB) GetOAuthClient(id string) (*OAuthClient, error) {
+	c, err := scanOAuthClient(d.QueryRow(`SELECT `+oauthClientColumns+` FROM oauth_clients WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ListOAuthClients returns every client, oldest first.
+func (d *DB) ListOAuthClients() ([]OAuthClient, error) {
+	rows, err := d.Query(`SELECT ` + oauthClientColumns + ` FROM oauth_clients ORDER BY created_at ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	clients := []OAuthClient{}
+	for rows.Next() {
+		if c, err := scanOAuthClient(rows); err == nil {
+			clients = append(clients, c)
+		}
+	}
+	return clients, rows.Err()
+}
+
+// UpdateOAuthClient saves c's name, redirect URIs, scopes and roles.
+func (d *DB) UpdateOAuthClient(c OAuthClient) (*OAuthClient, error) {
+	redirects, _ := json.Marshal(c.RedirectURIs)
+	roles, _ := json.Marshal(c.AllowedRoles)
+	_, err := d.Exec(`UPDATE oauth_clients SET name = ?, redirect_uris = ?, scopes = ?, allowed_roles = ? WHERE id = ?`,
+		c.Name, string(redirects), strings.Join(c.Scopes, " "), string(roles), c.ID)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetOAuthClient(c.ID)
+}
+
+// ResetOAuthClientSecret gives client id a new secret, returning it; the
+// old one stops working.  A public client becomes a confidential one.
+func (d *DB) ResetOAuthClientSecret(id string) (string, error) {
+	secret, hash := newOAuthSecret()
+	_, err := d.Exec(`UPDATE oauth_clients SET secret_hash = ? WHERE id = ?`, hash, id)
+	return secret, err
+}
+
+// DeleteOAuthClient deletes client id, and with it everything it was
+// given.
+func (d *DB) DeleteOAuthClient(id string) error {
+	for _, q := range []string{
+		`DELETE FROM oauth_tokens WHERE client_id = ?`,
+		`DELETE FROM oauth_codes WHERE client_id = ?`,
+		`DELETE FROM oauth_consents WHERE client_id = ?`,
+		`DELETE FROM oauth_clients WHERE id = ?`,
+	} {
+		if _, err := d.Exec(q, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ─── Consents ────────────────────────────────────────────────────────────────
+
+// OAuthConsent is an app a member let sign them in.
+type OAuthConsent struct {
+	ClientID   string    `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scope      string    `json:"scope"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// OAuthConsentScope returns what userID let clientID see, or "" if they
+// haven't yet.
+func (d *DB) OAuthConsentScope(userID, clientID string) string {
+	var scope string
+	d.QueryRow(`SELECT scope FROM oauth_consents WHERE user_id = ? AND client_id = ?`, userID, clientID).Scan(&scope)
+	return scope
+}
+
+// SaveOAuthConsent records that userID let clientID see scope.
+func (d *DB) SaveOAuthConsent(userID, clientID, scope string) error {
+	_, err := d.Exec(`INSERT INTO oauth_consents (user_id, client_id, scope, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, client_id) DO UPDATE SET scope = excluded.scope, created_at = excluded.created_at`,
+		userID, clientID, scope, time.Now().UTC())
+	return err
+}
+
+// ListOAuthConsents returns the apps userID let sign them in, newest first.
+func (d *DB) ListOAuthConsents(userID string) ([]OAuthConsent, error) {
+	rows, err := d.Query(`SELECT c.client_id, a.name, c.scope, c.created_at FROM oauth_consents c
+		JOIN oauth_clients a ON a.id = c.client_id WHERE c.user_id = ? ORDER BY c.created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	consents := []OAuthConsent{}
+	for rows.Next() {
+		var c OAuthConsent
+		if rows.Scan(&c.ClientID, &c.ClientName, &c.Scope, &c.CreatedAt) == nil {
+			consents = append(consents, c)
+		}
+	}
+	return consents, rows.Err()
+}
+
+// DeleteOAuthConsent takes back userID's consent to clientID, and the
+// tokens it was given for them, reporting whether there was one.
+func (d *DB) DeleteOAuthConsent(userID, clientID string) (bool, error) {
+	res, err := d.Exec(`DELETE FROM oauth_consents WHERE user_id = ? AND client_id = ?`, userID, clientID)
+	if err != nil {
+		return false, err
+	}
+	if _, err := d.Exec(`DELETE FROM oauth_tokens WHERE user_id = ? AND client_id = ?`, userID, clientID); err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ─── Codes and tokens ────────────────────────────────────────────────────────
+
+// OAuthGrant is what a code or token stands for: a member letting an app
+// see scope, having signed in at AuthTime.
+type OAuthGrant struct {
+	ClientID string
+	UserID   string
+	Scope    string
+	AuthTime time.Time
+	// For codes only: where the app asked to be sent back to, the nonce
+	// to put in the ID token, and the PKCE challenge.
+	RedirectURI   string
+	Nonce         string
+	CodeChallenge string
+}
+
+// CreateOAuthCode returns a code for g, good once and for ttl.
+func (d *DB) CreateOAuthCode(g OAuthGrant, ttl time.Duration) (string, error) {
+	code, hash := newOAuthSecret()
+	_, err := d.Exec(`INSERT INTO oauth_codes (code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, auth_time, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		hash, g.ClientID, g.UserID, g.RedirectURI, g.Scope, g.Nonce, g.CodeChallenge, g.AuthTime.UTC(), time.Now().Add(ttl).UTC())
+	return code, err
+}
+
+// TakeOAuthCode returns what code stands for, and uses it up.  An expired
+// or used code is sql.ErrNoRows.
+func (d *DB) TakeOAuthCode(code string) (*OAuthGrant, error) {
+	hash := hashBotSecret(code)
+	var g OAuthGrant
+	var expires time.Time
+	err := d.QueryRow(`SELECT client_id, user_id, redirect_uri, scope, nonce, code_challenge, auth_time, expires_at
+		FROM oauth_codes WHERE code_hash = ?`, hash).
+		Scan(&g.ClientID, &g.UserID, &g.RedirectURI, &g.Scope, &g.Nonce, &g.CodeChallenge, &g.AuthTime, &expires)
+	if err != nil {
+		return nil, err
+	}
+	res, err := d.Exec(`DELETE FROM oauth_codes WHERE code_hash = ?`, hash)
+	if err != nil {
+		return nil, err
+	}
+	// Whoever deleted it first gets to use it.
+	if n, _ := res.RowsAffected(); n == 0 || time.Now().After(expires) {
+		return nil, sql.ErrNoRows
+	}
+	return &g, nil
+}
+
+// CreateOAuthToken returns a token of kind for g, good for ttl.
+func (d *DB) CreateOAuthToken(kind string, g OAuthGrant, ttl time.Duration) (string, error) {
+	token, hash := newOAuthSecret()
+	_, err := d.Exec(`INSERT INTO oauth_tokens (token_hash, kind, client_id, user_id, scope, auth_time, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		hash, kind, g.ClientID, g.UserID, g.Scope, g.AuthTime.UTC(), time.Now().Add(ttl).UTC())
+	return token, err
+}
+
+// GetOAuthToken returns what token, of kind, stands for, if it hasn't run
+// out and its user and app are still there.
+func (d *DB) GetOAuthToken(kind, token string) (*OAuthGrant, error) {
+	var g OAuthGrant
+	err := d.QueryRow(`SELECT t.client_id, t.user_id, t.scope, t.auth_time FROM oauth_tokens t
+		JOIN users u ON u.id = t.user_id JOIN oauth_clients c ON c.id = t.client_id
+		WHERE t.token_hash = ? AND t.kind = ? AND t.expires_at > ?`, hashBotSecret(token), kind, time.Now().UTC()).
+		Scan(&g.ClientID, &g.UserID, &g.Scope, &g.AuthTime)
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// DeleteOAuthToken stops token working, if clientID was given it,
+// reporting whether it was.
+func (d *DB) DeleteOAuthToken(clientID, token string) (bool, error) {
+	res, err := d.Exec(`DELETE FROM oauth_tokens WHERE token_hash = ? AND client_id = ?`, hashBotSecret(token), clientID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// PruneOAuth deletes the codes and tokens that ran out before now.
+func (d *DB) PruneOAuth(now time.Time) error {
+	if _, err := d.Exec(`DELETE FROM oauth_codes WHERE expires_at <= ?`, now.UTC()); err != nil {
+		return err
+	}
+	_, err := d.Exec(`DELETE FROM oauth_tokens WHERE expires_at <= ?`, now.UTC())
+	return err
+}
+
+// ─── Signing keys ────────────────────────────────────────────────────────────
+
+// ErrNoOAuthKey is returned when no key has been made yet.
+var ErrNoOAuthKey = errors.New("no ID token signing key")
+
+// OAuthKey is a key ID tokens are signed with, as PEM.
+type OAuthKey struct {
+	ID         string
+	PrivateKey string
+	CreatedAt  time.Time
+}
+
+// ListOAuthKeys returns the signing keys, oldest first; the oldest signs.
+func (d *DB) ListOAuthKeys() ([]OAuthKey, error) {
+	rows, err := d.Query(`SELECT id, private_key, created_at FROM oauth_keys ORDER BY created_at ASC, id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []OAuthKey
+	for rows.Next() {
+		var k OAuthKey
+		if rows.Scan(&k.ID, &k.PrivateKey, &k.CreatedAt) == nil {
+			keys = append(keys, k)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoOAuthKey
+	}
+	return keys, nil
+}
+
+// AddOAuthKey stores a signing key.
+func (d *DB) AddOAuthKey(id, privateKey string) error {
+	_, err := d.Exec(`INSERT INTO oauth_keys (id, private_key) VALUES (?, ?)`, id, privateKey)
+	return err
+}
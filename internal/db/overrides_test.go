@@ -0,0 +1,78 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestChannelReadersMatchesChannelPermissions checks the batched check
+// against the one-user-at-a-time one, in the default guild and another.
+func TestChannelReadersMatchesChannelPermissions(t *testing.T) {
+	d, err := Open(filepath.Join(t.TempDir(), "chirm.db"), PoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	mustUser := func(name string, owner bool) *User {
+		u, err := d.CreateUser(name, name+"@example.com", "x", owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	owner := mustUser("owner", true)
+	staff := mustUser("staff", false)
+	plain := mustUser("plain", false)
+	outsider := mustUser("outsider", false)
+	ids := []string{owner.ID, staff.ID, plain.ID, outsider.ID, "nobody"}
+
+	staffRole, err := d.CreateRole(DefaultGuild, "Staff", "", PermSendMessages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.AssignRole(staff.ID, staffRole.ID)
+	everyone, err := d.CreateRole(DefaultGuild, "@everyone", "", DefaultEveryonePermissions) // made at setup
+	if err != nil {
+		t.Fatal(err)
+	}
+	staffOnly, err := d.CreateChannel(DefaultGuild, "staff-only", "", "text", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetChannelOverride(staffOnly.ID, everyone.ID, 0, PermReadMessages)
+	d.SetChannelOverride(staffOnly.ID, staffRole.ID, PermReadMessages, 0)
+	open, err := d.CreateChannel(DefaultGuild, "open", "", "text", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := d.CreateGuild("Side", "", plain.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.AddGuildMember(g.ID, staff.ID)
+	side, err := d.CreateChannel(g.ID, "side", "", "text", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, channelID := range []string{staffOnly.ID, open.ID, side.ID} {
+		readers, err := d.ChannelReaders(channelID, ids)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range ids {
+			want := false
+			if u, err := d.GetUserByID(id); err == nil {
+				want = d.ChannelPermissions(u, channelID)&PermReadMessages != 0
+			}
+			if got := readers[id] != nil; got != want {
+				t.Errorf("channel %s, user %s: ChannelReaders says %v, ChannelPermissions %v", channelID, id, got, want)
+			}
+		}
+	}
+	if readers, _ := d.ChannelReaders(staffOnly.ID, ids); readers[plain.ID] != nil || readers[staff.ID] == nil {
+		t.Errorf("staff-only channel readers = %v", readers)
+	}
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// ─── Connection pools ────────────────────────────────────────────────────────
+//
+// SQLite lets one connection write at a time, and any number read alongside
+// it in WAL mode.  So the database has a single writer connection, which
+// Exec and Begin use, and a pool of read-only connections for Query and
+// QueryRow: writers queue in Go rather than failing with SQLITE_BUSY, and a
+// long read doesn't hold up the next message being saved.  A server
+// database such as Postgres would instead take PoolConfig as the sizes of
+// its one pool.
+
+// PoolConfig sizes the connection pools.  Zero fields take the defaults.
+type PoolConfig struct {
+	MaxOpen     int           // reading connections; default GOMAXPROCS, at least 4
+	MaxIdle     int           // reading connections kept open when idle; default MaxOpen
+	MaxIdleTime time.Duration // an idle connection is closed after this; default 5m
+	MaxLifetime time.Duration // a connection is closed after this; default never
+	BusyTimeout time.Duration // how long to wait on another process's lock; default 5s
+}
+
+func (c PoolConfig) withDefaults() PoolConfig {
+	if c.MaxOpen <= 0 {
+		c.MaxOpen = max(runtime.GOMAXPROCS(0), 4)
+	}
+	if c.MaxIdle <= 0 {
+		c.MaxIdle = c.MaxOpen
+	}
+	if c.MaxIdleTime <= 0 {
+		c.MaxIdleTime = 5 * time.Minute
+	}
+	if c.BusyTimeout <= 0 {
+		c.BusyTimeout = 5 * time.Second
+	}
+	return c
+}
+
+// Open opens the SQLite database at path with pools sized by cfg, creating
+// and migrating it as needed.
+func Open(path string, cfg PoolConfig) (*DB, error) {
+	cfg = cfg.withDefaults()
+	// The busy timeout is for other processes: instances sharing DATA_DIR,
+	// and `chirm admin` commands run alongside the server.  Write
+	// transactions take their lock up front, as upgrading a read lock
+	// part-way through can fail without waiting.  Foreign keys stay off,
+	// as they always have been: the driver never understood the
+	// _foreign_keys parameter this used to pass.
+	busy := fmt.Sprintf("_pragma=busy_timeout(%d)", cfg.BusyTimeout.Milliseconds())
+	writer, err := sql.Open("sqlite", path+"?_pragma=journal_mode(WAL)&"+busy+"&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	writer.SetMaxOpenConns(1)
+	writer.SetMaxIdleConns(1)
+	writer.SetConnMaxLifetime(cfg.MaxLifetime)
+
+	read, err := sql.Open("sqlite", path+"?"+busy+"&_pragma=query_only(1)")
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	read.SetMaxOpenConns(cfg.MaxOpen)
+	read.SetMaxIdleConns(cfg.MaxIdle)
+	read.SetConnMaxIdleTime(cfg.MaxIdleTime)
+	read.SetConnMaxLifetime(cfg.MaxLifetime)
+
+	d := &DB{DB: writer, read: read}
+	if err := d.migrate(); err != nil {
+		d.Close()
+		return nil, fmt.Errorf("migration failed: %w", err)
+	}
+	return d, nil
+}
+
+// Close closes both pools.
+func (d *DB) Close() error {
+	d.read.Close()
+	return d.DB.Close()
+}
+
+// Query runs a query that returns rows on a reading connection.
+func (d *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return d.read.Query(query, args...)
+}
+
+// QueryRow runs a query that returns at most one row on a reading
+// connection.
+func (d *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return d.read.QueryRow(query, args...)
+}
+
+// vacuumInto copies the database to path on a reading connection, so
+// writes carry on meanwhile.  VACUUM INTO only reads this database, but
+// query_only refuses it all the same.
+func (d *DB) vacuumInto(path string) error {
+	ctx := context.Background()
+	conn, err := d.read.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `PRAGMA query_only = 0`); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, `VACUUM INTO ?`, path)
+	if _, err := conn.ExecContext(ctx, `PRAGMA query_only = 1`); err != nil {
+		// Don't hand a writable connection back to the pool.
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+	return err
+}
+
+// PoolStats is how a connection pool is doing, for /debug/vars.
+type PoolStats struct {
+	MaxOpen      int   `json:"max_open"`
+	Open         int   `json:"open"`
+	InUse        int   `json:"in_use"`
+	Idle         int   `json:"idle"`
+	Waits        int64 `json:"waits"` // for a connection, all told
+	WaitedMS     int64 `json:"waited_ms"`
+	ClosedIdle   int64 `json:"closed_idle"` // by MaxIdle or MaxIdleTime
+	ClosedMaxAge int64 `json:"closed_max_lifetime"`
+}
+
+func poolStats(db *sql.DB) PoolStats {
+	s := db.Stats()
+	return PoolStats{
+		MaxOpen: s.MaxOpenConnections, Open: s.OpenConnections, InUse: s.InUse, Idle: s.Idle,
+		Waits: s.WaitCount, WaitedMS: s.WaitDuration.Milliseconds(),
+		ClosedIdle: s.MaxIdleClosed + s.MaxIdleTimeClosed, ClosedMaxAge: s.MaxLifetimeClosed,
+	}
+}
+
+// Pools reports on the writing connection and the reading pool.
+func (d *DB) Pools() map[string]PoolStats {
+	return map[string]PoolStats{"writer": poolStats(d.DB), "readers": poolStats(d.read)}
+}
@@ -0,0 +1,62 @@
+package db
+
+import "time"
+
+// ─── Link preview cache ───────────────────────────────────────────────────────
+//
+// link_previews keeps fetched link previews across restarts.  The handlers
+// hold the working set in memory and decide what stays; this is only their
+// backing store.
+
+// StoredPreview is a cached link preview, as JSON.
+type StoredPreview struct {
+	URL       string
+	Data      []byte
+	FetchedAt time.Time
+}
+
+// LinkPreviews returns up to limit previews fetched since after, newest
+// first.
+func (d *DB) LinkPreviews(after time.Time, limit int) ([]StoredPreview, error) {
+	rows, err := d.Query(`SELECT url, data, fetched_at FROM link_previews
+		WHERE fetched_at > ? ORDER BY fetched_at DESC LIMIT ?`, after.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []StoredPreview
+	for rows.Next() {
+		var p StoredPreview
+		var data string
+		if err := rows.Scan(&p.URL, &data, &p.FetchedAt); err != nil {
+			return nil, err
+		}
+		p.Data = []byte(data)
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// SaveLinkPreview stores the preview for url, replacing any before it.
+func (d *DB) SaveLinkPreview(url string, data []byte, fetchedAt time.Time) error {
+	_, err := d.Exec(`INSERT INTO link_previews (url, data, fetched_at) VALUES (?, ?, ?)
+		ON CONFLICT(url) DO UPDATE SET data = excluded.data, fetched_at = excluded.fetched_at`,
+		url, string(data), fetchedAt.UTC())
+	return err
+}
+
+// DeleteLinkPreviews removes the previews for urls.
+func (d *DB) DeleteLinkPreviews(urls []string) error {
+	for _, u := range urls {
+		if _, err := d.Exec(`DELETE FROM link_previews WHERE url = ?`, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PruneLinkPreviews removes previews fetched before cutoff.
+func (d *DB) PruneLinkPreviews(cutoff time.Time) error {
+	_, err := d.Exec(`DELETE FROM link_previews WHERE fetched_at <= ?`, cutoff.UTC())
+	return err
+}
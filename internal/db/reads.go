@@ -0,0 +1,83 @@
+package db
+
+import "time"
+
+// ─── Read state ───────────────────────────────────────────────────────────────
+//
+// channel_reads records, per user and channel, the time of the newest message
+// the user has seen there.  Other people's messages after it are unread.  A
+// channel the user has never opened counts from when they joined.
+
+// UnreadMessage is an unread message with just enough to preview it.
+type UnreadMessage struct {
+	ID        string    `json:"id"`
+	ChannelID string    `json:"channel_id"`
+	Author    string    `json:"author"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MarkChannelRead marks everything currently in channelID as read by userID.
+func (d *DB) MarkChannelRead(userID, channelID string) error {
+	_, err := d.Exec(`INSERT INTO channel_reads (user_id, channel_id, last_read_at)
+		VALUES (?, ?, COALESCE((SELECT MAX(created_at) FROM messages WHERE channel_id = ?), CURRENT_TIMESTAMP))
+		ON CONFLICT(user_id, channel_id) DO UPDATE SET last_read_at = MAX(last_read_at, excluded.last_read_at)`,
+		userID, channelID, channelID)
+	return err
+}
+
+// IsMessageRead reports whether userID has read up to messageID.
+func (d *DB) IsMessageRead(userID, messageID string) bool {
+	var read bool
+	d.QueryRow(`SELECT EXISTS(SELECT 1 FROM messages m
+		JOIN channel_reads r ON r.channel_id = m.channel_id AND r.user_id = ?
+		WHERE m.id = ? AND m.created_at <= r.last_read_at)`, userID, messageID).Scan(&read)
+	return read
+}
+
+// UnreadCounts returns channelID → number of unread messages for userID,
+// leaving out channels with none.
+func (d *DB) UnreadCounts(userID string) (map[string]int, error) {
+	rows, err := d.Query(`SELECT m.channel_id, COUNT(*) FROM messages m
+		LEFT JOIN channel_reads r ON r.channel_id = m.channel_id AND r.user_id = ?
+		WHERE m.created_at > COALESCE(r.last_read_at, (SELECT created_at FROM users WHERE id = ?))
+			AND COALESCE(m.user_id, '') != ?
+		GROUP BY m.channel_id`, userID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	for rows.Next() {
+		var ch string
+		var n int
+		if rows.Scan(&ch, &n) == nil {
+			counts[ch] = n
+		}
+	}
+	return counts, rows.Err()
+}
+
+// UnreadMessages returns up to limit of userID's unread messages in
+// channelID, newest first.
+func (d *DB) UnreadMessages(userID, channelID string, limit int) ([]UnreadMessage, error) {
+	rows, err := d.Query(`SELECT m.id, m.channel_id, COALESCE(u.username, ''), m.content, m.created_at FROM messages m
+		LEFT JOIN users u ON u.id = m.user_id
+		LEFT JOIN channel_reads r ON r.channel_id = m.channel_id AND r.user_id = ?
+		WHERE m.channel_id = ?
+			AND m.created_at > COALESCE(r.last_read_at, (SELECT created_at FROM users WHERE id = ?))
+			AND COALESCE(m.user_id, '') != ?
+		ORDER BY m.created_at DESC LIMIT ?`, userID, channelID, userID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var msgs []UnreadMessage
+	for rows.Next() {
+		var m UnreadMessage
+		if rows.Scan(&m.ID, &m.ChannelID, &m.Author, &m.Content, &m.CreatedAt) == nil {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs, rows.Err()
+}
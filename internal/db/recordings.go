@@ -0,0 +1,77 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Recording is a recorded voice session.  Its audio lives in
+// DATA_DIR/recordings/<id>/, one Ogg/Opus file per published track.
+type Recording struct {
+	ID        string     `json:"id"`
+	ChannelID string     `json:"channel_id"`
+	StartedBy string     `json:"started_by"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Files     []string   `json:"files"`
+}
+
+func (d *DB) CreateRecording(channelID, startedBy string) (*Recording, error) {
+	id := NewID()
+	_, err := d.Exec(`INSERT INTO recordings (id, channel_id, started_by) VALUES (?, ?, ?)`, id, channelID, startedBy)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetRecording(id)
+}
+
+// FinishRecording marks a recording as ended and stores its file names.
+func (d *DB) FinishRecording(id string, files []string) error {
+	_, err := d.Exec(`UPDATE recordings SET ended_at = CURRENT_TIMESTAMP, files = ? WHERE id = ?`, strings.Join(files, ","), id)
+	return err
+}
+
+func (d *DB) GetRecording(id string) (*Recording, error) {
+	row := d.QueryRow(`SELECT id, channel_id, started_by, started_at, ended_at, files FROM recordings WHERE id = ?`, id)
+	return scanRecording(row)
+}
+
+func (d *DB) ListRecordings() ([]Recording, error) {
+	rows, err := d.Query(`SELECT id, channel_id, started_by, started_at, ended_at, files FROM recordings ORDER BY started_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Recording
+	for rows.Next() {
+		rec, err := scanRecording(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *rec)
+	}
+	return out, rows.Err()
+}
+
+func (d *DB) DeleteRecording(id string) error {
+	_, err := d.Exec(`DELETE FROM recordings WHERE id = ?`, id)
+	return err
+}
+
+func scanRecording(row interface{ Scan(...interface{}) error }) (*Recording, error) {
+	rec := &Recording{}
+	var ended sql.NullTime
+	var files string
+	if err := row.Scan(&rec.ID, &rec.ChannelID, &rec.StartedBy, &rec.StartedAt, &ended, &files); err != nil {
+		return nil, err
+	}
+	if ended.Valid {
+		rec.EndedAt = &ended.Time
+	}
+	rec.Files = []string{}
+	if files != "" {
+		rec.Files = strings.Split(files, ",")
+	}
+	return rec, nil
+}
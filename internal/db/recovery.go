@@ -0,0 +1,107 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"math/big"
+	"strings"
+)
+
+// ─── Recovery codes ──────────────────────────────────────────────────────────
+//
+// An owner gets a set of one-time codes at setup, shown once, any one of
+// which resets their password if they forget it.  Only the codes' hashes
+// are stored; a new set replaces the old one.
+
+// RecoveryCodeCount is how many codes make a set.
+const RecoveryCodeCount = 10
+
+// recoveryAlphabet leaves out letters and digits easily mistaken for others.
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// normalRecoveryCode is code as it's hashed: lower case, without the dashes
+// and spaces it may have been typed with.
+func normalRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(code)))
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCode makes a code like "k7mqp-3xh2a-wnr9d".
+func newRecoveryCode() (string, error) {
+	var b strings.Builder
+	for i := 0; i < 15; i++ {
+		if i > 0 && i%5 == 0 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(recoveryAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(recoveryAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// NewRecoveryCodes replaces userID's recovery codes with a new set, which
+// it returns; they can't be had again.
+func (d *DB) NewRecoveryCodes(userID string) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+	tx, err := d.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (code_hash, user_id) VALUES (?, ?)`, hashRecoveryCode(code), userID); err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit()
+}
+
+// UseRecoveryCode marks code used and returns whose it was, or
+// sql.ErrNoRows if it isn't an unused code.
+func (d *DB) UseRecoveryCode(code string) (string, error) {
+	hash := hashRecoveryCode(code)
+	var userID string
+	if err := d.QueryRow(`SELECT user_id FROM recovery_codes WHERE code_hash = ? AND used_at IS NULL`, hash).Scan(&userID); err != nil {
+		return "", err
+	}
+	// Two requests racing with the same code: only one marks it.
+	res, err := d.Exec(`UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE code_hash = ? AND used_at IS NULL`, hash)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return "", sql.ErrNoRows
+	}
+	return userID, nil
+}
+
+// RecoveryCodesLeft counts userID's unused recovery codes.
+func (d *DB) RecoveryCodesLeft(userID string) int {
+	var n int
+	d.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID).Scan(&n)
+	return n
+}
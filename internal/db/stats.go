@@ -0,0 +1,240 @@
+package db
+
+import "time"
+
+// ─── Statistics ───────────────────────────────────────────────────────────────
+//
+// daily_stats and channel_daily_stats hold a row per UTC day of figures for
+// the admin dashboard, so showing it doesn't mean counting every message.
+// RefreshStats fills them in from the messages and users tables and from
+// two tallies kept as things happen: user_activity, the days each user was
+// connected, and push_deliveries, how many notifications went out.
+
+// StatsDay is the layout of the days in the stats tables.
+const StatsDay = "2006-01-02"
+
+// activityKeepDays is how long user_activity rows are kept: long enough for
+// the weekly figure of every day RefreshStats recomputes.
+const activityKeepDays = 14
+
+// DailyStats is one day's figures.  StorageBytes is what uploads and the
+// database took up when the day was last refreshed.
+type DailyStats struct {
+	Day               string `json:"day"`
+	Messages          int    `json:"messages"`
+	ActiveUsers       int    `json:"active_users"`
+	WeeklyActiveUsers int    `json:"weekly_active_users"` // in the 7 days to this one
+	NewUsers          int    `json:"new_users"`
+	PushSent          int    `json:"push_sent"`
+	PushFailed        int    `json:"push_failed"`
+	StorageBytes      int64  `json:"storage_bytes"`
+}
+
+// ChannelStats is a channel's message count over some days.
+type ChannelStats struct {
+	ChannelID string `json:"channel_id"`
+	Name      string `json:"name"`
+	Messages  int    `json:"messages"`
+}
+
+// PushStats is how many notifications went out through one kind of push.
+type PushStats struct {
+	Sent   int `json:"sent"`
+	Failed int `json:"failed"`
+}
+
+// RecordActivity notes that userID was around at t.
+func (d *DB) RecordActivity(userID string, t time.Time) error {
+	_, err := d.Exec(`INSERT OR IGNORE INTO user_activity (user_id, day) VALUES (?, ?)`,
+		userID, t.UTC().Format(StatsDay))
+	return err
+}
+
+// RecordPushDelivery counts a notification sent through kind of push.
+func (d *DB) RecordPushDelivery(kind string, delivered bool) error {
+	sent, failed := 1, 0
+	if !delivered {
+		sent, failed = 0, 1
+	}
+	_, err := d.Exec(`INSERT INTO push_deliveries (day, kind, sent, failed) VALUES (?, ?, ?, ?)
+		ON CONFLICT(day, kind) DO UPDATE SET sent = sent + excluded.sent, failed = failed + excluded.failed`,
+		time.Now().UTC().Format(StatsDay), kind, sent, failed)
+	return err
+}
+
+// LatestStatsDay returns the newest day with stats, or "" if there are none.
+func (d *DB) LatestStatsDay() string {
+	var day string
+	d.QueryRow(`SELECT COALESCE(MAX(day), '') FROM daily_stats`).Scan(&day)
+	return day
+}
+
+// RefreshStats recomputes the stats of every day from since to now, and
+// records storageBytes as today's storage use.
+func (d *DB) RefreshStats(since, now time.Time, storageBytes int64) error {
+	from := since.UTC().Format(StatsDay)
+	today := now.UTC().Format(StatsDay)
+	days := map[string]*DailyStats{}
+	for t := since.UTC(); t.Format(StatsDay) <= today; t = t.AddDate(0, 0, 1) {
+		day := t.Format(StatsDay)
+		days[day] = &DailyStats{Day: day}
+	}
+
+	// Count first, so the database is locked only while the rows are written.
+	rows, err := d.Query(`SELECT date(created_at) AS day, channel_id, COUNT(*) FROM messages
+		WHERE created_at >= ? GROUP BY day, channel_id`, from)
+	if err != nil {
+		return err
+	}
+	type channelDay struct {
+		day, channelID string
+		n              int
+	}
+	var perChannel []channelDay
+	for rows.Next() {
+		var c channelDay
+		if rows.Scan(&c.day, &c.channelID, &c.n) == nil {
+			perChannel = append(perChannel, c)
+			if s := days[c.day]; s != nil {
+				s.Messages += c.n
+			}
+		}
+	}
+	rows.Close()
+
+	counts := func(query string, set func(*DailyStats, int)) error {
+		rows, err := d.Query(query, from)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var day string
+			var n int
+			if rows.Scan(&day, &n) == nil && days[day] != nil {
+				set(days[day], n)
+			}
+		}
+		return rows.Err()
+	}
+	if err := counts(`SELECT date(created_at) AS day, COUNT(*) FROM users WHERE created_at >= ? GROUP BY day`,
+		func(s *DailyStats, n int) { s.NewUsers = n }); err != nil {
+		return err
+	}
+	if err := counts(`SELECT day, COUNT(*) FROM user_activity WHERE day >= ? GROUP BY day`,
+		func(s *DailyStats, n int) { s.ActiveUsers = n }); err != nil {
+		return err
+	}
+	if err := counts(`SELECT day, SUM(sent) FROM push_deliveries WHERE day >= ? GROUP BY day`,
+		func(s *DailyStats, n int) { s.PushSent = n }); err != nil {
+		return err
+	}
+	if err := counts(`SELECT day, SUM(failed) FROM push_deliveries WHERE day >= ? GROUP BY day`,
+		func(s *DailyStats, n int) { s.PushFailed = n }); err != nil {
+		return err
+	}
+	for day, s := range days {
+		t, _ := time.Parse(StatsDay, day)
+		d.QueryRow(`SELECT COUNT(DISTINCT user_id) FROM user_activity WHERE day >= ? AND day <= ?`,
+			t.AddDate(0, 0, -6).Format(StatsDay), day).Scan(&s.WeeklyActiveUsers)
+	}
+
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM channel_daily_stats WHERE day >= ?`, from); err != nil {
+		return err
+	}
+	for _, c := range perChannel {
+		if _, err := tx.Exec(`INSERT INTO channel_daily_stats (day, channel_id, messages) VALUES (?, ?, ?)`,
+			c.day, c.channelID, c.n); err != nil {
+			return err
+		}
+	}
+	for day, s := range days {
+		storage := int64(0)
+		if day == today {
+			storage = storageBytes
+		}
+		_, err := tx.Exec(`INSERT INTO daily_stats
+			(day, messages, active_users, weekly_active_users, new_users, push_sent, push_failed, storage_bytes)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(day) DO UPDATE SET messages = excluded.messages, active_users = excluded.active_users,
+				weekly_active_users = excluded.weekly_active_users, new_users = excluded.new_users,
+				push_sent = excluded.push_sent, push_failed = excluded.push_failed,
+				storage_bytes = CASE WHEN excluded.day = ? THEN excluded.storage_bytes ELSE storage_bytes END`,
+			day, s.Messages, s.ActiveUsers, s.WeeklyActiveUsers, s.NewUsers, s.PushSent, s.PushFailed, storage, today)
+		if err != nil {
+			return err
+		}
+	}
+
+	cutoff := now.UTC().AddDate(0, 0, -activityKeepDays).Format(StatsDay)
+	if _, err := tx.Exec(`DELETE FROM user_activity WHERE day < ?`, cutoff); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Stats returns the figures for each day from since on, oldest first.
+func (d *DB) Stats(since time.Time) ([]DailyStats, error) {
+	rows, err := d.Query(`SELECT day, messages, active_users, weekly_active_users, new_users,
+		push_sent, push_failed, storage_bytes FROM daily_stats WHERE day >= ? ORDER BY day ASC`,
+		since.UTC().Format(StatsDay))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := []DailyStats{}
+	for rows.Next() {
+		var s DailyStats
+		if err := rows.Scan(&s.Day, &s.Messages, &s.ActiveUsers, &s.WeeklyActiveUsers, &s.NewUsers,
+			&s.PushSent, &s.PushFailed, &s.StorageBytes); err == nil {
+			stats = append(stats, s)
+		}
+	}
+	return stats, rows.Err()
+}
+
+// TopChannels returns the limit channels with the most messages from since
+// on, busiest first.
+func (d *DB) TopChannels(since time.Time, limit int) ([]ChannelStats, error) {
+	rows, err := d.Query(`SELECT s.channel_id, c.name, SUM(s.messages) AS n
+		FROM channel_daily_stats s JOIN channels c ON c.id = s.channel_id
+		WHERE s.day >= ? GROUP BY s.channel_id ORDER BY n DESC LIMIT ?`,
+		since.UTC().Format(StatsDay), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	top := []ChannelStats{}
+	for rows.Next() {
+		var c ChannelStats
+		if err := rows.Scan(&c.ChannelID, &c.Name, &c.Messages); err == nil {
+			top = append(top, c)
+		}
+	}
+	return top, rows.Err()
+}
+
+// PushStatsByKind returns the notifications sent from since on, by kind of
+// push.
+func (d *DB) PushStatsByKind(since time.Time) (map[string]PushStats, error) {
+	rows, err := d.Query(`SELECT kind, SUM(sent), SUM(failed) FROM push_deliveries WHERE day >= ? GROUP BY kind`,
+		since.UTC().Format(StatsDay))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byKind := map[string]PushStats{}
+	for rows.Next() {
+		var kind string
+		var s PushStats
+		if err := rows.Scan(&kind, &s.Sent, &s.Failed); err == nil {
+			byKind[kind] = s
+		}
+	}
+	return byKind, rows.Err()
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ─── Personal tokens ─────────────────────────────────────────────────────────
+//
+// Anyone can make tokens for themselves, to sign in where a password
+// won't do, such as an IRC client's server password.  A token is
+// "<token ID>.<secret>"; only a hash of the secret is stored, and the token
+// is shown once, when it's made.  Deleting a token, or its user, stops it
+// working.
+
+// PersonalToken is a token, as its owner sees it later.
+type PersonalToken struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+}
+
+// CreatePersonalToken makes a token for userID, returning it and the token
+// itself.
+func (d *DB) CreatePersonalToken(userID, name string) (*PersonalToken, string, error) {
+	id := NewID()
+	token, hash := newBotToken(id)
+	if _, err := d.Exec(`INSERT INTO personal_tokens (id, user_id, name, token_hash) VALUES (?, ?, ?, ?)`,
+		id, userID, name, hash); err != nil {
+		return nil, "", err
+	}
+	t := &PersonalToken{ID: id, Name: name}
+	err := d.QueryRow(`SELECT created_at FROM personal_tokens WHERE id = ?`, id).Scan(&t.CreatedAt)
+	return t, token, err
+}
+
+// UserByPersonalToken returns the user token belongs to, noting that it
+// was used.
+func (d *DB) UserByPersonalToken(token string) (*User, error) {
+	id, secret, found := strings.Cut(strings.TrimSpace(token), ".")
+	if !found || id == "" || secret == "" {
+		return nil, errors.New("malformed token")
+	}
+	var userID string
+	err := d.QueryRow(`SELECT t.user_id FROM personal_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.id = ? AND t.token_hash = ?`, id, hashBotSecret(secret)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("unknown token")
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.Exec(`UPDATE personal_tokens SET last_used = ? WHERE id = ?`, time.Now().UTC(), id)
+	return d.GetUserByID(userID)
+}
+
+// ListPersonalTokens returns userID's tokens, oldest first.
+func (d *DB) ListPersonalTokens(userID string) ([]PersonalToken, error) {
+	rows, err := d.Query(`SELECT id, name, created_at, last_used FROM personal_tokens
+		WHERE user_id = ? ORDER BY created_at ASC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []PersonalToken{}
+	for rows.Next() {
+		var t PersonalToken
+		var lastUsed sql.NullTime
+		if rows.Scan(&t.ID, &t.Name, &t.CreatedAt, &lastUsed) == nil {
+			if lastUsed.Valid {
+				t.LastUsed = &lastUsed.Time
+			}
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+// DeletePersonalToken deletes userID's token id, reporting whether there
+// was one.
+func (d *DB) DeletePersonalToken(userID, id string) (bool, error) {
+	res, err := d.Exec(`DELETE FROM personal_tokens WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
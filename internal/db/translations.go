@@ -0,0 +1,61 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// ─── Translation cache ───────────────────────────────────────────────────────
+//
+// message_translations keeps what the translation service made of a
+// message, so each message is sent off once per language however many
+// members ask.  An entry is only good for the text it was made from.
+
+// Translation is a message's text in another language.
+type Translation struct {
+	MessageID  string    `json:"message_id"`
+	Target     string    `json:"target"`      // the language it's in
+	SourceLang string    `json:"source_lang"` // the language it was in, if the service said
+	Content    string    `json:"content"`
+	Provider   string    `json:"provider"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TranslationHash identifies text in the cache.
+func TranslationHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetTranslation returns messageID's translation into target, made from
+// source, if it's been saved.
+func (d *DB) GetTranslation(messageID, target, source string) (*Translation, error) {
+	t := Translation{MessageID: messageID, Target: target}
+	err := d.QueryRow(`SELECT source_lang, content, provider, created_at FROM message_translations
+		WHERE message_id = ? AND target = ? AND source_hash = ?`, messageID, target, TranslationHash(source)).
+		Scan(&t.SourceLang, &t.Content, &t.Provider, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// SaveTranslation stores t, made from source, replacing any translation of
+// the message into the same language.
+func (d *DB) SaveTranslation(t Translation, source string) error {
+	_, err := d.Exec(`INSERT INTO message_translations (message_id, target, source_hash, source_lang, content, provider, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, target) DO UPDATE SET source_hash = excluded.source_hash, source_lang = excluded.source_lang,
+			content = excluded.content, provider = excluded.provider, created_at = excluded.created_at`,
+		t.MessageID, t.Target, TranslationHash(source), t.SourceLang, t.Content, t.Provider, t.CreatedAt.UTC())
+	return err
+}
+
+// PruneTranslations deletes translations made at or before cutoff, and
+// those of messages since deleted.
+func (d *DB) PruneTranslations(cutoff time.Time) error {
+	_, err := d.Exec(`DELETE FROM message_translations WHERE created_at <= ?
+		OR message_id NOT IN (SELECT id FROM messages)`, cutoff.UTC())
+	return err
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// ─── Webhooks ─────────────────────────────────────────────────────────────────
+//
+// A webhook lets an integration post into one channel without an account,
+// by knowing its token.  Only a hash of the token is stored; the token
+// itself is shown once, when the webhook is created.  Its messages have no
+// user_id but the webhook's ID and the name they were posted under.
+
+// Webhook is a channel's incoming webhook.
+type Webhook struct {
+	ID        string    `json:"id"`
+	ChannelID string    `json:"channel_id"`
+	Name      string    `json:"name"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const webhookColumns = `id, channel_id, name, created_by, created_at`
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (Webhook, error) {
+	var w Webhook
+	err := row.Scan(&w.ID, &w.ChannelID, &w.Name, &w.CreatedBy, &w.CreatedAt)
+	return w, err
+}
+
+func hashWebhookToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateWebhook adds a webhook to channelID, returning it and its token.
+func (d *DB) CreateWebhook(channelID, name, createdBy string) (*Webhook, string, error) {
+	id := NewID()
+	token := NewID() + NewID() + NewID() + NewID()
+	_, err := d.Exec(`INSERT INTO webhooks (id, channel_id, name, token_hash, created_by) VALUES (?, ?, ?, ?, ?)`,
+		id, channelID, name, hashWebhookToken(token), createdBy)
+	if err != nil {
+		return nil, "", err
+	}
+	w, err := d.GetWebhook(id)
+	return w, token, err
+}
+
+func (d *DB) GetWebhook(id string) (*Webhook, error) {
+	w, err := scanWebhook(d.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// WebhookByToken returns the webhook id if token is its token.
+func (d *DB) WebhookByToken(id, token string) (*Webhook, error) {
+	w, err := scanWebhook(d.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = ? AND token_hash = ?`,
+		id, hashWebhookToken(token)))
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// ListWebhooks returns channelID's webhooks, oldest first.
+func (d *DB) ListWebhooks(channelID string) ([]Webhook, error) {
+	rows, err := d.Query(`SELECT `+webhookColumns+` FROM webhooks WHERE channel_id = ? ORDER BY created_at ASC`, channelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := []Webhook{}
+	for rows.Next() {
+		if w, err := scanWebhook(rows); err == nil {
+			hooks = append(hooks, w)
+		}
+	}
+	return hooks, rows.Err()
+}
+
+func (d *DB) DeleteWebhook(id string) error {
+	_, err := d.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	return err
+}
+
+// CreateWebhookMessage stores a message posted through webhook w under
+// name, with its rich embeds (nil for none).
+func (d *DB) CreateWebhookMessage(w *Webhook, name, content string, richEmbeds json.RawMessage) (*Message, error) {
+	id := NewID()
+	var embeds interface{}
+	if richEmbeds != nil {
+		embeds = string(richEmbeds)
+	}
+	_, err := d.Exec(`INSERT INTO messages (id, channel_id, content, webhook_id, author_name, rich_embeds) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, w.ChannelID, content, w.ID, name, embeds)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetMessageByID(id)
+}
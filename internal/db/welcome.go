@@ -0,0 +1,43 @@
+package db
+
+import (
+	"time"
+)
+
+// ─── Welcome ──────────────────────────────────────────────────────────────────
+//
+// A new member can be greeted in a channel of the admins' choosing, and may
+// have to accept the server rules before posting.  rules_acceptances records
+// who has.
+
+// MessageWelcome is the type of the message greeting a new member.
+const MessageWelcome = "welcome"
+
+// CreateWelcomeMessage stores the greeting for userID, who has just joined.
+func (d *DB) CreateWelcomeMessage(channelID, userID, content string) (*Message, error) {
+	id := NewID()
+	_, err := d.Exec(`INSERT INTO messages (id, channel_id, user_id, type, content) VALUES (?, ?, ?, ?, ?)`,
+		id, channelID, userID, MessageWelcome, content)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetMessageByID(id)
+}
+
+// AcceptRules records that userID has accepted the server rules.
+func (d *DB) AcceptRules(userID string) error {
+	_, err := d.Exec(`INSERT INTO rules_acceptances (user_id, accepted_at) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET accepted_at = excluded.accepted_at`, userID, time.Now().UTC())
+	return err
+}
+
+// RulesAcceptedAt returns when userID accepted the rules, or nil if they
+// haven't.
+func (d *DB) RulesAcceptedAt(userID string) *time.Time {
+	var t time.Time
+	err := d.QueryRow(`SELECT accepted_at FROM rules_acceptances WHERE user_id = ?`, userID).Scan(&t)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,339 @@
+// Package discovery lets devices on the local network find Chirm without
+// being told its address.  The server is advertised over multicast DNS
+// (RFC 6762) as a DNS-SD service (RFC 6763) of type _chirm._tcp, which
+// service browsers such as `dns-sd -B _chirm._tcp` and Android's NsdManager
+// list under the server's name.
+//
+// This is a responder only, just big enough for the one service: it
+// answers questions about its own records, announces them when it starts
+// and says goodbye when it stops.  It doesn't probe for name conflicts, so
+// two instances given the same host name will fight over it.
+package discovery
+
+import (
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+// ServiceType is the DNS-SD service type Chirm is advertised as.
+const ServiceType = "_chirm._tcp"
+
+const (
+	// TTLs are RFC 6762's recommendations: 120s for records naming a host
+	// or its addresses, 75 minutes for the rest.
+	hostTTL  = 120
+	otherTTL = 4500
+
+	// cacheFlush marks a record as the only one of its name and type, so
+	// caches drop whatever they had for it (RFC 6762 §10.2).
+	cacheFlush = 1 << 15
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Service is what is advertised.
+type Service struct {
+	Name func() string   // instance name, normally the server name; asked each time, so renames show up
+	Host string          // host label; the service is at Host.local
+	Port int             // port the app is served on
+	TXT  func() []string // key=value pairs for the TXT record
+}
+
+// Responder answers mDNS questions for a Service.
+type Responder struct {
+	svc  Service
+	conn *net.UDPConn
+
+	mu   sync.Mutex
+	name string // instance name last announced
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Start listens for mDNS questions on every interface that can multicast
+// and announces svc.
+func Start(svc Service) (*Responder, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return nil, err
+	}
+	// ListenMulticastUDP joins the group on the default interface only.
+	pc := ipv4.NewPacketConn(conn)
+	if ifaces, err := net.Interfaces(); err == nil {
+		for i := range ifaces {
+			if ifaces[i].Flags&net.FlagUp != 0 && ifaces[i].Flags&net.FlagMulticast != 0 {
+				pc.JoinGroup(&ifaces[i], &net.UDPAddr{IP: mdnsGroup.IP})
+			}
+		}
+	}
+	pc.SetMulticastTTL(255)
+
+	r := &Responder{svc: svc, conn: conn, name: instanceLabel(svc.Name()), done: make(chan struct{})}
+	r.wg.Add(2)
+	go r.serve()
+	go r.watch()
+	return r, nil
+}
+
+// Host returns the name the service's addresses are advertised under.
+func (r *Responder) Host() string {
+	return r.svc.Host + ".local"
+}
+
+// Close says goodbye, so browsers drop the service at once rather than when
+// their cached records run out, and stops answering.
+func (r *Responder) Close() error {
+	close(r.done)
+	r.mu.Lock()
+	r.send(r.records(r.name, 0), mdnsGroup)
+	r.mu.Unlock()
+	err := r.conn.Close()
+	r.wg.Wait()
+	return err
+}
+
+// serve answers questions until the connection is closed.
+func (r *Responder) serve() {
+	defer r.wg.Done()
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-r.done:
+				return
+			default:
+			}
+			slog.Warn("mdns: read failed", "err", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		r.answer(buf[:n], from)
+	}
+}
+
+// watch announces the service twice when it starts, a second apart as RFC
+// 6762 §8.3 asks, and then checks each minute whether the server has been
+// renamed, announcing the new name and retracting the old one if it has.
+func (r *Responder) watch() {
+	defer r.wg.Done()
+	for i := 0; i < 2; i++ {
+		r.mu.Lock()
+		r.send(r.records(r.name, 1), mdnsGroup)
+		r.mu.Unlock()
+		select {
+		case <-r.done:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-tick.C:
+		}
+		name := instanceLabel(r.svc.Name())
+		r.mu.Lock()
+		if name != r.name {
+			r.send(r.records(r.name, 0), mdnsGroup)
+			r.name = name
+			r.send(r.records(r.name, 1), mdnsGroup)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// answer replies to the questions in msg that are about our records.
+// Queries from port 5353 are answered to the group, as everyone's caches
+// may as well learn the answer; others come from simple resolvers
+// (RFC 6762 §6.7) and are answered to the sender alone.
+func (r *Responder) answer(msg []byte, from *net.UDPAddr) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil || h.Response {
+		return
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	all := r.records(r.name, 1)
+	var answers []dnsmessage.Resource
+	for _, q := range questions {
+		for _, rr := range all {
+			if strings.EqualFold(rr.Header.Name.String(), q.Name.String()) &&
+				(q.Type == rr.Header.Type || q.Type == dnsmessage.TypeALL) {
+				answers = append(answers, rr)
+			}
+		}
+	}
+	if len(answers) == 0 {
+		return
+	}
+	// Whoever asked for the service will want the rest of it next, so
+	// it all goes in the one answer.
+	for _, rr := range all {
+		if !containsRecord(answers, rr) {
+			answers = append(answers, rr)
+		}
+	}
+
+	to := mdnsGroup
+	var id uint16
+	if from.Port != mdnsGroup.Port {
+		to, id = from, h.ID
+	}
+	r.sendReply(id, questions, answers, to)
+}
+
+func containsRecord(rrs []dnsmessage.Resource, rr dnsmessage.Resource) bool {
+	for _, x := range rrs {
+		if x.Header.Name == rr.Header.Name && x.Header.Type == rr.Header.Type && x.Body.GoString() == rr.Body.GoString() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Responder) send(answers []dnsmessage.Resource, to *net.UDPAddr) {
+	r.sendReply(0, nil, answers, to)
+}
+
+// sendReply sends answers to to.  A reply to a simple resolver repeats its
+// ID and questions, which mDNS proper does without.
+func (r *Responder) sendReply(id uint16, questions []dnsmessage.Question, answers []dnsmessage.Resource, to *net.UDPAddr) {
+	msg := dnsmessage.Message{
+		Header:  dnsmessage.Header{ID: id, Response: true, Authoritative: true},
+		Answers: answers,
+	}
+	if to != mdnsGroup {
+		msg.Questions = questions
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		slog.Warn("mdns: packing reply failed", "err", err)
+		return
+	}
+	if _, err := r.conn.WriteToUDP(b, to); err != nil {
+		slog.Debug("mdns: send failed", "to", to, "err", err)
+	}
+}
+
+// records returns the service's records for instance name.  ttlScale 0
+// makes them goodbyes.
+func (r *Responder) records(name string, ttlScale uint32) []dnsmessage.Resource {
+	serviceName := mustName(ServiceType + ".local.")
+	instanceName := mustName(name + "." + ServiceType + ".local.")
+	hostName := mustName(r.svc.Host + ".local.")
+	hdr := func(n dnsmessage.Name, t dnsmessage.Type, ttl uint32, unique bool) dnsmessage.ResourceHeader {
+		class := dnsmessage.ClassINET
+		if unique {
+			class |= cacheFlush
+		}
+		return dnsmessage.ResourceHeader{Name: n, Type: t, Class: class, TTL: ttl * ttlScale}
+	}
+
+	txt := r.svc.TXT()
+	if len(txt) == 0 {
+		txt = []string{""} // a TXT record can't be empty
+	}
+	rrs := []dnsmessage.Resource{
+		{Header: hdr(mustName("_services._dns-sd._udp.local."), dnsmessage.TypePTR, otherTTL, false),
+			Body: &dnsmessage.PTRResource{PTR: serviceName}},
+		{Header: hdr(serviceName, dnsmessage.TypePTR, otherTTL, false),
+			Body: &dnsmessage.PTRResource{PTR: instanceName}},
+		{Header: hdr(instanceName, dnsmessage.TypeSRV, hostTTL, true),
+			Body: &dnsmessage.SRVResource{Port: uint16(r.svc.Port), Target: hostName}},
+		{Header: hdr(instanceName, dnsmessage.TypeTXT, otherTTL, true),
+			Body: &dnsmessage.TXTResource{TXT: txt}},
+	}
+	for _, ip := range LANAddrs() {
+		var a [4]byte
+		copy(a[:], ip.To4())
+		rrs = append(rrs, dnsmessage.Resource{Header: hdr(hostName, dnsmessage.TypeA, hostTTL, true),
+			Body: &dnsmessage.AResource{A: a}})
+	}
+	return rrs
+}
+
+func mustName(s string) dnsmessage.Name {
+	n, err := dnsmessage.NewName(s)
+	if err != nil {
+		return dnsmessage.MustNewName("chirm.local.")
+	}
+	return n
+}
+
+// instanceLabel makes a server name fit in one DNS label: dots would split
+// it, and a label is at most 63 bytes.
+func instanceLabel(name string) string {
+	name = strings.TrimSpace(strings.ReplaceAll(name, ".", " "))
+	if name == "" {
+		name = "Chirm"
+	}
+	for len(name) > 63 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// HostLabel turns a machine's host name into the label the service's
+// addresses are advertised under: "myhost" becomes "myhost-chirm", which
+// leaves myhost.local to whatever else answers for it.
+func HostLabel(hostname string) string {
+	hostname, _, _ = strings.Cut(hostname, ".")
+	var b strings.Builder
+	for _, c := range strings.ToLower(hostname) {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' {
+			b.WriteRune(c)
+		}
+	}
+	label := strings.Trim(b.String(), "-")
+	if label == "" {
+		return "chirm"
+	}
+	if len(label) > 57 {
+		label = label[:57]
+	}
+	return label + "-chirm"
+}
+
+// LANAddrs returns the IPv4 addresses of the interfaces that are up, other
+// than loopback.
+func LANAddrs() []net.IP {
+	var ips []net.IP
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil && !ipNet.IP.IsLoopback() {
+				ips = append(ips, ipNet.IP.To4())
+			}
+		}
+	}
+	return ips
+}
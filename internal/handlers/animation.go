@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"mime"
+
+	"chirm/internal/db"
+)
+
+// ─── Animated images ─────────────────────────────────────────────────────────
+//
+// Animated GIF, APNG and WebP emoji and stickers are stored as uploaded,
+// since resizing them would mean re-encoding every frame, so they're held
+// to a size and frame count instead.  Each also gets a still copy of its
+// first frame, "<name>.static.png" (or .webp), for clients that shouldn't
+// animate.  The frames are only counted from the file's structure, never
+// decoded.
+
+// maxAnimationFrames bounds how many frames an animated image may have.
+const maxAnimationFrames = 200
+
+// animation describes an animated image.
+type animation struct {
+	Format        string // "gif", "png" or "webp"
+	Width, Height int
+	Frames        int
+}
+
+// probeAnimation reports whether data is an animated image, reading only
+// headers.  Images with a single frame are not animated.
+func probeAnimation(data []byte) (animation, bool) {
+	var a animation
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		a, err = probeGIF(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		a, err = probeAPNG(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		a, err = probeAnimatedWebP(data)
+	default:
+		return a, false
+	}
+	return a, err == nil && a.Frames > 1
+}
+
+var errBadImage = errors.New("malformed image")
+
+// checkAnimation validates an animated image's size and frame count.
+func checkAnimation(a animation, edge int) error {
+	if a.Width < 1 || a.Height < 1 || a.Width > edge || a.Height > edge {
+		return fmt.Errorf("animated images must be at most %dx%d", edge, edge)
+	}
+	if a.Frames > maxAnimationFrames {
+		return fmt.Errorf("animated images can have at most %d frames", maxAnimationFrames)
+	}
+	return nil
+}
+
+// customImage is an uploaded emoji or sticker ready to be stored.
+type customImage struct {
+	data, still   []byte // still is the first frame of an animation
+	ext, stillExt string
+	animated      bool
+}
+
+// prepareCustomImage shrinks a still image to fit edge, or checks that an
+// animated one fits animatedEdge and takes its first frame, scaled to edge.
+func prepareCustomImage(raw []byte, edge, animatedEdge int) (customImage, error) {
+	var img customImage
+	anim, ok := probeAnimation(raw)
+	if !ok {
+		var err error
+		img.data, img.ext, err = shrinkImage(bytes.NewReader(raw), edge)
+		return img, err
+	}
+	if err := checkAnimation(anim, animatedEdge); err != nil {
+		return img, err
+	}
+	still, stillExt, err := staticFrame(raw, anim, edge)
+	if err != nil {
+		return img, err
+	}
+	img.data, img.ext, img.animated = raw, "."+anim.Format, true
+	img.still, img.stillExt = still, stillExt
+	if anim.Format != "gif" {
+		img.data = stripImageMetadata(raw, "image/"+anim.Format)
+	}
+	return img, nil
+}
+
+// storeCustomImage saves img as "<prefix>_<id><ext>", returning its
+// filename and that of its still frame, if any.
+func (h *Handler) storeCustomImage(prefix string, img customImage) (string, string, error) {
+	id := db.NewID()
+	filename := fmt.Sprintf("%s_%s%s", prefix, id, img.ext)
+	if err := h.files.Put(filename, bytes.NewReader(img.data), int64(len(img.data)), mime.TypeByExtension(img.ext)); err != nil {
+		return "", "", err
+	}
+	if img.still == nil {
+		return filename, "", nil
+	}
+	still := fmt.Sprintf("%s_%s.static%s", prefix, id, img.stillExt)
+	if err := h.files.Put(still, bytes.NewReader(img.still), int64(len(img.still)), mime.TypeByExtension(img.stillExt)); err != nil {
+		h.files.Delete(filename)
+		return "", "", err
+	}
+	return filename, still, nil
+}
+
+// probeGIF counts the image descriptors in a GIF.
+func probeGIF(data []byte) (animation, error) {
+	a := animation{Format: "gif"}
+	if len(data) < 13 {
+		return a, errBadImage
+	}
+	a.Width = int(binary.LittleEndian.Uint16(data[6:]))
+	a.Height = int(binary.LittleEndian.Uint16(data[8:]))
+	i := 13
+	if data[10]&0x80 != 0 { // global colour table
+		i += 3 << (data[10]&7 + 1)
+	}
+	// skipBlocks steps over a run of data sub-blocks.
+	skipBlocks := func() bool {
+		for i < len(data) {
+			n := int(data[i])
+			i += 1 + n
+			if n == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension: label, then sub-blocks
+			i += 2
+			if !skipBlocks() {
+				return a, errBadImage
+			}
+		case 0x2C: // image descriptor
+			if i+10 > len(data) {
+				return a, errBadImage
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 { // local colour table
+				i += 3 << (flags&7 + 1)
+			}
+			i++ // LZW minimum code size
+			if !skipBlocks() {
+				return a, errBadImage
+			}
+			a.Frames++
+		case 0x3B: // trailer
+			return a, nil
+		default:
+			return a, errBadImage
+		}
+	}
+	// Plenty of GIFs in the wild lack a trailer.
+	return a, nil
+}
+
+// probeAPNG reads the frame count from a PNG's acTL chunk, which must come
+// before the image data for the file to be animated.
+func probeAPNG(data []byte) (animation, error) {
+	a := animation{Format: "png"}
+	for i := 8; i+8 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		body := i + 8
+		if n < 0 || body+n > len(data) {
+			return a, errBadImage
+		}
+		switch string(data[i+4 : i+8]) {
+		case "IHDR":
+			if n < 8 {
+				return a, errBadImage
+			}
+			a.Width = int(binary.BigEndian.Uint32(data[body:]))
+			a.Height = int(binary.BigEndian.Uint32(data[body+4:]))
+		case "acTL":
+			if n < 8 {
+				return a, errBadImage
+			}
+			a.Frames = int(binary.BigEndian.Uint32(data[body:]))
+		case "IDAT", "IEND":
+			return a, nil
+		}
+		i = body + n + 4 // and the CRC
+	}
+	return a, errBadImage
+}
+
+// webpChunks calls fn with the type and payload of each chunk in a RIFF
+// body, stopping early if fn returns false.
+func webpChunks(data []byte, fn func(fourcc string, payload []byte) bool) error {
+	for i := 0; i < len(data); {
+		if i+8 > len(data) {
+			return errBadImage
+		}
+		n := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + n
+		if n < 0 || end > len(data) {
+			return errBadImage
+		}
+		if !fn(string(data[i:i+4]), data[i+8:end]) {
+			return nil
+		}
+		i = end + n&1 // chunks are padded to even sizes
+	}
+	return nil
+}
+
+// probeAnimatedWebP counts the ANMF chunks of a WebP whose extended header
+// has the animation flag set.
+func probeAnimatedWebP(data []byte) (animation, error) {
+	a := animation{Format: "webp"}
+	animated := false
+	err := webpChunks(data[12:], func(fourcc string, payload []byte) bool {
+		switch fourcc {
+		case "VP8X":
+			if len(payload) < 10 {
+				return false
+			}
+			animated = payload[0]&0x02 != 0
+			le24 := func(p []byte) int { return int(p[0]) | int(p[1])<<8 | int(p[2])<<16 }
+			a.Width, a.Height = le24(payload[4:])+1, le24(payload[7:])+1
+		case "ANMF":
+			a.Frames++
+		}
+		return true
+	})
+	if err != nil {
+		return a, err
+	}
+	if !animated {
+		a.Frames = 1
+	}
+	return a, nil
+}
+
+// staticFrame returns a still image of an animation's first frame, fitted
+// to edge unless it's WebP, and the extension to store it under.
+func staticFrame(data []byte, a animation, edge int) ([]byte, string, error) {
+	if a.Format == "webp" {
+		frame, err := firstWebPFrame(data)
+		return frame, ".webp", err
+	}
+	// The standard decoders return a GIF's first frame and an APNG's
+	// default image.
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, a.Width, a.Height))
+	draw.Draw(canvas, src.Bounds(), src, src.Bounds().Min, draw.Over)
+	if w, h, smaller := fitWithin(a.Width, a.Height, edge); smaller {
+		canvas = downscale(canvas, w, h)
+	}
+	var buf bytes.Buffer
+	err = png.Encode(&buf, canvas)
+	return buf.Bytes(), ".png", err
+}
+
+// firstWebPFrame rewraps the bitstream of an animated WebP's first frame
+// as a still WebP, which needs no decoding.
+func firstWebPFrame(data []byte) ([]byte, error) {
+	var frame []byte
+	webpChunks(data[12:], func(fourcc string, payload []byte) bool {
+		if fourcc == "ANMF" && len(payload) >= 16 {
+			frame = payload
+			return false
+		}
+		return true
+	})
+	if frame == nil {
+		return nil, errBadImage
+	}
+	// ANMF: x, y, width-1, height-1 and duration as 24-bit values and a
+	// flags byte, then the frame's ALPH/VP8/VP8L chunks.
+	size, body := frame[6:12], frame[16:]
+	var flags byte
+	if err := webpChunks(body, func(fourcc string, _ []byte) bool {
+		if fourcc == "ALPH" || fourcc == "VP8L" {
+			flags |= 0x10 // alpha
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString("RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00")
+	out.Write([]byte{flags, 0, 0, 0})
+	out.Write(size)
+	out.Write(body)
+	b := out.Bytes()
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	return b, nil
+}
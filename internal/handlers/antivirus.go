@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"chirm/internal/db"
+	"chirm/internal/logging"
+)
+
+// ─── Upload scanning ─────────────────────────────────────────────────────────
+//
+// Servers open to the public can have attachments checked for malware
+// before anyone else can download them.  The operator points Chirm at a
+// clamd daemon or a scanning command (CLAMD_ADDRESS / AV_SCAN_COMMAND);
+// admins then choose with the "scan_uploads" setting whether scanning is
+// "on" (the default once a scanner is set up), "off", or "strict", which
+// also refuses uploads while the scanner can't be reached.  Flagged files
+// are kept in DATA_DIR/quarantine, never in the uploads store, and admins
+// are told about them.  The scanner itself isn't a setting: a command an
+// admin could change from the web would let any admin run programs on the
+// server.
+
+// scanTimeout bounds a single scan.
+const scanTimeout = 2 * time.Minute
+
+// A Scanner checks a file for malware.
+type Scanner interface {
+	// Scan reads r and returns the name of what it found, or "" if the
+	// file is clean.
+	Scan(ctx context.Context, r io.Reader) (string, error)
+}
+
+// SetScanner enables upload scanning with s.
+func (h *Handler) SetScanner(s Scanner) {
+	h.scanner = s
+}
+
+// scanMode returns the "scan_uploads" setting, or "off" without a scanner.
+func (h *Handler) scanMode() string {
+	if h.scanner == nil {
+		return "off"
+	}
+	switch v, _ := h.db.GetSetting("scan_uploads"); v {
+	case "off", "strict":
+		return v
+	}
+	return "on"
+}
+
+// errInfected is returned by scanUpload for quarantined files.
+type errInfected struct{ signature string }
+
+func (e errInfected) Error() string { return "file rejected: " + e.signature }
+
+// errScannerDown is returned by scanUpload in strict mode when the scanner
+// fails.
+var errScannerDown = errors.New("upload scanning is unavailable, try again later")
+
+// scanUpload checks an upload from u before it's stored.  Infected files
+// are quarantined and reported to admins; the error says why the upload
+// must be refused.
+func (h *Handler) scanUpload(ctx context.Context, u *db.User, originalName string, src io.ReadSeeker) error {
+	mode := h.scanMode()
+	if mode == "off" {
+		return nil
+	}
+	log := logging.FromContext(ctx)
+	ctx, cancel := context.WithTimeout(ctx, scanTimeout)
+	defer cancel()
+	src.Seek(0, io.SeekStart)
+	signature, err := h.scanner.Scan(ctx, src)
+	src.Seek(0, io.SeekStart)
+	if err != nil {
+		log.Warn("upload scan failed", "file", originalName, "user", u.Username, "err", err)
+		if mode == "strict" {
+			return errScannerDown
+		}
+		return nil
+	}
+	if signature == "" {
+		return nil
+	}
+
+	log.Warn("upload quarantined", "file", originalName, "user", u.Username, "signature", signature)
+	if err := h.quarantine(u, originalName, signature, src); err != nil {
+		log.Error("quarantine", "file", originalName, "err", err)
+	}
+	h.notifyQuarantine(u, originalName, signature)
+	return errInfected{signature}
+}
+
+// quarantine keeps a flagged upload, with a note of where it came from,
+// for admins to inspect on the server.
+func (h *Handler) quarantine(u *db.User, originalName, signature string, src io.Reader) error {
+	dir := filepath.Join(h.dataDir, "quarantine")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	base := filepath.Join(dir, time.Now().UTC().Format("20060102-150405")+"-"+newID())
+	f, err := os.OpenFile(base+".bin", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	info, _ := json.MarshalIndent(map[string]string{
+		"original_name": originalName,
+		"user_id":       u.ID,
+		"username":      u.Username,
+		"signature":     signature,
+		"time":          time.Now().UTC().Format(time.RFC3339),
+	}, "", "  ")
+	return os.WriteFile(base+".json", info, 0600)
+}
+
+// notifyQuarantine tells every admin about a quarantined upload, in the app
+// and by push.
+func (h *Handler) notifyQuarantine(u *db.User, originalName, signature string) {
+	users, err := h.db.ListUsers()
+	if err != nil {
+		return
+	}
+	evt := WSEvent{Type: "upload.quarantined", Data: map[string]string{
+		"user_id":       u.ID,
+		"username":      u.Username,
+		"original_name": originalName,
+		"signature":     signature,
+	}}
+	for i := range users {
+		admin := &users[i]
+		if !h.db.HasPermission(admin, db.PermManageServer) {
+			continue
+		}
+		h.hub.SendToUser(admin.ID, evt)
+		go pushToUser(h.db, admin.ID, PushPayload{
+			Tag:    "chirm-quarantine",
+			Key:    pushKeyQuarantine,
+			Params: map[string]string{"user": u.Username, "file": originalName, "signature": signature},
+		})
+	}
+}
+
+// ─── clamd ───────────────────────────────────────────────────────────────────
+
+// ClamdScanner streams files to a ClamAV daemon.
+type ClamdScanner struct {
+	network, addr string
+}
+
+// NewClamdScanner returns a scanner for the clamd at address: a Unix
+// socket path ("/run/clamav/clamd.ctl" or "unix:/…") or "host:port"
+// ("tcp:host:port").
+func NewClamdScanner(address string) *ClamdScanner {
+	switch {
+	case strings.HasPrefix(address, "unix:"):
+		return &ClamdScanner{"unix", strings.TrimPrefix(address, "unix:")}
+	case strings.HasPrefix(address, "tcp:"):
+		return &ClamdScanner{"tcp", strings.TrimPrefix(address, "tcp:")}
+	case strings.HasPrefix(address, "/"):
+		return &ClamdScanner{"unix", address}
+	}
+	return &ClamdScanner{"tcp", address}
+}
+
+// Describe names the daemon for logs.
+func (c *ClamdScanner) Describe() string {
+	return "clamd at " + c.addr
+}
+
+// clamdChunk is how much of the file each INSTREAM chunk carries.
+const clamdChunk = 64 << 10
+
+func (c *ClamdScanner) Scan(ctx context.Context, r io.Reader) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 4+clamdChunk)
+	for {
+		n, rerr := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				// clamd hangs up once a stream passes its size limit
+				// and says so in its reply.
+				break
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return "", rerr
+		}
+	}
+	conn.Write([]byte{0, 0, 0, 0})
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	reply = strings.TrimRight(reply, "\x00\n")
+	// "stream: OK", "stream: Eicar-Signature FOUND" or "… ERROR"
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd: %s", reply)
+}
+
+// ─── External command ────────────────────────────────────────────────────────
+
+// CommandScanner runs a program on each file, given its path as the last
+// argument.  Like clamscan, it should exit 0 for clean files and 1 for
+// infected ones, printing what it found; any other exit is an error.
+type CommandScanner struct {
+	argv []string
+}
+
+// NewCommandScanner parses a command line such as
+// "clamdscan --no-summary --fdpass".  Arguments are split on spaces.
+func NewCommandScanner(command string) (*CommandScanner, error) {
+	argv := strings.Fields(command)
+	if len(argv) == 0 {
+		return nil, errors.New("empty scan command")
+	}
+	if _, err := exec.LookPath(argv[0]); err != nil {
+		return nil, err
+	}
+	return &CommandScanner{argv: argv}, nil
+}
+
+// Describe names the command for logs.
+func (c *CommandScanner) Describe() string {
+	return c.argv[0]
+}
+
+func (c *CommandScanner) Scan(ctx context.Context, r io.Reader) (string, error) {
+	tmp, err := os.CreateTemp("", "chirm-scan-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	tmp.Close()
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.CommandContext(ctx, c.argv[0], append(c.argv[1:], tmp.Name())...)
+	out, err := cmd.CombinedOutput()
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return "", nil
+	case errors.As(err, &exit) && exit.ExitCode() == 1:
+		return commandSignature(string(out), tmp.Name(), c.argv[0]), nil
+	}
+	return "", fmt.Errorf("%s: %w: %s", c.argv[0], err, strings.TrimSpace(string(out)))
+}
+
+// commandSignature picks the finding out of a scanner's output, e.g.
+// "/tmp/chirm-scan-1: Eicar-Signature FOUND".
+func commandSignature(out, path, command string) string {
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), path+":"))
+		if strings.HasSuffix(line, " FOUND") {
+			return strings.TrimSuffix(line, " FOUND")
+		}
+	}
+	return "flagged by " + filepath.Base(command)
+}
@@ -0,0 +1,421 @@
+package handlers
+
+import (
+	"net/http"
+
+	"chirm/internal/db"
+	"chirm/internal/jobs"
+	"chirm/internal/openapi"
+)
+
+// ─── API reference ───────────────────────────────────────────────────────────
+//
+// The routes themselves come from the router; this table adds what a route
+// can't say about itself: a summary, and the types it reads and writes.
+// Request types are the structs the handlers decode.  Where a handler
+// answers with a map, the struct below describes the map.
+
+type authResponse struct {
+	User  db.User `json:"user"`
+	Token string  `json:"token"` // send as "Authorization: Bearer <token>"
+}
+
+type registerResponse struct {
+	authResponse
+	Welcome string `json:"welcome,omitempty"` // the private welcome, if the server has one
+}
+
+type setupResponse struct {
+	authResponse
+	RecoveryCodes []string `json:"recovery_codes"` // the owner's, shown this once
+}
+
+type messageResponse struct {
+	Message string `json:"message"`
+}
+
+type statusResponse struct {
+	Status string `json:"status"`
+}
+
+type setupStatusResponse struct {
+	SetupDone bool `json:"setup_done"`
+}
+
+type inviteInfo struct {
+	Valid      bool   `json:"valid"`
+	Code       string `json:"code"`
+	ServerName string `json:"server_name"`
+	GuildID    string `json:"guild_id,omitempty"`   // for an invite to a guild other than the default
+	GuildName  string `json:"guild_name,omitempty"` // ditto
+}
+
+type reactionsResponse struct {
+	MessageID string        `json:"message_id"`
+	ChannelID string        `json:"channel_id"`
+	Reactions []db.Reaction `json:"reactions"`
+}
+
+type webhookCreated struct {
+	Webhook db.Webhook `json:"webhook"`
+	Token   string     `json:"token"`
+	URL     string     `json:"url"` // path to post to; shown only now
+}
+
+type uploadFailure struct {
+	Index  int    `json:"index"`
+	Total  int    `json:"total"`
+	Name   string `json:"name"`
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+type uploadBatchResponse struct {
+	Attachments []db.Attachment `json:"attachments"`
+	Errors      []uploadFailure `json:"errors"`
+}
+
+type iceServersResponse struct {
+	ICEServers []iceServer `json:"ice_servers"`
+	TTL        int         `json:"ttl"` // seconds the TURN credentials last
+}
+
+type voiceRoomsResponse struct {
+	Rooms map[string][]string `json:"rooms"` // channel ID → user IDs
+}
+
+type broadcastState struct {
+	ChannelID  string `json:"channel_id"`
+	StreamerID string `json:"streamer_id"` // "" when nobody is live
+}
+
+type stageResponse struct {
+	ChannelID string   `json:"channel_id"`
+	Speakers  []string `json:"speakers"`
+	Hands     []string `json:"hands"`
+}
+
+type unreadResponse struct {
+	Total         int             `json:"total"`
+	Channels      []ChannelUnread `json:"channels"`
+	Notifications []PushPayload   `json:"notifications"`
+}
+
+type testPushResponse struct {
+	Sent          int    `json:"sent"`
+	Subscriptions int    `json:"subscriptions,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+type vapidKeyResponse struct {
+	PublicKey string `json:"public_key"`
+}
+
+type pushPlatformsResponse struct {
+	Platforms []string `json:"platforms"`
+}
+
+type pushLanguagesResponse struct {
+	Languages []PushLanguage `json:"languages"`
+	Default   string         `json:"default"`
+}
+
+type iconResponse struct {
+	Icon string `json:"icon"`
+}
+
+type loginBgResponse struct {
+	Bg string `json:"bg"`
+}
+
+// guildQuery documents the ?guild= parameter of routes that list one
+// guild's things.
+var guildQuery = map[string]string{"guild": "guild ID; the default guild if left out"}
+
+// APIDocs describes the API's routes, keyed "METHOD /path".
+func APIDocs() map[string]openapi.Operation {
+	created := http.StatusCreated
+	return map[string]openapi.Operation{
+		// Setup and sign-in
+		"GET /setup/status":    {Tag: "Setup", Public: true, Summary: "Whether the server has been set up", Response: setupStatusResponse{}},
+		"GET /setup/templates": {Tag: "Setup", Public: true, Summary: "Starting layouts of roles and channels, for setup or a guild", Response: []ServerTemplate{}},
+		"POST /setup":          {Tag: "Setup", Public: true, Summary: "Set up the server and create its owner", Request: SetupRequest{}, Status: created, Response: setupResponse{}},
+		"POST /auth/login":     {Tag: "Auth", Public: true, Summary: "Sign in with a username or email", Request: LoginRequest{}, Response: authResponse{}},
+		"POST /auth/register": {Tag: "Auth", Public: true, Summary: "Create an account",
+			Description: "invite_code is needed unless the server allows open registration.",
+			Request:     RegisterRequest{}, Status: created, Response: registerResponse{}},
+		"POST /auth/logout": {Tag: "Auth", Public: true, Summary: "Clear the session cookie", Response: messageResponse{}},
+		"POST /auth/recover": {Tag: "Auth", Public: true, Summary: "Set an owner's password with a recovery code, and sign in (rate-limited)",
+			Request: RecoverRequest{}, Response: recoverResponse{}},
+		"GET /join/{code}":     {Tag: "Auth", Public: true, Summary: "Check an invite code", Response: inviteInfo{}},
+		"GET /public-settings": {Tag: "Settings", Public: true, Summary: "Server name, branding and sign-up options", Response: map[string]string{}},
+		"GET /maintenance":     {Tag: "Settings", Public: true, Summary: "Whether the server is in maintenance mode", Response: MaintenanceState{}},
+		"PUT /maintenance":     {Tag: "Settings", Summary: "Turn maintenance mode on or off (admin)", Request: MaintenanceState{}, Response: MaintenanceState{}},
+		"GET /read-only":       {Tag: "Settings", Public: true, Summary: "Whether the server is read-only", Response: ReadOnlyState{}},
+		"PUT /read-only":       {Tag: "Settings", Summary: "Turn read-only mode on or off (admin)", Request: ReadOnlyState{}, Response: ReadOnlyState{}},
+		"GET /client-version":  {Tag: "Settings", Public: true, Summary: "The web app build this server serves, and the oldest that still works", Response: ClientVersion{}},
+		"GET /discovery":       {Tag: "Settings", Public: true, Summary: "How to reach this server, for apps that found it on the LAN", Response: Discovery{}},
+		"GET /preview":         {Tag: "Settings", Public: true, Summary: "What the server is and how busy, for invite pages (rate-limited)", Response: ServerPreview{}},
+
+		// Account
+		"GET /me":                 {Tag: "Account", Summary: "The signed-in user", Response: db.User{}},
+		"PUT /me":                 {Tag: "Account", Summary: "Change your username or avatar", Request: UpdateMeRequest{}, Response: db.User{}},
+		"POST /me/avatar":         {Tag: "Account", Summary: "Upload an avatar image", Form: map[string]string{"avatar": "image"}, Files: []string{"avatar"}, Response: db.User{}},
+		"GET /me/rules":           {Tag: "Account", Summary: "Whether you must accept the server rules before posting", Response: RulesStatus{}},
+		"POST /me/rules/accept":   {Tag: "Account", Summary: "Accept the server rules", Response: RulesStatus{}},
+		"GET /me/notifications":   {Tag: "Account", Summary: "Your notification settings", Response: db.NotificationSettings{}},
+		"PUT /me/notifications":   {Tag: "Account", Summary: "Change your notification settings", Description: "Fields left out keep their defaults.", Request: db.NotificationSettings{}, Response: db.NotificationSettings{}},
+		"GET /me/recovery-codes":  {Tag: "Account", Summary: "How many recovery codes you have left (owners)", Response: recoveryCodesStatus{}},
+		"POST /me/recovery-codes": {Tag: "Account", Summary: "Replace your recovery codes with a new set (owners)", Request: NewRecoveryCodesRequest{}, Response: recoveryCodes{}},
+		"GET /me/tokens":          {Tag: "Account", Summary: "Your personal tokens", Response: []db.PersonalToken{}},
+		"POST /me/tokens": {Tag: "Account", Summary: "Make a personal token",
+			Description: "For signing in where a password won't do, such as an IRC client's server password. The token is only returned this once.",
+			Request:     CreatePersonalTokenRequest{}, Status: created, Response: personalTokenCreated{}},
+		"DELETE /me/tokens/{id}": {Tag: "Account", Summary: "Delete a personal token", Description: "IRC connections signed in with it are closed.", Response: messageResponse{}},
+		"GET /me/oauth":          {Tag: "Account", Summary: "Apps you've let sign you in with Chirm", Response: []db.OAuthConsent{}},
+		"DELETE /me/oauth/{clientId}": {Tag: "Account", Summary: "Sign an app out",
+			Description: "Its tokens stop working, and it has to ask again next time.", Response: messageResponse{}},
+		"GET /members": {Tag: "Users", Summary: "A guild's members", Query: guildQuery, Response: []PublicUser{}},
+
+		// Guilds
+		"GET /guilds":  {Tag: "Guilds", Summary: "The guilds you're in", Description: "The default guild, which everyone is in, comes first.", Response: []db.Guild{}},
+		"POST /guilds": {Tag: "Guilds", Summary: "Create a guild (admin)", Description: "You become its owner. It starts with an @everyone role and a #general channel.", Request: GuildRequest{}, Status: created, Response: db.Guild{}},
+		"PUT /guilds/{id}": {Tag: "Guilds", Summary: "Rename a guild",
+			Description: "Needs Manage Server in the guild. For the default guild this sets the server name and description.",
+			Request:     GuildRequest{}, Response: db.Guild{}},
+		"DELETE /guilds/{id}": {Tag: "Guilds", Summary: "Delete a guild and everything in it", Description: "Only its owner or the instance owner may.", Response: messageResponse{}},
+		"POST /guilds/{id}/template": {Tag: "Guilds", Summary: "Add a template's roles, categories and channels to a guild (admin)",
+			Description: "Roles, categories and channels whose names the guild already has are left as they are.",
+			Request:     ApplyTemplateRequest{}, Response: templateResult{}},
+		"DELETE /guilds/{id}/members/{userId}": {Tag: "Guilds", Summary: "Leave a guild, or remove someone from it",
+			Description: "Removing someone else needs Manage Server in the guild.", Response: messageResponse{}},
+		"POST /guilds/join/{code}": {Tag: "Guilds", Summary: "Join the guild an invite is for", Response: db.Guild{}},
+
+		// Channels
+		"GET /channels":                            {Tag: "Channels", Summary: "List a guild's channels", Query: guildQuery, Response: []db.Channel{}},
+		"POST /channels":                           {Tag: "Channels", Summary: "Create a channel", Request: CreateChannelRequest{}, Status: created, Response: db.Channel{}},
+		"PUT /channels/{id}":                       {Tag: "Channels", Summary: "Change a channel", Request: UpdateChannelRequest{}, Response: db.Channel{}},
+		"DELETE /channels/{id}":                    {Tag: "Channels", Summary: "Delete a channel", Response: messageResponse{}},
+		"POST /channels/reorder":                   {Tag: "Channels", Summary: "Move channels", Request: []ChannelPosition{}, Response: messageResponse{}},
+		"GET /channels/{id}/overrides":             {Tag: "Channels", Summary: "A channel's permission overrides", Response: []db.ChannelOverride{}},
+		"PUT /channels/{id}/overrides/{roleId}":    {Tag: "Channels", Summary: "Set a role's permissions in a channel", Request: SetChannelOverrideRequest{}, Response: db.ChannelOverride{}},
+		"DELETE /channels/{id}/overrides/{roleId}": {Tag: "Channels", Summary: "Remove a role's override", Response: messageResponse{}},
+		"GET /channel-categories":                  {Tag: "Channels", Summary: "List a guild's categories", Query: guildQuery, Response: []db.ChannelCategory{}},
+		"POST /channel-categories":                 {Tag: "Channels", Summary: "Create a category", Request: CreateCategoryRequest{}, Status: created, Response: db.ChannelCategory{}},
+		"POST /channel-categories/reorder":         {Tag: "Channels", Summary: "Move categories", Request: []CategoryPosition{}, Response: messageResponse{}},
+		"PUT /channel-categories/{id}":             {Tag: "Channels", Summary: "Rename a category", Request: UpdateCategoryRequest{}, Response: messageResponse{}},
+		"DELETE /channel-categories/{id}":          {Tag: "Channels", Summary: "Delete a category", Response: messageResponse{}},
+
+		// Messages
+		"GET /channels/{id}/messages": {Tag: "Messages", Summary: "Read a channel's messages, newest last",
+			Query:    map[string]string{"before": "message ID to page back from", "limit": "1-100, default 50"},
+			Response: []db.Message{}},
+		"POST /channels/{id}/messages":            {Tag: "Messages", Summary: "Send a message", Request: SendMessageRequest{}, Status: created, Response: db.Message{}},
+		"POST /channels/{id}/read":                {Tag: "Messages", Summary: "Mark a channel read", Response: statusResponse{}},
+		"PUT /messages/{id}":                      {Tag: "Messages", Summary: "Edit a message", Request: EditMessageRequest{}, Response: db.Message{}},
+		"DELETE /messages/{id}":                   {Tag: "Messages", Summary: "Delete a message", Response: messageResponse{}},
+		"POST /messages/{id}/reactions":           {Tag: "Messages", Summary: "React to a message", Request: AddReactionRequest{}, Response: reactionsResponse{}},
+		"DELETE /messages/{id}/reactions/{emoji}": {Tag: "Messages", Summary: "Take back a reaction", Response: reactionsResponse{}},
+		"GET /link-preview":                       {Tag: "Messages", Summary: "Preview a link", Query: map[string]string{"url": "the link"}, Response: LinkPreview{}},
+		"GET /image-proxy":                        {Tag: "Messages", Summary: "Fetch a preview image through the server", Query: map[string]string{"url": "the image"}, ContentType: "image/*"},
+		"POST /messages/{id}/translate": {Tag: "Messages", Summary: "Translate a message",
+			Description: "Through LibreTranslate or DeepL, as admins set up; asking again for the same language is answered from the cache. 503 when translation isn't set up.",
+			Request:     TranslateMessageRequest{}, Response: db.Translation{}},
+		"GET /gifs/search": {Tag: "Messages", Summary: "Search for GIFs",
+			Description: "Searches Tenor or Giphy, as admins set up, from the server; an empty q gives popular GIFs. 503 when GIF search isn't set up.",
+			Query:       map[string]string{"q": "what to search for", "pos": "the next of the page before"}, Response: GIFResults{}},
+
+		// Webhooks
+		"GET /channels/{id}/webhooks":  {Tag: "Webhooks", Summary: "A channel's webhooks", Response: []db.Webhook{}},
+		"POST /channels/{id}/webhooks": {Tag: "Webhooks", Summary: "Create a webhook", Description: "The token is only ever shown here.", Request: CreateWebhookRequest{}, Status: created, Response: webhookCreated{}},
+		"DELETE /webhooks/{id}":        {Tag: "Webhooks", Summary: "Delete a webhook", Response: messageResponse{}},
+		"GET /channels/{id}/feeds":     {Tag: "Webhooks", Summary: "The RSS and Atom feeds a channel follows", Response: []db.Feed{}},
+		"POST /channels/{id}/feeds": {Tag: "Webhooks", Summary: "Follow a feed in a channel",
+			Description: "The feed is fetched at once; one that can't be read gets 400. Its latest item is posted, and new items after that every 10 minutes.",
+			Request:     CreateFeedRequest{}, Status: created, Response: db.Feed{}},
+		"DELETE /feeds/{id}": {Tag: "Webhooks", Summary: "Stop following a feed", Response: messageResponse{}},
+		"POST /webhooks/{id}/{token}": {Tag: "Webhooks", Public: true, Summary: "Post as a webhook",
+			Description: "The token in the path is the key; no sign-in is needed. A Slack incoming webhook payload (text, blocks and attachments), as JSON or a form's payload field, is taken too, and answered with a plain \"ok\".",
+			Request:     ExecuteWebhookRequest{}, Status: created, Response: db.Message{}},
+
+		// Federation
+		"GET /federation": {Tag: "Federation", Public: true, Summary: "This server's name and public key, for other servers to link to", Response: FederationInfo{}},
+		"POST /federation/inbox": {Tag: "Federation", Public: true, Summary: "Take an event from a linked server",
+			Description: "Signed by the sending server with X-Chirm-Server, X-Chirm-Timestamp and X-Chirm-Signature, an Ed25519 signature of the timestamp, a newline and the body.",
+			Request:     FederationEvent{}, Response: messageResponse{}},
+		"GET /channels/{id}/federation": {Tag: "Federation", Summary: "A channel's links to channels on other servers", Response: []db.FederationLink{}},
+		"POST /channels/{id}/federation": {Tag: "Federation", Summary: "Link a channel with one on another server",
+			Description: "The other server is asked for its key, which is pinned to the link; 502 if it can't be reached. Nothing arrives until its admins link back.",
+			Request:     CreateFederationLinkRequest{}, Status: created, Response: db.FederationLink{}},
+		"DELETE /federation/links/{id}": {Tag: "Federation", Summary: "Unlink a channel", Response: messageResponse{}},
+
+		// Events
+		"GET /events": {Tag: "Events", Summary: "A guild's events that are on now or still to come", Query: guildQuery, Response: []db.Event{}},
+		"POST /events": {Tag: "Events", Summary: "Schedule an event", Description: "Needs Manage Events in the guild.",
+			Request: EventRequest{}, Status: created, Response: db.Event{}},
+		"GET /events/{id}":       {Tag: "Events", Summary: "An event", Response: db.Event{}},
+		"PUT /events/{id}":       {Tag: "Events", Summary: "Change an event", Description: "Needs Manage Events in its guild.", Request: EventRequest{}, Response: db.Event{}},
+		"DELETE /events/{id}":    {Tag: "Events", Summary: "Cancel an event", Description: "Needs Manage Events in its guild.", Response: messageResponse{}},
+		"PUT /events/{id}/rsvp":  {Tag: "Events", Summary: "Say whether you're coming", Request: RSVPRequest{}, Response: db.Event{}},
+		"GET /events/{id}/rsvps": {Tag: "Events", Summary: "Who has answered an event, and what", Response: []db.EventRSVP{}},
+		"GET /me/calendar":       {Tag: "Events", Summary: "Whether you have a calendar feed", Response: calendarFeedStatus{}},
+		"POST /me/calendar": {Tag: "Events", Summary: "Get a new calendar feed URL",
+			Description: "An iCal URL of the events you're going to or interested in, for Google or Apple Calendar; shown this once. Your old URL stops working.",
+			Status:      created, Response: calendarFeedStatus{}},
+		"DELETE /me/calendar": {Tag: "Events", Summary: "Turn your calendar feed off", Response: messageResponse{}},
+		"GET /calendar/{token}": {Tag: "Events", Public: true, Summary: "A calendar feed",
+			Description: "The URL from POST /me/calendar, ending .ics; the token in it is the key.", ContentType: "text/calendar"},
+
+		"GET /automations": {Tag: "Automations", Summary: "A guild's automation rules", Description: "Needs Manage Server in the guild.", Query: guildQuery, Response: []db.AutomationRule{}},
+		"POST /automations": {Tag: "Automations", Summary: "Add an automation rule",
+			Description: "Needs Manage Server in the guild. A trigger (message, join or reaction) and up to 5 actions (reply, add_role, delete_message or notify_channel).",
+			Request:     AutomationRuleRequest{}, Status: created, Response: db.AutomationRule{}},
+		"PUT /automations/{id}":    {Tag: "Automations", Summary: "Change an automation rule", Description: "Needs Manage Server in its guild.", Request: AutomationRuleRequest{}, Response: db.AutomationRule{}},
+		"DELETE /automations/{id}": {Tag: "Automations", Summary: "Delete an automation rule", Description: "Needs Manage Server in its guild.", Response: messageResponse{}},
+		"GET /automod":             {Tag: "Auto-Moderation", Summary: "A guild's auto-moderation settings", Description: "Needs Manage Server in the guild.", Query: guildQuery, Response: db.AutomodConfig{}},
+		"PUT /automod": {Tag: "Auto-Moderation", Summary: "Change a guild's auto-moderation settings",
+			Description: "Needs Manage Server in the guild. The whole of the settings: banned words, links, invites, repeats and mentions filters, each with what it does (delete, timeout in minutes, flag), and the roles and channels left alone.",
+			Query:       guildQuery, Request: db.AutomodConfig{}, Response: db.AutomodConfig{}},
+		"GET /automod/flags": {Tag: "Auto-Moderation", Summary: "Messages flagged for review, newest first",
+			Description: "Needs Manage Messages in the guild.",
+			Query:       map[string]string{"guild": guildQuery["guild"], "reviewed": "1 to include flags already reviewed"}, Response: []db.AutomodFlag{}},
+		"POST /automod/flags/{id}/review": {Tag: "Auto-Moderation", Summary: "Dismiss a flag, or delete the flagged message",
+			Description: "Needs Manage Messages in the flag's guild.", Request: ReviewAutomodFlagRequest{}, Response: db.AutomodFlag{}},
+		"GET /automod/timeouts":             {Tag: "Auto-Moderation", Summary: "Members timed out now", Description: "Needs Manage Messages in the guild.", Query: guildQuery, Response: []db.AutomodTimeout{}},
+		"DELETE /automod/timeouts/{userId}": {Tag: "Auto-Moderation", Summary: "Let a timed-out member post again", Description: "Needs Manage Messages in the guild.", Query: guildQuery, Response: messageResponse{}},
+
+		// Sign in with Chirm
+		"GET /oauth/clients": {Tag: "Sign in with Chirm", Summary: "Apps that sign people in with Chirm (admin)", Response: []db.OAuthClient{}},
+		"POST /oauth/clients": {Tag: "Sign in with Chirm", Summary: "Register an app (admin)",
+			Description: "Its id is the client ID. The client_secret is only returned this once; public clients get none and must use PKCE.",
+			Request:     OAuthClientRequest{}, Status: created, Response: oauthClientCreated{}},
+		"PUT /oauth/clients/{id}": {Tag: "Sign in with Chirm", Summary: "Change an app (admin)",
+			Description: "Whether it's public can't be changed. Roles are checked again when its tokens are next refreshed.",
+			Request:     OAuthClientRequest{}, Response: db.OAuthClient{}},
+		"DELETE /oauth/clients/{id}": {Tag: "Sign in with Chirm", Summary: "Delete an app (admin)", Description: "Everyone's tokens for it stop working.", Response: messageResponse{}},
+		"POST /oauth/clients/{id}/secret": {Tag: "Sign in with Chirm", Summary: "Give an app a new secret (admin)",
+			Description: "The old one stops working. The new one is only returned this once.", Response: oauthClientCreated{}},
+
+		// Emoji, stickers and sounds
+		"GET /emojis":         {Tag: "Emoji", Summary: "List custom emoji", Response: []db.CustomEmoji{}},
+		"POST /emojis":        {Tag: "Emoji", Summary: "Add a custom emoji", Form: map[string]string{"name": "shortcode", "image": "image"}, Files: []string{"image"}, Status: created, Response: db.CustomEmoji{}},
+		"DELETE /emojis/{id}": {Tag: "Emoji", Summary: "Delete a custom emoji", Response: messageResponse{}},
+		"GET /emojis/export": {Tag: "Emoji", Summary: "Download every custom emoji as a pack",
+			Description: "A ZIP of the images, with a manifest.json naming each.", ContentType: "application/zip"},
+		"POST /emojis/import": {Tag: "Emoji", Summary: "Add the emoji in a pack",
+			Description: "Takes a ZIP with a manifest.json, a Pleroma-style pack.json, or just images named for their shortcodes.",
+			Form:        map[string]string{"pack": "ZIP file", "conflict": "skip (default), rename or replace, for names already taken"}, Files: []string{"pack"}, Response: EmojiImportResult{}},
+		"GET /stickers":         {Tag: "Emoji", Summary: "List stickers", Response: []db.Sticker{}},
+		"POST /stickers":        {Tag: "Emoji", Summary: "Add a sticker", Form: map[string]string{"name": "name", "description": "alt text", "image": "image"}, Files: []string{"image"}, Status: created, Response: db.Sticker{}},
+		"DELETE /stickers/{id}": {Tag: "Emoji", Summary: "Delete a sticker", Response: messageResponse{}},
+		"GET /sounds":           {Tag: "Voice", Summary: "List soundboard clips", Response: []db.Sound{}},
+		"POST /sounds":          {Tag: "Voice", Summary: "Add a soundboard clip", Form: map[string]string{"name": "name", "sound": "audio file"}, Files: []string{"sound"}, Status: created, Response: db.Sound{}},
+		"DELETE /sounds/{id}":   {Tag: "Voice", Summary: "Delete a soundboard clip", Response: messageResponse{}},
+
+		// Uploads
+		"POST /upload": {Tag: "Uploads", Summary: "Upload a file to attach to a message",
+			Form: map[string]string{"file": "the file", "kind": `"voice" for a voice note`}, Files: []string{"file"},
+			Status: created, Response: db.Attachment{}},
+		"POST /uploads": {Tag: "Uploads", Summary: "Upload several files",
+			Description: "Send the files as \"files\" parts. With Accept: application/x-ndjson a line is streamed as each file is stored.",
+			Form:        map[string]string{"files": "files"}, Files: []string{"files"},
+			Status: created, Response: uploadBatchResponse{}},
+
+		// Users, roles and invites
+		"GET /users":                        {Tag: "Users", Summary: "List accounts (admin)", Response: []db.User{}},
+		"PUT /users/{id}":                   {Tag: "Users", Summary: "Change an account (admin)", Request: UpdateUserRequest{}, Response: db.User{}},
+		"DELETE /users/{id}":                {Tag: "Users", Summary: "Delete an account (admin)", Response: messageResponse{}},
+		"GET /roles":                        {Tag: "Users", Summary: "List a guild's roles", Query: guildQuery, Response: []db.Role{}},
+		"POST /roles":                       {Tag: "Users", Summary: "Create a role", Request: CreateRoleRequest{}, Status: created, Response: db.Role{}},
+		"PUT /roles/{id}":                   {Tag: "Users", Summary: "Change a role", Request: UpdateRoleRequest{}, Response: db.Role{}},
+		"DELETE /roles/{id}":                {Tag: "Users", Summary: "Delete a role", Response: messageResponse{}},
+		"POST /users/{id}/roles/{roleId}":   {Tag: "Users", Summary: "Give a user a role", Response: messageResponse{}},
+		"DELETE /users/{id}/roles/{roleId}": {Tag: "Users", Summary: "Take a role from a user", Response: messageResponse{}},
+		"GET /invites":                      {Tag: "Users", Summary: "List a guild's invites", Query: guildQuery, Response: []db.Invite{}},
+		"POST /invites":                     {Tag: "Users", Summary: "Create an invite", Request: CreateInviteRequest{}, Status: created, Response: db.Invite{}},
+		"DELETE /invites/{code}":            {Tag: "Users", Summary: "Delete an invite", Response: messageResponse{}},
+		"GET /invites/{code}/joins": {Tag: "Users", Summary: "Who came in through an invite, and when (guild admin)",
+			Query: map[string]string{"days": "1-365, default 30"}, Response: InviteJoinsResponse{}},
+		"GET /invites/{code}/qr.png": {Tag: "Users", Summary: "A QR code of an invite's link",
+			Query: map[string]string{"size": "pixels square, 128-1024, default 256"}, ContentType: "image/png"},
+		"GET /audit-log": {Tag: "Users", Summary: "Recent admin actions (admin)",
+			Query:    map[string]string{"before": "entry ID to page back from", "limit": "1-200, default 50"},
+			Response: []db.AuditEntry{}},
+
+		// Server settings
+		"GET /settings":           {Tag: "Settings", Summary: "All server settings (admin)", Response: map[string]string{}},
+		"PUT /settings":           {Tag: "Settings", Summary: "Change server settings (admin)", Request: map[string]string{}, Response: messageResponse{}},
+		"POST /settings/icon":     {Tag: "Settings", Summary: "Upload the server icon", Form: map[string]string{"icon": "image"}, Files: []string{"icon"}, Response: iconResponse{}},
+		"POST /settings/login-bg": {Tag: "Settings", Summary: "Upload the sign-in page background", Form: map[string]string{"bg": "image"}, Files: []string{"bg"}, Response: loginBgResponse{}},
+		"GET /admin/stats": {Tag: "Settings", Summary: "Usage figures for the dashboard (admin)",
+			Description: "One entry per UTC day, refreshed hourly, with the busiest channels and push deliveries over the same days.",
+			Query:       map[string]string{"days": "1-365, default 30"}, Response: adminStats{}},
+		"POST /admin/client-reload": {Tag: "Settings", Summary: "Have tabs running an older web app reload (admin)",
+			Description: "Sends everyone a client.reload event; tabs running a build before min_build clear their cached files and reload. Omit min_build, or send 0, for the current build.",
+			Request:     ClientReloadRequest{}, Response: ClientVersion{}},
+		"GET /admin/jwt-keys": {Tag: "Settings", Summary: "The keys session tokens are signed with (owner)", Response: []JWTKeyInfo{}},
+		"POST /admin/jwt-keys": {Tag: "Settings", Summary: "Add a key to sign session tokens with from now on (owner)",
+			Description: "Tokens signed with older keys still work, and browser sessions are re-signed with the new key when next used.",
+			Status:      created, Response: JWTKeyInfo{}},
+		"DELETE /admin/jwt-keys/{id}": {Tag: "Settings", Summary: "Retire a signing key (owner)",
+			Description: "Tokens signed with it stop working. The last key left can't be retired (409); \"env\" is JWT_SECRET's.",
+			Response:    []JWTKeyInfo{}},
+		"GET /admin/jobs": {Tag: "Settings", Summary: "Background jobs, with their last and next runs (admin)",
+			Description: "Shared jobs run on one instance at a time; their last run is the last on any instance, and running_on says which instance has one now. next_run is when this instance will next try it.",
+			Response:    []jobs.Status{}},
+		"POST /admin/jobs/{name}/run": {Tag: "Settings", Summary: "Run a background job now (admin)",
+			Description: "The job runs as soon as it can; a shared job another instance is running isn't run again.",
+			Status:      http.StatusAccepted},
+		"GET /ip-rules": {Tag: "Settings", Summary: "IP allow and deny rules (admin)", Response: []db.IPRule{}},
+		"POST /ip-rules": {Tag: "Settings", Summary: "Add an IP rule (admin)",
+			Description: "Takes effect at once and closes WebSocket connections from addresses now blocked. A rule that would block the caller gets 409.",
+			Request:     CreateIPRuleRequest{}, Status: http.StatusCreated, Response: db.IPRule{}},
+		"DELETE /ip-rules/{id}": {Tag: "Settings", Summary: "Remove an IP rule (admin)", Response: messageResponse{}},
+		"GET /bots":             {Tag: "Settings", Summary: "Bot accounts (admin)", Response: []db.Bot{}},
+		"POST /bots": {Tag: "Settings", Summary: "Make a bot account (admin)",
+			Description: "The token is shown this once. The bot sends it as \"Authorization: Bot <token>\" to use the API as itself, and to connect to the /ws gateway with ?intents=.",
+			Request:     CreateBotRequest{}, Status: http.StatusCreated, Response: botToken{}},
+		"POST /bots/{id}/token": {Tag: "Settings", Summary: "Give a bot a new token (admin)",
+			Description: "The old token stops working and the bot is disconnected from the gateway.", Response: botToken{}},
+		"DELETE /bots/{id}": {Tag: "Settings", Summary: "Delete a bot account (admin)", Response: messageResponse{}},
+		"GET /openapi.json": {Tag: "Settings", Public: true, Summary: "This API description", ContentType: "application/json"},
+
+		// Voice
+		"GET /voice/rooms":                              {Tag: "Voice", Summary: "Who is in each voice channel", Response: voiceRoomsResponse{}},
+		"GET /voice/ice-servers":                        {Tag: "Voice", Summary: "STUN and TURN servers for WebRTC", Response: iceServersResponse{}},
+		"POST /voice/{channelId}/users/{id}/mute":       {Tag: "Voice", Summary: "Server-mute a user", Description: `Body {"muted": false} unmutes; no body mutes.`, Request: voiceModState{}, Response: voiceModState{}},
+		"POST /voice/{channelId}/users/{id}/deafen":     {Tag: "Voice", Summary: "Server-deafen a user", Description: `Body {"deafened": false} undeafens; no body deafens.`, Request: voiceModState{}, Response: voiceModState{}},
+		"POST /voice/{channelId}/stage/speakers/{id}":   {Tag: "Voice", Summary: "Invite someone to speak on a stage", Response: stageResponse{}},
+		"DELETE /voice/{channelId}/stage/speakers/{id}": {Tag: "Voice", Summary: "Move a speaker back to the audience", Response: stageResponse{}},
+		"POST /voice/{channelId}/broadcast":             {Tag: "Voice", Summary: "Go live in a broadcast channel", Response: broadcastState{}},
+		"DELETE /voice/{channelId}/broadcast":           {Tag: "Voice", Summary: "End a broadcast", Response: broadcastState{}},
+		"GET /calls":                                    {Tag: "Voice", Summary: "Your recent calls", Response: []db.Call{}},
+		"POST /voice/{channelId}/recording":             {Tag: "Recordings", Summary: "Start recording a voice channel", Status: created, Response: db.Recording{}},
+		"DELETE /voice/{channelId}/recording":           {Tag: "Recordings", Summary: "Stop recording", Response: db.Recording{}},
+		"GET /recordings":                               {Tag: "Recordings", Summary: "List recordings", Response: []db.Recording{}},
+		"GET /recordings/{id}/files/{name}":             {Tag: "Recordings", Summary: "Download one track of a recording", ContentType: "audio/ogg"},
+		"DELETE /recordings/{id}":                       {Tag: "Recordings", Summary: "Delete a recording", Response: messageResponse{}},
+
+		// Push notifications
+		"GET /push/vapid-public-key": {Tag: "Push", Summary: "The key to subscribe to Web Push with", Response: vapidKeyResponse{}},
+		"GET /push/platforms":        {Tag: "Push", Summary: "Push services this server can deliver through", Response: pushPlatformsResponse{}},
+		"GET /push/languages":        {Tag: "Push", Summary: "Languages notifications can be written in", Response: pushLanguagesResponse{}},
+		"POST /push/subscribe":       {Tag: "Push", Summary: "Register a device for notifications", Request: PushSubscribeRequest{}, Response: statusResponse{}},
+		"POST /push/unsubscribe":     {Tag: "Push", Summary: "Stop notifying a device", Request: RemovePushSubscriptionRequest{}, Response: statusResponse{}},
+		"GET /push/subscriptions":    {Tag: "Push", Summary: "Your registered devices", Response: []pushDevice{}},
+		"GET /push/poll":             {Tag: "Push", Summary: "Unread counts and mentions, for background sync", Response: unreadResponse{}},
+		"POST /push/test":            {Tag: "Push", Summary: "Send yourself a test notification", Response: testPushResponse{}},
+	}
+}
+
+// SetAPISpec sets the OpenAPI document served at /api/openapi.json.
+func (h *Handler) SetAPISpec(spec []byte) {
+	h.apiSpec = spec
+}
+
+// OpenAPISpec serves the API's OpenAPI document.
+func (h *Handler) OpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(h.apiSpec)
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"chirm/internal/db"
+)
+
+// ─── Apple Push Notification service ─────────────────────────────────────────
+//
+// Uses token-based auth: an ES256 JWT signed with the team's .p8 key, reused
+// for up to apnsTokenTTL as Apple asks.  Requests go over HTTP/2, which
+// net/http negotiates on its own.
+
+const apnsTokenTTL = 50 * time.Minute
+
+type apnsTransport struct {
+	host   string
+	keyID  string
+	teamID string
+	topic  string
+	key    *ecdsa.PrivateKey
+	client *http.Client
+
+	mu       sync.Mutex
+	jwt      string
+	issuedAt time.Time
+}
+
+func newAPNsTransport(keyFile, keyID, teamID, topic string, sandbox bool) (*apnsTransport, error) {
+	pem, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(pem)
+	if err != nil {
+		return nil, fmt.Errorf("signing key: %w", err)
+	}
+	host := "https://api.push.apple.com"
+	if sandbox {
+		host = "https://api.sandbox.push.apple.com"
+	}
+	return &apnsTransport{
+		host:   host,
+		keyID:  keyID,
+		teamID: teamID,
+		topic:  topic,
+		key:    key,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// token returns the provider JWT, signing a new one when the old is stale.
+func (a *apnsTransport) token() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.jwt != "" && time.Since(a.issuedAt) < apnsTokenTTL {
+		return a.jwt, nil
+	}
+	now := time.Now()
+	t := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": a.teamID,
+		"iat": now.Unix(),
+	})
+	t.Header["kid"] = a.keyID
+	signed, err := t.SignedString(a.key)
+	if err != nil {
+		return "", err
+	}
+	a.jwt, a.issuedAt = signed, now
+	return signed, nil
+}
+
+func (a *apnsTransport) Send(sub db.PushSubscription, payload []byte) error {
+	deviceToken, err := nativeToken(sub)
+	if err != nil {
+		return err
+	}
+	var p PushPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	providerToken, err := a.token()
+	if err != nil {
+		return err
+	}
+
+	aps := map[string]interface{}{
+		"alert":     map[string]string{"title": p.Title, "body": p.Body},
+		"sound":     "default",
+		"thread-id": p.ChannelID,
+	}
+	if p.Badge > 0 {
+		aps["badge"] = p.Badge
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"aps":        aps,
+		"channel_id": p.ChannelID,
+		"message_id": p.MessageID,
+	})
+	req, err := http.NewRequest("POST", a.host+"/3/device/"+deviceToken, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("authorization", "bearer "+providerToken)
+	req.Header.Set("apns-topic", a.topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-expiration", fmt.Sprint(time.Now().Add(24*time.Hour).Unix()))
+	if p.Tag != "" && len(p.Tag) <= 64 {
+		req.Header.Set("apns-collapse-id", p.Tag)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("apns request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	var reason struct {
+		Reason string `json:"reason"`
+	}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	json.Unmarshal(raw, &reason)
+	switch {
+	case resp.StatusCode == http.StatusGone, reason.Reason == "BadDeviceToken", reason.Reason == "Unregistered":
+		return fmt.Errorf("apns %d %s: %w", resp.StatusCode, reason.Reason, errPushGone)
+	case reason.Reason == "ExpiredProviderToken":
+		a.mu.Lock()
+		a.jwt = "" // sign a fresh one next time
+		a.mu.Unlock()
+	}
+	return fmt.Errorf("apns %d: %s", resp.StatusCode, reason.Reason)
+}
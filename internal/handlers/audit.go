@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// ListAuditLog handles GET /api/audit-log?before=<id>&limit=<n> (admin only).
+func (h *Handler) ListAuditLog(w http.ResponseWriter, r *http.Request) {
+	if _, isAdmin := h.requireAdmin(w, r); !isAdmin {
+		return
+	}
+	limit := 50
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 200 {
+		limit = n
+	}
+	entries, err := h.db.ListAuditLog(r.URL.Query().Get("before"), limit)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to load audit log")
+		return
+	}
+	ok(w, entries)
+}
@@ -1,23 +1,33 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
-	"io"
+	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
+
+	"chirm/internal/db"
+	mw "chirm/internal/middleware"
 )
 
 // Fix #11: Only allow safe, unambiguous characters in usernames.
 var validUsername = regexp.MustCompile(`^[a-zA-Z0-9_.\-]{2,32}$`)
 
+// ValidUsername reports whether name is allowed as a username.
+func ValidUsername(name string) bool {
+	return validUsername.MatchString(name)
+}
+
+// LoginRequest is the body of POST /api/auth/login.
+type LoginRequest struct {
+	Login    string `json:"login"` // username or email
+	Password string `json:"password"`
+}
+
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Login    string `json:"login"` // username or email
-		Password string `json:"password"`
-	}
+	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errResp(w, http.StatusBadRequest, "invalid request")
 		return
@@ -47,6 +57,17 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	ok(w, map[string]interface{}{"user": u, "token": token})
 }
 
+// RegisterRequest is the body of POST /api/auth/register.
+type RegisterRequest struct {
+	Username   string `json:"username"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	InviteCode string `json:"invite_code"`
+	// AcceptRules is set when the user accepted the server rules while
+	// signing up.
+	AcceptRules bool `json:"accept_rules,omitempty"`
+}
+
 func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 	// Check if registration is allowed
 	allowReg, _ := h.db.GetSetting("allow_registration")
@@ -57,12 +78,7 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		Username   string `json:"username"`
-		Email      string `json:"email"`
-		Password   string `json:"password"`
-		InviteCode string `json:"invite_code"`
-	}
+	var req RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errResp(w, http.StatusBadRequest, "invalid request")
 		return
@@ -89,13 +105,17 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check invite requirement
+	// Check invite requirement.  An invite to another guild also puts the
+	// new account in that guild.  Either way the account is marked with
+	// the invite, so admins can see which links bring people in.
+	var inv *db.Invite
 	if requireInvite == "1" {
 		if req.InviteCode == "" {
 			errResp(w, http.StatusForbidden, "invite code required")
 			return
 		}
-		inv, err := h.db.GetInviteByCode(req.InviteCode)
+		var err error
+		inv, err = h.db.GetInviteByCode(req.InviteCode)
 		if err != nil {
 			errResp(w, http.StatusForbidden, "invalid invite code")
 			return
@@ -106,6 +126,13 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		h.db.UseInvite(req.InviteCode)
+	} else if req.InviteCode != "" {
+		if i, err := h.db.GetInviteByCode(req.InviteCode); err == nil && h.db.IsInviteValid(i) {
+			inv = i
+			if i.GuildID != db.DefaultGuild {
+				h.db.UseInvite(req.InviteCode)
+			}
+		}
 	}
 
 	hash, err := h.auth.HashPassword(req.Password)
@@ -123,6 +150,13 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		errResp(w, http.StatusInternalServerError, "failed to create user")
 		return
 	}
+	if inv != nil {
+		h.db.AddGuildMember(inv.GuildID, u.ID)
+		h.db.RecordInviteJoin(inv, u.ID, true)
+	}
+	if req.AcceptRules {
+		h.db.AcceptRules(u.ID)
+	}
 
 	token, err := h.auth.GenerateToken(u.ID, u.Username, u.IsOwner)
 	if err != nil {
@@ -142,8 +176,17 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		},
 	})
 
+	resp := map[string]interface{}{"user": u, "token": token}
+	if welcome := h.welcomeNewMember(u); welcome != "" {
+		resp["welcome"] = welcome
+	}
+	h.runAutomations(db.TriggerJoin, automationEvent{GuildID: db.DefaultGuild, User: u})
+	if inv != nil && inv.GuildID != db.DefaultGuild {
+		h.runAutomations(db.TriggerJoin, automationEvent{GuildID: inv.GuildID, User: u})
+	}
+
 	setTokenCookie(w, r, token)
-	created(w, map[string]interface{}{"user": u, "token": token})
+	created(w, resp)
 }
 
 func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
@@ -169,6 +212,12 @@ func (h *Handler) GetMe(w http.ResponseWriter, r *http.Request) {
 	ok(w, u)
 }
 
+// UpdateMeRequest is the body of PUT /api/me.
+type UpdateMeRequest struct {
+	Username string `json:"username"`
+	Avatar   string `json:"avatar"`
+}
+
 func (h *Handler) UpdateMe(w http.ResponseWriter, r *http.Request) {
 	u, err := h.currentUser(r)
 	if err != nil || u == nil {
@@ -176,10 +225,7 @@ func (h *Handler) UpdateMe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		Username string `json:"username"`
-		Avatar   string `json:"avatar"`
-	}
+	var req UpdateMeRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errResp(w, http.StatusBadRequest, "invalid request")
 		return
@@ -199,7 +245,10 @@ func (h *Handler) UpdateMe(w http.ResponseWriter, r *http.Request) {
 	ok(w, updated)
 }
 
-// UploadAvatar accepts a multipart image, saves it, and updates the user's avatar field.
+// avatarSize is the longest edge avatars are stored at, in pixels.
+const avatarSize = 256
+
+// UploadAvatar accepts a multipart image, resizes it, and updates the user's avatar field.
 func (h *Handler) UploadAvatar(w http.ResponseWriter, r *http.Request) {
 	u, err := h.currentUser(r)
 	if err != nil || u == nil {
@@ -207,13 +256,13 @@ func (h *Handler) UploadAvatar(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, 5*1024*1024) // 5 MB cap for avatars
+	mw.SetBodyLimit(r, 5*1024*1024) // 5 MB cap for avatars
 	if err := r.ParseMultipartForm(5 * 1024 * 1024); err != nil {
 		errResp(w, http.StatusBadRequest, "file too large (max 5MB)")
 		return
 	}
 
-	file, header, err := r.FormFile("avatar")
+	file, _, err := r.FormFile("avatar")
 	if err != nil {
 		errResp(w, http.StatusBadRequest, "no file provided")
 		return
@@ -236,32 +285,22 @@ func (h *Handler) UploadAvatar(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Seek back, then save
-	file.Seek(0, 0)
-
-	// Generate unique filename
-	ext := filepath.Ext(header.Filename)
-	if ext == "" {
-		ext = ".jpg"
-	}
-	filename := "avatar_" + newID() + ext
-	destPath := filepath.Join(h.dataDir, "uploads", filename)
-
-	dest, err := os.Create(destPath)
+	// Re-encode at display size rather than storing the original verbatim
+	data, ext, err := shrinkImage(file, avatarSize)
 	if err != nil {
-		errResp(w, http.StatusInternalServerError, "failed to save avatar")
+		errResp(w, http.StatusBadRequest, "invalid avatar image: "+err.Error())
 		return
 	}
-	defer dest.Close()
-	if _, err := io.Copy(dest, file); err != nil {
-		os.Remove(destPath)
-		errResp(w, http.StatusInternalServerError, "failed to write avatar")
+
+	filename := "avatar_" + newID() + ext
+	if err := h.files.Put(filename, bytes.NewReader(data), int64(len(data)), mime.TypeByExtension(ext)); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to save avatar")
 		return
 	}
 
 	avatarURL := "/uploads/" + filename
 	if err := h.db.UpdateUser(u.ID, u.Username, avatarURL); err != nil {
-		os.Remove(destPath)
+		h.files.Delete(filename)
 		errResp(w, http.StatusInternalServerError, "failed to update avatar")
 		return
 	}
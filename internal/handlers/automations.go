@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+)
+
+// ─── Automation rules ────────────────────────────────────────────────────────
+//
+// Guild admins write rules (see db/automations.go) that run as things
+// happen: a message is checked against the guild's message rules once it
+// has gone out, someone joining runs the join rules, and a reaction the
+// reaction rules.  A rule's actions run in order.  What rules post never
+// sets rules off, and nor do bots, so rules and bots can't set each other
+// off in a loop.
+
+const (
+	automationMaxRules   = 50 // per guild
+	automationMaxActions = 5
+	automationMaxName    = 32
+	automationMaxPattern = 500
+	automationMaxContent = 2000
+	automationQuoteLen   = 500 // characters of a message {message} gives
+)
+
+// automationPatterns holds message rules' patterns, and auto-moderation's
+// banned words (see automod.go), compiled, by pattern.
+var automationPatterns = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: map[string]*regexp.Regexp{}}
+
+// automationPattern returns pattern compiled, or nil if it doesn't compile.
+func automationPattern(pattern string) *regexp.Regexp {
+	automationPatterns.Lock()
+	defer automationPatterns.Unlock()
+	if re, found := automationPatterns.m[pattern]; found {
+		return re
+	}
+	re, _ := regexp.Compile(pattern)
+	if len(automationPatterns.m) >= 1000 {
+		automationPatterns.m = map[string]*regexp.Regexp{}
+	}
+	automationPatterns.m[pattern] = re
+	return re
+}
+
+// automationEvent is what set rules off.
+type automationEvent struct {
+	GuildID string
+	User    *db.User    // who did it
+	Channel *db.Channel // where, for messages and reactions
+	Message *db.Message // the message, or the one reacted to
+	Emoji   string      // for reactions
+}
+
+// runAutomations runs guildID's enabled rules for trigger that ev matches.
+func (h *Handler) runAutomations(trigger string, ev automationEvent) {
+	if ev.User == nil || ev.User.Bot {
+		return
+	}
+	rules, err := h.db.ActiveAutomationRules(ev.GuildID, trigger)
+	if err != nil {
+		slog.Error("automation rules", "guild", ev.GuildID, "err", err)
+		return
+	}
+	for i := range rules {
+		a := &rules[i]
+		if a.ChannelID != "" && (ev.Channel == nil || ev.Channel.ID != a.ChannelID) {
+			continue
+		}
+		switch trigger {
+		case db.TriggerMessage:
+			re := automationPattern(a.Pattern)
+			if re == nil || ev.Message == nil || !re.MatchString(ev.Message.Content) {
+				continue
+			}
+		case db.TriggerReaction:
+			if a.Pattern != "" && a.Pattern != ev.Emoji {
+				continue
+			}
+		}
+		h.runAutomation(a, ev)
+		// Once a rule has deleted the message, later rules have nothing
+		// to act on.
+		if ev.Message != nil {
+			if _, err := h.db.GetMessageByID(ev.Message.ID); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// runAutomation does what rule a does about ev.
+func (h *Handler) runAutomation(a *db.AutomationRule, ev automationEvent) {
+	deletes := false
+	for _, act := range a.Actions {
+		deletes = deletes || act.Type == db.ActionDeleteMessage
+	}
+	for _, act := range a.Actions {
+		var err error
+		switch act.Type {
+		case db.ActionReply:
+			channelID, replyTo := act.ChannelID, (*string)(nil)
+			if channelID == "" && ev.Channel != nil {
+				channelID = ev.Channel.ID
+			}
+			if ev.Message != nil && ev.Message.ChannelID == channelID && !deletes {
+				replyTo = &ev.Message.ID
+			}
+			err = h.postAutomation(a, channelID, h.renderAutomation(act.Content, ev), replyTo)
+		case db.ActionNotifyChannel:
+			err = h.postAutomation(a, act.ChannelID, h.renderAutomation(act.Content, ev), nil)
+		case db.ActionAddRole:
+			role, rerr := h.db.GetRoleByID(act.RoleID)
+			if rerr != nil || role.GuildID != ev.GuildID {
+				err = fmt.Errorf("role %s is gone", act.RoleID)
+				break
+			}
+			err = h.db.AssignRole(ev.User.ID, role.ID)
+		case db.ActionDeleteMessage:
+			if ev.Message == nil {
+				break
+			}
+			if err = h.db.DeleteMessage(ev.Message.ID); err == nil {
+				h.hub.BroadcastToChannel(ev.Message.ChannelID, WSEvent{Type: "message.delete",
+					Data: map[string]string{"id": ev.Message.ID, "channel_id": ev.Message.ChannelID}})
+				h.federate("message.delete", ev.Message)
+			}
+		}
+		if err != nil {
+			slog.Warn("automation action failed", "rule", a.ID, "action", act.Type, "err", err)
+		}
+	}
+	h.db.AutomationRuleRan(a.ID, time.Now())
+}
+
+// postAutomation posts content in channelID as rule a.
+func (h *Handler) postAutomation(a *db.AutomationRule, channelID, content string, replyTo *string) error {
+	if channelID == "" || strings.TrimSpace(content) == "" {
+		return nil
+	}
+	msg, err := h.db.CreateAutomationMessage(a, channelID, content, replyTo)
+	if err != nil {
+		return err
+	}
+	h.publishMessage(msg, "")
+	return nil
+}
+
+// renderAutomation fills in a rule's text: {user} is who set it off,
+// {mention} the same as an @mention, {channel} where, {server} the
+// server's name, {message} the message's text and {emoji} the reaction.
+func (h *Handler) renderAutomation(tmpl string, ev automationEvent) string {
+	server, _ := h.db.GetSetting("server_name")
+	if server == "" {
+		server = "Chirm"
+	}
+	channel, message := "", ""
+	if ev.Channel != nil {
+		channel = "#" + ev.Channel.Name
+	}
+	if ev.Message != nil {
+		message = truncateRunes(ev.Message.Content, automationQuoteLen)
+	}
+	return strings.NewReplacer(
+		"{user}", ev.User.Username,
+		"{mention}", "@"+ev.User.Username,
+		"{channel}", channel,
+		"{server}", server,
+		"{message}", message,
+		"{emoji}", ev.Emoji,
+	).Replace(tmpl)
+}
+
+// AutomationRuleRequest is the body of POST /api/automations and PUT
+// /api/automations/{id}.
+type AutomationRuleRequest struct {
+	GuildID   string                `json:"guild_id"` // on creation; the default guild if left out
+	Name      string                `json:"name"`     // posts go out under it
+	Enabled   *bool                 `json:"enabled"`  // true if left out
+	Trigger   string                `json:"trigger"`  // message, join or reaction
+	Pattern   string                `json:"pattern"`  // a regular expression for messages, an emoji for reactions
+	ChannelID string                `json:"channel_id"`
+	Actions   []db.AutomationAction `json:"actions"`
+}
+
+// automationTextChannel reports whether channelID is a text channel in
+// guildID.
+func (h *Handler) automationTextChannel(guildID, channelID string) bool {
+	ch, err := h.db.GetChannelByID(channelID)
+	return err == nil && ch.GuildID == guildID && ch.Type == "text"
+}
+
+// validateAutomationRule checks req for guildID, tidying it, and returns
+// what's wrong with it, if anything.
+func (h *Handler) validateAutomationRule(req *AutomationRuleRequest, guildID string) string {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > automationMaxName {
+		return fmt.Sprintf("name must be 1-%d characters", automationMaxName)
+	}
+	switch req.Trigger {
+	case db.TriggerMessage:
+		if req.Pattern == "" || len(req.Pattern) > automationMaxPattern {
+			return fmt.Sprintf("pattern must be 1-%d characters", automationMaxPattern)
+		}
+		if _, err := regexp.Compile(req.Pattern); err != nil {
+			return "pattern is not a valid regular expression: " + err.Error()
+		}
+	case db.TriggerReaction:
+		req.Pattern = strings.TrimSpace(req.Pattern)
+		if len(req.Pattern) > 64 {
+			return "pattern must be one emoji"
+		}
+	case db.TriggerJoin:
+		if req.ChannelID != "" {
+			return "join rules can't be limited to a channel"
+		}
+		req.Pattern = ""
+	default:
+		return "trigger must be message, join or reaction"
+	}
+	if req.ChannelID != "" && !h.inGuild(guildID, req.ChannelID, "") {
+		return "channel not found"
+	}
+	if len(req.Actions) == 0 || len(req.Actions) > automationMaxActions {
+		return fmt.Sprintf("a rule needs 1-%d actions", automationMaxActions)
+	}
+	for i := range req.Actions {
+		act := &req.Actions[i]
+		act.Content = strings.TrimSpace(act.Content)
+		if len(act.Content) > automationMaxContent {
+			return fmt.Sprintf("action text must be at most %d characters", automationMaxContent)
+		}
+		switch act.Type {
+		case db.ActionReply:
+			if act.Content == "" {
+				return "a reply needs content"
+			}
+			if act.ChannelID == "" && req.Trigger == db.TriggerJoin {
+				return "a reply to a join needs a channel"
+			}
+			if act.ChannelID != "" && !h.automationTextChannel(guildID, act.ChannelID) {
+				return "reply channel must be a text channel in the guild"
+			}
+			act.RoleID = ""
+		case db.ActionNotifyChannel:
+			if act.Content == "" || act.ChannelID == "" {
+				return "a notification needs content and a channel"
+			}
+			if !h.automationTextChannel(guildID, act.ChannelID) {
+				return "notification channel must be a text channel in the guild"
+			}
+			act.RoleID = ""
+		case db.ActionAddRole:
+			role, err := h.db.GetRoleByID(act.RoleID)
+			if err != nil || role.GuildID != guildID || role.Name == "@everyone" {
+				return "role not found"
+			}
+			act.Content, act.ChannelID = "", ""
+		case db.ActionDeleteMessage:
+			if req.Trigger == db.TriggerJoin {
+				return "join rules have no message to delete"
+			}
+			act.Content, act.RoleID, act.ChannelID = "", "", ""
+		default:
+			return "action type must be reply, add_role, delete_message or notify_channel"
+		}
+	}
+	return ""
+}
+
+// automationRuleFrom is req as a rule.
+func automationRuleFrom(req AutomationRuleRequest) db.AutomationRule {
+	enabled := req.Enabled == nil || *req.Enabled
+	return db.AutomationRule{
+		Name:      req.Name,
+		Enabled:   enabled,
+		Trigger:   req.Trigger,
+		Pattern:   req.Pattern,
+		ChannelID: req.ChannelID,
+		Actions:   req.Actions,
+	}
+}
+
+// ListAutomationRules handles GET /api/automations?guild= (guild admins).
+func (h *Handler) ListAutomationRules(w http.ResponseWriter, r *http.Request) {
+	guildID := guildParam(r)
+	if _, isAdmin := h.requireGuildAdmin(w, r, guildID); !isAdmin {
+		return
+	}
+	rules, err := h.db.ListAutomationRules(guildID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list rules")
+		return
+	}
+	ok(w, rules)
+}
+
+// CreateAutomationRule handles POST /api/automations (guild admins).
+func (h *Handler) CreateAutomationRule(w http.ResponseWriter, r *http.Request) {
+	var req AutomationRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if req.GuildID == "" {
+		req.GuildID = db.DefaultGuild
+	}
+	u, isAdmin := h.requireGuildAdmin(w, r, req.GuildID)
+	if !isAdmin {
+		return
+	}
+	if msg := h.validateAutomationRule(&req, req.GuildID); msg != "" {
+		errResp(w, http.StatusBadRequest, msg)
+		return
+	}
+	if rules, _ := h.db.ListAutomationRules(req.GuildID); len(rules) >= automationMaxRules {
+		errResp(w, http.StatusBadRequest, fmt.Sprintf("a guild can have at most %d rules", automationMaxRules))
+		return
+	}
+	a := automationRuleFrom(req)
+	a.GuildID, a.CreatedBy = req.GuildID, u.ID
+	rule, err := h.db.CreateAutomationRule(a)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to create rule")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "automation.create", TargetID: rule.ID, Details: rule.Name})
+	created(w, rule)
+}
+
+// UpdateAutomationRule handles PUT /api/automations/{id} (guild admins).
+func (h *Handler) UpdateAutomationRule(w http.ResponseWriter, r *http.Request) {
+	old, err := h.db.GetAutomationRule(chi.URLParam(r, "id"))
+	if err != nil {
+		errResp(w, http.StatusNotFound, "rule not found")
+		return
+	}
+	u, isAdmin := h.requireGuildAdmin(w, r, old.GuildID)
+	if !isAdmin {
+		return
+	}
+	var req AutomationRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if msg := h.validateAutomationRule(&req, old.GuildID); msg != "" {
+		errResp(w, http.StatusBadRequest, msg)
+		return
+	}
+	a := automationRuleFrom(req)
+	a.ID = old.ID
+	rule, err := h.db.UpdateAutomationRule(a)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to update rule")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "automation.update", TargetID: rule.ID, Details: rule.Name})
+	ok(w, rule)
+}
+
+// DeleteAutomationRule handles DELETE /api/automations/{id} (guild admins).
+// What it posted stays.
+func (h *Handler) DeleteAutomationRule(w http.ResponseWriter, r *http.Request) {
+	guildID := db.DefaultGuild
+	rule, err := h.db.GetAutomationRule(chi.URLParam(r, "id"))
+	if err == nil {
+		guildID = rule.GuildID
+	}
+	u, isAdmin := h.requireGuildAdmin(w, r, guildID)
+	if !isAdmin {
+		return
+	}
+	if err != nil {
+		errResp(w, http.StatusNotFound, "rule not found")
+		return
+	}
+	if err := h.db.DeleteAutomationRule(rule.ID); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to delete rule")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "automation.delete", TargetID: rule.ID, Details: rule.Name})
+	ok(w, map[string]string{"message": "rule deleted"})
+}
@@ -0,0 +1,556 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+)
+
+// ─── Auto-moderation ─────────────────────────────────────────────────────────
+//
+// Before a message is posted, or an edit saved, it goes through the
+// guild's filters (see db/automod.go).  A filter that deletes stops the
+// message with a 403 saying why; otherwise it's posted, and flagged or its
+// author timed out afterwards.  Everything the filters do goes in the audit
+// log as automod.*, by Chirm rather than by anyone.  Moderators, those with
+// Manage Messages, review the flags and lift timeouts; admins set the
+// filters up.
+//
+// Repeats are counted in memory, per instance: several instances behind a
+// load balancer each count the messages they're sent.
+
+const (
+	automodMaxWords     = 500
+	automodMaxWordLen   = 64
+	automodMaxDomains   = 100
+	automodMaxTimeout   = 7 * 24 * 60 // minutes
+	automodFlagsPerPage = 100
+)
+
+// automodWordChars are what a banned word's * stands for, and what can't
+// be either side of a banned word.
+const automodWordChars = `[\p{L}\p{N}_]`
+
+var (
+	automodLinkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>()\[\]]+`)
+	// Other chat services' invite links, and Chirm's, whose codes are
+	// looked up, so this server's own invites are left alone.
+	automodForeignInvitePattern = regexp.MustCompile(`(?i)\b(?:discord(?:app)?\.com/invite|discord\.gg|t\.me/joinchat)/[\w-]+`)
+	automodChirmInvitePattern   = regexp.MustCompile(`(?i)(?:[?&]invite=|/join/)([\w-]+)`)
+)
+
+// automodRecent holds, by guild and author, the messages each author
+// posted lately, for the repeats filter.
+var automodRecent = struct {
+	sync.Mutex
+	m map[string][]automodSent
+}{m: map[string][]automodSent{}}
+
+type automodSent struct {
+	text string // lower-cased, spaces squeezed
+	at   time.Time
+}
+
+// automodHit is a filter catching a message.
+type automodHit struct {
+	Filter string
+	Reason string
+	Action db.AutomodAction
+}
+
+// automodVerdict is what the filters made of a message: which caught it,
+// and everything they'd have done about it together.
+type automodVerdict struct {
+	Hits   []automodHit
+	Action db.AutomodAction
+}
+
+// Reason is why the message was caught, for the author and the audit log.
+func (v *automodVerdict) Reason() string {
+	reasons := make([]string, len(v.Hits))
+	for i, hit := range v.Hits {
+		reasons[i] = hit.Reason
+	}
+	return strings.Join(reasons, "; ")
+}
+
+func (v *automodVerdict) add(filter, reason string, f db.AutomodFilter) {
+	v.Hits = append(v.Hits, automodHit{Filter: filter, Reason: reason, Action: f.AutomodAction})
+	v.Action.Delete = v.Action.Delete || f.Delete
+	v.Action.Flag = v.Action.Flag || f.Flag
+	if f.Timeout > v.Action.Timeout {
+		v.Action.Timeout = f.Timeout
+	}
+}
+
+// automodExempt reports whether u's messages in ch skip the filters.
+func (h *Handler) automodExempt(c *db.AutomodConfig, u *db.User, ch *db.Channel) bool {
+	if h.hasChannelPermission(u, ch.ID, db.PermManageMessages) {
+		return true
+	}
+	for _, id := range c.ExemptChannels {
+		if id == ch.ID {
+			return true
+		}
+	}
+	if len(c.ExemptRoles) > 0 {
+		roles, _ := h.db.GetUserGuildRoles(u.ID, ch.GuildID)
+		for _, r := range roles {
+			for _, id := range c.ExemptRoles {
+				if r.ID == id {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// automodCheck runs content, which u is posting in ch, through the guild's
+// filters, returning nil if none catch it.  repeats counts it towards the
+// repeats filter, as for new messages but not edits.
+func (h *Handler) automodCheck(u *db.User, ch *db.Channel, content string, repeats bool) *automodVerdict {
+	c, err := h.db.GetAutomodConfig(ch.GuildID)
+	if err != nil || h.automodExempt(c, u, ch) {
+		return nil
+	}
+	v := &automodVerdict{}
+	if f := c.BannedWords; f.Enabled && len(f.Words) > 0 {
+		if re := automationPattern(automodWordsPattern(f.Words)); re != nil {
+			if m := re.FindStringSubmatch(content); m != nil {
+				v.add("banned_words", fmt.Sprintf("banned word %q", m[1]), f.AutomodFilter)
+			}
+		}
+	}
+	if f := c.Links; f.Enabled {
+		for _, link := range automodLinkPattern.FindAllString(content, -1) {
+			if host := automodLinkHost(link); !h.automodAllowedHost(host, f.Allow) {
+				v.add("links", "link to "+host, f.AutomodFilter)
+				break
+			}
+		}
+	}
+	if f := c.Invites; f.Enabled {
+		if h.automodForeignInvite(content) {
+			v.add("invites", "invite to another server", f)
+		}
+	}
+	if f := c.Repeats; f.Enabled && repeats {
+		if n := automodRepeats(ch.GuildID, u.ID, content, time.Duration(f.Seconds)*time.Second); n >= f.Count {
+			v.add("repeats", fmt.Sprintf("same message %d times", n), f.AutomodFilter)
+		}
+	}
+	if f := c.Mentions; f.Enabled {
+		m := db.ParseMentions(content)
+		n := len(m.Users)
+		if m.Everyone {
+			n++
+		}
+		if n > f.Max {
+			v.add("mentions", fmt.Sprintf("%d mentions", n), f.AutomodFilter)
+		}
+	}
+	if len(v.Hits) == 0 {
+		return nil
+	}
+	return v
+}
+
+// automodWordsPattern is a regular expression matching any of words whole,
+// ignoring case, with the word caught as its first group.
+func automodWordsPattern(words []string) string {
+	alts := make([]string, 0, len(words))
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w == "" {
+			continue
+		}
+		q := regexp.QuoteMeta(strings.Join(strings.Fields(w), " "))
+		q = strings.ReplaceAll(q, `\*`, automodWordChars+`*`)
+		alts = append(alts, strings.ReplaceAll(q, " ", `\s+`))
+	}
+	return `(?i)(?:^|[^\p{L}\p{N}_])(` + strings.Join(alts, "|") + `)(?:[^\p{L}\p{N}_]|$)`
+}
+
+// automodLinkHost is the host link goes to, lower-cased.
+func automodLinkHost(link string) string {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+}
+
+// automodAllowedHost reports whether links may go to host: one of allow,
+// or a subdomain of one.  This server is always allowed, and so is the GIF
+// provider when the GIF picker is on, as the picker posts its links.
+func (h *Handler) automodAllowedHost(host string, allow []string) bool {
+	if host == "" {
+		return false
+	}
+	allowed := append([]string{}, allow...)
+	if h.discovery.PublicURL != "" {
+		if u, err := url.Parse(h.discovery.PublicURL); err == nil {
+			allowed = append(allowed, u.Hostname())
+		}
+	}
+	switch provider, _ := h.gifConfig(); provider {
+	case gifProviderTenor:
+		allowed = append(allowed, "tenor.com")
+	case gifProviderGiphy:
+		allowed = append(allowed, "giphy.com")
+	}
+	for _, d := range allowed {
+		d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "*."))
+		if d != "" && (host == d || strings.HasSuffix(host, "."+d)) {
+			return true
+		}
+	}
+	return false
+}
+
+// automodForeignInvite reports whether content has an invite to another
+// server in it.
+func (h *Handler) automodForeignInvite(content string) bool {
+	if automodForeignInvitePattern.MatchString(content) {
+		return true
+	}
+	for _, m := range automodChirmInvitePattern.FindAllStringSubmatch(content, -1) {
+		if _, err := h.db.GetInviteByCode(m[1]); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// automodRepeats records userID posting content in guildID, and returns
+// how many times they've posted it within window.
+func automodRepeats(guildID, userID, content string, window time.Duration) int {
+	text := strings.ToLower(strings.Join(strings.Fields(content), " "))
+	if text == "" {
+		return 0
+	}
+	now := time.Now()
+	key := guildID + "/" + userID
+	automodRecent.Lock()
+	defer automodRecent.Unlock()
+	if len(automodRecent.m) > 10000 {
+		for k, sent := range automodRecent.m {
+			if len(sent) == 0 || now.Sub(sent[len(sent)-1].at) > time.Hour {
+				delete(automodRecent.m, k)
+			}
+		}
+	}
+	sent := automodRecent.m[key][:0]
+	n := 1
+	for _, s := range automodRecent.m[key] {
+		if now.Sub(s.at) <= window {
+			sent = append(sent, s)
+			if s.text == text {
+				n++
+			}
+		}
+	}
+	automodRecent.m[key] = append(sent, automodSent{text: text, at: now})
+	return n
+}
+
+// automodTimedOut reports whether u is timed out in guildID, answering
+// the request if they are.
+func (h *Handler) automodTimedOut(w http.ResponseWriter, u *db.User, guildID string) bool {
+	until, timedOut := h.db.TimedOutUntil(guildID, u.ID, time.Now())
+	if timedOut {
+		errResp(w, http.StatusForbidden, "you're timed out until "+until.UTC().Format(time.RFC3339))
+	}
+	return timedOut
+}
+
+// applyAutomod does what v says about content, posted by u in ch as
+// message messageID, or stopped if that's empty.
+func (h *Handler) applyAutomod(v *automodVerdict, u *db.User, ch *db.Channel, content, messageID string) {
+	reason := v.Reason()
+	if v.Action.Delete {
+		h.db.AddAuditEntry(db.AuditEntry{Action: "automod.delete", TargetID: u.ID,
+			Details: fmt.Sprintf("stopped a message from %s in #%s: %s", u.Username, ch.Name, reason)})
+	}
+	if v.Action.Timeout > 0 {
+		until := time.Now().Add(time.Duration(v.Action.Timeout) * time.Minute)
+		if h.db.TimeOut(ch.GuildID, u.ID, until, reason) == nil {
+			h.db.AddAuditEntry(db.AuditEntry{Action: "automod.timeout", TargetID: u.ID,
+				Details: fmt.Sprintf("timed out %s for %d minutes: %s", u.Username, v.Action.Timeout, reason)})
+			h.hub.relayToUser(u.ID, WSEvent{Type: "automod.timeout", Data: map[string]interface{}{
+				"guild_id": ch.GuildID, "until": until.UTC(), "reason": reason,
+			}})
+		}
+	}
+	if v.Action.Flag {
+		f, err := h.db.CreateAutomodFlag(db.AutomodFlag{
+			GuildID:   ch.GuildID,
+			ChannelID: ch.ID,
+			MessageID: messageID,
+			UserID:    u.ID,
+			Filter:    v.Hits[0].Filter,
+			Reason:    reason,
+			Content:   content,
+		})
+		if err == nil {
+			h.db.AddAuditEntry(db.AuditEntry{Action: "automod.flag", TargetID: f.ID,
+				Details: fmt.Sprintf("flagged a message from %s in #%s: %s", u.Username, ch.Name, reason)})
+		}
+	}
+}
+
+// requireGuildModerator returns the caller if they have Manage Messages in
+// guildID, and otherwise answers the request itself.
+func (h *Handler) requireGuildModerator(w http.ResponseWriter, r *http.Request, guildID string) (*db.User, bool) {
+	u, isMember := h.requireGuildMember(w, r, guildID)
+	if !isMember {
+		return nil, false
+	}
+	if !h.db.HasGuildPermission(u, guildID, db.PermManageMessages) {
+		errResp(w, http.StatusForbidden, "insufficient permissions")
+		return nil, false
+	}
+	return u, true
+}
+
+// GetAutomodConfig handles GET /api/automod?guild= (guild admins).
+func (h *Handler) GetAutomodConfig(w http.ResponseWriter, r *http.Request) {
+	guildID := guildParam(r)
+	if _, isAdmin := h.requireGuildAdmin(w, r, guildID); !isAdmin {
+		return
+	}
+	c, err := h.db.GetAutomodConfig(guildID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to load auto-moderation settings")
+		return
+	}
+	ok(w, c)
+}
+
+// validateAutomodConfig checks c for guildID, tidying it, and returns
+// what's wrong with it, if anything.
+func (h *Handler) validateAutomodConfig(c *db.AutomodConfig, guildID string) string {
+	words := []string{}
+	for _, w := range c.BannedWords.Words {
+		if w = strings.TrimSpace(w); w == "" {
+			continue
+		}
+		if len(w) > automodMaxWordLen {
+			return fmt.Sprintf("banned words must be at most %d characters", automodMaxWordLen)
+		}
+		words = append(words, w)
+	}
+	if len(words) > automodMaxWords {
+		return fmt.Sprintf("at most %d banned words", automodMaxWords)
+	}
+	c.BannedWords.Words = words
+	domains := []string{}
+	for _, d := range c.Links.Allow {
+		d = strings.ToLower(strings.TrimSpace(d))
+		d = strings.TrimPrefix(strings.TrimPrefix(d, "https://"), "http://")
+		if d = strings.Trim(d, "/"); d == "" {
+			continue
+		}
+		if strings.ContainsAny(d, "/ ") {
+			return "allowed links must be domains, like example.com"
+		}
+		domains = append(domains, d)
+	}
+	if len(domains) > automodMaxDomains {
+		return fmt.Sprintf("at most %d allowed domains", automodMaxDomains)
+	}
+	c.Links.Allow = domains
+	switch {
+	case c.Repeats.Count < 2 || c.Repeats.Count > 20:
+		return "repeats count must be 2-20"
+	case c.Repeats.Seconds < 5 || c.Repeats.Seconds > 3600:
+		return "repeats seconds must be 5-3600"
+	case c.Mentions.Max < 1 || c.Mentions.Max > 50:
+		return "mentions max must be 1-50"
+	}
+	for _, f := range []db.AutomodFilter{c.BannedWords.AutomodFilter, c.Links.AutomodFilter, c.Invites, c.Repeats.AutomodFilter, c.Mentions.AutomodFilter} {
+		if f.Timeout < 0 || f.Timeout > automodMaxTimeout {
+			return "timeouts must be 0 to 10080 minutes"
+		}
+	}
+	if c.ExemptRoles == nil {
+		c.ExemptRoles = []string{}
+	}
+	for _, id := range c.ExemptRoles {
+		if role, err := h.db.GetRoleByID(id); err != nil || role.GuildID != guildID {
+			return "exempt role not found"
+		}
+	}
+	if c.ExemptChannels == nil {
+		c.ExemptChannels = []string{}
+	}
+	for _, id := range c.ExemptChannels {
+		if !h.inGuild(guildID, id, "") {
+			return "exempt channel not found"
+		}
+	}
+	return ""
+}
+
+// SetAutomodConfig handles PUT /api/automod?guild= (guild admins): the
+// whole of the guild's settings.
+func (h *Handler) SetAutomodConfig(w http.ResponseWriter, r *http.Request) {
+	guildID := guildParam(r)
+	u, isAdmin := h.requireGuildAdmin(w, r, guildID)
+	if !isAdmin {
+		return
+	}
+	c := db.DefaultAutomodConfig()
+	if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if msg := h.validateAutomodConfig(c, guildID); msg != "" {
+		errResp(w, http.StatusBadRequest, msg)
+		return
+	}
+	if len(c.BannedWords.Words) > 0 && automationPattern(automodWordsPattern(c.BannedWords.Words)) == nil {
+		errResp(w, http.StatusBadRequest, "banned words make too large a pattern")
+		return
+	}
+	if err := h.db.SetAutomodConfig(guildID, c); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to save auto-moderation settings")
+		return
+	}
+	var on []string
+	for _, f := range []struct {
+		name    string
+		enabled bool
+	}{{"banned words", c.BannedWords.Enabled}, {"links", c.Links.Enabled}, {"invites", c.Invites.Enabled},
+		{"repeats", c.Repeats.Enabled}, {"mentions", c.Mentions.Enabled}} {
+		if f.enabled {
+			on = append(on, f.name)
+		}
+	}
+	details := "turned auto-moderation off"
+	if len(on) > 0 {
+		details = "set auto-moderation filters: " + strings.Join(on, ", ")
+	}
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "automod.update", TargetID: guildID, Details: details})
+	ok(w, c)
+}
+
+// ListAutomodFlags handles GET /api/automod/flags?guild=&reviewed=1
+// (moderators): the newest flags, only those waiting for review unless
+// reviewed is set.
+func (h *Handler) ListAutomodFlags(w http.ResponseWriter, r *http.Request) {
+	guildID := guildParam(r)
+	if _, isMod := h.requireGuildModerator(w, r, guildID); !isMod {
+		return
+	}
+	flags, err := h.db.ListAutomodFlags(guildID, r.URL.Query().Get("reviewed") == "1", automodFlagsPerPage)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list flags")
+		return
+	}
+	ok(w, flags)
+}
+
+// ReviewAutomodFlagRequest is the body of POST /api/automod/flags/{id}/review.
+type ReviewAutomodFlagRequest struct {
+	Outcome string `json:"outcome"` // dismissed, or deleted to delete the message
+}
+
+// ReviewAutomodFlag handles POST /api/automod/flags/{id}/review
+// (moderators).
+func (h *Handler) ReviewAutomodFlag(w http.ResponseWriter, r *http.Request) {
+	guildID := db.DefaultGuild
+	f, err := h.db.GetAutomodFlag(chi.URLParam(r, "id"))
+	if err == nil {
+		guildID = f.GuildID
+	}
+	u, isMod := h.requireGuildModerator(w, r, guildID)
+	if !isMod {
+		return
+	}
+	if err != nil {
+		errResp(w, http.StatusNotFound, "flag not found")
+		return
+	}
+	var req ReviewAutomodFlagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if req.Outcome != db.FlagDismissed && req.Outcome != db.FlagDeleted {
+		errResp(w, http.StatusBadRequest, "outcome must be dismissed or deleted")
+		return
+	}
+	if req.Outcome == db.FlagDeleted && f.MessageID != "" {
+		if msg, err := h.db.GetMessageByID(f.MessageID); err == nil {
+			if err := h.db.DeleteMessage(msg.ID); err != nil {
+				errResp(w, http.StatusInternalServerError, "failed to delete message")
+				return
+			}
+			h.hub.BroadcastToChannel(msg.ChannelID, WSEvent{Type: "message.delete", Data: map[string]string{"id": msg.ID, "channel_id": msg.ChannelID}})
+			h.federate("message.delete", msg)
+		}
+	}
+	if err := h.db.ReviewAutomodFlag(f.ID, u.ID, req.Outcome); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to review flag")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "automod.review", TargetID: f.ID,
+		Details: fmt.Sprintf("%s a flagged message from %s: %s", req.Outcome, f.Username, f.Reason)})
+	f, _ = h.db.GetAutomodFlag(f.ID)
+	ok(w, f)
+}
+
+// ListAutomodTimeouts handles GET /api/automod/timeouts?guild=
+// (moderators): who's timed out now.
+func (h *Handler) ListAutomodTimeouts(w http.ResponseWriter, r *http.Request) {
+	guildID := guildParam(r)
+	if _, isMod := h.requireGuildModerator(w, r, guildID); !isMod {
+		return
+	}
+	timeouts, err := h.db.ListTimeouts(guildID, time.Now())
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list timeouts")
+		return
+	}
+	ok(w, timeouts)
+}
+
+// EndAutomodTimeout handles DELETE /api/automod/timeouts/{userId}?guild=
+// (moderators): the member can post again.
+func (h *Handler) EndAutomodTimeout(w http.ResponseWriter, r *http.Request) {
+	guildID := guildParam(r)
+	u, isMod := h.requireGuildModerator(w, r, guildID)
+	if !isMod {
+		return
+	}
+	userID := chi.URLParam(r, "userId")
+	ended, err := h.db.EndTimeout(guildID, userID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to end timeout")
+		return
+	}
+	if !ended {
+		errResp(w, http.StatusNotFound, "not timed out")
+		return
+	}
+	name := userID
+	if target, err := h.db.GetUserByID(userID); err == nil {
+		name = target.Username
+	}
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "automod.timeout_end", TargetID: userID, Details: "ended the timeout of " + name})
+	h.hub.relayToUser(userID, WSEvent{Type: "automod.timeout", Data: map[string]interface{}{"guild_id": guildID, "until": nil}})
+	ok(w, map[string]string{"message": "timeout ended"})
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// ─── Announcement banner ─────────────────────────────────────────────────────
+//
+// Admins can show one announcement across the top of the app, say to warn of
+// downtime, without posting it in every channel.  It's three settings —
+// banner_text, banner_severity and banner_expires_at — which public settings
+// carry while it's current, and saving any of them sends everyone a
+// settings.banner event.  People can dismiss it; a changed banner shows again.
+
+// Banner severities, from least to most urgent.
+var bannerSeverities = map[string]bool{"info": true, "warning": true, "critical": true}
+
+// maxBannerText caps banner_text, in characters.
+const maxBannerText = 500
+
+// Banner is the announcement in public settings and settings.banner events.
+type Banner struct {
+	ID        string     `json:"id"` // changes when the banner does, for dismissals
+	Text      string     `json:"text"`
+	Severity  string     `json:"severity"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// currentBanner returns the banner, or nil when there's none or it has
+// expired.
+func (h *Handler) currentBanner() *Banner {
+	text, _ := h.db.GetSetting("banner_text")
+	if text == "" {
+		return nil
+	}
+	b := &Banner{Text: text, Severity: "info"}
+	if s, _ := h.db.GetSetting("banner_severity"); bannerSeverities[s] {
+		b.Severity = s
+	}
+	expires, _ := h.db.GetSetting("banner_expires_at")
+	if expires != "" {
+		t, err := time.Parse(time.RFC3339, expires)
+		if err == nil {
+			if !t.After(time.Now()) {
+				return nil
+			}
+			b.ExpiresAt = &t
+		}
+	}
+	sum := sha256.Sum256([]byte(b.Severity + "\x00" + expires + "\x00" + text))
+	b.ID = hex.EncodeToString(sum[:8])
+	return b
+}
+
+// validBannerSetting reports whether v may be saved as banner setting k.
+func validBannerSetting(k, v string) bool {
+	switch k {
+	case "banner_text":
+		return len([]rune(v)) <= maxBannerText
+	case "banner_severity":
+		return bannerSeverities[v]
+	case "banner_expires_at":
+		if v == "" {
+			return true
+		}
+		_, err := time.Parse(time.RFC3339, v)
+		return err == nil
+	}
+	return false
+}
+
+// publicBannerSettings adds the current banner, if any, to public settings.
+func (h *Handler) publicBannerSettings(result map[string]string) {
+	b := h.currentBanner()
+	if b == nil {
+		return
+	}
+	result["banner_id"] = b.ID
+	result["banner_text"] = b.Text
+	result["banner_severity"] = b.Severity
+	if b.ExpiresAt != nil {
+		result["banner_expires_at"] = b.ExpiresAt.Format(time.RFC3339)
+	}
+}
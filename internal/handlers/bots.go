@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+)
+
+// ─── Bots and the gateway ────────────────────────────────────────────────────
+//
+// Admins make bot accounts, each with a token it sends as
+// "Authorization: Bot <token>".  With it a bot uses the REST API as its own
+// user, within the permissions its roles give it, and connects to /ws as
+// the gateway.  A bot declares the intents it wants there, in
+// ?intents=messages,reactions, and is only sent the events they cover, so
+// a moderation bot isn't woken by every voice speaking flag.  Events no
+// intent covers, such as maintenance, go to every bot.  Unlike a browser, a
+// bot needn't subscribe to a channel to get its messages: it's sent those
+// of every channel it can see.
+
+// Gateway intents, as bits of Client.intents.
+const (
+	IntentGuilds    = 1 << iota // guilds, channels, categories, settings, emoji, stickers, sounds and events
+	IntentMembers               // members joining and leaving
+	IntentMessages              // messages and their attachments
+	IntentReactions             // reactions
+	IntentTyping                // typing
+	IntentVoice                 // voice rooms, stages, broadcasts and calls
+)
+
+// intentNames are the intents a bot may ask for, by name.
+var intentNames = map[string]int{
+	"guilds":    IntentGuilds,
+	"members":   IntentMembers,
+	"messages":  IntentMessages,
+	"reactions": IntentReactions,
+	"typing":    IntentTyping,
+	"voice":     IntentVoice,
+}
+
+// eventIntents is the intent that covers each kind of event, by the event
+// type up to its first dot.
+var eventIntents = map[string]int{
+	"guild":      IntentGuilds,
+	"event":      IntentGuilds,
+	"channel":    IntentGuilds,
+	"channels":   IntentGuilds,
+	"category":   IntentGuilds,
+	"categories": IntentGuilds,
+	"settings":   IntentGuilds,
+	"emoji":      IntentGuilds,
+	"sticker":    IntentGuilds,
+	"sound":      IntentGuilds,
+	"member":     IntentMembers,
+	"message":    IntentMessages,
+	"attachment": IntentMessages,
+	"upload":     IntentMessages,
+	"reaction":   IntentReactions,
+	"typing":     IntentTyping,
+	"voice":      IntentVoice,
+	"stage":      IntentVoice,
+	"broadcast":  IntentVoice,
+	"call":       IntentVoice,
+}
+
+// parseIntents reads a comma-separated list of intent names.
+func parseIntents(list string) (int, bool) {
+	intents := 0
+	for _, name := range strings.Split(list, ",") {
+		bit, found := intentNames[strings.TrimSpace(name)]
+		if !found {
+			return 0, false
+		}
+		intents |= bit
+	}
+	return intents, true
+}
+
+// intentList names the intents in intents, in their order above.
+func intentList(intents int) []string {
+	names := []string{}
+	for _, name := range []string{"guilds", "members", "messages", "reactions", "typing", "voice"} {
+		if intents&intentNames[name] != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+var eventTypePrefix = []byte(`{"type":"`)
+
+// wants reports whether c is to be sent data, a marshalled WSEvent: always
+// for a browser, and for a bot or IRC client if one of its intents covers
+// the event.
+func (c *Client) wants(data []byte) bool {
+	if (!c.bot && c.irc == nil) || !bytes.HasPrefix(data, eventTypePrefix) {
+		return true
+	}
+	typ := data[len(eventTypePrefix):]
+	if end := bytes.IndexByte(typ, '"'); end >= 0 {
+		typ = typ[:end]
+	}
+	if dot := bytes.IndexByte(typ, '.'); dot >= 0 {
+		typ = typ[:dot]
+	}
+	need, covered := eventIntents[string(typ)]
+	return !covered || c.intents&need != 0
+}
+
+// readyEvent is the first event a bot is sent on connecting.
+func readyEvent(u *db.User, intents int) WSEvent {
+	return WSEvent{Type: "ready", Data: map[string]interface{}{"user": u, "intents": intentList(intents)}}
+}
+
+// botClaims looks up a bot's token for the auth service.
+func (h *Handler) botClaims(token string) (string, string, error) {
+	u, err := h.db.BotByToken(token)
+	if err != nil {
+		return "", "", err
+	}
+	return u.ID, u.Username, nil
+}
+
+// disconnectUser closes userID's WebSocket connections, logging why.
+func (h *Hub) disconnectUser(userID, reason string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.clients {
+		if client.userID == userID {
+			client.log.Info("ws closed", "reason", reason)
+			client.close()
+		}
+	}
+}
+
+// ListBots handles GET /api/bots (admin only).
+func (h *Handler) ListBots(w http.ResponseWriter, r *http.Request) {
+	if _, isAdmin := h.requireAdmin(w, r); !isAdmin {
+		return
+	}
+	bots, err := h.db.ListBots()
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list bots")
+		return
+	}
+	ok(w, bots)
+}
+
+// CreateBotRequest is the body of POST /api/bots.
+type CreateBotRequest struct {
+	Username string `json:"username"`
+}
+
+// botToken is a bot's token, shown this once.
+type botToken struct {
+	Bot   *db.User `json:"bot"`
+	Token string   `json:"token"`
+}
+
+// CreateBot handles POST /api/bots (admin only): a new bot, and its token.
+func (h *Handler) CreateBot(w http.ResponseWriter, r *http.Request) {
+	admin, isAdmin := h.requireAdmin(w, r)
+	if !isAdmin {
+		return
+	}
+	var req CreateBotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if len(req.Username) < 2 || len(req.Username) > 32 {
+		errResp(w, http.StatusBadRequest, "username must be 2-32 characters")
+		return
+	}
+	if !validUsername.MatchString(req.Username) {
+		errResp(w, http.StatusBadRequest, "username may only contain letters, numbers, _ . -")
+		return
+	}
+	u, token, err := h.db.CreateBot(req.Username, admin.ID)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			errResp(w, http.StatusConflict, "username already taken")
+			return
+		}
+		errResp(w, http.StatusInternalServerError, "failed to create bot")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: admin.ID, Action: "bot.create", TargetID: u.ID, Details: "created bot " + u.Username})
+	h.hub.Broadcast(WSEvent{
+		Type: "member.new",
+		Data: map[string]interface{}{
+			"id":       u.ID,
+			"username": u.Username,
+			"avatar":   u.Avatar,
+			"is_owner": false,
+			"bot":      true,
+			"roles":    []interface{}{},
+		},
+	})
+	created(w, botToken{Bot: u, Token: token})
+}
+
+// botParam returns the bot the URL names, or answers 404.
+func (h *Handler) botParam(w http.ResponseWriter, r *http.Request) (*db.User, bool) {
+	u, err := h.db.GetUserByID(chi.URLParam(r, "id"))
+	if err != nil || !u.Bot {
+		errResp(w, http.StatusNotFound, "bot not found")
+		return nil, false
+	}
+	return u, true
+}
+
+// ResetBotToken handles POST /api/bots/{id}/token (admin only): a new
+// token for the bot, which is disconnected from the gateway.
+func (h *Handler) ResetBotToken(w http.ResponseWriter, r *http.Request) {
+	admin, isAdmin := h.requireAdmin(w, r)
+	if !isAdmin {
+		return
+	}
+	u, found := h.botParam(w, r)
+	if !found {
+		return
+	}
+	token, err := h.db.ResetBotToken(u.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to reset token")
+		return
+	}
+	h.hub.disconnectUser(u.ID, "bot token reset")
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: admin.ID, Action: "bot.token", TargetID: u.ID, Details: "reset the token of bot " + u.Username})
+	ok(w, botToken{Bot: u, Token: token})
+}
+
+// DeleteBot handles DELETE /api/bots/{id} (admin only).
+func (h *Handler) DeleteBot(w http.ResponseWriter, r *http.Request) {
+	admin, isAdmin := h.requireAdmin(w, r)
+	if !isAdmin {
+		return
+	}
+	u, found := h.botParam(w, r)
+	if !found {
+		return
+	}
+	if err := h.db.DeleteBot(u.ID); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to delete bot")
+		return
+	}
+	h.hub.disconnectUser(u.ID, "bot deleted")
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: admin.ID, Action: "bot.delete", TargetID: u.ID, Details: "deleted bot " + u.Username})
+	h.hub.Broadcast(WSEvent{Type: "member.leave", Data: map[string]string{"guild_id": db.DefaultGuild, "id": u.ID}})
+	ok(w, map[string]string{"message": "deleted"})
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+)
+
+// ─── Broadcast channels ───────────────────────────────────────────────────────
+//
+// A broadcast channel (type "broadcast") has one live streamer and any number
+// of listeners, for radio-style or DJ sessions.  It needs the SFU: the
+// streamer uploads a single audio track and the server fans it out, so the
+// streamer's upload doesn't grow with the audience.  Listeners join without a
+// microphone.  Anyone with the Broadcast permission may go live while nobody
+// else is; the streamer or a moderator ends it.  Like SFU rooms, the live
+// state belongs to the instance the room is on.
+
+var (
+	errNotBroadcast = errors.New("not a broadcast channel")
+	errAlreadyLive  = errors.New("someone else is already live")
+)
+
+func (h *Hub) isBroadcastChannel(channelID string) bool {
+	ch, err := h.db.GetChannelByID(channelID)
+	return err == nil && ch.Type == "broadcast"
+}
+
+// broadcastJoin makes sure a broadcast room has state once someone is in it.
+func (h *Hub) broadcastJoin(channelID string) {
+	h.broadcastsMu.Lock()
+	defer h.broadcastsMu.Unlock()
+	if _, ok := h.broadcasts[channelID]; !ok {
+		h.broadcasts[channelID] = ""
+	}
+}
+
+// broadcastStreamer returns who is live in channelID ("" if nobody) and
+// whether channelID is an active broadcast room at all.
+func (h *Hub) broadcastStreamer(channelID string) (string, bool) {
+	h.broadcastsMu.Lock()
+	defer h.broadcastsMu.Unlock()
+	s, ok := h.broadcasts[channelID]
+	return s, ok
+}
+
+// broadcastListener reports whether userID is in a broadcast without being
+// the one who is live.
+func (h *Hub) broadcastListener(channelID, userID string) bool {
+	s, ok := h.broadcastStreamer(channelID)
+	return ok && s != userID
+}
+
+// setBroadcastStreamer puts userID live, or ends the broadcast if userID is "".
+func (h *Hub) setBroadcastStreamer(channelID, userID string) error {
+	h.broadcastsMu.Lock()
+	cur, ok := h.broadcasts[channelID]
+	switch {
+	case !ok:
+		h.broadcastsMu.Unlock()
+		return errNotBroadcast
+	case userID != "" && cur != "" && cur != userID:
+		h.broadcastsMu.Unlock()
+		return errAlreadyLive
+	}
+	h.broadcasts[channelID] = userID
+	h.broadcastsMu.Unlock()
+	if cur != userID {
+		h.relayToVoiceRoom(channelID, WSEvent{Type: "broadcast.state", Data: h.broadcastSnapshot(channelID)}, nil)
+	}
+	return nil
+}
+
+// broadcastLeave ends the broadcast if its streamer left and drops the room's
+// state once it is empty.
+func (h *Hub) broadcastLeave(channelID, userID string) {
+	s, ok := h.broadcastStreamer(channelID)
+	if !ok {
+		return
+	}
+	if s == userID {
+		h.setBroadcastStreamer(channelID, "")
+	}
+	h.voiceRoomsMu.RLock()
+	local := len(h.voiceRooms[channelID])
+	h.voiceRoomsMu.RUnlock()
+	if local == 0 {
+		h.broadcastsMu.Lock()
+		delete(h.broadcasts, channelID)
+		h.broadcastsMu.Unlock()
+	}
+}
+
+func (h *Hub) broadcastSnapshot(channelID string) map[string]interface{} {
+	s, _ := h.broadcastStreamer(channelID)
+	return map[string]interface{}{"channel_id": channelID, "streamer_id": s}
+}
+
+// GoLive handles POST /api/voice/{channelId}/broadcast.
+func (h *Handler) GoLive(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	channelID := chi.URLParam(r, "channelId")
+	if !h.hub.userInLocalVoiceRoom(channelID, u.ID) {
+		errResp(w, http.StatusConflict, "join the channel first")
+		return
+	}
+	if h.hub.voicePermissions(channelID, u.ID)&db.PermBroadcast == 0 {
+		errResp(w, http.StatusForbidden, "no permission to broadcast in this channel")
+		return
+	}
+	if h.hub.voiceModeration(u.ID).Muted {
+		errResp(w, http.StatusForbidden, "you are server muted")
+		return
+	}
+	switch err := h.hub.setBroadcastStreamer(channelID, u.ID); err {
+	case nil:
+		ok(w, h.hub.broadcastSnapshot(channelID))
+	case errNotBroadcast:
+		errResp(w, http.StatusNotFound, err.Error())
+	default:
+		errResp(w, http.StatusConflict, err.Error())
+	}
+}
+
+// EndLive handles DELETE /api/voice/{channelId}/broadcast.  The streamer may
+// always stop; moderators may stop anyone.
+func (h *Handler) EndLive(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	channelID := chi.URLParam(r, "channelId")
+	streamer, isBroadcast := h.hub.broadcastStreamer(channelID)
+	if !isBroadcast {
+		errResp(w, http.StatusNotFound, errNotBroadcast.Error())
+		return
+	}
+	if streamer != u.ID && !h.hasChannelPermission(u, channelID, db.PermMuteMembers) {
+		errResp(w, http.StatusForbidden, "insufficient permissions")
+		return
+	}
+	h.hub.setBroadcastStreamer(channelID, "")
+	ok(w, h.hub.broadcastSnapshot(channelID))
+}
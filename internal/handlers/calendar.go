@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+)
+
+// ─── Calendar feeds ──────────────────────────────────────────────────────────
+//
+// Members can subscribe Google or Apple Calendar to the events they're
+// going to or interested in (see db/events.go).  The feed is iCal at
+// /api/v1/calendar/<token>.ics, which calendars fetch every so often, so a
+// moved or cancelled event shows up changed, or gone, by itself.  A
+// repeating event is one VEVENT with an RRULE, in its own timezone, so it
+// stays at 8pm across daylight saving.
+
+const (
+	calendarRefresh  = "PT1H"              // how often calendars are asked to look again
+	calendarKeepPast = 90 * 24 * time.Hour // how long a one-off stays in the feed after it ends
+)
+
+// calendarFeedStatus is the body of GET and POST /api/me/calendar.  URL is
+// only there when the feed has just been made.
+type calendarFeedStatus struct {
+	Enabled bool `json:"enabled"`
+	*db.CalendarFeed
+	URL string `json:"url,omitempty"`
+}
+
+// GetCalendarFeed handles GET /api/me/calendar: whether the caller has a
+// calendar feed.
+func (h *Handler) GetCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	feed, err := h.db.GetCalendarFeed(u.ID)
+	if err != nil {
+		ok(w, calendarFeedStatus{})
+		return
+	}
+	ok(w, calendarFeedStatus{Enabled: true, CalendarFeed: feed})
+}
+
+// CreateCalendarFeed handles POST /api/me/calendar: a new feed URL for the
+// caller, shown this once.  Any URL they had stops working.
+func (h *Handler) CreateCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if u.Bot {
+		errResp(w, http.StatusForbidden, "bots can't have calendar feeds")
+		return
+	}
+	token, err := h.db.ResetCalendarFeed(u.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to create calendar feed")
+		return
+	}
+	feed, err := h.db.GetCalendarFeed(u.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to create calendar feed")
+		return
+	}
+	created(w, calendarFeedStatus{
+		Enabled:      true,
+		CalendarFeed: feed,
+		URL:          h.inviteBaseURL(r) + "/api/v1/calendar/" + token + ".ics",
+	})
+}
+
+// DeleteCalendarFeed handles DELETE /api/me/calendar: the caller's feed URL
+// stops working.
+func (h *Handler) DeleteCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if err := h.db.DeleteCalendarFeed(u.ID); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to delete calendar feed")
+		return
+	}
+	ok(w, map[string]string{"message": "calendar feed deleted"})
+}
+
+// CalendarFeed handles GET /api/calendar/{token}.ics, which needs no
+// sign-in: the token's owner's events, as iCal.  Events in guilds they've
+// left are left out.
+func (h *Handler) CalendarFeed(w http.ResponseWriter, r *http.Request) {
+	u, err := h.db.UserByCalendarToken(strings.TrimSuffix(chi.URLParam(r, "token"), ".ics"))
+	if err != nil {
+		errResp(w, http.StatusNotFound, "calendar not found")
+		return
+	}
+	events, err := h.db.RSVPEvents(u.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list events")
+		return
+	}
+	serverName, _ := h.db.GetSetting("server_name")
+	if serverName == "" {
+		serverName = "Chirm"
+	}
+	base := h.inviteBaseURL(r)
+	host := strings.TrimPrefix(strings.TrimPrefix(base, "https://"), "http://")
+	now := time.Now()
+
+	var cal icalWriter
+	cal.line("BEGIN:VCALENDAR")
+	cal.line("VERSION:2.0")
+	cal.line("PRODID:-//Chirm//Server Events//EN")
+	cal.line("CALSCALE:GREGORIAN")
+	cal.line("METHOD:PUBLISH")
+	cal.line("X-WR-CALNAME:" + icalText(serverName+" events"))
+	cal.line("REFRESH-INTERVAL;VALUE=DURATION:" + calendarRefresh)
+	cal.line("X-PUBLISHED-TTL:" + calendarRefresh)
+	guilds := map[string]*db.Guild{}
+	for i := range events {
+		e := &events[i]
+		if !h.db.CanAccessGuild(u, e.GuildID) {
+			continue
+		}
+		length := time.Duration(e.Duration) * time.Minute
+		if e.Recurrence == db.RecurNone && e.StartsAt.Add(length).Before(now.Add(-calendarKeepPast)) {
+			continue
+		}
+		g, seen := guilds[e.GuildID]
+		if !seen {
+			g, _ = h.db.GetGuild(e.GuildID)
+			guilds[e.GuildID] = g
+		}
+		var where []string
+		if e.ChannelID != "" && h.db.HasChannelPermission(u, e.ChannelID, db.PermReadMessages) {
+			if ch, err := h.db.GetChannelByID(e.ChannelID); err == nil {
+				where = append(where, "#"+ch.Name)
+			}
+		}
+		if g != nil && g.Name != "" {
+			where = append(where, g.Name)
+		}
+
+		loc := time.UTC
+		if l, err := time.LoadLocation(e.Timezone); err == nil {
+			loc = l
+		}
+		cal.line("BEGIN:VEVENT")
+		cal.line("UID:" + e.ID + "@" + host)
+		cal.line("DTSTAMP:" + icalUTC(e.UpdatedAt))
+		cal.line("CREATED:" + icalUTC(e.CreatedAt))
+		cal.line("LAST-MODIFIED:" + icalUTC(e.UpdatedAt))
+		cal.line("DTSTART" + icalLocal(e.StartsAt, loc))
+		if e.Duration > 0 {
+			cal.line("DTEND" + icalLocal(e.StartsAt.Add(length), loc))
+		}
+		if rule := icalRRule(e.Recurrence); rule != "" {
+			cal.line("RRULE:" + rule)
+		}
+		cal.line("SUMMARY:" + icalText(e.Title))
+		if e.Description != "" {
+			cal.line("DESCRIPTION:" + icalText(e.Description))
+		}
+		if len(where) > 0 {
+			cal.line("LOCATION:" + icalText(strings.Join(where, " · ")))
+		}
+		cal.line("URL:" + base + "/")
+		if e.RSVP == db.RSVPGoing {
+			cal.line("STATUS:CONFIRMED")
+		} else {
+			cal.line("STATUS:TENTATIVE")
+		}
+		cal.line("END:VEVENT")
+	}
+	cal.line("END:VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="chirm.ics"`)
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Write([]byte(cal.String()))
+}
+
+// icalWriter builds an iCal document: lines end in CRLF, and are folded
+// at 75 bytes, as RFC 5545 asks.
+type icalWriter struct {
+	strings.Builder
+}
+
+func (c *icalWriter) line(s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		c.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+		limit = 74 // the space counts
+	}
+	c.WriteString(s + "\r\n")
+}
+
+// icalText escapes s for a TEXT value.
+var icalText = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace
+
+// icalUTC is t as an iCal UTC date-time.
+func icalUTC(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// icalLocal is t in loc as the rest of a DTSTART or DTEND line: in UTC
+// for UTC, and otherwise local time with a TZID, which calendars take IANA
+// names for.
+func icalLocal(t time.Time, loc *time.Location) string {
+	if loc == time.UTC {
+		return ":" + icalUTC(t)
+	}
+	return fmt.Sprintf(";TZID=%s:%s", loc.String(), t.In(loc).Format("20060102T150405"))
+}
+
+// icalRRule is the RRULE for how an event repeats; "" for a one-off.
+func icalRRule(recurrence string) string {
+	switch recurrence {
+	case db.RecurDaily:
+		return "FREQ=DAILY"
+	case db.RecurWeekly:
+		return "FREQ=WEEKLY"
+	case db.RecurBiweekly:
+		return "FREQ=WEEKLY;INTERVAL=2"
+	case db.RecurMonthly:
+		return "FREQ=MONTHLY"
+	}
+	return ""
+}
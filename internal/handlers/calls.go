@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"chirm/internal/db"
+)
+
+// ─── Direct calls ─────────────────────────────────────────────────────────────
+//
+// 1:1 calls between two members, outside any voice channel.  The caller sends
+// call.start; the callee's clients get call.incoming (and a push notification)
+// until one of them accepts or declines, or the call times out as missed.
+// Once answered, call.signal carries the WebRTC offer/answer/ICE between the
+// two parties — the same mesh signaling as voice rooms, scoped to the call.
+//
+// Call state lives in the calls table so every instance in a cluster sees the
+// same thing; only the ring timer is local to the instance that started it.
+
+const callRingTimeout = 45 * time.Second
+
+// handleCall dispatches the call.* events a client may send.
+func (c *Client) handleCall(eventType string, raw json.RawMessage) {
+	var d struct {
+		CallID  string          `json:"call_id"`
+		UserID  string          `json:"user_id"`
+		Video   bool            `json:"video"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if json.Unmarshal(raw, &d) != nil {
+		return
+	}
+	h := c.hub
+
+	if eventType == "call.start" {
+		c.startCall(d.UserID, d.Video)
+		return
+	}
+
+	call, err := h.db.GetCall(d.CallID)
+	if err != nil || (call.CallerID != c.userID && call.CalleeID != c.userID) {
+		return
+	}
+	switch eventType {
+	case "call.accept":
+		if call.CalleeID != c.userID {
+			return
+		}
+		if answered, _ := h.db.AnswerCall(call.ID); !answered {
+			return
+		}
+		h.stopRinging(call.ID)
+		if updated, err := h.db.GetCall(call.ID); err == nil {
+			call = updated
+		}
+		// Both sides hear about it: the caller starts the WebRTC offer and the
+		// callee's other devices stop ringing.
+		evt := WSEvent{Type: "call.accepted", Data: call}
+		h.relayToUser(call.CallerID, evt)
+		h.relayToUser(call.CalleeID, evt)
+
+	case "call.decline":
+		if call.CalleeID == c.userID && call.Status == db.CallRinging {
+			h.endCall(call.ID, db.CallDeclined)
+		}
+
+	case "call.hangup":
+		switch {
+		case call.Status == db.CallAnswered:
+			h.endCall(call.ID, db.CallEnded)
+		case call.Status == db.CallRinging && call.CallerID == c.userID:
+			h.endCall(call.ID, db.CallCancelled)
+		case call.Status == db.CallRinging:
+			h.endCall(call.ID, db.CallDeclined)
+		}
+
+	case "call.signal":
+		if call.Status != db.CallAnswered || len(d.Payload) == 0 {
+			return
+		}
+		h.relayToUser(call.Peer(c.userID), WSEvent{
+			Type: "call.signal",
+			Data: map[string]interface{}{
+				"call_id":      call.ID,
+				"from_user_id": c.userID,
+				"payload":      d.Payload,
+			},
+		})
+	}
+}
+
+func (c *Client) startCall(calleeID string, video bool) {
+	h := c.hub
+	fail := func(msg string) {
+		c.sendEvent(WSEvent{Type: "call.error", Data: map[string]string{"user_id": calleeID, "error": msg}})
+	}
+	if calleeID == "" || calleeID == c.userID {
+		return
+	}
+	callee, err := h.db.GetUserByID(calleeID)
+	if err != nil {
+		fail("User not found")
+		return
+	}
+	if live, _ := h.db.LiveCallsFor(c.userID); len(live) > 0 {
+		fail("You're already in a call")
+		return
+	}
+	call, err := h.db.CreateCall(c.userID, calleeID, video)
+	if err != nil {
+		fail("Could not start the call")
+		return
+	}
+	// Only the tab that placed the call tracks it.
+	c.sendEvent(WSEvent{Type: "call.ringing", Data: call})
+
+	if live, _ := h.db.LiveCallsFor(calleeID); len(live) > 1 {
+		fail(callee.Username + " is in another call")
+		h.endCall(call.ID, db.CallMissed)
+		return
+	}
+
+	h.relayToUser(calleeID, WSEvent{Type: "call.incoming", Data: call})
+	h.ringMu.Lock()
+	h.ringing[call.ID] = time.AfterFunc(callRingTimeout, func() {
+		h.endCall(call.ID, db.CallMissed)
+	})
+	h.ringMu.Unlock()
+
+	callerName := "Someone"
+	if u, err := h.db.GetUserByID(c.userID); err == nil {
+		callerName = u.Username
+	}
+	key := pushKeyCallVoice
+	if video {
+		key = pushKeyCallVideo
+	}
+	go pushToUser(h.db, calleeID, PushPayload{
+		Tag:    "chirm-call-" + call.ID,
+		Key:    key,
+		Params: map[string]string{"caller": callerName},
+	})
+}
+
+// endCall moves a live call to its final status and tells both parties.
+func (h *Hub) endCall(callID, status string) {
+	h.stopRinging(callID)
+	if ended, _ := h.db.EndCall(callID, status); !ended {
+		return
+	}
+	call, err := h.db.GetCall(callID)
+	if err != nil {
+		return
+	}
+	evt := WSEvent{Type: "call.ended", Data: call}
+	h.relayToUser(call.CallerID, evt)
+	h.relayToUser(call.CalleeID, evt)
+}
+
+func (h *Hub) stopRinging(callID string) {
+	h.ringMu.Lock()
+	defer h.ringMu.Unlock()
+	if t := h.ringing[callID]; t != nil {
+		t.Stop()
+		delete(h.ringing, callID)
+	}
+}
+
+// dropCallsAfterDisconnect ends userID's calls if they don't reconnect within
+// the voice reconnect grace period.
+func (h *Hub) dropCallsAfterDisconnect(userID string) {
+	time.AfterFunc(h.voiceGrace, func() {
+		if h.userConnected(userID) {
+			return
+		}
+		live, _ := h.db.LiveCallsFor(userID)
+		for _, call := range live {
+			switch {
+			case call.Status == db.CallAnswered:
+				h.endCall(call.ID, db.CallEnded)
+			case call.CallerID == userID:
+				h.endCall(call.ID, db.CallCancelled)
+			}
+			// A callee who closed the app may still answer from the push
+			// notification; the ring timer decides when that call is missed.
+		}
+	})
+}
+
+// userConnected reports whether userID has a WebSocket open on any instance.
+func (h *Hub) userConnected(userID string) bool {
+	return h.userConnectedHere(userID) || h.isRemoteClient(userID)
+}
+
+// userConnectedHere reports whether userID has a WebSocket open on this
+// instance.
+func (h *Hub) userConnectedHere(userID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.clients {
+		if c.userID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// ListCalls handles GET /api/calls: the caller's recent call history.
+func (h *Handler) ListCalls(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	calls, err := h.db.ListCalls(u.ID, 50)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list calls")
+		return
+	}
+	ok(w, calls)
+}
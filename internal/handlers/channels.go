@@ -11,7 +11,11 @@ import (
 )
 
 func (h *Handler) ListChannels(w http.ResponseWriter, r *http.Request) {
-	channels, err := h.db.ListChannels()
+	guildID := guildParam(r)
+	if _, isMember := h.requireGuildMember(w, r, guildID); !isMember {
+		return
+	}
+	channels, err := h.db.ListChannels(guildID)
 	if err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to list channels")
 		return
@@ -22,21 +26,31 @@ func (h *Handler) ListChannels(w http.ResponseWriter, r *http.Request) {
 	ok(w, channels)
 }
 
+// CreateChannelRequest is the body of POST /api/channels.
+type CreateChannelRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Emoji       string `json:"emoji"`
+	CategoryID  string `json:"category_id"`
+	GuildID     string `json:"guild_id"` // the default guild if empty
+}
+
 func (h *Handler) CreateChannel(w http.ResponseWriter, r *http.Request) {
-	_, isAdmin := h.requireAdmin(w, r)
-	if !isAdmin {
+	var req CreateChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
 		return
 	}
-
-	var req struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
-		Type        string `json:"type"`
-		Emoji       string `json:"emoji"`
-		CategoryID  string `json:"category_id"`
+	if req.GuildID == "" {
+		req.GuildID = db.DefaultGuild
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		errResp(w, http.StatusBadRequest, "invalid request")
+	_, isAdmin := h.requireGuildAdmin(w, r, req.GuildID)
+	if !isAdmin {
+		return
+	}
+	if !h.inGuild(req.GuildID, "", req.CategoryID) {
+		errResp(w, http.StatusBadRequest, "category not found")
 		return
 	}
 
@@ -45,80 +59,248 @@ func (h *Handler) CreateChannel(w http.ResponseWriter, r *http.Request) {
 		errResp(w, http.StatusBadRequest, "name required")
 		return
 	}
-	if req.Type == "" {
+	switch req.Type {
+	case "":
 		req.Type = "text"
+	case "text", "voice", "stage", "broadcast":
+	default:
+		errResp(w, http.StatusBadRequest, "invalid channel type")
+		return
 	}
 
-	channel, err := h.db.CreateChannel(req.Name, req.Description, req.Type, req.Emoji, req.CategoryID)
+	channel, err := h.db.CreateChannel(req.GuildID, req.Name, req.Description, req.Type, req.Emoji, req.CategoryID)
 	if err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to create channel")
 		return
 	}
 
-	h.hub.Broadcast(WSEvent{Type: "channel.new", Data: channel})
+	h.hub.BroadcastToGuild(req.GuildID, WSEvent{Type: "channel.new", Data: channel})
 	created(w, channel)
 }
 
+// UpdateChannelRequest is the body of PUT /api/channels/{id}.
+type UpdateChannelRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Emoji       string `json:"emoji"`
+	CategoryID  string `json:"category_id"`
+	// Voice channels only; omit to leave unchanged, "" to turn off.
+	VoiceLogChannelID *string `json:"voice_log_channel_id"`
+	// Voice quality caps; omit to leave unchanged, 0 for no cap.
+	AudioBitrate *int `json:"audio_bitrate"`
+	VideoHeight  *int `json:"video_height"`
+	// Text channels only: whether the IRC gateway offers it; omit to
+	// leave unchanged.
+	IRC *bool `json:"irc"`
+}
+
 func (h *Handler) UpdateChannel(w http.ResponseWriter, r *http.Request) {
-	_, isAdmin := h.requireAdmin(w, r)
+	id := chi.URLParam(r, "id")
+	guildID := h.db.ChannelGuild(id)
+	_, isAdmin := h.requireGuildAdmin(w, r, guildID)
 	if !isAdmin {
 		return
 	}
 
-	id := chi.URLParam(r, "id")
-	var req struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
-		Emoji       string `json:"emoji"`
-		CategoryID  string `json:"category_id"`
-	}
+	var req UpdateChannelRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errResp(w, http.StatusBadRequest, "invalid request")
 		return
 	}
+	if !h.inGuild(guildID, "", req.CategoryID) {
+		errResp(w, http.StatusBadRequest, "category not found")
+		return
+	}
+	if req.VoiceLogChannelID != nil && *req.VoiceLogChannelID != "" {
+		logCh, err := h.db.GetChannelByID(*req.VoiceLogChannelID)
+		if err != nil || logCh.Type != "text" || logCh.GuildID != guildID {
+			errResp(w, http.StatusBadRequest, "voice log channel must be a text channel")
+			return
+		}
+	}
+
+	current, err := h.db.GetChannelByID(id)
+	if err != nil {
+		errResp(w, http.StatusNotFound, "channel not found")
+		return
+	}
+	audioBitrate, videoHeight := current.AudioBitrate, current.VideoHeight
+	if req.AudioBitrate != nil {
+		audioBitrate = *req.AudioBitrate
+	}
+	if req.VideoHeight != nil {
+		videoHeight = *req.VideoHeight
+	}
+	if !validVoiceQuality(audioBitrate, videoHeight) {
+		errResp(w, http.StatusBadRequest, "audio bitrate must be 6-510 kbps and video height 144-2160 px")
+		return
+	}
+	if req.IRC != nil && *req.IRC && current.Type != "text" {
+		errResp(w, http.StatusBadRequest, "only text channels can be opened to IRC")
+		return
+	}
 
 	if err := h.db.UpdateChannel(id, req.Name, req.Description, req.Emoji, req.CategoryID); err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to update channel")
 		return
 	}
+	if audioBitrate != current.AudioBitrate || videoHeight != current.VideoHeight {
+		if err := h.db.SetVoiceQuality(id, audioBitrate, videoHeight); err != nil {
+			errResp(w, http.StatusInternalServerError, "failed to update channel")
+			return
+		}
+		q := h.hub.roomQuality(id)
+		h.hub.relayToVoiceRoom(id, WSEvent{Type: "voice.quality", Data: map[string]interface{}{
+			"channel_id":    id,
+			"audio_bitrate": q["audio_bitrate"],
+			"video_height":  q["video_height"],
+		}}, nil)
+	}
+	if req.VoiceLogChannelID != nil {
+		if err := h.db.SetVoiceLogChannel(id, *req.VoiceLogChannelID); err != nil {
+			errResp(w, http.StatusInternalServerError, "failed to update channel")
+			return
+		}
+	}
+	if req.IRC != nil && *req.IRC != current.IRC {
+		if err := h.db.SetChannelIRC(id, *req.IRC); err != nil {
+			errResp(w, http.StatusInternalServerError, "failed to update channel")
+			return
+		}
+		if !*req.IRC {
+			h.hub.closeIRCChannel(id)
+		}
+	}
 
 	channel, _ := h.db.GetChannelByID(id)
-	h.hub.Broadcast(WSEvent{Type: "channel.update", Data: channel})
+	h.hub.BroadcastToGuild(guildID, WSEvent{Type: "channel.update", Data: channel})
 	ok(w, channel)
 }
 
 func (h *Handler) DeleteChannel(w http.ResponseWriter, r *http.Request) {
-	_, isAdmin := h.requireAdmin(w, r)
+	id := chi.URLParam(r, "id")
+	guildID := h.db.ChannelGuild(id)
+	_, isAdmin := h.requireGuildAdmin(w, r, guildID)
 	if !isAdmin {
 		return
 	}
 
-	id := chi.URLParam(r, "id")
 	if err := h.db.DeleteChannel(id); err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to delete channel")
 		return
 	}
 
-	h.hub.Broadcast(WSEvent{Type: "channel.delete", Data: map[string]string{"id": id}})
+	h.hub.closeIRCChannel(id)
+	h.hub.BroadcastToGuild(guildID, WSEvent{Type: "channel.delete", Data: map[string]string{"id": id}})
 	ok(w, map[string]string{"message": "deleted"})
 }
 
-// ReorderChannels handles bulk position/category updates for drag-and-drop.
-func (h *Handler) ReorderChannels(w http.ResponseWriter, r *http.Request) {
-	_, isAdmin := h.requireAdmin(w, r)
+// ListChannelOverrides returns the per-role permission overrides of a channel.
+func (h *Handler) ListChannelOverrides(w http.ResponseWriter, r *http.Request) {
+	_, isAdmin := h.requireGuildAdmin(w, r, h.db.ChannelGuild(chi.URLParam(r, "id")))
 	if !isAdmin {
 		return
 	}
 
-	var req []struct {
-		ID         string `json:"id"`
-		Position   int    `json:"position"`
-		CategoryID string `json:"category_id"`
+	overrides, err := h.db.ListChannelOverrides(chi.URLParam(r, "id"))
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list overrides")
+		return
+	}
+	if overrides == nil {
+		overrides = []db.ChannelOverride{}
+	}
+	ok(w, overrides)
+}
+
+// SetChannelOverrideRequest is the body of PUT /api/channels/{id}/overrides/{roleId}.
+type SetChannelOverrideRequest struct {
+	Allow int `json:"allow"`
+	Deny  int `json:"deny"`
+}
+
+// SetChannelOverride creates or replaces a role's override in a channel.
+// Only voice permissions can currently be overridden per channel.
+func (h *Handler) SetChannelOverride(w http.ResponseWriter, r *http.Request) {
+	channelID := chi.URLParam(r, "id")
+	_, isAdmin := h.requireGuildAdmin(w, r, h.db.ChannelGuild(channelID))
+	if !isAdmin {
+		return
 	}
+
+	roleID := chi.URLParam(r, "roleId")
+	var req SetChannelOverrideRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errResp(w, http.StatusBadRequest, "invalid request")
 		return
 	}
+	if (req.Allow|req.Deny)&^(db.PermVoiceAll|db.PermSoundboard|db.PermBroadcast) != 0 {
+		errResp(w, http.StatusBadRequest, "only voice permissions can be overridden per channel")
+		return
+	}
+	if req.Allow&req.Deny != 0 {
+		errResp(w, http.StatusBadRequest, "a permission cannot be both allowed and denied")
+		return
+	}
+	ch, err := h.db.GetChannelByID(channelID)
+	if err != nil {
+		errResp(w, http.StatusNotFound, "channel not found")
+		return
+	}
+	if role, err := h.db.GetRoleByID(roleID); err != nil || role.GuildID != ch.GuildID {
+		errResp(w, http.StatusNotFound, "role not found")
+		return
+	}
+
+	if err := h.db.SetChannelOverride(channelID, roleID, req.Allow, req.Deny); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to save override")
+		return
+	}
+	ok(w, db.ChannelOverride{ChannelID: channelID, RoleID: roleID, Allow: req.Allow, Deny: req.Deny})
+}
+
+func (h *Handler) DeleteChannelOverride(w http.ResponseWriter, r *http.Request) {
+	_, isAdmin := h.requireGuildAdmin(w, r, h.db.ChannelGuild(chi.URLParam(r, "id")))
+	if !isAdmin {
+		return
+	}
+
+	if err := h.db.DeleteChannelOverride(chi.URLParam(r, "id"), chi.URLParam(r, "roleId")); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to delete override")
+		return
+	}
+	ok(w, map[string]string{"message": "deleted"})
+}
+
+// ChannelPosition is one entry of the body of POST /api/channels/reorder.
+type ChannelPosition struct {
+	ID         string `json:"id"`
+	Position   int    `json:"position"`
+	CategoryID string `json:"category_id"`
+}
+
+// ReorderChannels handles bulk position/category updates for drag-and-drop.
+// The channels must all be in one guild.
+func (h *Handler) ReorderChannels(w http.ResponseWriter, r *http.Request) {
+	var req []ChannelPosition
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	guildID := db.DefaultGuild
+	if len(req) > 0 {
+		guildID = h.db.ChannelGuild(req[0].ID)
+	}
+	_, isAdmin := h.requireGuildAdmin(w, r, guildID)
+	if !isAdmin {
+		return
+	}
+	for _, p := range req {
+		if !h.inGuild(guildID, p.ID, p.CategoryID) {
+			errResp(w, http.StatusBadRequest, "channels must all be in one guild")
+			return
+		}
+	}
 
 	orders := make([]struct {
 		ID         string
@@ -138,15 +320,19 @@ func (h *Handler) ReorderChannels(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	channels, _ := h.db.ListChannels()
-	h.hub.Broadcast(WSEvent{Type: "channels.reorder", Data: channels})
+	channels, _ := h.db.ListChannels(guildID)
+	h.hub.BroadcastToGuild(guildID, WSEvent{Type: "channels.reorder", Data: channels})
 	ok(w, map[string]string{"message": "reordered"})
 }
 
 // ─── Channel Categories ────────────────────────────────────────────────────────
 
 func (h *Handler) ListCategories(w http.ResponseWriter, r *http.Request) {
-	cats, err := h.db.ListCategories()
+	guildID := guildParam(r)
+	if _, isMember := h.requireGuildMember(w, r, guildID); !isMember {
+		return
+	}
+	cats, err := h.db.ListCategories(guildID)
 	if err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to list categories")
 		return
@@ -154,17 +340,23 @@ func (h *Handler) ListCategories(w http.ResponseWriter, r *http.Request) {
 	ok(w, cats)
 }
 
+// CreateCategoryRequest is the body of POST /api/channel-categories.
+type CreateCategoryRequest struct {
+	Name    string `json:"name"`
+	GuildID string `json:"guild_id"` // the default guild if empty
+}
+
 func (h *Handler) CreateCategory(w http.ResponseWriter, r *http.Request) {
-	_, isAdmin := h.requireAdmin(w, r)
-	if !isAdmin {
+	var req CreateCategoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
 		return
 	}
-
-	var req struct {
-		Name string `json:"name"`
+	if req.GuildID == "" {
+		req.GuildID = db.DefaultGuild
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		errResp(w, http.StatusBadRequest, "invalid request")
+	_, isAdmin := h.requireGuildAdmin(w, r, req.GuildID)
+	if !isAdmin {
 		return
 	}
 	req.Name = strings.TrimSpace(req.Name)
@@ -173,26 +365,30 @@ func (h *Handler) CreateCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cat, err := h.db.CreateCategory(req.Name)
+	cat, err := h.db.CreateCategory(req.GuildID, req.Name)
 	if err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to create category")
 		return
 	}
 
-	h.hub.Broadcast(WSEvent{Type: "category.new", Data: cat})
+	h.hub.BroadcastToGuild(req.GuildID, WSEvent{Type: "category.new", Data: cat})
 	created(w, cat)
 }
 
+// UpdateCategoryRequest is the body of PUT /api/channel-categories/{id}.
+type UpdateCategoryRequest struct {
+	Name string `json:"name"`
+}
+
 func (h *Handler) UpdateCategory(w http.ResponseWriter, r *http.Request) {
-	_, isAdmin := h.requireAdmin(w, r)
+	id := chi.URLParam(r, "id")
+	guildID := h.categoryGuild(id)
+	_, isAdmin := h.requireGuildAdmin(w, r, guildID)
 	if !isAdmin {
 		return
 	}
 
-	id := chi.URLParam(r, "id")
-	var req struct {
-		Name string `json:"name"`
-	}
+	var req UpdateCategoryRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errResp(w, http.StatusBadRequest, "invalid request")
 		return
@@ -203,25 +399,38 @@ func (h *Handler) UpdateCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cats, _ := h.db.ListCategories()
-	h.hub.Broadcast(WSEvent{Type: "categories.update", Data: cats})
+	cats, _ := h.db.ListCategories(guildID)
+	h.hub.BroadcastToGuild(guildID, WSEvent{Type: "categories.update", Data: cats})
 	ok(w, map[string]string{"message": "updated"})
 }
 
+// CategoryPosition is one entry of the body of
+// POST /api/channel-categories/reorder.
+type CategoryPosition struct {
+	ID       string `json:"id"`
+	Position int    `json:"position"`
+}
+
 func (h *Handler) ReorderCategories(w http.ResponseWriter, r *http.Request) {
-	_, isAdmin := h.requireAdmin(w, r)
-	if !isAdmin {
+	var orders []CategoryPosition
+	if err := json.NewDecoder(r.Body).Decode(&orders); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
 		return
 	}
-
-	var orders []struct {
-		ID       string `json:"id"`
-		Position int    `json:"position"`
+	guildID := db.DefaultGuild
+	if len(orders) > 0 {
+		guildID = h.categoryGuild(orders[0].ID)
 	}
-	if err := json.NewDecoder(r.Body).Decode(&orders); err != nil {
-		errResp(w, http.StatusBadRequest, "invalid request")
+	_, isAdmin := h.requireGuildAdmin(w, r, guildID)
+	if !isAdmin {
 		return
 	}
+	for _, o := range orders {
+		if h.categoryGuild(o.ID) != guildID {
+			errResp(w, http.StatusBadRequest, "categories must all be in one guild")
+			return
+		}
+	}
 
 	mapped := make([]struct{ ID string; Position int }, len(orders))
 	for i, o := range orders {
@@ -233,24 +442,47 @@ func (h *Handler) ReorderCategories(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cats, _ := h.db.ListCategories()
-	h.hub.Broadcast(WSEvent{Type: "categories.update", Data: cats})
+	cats, _ := h.db.ListCategories(guildID)
+	h.hub.BroadcastToGuild(guildID, WSEvent{Type: "categories.update", Data: cats})
 	ok(w, map[string]string{"message": "reordered"})
 }
 
 func (h *Handler) DeleteCategory(w http.ResponseWriter, r *http.Request) {
-	_, isAdmin := h.requireAdmin(w, r)
+	id := chi.URLParam(r, "id")
+	guildID := h.categoryGuild(id)
+	_, isAdmin := h.requireGuildAdmin(w, r, guildID)
 	if !isAdmin {
 		return
 	}
 
-	id := chi.URLParam(r, "id")
 	if err := h.db.DeleteCategory(id); err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to delete category")
 		return
 	}
 
-	channels, _ := h.db.ListChannels()
-	h.hub.Broadcast(WSEvent{Type: "category.delete", Data: map[string]interface{}{"id": id, "channels": channels}})
+	channels, _ := h.db.ListChannels(guildID)
+	h.hub.BroadcastToGuild(guildID, WSEvent{Type: "category.delete", Data: map[string]interface{}{"id": id, "guild_id": guildID, "channels": channels}})
 	ok(w, map[string]string{"message": "deleted"})
 }
+
+// categoryGuild returns the guild category id is in, or the default guild
+// if there's no such category.
+func (h *Handler) categoryGuild(id string) string {
+	if c, err := h.db.GetCategoryByID(id); err == nil {
+		return c.GuildID
+	}
+	return db.DefaultGuild
+}
+
+// inGuild reports whether channelID and categoryID, each if not empty, are
+// in guildID.
+func (h *Handler) inGuild(guildID, channelID, categoryID string) bool {
+	if channelID != "" && h.db.ChannelGuild(channelID) != guildID {
+		return false
+	}
+	if categoryID != "" {
+		c, err := h.db.GetCategoryByID(categoryID)
+		return err == nil && c.GuildID == guildID
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"chirm/internal/db"
+)
+
+// ─── Client upgrades ─────────────────────────────────────────────────────────
+//
+// The web app is embedded in the binary, so an upgrade changes it, but tabs
+// left open carry on running the old one against the new API.  Each version
+// of the app's files gets a build number, bumped when the server starts with
+// files that differ from the last run's.  After an upgrade an admin sends a
+// client.reload event naming the oldest build that still works, and tabs
+// running an older one clear their cached files and reload.  The minimum is
+// kept, so a tab that was asleep through the event reloads when its
+// WebSocket reconnects.
+
+// ClientVersion is the body of GET /api/client-version and the data of the
+// client.reload event.
+type ClientVersion struct {
+	Build    int    `json:"build"`     // of the app this server serves
+	Hash     string `json:"hash"`      // of its files
+	MinBuild int    `json:"min_build"` // tabs running an older build should reload
+}
+
+// SetClientFiles numbers the build of the web app in fsys, which is a new
+// one if its files changed since the last start.
+func (h *Handler) SetClientFiles(fsys fs.FS) {
+	sum := sha256.New()
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		f, err := fsys.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		io.WriteString(sum, p+"\x00")
+		_, err = io.Copy(sum, f)
+		return err
+	})
+	if err != nil {
+		slog.Error("hashing web app files", "err", err)
+		return
+	}
+	hash := hex.EncodeToString(sum.Sum(nil))[:16]
+
+	v, _ := h.db.GetSetting("client_build")
+	build, _ := strconv.Atoi(v)
+	if prev, _ := h.db.GetSetting("client_hash"); prev != hash || build == 0 {
+		build++
+		h.db.SetSetting("client_build", strconv.Itoa(build))
+		h.db.SetSetting("client_hash", hash)
+		slog.Info("new web app build", "build", build, "hash", hash)
+	}
+	h.clientBuild, h.clientHash = build, hash
+}
+
+func (h *Handler) clientVersion() ClientVersion {
+	v, _ := h.db.GetSetting("client_min_build")
+	minBuild, _ := strconv.Atoi(v)
+	return ClientVersion{Build: h.clientBuild, Hash: h.clientHash, MinBuild: minBuild}
+}
+
+// GetClientVersion handles GET /api/client-version.  Anyone may ask.
+func (h *Handler) GetClientVersion(w http.ResponseWriter, r *http.Request) {
+	ok(w, h.clientVersion())
+}
+
+// ClientReloadRequest is the body of POST /api/admin/client-reload.
+type ClientReloadRequest struct {
+	// MinBuild is the oldest build that still works with this server;
+	// 0 means the current one, so every older tab reloads.
+	MinBuild int `json:"min_build"`
+}
+
+// ReloadClients handles POST /api/admin/client-reload (admin only): it
+// raises the minimum build and tells every tab, so those running an older
+// one reload.
+func (h *Handler) ReloadClients(w http.ResponseWriter, r *http.Request) {
+	u, isAdmin := h.requireAdmin(w, r)
+	if !isAdmin {
+		return
+	}
+	var req ClientReloadRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errResp(w, http.StatusBadRequest, "invalid request")
+			return
+		}
+	}
+	if req.MinBuild == 0 {
+		req.MinBuild = h.clientBuild
+	}
+	if req.MinBuild < 1 || req.MinBuild > h.clientBuild {
+		errResp(w, http.StatusBadRequest, "min_build must be between 1 and the current build, "+strconv.Itoa(h.clientBuild))
+		return
+	}
+	if err := h.db.SetSetting("client_min_build", strconv.Itoa(req.MinBuild)); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to save minimum build")
+		return
+	}
+	v := h.clientVersion()
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "client.reload", Details: "build " + strconv.Itoa(v.MinBuild) + " or later"})
+	h.hub.Broadcast(WSEvent{Type: "client.reload", Data: v})
+	ok(w, v)
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"chirm/internal/db"
+)
+
+// ─── Multi-instance broker ───────────────────────────────────────────────────
+//
+// cluster lets several Chirm processes that share one database behave like a
+// single hub as far as voice rooms are concerned.  Each instance writes its own
+// voice membership to the database and publishes room/user events to a shared
+// log; every other instance polls the log and delivers those events to its
+// locally connected clients.  Nothing here is used unless EnableCluster is
+// called, so a single-process deployment pays no cost.
+
+const (
+	clusterPollInterval = 250 * time.Millisecond
+	clusterHeartbeat    = 5 * time.Second
+	clusterStaleAfter   = 20 * time.Second
+	clusterEventTTL     = time.Minute
+)
+
+// Cluster event kinds.
+const (
+	clusterAll  = "all"  // deliver to every local client
+	clusterRoom = "room" // deliver to local clients in voice room Target
+	clusterUser = "user" // deliver to local clients of user Target
+	clusterSelf = "self" // for the instances themselves, not their clients
+)
+
+type cluster struct {
+	db         *db.DB
+	instanceID string
+}
+
+// EnableCluster turns on cross-instance voice state sharing.  It must be
+// called before Run.
+func (h *Hub) EnableCluster(database *db.DB, instanceID string) {
+	database.ClusterLeave(instanceID)
+	database.ClusterHeartbeat(instanceID)
+	h.cluster = &cluster{db: database, instanceID: instanceID}
+	// Events published from here on are delivered, even ones published
+	// before the poller gets going.
+	go h.runCluster(database.LatestClusterEventSeq())
+}
+
+// Shutdown withdraws this instance's voice membership from the cluster.
+func (h *Hub) Shutdown() {
+	if h.cluster != nil {
+		h.cluster.db.ClusterLeave(h.cluster.instanceID)
+	}
+}
+
+func (h *Hub) runCluster(seq int64) {
+	c := h.cluster
+	poll := time.NewTicker(clusterPollInterval)
+	beat := time.NewTicker(clusterHeartbeat)
+	defer poll.Stop()
+	defer beat.Stop()
+
+	for {
+		select {
+		case <-poll.C:
+			events, err := c.db.ClusterEventsSince(seq, c.instanceID)
+			if err != nil {
+				slog.Error("cluster poll", "err", err)
+				continue
+			}
+			for _, e := range events {
+				seq = e.Seq
+				h.deliverClusterEvent(e)
+			}
+
+		case <-beat.C:
+			if err := c.db.ClusterHeartbeat(c.instanceID); err != nil {
+				slog.Error("cluster heartbeat", "err", err)
+			}
+			gone, err := c.db.ClusterSweep(clusterStaleAfter, clusterEventTTL)
+			if err != nil {
+				continue
+			}
+			// An instance died without cleaning up — announce its voice
+			// members as departed so local clients tear down their peers.
+			for _, m := range gone {
+				evt := WSEvent{
+					Type: "voice.left",
+					Data: map[string]string{"channel_id": m.ChannelID, "user_id": m.UserID},
+				}
+				h.BroadcastToVoiceRoom(m.ChannelID, evt, nil)
+				h.Broadcast(evt)
+			}
+		}
+	}
+}
+
+func (h *Hub) deliverClusterEvent(e db.ClusterEvent) {
+	var evt WSEvent
+	if err := json.Unmarshal([]byte(e.Payload), &evt); err != nil {
+		return
+	}
+	switch evt.Type {
+	case "voice.moderation":
+		h.applyModerationEvent(evt)
+	case "stage.state":
+		h.applyStageEvent(evt)
+	case "jwt_keys.changed":
+		h.jwtKeysChanged()
+	}
+	switch e.Kind {
+	case clusterAll:
+		h.Broadcast(evt)
+	case clusterRoom:
+		h.BroadcastToVoiceRoom(e.Target, evt, nil)
+	case clusterUser:
+		h.SendToUser(e.Target, evt)
+	}
+}
+
+// publish forwards an event to the other instances.  No-op outside cluster mode.
+func (h *Hub) publish(kind, target string, event WSEvent) {
+	if h.cluster == nil {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	if err := h.cluster.db.PublishClusterEvent(h.cluster.instanceID, kind, target, string(data)); err != nil {
+		slog.Error("cluster publish", "err", err)
+	}
+}
+
+// relayToVoiceRoom delivers an event to a voice room on every instance.
+func (h *Hub) relayToVoiceRoom(channelID string, event WSEvent, exclude *Client) {
+	h.BroadcastToVoiceRoom(channelID, event, exclude)
+	h.publish(clusterRoom, channelID, event)
+}
+
+// relayToUser delivers an event to a user's clients on every instance.
+func (h *Hub) relayToUser(userID string, event WSEvent) {
+	h.SendToUser(userID, event)
+	h.publish(clusterUser, userID, event)
+}
+
+// relayToAll delivers an event to every client on every instance.
+func (h *Hub) relayToAll(event WSEvent) {
+	h.Broadcast(event)
+	h.publish(clusterAll, "", event)
+}
+
+func (h *Hub) recordVoiceJoin(channelID, userID string) {
+	if h.cluster != nil {
+		h.cluster.db.AddClusterVoiceMember(h.cluster.instanceID, channelID, userID)
+	}
+}
+
+// recordVoiceLeave drops userID's membership of channelID for this instance,
+// unless another of their local clients is still in the room.  The check and
+// the delete happen under voiceRoomsMu, so a tab joining at the same moment
+// can't have its row removed after it was recorded.
+func (h *Hub) recordVoiceLeave(channelID, userID string) {
+	if h.cluster == nil {
+		return
+	}
+	h.voiceRoomsMu.RLock()
+	defer h.voiceRoomsMu.RUnlock()
+	for c := range h.voiceRooms[channelID] {
+		if c.userID == userID {
+			return
+		}
+	}
+	h.cluster.db.RemoveClusterVoiceMember(h.cluster.instanceID, channelID, userID)
+}
+
+// remoteVoiceParticipants returns user IDs in channelID on other instances.
+func (h *Hub) remoteVoiceParticipants() map[string][]string {
+	out := make(map[string][]string)
+	if h.cluster == nil {
+		return out
+	}
+	members, err := h.cluster.db.RemoteVoiceMembers(h.cluster.instanceID, clusterStaleAfter)
+	if err != nil {
+		return out
+	}
+	for _, m := range members {
+		out[m.ChannelID] = append(out[m.ChannelID], m.UserID)
+	}
+	return out
+}
+
+func (h *Hub) isRemoteVoiceMember(channelID, userID string) bool {
+	if h.cluster == nil {
+		return false
+	}
+	return h.cluster.db.IsRemoteVoiceMember(h.cluster.instanceID, channelID, userID, clusterStaleAfter)
+}
+
+// recordConnect notes in the cluster that userID is connected here.  Only
+// called from Run, like recordDisconnect, so the two can't cross.
+func (h *Hub) recordConnect(userID string) {
+	if h.cluster != nil {
+		h.cluster.db.AddClusterClient(h.cluster.instanceID, userID)
+	}
+}
+
+// recordDisconnect notes that userID isn't connected here any more, unless
+// another of their local clients is.
+func (h *Hub) recordDisconnect(userID string) {
+	if h.cluster != nil && !h.userConnectedHere(userID) {
+		h.cluster.db.RemoveClusterClient(h.cluster.instanceID, userID)
+	}
+}
+
+// isRemoteClient reports whether userID is connected to another instance.
+func (h *Hub) isRemoteClient(userID string) bool {
+	if h.cluster == nil {
+		return false
+	}
+	return h.cluster.db.IsRemoteClient(h.cluster.instanceID, userID, clusterStaleAfter)
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	chimw "github.com/go-chi/chi/v5/middleware"
+
+	mw "chirm/internal/middleware"
+)
+
+// ─── Debug endpoints ─────────────────────────────────────────────────────────
+//
+// /debug/pprof and /debug/vars (expvar) let whoever runs the server take
+// goroutine dumps and CPU or heap profiles of a live process.  They give
+// away how the server works inside, so only admins get in, and with
+// localToo, anyone connecting from the machine itself.  A request that
+// carries forwarding headers came through a proxy, wherever it connected
+// from, so it doesn't count as local.
+
+// DebugAccess guards the debug endpoints.  Anyone else gets a 404, as if
+// they weren't there.
+func (h *Handler) DebugAccess(localToo bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if localToo {
+				if ip := mw.RemoteIP(r); ip != nil && ip.IsLoopback() && !forwarded(r) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			if !h.isAdminRequest(r) {
+				http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwarded reports whether r says it was passed on by a proxy.
+func forwarded(r *http.Request) bool {
+	return r.Header.Get("X-Forwarded-For") != "" || r.Header.Get("X-Real-IP") != "" || r.Header.Get("Forwarded") != ""
+}
+
+// DebugRoutes serves pprof and expvar, for mounting at /debug.  It's
+// chi's Profiler, except that the pprof index copes with CleanPath having
+// already stripped its trailing slash from the route.
+func DebugRoutes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(chimw.NoCache)
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/debug/pprof/", http.StatusMovedPermanently)
+	})
+	r.HandleFunc("/pprof", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			// The index links to profiles relative to itself.
+			http.Redirect(w, r, "/debug/pprof/", http.StatusMovedPermanently)
+			return
+		}
+		pprof.Index(w, r)
+	})
+	r.HandleFunc("/pprof/*", pprof.Index)
+	r.HandleFunc("/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/pprof/profile", pprof.Profile)
+	r.HandleFunc("/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/pprof/trace", pprof.Trace)
+	r.Handle("/vars", expvar.Handler())
+	return r
+}
+
+// PublishDebugVars adds Chirm's own figures to /debug/vars, next to the
+// memstats and cmdline expvar always has.
+func (h *Handler) PublishDebugVars() {
+	started := time.Now()
+	expvar.Publish("chirm", expvar.Func(func() any {
+		h.hub.mu.RLock()
+		clients := len(h.hub.clients)
+		h.hub.mu.RUnlock()
+		rooms, inVoice := 0, 0
+		for _, users := range h.hub.GetVoiceRoomSnapshot() {
+			rooms++
+			inVoice += len(users)
+		}
+		return map[string]any{
+			"uptime_seconds": int64(time.Since(started).Seconds()),
+			"goroutines":     runtime.NumGoroutine(),
+			"ws_clients":     clients,
+			"voice_rooms":    rooms,
+			"voice_users":    inVoice,
+			"db_pools":       h.db.Pools(),
+		}
+	}))
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"chirm/internal/db"
+)
+
+const (
+	// digestCheckInterval is how often the digest job looks for users whose
+	// digest is due.  A digest may go out up to this much early.
+	digestCheckInterval = 15 * time.Minute
+	// digestScanLimit caps how many unread messages per channel a digest
+	// counts and ranks.
+	digestScanLimit = 500
+	// digestTopMessages is how many messages each channel's section quotes.
+	digestTopMessages = 3
+)
+
+// digestChannel is one channel's section of a digest.
+type digestChannel struct {
+	Name     string
+	Unread   int
+	Mentions int
+	Top      []db.DigestMessage
+}
+
+// sendDueDigests emails every subscriber whose last digest is at least a
+// period old.  It's the digests job; see jobs.go.
+func (h *Handler) sendDueDigests(now time.Time) error {
+	subs, err := h.db.DigestSubscribers()
+	if err != nil {
+		return err
+	}
+	for _, s := range subs {
+		period := db.DigestPeriod(s.Freq)
+		due := now.Add(-period + digestCheckInterval)
+		if !s.SentAt.IsZero() && s.SentAt.After(due) {
+			continue
+		}
+		since := s.SentAt
+		if since.IsZero() {
+			since = now.Add(-period)
+		}
+		if !h.db.ClaimDigest(s.UserID, due, now) {
+			continue // another instance is sending it
+		}
+		if err := h.sendDigest(s.UserID, s.Freq, since); err != nil {
+			slog.Warn("digest", "user_id", s.UserID, "err", err)
+		}
+	}
+	return nil
+}
+
+// sendDigest emails userID a summary of unread activity since since.  Nothing
+// is sent if there's nothing to report.
+func (h *Handler) sendDigest(userID, freq string, since time.Time) error {
+	u, err := h.db.GetUserByID(userID)
+	if err != nil || u.Email == "" {
+		return err
+	}
+	sections := h.digestChannels(u, since)
+	if len(sections) == 0 {
+		return nil
+	}
+
+	unread, mentions := 0, 0
+	for _, c := range sections {
+		unread += c.Unread
+		mentions += c.Mentions
+	}
+	subject := fmt.Sprintf("Your %s Chirm digest: %d unread in %d channel%s",
+		freq, unread, len(sections), plural(len(sections)))
+	if mentions > 0 {
+		subject += fmt.Sprintf(", %d mention%s", mentions, plural(mentions))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi %s,\n\nHere's what you missed since %s:\n",
+		u.Username, since.UTC().Format("Mon 2 Jan 15:04 MST"))
+	for _, c := range sections {
+		fmt.Fprintf(&b, "\n#%s — %d unread", c.Name, c.Unread)
+		if c.Mentions > 0 {
+			fmt.Fprintf(&b, ", %d mention%s", c.Mentions, plural(c.Mentions))
+		}
+		b.WriteString("\n")
+		for _, m := range c.Top {
+			preview := strings.Join(strings.Fields(m.Content), " ")
+			if len(preview) > 140 {
+				preview = preview[:140] + "…"
+			}
+			fmt.Fprintf(&b, "  %s: %s\n", m.Author, preview)
+		}
+	}
+	b.WriteString("\n")
+	if h.email.baseURL != "" {
+		fmt.Fprintf(&b, "Catch up: %s/\n\n", h.email.baseURL)
+	}
+	b.WriteString("You can change or turn off digests under Notification settings.\n")
+	return h.email.mailer.Send(u.Email, subject, b.String())
+}
+
+// digestChannels builds the per-channel sections of u's digest.  Channels
+// they can't read or have set to "nothing" are left out; "mentions only"
+// channels show only their mentions.  Each section quotes the messages
+// that mention u first, then the most reacted to.
+func (h *Handler) digestChannels(u *db.User, since time.Time) []digestChannel {
+	counts, err := h.db.UnreadCounts(u.ID)
+	if err != nil {
+		return nil
+	}
+	prefs, err := h.db.GetNotificationSettings(u.ID)
+	if err != nil {
+		prefs = db.DefaultNotificationSettings()
+	}
+
+	var sections []digestChannel
+	for chID := range counts {
+		level := prefs.Level(chID)
+		if level == db.NotifyNone || h.db.ChannelPermissions(u, chID)&db.PermReadMessages == 0 {
+			continue
+		}
+		ch, err := h.db.GetChannelByID(chID)
+		if err != nil {
+			continue
+		}
+		msgs, _ := h.db.UnreadSince(u.ID, chID, since, digestScanLimit)
+		mentioned := map[string]bool{}
+		for _, m := range msgs {
+			mentions := db.ParseMentions(m.Content)
+			if mentions.Has(u.Username) || (mentions.Everyone && !prefs.SuppressEveryone) {
+				mentioned[m.ID] = true
+			}
+		}
+		if level == db.NotifyMentions {
+			kept := msgs[:0]
+			for _, m := range msgs {
+				if mentioned[m.ID] {
+					kept = append(kept, m)
+				}
+			}
+			msgs = kept
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+
+		c := digestChannel{Name: ch.Name, Unread: len(msgs), Mentions: len(mentioned)}
+		sort.SliceStable(msgs, func(i, j int) bool {
+			if mentioned[msgs[i].ID] != mentioned[msgs[j].ID] {
+				return mentioned[msgs[i].ID]
+			}
+			return msgs[i].Reactions > msgs[j].Reactions
+		})
+		if len(msgs) > digestTopMessages {
+			msgs = msgs[:digestTopMessages]
+		}
+		c.Top = msgs
+		sections = append(sections, c)
+	}
+	sort.Slice(sections, func(i, j int) bool {
+		if sections[i].Mentions != sections[j].Mentions {
+			return sections[i].Mentions > sections[j].Mentions
+		}
+		return sections[i].Unread > sections[j].Unread
+	})
+	return sections
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
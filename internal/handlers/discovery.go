@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"chirm/internal/discovery"
+)
+
+// DiscoveryConfig is how the server can be reached, for GET /api/discovery.
+type DiscoveryConfig struct {
+	HTTPPort   int
+	HTTPSPort  int    // 0 when HTTPS isn't running
+	SelfSigned bool   // the certificate is from the built-in CA
+	PublicURL  string // PUBLIC_URL, if set
+	MDNSHost   string // e.g. "myhost-chirm.local"; "" when not advertising
+}
+
+// SetDiscovery sets what GetDiscovery describes.
+func (h *Handler) SetDiscovery(cfg DiscoveryConfig) {
+	h.discovery = cfg
+}
+
+// Discovery is the descriptor returned by GET /api/discovery.  An app that
+// found the server on the LAN, or was given its address, reads it to learn
+// what it's called and which URL to open.
+type Discovery struct {
+	Service     string   `json:"service"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Icon        string   `json:"icon,omitempty"`
+	SetupDone   bool     `json:"setup_done"`
+	API         string   `json:"api"`
+	URLs        []string `json:"urls"`              // best first
+	CACert      string   `json:"ca_cert,omitempty"` // to install before the https URLs are trusted
+	HTTPPort    int      `json:"http_port"`
+	HTTPSPort   int      `json:"https_port,omitempty"`
+	MDNSHost    string   `json:"mdns_host,omitempty"`
+}
+
+// GetDiscovery describes the server to apps looking for it.  It needs no
+// sign-in, and says nothing the login page doesn't.
+func (h *Handler) GetDiscovery(w http.ResponseWriter, r *http.Request) {
+	cfg := h.discovery
+	d := Discovery{
+		Service:   discovery.ServiceType,
+		SetupDone: h.db.IsSetupDone(),
+		API:       "/api/v1",
+		URLs:      []string{},
+		HTTPPort:  cfg.HTTPPort,
+		HTTPSPort: cfg.HTTPSPort,
+		MDNSHost:  cfg.MDNSHost,
+	}
+	d.Name, _ = h.db.GetSetting("server_name")
+	d.Description, _ = h.db.GetSetting("server_description")
+	d.Icon, _ = h.db.GetSetting("server_icon")
+
+	if cfg.PublicURL != "" {
+		d.URLs = append(d.URLs, cfg.PublicURL)
+	}
+	// The built-in CA's certificate names the machine's addresses, not
+	// its mDNS host, so the URLs go by address.
+	httpPort := strconv.Itoa(cfg.HTTPPort)
+	ips := discovery.LANAddrs()
+	if cfg.HTTPSPort != 0 {
+		for _, ip := range ips {
+			d.URLs = append(d.URLs, "https://"+ip.String()+":"+strconv.Itoa(cfg.HTTPSPort))
+		}
+	}
+	for _, ip := range ips {
+		d.URLs = append(d.URLs, "http://"+ip.String()+":"+httpPort)
+	}
+	if cfg.SelfSigned && len(ips) > 0 {
+		d.CACert = "http://" + ips[0].String() + ":" + httpPort + "/ca-cert"
+	}
+	ok(w, d)
+}
@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ─── Document previews ───────────────────────────────────────────────────────
+//
+// Text and PDF uploads get a short snippet of their text stored on the
+// attachment, the first lines of a text file or of a PDF's first page, so
+// the chat can show a peek without the file being downloaded.  PDF text is
+// read from the page's content stream without laying it out, which is good
+// enough for most documents; when a PDF's fonts hide the characters (or the
+// file uses features we don't read) there's simply no preview.
+
+const (
+	previewLines = 10
+	previewChars = 600
+)
+
+// maxPreviewPDF bounds the PDFs we're willing to read for a preview.
+const maxPreviewPDF = 16 << 20
+
+// documentPreview returns a preview of a text or PDF upload, or "".
+func documentPreview(r io.ReadSeeker, size int64, mimeType string) string {
+	r.Seek(0, io.SeekStart)
+	defer r.Seek(0, io.SeekStart)
+	switch mimeType {
+	case "text/plain":
+		head := make([]byte, 4*previewChars)
+		n, _ := io.ReadFull(r, head)
+		if bytes.IndexByte(head[:n], 0) >= 0 {
+			return "" // binary, whatever the extension says
+		}
+		return previewText(string(head[:n]))
+	case "application/pdf":
+		if size > maxPreviewPDF {
+			return ""
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return ""
+		}
+		text := pdfFirstPageText(data)
+		if !mostlyReadable(text) {
+			return ""
+		}
+		return previewText(text)
+	}
+	return ""
+}
+
+// previewText trims text to previewLines non-blank lines and previewChars.
+func previewText(text string) string {
+	text = strings.ToValidUTF8(text, "�")
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if line == "" && (len(lines) == 0 || lines[len(lines)-1] == "") {
+			continue
+		}
+		lines = append(lines, line)
+		if len(lines) == previewLines {
+			break
+		}
+	}
+	out := strings.TrimSpace(strings.Join(lines, "\n"))
+	if utf8.RuneCountInString(out) > previewChars {
+		out = string([]rune(out)[:previewChars]) + "…"
+	}
+	return out
+}
+
+// mostlyReadable reports whether text looks like words rather than glyph
+// IDs from a font with its own encoding.
+func mostlyReadable(text string) bool {
+	total, good := 0, 0
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		total++
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsPunct(r) {
+			good++
+		}
+	}
+	return total >= 8 && good*10 >= total*9
+}
+
+// pdfObject is an object of a PDF: its dictionary (or other value) and,
+// for streams, the decoded stream data.
+type pdfObject struct {
+	dict   string
+	stream []byte
+}
+
+var (
+	pdfObjStart = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+	pdfRef      = regexp.MustCompile(`(\d+)\s+\d+\s+R`)
+)
+
+// pdfObjects reads the objects of a PDF, including those packed in object
+// streams.  Cross-reference tables are ignored; where an object appears
+// more than once (incremental updates), the last copy wins.
+func pdfObjects(data []byte) map[int]pdfObject {
+	objs := map[int]pdfObject{}
+	for _, m := range pdfObjStart.FindAllSubmatchIndex(data, -1) {
+		num, _ := strconv.Atoi(string(data[m[2]:m[3]]))
+		body := data[m[1]:]
+		if end := bytes.Index(body, []byte("endobj")); end >= 0 {
+			body = body[:end]
+		}
+		var o pdfObject
+		if i := bytes.Index(body, []byte("stream")); i >= 0 && bytes.Contains(body[:i], []byte("<<")) {
+			o.dict = string(body[:i])
+			raw := body[i+len("stream"):]
+			raw = bytes.TrimPrefix(bytes.TrimPrefix(raw, []byte("\r")), []byte("\n"))
+			if j := bytes.LastIndex(raw, []byte("endstream")); j >= 0 {
+				raw = raw[:j]
+			}
+			o.stream = pdfDecode(o.dict, raw)
+		} else {
+			o.dict = string(body)
+		}
+		objs[num] = o
+	}
+
+	// Object streams: a header of "number offset" pairs, then the objects.
+	for _, o := range objs {
+		if !strings.Contains(o.dict, "/ObjStm") || o.stream == nil {
+			continue
+		}
+		n, first := pdfInt(o.dict, "/N"), pdfInt(o.dict, "/First")
+		if first <= 0 || first > len(o.stream) {
+			continue
+		}
+		header := strings.Fields(string(o.stream[:first]))
+		for i := 0; i+1 < len(header) && i/2 < n; i += 2 {
+			num, err1 := strconv.Atoi(header[i])
+			start, err2 := strconv.Atoi(header[i+1])
+			if err1 != nil || err2 != nil || first+start > len(o.stream) {
+				break
+			}
+			end := len(o.stream)
+			if i+3 < len(header) {
+				if next, err := strconv.Atoi(header[i+3]); err == nil && first+next <= end && next >= start {
+					end = first + next
+				}
+			}
+			if _, ok := objs[num]; !ok {
+				objs[num] = pdfObject{dict: string(o.stream[first+start : end])}
+			}
+		}
+	}
+	return objs
+}
+
+// pdfDecode undoes a stream's filter.  Only Flate is supported; streams
+// with other filters are left out.
+func pdfDecode(dict string, raw []byte) []byte {
+	if !strings.Contains(dict, "/Filter") {
+		return raw
+	}
+	if !strings.Contains(dict, "/FlateDecode") || strings.Contains(dict, "/DecodeParms") && strings.Contains(dict, "/Predictor") {
+		return nil
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil
+	}
+	// A truncated stream still gives us its start.
+	out, _ := io.ReadAll(io.LimitReader(zr, maxPreviewPDF))
+	return out
+}
+
+// pdfInt reads an integer entry from a dictionary.
+func pdfInt(dict, key string) int {
+	i := strings.Index(dict, key)
+	if i < 0 {
+		return 0
+	}
+	f := strings.Fields(dict[i+len(key):])
+	if len(f) == 0 {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimRight(f[0], "/>"))
+	return n
+}
+
+// pdfRefsAfter returns the object numbers referenced by a dictionary entry,
+// either one reference or an array of them.
+func pdfRefsAfter(dict, key string) []int {
+	i := strings.Index(dict, key)
+	if i < 0 {
+		return nil
+	}
+	rest := strings.TrimSpace(dict[i+len(key):])
+	if strings.HasPrefix(rest, "[") {
+		if end := strings.Index(rest, "]"); end >= 0 {
+			rest = rest[:end]
+		}
+	} else if m := pdfRef.FindStringIndex(rest); m != nil && m[0] == 0 {
+		rest = rest[:m[1]]
+	} else {
+		return nil
+	}
+	var refs []int
+	for _, m := range pdfRef.FindAllStringSubmatch(rest, -1) {
+		n, _ := strconv.Atoi(m[1])
+		refs = append(refs, n)
+	}
+	return refs
+}
+
+var (
+	pdfTypeCatalog = regexp.MustCompile(`/Type\s*/Catalog\b`)
+	pdfTypePage    = regexp.MustCompile(`/Type\s*/Page\b`)
+)
+
+// pdfFirstPageText returns the text drawn on a PDF's first page.
+func pdfFirstPageText(data []byte) string {
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return ""
+	}
+	objs := pdfObjects(data)
+	var root int
+	for _, o := range objs {
+		if pdfTypeCatalog.MatchString(o.dict) {
+			if refs := pdfRefsAfter(o.dict, "/Pages"); len(refs) > 0 {
+				root = refs[0]
+			}
+			break
+		}
+	}
+
+	// Follow the first kid down the page tree.
+	page, ok := objs[root]
+	for depth := 0; ok && depth < 32 && !pdfTypePage.MatchString(page.dict); depth++ {
+		kids := pdfRefsAfter(page.dict, "/Kids")
+		if len(kids) == 0 {
+			ok = false
+			break
+		}
+		page, ok = objs[kids[0]]
+	}
+	if !ok {
+		return ""
+	}
+
+	var text strings.Builder
+	for _, ref := range pdfRefsAfter(page.dict, "/Contents") {
+		pdfContentText(objs[ref].stream, &text)
+		if text.Len() > 4*previewChars {
+			break
+		}
+	}
+	return text.String()
+}
+
+// pdfContentText appends the text shown by a content stream's text
+// operators, starting a line for each move to a new line.
+func pdfContentText(content []byte, out *strings.Builder) {
+	var operands []string // strings shown, for the next operator
+	var nums []float64
+	newline := func() {
+		if s := out.String(); s != "" && !strings.HasSuffix(s, "\n") {
+			out.WriteByte('\n')
+		}
+	}
+	for i := 0; i < len(content) && out.Len() <= 4*previewChars; {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, n := pdfLiteral(content[i:])
+			operands = append(operands, s)
+			i += n
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return
+			}
+			operands = append(operands, pdfHex(content[i+1:i+end]))
+			i += end + 1
+		case c == '[':
+			operands, nums = operands[:0], nums[:0]
+			i++
+		case c == ']':
+			i++
+		case c == '/': // a name, e.g. a font's
+			i++
+			for i < len(content) && !bytes.ContainsRune([]byte(" \t\r\n/[]()<>%"), rune(content[i])) {
+				i++
+			}
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '-' || c == '.' || c >= '0' && c <= '9':
+			j := i + 1
+			for j < len(content) && (content[j] == '.' || content[j] >= '0' && content[j] <= '9') {
+				j++
+			}
+			f, _ := strconv.ParseFloat(string(content[i:j]), 64)
+			// In a TJ array a large negative gap is a space.
+			if f < -200 && len(operands) > 0 {
+				operands[len(operands)-1] += " "
+			}
+			nums = append(nums, f)
+			i = j
+		case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c == '\'' || c == '"' || c == '*':
+			j := i + 1
+			for j < len(content) && (content[j] >= 'A' && content[j] <= 'Z' || content[j] >= 'a' && content[j] <= 'z' || content[j] == '*') {
+				j++
+			}
+			switch op := string(content[i:j]); op {
+			case "Tj", "TJ":
+				out.WriteString(strings.Join(operands, ""))
+			case "'", "\"":
+				newline()
+				out.WriteString(strings.Join(operands, ""))
+			case "T*", "ET":
+				newline()
+			case "Td", "TD":
+				if len(nums) >= 2 && nums[len(nums)-1] != 0 {
+					newline()
+				} else if len(nums) >= 2 && nums[len(nums)-2] > 0 && out.Len() > 0 {
+					out.WriteByte(' ')
+				}
+			case "Tm":
+				newline()
+			}
+			operands, nums = operands[:0], nums[:0]
+			i = j
+		default:
+			i++
+		}
+	}
+}
+
+// pdfLiteral reads a (string) with its escapes and nested parentheses,
+// returning it and how many bytes it took.
+func pdfLiteral(b []byte) (string, int) {
+	var s strings.Builder
+	depth := 0
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; c {
+		case '(':
+			if depth > 0 {
+				s.WriteByte(c)
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return latin1(s.String()), i + 1
+			}
+			s.WriteByte(c)
+		case '\\':
+			i++
+			if i >= len(b) {
+				break
+			}
+			switch e := b[i]; e {
+			case 'n':
+				s.WriteByte('\n')
+			case 'r', 't', 'b', 'f':
+				s.WriteByte(' ')
+			case '\r', '\n': // line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					v, j := 0, i
+					for ; j < len(b) && j < i+3 && b[j] >= '0' && b[j] <= '7'; j++ {
+						v = v*8 + int(b[j]-'0')
+					}
+					s.WriteByte(byte(v))
+					i = j - 1
+				} else {
+					s.WriteByte(e)
+				}
+			}
+		default:
+			s.WriteByte(c)
+		}
+	}
+	return latin1(s.String()), len(b)
+}
+
+// pdfHex decodes a <hex> string.
+func pdfHex(b []byte) string {
+	var digits []byte
+	for _, c := range b {
+		if c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F' {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		v, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		out[i] = byte(v)
+	}
+	return latin1(string(out))
+}
+
+// latin1 reads bytes as Latin-1, close to the encodings simple PDF fonts
+// use.
+func latin1(s string) string {
+	r := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		r[i] = rune(s[i])
+	}
+	return string(r)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"chirm/internal/db"
+	"chirm/internal/mail"
+)
+
+// emailNotifier batches @mentions for users who were offline and had no
+// working push subscription, and emails them once the delay has passed.
+// Timers are per instance; in cluster mode each instance mails about the
+// messages it handled.
+type emailNotifier struct {
+	mailer  *mail.Mailer
+	delay   time.Duration
+	baseURL string
+
+	mu      sync.Mutex
+	pending map[string][]missedMention // userID → mentions waiting to send
+}
+
+type missedMention struct {
+	MessageID string
+	Line      string // "alice in #general: hey @bob"
+}
+
+// SetMailer turns on email notifications for missed mentions, sent delay
+// after the first one.  baseURL, when set, is linked from the email.
+func (h *Handler) SetMailer(m *mail.Mailer, delay time.Duration, baseURL string) {
+	h.email = &emailNotifier{
+		mailer:  m,
+		delay:   delay,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		pending: map[string][]missedMention{},
+	}
+}
+
+// emailMissedMentions queues an email for each user the message directly
+// @mentions who didn't get a push and isn't connected to any instance.
+// @everyone never emails, and neither does a mention in quiet hours that
+// held the push back.
+// mentioned are the users it mentions, and readers those who can read the
+// channel, keyed by ID.
+func (h *Handler) emailMissedMentions(channelID string, mentioned []string, readers map[string]*db.User,
+	mentions db.Mentions, prefs map[string]*db.NotificationSettings, pushed map[string]bool, payload PushPayload) {
+	if h.email == nil {
+		return
+	}
+	for _, id := range mentioned {
+		u := readers[id] // nil for the author, and for anyone who can't read the channel
+		if u == nil || u.Email == "" || pushed[u.ID] || h.hub.userConnected(u.ID) {
+			continue
+		}
+		s := prefs[u.ID]
+		if s == nil {
+			s = db.DefaultNotificationSettings()
+		}
+		if !s.EmailMentions || !s.Wants(channelID, true, mentions.Everyone) || s.QuietFor(time.Now(), true) {
+			continue
+		}
+		text := localizePush(payload, s.Language)
+		h.email.queue(h.db, u.ID, missedMention{
+			MessageID: payload.MessageID,
+			Line:      text.Title + ": " + text.Body,
+		})
+	}
+}
+
+// queue adds a mention to userID's batch, starting the batch timer if this
+// is the first one.
+func (e *emailNotifier) queue(database *db.DB, userID string, m missedMention) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, waiting := e.pending[userID]; !waiting {
+		time.AfterFunc(e.delay, func() { e.flush(database, userID) })
+	}
+	e.pending[userID] = append(e.pending[userID], m)
+}
+
+// flush sends userID's batch, leaving out anything they've read since.
+func (e *emailNotifier) flush(database *db.DB, userID string) {
+	e.mu.Lock()
+	batch := e.pending[userID]
+	delete(e.pending, userID)
+	e.mu.Unlock()
+
+	var lines []string
+	for _, m := range batch {
+		if !database.IsMessageRead(userID, m.MessageID) {
+			lines = append(lines, "  "+m.Line)
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+	u, err := database.GetUserByID(userID)
+	if err != nil || u.Email == "" {
+		return
+	}
+
+	subject := "You were mentioned on Chirm"
+	if len(lines) > 1 {
+		subject = fmt.Sprintf("You were mentioned %d times on Chirm", len(lines))
+	}
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\n\nYou were mentioned while you were away:\n\n", u.Username)
+	body.WriteString(strings.Join(lines, "\n"))
+	body.WriteString("\n\n")
+	if e.baseURL != "" {
+		fmt.Fprintf(&body, "Catch up: %s/\n\n", e.baseURL)
+	}
+	body.WriteString("You can turn these emails off under Notification settings.\n")
+
+	if err := e.mailer.Send(u.Email, subject, body.String()); err != nil {
+		slog.Warn("mention email", "user_id", userID, "err", err)
+	}
+}
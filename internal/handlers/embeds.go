@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"regexp"
+
+	"chirm/internal/db"
+)
+
+// ─── Message embeds ──────────────────────────────────────────────────────────
+//
+// Link previews are fetched once, by the server, when a message is sent or
+// edited, and stored on the message; clients get them with the message or
+// in a message.embed_update event when they're ready.  Only messages from
+// before this leave clients to call /api/link-preview themselves.
+
+// maxEmbedURLs is how many of a message's links are tried for a preview,
+// in order; the first that gives one is used.
+const maxEmbedURLs = 2
+
+var (
+	// Links as the client's renderer finds them, outside code.
+	reMessageURL  = regexp.MustCompile(`https?://[^\s<>"')\]]+|www\.[a-zA-Z0-9-]+\.[a-zA-Z]{2,}[^\s<>"')\]]*`)
+	reMessageCode = regexp.MustCompile("(?s)```.*?```|`[^`\n]+`")
+	// <https://...> is a link the sender doesn't want previewed.
+	reSuppressedURL = regexp.MustCompile(`<https?://[^\s<>]+>`)
+	// Media and documents get no preview.
+	reNoPreview = regexp.MustCompile(`(?i)\.(png|jpe?g|gif|webp|svg|mp4|webm|ogg|mp3|wav|pdf|zip|tar|gz)(\?.*)?$`)
+)
+
+// messageURLs returns the links in content worth previewing.
+func messageURLs(content string) []string {
+	text := reMessageCode.ReplaceAllString(content, "")
+	text = reSuppressedURL.ReplaceAllString(text, "")
+	var urls []string
+	for _, u := range reMessageURL.FindAllString(text, -1) {
+		if len(u) < 4 || u[:4] != "http" {
+			u = "https://" + u
+		}
+		if reNoPreview.MatchString(u) || containsString(urls, u) {
+			continue
+		}
+		urls = append(urls, u)
+		if len(urls) == maxEmbedURLs {
+			break
+		}
+	}
+	return urls
+}
+
+// queueEmbeds starts fetching the link previews for a message just sent or
+// edited, marking msg as waiting for them.  A message edited to have no
+// links, or with previews suppressed, loses its previews.
+func (h *Handler) queueEmbeds(msg *db.Message) {
+	found := messageURLs(msg.Content)
+	var urls []string
+	if !msg.SuppressEmbeds {
+		policy := h.previewPolicy()
+		for _, u := range found {
+			if parsed, err := url.Parse(u); err == nil && policy.allows(parsed.Hostname()) {
+				urls = append(urls, u)
+			}
+		}
+	}
+	if len(urls) == 0 {
+		// Links that are suppressed or that the policy rules out still
+		// get an empty list, so clients don't ask for previews themselves.
+		if reMessageURL.MatchString(msg.Content) || msg.Embeds != nil || msg.EmbedsPending {
+			none := json.RawMessage("[]")
+			h.db.SetMessageEmbeds(msg.ID, msg.Content, none)
+			msg.Embeds, msg.EmbedsPending = none, false
+		}
+		return
+	}
+	if err := h.db.MarkEmbedsPending(msg.ID); err != nil {
+		return
+	}
+	msg.Embeds, msg.EmbedsPending = nil, true
+	go h.resolveEmbeds(msg.ID, msg.ChannelID, msg.Content, urls)
+}
+
+// resolveEmbeds fetches previews for urls, stores the first useful one and
+// tells the channel.
+func (h *Handler) resolveEmbeds(id, channelID, content string, urls []string) {
+	embeds := []LinkPreview{}
+	for _, u := range urls {
+		pv := h.fetchPreview(u)
+		if pv.Title == "" && pv.Description == "" && pv.Embed == nil {
+			continue
+		}
+		// Readers load preview images through the proxy, not from the site.
+		pv.Image = proxiedImageURL(pv.Image)
+		pv.Favicon = proxiedImageURL(pv.Favicon)
+		pv.Error = ""
+		embeds = append(embeds, pv)
+		break
+	}
+	data, _ := json.Marshal(embeds)
+	stored, err := h.db.SetMessageEmbeds(id, content, data)
+	if err != nil {
+		slog.Error("storing embeds", "message_id", id, "err", err)
+		return
+	}
+	if !stored {
+		return // deleted, or edited and being fetched again
+	}
+	h.hub.BroadcastToChannel(channelID, WSEvent{Type: "message.embed_update", Data: map[string]interface{}{
+		"id":         id,
+		"channel_id": channelID,
+		"embeds":     embeds,
+	}})
+}
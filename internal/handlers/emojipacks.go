@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"chirm/internal/db"
+	mw "chirm/internal/middleware"
+)
+
+// ─── Emoji packs ─────────────────────────────────────────────────────────────
+//
+// A pack is a ZIP of emoji images, so a server's emoji can be moved or
+// shared in one go.  Chirm's own packs have a manifest.json naming each
+// image; Pleroma and Akkoma packs, with a pack.json, and plain ZIPs of
+// images, named for their shortcodes, import too.  Each image goes through
+// the same checks and resizing as an upload.
+
+const (
+	emojiPackMaxBytes   = 50 << 20
+	emojiPackMaxEmojis  = 500
+	emojiPackMaxNameLen = 32
+	emojiPackMaxImage   = 2 << 20 // as for an upload
+)
+
+// EmojiPackManifest is manifest.json in an exported pack.
+type EmojiPackManifest struct {
+	Name       string           `json:"name"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Emojis     []EmojiPackEntry `json:"emojis"`
+}
+
+// EmojiPackEntry is one emoji in a pack.
+type EmojiPackEntry struct {
+	Name     string `json:"name"`
+	File     string `json:"file"` // path in the ZIP
+	Animated bool   `json:"animated,omitempty"`
+}
+
+// EmojiImportSkip is an emoji in a pack that wasn't imported, and why.
+type EmojiImportSkip struct {
+	Name   string `json:"name"`
+	File   string `json:"file,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// EmojiImportResult is the answer to POST /api/emojis/import.
+type EmojiImportResult struct {
+	Added    []db.CustomEmoji  `json:"added"`
+	Replaced []string          `json:"replaced"` // names whose old emoji was replaced
+	Skipped  []EmojiImportSkip `json:"skipped"`
+}
+
+// ExportCustomEmojis handles GET /api/emojis/export: every custom emoji, as
+// a pack (admin only).
+func (h *Handler) ExportCustomEmojis(w http.ResponseWriter, r *http.Request) {
+	if _, isOk := h.requireAdmin(w, r); !isOk {
+		return
+	}
+	emojis, err := h.db.ListCustomEmojis()
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list emojis")
+		return
+	}
+
+	name, _ := h.db.GetSetting("server_name")
+	manifest := EmojiPackManifest{Name: name, ExportedAt: time.Now().UTC(), Emojis: []EmojiPackEntry{}}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="emojis.zip"`)
+	zw := zip.NewWriter(w)
+	for _, e := range emojis {
+		file := e.Name + path.Ext(e.Filename)
+		if err := h.addEmojiToPack(zw, file, e.Filename); err != nil {
+			// The response has started, so the ZIP is cut short instead.
+			slog.Warn("emoji export failed", "emoji", e.Name, "err", err)
+			return
+		}
+		manifest.Emojis = append(manifest.Emojis, EmojiPackEntry{Name: e.Name, File: file, Animated: e.Animated})
+	}
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: manifest.ExportedAt})
+	if err != nil {
+		return
+	}
+	enc := json.NewEncoder(fw)
+	enc.SetIndent("", "  ")
+	enc.Encode(manifest)
+	zw.Close()
+}
+
+// addEmojiToPack copies stored file into zw as name.
+func (h *Handler) addEmojiToPack(zw *zip.Writer, name, stored string) error {
+	f, err := h.files.Open(stored)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Images are compressed already.
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, f)
+	return err
+}
+
+// ImportCustomEmojis handles POST /api/emojis/import: a pack, uploaded as
+// "pack", added in one go (admin only).  "conflict" says what to do with an
+// emoji whose name is taken: "skip" it (the default), "rename" it with a
+// number on the end, or "replace" the one there.
+func (h *Handler) ImportCustomEmojis(w http.ResponseWriter, r *http.Request) {
+	u, isOk := h.requireAdmin(w, r)
+	if !isOk {
+		return
+	}
+
+	mw.SetBodyLimit(r, emojiPackMaxBytes+1<<20)
+	if err := r.ParseMultipartForm(8 << 20); err != nil {
+		errResp(w, http.StatusBadRequest, "request too large")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	conflict := r.FormValue("conflict")
+	switch conflict {
+	case "":
+		conflict = "skip"
+	case "skip", "rename", "replace":
+	default:
+		errResp(w, http.StatusBadRequest, "conflict must be skip, rename or replace")
+		return
+	}
+	file, header, err := r.FormFile("pack")
+	if err != nil {
+		errResp(w, http.StatusBadRequest, "pack required")
+		return
+	}
+	defer file.Close()
+	if header.Size > emojiPackMaxBytes {
+		errResp(w, http.StatusBadRequest, "pack must be under 50MB")
+		return
+	}
+	zr, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		errResp(w, http.StatusBadRequest, "pack must be a ZIP file")
+		return
+	}
+	entries, err := emojiPackEntries(zr)
+	if err != nil {
+		errResp(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(entries) == 0 {
+		errResp(w, http.StatusBadRequest, "no emoji found in the pack")
+		return
+	}
+	if len(entries) > emojiPackMaxEmojis {
+		errResp(w, http.StatusBadRequest, fmt.Sprintf("a pack can have at most %d emoji", emojiPackMaxEmojis))
+		return
+	}
+
+	existing, err := h.db.ListCustomEmojis()
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list emojis")
+		return
+	}
+	// Names taken, by the emoji already here; those the pack adds map to nil.
+	taken := map[string]*db.CustomEmoji{}
+	for i := range existing {
+		taken[existing[i].Name] = &existing[i]
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	result := EmojiImportResult{Added: []db.CustomEmoji{}, Replaced: []string{}, Skipped: []EmojiImportSkip{}}
+	skip := func(e EmojiPackEntry, reason string) {
+		result.Skipped = append(result.Skipped, EmojiImportSkip{Name: e.Name, File: e.File, Reason: reason})
+	}
+	for _, e := range entries {
+		e.Name = emojiNameFrom(e.Name)
+		if e.Name == "" {
+			skip(e, "no usable name")
+			continue
+		}
+		var replace *db.CustomEmoji
+		if old, found := taken[e.Name]; found {
+			switch {
+			case conflict == "rename":
+				e.Name = freeEmojiName(e.Name, taken)
+			case conflict == "replace" && old != nil:
+				replace = old
+			default:
+				skip(e, "name already taken")
+				continue
+			}
+		}
+		f := files[e.File]
+		if f == nil {
+			skip(e, "file not in the pack")
+			continue
+		}
+		raw, err := readPackFile(f, emojiPackMaxImage)
+		if err != nil {
+			skip(e, err.Error())
+			continue
+		}
+		img, err := prepareCustomImage(raw, emojiSize, 2*emojiSize)
+		if err != nil {
+			skip(e, "invalid emoji image: "+err.Error())
+			continue
+		}
+		ce := db.CustomEmoji{Name: e.Name, UploaderID: u.ID, Animated: img.animated}
+		if ce.Filename, ce.StaticFilename, err = h.storeCustomImage("emoji", img); err != nil {
+			skip(e, "failed to save file")
+			continue
+		}
+		if replace != nil {
+			h.removeCustomEmoji(replace.ID)
+		}
+		emoji, err := h.db.CreateCustomEmoji(ce)
+		if err != nil {
+			h.files.Delete(ce.Filename)
+			if ce.StaticFilename != "" {
+				h.files.Delete(ce.StaticFilename)
+			}
+			skip(e, "failed to create emoji")
+			continue
+		}
+		taken[emoji.Name] = nil
+		if replace != nil {
+			result.Replaced = append(result.Replaced, emoji.Name)
+		}
+		result.Added = append(result.Added, *emoji)
+		h.hub.Broadcast(WSEvent{Type: "emoji.new", Data: emoji})
+	}
+
+	h.db.AddAuditEntry(db.AuditEntry{
+		ActorID: u.ID,
+		Action:  "emoji.import",
+		Details: fmt.Sprintf("imported %d emoji from %s (%d replaced, %d skipped)", len(result.Added), header.Filename, len(result.Replaced), len(result.Skipped)),
+	})
+	ok(w, result)
+}
+
+// removeCustomEmoji deletes emoji id and its files, telling everyone.
+func (h *Handler) removeCustomEmoji(id string) {
+	emoji, err := h.db.DeleteCustomEmoji(id)
+	if err != nil {
+		return
+	}
+	h.files.Delete(emoji.Filename)
+	if emoji.StaticFilename != "" {
+		h.files.Delete(emoji.StaticFilename)
+	}
+	h.hub.Broadcast(WSEvent{Type: "emoji.delete", Data: map[string]string{"id": id}})
+}
+
+// emojiPackEntries lists the emoji in a pack: from its manifest.json, its
+// pack.json, or else every image in it, named for its file.
+func emojiPackEntries(zr *zip.Reader) ([]EmojiPackEntry, error) {
+	var manifest, pleroma *zip.File
+	for _, f := range zr.File {
+		switch path.Base(f.Name) {
+		case "manifest.json":
+			if manifest == nil || len(f.Name) < len(manifest.Name) {
+				manifest = f
+			}
+		case "pack.json":
+			if pleroma == nil || len(f.Name) < len(pleroma.Name) {
+				pleroma = f
+			}
+		}
+	}
+
+	switch {
+	case manifest != nil:
+		raw, err := readPackFile(manifest, 1<<20)
+		if err != nil {
+			return nil, err
+		}
+		var m EmojiPackManifest
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return nil, fmt.Errorf("invalid manifest.json")
+		}
+		dir := path.Dir(manifest.Name)
+		for i := range m.Emojis {
+			m.Emojis[i].File = path.Join(dir, m.Emojis[i].File)
+		}
+		return m.Emojis, nil
+
+	case pleroma != nil:
+		raw, err := readPackFile(pleroma, 1<<20)
+		if err != nil {
+			return nil, err
+		}
+		var p struct {
+			Files map[string]string `json:"files"`
+		}
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return nil, fmt.Errorf("invalid pack.json")
+		}
+		dir := path.Dir(pleroma.Name)
+		entries := []EmojiPackEntry{}
+		for name, file := range p.Files {
+			entries = append(entries, EmojiPackEntry{Name: name, File: path.Join(dir, file)})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+		return entries, nil
+	}
+
+	entries := []EmojiPackEntry{}
+	for _, f := range zr.File {
+		base := path.Base(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(base, ".") || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		switch strings.ToLower(path.Ext(base)) {
+		case ".png", ".gif", ".webp", ".jpg", ".jpeg":
+			entries = append(entries, EmojiPackEntry{Name: strings.TrimSuffix(base, path.Ext(base)), File: f.Name})
+		}
+	}
+	return entries, nil
+}
+
+// readPackFile reads f from a pack, refusing it if it's over max bytes.
+func readPackFile(f *zip.File, max int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(max) {
+		return nil, fmt.Errorf("file too large")
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read file")
+	}
+	defer rc.Close()
+	// The size in the header may lie.
+	raw, err := io.ReadAll(io.LimitReader(rc, max+1))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read file")
+	}
+	if int64(len(raw)) > max {
+		return nil, fmt.Errorf("file too large")
+	}
+	return raw, nil
+}
+
+// emojiNameFrom makes s a shortcode, as the app does for an upload's file
+// name: lower case, with underscores for anything but letters, numbers and
+// underscores.
+func emojiNameFrom(s string) string {
+	s = strings.Trim(strings.TrimSpace(s), ":")
+	var b strings.Builder
+	for _, c := range strings.ToLower(s) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	name := b.String()
+	if len(name) > emojiPackMaxNameLen {
+		name = name[:emojiPackMaxNameLen]
+	}
+	if strings.Trim(name, "_") == "" {
+		return ""
+	}
+	return name
+}
+
+// freeEmojiName is name with the first number on the end, from 2, that
+// makes it one not in taken.
+func freeEmojiName(name string, taken map[string]*db.CustomEmoji) string {
+	for n := 2; ; n++ {
+		suffix := fmt.Sprintf("_%d", n)
+		base := name
+		if len(base)+len(suffix) > emojiPackMaxNameLen {
+			base = base[:emojiPackMaxNameLen-len(suffix)]
+		}
+		if _, found := taken[base+suffix]; !found {
+			return base + suffix
+		}
+	}
+}
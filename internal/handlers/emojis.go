@@ -1,18 +1,20 @@
 package handlers
 
 import (
-	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
 
 	"chirm/internal/db"
+	mw "chirm/internal/middleware"
 )
 
+// emojiSize is the longest edge custom emoji are stored at, in pixels —
+// enough for the 22px inline size on high-density screens.
+const emojiSize = 64
+
 // ListCustomEmojis returns all custom emojis (any authenticated user).
 func (h *Handler) ListCustomEmojis(w http.ResponseWriter, r *http.Request) {
 	emojis, err := h.db.ListCustomEmojis()
@@ -30,6 +32,7 @@ func (h *Handler) UploadCustomEmoji(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	mw.SetBodyLimit(r, 4<<20)
 	if err := r.ParseMultipartForm(4 << 20); err != nil {
 		errResp(w, http.StatusBadRequest, "request too large")
 		return
@@ -56,42 +59,40 @@ func (h *Handler) UploadCustomEmoji(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	mime := header.Header.Get("Content-Type")
-	if !strings.HasPrefix(mime, "image/") {
+	if !strings.HasPrefix(header.Header.Get("Content-Type"), "image/") {
 		errResp(w, http.StatusBadRequest, "file must be an image")
 		return
 	}
-	if header.Size > 256*1024 {
-		errResp(w, http.StatusBadRequest, "emoji image must be under 256KB")
+	// Stored resized, so this only bounds the upload itself
+	if header.Size > 2*1024*1024 {
+		errResp(w, http.StatusBadRequest, "emoji image must be under 2MB")
 		return
 	}
 
-	ext := filepath.Ext(header.Filename)
-	if ext == "" {
-		ext = ".png"
-	}
-	filename := fmt.Sprintf("emoji_%s%s", db.NewID(), ext)
-
-	uploadsDir := filepath.Join(h.dataDir, "uploads")
-	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
-		errResp(w, http.StatusInternalServerError, "storage error")
+	raw, err := io.ReadAll(file)
+	if err != nil {
+		errResp(w, http.StatusBadRequest, "failed to read file")
 		return
 	}
-
-	dst, err := os.Create(filepath.Join(uploadsDir, filename))
+	// Animated emoji can't be resized, so they may be up to twice the size
+	// still ones are stored at.
+	img, err := prepareCustomImage(raw, emojiSize, 2*emojiSize)
 	if err != nil {
-		errResp(w, http.StatusInternalServerError, "failed to save file")
+		errResp(w, http.StatusBadRequest, "invalid emoji image: "+err.Error())
 		return
 	}
-	defer dst.Close()
-	if _, err := io.Copy(dst, file); err != nil {
-		errResp(w, http.StatusInternalServerError, "failed to write file")
+	e := db.CustomEmoji{Name: name, UploaderID: u.ID, Animated: img.animated}
+	if e.Filename, e.StaticFilename, err = h.storeCustomImage("emoji", img); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to save file")
 		return
 	}
 
-	emoji, err := h.db.CreateCustomEmoji(name, filename, u.ID)
+	emoji, err := h.db.CreateCustomEmoji(e)
 	if err != nil {
-		os.Remove(filepath.Join(uploadsDir, filename))
+		h.files.Delete(e.Filename)
+		if e.StaticFilename != "" {
+			h.files.Delete(e.StaticFilename)
+		}
 		if strings.Contains(err.Error(), "UNIQUE") {
 			errResp(w, http.StatusConflict, "an emoji with that name already exists")
 			return
@@ -112,14 +113,16 @@ func (h *Handler) DeleteCustomEmoji(w http.ResponseWriter, r *http.Request) {
 	}
 
 	id := chi.URLParam(r, "id")
-	filename, err := h.db.DeleteCustomEmoji(id)
+	emoji, err := h.db.DeleteCustomEmoji(id)
 	if err != nil {
 		errResp(w, http.StatusNotFound, "emoji not found")
 		return
 	}
 
-	uploadsDir := filepath.Join(h.dataDir, "uploads")
-	os.Remove(filepath.Join(uploadsDir, filename))
+	h.files.Delete(emoji.Filename)
+	if emoji.StaticFilename != "" {
+		h.files.Delete(emoji.StaticFilename)
+	}
 
 	h.hub.Broadcast(WSEvent{Type: "emoji.delete", Data: map[string]string{"id": id}})
 	ok(w, map[string]string{"message": "deleted"})
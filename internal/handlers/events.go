@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+)
+
+// ─── Server events ───────────────────────────────────────────────────────────
+//
+// Members with Manage Events schedule events for their guild (see
+// db/events.go), one-off or repeating, and everyone in the guild sees them
+// and says whether they're coming.  Changes go out as event.* events to the
+// guild.  Every minute the event-reminders job pushes a reminder, and an
+// event.reminder event, to those going or interested, reminder_minutes
+// before each occurrence.
+
+const (
+	eventMaxTitle         = 100
+	eventMaxDescription   = 2000
+	eventMaxMinutes       = 7 * 24 * 60 // for durations and reminders
+	eventReminderDefault  = 15
+	eventReminderInterval = time.Minute
+)
+
+// EventRequest is the body of POST /api/events and PUT /api/events/{id}.
+type EventRequest struct {
+	GuildID     string    `json:"guild_id"` // on creation; the default guild if left out
+	ChannelID   string    `json:"channel_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	StartsAt    time.Time `json:"starts_at"`
+	Duration    int       `json:"duration"`   // minutes, 0 for no set end
+	Recurrence  string    `json:"recurrence"` // "", daily, weekly, biweekly or monthly
+	Timezone    string    `json:"timezone"`   // e.g. Europe/Berlin; UTC if left out
+	// Minutes before each occurrence to remind those coming, 0 for never;
+	// 15 if left out on creation, and unchanged if left out on an update.
+	ReminderMinutes *int `json:"reminder_minutes"`
+}
+
+// RSVPRequest is the body of PUT /api/events/{id}/rsvp.
+type RSVPRequest struct {
+	Status string `json:"status"` // going, interested or not_going; "" to take it back
+}
+
+// requireEventManager returns the caller if they have Manage Events in
+// guildID, and otherwise answers the request itself.
+func (h *Handler) requireEventManager(w http.ResponseWriter, r *http.Request, guildID string) (*db.User, bool) {
+	u, isMember := h.requireGuildMember(w, r, guildID)
+	if !isMember {
+		return nil, false
+	}
+	if !h.db.HasGuildPermission(u, guildID, db.PermManageEvents) {
+		errResp(w, http.StatusForbidden, "insufficient permissions")
+		return nil, false
+	}
+	return u, true
+}
+
+// visibleEvent returns event id if the caller is in its guild, and
+// otherwise answers 404.
+func (h *Handler) visibleEvent(w http.ResponseWriter, r *http.Request) (*db.User, *db.Event, bool) {
+	e, err := h.db.GetEvent(chi.URLParam(r, "id"))
+	if err != nil {
+		u, err := h.currentUser(r)
+		if err != nil || u == nil {
+			errResp(w, http.StatusUnauthorized, "unauthorized")
+		} else {
+			errResp(w, http.StatusNotFound, "event not found")
+		}
+		return nil, nil, false
+	}
+	u, isMember := h.requireGuildMember(w, r, e.GuildID)
+	if !isMember {
+		return nil, nil, false
+	}
+	return u, e, true
+}
+
+// withNextStart fills in e's next occurrence as of now.
+func withNextStart(e *db.Event, now time.Time) *db.Event {
+	if next, found := e.NextOccurrence(now); found {
+		next = next.UTC()
+		e.NextStart = &next
+	}
+	return e
+}
+
+// ListEvents handles GET /api/events?guild=: the guild's events that are on
+// now or still to come, soonest first, with the caller's RSVPs.
+func (h *Handler) ListEvents(w http.ResponseWriter, r *http.Request) {
+	guildID := guildParam(r)
+	u, isMember := h.requireGuildMember(w, r, guildID)
+	if !isMember {
+		return
+	}
+	events, err := h.db.ListEvents(guildID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list events")
+		return
+	}
+	rsvps, _ := h.db.UserRSVPs(u.ID)
+	now := time.Now()
+	upcoming := []db.Event{}
+	for i := range events {
+		e := withNextStart(&events[i], now)
+		if e.NextStart == nil {
+			continue
+		}
+		e.RSVP = rsvps[e.ID]
+		upcoming = append(upcoming, *e)
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool { return upcoming[i].NextStart.Before(*upcoming[j].NextStart) })
+	ok(w, upcoming)
+}
+
+// GetEvent handles GET /api/events/{id}.
+func (h *Handler) GetEvent(w http.ResponseWriter, r *http.Request) {
+	u, e, found := h.visibleEvent(w, r)
+	if !found {
+		return
+	}
+	rsvps, _ := h.db.UserRSVPs(u.ID)
+	e.RSVP = rsvps[e.ID]
+	ok(w, withNextStart(e, time.Now()))
+}
+
+// validateEvent checks req for guildID, filling in its defaults, and
+// returns what's wrong with it, if anything.
+func (h *Handler) validateEvent(req *EventRequest, guildID string) string {
+	req.Title = strings.TrimSpace(req.Title)
+	req.Description = strings.TrimSpace(req.Description)
+	switch {
+	case req.Title == "" || len(req.Title) > eventMaxTitle:
+		return fmt.Sprintf("title must be 1-%d characters", eventMaxTitle)
+	case len(req.Description) > eventMaxDescription:
+		return fmt.Sprintf("description must be at most %d characters", eventMaxDescription)
+	case req.StartsAt.IsZero():
+		return "starts_at is required"
+	case req.Duration < 0 || req.Duration > eventMaxMinutes:
+		return "duration must be 0 to 10080 minutes"
+	case req.ReminderMinutes != nil && (*req.ReminderMinutes < 0 || *req.ReminderMinutes > eventMaxMinutes):
+		return "reminder_minutes must be 0 to 10080"
+	case req.ChannelID != "" && !h.inGuild(guildID, req.ChannelID, ""):
+		return "channel not found"
+	}
+	switch req.Recurrence {
+	case db.RecurNone, db.RecurDaily, db.RecurWeekly, db.RecurBiweekly, db.RecurMonthly:
+	default:
+		return "recurrence must be daily, weekly, biweekly, monthly or empty"
+	}
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(req.Timezone); err != nil {
+		return "unknown timezone"
+	}
+	return ""
+}
+
+// eventFrom is req as an event.
+func eventFrom(req EventRequest) db.Event {
+	return db.Event{
+		ChannelID:   req.ChannelID,
+		Title:       req.Title,
+		Description: req.Description,
+		StartsAt:    req.StartsAt,
+		Duration:    req.Duration,
+		Recurrence:  req.Recurrence,
+		Timezone:    req.Timezone,
+	}
+}
+
+// CreateEvent handles POST /api/events (Manage Events).
+func (h *Handler) CreateEvent(w http.ResponseWriter, r *http.Request) {
+	var req EventRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if req.GuildID == "" {
+		req.GuildID = db.DefaultGuild
+	}
+	u, isManager := h.requireEventManager(w, r, req.GuildID)
+	if !isManager {
+		return
+	}
+	if msg := h.validateEvent(&req, req.GuildID); msg != "" {
+		errResp(w, http.StatusBadRequest, msg)
+		return
+	}
+	if req.Recurrence == db.RecurNone && req.StartsAt.Add(time.Duration(req.Duration)*time.Minute).Before(time.Now()) {
+		errResp(w, http.StatusBadRequest, "that event is already over")
+		return
+	}
+	e := eventFrom(req)
+	e.GuildID, e.CreatedBy, e.ReminderMinutes = req.GuildID, u.ID, eventReminderDefault
+	if req.ReminderMinutes != nil {
+		e.ReminderMinutes = *req.ReminderMinutes
+	}
+	event, err := h.db.CreateEvent(e)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to create event")
+		return
+	}
+	withNextStart(event, time.Now())
+	h.hub.BroadcastToGuild(event.GuildID, WSEvent{Type: "event.new", Data: event})
+	created(w, event)
+}
+
+// UpdateEvent handles PUT /api/events/{id} (Manage Events).  Moving an
+// event means those coming are reminded of its new time.
+func (h *Handler) UpdateEvent(w http.ResponseWriter, r *http.Request) {
+	old, err := h.db.GetEvent(chi.URLParam(r, "id"))
+	if err != nil {
+		errResp(w, http.StatusNotFound, "event not found")
+		return
+	}
+	u, isManager := h.requireEventManager(w, r, old.GuildID)
+	if !isManager {
+		return
+	}
+	var req EventRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if msg := h.validateEvent(&req, old.GuildID); msg != "" {
+		errResp(w, http.StatusBadRequest, msg)
+		return
+	}
+	e := eventFrom(req)
+	e.ID, e.ReminderMinutes = old.ID, old.ReminderMinutes
+	if req.ReminderMinutes != nil {
+		e.ReminderMinutes = *req.ReminderMinutes
+	}
+	event, err := h.db.UpdateEvent(e)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to update event")
+		return
+	}
+	withNextStart(event, time.Now())
+	h.hub.BroadcastToGuild(event.GuildID, WSEvent{Type: "event.update", Data: event})
+	rsvps, _ := h.db.UserRSVPs(u.ID)
+	event.RSVP = rsvps[event.ID]
+	ok(w, event)
+}
+
+// DeleteEvent handles DELETE /api/events/{id} (Manage Events).
+func (h *Handler) DeleteEvent(w http.ResponseWriter, r *http.Request) {
+	e, err := h.db.GetEvent(chi.URLParam(r, "id"))
+	if err != nil {
+		errResp(w, http.StatusNotFound, "event not found")
+		return
+	}
+	if _, isManager := h.requireEventManager(w, r, e.GuildID); !isManager {
+		return
+	}
+	if err := h.db.DeleteEvent(e.ID); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to delete event")
+		return
+	}
+	h.hub.BroadcastToGuild(e.GuildID, WSEvent{Type: "event.delete", Data: map[string]string{"id": e.ID, "guild_id": e.GuildID}})
+	ok(w, map[string]string{"message": "event deleted"})
+}
+
+// SetEventRSVP handles PUT /api/events/{id}/rsvp: whether the caller is
+// coming.
+func (h *Handler) SetEventRSVP(w http.ResponseWriter, r *http.Request) {
+	u, e, found := h.visibleEvent(w, r)
+	if !found {
+		return
+	}
+	var req RSVPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	switch req.Status {
+	case "", db.RSVPGoing, db.RSVPInterested, db.RSVPNotGoing:
+	default:
+		errResp(w, http.StatusBadRequest, "status must be going, interested, not_going or empty")
+		return
+	}
+	if err := h.db.SetEventRSVP(e.ID, u.ID, req.Status); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to save RSVP")
+		return
+	}
+	e, err := h.db.GetEvent(e.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to save RSVP")
+		return
+	}
+	h.hub.BroadcastToGuild(e.GuildID, WSEvent{Type: "event.rsvp", Data: map[string]interface{}{
+		"event_id":   e.ID,
+		"guild_id":   e.GuildID,
+		"user_id":    u.ID,
+		"status":     req.Status,
+		"going":      e.Going,
+		"interested": e.Interested,
+	}})
+	e.RSVP = req.Status
+	ok(w, withNextStart(e, time.Now()))
+}
+
+// ListEventRSVPs handles GET /api/events/{id}/rsvps: who has answered, and
+// what.
+func (h *Handler) ListEventRSVPs(w http.ResponseWriter, r *http.Request) {
+	_, e, found := h.visibleEvent(w, r)
+	if !found {
+		return
+	}
+	rsvps, err := h.db.EventRSVPs(e.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list RSVPs")
+		return
+	}
+	ok(w, rsvps)
+}
+
+// sendEventReminders reminds those going to, or interested in, each event
+// whose next occurrence is within its reminder time, once an occurrence.
+func (h *Handler) sendEventReminders() error {
+	events, err := h.db.EventsWithReminders()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range events {
+		e := &events[i]
+		next, found := e.NextOccurrence(now)
+		if !found || !next.After(now) || next.Sub(now) > time.Duration(e.ReminderMinutes)*time.Minute {
+			continue
+		}
+		if e.RemindedFor != nil && e.RemindedFor.Equal(next) {
+			continue
+		}
+		if err := h.db.SetEventReminded(e.ID, next); err != nil {
+			return err
+		}
+		h.remindEvent(e, next, now)
+	}
+	return nil
+}
+
+// remindEvent tells those coming to e that it starts at next.
+func (h *Handler) remindEvent(e *db.Event, next, now time.Time) {
+	rsvps, err := h.db.EventRSVPs(e.ID)
+	if err != nil {
+		return
+	}
+	next = next.UTC()
+	e.NextStart = &next
+	minutes := int(next.Sub(now).Round(time.Minute) / time.Minute)
+	for _, rsvp := range rsvps {
+		if rsvp.Status != db.RSVPGoing && rsvp.Status != db.RSVPInterested {
+			continue
+		}
+		if u, err := h.db.GetUserByID(rsvp.UserID); err != nil || !h.db.CanAccessGuild(u, e.GuildID) {
+			continue
+		}
+		h.hub.relayToUser(rsvp.UserID, WSEvent{Type: "event.reminder", Data: e})
+		go pushToUser(h.db, rsvp.UserID, PushPayload{
+			Key:       pushKeyEvent,
+			Params:    map[string]string{"title": e.Title, "minutes": strconv.Itoa(minutes)},
+			ChannelID: e.ChannelID,
+			Tag:       "event-" + e.ID,
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"chirm/internal/db"
+)
+
+// ─── Firebase Cloud Messaging (HTTP v1) ──────────────────────────────────────
+//
+// Authenticates as a service account: a self-signed RS256 JWT is exchanged
+// for an OAuth2 access token, which is cached until shortly before expiry.
+
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+type fcmTransport struct {
+	projectID   string
+	sendURL     string
+	clientEmail string
+	tokenURI    string
+	key         *rsa.PrivateKey
+	client      *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expires     time.Time
+}
+
+func newFCMTransport(credentialsFile string) (*fcmTransport, error) {
+	raw, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	var sa struct {
+		ProjectID   string `json:"project_id"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(raw, &sa); err != nil {
+		return nil, fmt.Errorf("parse service account: %w", err)
+	}
+	if sa.ProjectID == "" || sa.ClientEmail == "" || sa.PrivateKey == "" {
+		return nil, errors.New("service account JSON needs project_id, client_email and private_key")
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(sa.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("service account key: %w", err)
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &fcmTransport{
+		projectID:   sa.ProjectID,
+		sendURL:     "https://fcm.googleapis.com/v1/projects/" + url.PathEscape(sa.ProjectID) + "/messages:send",
+		clientEmail: sa.ClientEmail,
+		tokenURI:    sa.TokenURI,
+		key:         key,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// token returns a valid OAuth2 access token, fetching a new one if needed.
+func (f *fcmTransport) token() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.accessToken != "" && time.Now().Before(f.expires) {
+		return f.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   f.clientEmail,
+		"scope": fcmScope,
+		"aud":   f.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(f.key)
+	if err != nil {
+		return "", err
+	}
+	resp, err := f.client.PostForm(f.tokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("token endpoint %d: %s", resp.StatusCode, string(body))
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil || tok.AccessToken == "" {
+		return "", errors.New("token endpoint returned no access token")
+	}
+	f.accessToken = tok.AccessToken
+	f.expires = now.Add(time.Duration(tok.ExpiresIn)*time.Second - time.Minute)
+	return f.accessToken, nil
+}
+
+func (f *fcmTransport) Send(sub db.PushSubscription, payload []byte) error {
+	deviceToken, err := nativeToken(sub)
+	if err != nil {
+		return err
+	}
+	var p PushPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	accessToken, err := f.token()
+	if err != nil {
+		return err
+	}
+
+	android := map[string]interface{}{"tag": p.Tag}
+	if p.Count > 0 {
+		android["notification_count"] = p.Count
+	}
+	msg := map[string]interface{}{
+		"message": map[string]interface{}{
+			"token":        deviceToken,
+			"notification": map[string]string{"title": p.Title, "body": p.Body},
+			"data": map[string]string{
+				"channel_id": p.ChannelID,
+				"message_id": p.MessageID,
+				"tag":        p.Tag,
+				"badge":      strconv.Itoa(p.Badge),
+			},
+			"android": map[string]interface{}{"notification": android},
+		},
+	}
+	body, _ := json.Marshal(msg)
+	req, err := http.NewRequest("POST", f.sendURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fcm request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	// UNREGISTERED (404) means the app was uninstalled or the token rotated.
+	if resp.StatusCode == http.StatusNotFound || strings.Contains(string(respBody), "UNREGISTERED") {
+		return fmt.Errorf("fcm %d: %w", resp.StatusCode, errPushGone)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		f.mu.Lock()
+		f.accessToken = "" // fetch a fresh one next time
+		f.mu.Unlock()
+	}
+	return fmt.Errorf("fcm %d: %s", resp.StatusCode, string(respBody))
+}
@@ -0,0 +1,763 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+	"chirm/internal/logging"
+)
+
+// ─── Federation ──────────────────────────────────────────────────────────────
+//
+// With FEDERATION=1 and PUBLIC_URL set, a channel can be shared with a
+// channel on another Chirm server, so allied communities can talk without
+// merging servers.  Admins on both sides link their channel to the other's
+// (see db/federation.go); until both have, nothing arrives.
+//
+// Each server has an Ed25519 key, published at /api/v1/federation, and
+// signs what it sends with it.  Messages posted here in a linked channel,
+// and their edits and deletions, are queued for each link and POSTed to
+// the other server's /api/v1/federation/inbox, retried for
+// federationRetryFor while it's down.  Only messages from here are sent,
+// not ones that came from elsewhere, so a channel linked to two servers
+// doesn't pass one's messages to the other; link every pair that should
+// talk.
+//
+// A message that arrives is posted as its author on the other server, a
+// remote user shown as name@host, with the same permissions as a webhook:
+// none.  Its attachments are downloaded from the other server and stored
+// like uploads, with the same type checks, scanning and quota, so they
+// stay when the other server forgets them.
+
+const (
+	federationVersion        = 1
+	federationClockSkew      = 5 * time.Minute // how far a signed request's time may be from ours
+	federationTimeout        = 30 * time.Second
+	federationFetchTimeout   = 2 * time.Minute // for an event's attachments, all together
+	federationRetryFor       = 24 * time.Hour
+	federationInterval       = 30 * time.Second
+	federationMaxEvent       = 1 << 20
+	federationMaxAttachments = 10
+	federationBatch          = 100
+)
+
+// Headers on requests to another server's inbox.
+const (
+	federationServerHeader    = "X-Chirm-Server"
+	federationTimeHeader      = "X-Chirm-Timestamp"
+	federationSignatureHeader = "X-Chirm-Signature"
+)
+
+var federationClient = &http.Client{Timeout: federationTimeout}
+
+// federationFileClient fetches the attachments on other servers' events.
+// Their redirects are followed only as far as the same server; see
+// fetchFederatedAttachment.
+var federationFileClient = &http.Client{Timeout: federationFetchTimeout}
+
+var errFederationRedirect = errors.New("redirected away from the linked server")
+
+// FederationInfo is GET /api/federation: who this server is to others.
+type FederationInfo struct {
+	Server    string `json:"server"` // its URL, which it signs requests as
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key"` // Ed25519, base64
+	Version   int    `json:"version"`
+}
+
+// FederatedAuthor is who wrote a federated message, on their server.
+type FederatedAuthor struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Avatar   string `json:"avatar,omitempty"` // absolute URL
+}
+
+// FederatedAttachment is a file on a federated message, for the other
+// server to download.
+type FederatedAttachment struct {
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	URL      string `json:"url"` // absolute, and signed for long enough to fetch
+	Kind     string `json:"kind,omitempty"`
+}
+
+// FederatedRef is a message on one of the servers, by its ID there.
+type FederatedRef struct {
+	Server string `json:"server"`
+	ID     string `json:"id"`
+}
+
+// FederatedMessage is a message as it goes between servers.  IDs are the
+// sending server's.
+type FederatedMessage struct {
+	ID          string                `json:"id"`
+	Author      *FederatedAuthor      `json:"author,omitempty"`
+	Content     string                `json:"content,omitempty"`
+	ReplyTo     *FederatedRef         `json:"reply_to,omitempty"`
+	Attachments []FederatedAttachment `json:"attachments,omitempty"`
+	RichEmbeds  json.RawMessage       `json:"rich_embeds,omitempty"`
+	CreatedAt   time.Time             `json:"created_at"`
+}
+
+// FederationEvent is what one server POSTs to another's inbox.  ChannelID
+// is the receiving server's channel.
+type FederationEvent struct {
+	Type      string           `json:"type"` // message.new, message.edit or message.delete
+	ChannelID string           `json:"channel_id"`
+	Message   FederatedMessage `json:"message"`
+}
+
+// SetFederation turns federation on or off.  It needs PUBLIC_URL, set with
+// SetDiscovery first, as the name other servers know this one by.
+func (h *Handler) SetFederation(on bool) error {
+	if !on {
+		return nil
+	}
+	origin := serverOrigin(h.discovery.PublicURL)
+	if origin == "" {
+		return errors.New("federation needs PUBLIC_URL")
+	}
+	key, err := h.loadFederationKey()
+	if err != nil {
+		return err
+	}
+	h.federation, h.federationKey = origin, key
+	return nil
+}
+
+// loadFederationKey returns the server's signing key, making it the first
+// time.
+func (h *Handler) loadFederationKey() (ed25519.PrivateKey, error) {
+	if s, _ := h.db.GetSetting("federation_private_key"); s != "" {
+		seed, err := base64.StdEncoding.DecodeString(s)
+		if err == nil && len(seed) == ed25519.SeedSize {
+			return ed25519.NewKeyFromSeed(seed), nil
+		}
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.db.SetSetting("federation_private_key", base64.StdEncoding.EncodeToString(key.Seed())); err != nil {
+		return nil, err
+	}
+	slog.Info("federation: made a signing key")
+	return key, nil
+}
+
+// serverOrigin is the scheme and host of raw, a server's URL, or "" if it
+// isn't an http or https URL.
+func serverOrigin(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + strings.ToLower(u.Host)
+}
+
+// absoluteURL makes path, one of this server's, a full URL.
+func (h *Handler) absoluteURL(path string) string {
+	if strings.HasPrefix(path, "/") {
+		return h.federation + path
+	}
+	return path
+}
+
+// signFederation is the signature of body sent at ts.
+func signFederation(key ed25519.PrivateKey, ts string, body []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, append([]byte(ts+"\n"), body...)))
+}
+
+// verifyFederation checks sig, over body sent at ts, against publicKey,
+// and that ts is close enough to now.
+func verifyFederation(publicKey, ts, sig string, body []byte, now time.Time) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("bad public key")
+	}
+	sent, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("bad timestamp")
+	}
+	if d := now.Sub(time.Unix(sent, 0)); d > federationClockSkew || d < -federationClockSkew {
+		return errors.New("timestamp too far from now")
+	}
+	s, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil || !ed25519.Verify(key, append([]byte(ts+"\n"), body...), s) {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+// GetFederationInfo handles GET /api/federation: this server's name and
+// key, for another server's admins to link to it.
+func (h *Handler) GetFederationInfo(w http.ResponseWriter, r *http.Request) {
+	if h.federation == "" {
+		errResp(w, http.StatusNotFound, "federation is off")
+		return
+	}
+	name, _ := h.db.GetSetting("server_name")
+	ok(w, FederationInfo{
+		Server:    h.federation,
+		Name:      name,
+		PublicKey: base64.StdEncoding.EncodeToString(h.federationKey.Public().(ed25519.PublicKey)),
+		Version:   federationVersion,
+	})
+}
+
+// fetchFederationInfo asks server for its FederationInfo.
+func fetchFederationInfo(ctx context.Context, server string) (*FederationInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server+"/api/v1/federation", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := federationClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d; is federation on there?", resp.StatusCode)
+	}
+	var info FederationInfo
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&info); err != nil {
+		return nil, errors.New("not a Chirm server")
+	}
+	if key, err := base64.StdEncoding.DecodeString(info.PublicKey); err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("no usable public key")
+	}
+	if serverOrigin(info.Server) != server {
+		return nil, fmt.Errorf("it calls itself %s; link to that instead", info.Server)
+	}
+	return &info, nil
+}
+
+// ─── Links ───────────────────────────────────────────────────────────────────
+
+// ListFederationLinks handles GET /api/channels/{id}/federation (guild
+// admins only).
+func (h *Handler) ListFederationLinks(w http.ResponseWriter, r *http.Request) {
+	if _, isAdmin := h.requireGuildAdmin(w, r, h.db.ChannelGuild(chi.URLParam(r, "id"))); !isAdmin {
+		return
+	}
+	links, err := h.db.ListFederationLinks(chi.URLParam(r, "id"))
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list links")
+		return
+	}
+	ok(w, links)
+}
+
+// CreateFederationLinkRequest is the body of POST
+// /api/channels/{id}/federation.
+type CreateFederationLinkRequest struct {
+	Server    string `json:"server"`     // the other server's URL
+	ChannelID string `json:"channel_id"` // its channel's ID
+}
+
+// CreateFederationLink handles POST /api/channels/{id}/federation (guild
+// admins only): the channel is linked to a channel on another server,
+// whose key is fetched and pinned now.
+func (h *Handler) CreateFederationLink(w http.ResponseWriter, r *http.Request) {
+	u, isAdmin := h.requireGuildAdmin(w, r, h.db.ChannelGuild(chi.URLParam(r, "id")))
+	if !isAdmin {
+		return
+	}
+	if h.federation == "" {
+		errResp(w, http.StatusServiceUnavailable, "federation is off on this server")
+		return
+	}
+	ch, err := h.db.GetChannelByID(chi.URLParam(r, "id"))
+	if err != nil {
+		errResp(w, http.StatusNotFound, "channel not found")
+		return
+	}
+	if ch.Type != "text" {
+		errResp(w, http.StatusBadRequest, "only text channels can be linked")
+		return
+	}
+	var req CreateFederationLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	server := serverOrigin(req.Server)
+	req.ChannelID = strings.TrimSpace(req.ChannelID)
+	if server == "" {
+		errResp(w, http.StatusBadRequest, "server must be an http or https URL")
+		return
+	}
+	if server == h.federation {
+		errResp(w, http.StatusBadRequest, "that's this server")
+		return
+	}
+	if req.ChannelID == "" || len(req.ChannelID) > 64 {
+		errResp(w, http.StatusBadRequest, "channel_id is required")
+		return
+	}
+	if _, err := h.db.FederationLinkFor(ch.ID, server); err == nil {
+		errResp(w, http.StatusConflict, "this channel is already linked to that server")
+		return
+	}
+	info, err := fetchFederationInfo(r.Context(), server)
+	if err != nil {
+		errResp(w, http.StatusBadGateway, "couldn't reach the server: "+err.Error())
+		return
+	}
+	link, err := h.db.CreateFederationLink(ch.ID, server, req.ChannelID, info.PublicKey, u.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to link channel")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{
+		ActorID:  u.ID,
+		Action:   "federation.link",
+		TargetID: link.ID,
+		Details:  fmt.Sprintf("linked #%s to channel %s on %s", ch.Name, req.ChannelID, server),
+	})
+	created(w, link)
+}
+
+// DeleteFederationLink handles DELETE /api/federation/links/{id} (guild
+// admins only).  Messages that came over the link stay.
+func (h *Handler) DeleteFederationLink(w http.ResponseWriter, r *http.Request) {
+	guildID := db.DefaultGuild
+	link, err := h.db.GetFederationLink(chi.URLParam(r, "id"))
+	if err == nil {
+		guildID = h.db.ChannelGuild(link.ChannelID)
+	}
+	u, isAdmin := h.requireGuildAdmin(w, r, guildID)
+	if !isAdmin {
+		return
+	}
+	if err != nil {
+		errResp(w, http.StatusNotFound, "link not found")
+		return
+	}
+	if err := h.db.DeleteFederationLink(link.ID); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to unlink channel")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{
+		ActorID:  u.ID,
+		Action:   "federation.unlink",
+		TargetID: link.ID,
+		Details:  "unlinked a channel from " + link.Server,
+	})
+	ok(w, map[string]string{"message": "link deleted"})
+}
+
+// ─── Sending ─────────────────────────────────────────────────────────────────
+
+// federate queues typ, an event about msg, for each server msg's channel
+// is linked to, and starts delivering it.  Messages that came from another
+// server, and system messages, aren't sent on.
+func (h *Handler) federate(typ string, msg *db.Message) {
+	if h.federation == "" || msg.FederationID != "" || msg.Type != "" {
+		return
+	}
+	links, err := h.db.ListFederationLinks(msg.ChannelID)
+	if err != nil || len(links) == 0 {
+		return
+	}
+	fm := FederatedMessage{ID: msg.ID, CreatedAt: msg.CreatedAt}
+	if typ != "message.delete" {
+		fm.Content = msg.Content
+		fm.RichEmbeds = msg.RichEmbeds
+		if a := msg.Author; a != nil {
+			fm.Author = &FederatedAuthor{ID: a.ID, Username: a.Username, Avatar: h.absoluteURL(a.Avatar)}
+		}
+		if msg.ReplyToID != nil {
+			fm.ReplyTo = h.federatedRef(*msg.ReplyToID)
+		}
+		for _, a := range msg.Attachments {
+			if a.URL == "" {
+				continue // evicted
+			}
+			fm.Attachments = append(fm.Attachments, FederatedAttachment{
+				Name: a.OriginalName, MimeType: a.MimeType, Size: a.Size, URL: h.absoluteURL(a.URL), Kind: a.Kind,
+			})
+		}
+		if msg.Sticker != nil && fm.Content == "" {
+			fm.Content = "[sticker: " + msg.Sticker.Name + "]"
+		}
+	}
+	for _, link := range links {
+		event, _ := json.Marshal(FederationEvent{Type: typ, ChannelID: link.RemoteChannelID, Message: fm})
+		if err := h.db.QueueFederationEvent(link.ID, event); err != nil {
+			slog.Error("federation: queueing event", "link", link.ID, "err", err)
+		}
+	}
+	go h.deliverFederation()
+}
+
+// federatedRef names message id to other servers: by the ID it had where
+// it was first posted.
+func (h *Handler) federatedRef(id string) *FederatedRef {
+	m, err := h.db.GetMessageByID(id)
+	if err != nil {
+		return nil
+	}
+	if m.FederationID == "" {
+		return &FederatedRef{Server: h.federation, ID: m.ID}
+	}
+	link, err := h.db.GetFederationLink(m.FederationID)
+	if err != nil {
+		return nil
+	}
+	return &FederatedRef{Server: link.Server, ID: m.RemoteID}
+}
+
+// deliverFederation sends the outbox's due events, in order for each link;
+// a link that fails is left till next time.  The federation job runs it
+// too, for the retries.
+func (h *Handler) deliverFederation() error {
+	if h.federation == "" {
+		return nil
+	}
+	h.federationMu.Lock()
+	defer h.federationMu.Unlock()
+
+	now := time.Now()
+	events, err := h.db.DueFederationEvents(now, federationBatch)
+	if err != nil {
+		return err
+	}
+	links := map[string]*db.FederationLink{}
+	failed := map[string]bool{}
+	var failures int
+	for _, e := range events {
+		if failed[e.LinkID] {
+			continue
+		}
+		link, known := links[e.LinkID]
+		if !known {
+			link, _ = h.db.GetFederationLink(e.LinkID)
+			links[e.LinkID] = link
+		}
+		if link == nil {
+			h.db.DeleteFederationEvent(e.ID)
+			continue
+		}
+		err := h.sendFederationEvent(link.Server, e.Event)
+		if err == nil {
+			h.db.DeleteFederationEvent(e.ID)
+			if link.LastError != "" {
+				h.db.SetFederationLinkError(link.ID, "")
+				link.LastError = ""
+			}
+			continue
+		}
+		failed[e.LinkID] = true
+		failures++
+		h.db.SetFederationLinkError(link.ID, err.Error())
+		if now.Sub(e.CreatedAt) > federationRetryFor {
+			slog.Warn("federation: giving up on event", "server", link.Server, "link", link.ID, "err", err)
+			h.db.DeleteFederationEvent(e.ID)
+			continue
+		}
+		// Back off: 30s, 1m, 2m… up to an hour.
+		wait := min(federationInterval<<min(e.Attempts, 7), time.Hour)
+		h.db.RetryFederationEvent(e.ID, now.Add(wait))
+		slog.Info("federation: delivery failed", "server", link.Server, "link", link.ID, "attempt", e.Attempts+1, "err", err)
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d link(s) failing", failures)
+	}
+	return nil
+}
+
+// sendFederationEvent POSTs event, signed, to server's inbox.
+func (h *Handler) sendFederationEvent(server string, event []byte) error {
+	req, err := http.NewRequest(http.MethodPost, server+"/api/v1/federation/inbox", bytes.NewReader(event))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(federationServerHeader, h.federation)
+	req.Header.Set(federationTimeHeader, ts)
+	req.Header.Set(federationSignatureHeader, signFederation(h.federationKey, ts, event))
+	resp, err := federationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 4<<10)).Decode(&body)
+	if body.Error != "" {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, body.Error)
+	}
+	return fmt.Errorf("HTTP %d", resp.StatusCode)
+}
+
+// ─── Receiving ───────────────────────────────────────────────────────────────
+
+// FederationInbox handles POST /api/federation/inbox: an event from a
+// server one of this server's channels is linked to, signed with its key.
+func (h *Handler) FederationInbox(w http.ResponseWriter, r *http.Request) {
+	if h.federation == "" {
+		errResp(w, http.StatusNotFound, "federation is off")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, federationMaxEvent+1))
+	if err != nil || len(body) > federationMaxEvent {
+		errResp(w, http.StatusRequestEntityTooLarge, "event too large")
+		return
+	}
+	var event FederationEvent
+	if err := json.Unmarshal(body, &event); err != nil || event.Message.ID == "" {
+		errResp(w, http.StatusBadRequest, "invalid event")
+		return
+	}
+	server := serverOrigin(r.Header.Get(federationServerHeader))
+	link, err := h.db.FederationLinkFor(event.ChannelID, server)
+	if err != nil {
+		errResp(w, http.StatusForbidden, "that channel isn't linked to your server here")
+		return
+	}
+	if err := verifyFederation(link.PublicKey, r.Header.Get(federationTimeHeader), r.Header.Get(federationSignatureHeader), body, time.Now()); err != nil {
+		errResp(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	log := logging.FromContext(r.Context()).With("server", server, "link", link.ID)
+
+	fm := &event.Message
+	switch event.Type {
+	case "message.new":
+		if _, err := h.db.FederatedMessage(link.ID, fm.ID); err == nil {
+			ok(w, map[string]string{"message": "already have it"})
+			return
+		}
+		if err := validateFederatedMessage(fm); err != nil {
+			errResp(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		author, err := h.db.SaveRemoteUser(server, fm.Author.ID, fm.Author.Username, proxiedImageURL(fm.Author.Avatar))
+		if err != nil {
+			errResp(w, http.StatusInternalServerError, "failed to save author")
+			return
+		}
+		attachments := h.fetchFederatedAttachments(r.Context(), log, link, author, fm.Attachments)
+		msg, err := h.db.CreateFederatedMessage(link, author, fm.ID, fm.Content, h.localRef(link, fm.ReplyTo), fm.RichEmbeds)
+		if err != nil {
+			errResp(w, http.StatusInternalServerError, "failed to post message")
+			return
+		}
+		for _, a := range attachments {
+			h.db.LinkAttachment(a.ID, msg.ID)
+		}
+		if len(attachments) > 0 {
+			if full, err := h.db.GetMessageByID(msg.ID); err == nil {
+				msg = full
+			}
+		}
+		h.publishMessage(msg, "")
+		created(w, map[string]string{"id": msg.ID})
+
+	case "message.edit":
+		msg, err := h.db.FederatedMessage(link.ID, fm.ID)
+		if err != nil {
+			ok(w, map[string]string{"message": "no such message"})
+			return
+		}
+		fm.Content = strings.TrimSpace(fm.Content)
+		if fm.Content == "" || len(fm.Content) > 4000 {
+			errResp(w, http.StatusBadRequest, "content must be 1-4000 characters")
+			return
+		}
+		if err := h.db.EditMessage(msg.ID, fm.Content); err != nil {
+			errResp(w, http.StatusInternalServerError, "failed to edit message")
+			return
+		}
+		updated, _ := h.db.GetMessageByID(msg.ID)
+		if updated != nil {
+			h.queueEmbeds(updated)
+			h.hub.BroadcastToChannel(msg.ChannelID, WSEvent{Type: "message.edit", Data: updated})
+		}
+		ok(w, map[string]string{"message": "edited"})
+
+	case "message.delete":
+		msg, err := h.db.FederatedMessage(link.ID, fm.ID)
+		if err != nil {
+			ok(w, map[string]string{"message": "no such message"})
+			return
+		}
+		if err := h.db.DeleteMessage(msg.ID); err != nil {
+			errResp(w, http.StatusInternalServerError, "failed to delete message")
+			return
+		}
+		h.hub.BroadcastToChannel(msg.ChannelID, WSEvent{Type: "message.delete", Data: map[string]string{"id": msg.ID, "channel_id": msg.ChannelID}})
+		ok(w, map[string]string{"message": "deleted"})
+
+	default:
+		errResp(w, http.StatusBadRequest, "unknown event type")
+	}
+}
+
+// validateFederatedMessage checks a new message from another server
+// against the limits messages here have.
+func validateFederatedMessage(fm *FederatedMessage) error {
+	if fm.Author == nil || fm.Author.ID == "" {
+		return errors.New("message has no author")
+	}
+	fm.Author.Username = strings.TrimSpace(fm.Author.Username)
+	if fm.Author.Username == "" || len(fm.Author.Username) > 64 || strings.ContainsAny(fm.Author.Username, "@\n") {
+		return errors.New("invalid author name")
+	}
+	fm.Content = strings.TrimSpace(fm.Content)
+	if len(fm.Content) > 4000 {
+		return errors.New("message too long")
+	}
+	if fm.Content == "" && len(fm.Attachments) == 0 && len(fm.RichEmbeds) == 0 {
+		return errors.New("message cannot be empty")
+	}
+	if len(fm.Attachments) > federationMaxAttachments {
+		return fmt.Errorf("at most %d attachments", federationMaxAttachments)
+	}
+	if len(fm.RichEmbeds) > 0 {
+		var embeds []RichEmbed
+		if err := json.Unmarshal(fm.RichEmbeds, &embeds); err != nil {
+			return errors.New("invalid embeds")
+		}
+		if err := validateRichEmbeds(embeds); err != nil {
+			return err
+		}
+		fm.RichEmbeds, _ = json.Marshal(embeds)
+	}
+	return nil
+}
+
+// localRef finds the message here that ref, from the server at the other
+// end of link, names, or returns nil.
+func (h *Handler) localRef(link *db.FederationLink, ref *FederatedRef) *string {
+	if ref == nil {
+		return nil
+	}
+	var m *db.Message
+	switch ref.Server {
+	case h.federation:
+		m, _ = h.db.GetMessageByID(ref.ID)
+	case link.Server:
+		m, _ = h.db.FederatedMessage(link.ID, ref.ID)
+	}
+	if m == nil || m.ChannelID != link.ChannelID {
+		return nil
+	}
+	return &m.ID
+}
+
+// fetchFederatedAttachments downloads a new message's attachments from the
+// other server and stores them as uploads by author.  Any that can't be
+// fetched, or wouldn't be accepted as an upload, are left out.
+func (h *Handler) fetchFederatedAttachments(ctx context.Context, log *slog.Logger, link *db.FederationLink, author *db.RemoteUser, files []FederatedAttachment) []*db.Attachment {
+	if len(files) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, federationFetchTimeout)
+	defer cancel()
+	maxBytes, _ := h.maxUploadBytes()
+	uploader := &db.User{ID: author.ID, Username: author.Username}
+	var out []*db.Attachment
+	for _, f := range files {
+		// Files come from the server that sent them, nowhere else.
+		if !strings.HasPrefix(f.URL, link.Server+"/") {
+			log.Warn("federation: attachment from elsewhere", "url", f.URL)
+			continue
+		}
+		if f.Size > maxBytes {
+			log.Info("federation: attachment too large", "name", f.Name, "bytes", f.Size)
+			continue
+		}
+		att, err := h.fetchFederatedAttachment(ctx, link.Server, uploader, f, maxBytes)
+		if err != nil {
+			log.Warn("federation: fetching attachment", "name", f.Name, "err", err)
+			continue
+		}
+		out = append(out, att)
+	}
+	return out
+}
+
+// fetchFederatedAttachment downloads f from server and stores it.  A
+// redirect anywhere but server is refused, so a linked server can't have
+// this one fetch from its own network.
+func (h *Handler) fetchFederatedAttachment(ctx context.Context, server string, uploader *db.User, f FederatedAttachment, maxBytes int64) (*db.Attachment, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := *federationFileClient
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		if serverOrigin(req.URL.String()) != serverOrigin(server) {
+			return errFederationRedirect
+		}
+		return nil
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	tmp, err := os.CreateTemp("", "chirm-federated-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	n, err := io.Copy(tmp, io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > maxBytes {
+		return nil, errors.New("too large")
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	name := f.Name
+	if name == "" {
+		name = "file"
+	}
+	kind := ""
+	if f.Kind == attachmentKindVoice {
+		kind = attachmentKindVoice
+	}
+	att, uerr := h.storeUpload(ctx, uploader, tmp, &multipart.FileHeader{Filename: name, Size: n}, kind)
+	if uerr != nil {
+		return nil, errors.New(uerr.msg)
+	}
+	return att, nil
+}
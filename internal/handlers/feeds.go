@@ -0,0 +1,467 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+
+	"chirm/internal/db"
+)
+
+// ─── RSS and Atom feeds ──────────────────────────────────────────────────────
+//
+// Guild admins give a channel feeds to follow.  The "feeds" job polls them
+// all every feedInterval, and posts each new item as a rich embed under the
+// feed's title.  A feed that's just been added posts only its latest item,
+// so a news channel isn't flooded with the feed's back catalogue, and a poll
+// posts at most feedMaxPosts, the rest being passed over.
+
+const (
+	feedInterval  = 10 * time.Minute
+	feedTimeout   = 15 * time.Second
+	feedMaxBody   = 5 << 20
+	feedMaxPosts  = 5
+	feedForget    = 30 * 24 * time.Hour // after an item leaves its feed
+	feedSummary   = 500                 // characters of an item's text shown
+	feedUserAgent = "Mozilla/5.0 (compatible; Chirm/1.0; +https://chirm.app) FeedFetcher"
+)
+
+// feedClient only connects to public addresses, since guild admins choose
+// what it fetches.
+var feedClient = &http.Client{
+	Timeout: feedTimeout,
+	Transport: &http.Transport{
+		DialContext:           publicDialer.DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		IdleConnTimeout:       time.Minute,
+	},
+}
+
+// feedItem is an item of a feed, as read.
+type feedItem struct {
+	Key       string // GUID, or else the link
+	Title     string
+	Link      string
+	Summary   string
+	Author    string
+	Image     string
+	Published time.Time
+}
+
+// parsedFeed is a feed, as read.
+type parsedFeed struct {
+	Title string
+	Items []feedItem // as listed, usually newest first
+}
+
+// feedDoc is any of RSS 2.0 (<rss><channel>), RSS 1.0 (<rdf:RDF>) and
+// Atom (<feed>).
+type feedDoc struct {
+	XMLName xml.Name
+	Title   string `xml:"title"` // Atom
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"` // RSS 2.0
+	} `xml:"channel"`
+	Items   []rssItem   `xml:"item"` // RSS 1.0
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	Description string `xml:"description"`
+	Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Author      string `xml:"author"`
+	Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Enclosure   struct {
+		URL  string `xml:"url,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"enclosure"`
+	Thumbnail struct {
+		URL string `xml:"url,attr"`
+	} `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+}
+
+type atomEntry struct {
+	Title string `xml:"title"`
+	ID    string `xml:"id"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"link"`
+	Summary   string `xml:"summary"`
+	Content   string `xml:"content"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+	Author    struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Thumbnail struct {
+		URL string `xml:"url,attr"`
+	} `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+}
+
+// parseFeed reads an RSS or Atom document.
+func parseFeed(r io.Reader) (*parsedFeed, error) {
+	dec := xml.NewDecoder(r)
+	dec.CharsetReader = charset.NewReaderLabel
+	dec.Strict = false
+	var doc feedDoc
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("not a feed: %w", err)
+	}
+	f := &parsedFeed{}
+	switch strings.ToLower(doc.XMLName.Local) {
+	case "rss", "rdf":
+		f.Title = doc.Channel.Title
+		for _, it := range append(doc.Channel.Items, doc.Items...) {
+			item := feedItem{
+				Key:       strings.TrimSpace(it.GUID),
+				Title:     it.Title,
+				Link:      strings.TrimSpace(it.Link),
+				Summary:   firstNonEmpty(it.Description, it.Content),
+				Author:    firstNonEmpty(it.Creator, it.Author),
+				Image:     it.Thumbnail.URL,
+				Published: parseFeedTime(firstNonEmpty(it.PubDate, it.Date)),
+			}
+			if strings.HasPrefix(it.Enclosure.Type, "image/") {
+				item.Image = it.Enclosure.URL
+			}
+			f.Items = append(f.Items, item)
+		}
+	case "feed":
+		f.Title = doc.Title
+		for _, e := range doc.Entries {
+			item := feedItem{
+				Key:       strings.TrimSpace(e.ID),
+				Title:     e.Title,
+				Summary:   firstNonEmpty(e.Summary, e.Content),
+				Author:    e.Author.Name,
+				Image:     e.Thumbnail.URL,
+				Published: parseFeedTime(firstNonEmpty(e.Published, e.Updated)),
+			}
+			for _, l := range e.Links {
+				if (l.Rel == "" || l.Rel == "alternate") && item.Link == "" {
+					item.Link = strings.TrimSpace(l.Href)
+				}
+			}
+			f.Items = append(f.Items, item)
+		}
+	default:
+		return nil, errors.New("not an RSS or Atom feed")
+	}
+	f.Title = strings.TrimSpace(f.Title)
+	kept := f.Items[:0]
+	for _, item := range f.Items {
+		if item.Key == "" {
+			item.Key = item.Link
+		}
+		if item.Key == "" {
+			item.Key = item.Title + "\x00" + item.Published.String()
+		}
+		item.Title = strings.TrimSpace(htmlText(item.Title))
+		item.Summary = htmlText(item.Summary)
+		if item.Title != "" || item.Summary != "" {
+			kept = append(kept, item)
+		}
+	}
+	f.Items = kept
+	return f, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseFeedTime reads the dates feeds use, RSS's RFC 822 and Atom's
+// RFC 3339, and returns the zero time for anything else.
+func parseFeedTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339, time.RFC1123Z, time.RFC1123, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", "2 Jan 2006 15:04:05 -0700", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// htmlText is the text of an HTML fragment, as one line of plain text.
+func htmlText(s string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(b.String()), " ")
+		case html.TextToken:
+			b.Write(z.Text())
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			b.WriteByte(' ')
+		}
+	}
+}
+
+// fetchFeed gets and reads the feed at f.URL, sending its ETag and
+// Last-Modified so an unchanged feed isn't sent again; it returns nil for
+// one that hasn't changed.
+func fetchFeed(f *db.Feed) (*parsedFeed, string, string, error) {
+	req, err := http.NewRequest("GET", f.URL, nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	req.Header.Set("User-Agent", feedUserAgent)
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, text/xml;q=0.9, */*;q=0.5")
+	if f.ETag != "" {
+		req.Header.Set("If-None-Match", f.ETag)
+	}
+	if f.LastModified != "" {
+		req.Header.Set("If-Modified-Since", f.LastModified)
+	}
+	resp, err := feedClient.Do(req)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, f.ETag, f.LastModified, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", fmt.Errorf("the feed answered %s", resp.Status)
+	}
+	parsed, err := parseFeed(io.LimitReader(resp.Body, feedMaxBody))
+	if err != nil {
+		return nil, "", "", err
+	}
+	return parsed, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), nil
+}
+
+// feedEmbed is item as a rich embed, fit to pass validateRichEmbeds.
+func feedEmbed(feedTitle string, item feedItem) RichEmbed {
+	e := RichEmbed{
+		Title:       truncateRunes(item.Title, maxEmbedTitle),
+		Description: truncateRunes(item.Summary, feedSummary),
+		Author:      truncateRunes(item.Author, maxEmbedAuthor),
+		Footer:      truncateRunes(feedTitle, maxEmbedFooter),
+	}
+	if u, err := url.Parse(item.Link); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		e.URL = item.Link
+	}
+	if u, err := url.Parse(item.Image); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		e.Thumbnail = item.Image
+	}
+	if !item.Published.IsZero() {
+		t := item.Published.UTC()
+		e.Timestamp = &t
+	}
+	return e
+}
+
+// truncateRunes shortens s to at most n characters, ending in an
+// ellipsis if it was cut.
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return strings.TrimSpace(string(r[:n-1])) + "…"
+}
+
+// pollFeed checks f for new items and posts them; only the latest, if f
+// has never been checked.
+func (h *Handler) pollFeed(f *db.Feed, now time.Time) error {
+	parsed, etag, lastModified, err := fetchFeed(f)
+	if err != nil {
+		h.db.FeedChecked(f.ID, now, err.Error(), f.ETag, f.LastModified)
+		return err
+	}
+	if parsed == nil {
+		return h.db.FeedChecked(f.ID, now, "", etag, lastModified)
+	}
+	keys := make([]string, len(parsed.Items))
+	byKey := make(map[string]feedItem, len(parsed.Items))
+	for i, item := range parsed.Items {
+		keys[i] = item.Key
+		byKey[item.Key] = item
+	}
+	fresh, err := h.db.SeeFeedItems(f.ID, keys, now, now.Add(-feedForget))
+	if err != nil {
+		return err
+	}
+	items := make([]feedItem, 0, len(fresh))
+	for _, key := range fresh {
+		items = append(items, byKey[key])
+	}
+	// Newest first, by date where the feed gives one, else as listed.
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Published.After(items[j].Published) })
+	limit := feedMaxPosts
+	if f.LastChecked == nil {
+		limit = 1
+	}
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	title := f.Title
+	if title == "" {
+		title = parsed.Title
+	}
+	for i := len(items) - 1; i >= 0; i-- {
+		embeds := []RichEmbed{feedEmbed(title, items[i])}
+		if err := validateRichEmbeds(embeds); err != nil {
+			continue
+		}
+		data, _ := json.Marshal(embeds)
+		msg, err := h.db.CreateFeedMessage(f, "", data)
+		if err != nil {
+			return err
+		}
+		h.publishMessage(msg, "")
+	}
+	return h.db.FeedChecked(f.ID, now, "", etag, lastModified)
+}
+
+// pollFeeds checks every feed, a failing one not holding up the rest.
+func (h *Handler) pollFeeds() error {
+	feeds, err := h.db.AllFeeds()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range feeds {
+		if err := h.pollFeed(&feeds[i], now); err != nil {
+			slog.Warn("feed poll failed", "feed", feeds[i].ID, "url", feeds[i].URL, "err", err)
+		}
+	}
+	return nil
+}
+
+// ListFeeds handles GET /api/channels/{id}/feeds (guild admins only).
+func (h *Handler) ListFeeds(w http.ResponseWriter, r *http.Request) {
+	if _, isAdmin := h.requireGuildAdmin(w, r, h.db.ChannelGuild(chi.URLParam(r, "id"))); !isAdmin {
+		return
+	}
+	feeds, err := h.db.ListFeeds(chi.URLParam(r, "id"))
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list feeds")
+		return
+	}
+	ok(w, feeds)
+}
+
+// CreateFeedRequest is the body of POST /api/channels/{id}/feeds.
+type CreateFeedRequest struct {
+	URL   string `json:"url"`
+	Title string `json:"title"` // to post under; the feed's own if left out
+}
+
+// CreateFeed handles POST /api/channels/{id}/feeds (guild admins only).  The
+// feed is fetched at once, to check it is one, and its latest item posted.
+func (h *Handler) CreateFeed(w http.ResponseWriter, r *http.Request) {
+	u, isAdmin := h.requireGuildAdmin(w, r, h.db.ChannelGuild(chi.URLParam(r, "id")))
+	if !isAdmin {
+		return
+	}
+	ch, err := h.db.GetChannelByID(chi.URLParam(r, "id"))
+	if err != nil {
+		errResp(w, http.StatusNotFound, "channel not found")
+		return
+	}
+	var req CreateFeedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	req.URL = strings.TrimSpace(req.URL)
+	if parsed, err := url.Parse(req.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		errResp(w, http.StatusBadRequest, "url must be an http or https URL")
+		return
+	}
+	req.Title = strings.TrimSpace(req.Title)
+	if len(req.Title) > 32 {
+		errResp(w, http.StatusBadRequest, "title must be at most 32 characters")
+		return
+	}
+
+	parsed, _, _, err := fetchFeed(&db.Feed{URL: req.URL})
+	if err != nil {
+		slog.Debug("new feed unreadable", "url", req.URL, "err", err)
+		errResp(w, http.StatusBadRequest, "couldn't read the feed")
+		return
+	}
+	if req.Title == "" {
+		req.Title = truncateRunes(parsed.Title, 32)
+	}
+	if req.Title == "" {
+		req.Title = "Feed"
+	}
+	feed, err := h.db.CreateFeed(ch.ID, req.URL, req.Title, u.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to add feed")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{
+		ActorID:  u.ID,
+		Action:   "feed.create",
+		TargetID: feed.ID,
+		Details:  "added feed " + feed.URL + " to #" + ch.Name,
+	})
+	if err := h.pollFeed(feed, time.Now()); err != nil {
+		slog.Warn("feed poll failed", "feed", feed.ID, "url", feed.URL, "err", err)
+	}
+	if f, err := h.db.GetFeed(feed.ID); err == nil {
+		feed = f
+	}
+	created(w, feed)
+}
+
+// DeleteFeed handles DELETE /api/feeds/{id} (guild admins only).  What it
+// posted stays.
+func (h *Handler) DeleteFeed(w http.ResponseWriter, r *http.Request) {
+	guildID := db.DefaultGuild
+	feed, err := h.db.GetFeed(chi.URLParam(r, "id"))
+	if err == nil {
+		guildID = h.db.ChannelGuild(feed.ChannelID)
+	}
+	u, isAdmin := h.requireGuildAdmin(w, r, guildID)
+	if !isAdmin {
+		return
+	}
+	if err != nil {
+		errResp(w, http.StatusNotFound, "feed not found")
+		return
+	}
+	if err := h.db.DeleteFeed(feed.ID); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to delete feed")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{
+		ActorID:  u.ID,
+		Action:   "feed.delete",
+		TargetID: feed.ID,
+		Details:  "removed feed " + feed.URL,
+	})
+	ok(w, map[string]string{"message": "feed deleted"})
+}
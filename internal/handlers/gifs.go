@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"chirm/internal/logging"
+)
+
+// ─── GIF search ──────────────────────────────────────────────────────────────
+//
+// The GIF picker searches Tenor or Giphy through the server, with the API
+// key an admin set in the settings, so the key stays on the server and the
+// provider never sees who is searching.  The GIFs themselves come back as
+// image-proxy URLs for the same reason, and a GIF sent in a message is shown
+// through the proxy like any other ![alt](url) image.  Results are cached
+// for gifCacheTTL, so popular searches cost one call to the provider.
+
+const (
+	gifProviderTenor = "tenor"
+	gifProviderGiphy = "giphy"
+
+	gifLimit        = 24
+	gifTimeout      = 8 * time.Second
+	gifCacheTTL     = time.Hour
+	gifCacheEntries = 1000
+	gifMaxQuery     = 100
+)
+
+// The providers' APIs.
+var (
+	tenorAPI = "https://tenor.googleapis.com/v2"
+	giphyAPI = "https://api.giphy.com/v1/gifs"
+)
+
+var gifClient = &http.Client{Timeout: gifTimeout}
+
+var errGIFProvider = errors.New("the GIF provider refused the request")
+
+// GIF is a search result.  URL is the GIF to send, at a size the image
+// proxy takes; Preview is a small version to show in the picker.
+type GIF struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Preview string `json:"preview"` // proxied
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+}
+
+// GIFResults is a page of search results.  Next, when set, is passed back
+// as pos for the page after.
+type GIFResults struct {
+	Provider string `json:"provider"`
+	Results  []GIF  `json:"results"`
+	Next     string `json:"next,omitempty"`
+}
+
+type gifCacheEntry struct {
+	results GIFResults
+	expires time.Time
+}
+
+// gifCache holds recent search results by provider, query and page.
+type gifCache struct {
+	mu      sync.Mutex
+	entries map[string]gifCacheEntry
+}
+
+var gifs = &gifCache{entries: map[string]gifCacheEntry{}}
+
+func (c *gifCache) get(key string) (GIFResults, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, found := c.entries[key]
+	if !found || time.Now().After(e.expires) {
+		return GIFResults{}, false
+	}
+	return e.results, true
+}
+
+func (c *gifCache) put(key string, results GIFResults) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= gifCacheEntries {
+		// Drop what's expired, or failing that whatever's soonest to.
+		oldest := ""
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			} else if oldest == "" || e.expires.Before(c.entries[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(c.entries) >= gifCacheEntries {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[key] = gifCacheEntry{results: results, expires: now.Add(gifCacheTTL)}
+}
+
+// gifConfig is the provider and key admins set, or "" if GIF search is off.
+func (h *Handler) gifConfig() (provider, key string) {
+	provider, _ = h.db.GetSetting("gif_provider")
+	key, _ = h.db.GetSetting("gif_api_key")
+	if key == "" || (provider != gifProviderTenor && provider != gifProviderGiphy) {
+		return "", ""
+	}
+	return provider, key
+}
+
+// getGIFJSON fetches a provider's API and decodes its answer into v.
+func getGIFJSON(endpoint string, params url.Values, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := gifClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w (HTTP %d)", errGIFProvider, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(v)
+}
+
+// searchTenor searches Tenor, or lists its featured GIFs for an empty query.
+func searchTenor(key, query, pos string) (GIFResults, error) {
+	type media struct {
+		URL  string `json:"url"`
+		Dims []int  `json:"dims"`
+	}
+	var resp struct {
+		Results []struct {
+			ID           string           `json:"id"`
+			Description  string           `json:"content_description"`
+			MediaFormats map[string]media `json:"media_formats"`
+		} `json:"results"`
+		Next string `json:"next"`
+	}
+	params := url.Values{
+		"key":           {key},
+		"client_key":    {"chirm"},
+		"limit":         {strconv.Itoa(gifLimit)},
+		"media_filter":  {"mediumgif,tinygif"},
+		"contentfilter": {"medium"},
+	}
+	endpoint := tenorAPI + "/featured"
+	if query != "" {
+		endpoint = tenorAPI + "/search"
+		params.Set("q", query)
+	}
+	if pos != "" {
+		params.Set("pos", pos)
+	}
+	if err := getGIFJSON(endpoint, params, &resp); err != nil {
+		return GIFResults{}, err
+	}
+	out := GIFResults{Provider: gifProviderTenor, Results: []GIF{}, Next: resp.Next}
+	for _, r := range resp.Results {
+		full, preview := r.MediaFormats["mediumgif"], r.MediaFormats["tinygif"]
+		if full.URL == "" || preview.URL == "" {
+			continue
+		}
+		g := GIF{ID: r.ID, Title: r.Description, URL: full.URL, Preview: proxiedImageURL(preview.URL)}
+		if len(full.Dims) == 2 {
+			g.Width, g.Height = full.Dims[0], full.Dims[1]
+		}
+		out.Results = append(out.Results, g)
+	}
+	return out, nil
+}
+
+// searchGiphy searches Giphy, or lists its trending GIFs for an empty query.
+func searchGiphy(key, query, pos string) (GIFResults, error) {
+	type image struct {
+		URL    string `json:"url"`
+		Width  string `json:"width"`
+		Height string `json:"height"`
+	}
+	var resp struct {
+		Data []struct {
+			ID     string           `json:"id"`
+			Title  string           `json:"title"`
+			Images map[string]image `json:"images"`
+		} `json:"data"`
+		Pagination struct {
+			TotalCount int `json:"total_count"`
+			Count      int `json:"count"`
+			Offset     int `json:"offset"`
+		} `json:"pagination"`
+	}
+	offset, _ := strconv.Atoi(pos)
+	params := url.Values{
+		"api_key": {key},
+		"limit":   {strconv.Itoa(gifLimit)},
+		"offset":  {strconv.Itoa(max(offset, 0))},
+		"rating":  {"pg-13"},
+	}
+	endpoint := giphyAPI + "/trending"
+	if query != "" {
+		endpoint = giphyAPI + "/search"
+		params.Set("q", query)
+	}
+	if err := getGIFJSON(endpoint, params, &resp); err != nil {
+		return GIFResults{}, err
+	}
+	out := GIFResults{Provider: gifProviderGiphy, Results: []GIF{}}
+	for _, d := range resp.Data {
+		// downsized is kept under 2 MB, well within the image proxy's limit.
+		full, preview := d.Images["downsized"], d.Images["fixed_width_small"]
+		if full.URL == "" || preview.URL == "" {
+			continue
+		}
+		g := GIF{ID: d.ID, Title: d.Title, URL: full.URL, Preview: proxiedImageURL(preview.URL)}
+		g.Width, _ = strconv.Atoi(full.Width)
+		g.Height, _ = strconv.Atoi(full.Height)
+		out.Results = append(out.Results, g)
+	}
+	if next := resp.Pagination.Offset + resp.Pagination.Count; resp.Pagination.Count > 0 && next < resp.Pagination.TotalCount {
+		out.Next = strconv.Itoa(next)
+	}
+	return out, nil
+}
+
+// SearchGIFs handles GET /api/gifs/search?q=...&pos=...: a page of GIFs
+// matching q, or the provider's popular ones when q is empty.
+func (h *Handler) SearchGIFs(w http.ResponseWriter, r *http.Request) {
+	provider, key := h.gifConfig()
+	if provider == "" {
+		errResp(w, http.StatusServiceUnavailable, "GIF search is not configured on this server")
+		return
+	}
+	query := strings.Join(strings.Fields(strings.ToLower(r.URL.Query().Get("q"))), " ")
+	if len(query) > gifMaxQuery {
+		errResp(w, http.StatusBadRequest, "search is too long")
+		return
+	}
+	pos := r.URL.Query().Get("pos")
+	cacheKey := provider + "\x00" + query + "\x00" + pos
+	if results, found := gifs.get(cacheKey); found {
+		ok(w, results)
+		return
+	}
+	var results GIFResults
+	var err error
+	if provider == gifProviderTenor {
+		results, err = searchTenor(key, query, pos)
+	} else {
+		results, err = searchGiphy(key, query, pos)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("gif search failed", "provider", provider, "err", err)
+		errResp(w, http.StatusBadGateway, "GIF search failed")
+		return
+	}
+	gifs.put(cacheKey, results)
+	ok(w, results)
+}
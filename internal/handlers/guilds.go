@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+)
+
+// ─── Guilds ──────────────────────────────────────────────────────────────────
+//
+// Channels, categories, roles and invites each belong to a guild.  Routes
+// that list them take ?guild= (the default guild if left out), routes that
+// make them take guild_id in the body, and the rest go by the guild of the
+// thing they're about.  Managing a guild takes Manage Server in that guild;
+// outside the default guild, someone who isn't a member gets 404 as though
+// the guild weren't there.
+
+// guildParam returns the guild a request names in ?guild=.
+func guildParam(r *http.Request) string {
+	if g := r.URL.Query().Get("guild"); g != "" {
+		return g
+	}
+	return db.DefaultGuild
+}
+
+// requireGuildMember returns the caller if they may see guildID, and
+// otherwise answers the request itself.
+func (h *Handler) requireGuildMember(w http.ResponseWriter, r *http.Request, guildID string) (*db.User, bool) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return nil, false
+	}
+	if guildID != db.DefaultGuild {
+		if _, err := h.db.GetGuild(guildID); err != nil || !h.db.CanAccessGuild(u, guildID) {
+			errResp(w, http.StatusNotFound, "guild not found")
+			return nil, false
+		}
+	}
+	return u, true
+}
+
+// requireGuildAdmin is requireAdmin for guildID: the caller needs Manage
+// Server there.
+func (h *Handler) requireGuildAdmin(w http.ResponseWriter, r *http.Request, guildID string) (*db.User, bool) {
+	u, isMember := h.requireGuildMember(w, r, guildID)
+	if !isMember {
+		return nil, false
+	}
+	if !h.db.HasGuildPermission(u, guildID, db.PermManageServer) {
+		errResp(w, http.StatusForbidden, "insufficient permissions")
+		return nil, false
+	}
+	return u, true
+}
+
+// visibleChannel returns channelID if u may see it, and otherwise answers
+// 404, the same as for a channel that doesn't exist.
+func (h *Handler) visibleChannel(w http.ResponseWriter, u *db.User, channelID string) (*db.Channel, bool) {
+	ch, err := h.db.GetChannelByID(channelID)
+	if err != nil || !h.db.CanAccessGuild(u, ch.GuildID) {
+		errResp(w, http.StatusNotFound, "channel not found")
+		return nil, false
+	}
+	return ch, true
+}
+
+// hasChannelPermission is HasPermission within channelID's guild, with the
+// channel's overrides applied.
+func (h *Handler) hasChannelPermission(u *db.User, channelID string, perm int) bool {
+	p := h.db.ChannelPermissions(u, channelID)
+	return p&db.PermAdministrator != 0 || p&perm != 0
+}
+
+// canSee reports whether userID may see channelID, so may follow what's
+// said there.
+func (h *Hub) canSee(channelID, userID string) bool {
+	u, err := h.db.GetUserByID(userID)
+	return err == nil && h.db.CanAccessGuild(u, h.db.ChannelGuild(channelID))
+}
+
+// BroadcastToGuild sends an event to the connected members of guildID; for
+// the default guild that's everyone.
+func (h *Hub) BroadcastToGuild(guildID string, event WSEvent) {
+	if guildID == db.DefaultGuild {
+		h.Broadcast(event)
+		return
+	}
+	ids, err := h.db.GuildMemberIDs(guildID)
+	if err != nil {
+		return
+	}
+	members := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		members[id] = true
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.clients {
+		if members[client.userID] && client.wants(data) {
+			select {
+			case client.send <- data:
+			default:
+			}
+		}
+	}
+}
+
+// ListGuilds returns the guilds the caller is in, the default guild first,
+// each with the caller's permissions there.
+func (h *Handler) ListGuilds(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	guilds, err := h.db.ListUserGuilds(u.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list guilds")
+		return
+	}
+	for i := range guilds {
+		guilds[i].Permissions = h.db.GuildPermissions(u, guilds[i].ID)
+	}
+	ok(w, guilds)
+}
+
+// GuildRequest is the body of POST /api/guilds and PUT /api/guilds/{id}.
+type GuildRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// CreateGuild makes a guild owned by the caller.  Only the instance's
+// admins (Manage Server in the default guild) may.
+func (h *Handler) CreateGuild(w http.ResponseWriter, r *http.Request) {
+	u, isAdmin := h.requireAdmin(w, r)
+	if !isAdmin {
+		return
+	}
+	var req GuildRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		errResp(w, http.StatusBadRequest, "name must be 1-100 characters")
+		return
+	}
+	g, err := h.db.CreateGuild(req.Name, strings.TrimSpace(req.Description), u.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to create guild")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "guild.create", TargetID: g.ID, Details: g.Name})
+	h.hub.SendToUser(u.ID, WSEvent{Type: "guild.new", Data: g})
+	created(w, g)
+}
+
+// UpdateGuild renames a guild or changes its description.  The default
+// guild's are the server_name and server_description settings.
+func (h *Handler) UpdateGuild(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	u, isAdmin := h.requireGuildAdmin(w, r, id)
+	if !isAdmin {
+		return
+	}
+	var req GuildRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	req.Description = strings.TrimSpace(req.Description)
+	if req.Name == "" || len(req.Name) > 100 {
+		errResp(w, http.StatusBadRequest, "name must be 1-100 characters")
+		return
+	}
+	var err error
+	if id == db.DefaultGuild {
+		if err = h.db.SetSetting("server_name", req.Name); err == nil {
+			err = h.db.SetSetting("server_description", req.Description)
+		}
+	} else {
+		err = h.db.UpdateGuild(id, req.Name, req.Description)
+	}
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to update guild")
+		return
+	}
+	g, _ := h.db.GetGuild(id)
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "guild.update", TargetID: id, Details: g.Name})
+	h.hub.BroadcastToGuild(id, WSEvent{Type: "guild.update", Data: g})
+	ok(w, g)
+}
+
+// DeleteGuild removes a guild with all its channels and messages.  Only
+// its owner or the instance owner may, and the default guild can't go.
+func (h *Handler) DeleteGuild(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	u, isMember := h.requireGuildMember(w, r, id)
+	if !isMember {
+		return
+	}
+	if id == db.DefaultGuild {
+		errResp(w, http.StatusBadRequest, "the default guild cannot be deleted")
+		return
+	}
+	g, _ := h.db.GetGuild(id)
+	if g.OwnerID != u.ID && !u.IsOwner {
+		errResp(w, http.StatusForbidden, "only the guild's owner can delete it")
+		return
+	}
+	// Tell the members while they can still be found.
+	h.hub.BroadcastToGuild(id, WSEvent{Type: "guild.delete", Data: map[string]string{"id": id}})
+	if err := h.db.DeleteGuild(id); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to delete guild")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "guild.delete", TargetID: id, Details: g.Name})
+	ok(w, map[string]string{"message": "deleted"})
+}
+
+// RemoveGuildMember takes someone out of a guild: themselves, to leave it,
+// or anyone else if the caller has Manage Server there.  The guild's owner
+// can't leave; they delete the guild instead.
+func (h *Handler) RemoveGuildMember(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID := chi.URLParam(r, "userId")
+	u, isMember := h.requireGuildMember(w, r, id)
+	if !isMember {
+		return
+	}
+	if id == db.DefaultGuild {
+		errResp(w, http.StatusBadRequest, "everyone is in the default guild")
+		return
+	}
+	if userID != u.ID && !h.db.HasGuildPermission(u, id, db.PermManageServer) {
+		errResp(w, http.StatusForbidden, "insufficient permissions")
+		return
+	}
+	g, _ := h.db.GetGuild(id)
+	if userID == g.OwnerID {
+		errResp(w, http.StatusBadRequest, "the guild's owner cannot leave it")
+		return
+	}
+	if !h.db.IsGuildMember(id, userID) {
+		errResp(w, http.StatusNotFound, "not a member")
+		return
+	}
+	if err := h.db.RemoveGuildMember(id, userID); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to remove member")
+		return
+	}
+	if userID != u.ID {
+		h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "guild.kick", TargetID: userID, Details: g.Name})
+	}
+	h.hub.SendToUser(userID, WSEvent{Type: "guild.delete", Data: map[string]string{"id": id}})
+	h.hub.BroadcastToGuild(id, WSEvent{Type: "member.leave", Data: map[string]string{"guild_id": id, "id": userID}})
+	ok(w, map[string]string{"message": "removed"})
+}
+
+// JoinGuild puts the caller in an invite's guild.
+func (h *Handler) JoinGuild(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	code := chi.URLParam(r, "code")
+	inv, err := h.db.GetInviteByCode(code)
+	if err != nil {
+		errResp(w, http.StatusNotFound, "invite not found")
+		return
+	}
+	if !h.db.IsInviteValid(inv) {
+		errResp(w, http.StatusForbidden, "invite is no longer valid")
+		return
+	}
+	g, err := h.db.GetGuild(inv.GuildID)
+	if err != nil {
+		errResp(w, http.StatusNotFound, "invite not found")
+		return
+	}
+	if !h.db.IsGuildMember(inv.GuildID, u.ID) {
+		if err := h.db.AddGuildMember(inv.GuildID, u.ID); err != nil {
+			errResp(w, http.StatusInternalServerError, "failed to join guild")
+			return
+		}
+		h.db.UseInvite(code)
+		h.db.RecordInviteJoin(inv, u.ID, false)
+		h.hub.BroadcastToGuild(inv.GuildID, WSEvent{
+			Type: "member.new",
+			Data: map[string]interface{}{
+				"guild_id": inv.GuildID,
+				"id":       u.ID,
+				"username": u.Username,
+				"avatar":   u.Avatar,
+				"is_owner": u.IsOwner,
+				"roles":    []interface{}{},
+			},
+		})
+		h.runAutomations(db.TriggerJoin, automationEvent{GuildID: inv.GuildID, User: u})
+	}
+	ok(w, g)
+}
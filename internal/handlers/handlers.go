@@ -1,26 +1,72 @@
 package handlers
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 
 	"chirm/internal/auth"
 	"chirm/internal/db"
+	"chirm/internal/jobs"
+	"chirm/internal/logging"
 	mw "chirm/internal/middleware"
+	"chirm/internal/storage"
 )
 
 type Handler struct {
-	db      *db.DB
-	auth    *auth.Service
-	hub     *Hub
-	dataDir string
+	db            *db.DB
+	auth          *auth.Service
+	hub           *Hub
+	dataDir       string
+	files         storage.Store   // uploads, avatars, emoji and sounds
+	video         *videoTools     // nil unless ffmpeg is available
+	transcode     *transcodeQueue // nil unless transcoding is enabled
+	scanner       Scanner         // nil unless upload scanning is set up
+	ice           ICEConfig
+	email         *emailNotifier // nil unless SMTP is configured
+	pushes        *pushCoalescer
+	previews      *previewCache
+	uploadMB      int64                   // per-file upload limit until admins set max_upload_mb
+	apiSpec       []byte                  // OpenAPI document, built from the router at startup
+	rateLimits    map[string]mw.RateLimit // each route class's limit until admins set one
+	ipFilter      *mw.IPFilter            // nil until SetIPFilter
+	discovery     DiscoveryConfig
+	jobs          *jobs.Scheduler                  // nil until ScheduleJobs
+	maintenance   atomic.Pointer[MaintenanceState] // never nil after New
+	readOnly      atomic.Pointer[ReadOnlyState]    // never nil after New
+	clientBuild   int                              // of the embedded web app; see SetClientFiles
+	clientHash    string
+	ircPort       string // the IRC gateway's, if it's listening; see ServeIRC
+	ircTLSPort    string
+	federation    string             // this server's URL when federation is on; see federation.go
+	federationKey ed25519.PrivateKey // signs what's sent to other servers
+	federationMu  sync.Mutex         // held while delivering the outbox
 }
 
 func New(database *db.DB, authSvc *auth.Service, hub *Hub, dataDir string) *Handler {
-	return &Handler{db: database, auth: authSvc, hub: hub, dataDir: dataDir}
+	h := &Handler{
+		db: database, auth: authSvc, hub: hub, dataDir: dataDir,
+		files:      storage.NewLocal(filepath.Join(dataDir, "uploads")),
+		pushes:     newPushCoalescer(database),
+		previews:   newPreviewCache(database),
+		uploadMB:   25,
+		rateLimits: make(map[string]mw.RateLimit),
+	}
+	database.SetAttachmentURLs(h.attachmentURL)
+	h.loadMaintenance()
+	h.loadReadOnly()
+	h.loadJWTKeys()
+	authSvc.OnUnknownKey(h.loadJWTKeys)
+	hub.setOnJWTKeys(h.loadJWTKeys)
+	authSvc.OnBotToken(h.botClaims)
+	return h
 }
 
 // makeUpgrader builds a WebSocket upgrader that validates the Origin header.
@@ -86,6 +132,20 @@ func (h *Handler) requireAdmin(w http.ResponseWriter, r *http.Request) (*db.User
 	return u, true
 }
 
+// requireOwner is like requireAdmin, for owners only.
+func (h *Handler) requireOwner(w http.ResponseWriter, r *http.Request) (*db.User, bool) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return nil, false
+	}
+	if !u.IsOwner {
+		errResp(w, http.StatusForbidden, "owner only")
+		return nil, false
+	}
+	return u, true
+}
+
 // --- WebSocket handler ---
 
 func (h *Handler) WebSocket(w http.ResponseWriter, r *http.Request) {
@@ -94,6 +154,15 @@ func (h *Handler) WebSocket(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
+	// A bot says which events it wants; see bots.go.
+	var intents int
+	if claims.Bot {
+		var valid bool
+		if intents, valid = parseIntents(r.URL.Query().Get("intents")); !valid {
+			errResp(w, http.StatusBadRequest, "intents must list some of: guilds, members, messages, reactions, typing, voice")
+			return
+		}
+	}
 
 	upgrader := makeUpgrader(os.Getenv("ALLOWED_ORIGIN"))
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -102,20 +171,42 @@ func (h *Handler) WebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 
 	client := &Client{
-		hub:    h.hub,
-		conn:   conn,
-		send:   make(chan []byte, 256),
-		userID: claims.UserID,
+		hub:     h.hub,
+		conn:    conn,
+		send:    make(chan []byte, 256),
+		userID:  claims.UserID,
+		ip:      mw.RemoteIP(r),
+		log:     logging.FromContext(r.Context()),
+		bot:     claims.Bot,
+		intents: intents,
 	}
+	client.log.Debug("ws connected")
+	h.db.RecordActivity(claims.UserID, time.Now())
 	h.hub.register <- client
+	if client.bot {
+		if u, err := h.db.GetUserByID(claims.UserID); err == nil {
+			client.sendEvent(readyEvent(u, intents))
+		}
+	}
 
 	go client.writePump()
 	go client.readPump()
 }
 
-// VoiceRooms returns a snapshot of who is currently in each voice room.
-// Used by clients on page load to populate sidebar participant lists.
+// VoiceRooms returns a snapshot of who is currently in each voice room the
+// caller can see.  Used by clients on page load to populate sidebar
+// participant lists.
 func (h *Handler) VoiceRooms(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
 	snapshot := h.hub.GetVoiceRoomSnapshot()
+	for channelID := range snapshot {
+		if !h.db.CanAccessGuild(u, h.db.ChannelGuild(channelID)) {
+			delete(snapshot, channelID)
+		}
+	}
 	ok(w, map[string]interface{}{"rooms": snapshot})
 }
@@ -2,10 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
+	"net"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+
+	"chirm/internal/db"
+	"chirm/internal/sfu"
 )
 
 // WSEvent is the envelope for all WebSocket messages
@@ -20,8 +26,24 @@ type Client struct {
 	conn      *websocket.Conn
 	send      chan []byte
 	userID    string
-	channelID string // currently viewed text channel
+	ip        net.IP       // the client's address when it connected
+	channelID string       // currently viewed text channel
+	log       *slog.Logger // with the request ID of the connection
 	mu        sync.Mutex
+	speaking  speakingState // see voicespeaking.go; guarded by mu
+	bot       bool          // connected with a bot token
+	intents   int           // a bot's; see bots.go
+	irc       *ircConn      // set, and conn nil, for IRC clients; see irc.go
+}
+
+// close closes c's connection, whatever kind it is; its read loop then
+// unregisters it.
+func (c *Client) close() {
+	if c.irc != nil {
+		c.irc.conn.Close()
+		return
+	}
+	c.conn.Close()
 }
 
 // Hub manages all active WebSocket clients
@@ -36,16 +58,69 @@ type Hub struct {
 	voiceRooms    map[string]map[*Client]bool
 	voiceRoomsMu  sync.RWMutex
 
+	// voiceMod: userID → moderator-imposed mute/deafen (see voicemod.go)
+	voiceMod   map[string]voiceModState
+	voiceModMu sync.RWMutex
+
+	// stages: channelID → speakers/raised hands (see stage.go)
+	stages   map[string]*stageState
+	stagesMu sync.Mutex
+
+	// held: channelID → userID → grace timer for dropped voice clients
+	// (see voicegrace.go)
+	voiceGrace time.Duration
+	held       map[string]map[string]*time.Timer
+	heldMu     sync.Mutex
+
+	// recordings: channelID → ID of the recording in progress
+	// (see recordings.go)
+	recordDir    string
+	recordings   map[string]string
+	recordingsMu sync.Mutex
+
+	// broadcasts: channelID → user who is live ("" if nobody) for broadcast
+	// rooms with anyone in them (see broadcast.go)
+	broadcasts   map[string]string
+	broadcastsMu sync.Mutex
+
+	// ringing: callID → timer that marks an unanswered call missed
+	// (see calls.go)
+	ringing map[string]*time.Timer
+	ringMu  sync.Mutex
+
+	// onJWTKeys reloads the token signing keys when another instance adds
+	// or retires one (see jwtkeys.go)
+	onJWTKeys   func()
+	onJWTKeysMu sync.Mutex
+
+	// per-user rate limits for soundboard clips and in-call chat
+	soundLimits *userLimiter
+	chatLimits  *userLimiter
+
 	allowedOrigin string // used by WS upgrader origin check
+
+	db      *db.DB
+	cluster *cluster    // nil unless running as one of several instances
+	sfu     *sfu.Server // nil in mesh mode (the default)
 }
 
-func NewHub(allowedOrigin string) *Hub {
+func NewHub(database *db.DB, allowedOrigin string) *Hub {
 	return &Hub{
+		db:            database,
 		clients:       make(map[*Client]bool),
 		broadcast:     make(chan []byte, 256),
 		register:      make(chan *Client),
 		unregister:    make(chan *Client),
 		voiceRooms:    make(map[string]map[*Client]bool),
+		voiceMod:      make(map[string]voiceModState),
+		stages:        make(map[string]*stageState),
+		voiceGrace:    DefaultVoiceReconnectGrace,
+		held:          make(map[string]map[string]*time.Timer),
+		recordings:    make(map[string]string),
+		soundLimits:   newUserLimiter(soundInterval, soundBurst),
+		chatLimits:    newUserLimiter(voiceChatInterval, voiceChatBurst),
+		ringing:       make(map[string]*time.Timer),
+		broadcasts:    make(map[string]string),
 		allowedOrigin: allowedOrigin,
 	}
 }
@@ -57,6 +132,7 @@ func (h *Hub) Run() {
 			h.mu.Lock()
 			h.clients[client] = true
 			h.mu.Unlock()
+			h.recordConnect(client.userID)
 
 		case client := <-h.unregister:
 			h.mu.Lock()
@@ -65,7 +141,9 @@ func (h *Hub) Run() {
 				close(client.send)
 			}
 			h.mu.Unlock()
+			h.recordDisconnect(client.userID)
 			h.leaveAllVoiceRooms(client)
+			h.dropCallsAfterDisconnect(client.userID)
 
 		case message := <-h.broadcast:
 			// Fix #6: collect dead clients under RLock, then evict under write lock
@@ -73,6 +151,9 @@ func (h *Hub) Run() {
 			h.mu.RLock()
 			var dead []*Client
 			for client := range h.clients {
+				if !client.wants(message) {
+					continue
+				}
 				select {
 				case client.send <- message:
 				default:
@@ -98,7 +179,7 @@ func (h *Hub) Run() {
 func (h *Hub) Broadcast(event WSEvent) {
 	data, err := json.Marshal(event)
 	if err != nil {
-		log.Println("ws marshal error:", err)
+		slog.Error("ws marshal", "err", err)
 		return
 	}
 	h.broadcast <- data
@@ -116,6 +197,10 @@ func (h *Hub) BroadcastToChannel(channelID string, event WSEvent) {
 		client.mu.Lock()
 		inChannel := client.channelID == channelID
 		client.mu.Unlock()
+		if client.bot || client.irc != nil {
+			// Bots and IRC clients get every channel they can see, not just one.
+			inChannel = client.wants(data) && h.canSee(channelID, client.userID)
+		}
 		if inChannel {
 			select {
 			case client.send <- data:
@@ -134,7 +219,7 @@ func (h *Hub) SendToUser(targetUserID string, event WSEvent) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 	for client := range h.clients {
-		if client.userID == targetUserID {
+		if client.userID == targetUserID && client.wants(data) {
 			select {
 			case client.send <- data:
 			default:
@@ -156,7 +241,7 @@ func (h *Hub) BroadcastToVoiceRoom(channelID string, event WSEvent, exclude *Cli
 		return
 	}
 	for client := range room {
-		if client == exclude {
+		if client == exclude || !client.wants(data) {
 			continue
 		}
 		select {
@@ -181,6 +266,88 @@ func (h *Hub) joinVoiceRoom(channelID string, client *Client) []string {
 	return existing
 }
 
+// onVoiceLeave releases a user's per-room resources once their last local
+// client has left the room (another tab may still be connected).
+func (h *Hub) onVoiceLeave(channelID, userID string) {
+	if h.userInLocalVoiceRoom(channelID, userID) {
+		return
+	}
+	h.recordVoiceLeave(channelID, userID)
+	if h.sfu != nil {
+		h.sfu.Leave(channelID, userID)
+		h.stopRecordingIfEmpty(channelID)
+	}
+	if !h.isRemoteVoiceMember(channelID, userID) {
+		go h.postVoiceActivity(channelID, userID, false)
+	}
+	if h.stageLeave(channelID, userID) {
+		h.broadcastStage(channelID)
+		h.dropStageIfEmpty(channelID)
+	}
+	h.broadcastLeave(channelID, userID)
+}
+
+// EnableSFU switches voice rooms from mesh to server-forwarded media.
+func (h *Hub) EnableSFU(cfg sfu.Config) error {
+	cfg.AllowTrack = func(channelID, userID, kind string) bool {
+		if streamer, ok := h.broadcastStreamer(channelID); ok {
+			return kind == "audio" && streamer == userID
+		}
+		perms := h.voicePermissions(channelID, userID)
+		if kind == "audio" {
+			return perms&db.PermSpeak != 0
+		}
+		return perms&(db.PermVideo|db.PermScreenShare) != 0
+	}
+	cfg.Muted = func(channelID, userID string) bool {
+		return h.voiceModeration(userID).Muted || h.listenOnly(channelID, userID)
+	}
+	cfg.Deafened = func(channelID, userID string) bool { return h.voiceModeration(userID).Deafened }
+	cfg.Quality = h.sfuQuality
+	s, err := sfu.New(cfg, func(userID, eventType string, data map[string]interface{}) {
+		h.SendToUser(userID, WSEvent{Type: eventType, Data: data})
+	})
+	if err != nil {
+		return err
+	}
+	h.sfu = s
+	return nil
+}
+
+// voicePermissions returns userID's effective permissions in a voice channel.
+func (h *Hub) voicePermissions(channelID, userID string) int {
+	u, err := h.db.GetUserByID(userID)
+	if err != nil {
+		return 0
+	}
+	return h.db.ChannelPermissions(u, channelID)
+}
+
+// canModerateVoice reports whether userID may mute others and run stages
+// in channelID.
+func (h *Hub) canModerateVoice(channelID, userID string) bool {
+	p := h.voicePermissions(channelID, userID)
+	return p&(db.PermAdministrator|db.PermMuteMembers) != 0
+}
+
+// listenOnly reports whether userID may only listen in channelID: a stage
+// audience member or a broadcast listener.
+func (h *Hub) listenOnly(channelID, userID string) bool {
+	return h.stageAudience(channelID, userID) || h.broadcastListener(channelID, userID)
+}
+
+// userInLocalVoiceRoom reports whether any local client of userID is in channelID.
+func (h *Hub) userInLocalVoiceRoom(channelID, userID string) bool {
+	h.voiceRoomsMu.RLock()
+	defer h.voiceRoomsMu.RUnlock()
+	for c := range h.voiceRooms[channelID] {
+		if c.userID == userID {
+			return true
+		}
+	}
+	return false
+}
+
 // leaveVoiceRoom removes a client from a specific voice room
 func (h *Hub) leaveVoiceRoom(channelID string, client *Client) bool {
 	h.voiceRoomsMu.Lock()
@@ -215,54 +382,69 @@ func (h *Hub) leaveAllVoiceRooms(client *Client) {
 	h.voiceRoomsMu.Unlock()
 
 	for _, channelID := range affected {
-		evt := WSEvent{
-			Type: "voice.left",
-			Data: map[string]string{
-				"channel_id": channelID,
-				"user_id":    client.userID,
-			},
+		if !h.userInLocalVoiceRoom(channelID, client.userID) && h.holdVoiceSlot(channelID, client.userID) {
+			evt := WSEvent{
+				Type: "voice.reconnecting",
+				Data: map[string]string{
+					"channel_id": channelID,
+					"user_id":    client.userID,
+				},
+			}
+			h.relayToVoiceRoom(channelID, evt, nil)
+			h.relayToAll(evt)
+			continue
 		}
-		h.BroadcastToVoiceRoom(channelID, evt, nil)
-		h.Broadcast(evt)
+		h.finishVoiceLeave(channelID, client.userID)
 	}
 }
 
 // AreInSameVoiceRoom returns true if both userIDs have active clients in channelID.
-// Fix #13: Used to gate WebRTC signaling relay.
+// Fix #13: Used to gate WebRTC signaling relay.  In cluster mode either user
+// may be connected to a different instance.
 func (h *Hub) AreInSameVoiceRoom(channelID, userA, userB string) bool {
-	h.voiceRoomsMu.RLock()
-	defer h.voiceRoomsMu.RUnlock()
-	room, ok := h.voiceRooms[channelID]
-	if !ok {
+	foundA := h.userInLocalVoiceRoom(channelID, userA) || h.isRemoteVoiceMember(channelID, userA)
+	if !foundA {
 		return false
 	}
-	var foundA, foundB bool
-	for c := range room {
-		if c.userID == userA {
-			foundA = true
-		}
-		if c.userID == userB {
-			foundB = true
-		}
-	}
-	return foundA && foundB
+	return h.userInLocalVoiceRoom(channelID, userB) || h.isRemoteVoiceMember(channelID, userB)
 }
 
 // GetVoiceRoomSnapshot returns a map of channelID → []userID for all active rooms
 func (h *Hub) GetVoiceRoomSnapshot() map[string][]string {
+	out := h.remoteVoiceParticipants()
 	h.voiceRoomsMu.RLock()
 	defer h.voiceRoomsMu.RUnlock()
-	out := make(map[string][]string)
 	for channelID, room := range h.voiceRooms {
-		uids := make([]string, 0, len(room))
 		for c := range room {
-			uids = append(uids, c.userID)
+			out[channelID] = appendUnique(out[channelID], c.userID)
+		}
+	}
+	for channelID, users := range h.heldVoiceSlots() {
+		for _, uid := range users {
+			out[channelID] = appendUnique(out[channelID], uid)
 		}
-		out[channelID] = uids
 	}
 	return out
 }
 
+func containsString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(list []string, v string) []string {
+	for _, s := range list {
+		if s == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
+
 func (c *Client) SetChannel(channelID string) {
 	c.mu.Lock()
 	c.channelID = channelID
@@ -293,6 +475,9 @@ func (c *Client) readPump() {
 	for {
 		_, msg, err := c.conn.ReadMessage()
 		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				c.log.Debug("ws closed", "err", err)
+			}
 			break
 		}
 		var evt rawClientMessage
@@ -310,7 +495,7 @@ func (c *Client) handleMessage(evt rawClientMessage) {
 		var d struct {
 			ChannelID string `json:"channel_id"`
 		}
-		if json.Unmarshal(evt.Data, &d) == nil {
+		if json.Unmarshal(evt.Data, &d) == nil && c.hub.canSee(d.ChannelID, c.userID) {
 			c.SetChannel(d.ChannelID)
 		}
 
@@ -331,33 +516,114 @@ func (c *Client) handleMessage(evt rawClientMessage) {
 	case "voice.join":
 		var d struct {
 			ChannelID string `json:"channel_id"`
+			Resume    bool   `json:"resume"` // rejoining after a dropped connection
 		}
 		if json.Unmarshal(evt.Data, &d) != nil || d.ChannelID == "" {
 			return
 		}
+		perms := c.hub.voicePermissions(d.ChannelID, c.userID)
+		if perms&db.PermConnect == 0 {
+			c.sendEvent(WSEvent{
+				Type: "voice.error",
+				Data: map[string]string{
+					"channel_id": d.ChannelID,
+					"error":      "You don't have permission to join this voice channel",
+				},
+			})
+			return
+		}
+		broadcast := c.hub.isBroadcastChannel(d.ChannelID)
+		if broadcast && c.hub.sfu == nil {
+			c.sendEvent(WSEvent{
+				Type: "voice.error",
+				Data: map[string]string{
+					"channel_id": d.ChannelID,
+					"error":      "Broadcast channels need the server to forward media (VOICE_SFU=1)",
+				},
+			})
+			return
+		}
+		resumed := false
+		if c.hub.resumeVoiceSlot(d.ChannelID, c.userID) {
+			if d.Resume {
+				resumed = true
+			} else {
+				// A fresh client has none of the old peer connections.
+				c.hub.finishVoiceLeave(d.ChannelID, c.userID)
+			}
+		}
+		stage := c.hub.isStageChannel(d.ChannelID)
+		promoted := false
+		if stage {
+			promoted = c.hub.canModerateVoice(d.ChannelID, c.userID)
+			c.hub.stageJoin(d.ChannelID, c.userID, promoted)
+			if c.hub.stageAudience(d.ChannelID, c.userID) {
+				perms &^= db.PermSpeak | db.PermVideo | db.PermScreenShare
+			}
+		}
+		if broadcast {
+			c.hub.broadcastJoin(d.ChannelID)
+			perms &^= db.PermVideo | db.PermScreenShare
+			if c.hub.broadcastListener(d.ChannelID, c.userID) {
+				perms &^= db.PermSpeak
+			}
+		}
 		existing := c.hub.joinVoiceRoom(d.ChannelID, c)
+		for _, uid := range c.hub.remoteVoiceParticipants()[d.ChannelID] {
+			existing = appendUnique(existing, uid)
+		}
+		if !resumed && !containsString(existing, c.userID) {
+			go c.hub.postVoiceActivity(d.ChannelID, c.userID, true)
+		}
+		c.hub.recordVoiceJoin(d.ChannelID, c.userID)
 
 		// Tell joiner who's already present
-		c.sendEvent(WSEvent{
-			Type: "voice.room_state",
-			Data: map[string]interface{}{
-				"channel_id":   d.ChannelID,
-				"participants": existing,
+		roomState := map[string]interface{}{
+			"channel_id":   d.ChannelID,
+			"participants": existing,
+			"resumed":      resumed,
+			"sfu":          c.hub.sfu != nil,
+			"permissions": map[string]bool{
+				"speak":        perms&db.PermSpeak != 0,
+				"video":        perms&db.PermVideo != 0,
+				"screen_share": perms&db.PermScreenShare != 0,
+				"broadcast":    broadcast && perms&db.PermBroadcast != 0,
 			},
-		})
+			"moderation":          c.hub.roomModeration(append(existing, c.userID)),
+			"recording":           c.hub.activeRecording(d.ChannelID) != "",
+			"recording_available": c.hub.recordingAvailable(),
+			"quality":             c.hub.roomQuality(d.ChannelID),
+		}
+		if stage {
+			roomState["stage"] = c.hub.stageSnapshot(d.ChannelID)
+		}
+		if broadcast {
+			roomState["broadcast"] = c.hub.broadcastSnapshot(d.ChannelID)
+		}
+		c.sendEvent(WSEvent{Type: "voice.room_state", Data: roomState})
 
 		// Notify others in the room
-		c.hub.BroadcastToVoiceRoom(d.ChannelID, WSEvent{
-			Type: "voice.joined",
+		joinType := "voice.joined"
+		if resumed {
+			joinType = "voice.resumed"
+		}
+		c.hub.relayToVoiceRoom(d.ChannelID, WSEvent{
+			Type: joinType,
 			Data: map[string]string{
 				"channel_id": d.ChannelID,
 				"user_id":    c.userID,
 			},
 		}, c)
+		if st := c.hub.voiceModeration(c.userID); st != (voiceModState{}) && !resumed {
+			c.hub.relayToVoiceRoom(d.ChannelID, moderationEvent(d.ChannelID, c.userID, st), c)
+		}
+		if promoted {
+			c.hub.broadcastStage(d.ChannelID)
+		}
 
 		// Broadcast to whole server for sidebar participant count
-		c.hub.Broadcast(WSEvent{
-			Type: "voice.joined",
+		c.hub.relayToAll(WSEvent{
+			Type: joinType,
 			Data: map[string]string{
 				"channel_id": d.ChannelID,
 				"user_id":    c.userID,
@@ -372,16 +638,47 @@ func (c *Client) handleMessage(evt rawClientMessage) {
 			return
 		}
 		if c.hub.leaveVoiceRoom(d.ChannelID, c) {
-			evt := WSEvent{
-				Type: "voice.left",
-				Data: map[string]string{
-					"channel_id": d.ChannelID,
-					"user_id":    c.userID,
-				},
-			}
-			c.hub.BroadcastToVoiceRoom(d.ChannelID, evt, nil)
-			c.hub.Broadcast(evt)
+			c.hub.finishVoiceLeave(d.ChannelID, c.userID)
+		}
+
+	case "voice.speaking":
+		var d struct {
+			ChannelID string `json:"channel_id"`
+			Speaking  bool   `json:"speaking"`
+		}
+		if json.Unmarshal(evt.Data, &d) != nil || d.ChannelID == "" {
+			return
+		}
+		if !c.hub.userInLocalVoiceRoom(d.ChannelID, c.userID) {
+			return
+		}
+		if d.Speaking && (c.hub.voiceModeration(c.userID).Muted || c.hub.listenOnly(d.ChannelID, c.userID)) {
+			return
+		}
+		c.reportSpeaking(d.ChannelID, d.Speaking)
+
+	case "call.start", "call.accept", "call.decline", "call.hangup", "call.signal":
+		c.handleCall(evt.Type, evt.Data)
+
+	case "voice.sound":
+		var d struct {
+			ChannelID string `json:"channel_id"`
+			SoundID   string `json:"sound_id"`
+		}
+		if json.Unmarshal(evt.Data, &d) != nil || d.ChannelID == "" || d.SoundID == "" {
+			return
+		}
+		c.playSound(d.ChannelID, d.SoundID)
+
+	case "voice.chat":
+		var d struct {
+			ChannelID string `json:"channel_id"`
+			Content   string `json:"content"`
+		}
+		if json.Unmarshal(evt.Data, &d) != nil || d.ChannelID == "" {
+			return
 		}
+		c.sendVoiceChat(d.ChannelID, d.Content)
 
 	// WebRTC signaling relay — server routes to the target peer only if
 	// Fix #13: both sender and target are verified members of the same voice room.
@@ -398,7 +695,11 @@ func (c *Client) handleMessage(evt rawClientMessage) {
 		if !c.hub.AreInSameVoiceRoom(d.ChannelID, c.userID, d.TargetUserID) {
 			return
 		}
-		c.hub.SendToUser(d.TargetUserID, WSEvent{
+		// A server-muted user can't (re)negotiate new media.
+		if evt.Type == "voice.offer" && c.hub.voiceModeration(c.userID).Muted {
+			return
+		}
+		c.hub.relayToUser(d.TargetUserID, WSEvent{
 			Type: evt.Type,
 			Data: map[string]interface{}{
 				"channel_id":   d.ChannelID,
@@ -407,6 +708,53 @@ func (c *Client) handleMessage(evt rawClientMessage) {
 			},
 		})
 
+	// Stage audience members raising or lowering their hand.
+	case "stage.hand":
+		var d struct {
+			ChannelID string `json:"channel_id"`
+			Raised    bool   `json:"raised"`
+		}
+		if json.Unmarshal(evt.Data, &d) != nil || d.ChannelID == "" {
+			return
+		}
+		if c.hub.userInLocalVoiceRoom(d.ChannelID, c.userID) {
+			c.hub.setStageHand(d.ChannelID, c.userID, d.Raised)
+		}
+
+	// SFU signaling — the peer is the server itself rather than another
+	// user.  Handled inline so offers, answers and candidates from one
+	// client are applied in the order they were sent.
+	case "voice.sfu.offer", "voice.sfu.answer", "voice.sfu.ice":
+		if c.hub.sfu == nil {
+			return
+		}
+		var d struct {
+			ChannelID string          `json:"channel_id"`
+			Payload   json.RawMessage `json:"payload"`
+		}
+		if json.Unmarshal(evt.Data, &d) != nil || d.ChannelID == "" || len(d.Payload) == 0 {
+			return
+		}
+		if !c.hub.userInLocalVoiceRoom(d.ChannelID, c.userID) {
+			return
+		}
+		var err error
+		if evt.Type == "voice.sfu.ice" {
+			err = c.hub.sfu.HandleCandidate(d.ChannelID, c.userID, d.Payload)
+		} else {
+			var sdp webrtc.SessionDescription
+			if err = json.Unmarshal(d.Payload, &sdp); err == nil {
+				if evt.Type == "voice.sfu.offer" {
+					err = c.hub.sfu.HandleOffer(d.ChannelID, c.userID, sdp)
+				} else {
+					err = c.hub.sfu.HandleAnswer(d.ChannelID, c.userID, sdp)
+				}
+			}
+		}
+		if err != nil {
+			c.log.Warn("sfu signaling", "event", evt.Type, "err", err)
+		}
+
 	// Broadcast camera/mic state to everyone else in the room so they can
 	// show/hide the video tile vs avatar without relying on track detection.
 	case "voice.media_state":
@@ -418,7 +766,17 @@ func (c *Client) handleMessage(evt rawClientMessage) {
 		if json.Unmarshal(evt.Data, &d) != nil || d.ChannelID == "" {
 			return
 		}
-		c.hub.BroadcastToVoiceRoom(d.ChannelID, WSEvent{
+		if !c.hub.userInLocalVoiceRoom(d.ChannelID, c.userID) || c.hub.voiceModeration(c.userID).Muted {
+			return
+		}
+		// Never advertise media the user isn't allowed to send.
+		perms := c.hub.voicePermissions(d.ChannelID, c.userID)
+		if c.hub.listenOnly(d.ChannelID, c.userID) || c.hub.isBroadcastChannel(d.ChannelID) {
+			perms = 0
+		}
+		d.CamEnabled = d.CamEnabled && perms&db.PermVideo != 0
+		d.ScreenSharing = d.ScreenSharing && perms&db.PermScreenShare != 0
+		c.hub.relayToVoiceRoom(d.ChannelID, WSEvent{
 			Type: "voice.media_state",
 			Data: map[string]interface{}{
 				"channel_id":     d.ChannelID,
@@ -432,7 +790,7 @@ func (c *Client) handleMessage(evt rawClientMessage) {
 
 func (c *Client) sendEvent(event WSEvent) {
 	data, err := json.Marshal(event)
-	if err != nil {
+	if err != nil || !c.wants(data) {
 		return
 	}
 	select {
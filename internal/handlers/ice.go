@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	mw "chirm/internal/middleware"
+	"chirm/internal/turn"
+)
+
+// ICEConfig lists the STUN/TURN servers handed to voice clients.
+type ICEConfig struct {
+	STUNURLs   []string
+	TURNURLs   []string
+	TURNSecret string        // shared secret for time-limited TURN credentials
+	TURNTTL    time.Duration // how long issued credentials stay valid
+}
+
+type iceServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// SetICEConfig sets the servers returned by GetICEServers.
+func (h *Handler) SetICEConfig(cfg ICEConfig) {
+	h.ice = cfg
+}
+
+// GetICEServers returns the RTCPeerConnection iceServers list for the caller,
+// with freshly minted TURN credentials when a relay is configured.
+func (h *Handler) GetICEServers(w http.ResponseWriter, r *http.Request) {
+	claims := mw.GetClaims(r)
+	servers := []iceServer{}
+	if len(h.ice.STUNURLs) > 0 {
+		servers = append(servers, iceServer{URLs: h.ice.STUNURLs})
+	}
+	ttl := 0
+	if len(h.ice.TURNURLs) > 0 && h.ice.TURNSecret != "" {
+		user, pass := turn.Credentials(h.ice.TURNSecret, claims.UserID, h.ice.TURNTTL)
+		servers = append(servers, iceServer{URLs: h.ice.TURNURLs, Username: user, Credential: pass})
+		ttl = int(h.ice.TURNTTL.Seconds())
+	}
+	ok(w, map[string]interface{}{"ice_servers": servers, "ttl": ttl})
+}
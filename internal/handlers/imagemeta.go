@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// ─── Image metadata stripping ────────────────────────────────────────────────
+//
+// Photos straight off a phone carry EXIF: GPS position, device make and
+// serial, capture time.  Unless the "strip_image_metadata" setting is "0",
+// uploaded JPEG, PNG and WebP images have their metadata blocks removed
+// before they're stored.  The pixels are copied untouched, and colour
+// profiles are kept.  A JPEG's orientation survives as a bare EXIF block so
+// the photo still shows the right way up.
+
+// stripsImageMetadata reports whether uploads should lose their metadata.
+func (h *Handler) stripsImageMetadata() bool {
+	v, _ := h.db.GetSetting("strip_image_metadata")
+	return v != "0"
+}
+
+// stripUploadMetadata reads an uploaded image and returns it without
+// metadata, if it's a type that can carry any and the server strips it.
+// Otherwise src is returned as is.
+func (h *Handler) stripUploadMetadata(src io.ReadSeeker, size int64, mimeType string) (io.ReadSeeker, int64, error) {
+	if !h.stripsImageMetadata() {
+		return src, size, nil
+	}
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/webp":
+	default:
+		return src, size, nil
+	}
+	src.Seek(0, io.SeekStart)
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	data = stripImageMetadata(data, mimeType)
+	return bytes.NewReader(data), int64(len(data)), nil
+}
+
+// stripImageMetadata removes metadata from an encoded image.  Data it can't
+// parse is returned unchanged.
+func stripImageMetadata(data []byte, mimeType string) []byte {
+	var out []byte
+	switch mimeType {
+	case "image/jpeg":
+		out = stripJPEG(data)
+	case "image/png":
+		out = stripPNG(data)
+	case "image/webp":
+		out = stripWebP(data)
+	}
+	if out == nil {
+		return data
+	}
+	return out
+}
+
+// stripJPEG drops APPn segments other than JFIF (APP0), ICC profiles (APP2)
+// and Adobe colour info (APP14), plus comments.  Everything from the start
+// of scan on is copied as is.
+func stripJPEG(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+	var kept bytes.Buffer
+	orientation := 0
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil
+		}
+		marker := data[i+1]
+		if marker == 0xFF { // fill byte
+			i++
+			continue
+		}
+		if marker == 0xDA { // start of scan: the rest is image data
+			out := bytes.NewBuffer(make([]byte, 0, len(data)))
+			out.Write(data[:2])
+			if orientation > 1 { // EXIF belongs straight after SOI
+				out.Write(orientationEXIF(orientation))
+			}
+			out.Write(kept.Bytes())
+			out.Write(data[i:])
+			return out.Bytes()
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) {
+			return nil
+		}
+		seg := data[i:end]
+		switch {
+		case marker == 0xE1:
+			if o := exifOrientation(seg[4:]); o != 0 {
+				orientation = o
+			}
+		case marker == 0xFE, marker >= 0xE1 && marker <= 0xEF && marker != 0xE2 && marker != 0xEE:
+		default:
+			kept.Write(seg)
+		}
+		i = end
+	}
+}
+
+// orientationEXIF builds an APP1 segment holding only an orientation tag.
+func orientationEXIF(orientation int) []byte {
+	tiff := []byte{
+		'M', 'M', 0, 42, 0, 0, 0, 8, // big-endian header, IFD0 at offset 8
+		0, 1, // one entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0, // Orientation, SHORT, 1
+		0, 0, 0, 0, // no next IFD
+	}
+	body := append([]byte("Exif\x00\x00"), tiff...)
+	seg := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(body)+2))
+	return append(seg, body...)
+}
+
+// pngMetadataChunks are the ancillary chunks that hold text, EXIF or
+// timestamps.
+var pngMetadataChunks = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true}
+
+func stripPNG(data []byte) []byte {
+	const sig = "\x89PNG\r\n\x1a\n"
+	if len(data) < len(sig) || string(data[:len(sig)]) != sig {
+		return nil
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.WriteString(sig)
+	for i := len(sig); i < len(data); {
+		if i+12 > len(data) {
+			return nil
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i {
+			return nil
+		}
+		if !pngMetadataChunks[string(data[i+4:i+8])] {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	return out.Bytes()
+}
+
+// stripWebP drops the EXIF and XMP chunks and clears their flags in the
+// extended header.
+func stripWebP(data []byte) []byte {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil
+		}
+		n := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + n + n&1 // chunks are padded to even sizes
+		if end > len(data) || end < i {
+			return nil
+		}
+		chunk := data[i:end]
+		switch string(chunk[:4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			if len(chunk) < 9 {
+				return nil
+			}
+			chunk = append([]byte(nil), chunk...)
+			chunk[8] &^= 0x08 | 0x04 // EXIF and XMP present
+			out.Write(chunk)
+		default:
+			out.Write(chunk)
+		}
+		i = end
+	}
+	b := out.Bytes()
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	return b
+}
@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ─── Image proxy ─────────────────────────────────────────────────────────────
+//
+// Link-preview images, favicons and images embedded with ![alt](url) are
+// loaded through /api/image-proxy rather than straight from their hosts, so
+// a third party can't learn who reads a channel from the requests.  Images
+// are fetched from public addresses only, checked to really be a raster
+// image, limited in size and cached on disk under DATA_DIR/imgcache.
+//
+// Link previews' images and favicons are put in the cache when the preview
+// is made, and a cached image is served as it is when its site can't be
+// reached, so previews outlive the sites they point at.  The cache is kept
+// under proxyCacheBytes by removing the images fetched longest ago.
+
+const (
+	proxyImageMaxBytes = 8 << 20
+	proxyImageTTL      = 24 * time.Hour // then refetched, if the site is up
+	proxyFailureTTL    = 10 * time.Minute
+	proxyCacheBytes    = 512 << 20
+)
+
+// proxyImageTypes are the sniffed types the proxy passes on.  SVG is left
+// out: opened directly, it would run script on this origin.
+var proxyImageTypes = map[string]bool{
+	"image/jpeg":   true,
+	"image/png":    true,
+	"image/gif":    true,
+	"image/webp":   true,
+	"image/bmp":    true,
+	"image/x-icon": true,
+}
+
+var errNotPublic = errors.New("address is not public")
+
+// nonPublicPrefixes are the special-purpose ranges that aren't routable on
+// the internet (RFC 6890 and its updates).
+var nonPublicPrefixes = func() []netip.Prefix {
+	var out []netip.Prefix
+	for _, s := range []string{
+		"0.0.0.0/8",       // "this network"
+		"10.0.0.0/8",      // private
+		"100.64.0.0/10",   // carrier-grade NAT
+		"127.0.0.0/8",     // loopback
+		"169.254.0.0/16",  // link-local, including cloud metadata
+		"172.16.0.0/12",   // private
+		"192.0.0.0/24",    // IETF protocol assignments
+		"192.0.2.0/24",    // documentation
+		"192.88.99.0/24",  // 6to4 relay anycast
+		"192.168.0.0/16",  // private
+		"198.18.0.0/15",   // benchmarking
+		"198.51.100.0/24", // documentation
+		"203.0.113.0/24",  // documentation
+		"224.0.0.0/4",     // multicast
+		"240.0.0.0/4",     // reserved, and broadcast
+		"::/96",           // unspecified, loopback, IPv4-compatible
+		"64:ff9b:1::/48",  // local-use NAT64
+		"100::/64",        // discard
+		"2001::/32",       // Teredo
+		"2001:db8::/32",   // documentation
+		"fc00::/7",        // unique local
+		"fe80::/10",       // link-local
+		"fec0::/10",       // site-local
+		"ff00::/8",        // multicast
+	} {
+		out = append(out, netip.MustParsePrefix(s))
+	}
+	return out
+}()
+
+var (
+	nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFour   = netip.MustParsePrefix("2002::/16")
+)
+
+// isPublicIP reports whether ip is routable on the internet, so the proxy
+// can't be pointed at this server or its network.  An IPv6 address that
+// carries an IPv4 one (mapped, NAT64 or 6to4) is judged by the IPv4 one.
+func isPublicIP(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	if b := addr.As16(); nat64Prefix.Contains(addr) {
+		addr = netip.AddrFrom4([4]byte(b[12:16]))
+	} else if sixToFour.Contains(addr) {
+		addr = netip.AddrFrom4([4]byte(b[2:6]))
+	}
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// publicDialer only connects to public addresses.  The check runs on the
+// resolved address of every connection, so it holds for redirects too.
+// Whatever fetches a URL a user gave it dials with this.
+var publicDialer = &net.Dialer{
+	Timeout: 5 * time.Second,
+	Control: func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+			return errNotPublic
+		}
+		return nil
+	},
+}
+
+// imageProxyClient only connects to public addresses.
+var imageProxyClient = &http.Client{
+	Timeout: 15 * time.Second,
+	Transport: &http.Transport{
+		DialContext:           publicDialer.DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		MaxIdleConns:          20,
+		IdleConnTimeout:       time.Minute,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		return nil
+	},
+}
+
+// proxyFailures remembers URLs that couldn't be fetched, so a broken image
+// in a busy channel isn't re-requested for every reader.
+var proxyFailures sync.Map // URL → time.Time
+
+// proxiedImageURL returns the proxy URL for an external image, or "" if
+// raw isn't an http(s) URL.
+func proxiedImageURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return "/api/v1/image-proxy?url=" + url.QueryEscape(raw)
+}
+
+// fetchProxiedImage downloads an image, refusing anything too large or
+// that doesn't sniff as one of proxyImageTypes.
+func fetchProxiedImage(ctx context.Context, client *http.Client, raw string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", raw, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Chirm/1.0; +https://chirm.app) ImageProxy")
+	req.Header.Set("Accept", "image/webp,image/png,image/jpeg,image/gif,image/*;q=0.8")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if resp.ContentLength > proxyImageMaxBytes {
+		return nil, fmt.Errorf("image too large")
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, proxyImageMaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > proxyImageMaxBytes {
+		return nil, fmt.Errorf("image too large")
+	}
+	if ct := http.DetectContentType(data); !proxyImageTypes[ct] {
+		return nil, fmt.Errorf("not an image (%s)", ct)
+	}
+	return data, nil
+}
+
+// proxyCachePath is where the image for raw is cached.
+func (h *Handler) proxyCachePath(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return filepath.Join(h.dataDir, "imgcache", hex.EncodeToString(sum[:]))
+}
+
+var errImageUnavailable = errors.New("image unavailable")
+
+// proxiedImage returns the image at raw, from the cache while it's fresh
+// and otherwise fetched and cached.  If the fetch fails, a stale copy is
+// returned instead.
+func (h *Handler) proxiedImage(ctx context.Context, raw string) ([]byte, error) {
+	path := h.proxyCachePath(raw)
+	var cached []byte
+	if fi, err := os.Stat(path); err == nil {
+		if cached, err = os.ReadFile(path); err == nil && time.Since(fi.ModTime()) < proxyImageTTL {
+			return cached, nil
+		}
+	}
+	if t, ok := proxyFailures.Load(raw); ok && time.Since(t.(time.Time)) < proxyFailureTTL {
+		if cached != nil {
+			return cached, nil
+		}
+		return nil, errImageUnavailable
+	}
+
+	data, err := fetchProxiedImage(ctx, imageProxyClient, raw)
+	if err != nil {
+		if ctx.Err() == nil {
+			proxyFailures.Store(raw, time.Now())
+		}
+		if cached != nil {
+			return cached, nil
+		}
+		return nil, err
+	}
+	h.storeProxiedImage(path, data, int64(len(cached)))
+	return data, nil
+}
+
+// storeProxiedImage writes an image to the cache, replacing one of
+// oldSize bytes, and has the image-cache job trim the cache if it's grown
+// too big.
+func (h *Handler) storeProxiedImage(path string, data []byte, oldSize int64) {
+	if os.MkdirAll(filepath.Dir(path), 0755) != nil {
+		return
+	}
+	// Written aside and renamed, so readers never see half an image.
+	tmp := path + ".tmp"
+	if os.WriteFile(tmp, data, 0644) != nil || os.Rename(tmp, path) != nil {
+		os.Remove(tmp)
+		return
+	}
+	if imageCacheSize.Add(int64(len(data))-oldSize) > proxyCacheBytes && h.jobs != nil {
+		h.jobs.RunNow("image-cache")
+	}
+}
+
+// ImageProxy serves an external image from the cache, fetching it first if
+// needed.
+func (h *Handler) ImageProxy(w http.ResponseWriter, r *http.Request) {
+	raw := r.URL.Query().Get("url")
+	if proxiedImageURL(raw) == "" {
+		errResp(w, http.StatusBadRequest, "invalid URL")
+		return
+	}
+
+	data, err := h.proxiedImage(r.Context(), raw)
+	if err != nil {
+		http.Error(w, "image unavailable", http.StatusBadGateway)
+		return
+	}
+
+	ct := http.DetectContentType(data)
+	if !proxyImageTypes[ct] {
+		http.Error(w, "image unavailable", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+var imageCacheSize atomic.Int64 // bytes in the cache, roughly
+
+// trimImageCache keeps the cache under proxyCacheBytes and forgets old
+// fetch failures.  It's the image-cache job, run every hour and whenever
+// the cache grows past the bound; see jobs.go.
+func (h *Handler) trimImageCache() error {
+	trimImageCacheDir(filepath.Join(h.dataDir, "imgcache"))
+	proxyFailures.Range(func(k, v interface{}) bool {
+		if time.Since(v.(time.Time)) > proxyFailureTTL {
+			proxyFailures.Delete(k)
+		}
+		return true
+	})
+	return nil
+}
+
+// trimImageCacheDir removes the images fetched longest ago until the cache is
+// back to nine tenths of proxyCacheBytes, leaving room before the next
+// trim.
+func trimImageCacheDir(dir string) {
+	entries, _ := os.ReadDir(dir)
+	files := make([]os.FileInfo, 0, len(entries))
+	var total int64
+	for _, e := range entries {
+		if fi, err := e.Info(); err == nil && fi.Mode().IsRegular() {
+			files = append(files, fi)
+			total += fi.Size()
+		}
+	}
+	if total > proxyCacheBytes {
+		sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+		for _, fi := range files {
+			if total <= proxyCacheBytes/10*9 {
+				break
+			}
+			if os.Remove(filepath.Join(dir, fi.Name())) == nil {
+				total -= fi.Size()
+			}
+		}
+	}
+	imageCacheSize.Store(total)
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":          true,
+		"2606:2800:220:1::1":     true,
+		"64:ff9b::5db8:d822":     true, // NAT64 of 93.184.216.34
+		"2002:5db8:d822::1":      true, // 6to4 of 93.184.216.34
+		"0.0.0.0":                false,
+		"0.1.2.3":                false,
+		"10.1.2.3":               false,
+		"100.64.0.1":             false,
+		"127.0.0.1":              false,
+		"169.254.169.254":        false,
+		"172.16.0.1":             false,
+		"192.168.1.1":            false,
+		"198.18.0.1":             false,
+		"240.0.0.1":              false,
+		"255.255.255.255":        false,
+		"::1":                    false,
+		"::ffff:127.0.0.1":       false,
+		"::ffff:10.0.0.1":        false,
+		"64:ff9b::a00:1":         false, // NAT64 of 10.0.0.1
+		"64:ff9b::7f00:1":        false, // NAT64 of 127.0.0.1
+		"2002:a00:1::1":          false, // 6to4 of 10.0.0.1
+		"2001:0:4136:e378::1":    false, // Teredo
+		"fd00::1":                false,
+		"fe80::1":                false,
+		"ff02::1":                false,
+		"2001:db8::1":            false,
+		"::ffff:169.254.169.254": false,
+	} {
+		if got := isPublicIP(net.ParseIP(addr)); got != want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
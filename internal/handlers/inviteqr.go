@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	qrcode "github.com/skip2/go-qrcode"
+
+	"chirm/internal/discovery"
+)
+
+// ─── Invite QR codes ─────────────────────────────────────────────────────────
+//
+// At a LAN party it's quicker to point a phone's camera at the screen than
+// to type https://192.168.1.20:8443/login?invite=…, so invites can be shown
+// as QR codes.  The link in the code is the one the app copies, made from
+// PUBLIC_URL when set, else from the address the request came in on; an
+// admin looking at the server on localhost gets the machine's LAN address
+// instead, which a phone can reach.
+
+const (
+	inviteQRSize    = 256
+	inviteQRMinSize = 128
+	inviteQRMaxSize = 1024
+)
+
+// InviteQR handles GET /api/invites/{code}/qr.png?size=<px> (members of the
+// invite's guild): a PNG QR code of the invite's link, size pixels square
+// (256 by default, 128 to 1024).
+func (h *Handler) InviteQR(w http.ResponseWriter, r *http.Request) {
+	inv, err := h.db.GetInviteByCode(chi.URLParam(r, "code"))
+	if err != nil {
+		errResp(w, http.StatusNotFound, "invite not found")
+		return
+	}
+	if _, isMember := h.requireGuildMember(w, r, inv.GuildID); !isMember {
+		return
+	}
+	if !h.db.IsInviteValid(inv) {
+		errResp(w, http.StatusForbidden, "invite is no longer valid")
+		return
+	}
+	size := inviteQRSize
+	if v := r.URL.Query().Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < inviteQRMinSize || n > inviteQRMaxSize {
+			errResp(w, http.StatusBadRequest, "size must be between 128 and 1024")
+			return
+		}
+		size = n
+	}
+
+	link := h.inviteBaseURL(r) + "/login?invite=" + url.QueryEscape(inv.Code)
+	png, err := qrcode.Encode(link, qrcode.Medium, size)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to make QR code")
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Header().Set("X-Invite-URL", link)
+	w.Write(png)
+}
+
+// inviteBaseURL is where the server can be reached by whoever the invite
+// is for, without a trailing slash.
+func (h *Handler) inviteBaseURL(r *http.Request) string {
+	if h.discovery.PublicURL != "" {
+		return strings.TrimRight(h.discovery.PublicURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	host := r.Host
+	name := host
+	if hn, _, err := net.SplitHostPort(host); err == nil {
+		name = hn
+	}
+	if ip := net.ParseIP(name); name == "localhost" || (ip != nil && ip.IsLoopback()) {
+		if ips := discovery.LANAddrs(); len(ips) > 0 {
+			// The built-in certificate names the LAN addresses, so HTTPS
+			// is the better link when it's running.
+			switch {
+			case h.discovery.HTTPSPort != 0:
+				return "https://" + net.JoinHostPort(ips[0].String(), strconv.Itoa(h.discovery.HTTPSPort))
+			case h.discovery.HTTPPort != 0:
+				return "http://" + net.JoinHostPort(ips[0].String(), strconv.Itoa(h.discovery.HTTPPort))
+			}
+		}
+	}
+	return scheme + "://" + host
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+	mw "chirm/internal/middleware"
+)
+
+// ─── IP allow and deny lists ─────────────────────────────────────────────────
+//
+// Admins can shut clients out by address: deny rules block networks, and
+// once there are allow rules nobody outside them gets in — for a LAN server
+// that's briefly reachable from the internet.  The rules live in the
+// database and are enforced by an mw.IPFilter ahead of everything else,
+// including sign-in.  A change applies straight away, and WebSocket
+// connections from addresses no longer allowed are closed.
+
+// SetIPFilter hands the server's filter to h and loads the saved rules
+// into it.
+func (h *Handler) SetIPFilter(f *mw.IPFilter) error {
+	h.ipFilter = f
+	return h.reloadIPRules()
+}
+
+// reloadIPRules applies the rules in the database to the filter and drops
+// the WebSocket clients it now refuses.
+func (h *Handler) reloadIPRules() error {
+	rules, err := h.db.ListIPRules()
+	if err != nil {
+		return err
+	}
+	allow, deny := ipRuleNetworks(rules)
+	h.ipFilter.Set(allow, deny)
+	h.hub.disconnectAddresses(h.ipFilter.Allows)
+	return nil
+}
+
+// ipRuleNetworks splits rules into allowed and denied networks.
+func ipRuleNetworks(rules []db.IPRule) (allow, deny []*net.IPNet) {
+	for _, rule := range rules {
+		n, err := mw.ParseNetwork(rule.Network)
+		if err != nil {
+			continue
+		}
+		if rule.Action == db.IPAllow {
+			allow = append(allow, n)
+		} else {
+			deny = append(deny, n)
+		}
+	}
+	return allow, deny
+}
+
+// blockedRequester returns r's client address if rules would refuse it,
+// so admins don't lock themselves out, or nil.
+func blockedRequester(r *http.Request, rules []db.IPRule) net.IP {
+	ip := mw.RemoteIP(r)
+	if ip == nil || ip.IsLoopback() {
+		return nil
+	}
+	if allow, deny := ipRuleNetworks(rules); mw.IPAllowed(ip, allow, deny) {
+		return nil
+	}
+	return ip
+}
+
+// disconnectAddresses closes the connections of clients whose address
+// allowed refuses.  Their read loops then unregister them as usual.
+func (h *Hub) disconnectAddresses(allowed func(net.IP) bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.clients {
+		if client.ip != nil && !allowed(client.ip) {
+			client.log.Info("ws closed by ip rule", "ip", client.ip.String())
+			client.close()
+		}
+	}
+}
+
+// ListIPRules handles GET /api/ip-rules (admin only).
+func (h *Handler) ListIPRules(w http.ResponseWriter, r *http.Request) {
+	if _, isAdmin := h.requireAdmin(w, r); !isAdmin {
+		return
+	}
+	rules, err := h.db.ListIPRules()
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to load ip rules")
+		return
+	}
+	ok(w, rules)
+}
+
+// CreateIPRuleRequest is the body of POST /api/ip-rules.
+type CreateIPRuleRequest struct {
+	Network string `json:"network"` // an IP address or CIDR network
+	Action  string `json:"action"`  // "allow" or "deny"
+	Note    string `json:"note"`
+}
+
+// CreateIPRule handles POST /api/ip-rules (admin only).  A rule that would
+// shut out the admin adding it is refused.
+func (h *Handler) CreateIPRule(w http.ResponseWriter, r *http.Request) {
+	u, isAdmin := h.requireAdmin(w, r)
+	if !isAdmin {
+		return
+	}
+	var req CreateIPRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if req.Action != db.IPAllow && req.Action != db.IPDeny {
+		errResp(w, http.StatusBadRequest, `action must be "allow" or "deny"`)
+		return
+	}
+	n, err := mw.ParseNetwork(req.Network)
+	if err != nil {
+		errResp(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if len(req.Note) > 200 {
+		errResp(w, http.StatusBadRequest, "note must be at most 200 characters")
+		return
+	}
+
+	rules, err := h.db.ListIPRules()
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to load ip rules")
+		return
+	}
+	if ip := blockedRequester(r, append(rules, db.IPRule{Network: n.String(), Action: req.Action})); ip != nil {
+		errResp(w, http.StatusConflict, "this rule would block your own address ("+ip.String()+")")
+		return
+	}
+
+	rule, err := h.db.CreateIPRule(n.String(), req.Action, req.Note, u.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to save ip rule")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{
+		ActorID:  u.ID,
+		Action:   "ip_rule.create",
+		TargetID: rule.ID,
+		Details:  req.Action + " " + rule.Network,
+	})
+	h.reloadIPRules()
+	created(w, rule)
+}
+
+// DeleteIPRule handles DELETE /api/ip-rules/{id} (admin only).
+func (h *Handler) DeleteIPRule(w http.ResponseWriter, r *http.Request) {
+	u, isAdmin := h.requireAdmin(w, r)
+	if !isAdmin {
+		return
+	}
+	rule, err := h.db.GetIPRule(chi.URLParam(r, "id"))
+	if err != nil {
+		errResp(w, http.StatusNotFound, "ip rule not found")
+		return
+	}
+	rules, err := h.db.ListIPRules()
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to load ip rules")
+		return
+	}
+	var rest []db.IPRule
+	for _, other := range rules {
+		if other.ID != rule.ID {
+			rest = append(rest, other)
+		}
+	}
+	if ip := blockedRequester(r, rest); ip != nil {
+		errResp(w, http.StatusConflict, "removing this rule would block your own address ("+ip.String()+")")
+		return
+	}
+	if err := h.db.DeleteIPRule(rule.ID); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to delete ip rule")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{
+		ActorID:  u.ID,
+		Action:   "ip_rule.delete",
+		TargetID: rule.ID,
+		Details:  "removed " + rule.Action + " " + rule.Network,
+	})
+	h.reloadIPRules()
+	ok(w, map[string]string{"message": "ip rule deleted"})
+}
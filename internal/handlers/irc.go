@@ -0,0 +1,779 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"chirm/internal/db"
+)
+
+// ─── IRC gateway ─────────────────────────────────────────────────────────────
+//
+// With IRC_LISTEN set, Chirm also speaks enough IRC for terminal clients
+// and old favourites to take part in text channels.  Guild admins choose
+// which channels are open to it.  A client signs in with one of its user's
+// personal tokens as the server password, and its nick is the user's
+// username, whatever it asked for.  It can LIST the open channels it can
+// see, JOIN them, and PRIVMSG them, which posts a message as that user
+// with the same checks as the app; messages posted anywhere come back as
+// PRIVMSGs from their authors.  There are no private messages, since
+// Chirm has none, nor modes, kicks or the rest.
+//
+// A connection takes its place in the hub like a browser's, so it counts
+// as being online, is closed by IP rules and maintenance like one, and is
+// sent the messages of every channel its user can see, keeping those of
+// the channels it has joined.
+
+const (
+	ircServerName  = "chirm"
+	ircMaxLine     = 8 << 10 // IRC says 512 bytes, but some clients send more
+	ircRegTimeout  = 60 * time.Second
+	ircIdleTimeout = 5 * time.Minute
+	ircPingEvery   = 2 * time.Minute
+	ircWriteWait   = 10 * time.Second
+	ircFloodEvery  = time.Second // messages a client may send, after ircFloodBurst at once
+	ircFloodBurst  = 5
+)
+
+// ircLimits keeps IRC clients from flooding channels.
+var ircLimits = newUserLimiter(ircFloodEvery, ircFloodBurst)
+
+// ircConn is a client connected to the IRC gateway.
+type ircConn struct {
+	h       *Handler
+	conn    net.Conn
+	log     *slog.Logger
+	writeMu sync.Mutex
+
+	// Set while registering, then fixed.
+	pass, nick string
+	gotUser    bool
+	user       *db.User
+	tokenID    string
+	client     *Client // in the hub, once registered
+
+	mu     sync.Mutex
+	joined map[string]string // channel ID → IRC name
+	sent   map[string]bool   // IDs of messages this connection posted, not to be echoed
+}
+
+// ircMessage is a line from a client.
+type ircMessage struct {
+	Command string
+	Params  []string
+}
+
+// parseIRCLine splits line into its command and parameters, dropping any
+// tags and prefix.
+func parseIRCLine(line string) ircMessage {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "@") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	var m ircMessage
+	for line != "" {
+		line = strings.TrimLeft(line, " ")
+		if strings.HasPrefix(line, ":") && m.Command != "" {
+			m.Params = append(m.Params, line[1:])
+			break
+		}
+		var word string
+		word, line, _ = strings.Cut(line, " ")
+		if word == "" {
+			continue
+		}
+		if m.Command == "" {
+			m.Command = strings.ToUpper(word)
+		} else {
+			m.Params = append(m.Params, word)
+		}
+	}
+	return m
+}
+
+// ircNick is name as a nick: IRC nicks have no spaces.
+func ircNick(name string) string {
+	return strings.NewReplacer(" ", "_", "!", "_", "@", "_", ",", "_").Replace(name)
+}
+
+// ircChannelNames names the channels open to IRC, "#" and the channel's
+// name, with a bit of its ID after any whose name another has already.
+func ircChannelNames(channels []db.Channel) map[string]*db.Channel {
+	names := make(map[string]*db.Channel, len(channels))
+	for i := range channels {
+		ch := &channels[i]
+		name := "#" + strings.ToLower(strings.NewReplacer(" ", "-", ",", "-").Replace(ch.Name))
+		if _, taken := names[name]; taken {
+			name += "-" + ch.ID[:min(6, len(ch.ID))]
+		}
+		names[name] = ch
+	}
+	return names
+}
+
+// ircFormatting strips IRC's bold, colour and other formatting codes.
+func ircFormatting(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 0x02, 0x0F, 0x11, 0x16, 0x1D, 0x1E, 0x1F:
+		case 0x03: // colour: ^C, then up to two digits, a comma and two more
+			for n := 0; n < 2 && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9'; n++ {
+				i++
+			}
+			if i+2 < len(s) && s[i+1] == ',' && s[i+2] >= '0' && s[i+2] <= '9' {
+				i += 2
+				if i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9' {
+					i++
+				}
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// ServeIRC runs the IRC gateway on ln, in the background, until ln is
+// closed.  secure says whether ln is a TLS listener, which the app tells
+// people.
+func (h *Handler) ServeIRC(ln net.Listener, secure bool) {
+	if ln.Addr().Network() == "tcp" {
+		if _, port, err := net.SplitHostPort(ln.Addr().String()); err == nil {
+			if secure {
+				h.ircTLSPort = port
+			} else {
+				h.ircPort = port
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					time.Sleep(time.Second)
+					continue
+				}
+				slog.Error("irc: accept", "err", err)
+				return
+			}
+			go h.serveIRCConn(conn)
+		}
+	}()
+}
+
+func (h *Handler) serveIRCConn(conn net.Conn) {
+	var ip net.IP
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		ip = addr.IP
+	}
+	if h.ipFilter != nil && ip != nil && !ip.IsLoopback() && !h.ipFilter.Allows(ip) {
+		conn.Close()
+		return
+	}
+	c := &ircConn{
+		h:      h,
+		conn:   conn,
+		log:    slog.With("irc", conn.RemoteAddr().String()),
+		joined: map[string]string{},
+		sent:   map[string]bool{},
+	}
+	c.log.Debug("irc connected")
+	defer func() {
+		if c.client != nil {
+			h.hub.unregister <- c.client
+		}
+		conn.Close()
+		c.log.Debug("irc disconnected")
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 1024), ircMaxLine)
+	conn.SetReadDeadline(time.Now().Add(ircRegTimeout))
+	for scanner.Scan() {
+		m := parseIRCLine(scanner.Text())
+		if m.Command == "" {
+			continue
+		}
+		if c.client == nil {
+			if !c.register(m, ip) {
+				return
+			}
+		} else if !c.handle(m) {
+			return
+		}
+		if c.client != nil {
+			conn.SetReadDeadline(time.Now().Add(ircIdleTimeout))
+		}
+	}
+}
+
+// send writes a line to the client.
+func (c *ircConn) send(format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	if len(line) > 510 {
+		line = line[:510]
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(ircWriteWait))
+	c.conn.Write([]byte(line + "\r\n"))
+}
+
+// reply sends a numeric reply.
+func (c *ircConn) reply(code, format string, args ...interface{}) {
+	nick := c.nick
+	if nick == "" {
+		nick = "*"
+	}
+	c.send(":%s %s %s %s", ircServerName, code, nick, fmt.Sprintf(format, args...))
+}
+
+// prefix is the client's own source, as it appears in its commands' echoes.
+func (c *ircConn) prefix() string {
+	return c.nick + "!" + c.nick + "@" + ircServerName
+}
+
+// register handles a command from a client that hasn't registered yet,
+// and signs it in once it has sent PASS, NICK and USER.  It reports whether
+// to carry on.
+func (c *ircConn) register(m ircMessage, ip net.IP) bool {
+	switch m.Command {
+	case "CAP":
+		if len(m.Params) > 0 {
+			switch strings.ToUpper(m.Params[0]) {
+			case "LS", "LIST":
+				c.send(":%s CAP * %s :", ircServerName, strings.ToUpper(m.Params[0]))
+			case "REQ":
+				c.send(":%s CAP * NAK :%s", ircServerName, m.Params[len(m.Params)-1])
+			}
+		}
+		return true
+	case "PASS":
+		if len(m.Params) > 0 {
+			c.pass = m.Params[0]
+		}
+		return true
+	case "NICK":
+		if len(m.Params) > 0 {
+			c.nick = m.Params[0]
+		}
+	case "USER":
+		c.gotUser = true
+	case "PING":
+		c.pong(m)
+		return true
+	case "QUIT":
+		return false
+	default:
+		c.reply("451", ":You have not registered")
+		return true
+	}
+	if c.nick == "" || !c.gotUser {
+		return true
+	}
+
+	if c.pass == "" {
+		c.reply("464", ":Sign in with a personal token from Chirm as the server password")
+		c.send("ERROR :Closing link (no password)")
+		return false
+	}
+	u, err := c.h.db.UserByPersonalToken(c.pass)
+	if err != nil {
+		c.reply("464", ":Password incorrect")
+		c.send("ERROR :Closing link (bad password)")
+		return false
+	}
+	if m := c.h.maintenance.Load(); m != nil && m.Enabled && !c.h.db.HasPermission(u, db.PermManageServer) {
+		c.send("ERROR :Closing link (the server is down for maintenance)")
+		return false
+	}
+	c.user = u
+	c.tokenID, _, _ = strings.Cut(strings.TrimSpace(c.pass), ".")
+	c.pass = ""
+	c.log = c.log.With("user", u.Username)
+
+	asked := c.nick
+	c.nick = ircNick(u.Username)
+	network, _ := c.h.db.GetSetting("server_name")
+	network = ircNick(network)
+	if network == "" {
+		network = "Chirm"
+	}
+	c.reply("001", ":Welcome to %s, %s", network, c.nick)
+	c.reply("002", ":Your host is %s, the Chirm IRC gateway", ircServerName)
+	c.reply("003", ":Channels are the ones open to IRC; /list shows them")
+	c.reply("004", "%s chirm i nt", ircServerName)
+	c.reply("005", "CHANTYPES=# NETWORK=%s CASEMAPPING=ascii NICKLEN=32 CHANNELLEN=64 :are supported by this server", network)
+	c.reply("422", ":No MOTD; /list shows the channels you can join")
+	if !strings.EqualFold(asked, c.nick) {
+		c.send(":%s!%s@%s NICK %s", ircNick(asked), ircNick(asked), ircServerName, c.nick)
+	}
+
+	c.client = &Client{
+		hub:     c.h.hub,
+		send:    make(chan []byte, 256),
+		userID:  u.ID,
+		ip:      ip,
+		log:     c.log,
+		irc:     c,
+		intents: IntentMessages,
+	}
+	c.h.db.RecordActivity(u.ID, time.Now())
+	c.h.hub.register <- c.client
+	go c.writeLoop(c.client)
+	c.log.Info("irc signed in")
+	return true
+}
+
+func (c *ircConn) pong(m ircMessage) {
+	token := ircServerName
+	if len(m.Params) > 0 {
+		token = m.Params[0]
+	}
+	c.send(":%s PONG %s :%s", ircServerName, ircServerName, token)
+}
+
+// handle handles a command from a registered client, reporting whether to
+// carry on.
+func (c *ircConn) handle(m ircMessage) bool {
+	switch m.Command {
+	case "PING":
+		c.pong(m)
+	case "PONG", "CAP", "NOTICE", "USERHOST", "ISON":
+		// Nothing to say.  NOTICEs are never answered, and aren't posted
+		// either: bots use them for their automatic replies.
+	case "NICK":
+		if len(m.Params) > 0 && m.Params[0] != c.nick {
+			c.reply("432", "%s :Your nick is your Chirm username", m.Params[0])
+		}
+	case "JOIN":
+		if len(m.Params) == 0 {
+			c.reply("461", "JOIN :Not enough parameters")
+			break
+		}
+		if m.Params[0] == "0" {
+			for _, name := range c.joinedNames() {
+				c.part(name, "")
+			}
+			break
+		}
+		for _, name := range strings.Split(m.Params[0], ",") {
+			c.join(name)
+		}
+	case "PART":
+		if len(m.Params) == 0 {
+			c.reply("461", "PART :Not enough parameters")
+			break
+		}
+		reason := ""
+		if len(m.Params) > 1 {
+			reason = m.Params[1]
+		}
+		for _, name := range strings.Split(m.Params[0], ",") {
+			if !c.part(strings.ToLower(name), reason) {
+				c.reply("442", "%s :You're not on that channel", name)
+			}
+		}
+	case "PRIVMSG":
+		if len(m.Params) < 2 {
+			c.reply("412", ":No text to send")
+			break
+		}
+		for _, target := range strings.Split(m.Params[0], ",") {
+			c.privmsg(target, m.Params[1])
+		}
+	case "LIST":
+		c.list()
+	case "NAMES":
+		if len(m.Params) > 0 {
+			for _, name := range strings.Split(m.Params[0], ",") {
+				if ch := c.channel(strings.ToLower(name)); ch != nil {
+					c.names(name, ch)
+				} else {
+					c.reply("366", "%s :End of /NAMES list", name)
+				}
+			}
+		}
+	case "TOPIC":
+		if len(m.Params) == 0 {
+			c.reply("461", "TOPIC :Not enough parameters")
+		} else if len(m.Params) > 1 {
+			c.reply("482", "%s :Change the topic in Chirm", m.Params[0])
+		} else if ch := c.channel(strings.ToLower(m.Params[0])); ch != nil {
+			c.topic(m.Params[0], ch)
+		} else {
+			c.reply("403", "%s :No such channel", m.Params[0])
+		}
+	case "MODE":
+		switch {
+		case len(m.Params) == 0:
+			c.reply("461", "MODE :Not enough parameters")
+		case strings.HasPrefix(m.Params[0], "#") && len(m.Params) == 1:
+			c.reply("324", "%s +nt", m.Params[0])
+		case strings.EqualFold(m.Params[0], c.nick):
+			c.reply("221", "+i")
+		}
+	case "WHO":
+		mask := "*"
+		if len(m.Params) > 0 {
+			mask = m.Params[0]
+		}
+		c.reply("315", "%s :End of /WHO list", mask)
+	case "AWAY":
+		if len(m.Params) > 0 && m.Params[0] != "" {
+			c.reply("306", ":You have been marked as being away")
+		} else {
+			c.reply("305", ":You are no longer marked as being away")
+		}
+	case "QUIT":
+		c.send("ERROR :Closing link (quit)")
+		return false
+	default:
+		c.reply("421", "%s :Unknown command", m.Command)
+	}
+	return true
+}
+
+// channels returns the channels open to IRC that the client may see, by
+// IRC name.
+func (c *ircConn) channels() map[string]*db.Channel {
+	all, err := c.h.db.IRCChannels()
+	if err != nil {
+		c.log.Error("irc: listing channels", "err", err)
+		return nil
+	}
+	names := ircChannelNames(all)
+	for name, ch := range names {
+		if !c.h.db.CanAccessGuild(c.user, ch.GuildID) {
+			delete(names, name)
+		}
+	}
+	return names
+}
+
+// channel returns the channel name names, if the client may see it.
+func (c *ircConn) channel(name string) *db.Channel {
+	return c.channels()[name]
+}
+
+func (c *ircConn) joinedNames() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.joined))
+	for _, name := range c.joined {
+		names = append(names, name)
+	}
+	return names
+}
+
+// joinedID returns the ID of the joined channel called name, or "".
+func (c *ircConn) joinedID(name string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, n := range c.joined {
+		if n == name {
+			return id
+		}
+	}
+	return ""
+}
+
+func (c *ircConn) join(name string) {
+	name = strings.ToLower(name)
+	ch := c.channel(name)
+	if ch == nil {
+		c.reply("403", "%s :No such channel", name)
+		return
+	}
+	c.mu.Lock()
+	_, already := c.joined[ch.ID]
+	c.joined[ch.ID] = name
+	c.mu.Unlock()
+	if already {
+		return
+	}
+	c.send(":%s JOIN %s", c.prefix(), name)
+	c.topic(name, ch)
+	c.names(name, ch)
+}
+
+// part leaves the joined channel called name, reporting whether the client
+// was in it.
+func (c *ircConn) part(name, reason string) bool {
+	id := c.joinedID(name)
+	if id == "" {
+		return false
+	}
+	c.mu.Lock()
+	delete(c.joined, id)
+	c.mu.Unlock()
+	if reason != "" {
+		c.send(":%s PART %s :%s", c.prefix(), name, reason)
+	} else {
+		c.send(":%s PART %s", c.prefix(), name)
+	}
+	return true
+}
+
+// closeChannel parts the client from channelID, if it's in it, as the
+// channel has been closed to IRC or deleted.
+func (c *ircConn) closeChannel(channelID string) {
+	c.mu.Lock()
+	name, in := c.joined[channelID]
+	c.mu.Unlock()
+	if in {
+		c.part(name, "Channel closed to IRC")
+	}
+}
+
+func (c *ircConn) topic(name string, ch *db.Channel) {
+	if ch.Description == "" {
+		c.reply("331", "%s :No topic is set", name)
+		return
+	}
+	c.reply("332", "%s :%s", name, strings.ReplaceAll(ch.Description, "\n", " "))
+}
+
+// names lists who is online and can see ch.
+func (c *ircConn) names(name string, ch *db.Channel) {
+	var nicks []string
+	for _, id := range c.h.hub.connectedUsers() {
+		u, err := c.h.db.GetUserByID(id)
+		if err == nil && c.h.db.CanAccessGuild(u, ch.GuildID) {
+			nicks = append(nicks, ircNick(u.Username))
+		}
+	}
+	sort.Strings(nicks)
+	for len(nicks) > 0 {
+		n := min(len(nicks), 20)
+		c.reply("353", "= %s :%s", name, strings.Join(nicks[:n], " "))
+		nicks = nicks[n:]
+	}
+	c.reply("366", "%s :End of /NAMES list", name)
+}
+
+func (c *ircConn) list() {
+	channels := c.channels()
+	names := make([]string, 0, len(channels))
+	for name := range channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.reply("321", "Channel :Users  Name")
+	for _, name := range names {
+		c.reply("322", "%s 0 :%s", name, strings.ReplaceAll(channels[name].Description, "\n", " "))
+	}
+	c.reply("323", ":End of /LIST")
+}
+
+// privmsg posts text to the channel target, as the client's user.
+func (c *ircConn) privmsg(target, text string) {
+	name := strings.ToLower(target)
+	if !strings.HasPrefix(name, "#") {
+		c.reply("401", "%s :No such nick; Chirm has no private messages", target)
+		return
+	}
+	id := c.joinedID(name)
+	if id == "" {
+		c.reply("404", "%s :Join the channel first", target)
+		return
+	}
+	// /me comes as a CTCP ACTION; other CTCPs are dropped.
+	if strings.HasPrefix(text, "\x01") {
+		action, found := strings.CutPrefix(strings.TrimSuffix(text[1:], "\x01"), "ACTION ")
+		if !found {
+			return
+		}
+		text = "*" + action + "*"
+	}
+	text = strings.TrimSpace(ircFormatting(text))
+	if text == "" {
+		return
+	}
+
+	h, u := c.h, c.user
+	if u2, err := h.db.GetUserByID(u.ID); err == nil {
+		u = u2 // roles may have changed since signing in
+	}
+	ch, err := h.db.GetChannelByID(id)
+	if err != nil || !ch.IRC || !h.db.CanAccessGuild(u, ch.GuildID) {
+		c.closeChannel(id)
+		return
+	}
+	refuse := ""
+	switch {
+	case !h.hasChannelPermission(u, id, db.PermSendMessages):
+		refuse = "You don't have permission to send messages there"
+	case h.mustAcceptRules(u):
+		refuse = "Accept the server rules in Chirm before posting"
+	case h.readOnly.Load().Enabled && !h.db.HasPermission(u, db.PermManageServer):
+		refuse = "The server is read-only for now"
+	case len(text) > 4000:
+		refuse = "Message too long"
+	case !ircLimits.allow(u.ID):
+		refuse = "You're sending messages too quickly"
+	}
+	if until, timedOut := h.db.TimedOutUntil(ch.GuildID, u.ID, time.Now()); refuse == "" && timedOut {
+		refuse = "You're timed out until " + until.UTC().Format(time.RFC3339)
+	}
+	var verdict *automodVerdict
+	if refuse == "" {
+		verdict = h.automodCheck(u, ch, text, true)
+		if verdict != nil && verdict.Action.Delete {
+			h.applyAutomod(verdict, u, ch, text, "")
+			refuse = "Blocked by auto-moderation: " + verdict.Reason()
+		}
+	}
+	if refuse != "" {
+		c.reply("404", "%s :%s", target, refuse)
+		return
+	}
+	msg, err := h.db.CreateMessage(id, u.ID, text, nil)
+	if err != nil {
+		c.log.Error("irc: sending message", "err", err)
+		c.reply("404", "%s :Couldn't send the message", target)
+		return
+	}
+	c.mu.Lock()
+	c.sent[msg.ID] = true
+	c.mu.Unlock()
+	h.publishMessage(msg, u.ID)
+	if verdict != nil {
+		h.applyAutomod(verdict, u, ch, text, msg.ID)
+	}
+}
+
+// writeLoop relays the hub's events for client to the IRC client until the
+// hub lets it go, pinging it now and then.
+func (c *ircConn) writeLoop(client *Client) {
+	ping := time.NewTicker(ircPingEvery)
+	defer func() {
+		ping.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case data, open := <-client.send:
+			if !open {
+				return
+			}
+			c.deliver(data)
+		case <-ping.C:
+			c.send("PING :%s", ircServerName)
+		}
+	}
+}
+
+// deliver passes on an event from the hub: new messages in joined
+// channels, as PRIVMSGs.  Edits, deletions and the rest have no IRC
+// equivalent.
+func (c *ircConn) deliver(data []byte) {
+	var event struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	if json.Unmarshal(data, &event) != nil {
+		return
+	}
+	if event.Type == "message.new" {
+		var msg db.Message
+		if json.Unmarshal(event.Data, &msg) != nil {
+			return
+		}
+		c.mu.Lock()
+		name, in := c.joined[msg.ChannelID]
+		own := c.sent[msg.ID]
+		delete(c.sent, msg.ID)
+		c.mu.Unlock()
+		if !in || own {
+			return
+		}
+		from := ircServerName
+		if msg.Author != nil {
+			from = ircNick(msg.Author.Username)
+		}
+		for _, line := range c.messageLines(&msg) {
+			c.send(":%s!%s@%s PRIVMSG %s :%s", from, from, ircServerName, name, line)
+		}
+	}
+}
+
+// messageLines is msg as lines of text, with its attachments, sticker and
+// embeds described.
+func (c *ircConn) messageLines(msg *db.Message) []string {
+	var lines []string
+	for _, line := range strings.Split(msg.Content, "\n") {
+		if line = strings.TrimRight(line, " \r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	base := strings.TrimRight(c.h.discovery.PublicURL, "/")
+	for _, a := range msg.Attachments {
+		name := a.OriginalName
+		if name == "" {
+			name = a.Filename
+		}
+		if base != "" && strings.HasPrefix(a.URL, "/") {
+			lines = append(lines, fmt.Sprintf("[file: %s] %s", name, base+a.URL))
+		} else {
+			lines = append(lines, fmt.Sprintf("[file: %s]", name))
+		}
+	}
+	if msg.Sticker != nil {
+		lines = append(lines, fmt.Sprintf("[sticker: %s]", msg.Sticker.Name))
+	}
+	var embeds []RichEmbed
+	if len(msg.RichEmbeds) > 0 && json.Unmarshal(msg.RichEmbeds, &embeds) == nil {
+		for _, e := range embeds {
+			text := strings.TrimSpace(strings.Join([]string{e.Title, e.URL}, " "))
+			if text == "" {
+				text = truncateRunes(strings.ReplaceAll(e.Description, "\n", " "), 200)
+			}
+			if text != "" {
+				lines = append(lines, "[embed] "+text)
+			}
+		}
+	}
+	return lines
+}
+
+// closeIRCChannel parts IRC clients from channelID, which has been closed
+// to IRC or deleted.
+func (h *Hub) closeIRCChannel(channelID string) {
+	h.mu.RLock()
+	var conns []*ircConn
+	for client := range h.clients {
+		if client.irc != nil {
+			conns = append(conns, client.irc)
+		}
+	}
+	h.mu.RUnlock()
+	for _, c := range conns {
+		c.closeChannel(channelID)
+	}
+}
+
+// disconnectToken closes the IRC connections signed in with personal
+// token id.
+func (h *Hub) disconnectToken(id string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.clients {
+		if client.irc != nil && client.irc.tokenID == id {
+			client.log.Info("irc closed", "reason", "token deleted")
+			client.close()
+		}
+	}
+}
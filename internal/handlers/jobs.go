@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+	"chirm/internal/jobs"
+)
+
+// ─── Background jobs ─────────────────────────────────────────────────────────
+//
+// The handlers' periodic work runs on the server's scheduler (see the jobs
+// package), next to the backups and certificate renewal main adds.  Admins
+// see when each job last ran, how it went and when it's next due, and can
+// run one now.
+
+// ScheduleJobs adds the handlers' jobs to s.  Digests need a mailer, so
+// call it after SetMailer.
+func (h *Handler) ScheduleJobs(s *jobs.Scheduler) {
+	h.jobs = s
+	// Every instance records its own connected users as active, so each
+	// refreshes the stats.
+	s.Add(jobs.Job{Name: "stats", Every: statsInterval, Retry: statsRetry, Run: func() error {
+		return h.refreshStats(time.Now())
+	}})
+	s.Add(jobs.Job{Name: "orphaned-attachments", Every: time.Hour, Jitter: time.Minute, Shared: true, Run: func() error {
+		return h.db.CleanOrphanedAttachments(time.Hour, h.RemoveUpload)
+	}})
+	s.Add(jobs.Job{Name: "link-preview-retention", Every: 24 * time.Hour, Jitter: 5 * time.Minute, Shared: true, Run: func() error {
+		return h.db.PruneLinkPreviews(time.Now().Add(-previewTTL))
+	}})
+	s.Add(jobs.Job{Name: "translation-retention", Every: 24 * time.Hour, Jitter: 5 * time.Minute, Shared: true, Run: func() error {
+		return h.db.PruneTranslations(time.Now().Add(-translationTTL))
+	}})
+	s.Add(jobs.Job{Name: "oauth-cleanup", Every: time.Hour, Jitter: 5 * time.Minute, Shared: true, Run: func() error {
+		return h.db.PruneOAuth(time.Now())
+	}})
+	s.Add(jobs.Job{Name: "image-cache", Every: time.Hour, Run: h.trimImageCache})
+	s.Add(jobs.Job{Name: "feeds", Every: feedInterval, Jitter: time.Minute, Shared: true, Run: h.pollFeeds})
+	s.Add(jobs.Job{Name: "event-reminders", Every: eventReminderInterval, Shared: true, Run: h.sendEventReminders})
+	s.Add(jobs.Job{Name: "federation", Every: federationInterval, Delay: federationInterval, Shared: true, Run: h.deliverFederation})
+	if h.email != nil {
+		s.Add(jobs.Job{Name: "digests", Every: digestCheckInterval, Jitter: time.Minute, Shared: true, Run: func() error {
+			return h.sendDueDigests(time.Now())
+		}})
+	}
+}
+
+// ListJobs handles GET /api/admin/jobs (admin only): every background job,
+// with its last and next run.
+func (h *Handler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	if _, isAdmin := h.requireAdmin(w, r); !isAdmin {
+		return
+	}
+	statuses := []jobs.Status{}
+	if h.jobs != nil {
+		statuses = h.jobs.Statuses()
+	}
+	ok(w, statuses)
+}
+
+// RunJob handles POST /api/admin/jobs/{name}/run (admin only): the job runs
+// as soon as it can, unless another instance is running it already.
+func (h *Handler) RunJob(w http.ResponseWriter, r *http.Request) {
+	u, isAdmin := h.requireAdmin(w, r)
+	if !isAdmin {
+		return
+	}
+	name := chi.URLParam(r, "name")
+	err := jobs.ErrUnknownJob
+	if h.jobs != nil {
+		err = h.jobs.RunNow(name)
+	}
+	if errors.Is(err, jobs.ErrUnknownJob) {
+		errResp(w, http.StatusNotFound, "no such job")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "job.run", TargetID: name, Details: "ran job " + name})
+	respond(w, http.StatusAccepted, map[string]string{"status": "queued"})
+}
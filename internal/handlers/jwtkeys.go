@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/auth"
+	"chirm/internal/db"
+)
+
+// ─── Rotating the token key ──────────────────────────────────────────────────
+//
+// Owners rotate the key session tokens are signed with by adding a new one,
+// which signs tokens from then on, and retiring the old one once sessions
+// have moved over: a browser's session is re-signed with the new key the
+// next time it's used.  See the db package for how keys are kept.
+
+// loadJWTKeys gives h.auth the keys that aren't retired.
+func (h *Handler) loadJWTKeys() {
+	keys, err := h.db.ListJWTKeys()
+	if err != nil {
+		slog.Error("loading token signing keys", "err", err)
+		return
+	}
+	var active []auth.Key
+	for _, k := range keys {
+		if k.RetiredAt == nil {
+			active = append(active, auth.Key{ID: k.ID, Secret: []byte(k.Secret)})
+		}
+	}
+	h.auth.SetKeys(active)
+}
+
+// publishJWTKeys reloads h's keys after one was added or retired, and tells
+// the other instances of a cluster to reload theirs: a retired key they
+// still hold would keep working there, and an added one wouldn't sign.
+func (h *Handler) publishJWTKeys() {
+	h.loadJWTKeys()
+	h.hub.publish(clusterSelf, "", WSEvent{Type: "jwt_keys.changed"})
+}
+
+// setOnJWTKeys sets what the hub calls when another instance says the
+// signing keys changed.
+func (h *Hub) setOnJWTKeys(reload func()) {
+	h.onJWTKeysMu.Lock()
+	h.onJWTKeys = reload
+	h.onJWTKeysMu.Unlock()
+}
+
+func (h *Hub) jwtKeysChanged() {
+	h.onJWTKeysMu.Lock()
+	reload := h.onJWTKeys
+	h.onJWTKeysMu.Unlock()
+	if reload != nil {
+		reload()
+	}
+}
+
+// JWTKeyInfo describes a signing key, without its secret.
+type JWTKeyInfo struct {
+	db.JWTKey
+	Signing bool `json:"signing"` // signs new tokens
+}
+
+func (h *Handler) jwtKeyInfo() ([]JWTKeyInfo, error) {
+	keys, err := h.db.ListJWTKeys()
+	if err != nil {
+		return nil, err
+	}
+	signing := h.auth.SigningKeyID()
+	infos := make([]JWTKeyInfo, len(keys))
+	for i, k := range keys {
+		infos[i] = JWTKeyInfo{JWTKey: k, Signing: k.ID == signing}
+	}
+	return infos, nil
+}
+
+// ListJWTKeys handles GET /api/admin/jwt-keys (owners): the signing keys,
+// retired ones included.
+func (h *Handler) ListJWTKeys(w http.ResponseWriter, r *http.Request) {
+	if _, isOwner := h.requireOwner(w, r); !isOwner {
+		return
+	}
+	keys, err := h.jwtKeyInfo()
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list keys")
+		return
+	}
+	ok(w, keys)
+}
+
+// AddJWTKey handles POST /api/admin/jwt-keys (owners): a new key, which
+// signs tokens from now on.
+func (h *Handler) AddJWTKey(w http.ResponseWriter, r *http.Request) {
+	u, isOwner := h.requireOwner(w, r)
+	if !isOwner {
+		return
+	}
+	k, err := h.db.AddJWTKey()
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to add key")
+		return
+	}
+	h.publishJWTKeys()
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "jwt_key.add", TargetID: k.ID, Details: "added token signing key " + k.ID})
+	created(w, JWTKeyInfo{JWTKey: *k, Signing: h.auth.SigningKeyID() == k.ID})
+}
+
+// RetireJWTKey handles DELETE /api/admin/jwt-keys/{id} (owners): tokens
+// signed with the key stop working.  The only key left can't be retired.
+func (h *Handler) RetireJWTKey(w http.ResponseWriter, r *http.Request) {
+	u, isOwner := h.requireOwner(w, r)
+	if !isOwner {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	switch err := h.db.RetireJWTKey(id); {
+	case errors.Is(err, sql.ErrNoRows):
+		errResp(w, http.StatusNotFound, "no such key in use")
+		return
+	case errors.Is(err, db.ErrLastJWTKey):
+		errResp(w, http.StatusConflict, "add a new key before retiring the last one")
+		return
+	case err != nil:
+		errResp(w, http.StatusInternalServerError, "failed to retire key")
+		return
+	}
+	h.publishJWTKeys()
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "jwt_key.retire", TargetID: id, Details: "retired token signing key " + id})
+	keys, err := h.jwtKeyInfo()
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list keys")
+		return
+	}
+	ok(w, keys)
+}
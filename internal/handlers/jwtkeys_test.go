@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"chirm/internal/auth"
+	"chirm/internal/db"
+)
+
+// newClusterTestHandler returns a Handler on the database at path, as
+// cluster instance instanceID.
+func newClusterTestHandler(t *testing.T, path, instanceID string) *Handler {
+	t.Helper()
+	database, err := db.Open(path, db.PoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	hub := NewHub(database, "")
+	hub.EnableCluster(database, instanceID)
+	return New(database, auth.New("0123456789abcdef0123456789abcdef"), hub, filepath.Dir(path))
+}
+
+func TestRetiredJWTKeyRejectedAcrossCluster(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chirm.db")
+	a := newClusterTestHandler(t, path, "a")
+	b := newClusterTestHandler(t, path, "b")
+
+	token, err := a.auth.GenerateToken("u1", "alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.auth.ValidateToken(token); err != nil {
+		t.Fatalf("b refused a token before the key was retired: %v", err)
+	}
+
+	k, err := a.db.AddJWTKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.publishJWTKeys()
+	if err := a.db.RetireJWTKey(auth.EnvKeyID); err != nil {
+		t.Fatal(err)
+	}
+	a.publishJWTKeys()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := b.auth.ValidateToken(token)
+		if err != nil && b.auth.SigningKeyID() == k.ID {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("b still accepts the retired key's token (err %v) or signs with %s, not %s", err, b.auth.SigningKeyID(), k.ID)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -1,27 +1,18 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/url"
-	"regexp"
+	"slices"
 	"strings"
-	"sync"
-	"time"
-)
-
-// ─── Cache ────────────────────────────────────────────────────────────────────
-
-type previewEntry struct {
-	data      LinkPreview
-	fetchedAt time.Time
-}
 
-var (
-	previewCache   sync.Map        // key: normalised URL → previewEntry
-	previewTTL     = 2 * time.Hour // re-fetch after this long
-	previewTimeout = 6 * time.Second
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
 )
 
 // ─── Model ────────────────────────────────────────────────────────────────────
@@ -32,51 +23,118 @@ type LinkPreview struct {
 	Description string `json:"description,omitempty"`
 	Image       string `json:"image,omitempty"`
 	SiteName    string `json:"site_name,omitempty"`
+	ImageWidth  int    `json:"image_width,omitempty"`
+	ImageHeight int    `json:"image_height,omitempty"`
 	Favicon     string `json:"favicon,omitempty"`
+	Embed       *Embed `json:"embed,omitempty"`
 	Error       string `json:"error,omitempty"`
 }
 
-// ─── OG regex helpers ─────────────────────────────────────────────────────────
-
-var (
-	reOGTitle       = buildMetaRe(`og:title`, `twitter:title`)
-	reOGDesc        = buildMetaRe(`og:description`, `twitter:description`)
-	reOGImage       = buildMetaRe(`og:image`, `twitter:image`, `twitter:image:src`)
-	reOGSite        = buildMetaRe(`og:site_name`, `twitter:site`)
-	reMetaDesc      = regexp.MustCompile(`(?i)<meta[^>]+name=["']description["'][^>]+content=["']([^"']+)["']`)
-	reMetaDescAlt   = regexp.MustCompile(`(?i)<meta[^>]+content=["']([^"']+)["'][^>]+name=["']description["']`)
-	reTitle         = regexp.MustCompile(`(?i)<title[^>]*>([^<]+)</title>`)
-	reLinkIcon      = regexp.MustCompile(`(?i)<link[^>]+rel=["'][^"']*(?:shortcut )?icon[^"']*["'][^>]+href=["']([^"']+)["']`)
-	reLinkIconAlt   = regexp.MustCompile(`(?i)<link[^>]+href=["']([^"']+)["'][^>]+rel=["'][^"']*(?:shortcut )?icon[^"']*["']`)
-)
+// ─── Page metadata ────────────────────────────────────────────────────────────
+
+// pageMeta is what a page's markup says about it.
+type pageMeta struct {
+	meta   map[string]string // <meta property|name=... content=...>, first wins
+	title  string
+	icon   string // <link rel="icon">
+	oembed string // <link rel="alternate" type="application/json+oembed">
+	base   *url.URL
+}
 
-func buildMetaRe(props ...string) *regexp.Regexp {
-	// Matches: <meta property="og:title" content="..."> and alternate attr order
-	var alts []string
-	for _, p := range props {
-		ep := regexp.QuoteMeta(p)
-		alts = append(alts,
-			// property/name before content
-			`(?i)<meta[^>]+(?:property|name)=["']`+ep+`["'][^>]+content=["']([^"']{1,400})["']`,
-			// content before property/name
-			`(?i)<meta[^>]+content=["']([^"']{1,400})["'][^>]+(?:property|name)=["']`+ep+`["']`,
-		)
-	}
-	return regexp.MustCompile(`(?:` + strings.Join(alts, `|`) + `)`)
+// get returns the first of keys the page has a meta tag for.
+func (m *pageMeta) get(keys ...string) string {
+	for _, k := range keys {
+		if v := strings.TrimSpace(m.meta[k]); v != "" {
+			return v
+		}
+	}
+	return ""
 }
 
-func firstGroup(re *regexp.Regexp, body string) string {
-	m := re.FindStringSubmatch(body)
-	if m == nil {
+// resolve makes a URL from the page absolute, against its <base href>.
+func (m *pageMeta) resolve(ref string) string {
+	if ref == "" {
 		return ""
 	}
-	// Walk sub-groups to find first non-empty
-	for _, g := range m[1:] {
-		if g != "" {
-			return strings.TrimSpace(g)
+	return resolveURL(m.base, ref)
+}
+
+// parsePage tokenizes an HTML document, converted to UTF-8 from the
+// charset its Content-Type or <meta charset> gives, and collects its
+// metadata.  pageURL is the address it was fetched from, after redirects.
+func parsePage(r io.Reader, contentType string, pageURL *url.URL) (*pageMeta, error) {
+	m := &pageMeta{meta: map[string]string{}, base: pageURL}
+	utf8Body, err := charset.NewReader(r, contentType)
+	if err != nil {
+		return nil, err
+	}
+	z := html.NewTokenizer(utf8Body)
+	inTitle, haveBase, haveTitle := false, false, false
+	var altIcon string
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if m.icon == "" {
+				m.icon = altIcon
+			}
+			m.icon = m.resolve(m.icon)
+			m.oembed = m.resolve(m.oembed)
+			return m, nil
+
+		case html.TextToken:
+			if inTitle && !haveTitle {
+				m.title += string(z.Text())
+			}
+
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "title" && inTitle {
+				inTitle, haveTitle = false, true
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			attrs := map[string]string{}
+			for hasAttr {
+				var k, v []byte
+				k, v, hasAttr = z.TagAttr()
+				if _, seen := attrs[string(k)]; !seen {
+					attrs[string(k)] = string(v)
+				}
+			}
+			switch string(name) {
+			case "title":
+				inTitle = !haveTitle
+			case "base":
+				if href := attrs["href"]; href != "" && !haveBase {
+					if u, err := url.Parse(href); err == nil {
+						m.base, haveBase = pageURL.ResolveReference(u), true
+					}
+				}
+			case "meta":
+				key := strings.ToLower(attrs["property"])
+				if key == "" {
+					key = strings.ToLower(attrs["name"])
+				}
+				if content, ok := attrs["content"]; ok && key != "" {
+					if _, seen := m.meta[key]; !seen {
+						m.meta[key] = content
+					}
+				}
+			case "link":
+				rels := strings.Fields(strings.ToLower(attrs["rel"]))
+				href := strings.TrimSpace(attrs["href"])
+				switch {
+				case href == "":
+				case slices.Contains(rels, "icon") && m.icon == "":
+					m.icon = href
+				case slices.Contains(rels, "apple-touch-icon") && altIcon == "":
+					altIcon = href
+				case slices.Contains(rels, "alternate") && strings.EqualFold(attrs["type"], "application/json+oembed") && m.oembed == "":
+					m.oembed = href
+				}
+			}
 		}
 	}
-	return ""
 }
 
 // ─── Scraper ──────────────────────────────────────────────────────────────────
@@ -91,22 +149,40 @@ var previewClient = &http.Client{
 	},
 }
 
-func fetchPreview(rawURL string) LinkPreview {
-	// Check cache
-	if v, ok := previewCache.Load(rawURL); ok {
-		e := v.(previewEntry)
-		if time.Since(e.fetchedAt) < previewTTL {
-			return e.data
-		}
+// fetchPreview returns the preview for rawURL, from the cache if it's
+// there.
+func (h *Handler) fetchPreview(rawURL string) LinkPreview {
+	host := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		host = strings.ToLower(u.Hostname())
+	}
+	policy := h.previewPolicy()
+	if !policy.allows(host) {
+		return LinkPreview{URL: rawURL, Error: errPreviewBlocked.Error()}
+	}
+	if pv, ok := h.previews.get(rawURL); ok {
+		return pv
+	}
+	if h.previews.hostDown(host) {
+		return LinkPreview{URL: rawURL, Error: "site unavailable"}
 	}
 
-	pv := scrapePreview(rawURL)
+	pv := scrapePreview(rawURL, policy)
+	h.cachePreviewImages(&pv)
 
-	previewCache.Store(rawURL, previewEntry{data: pv, fetchedAt: time.Now()})
+	failed := pv.Error == "fetch failed"
+	h.previews.hostResult(host, failed)
+	// Failures aren't kept; the host's backoff stands in for them.  Nor
+	// are redirects the policy stopped, which it may later allow.
+	if !failed && pv.Error != errPreviewBlocked.Error() {
+		h.previews.put(rawURL, pv)
+	}
 	return pv
 }
 
-func scrapePreview(rawURL string) LinkPreview {
+// scrapePreview fetches rawURL and reads its preview, following redirects
+// only to sites policy allows.
+func scrapePreview(rawURL string, policy previewPolicy) LinkPreview {
 	pv := LinkPreview{URL: rawURL}
 
 	parsed, err := url.Parse(rawURL)
@@ -115,6 +191,17 @@ func scrapePreview(rawURL string) LinkPreview {
 		return pv
 	}
 
+	// Videos on sites we know play in the chat; their oEmbed endpoint
+	// gives the title and thumbnail without scraping the page.
+	if embed, endpoint := knownEmbed(parsed); embed != nil {
+		pv.Embed = embed
+		pv.SiteName = embed.Provider
+		if o, err := fetchOEmbed(endpoint + url.QueryEscape(rawURL)); err == nil {
+			applyOEmbed(&pv, o)
+			return pv
+		}
+	}
+
 	req, err := http.NewRequest("GET", rawURL, nil)
 	if err != nil {
 		pv.Error = "request error"
@@ -124,12 +211,27 @@ func scrapePreview(rawURL string) LinkPreview {
 	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.8")
 	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
 
-	resp, err := previewClient.Do(req)
+	client := *previewClient
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if !policy.allows(req.URL.Hostname()) {
+			return errPreviewBlocked
+		}
+		return previewClient.CheckRedirect(req, via)
+	}
+	resp, err := client.Do(req)
+	if errors.Is(err, errPreviewBlocked) {
+		pv.Error = errPreviewBlocked.Error()
+		return pv
+	}
 	if err != nil {
 		pv.Error = "fetch failed"
 		return pv
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		pv.Error = "fetch failed"
+		return pv
+	}
 
 	// Only parse HTML-ish responses; skip binary/media
 	ct := resp.Header.Get("Content-Type")
@@ -139,47 +241,41 @@ func scrapePreview(rawURL string) LinkPreview {
 	}
 
 	// Read up to 256KB — enough for any <head> section
-	lr := io.LimitReader(resp.Body, 256*1024)
-	bodyBytes, err := io.ReadAll(lr)
+	page, err := parsePage(io.LimitReader(resp.Body, 256*1024), ct, resp.Request.URL)
 	if err != nil {
 		pv.Error = "read error"
 		return pv
 	}
-	body := string(bodyBytes)
 
 	// Extract fields
-	pv.Title = firstGroup(reOGTitle, body)
-	pv.Description = firstGroup(reOGDesc, body)
-	pv.Image = firstGroup(reOGImage, body)
-	pv.SiteName = firstGroup(reOGSite, body)
+	pv.Title = page.get("og:title", "twitter:title")
+	pv.Description = page.get("og:description", "twitter:description", "description")
+	pv.Image = page.resolve(page.get("og:image", "og:image:url", "og:image:secure_url", "twitter:image", "twitter:image:src"))
+	pv.SiteName = page.get("og:site_name", "twitter:site")
+
+	// Playable embeds: PeerTube from the page itself, anything else
+	// through the oEmbed endpoint the page advertises.
+	if pv.Embed == nil {
+		pv.Embed = peerTubeEmbed(parsed, page)
+	}
+	if page.oembed != "" {
+		if o, err := fetchOEmbed(page.oembed); err == nil {
+			applyOEmbed(&pv, o)
+		}
+	}
 
 	// Fallbacks
 	if pv.Title == "" {
-		pv.Title = strings.TrimSpace(firstGroup(reTitle, body))
-	}
-	if pv.Description == "" {
-		pv.Description = firstGroup(reMetaDesc, body)
-	}
-	if pv.Description == "" {
-		pv.Description = firstGroup(reMetaDescAlt, body)
+		pv.Title = strings.Join(strings.Fields(page.title), " ")
 	}
 	if pv.SiteName == "" && parsed.Host != "" {
 		pv.SiteName = strings.TrimPrefix(parsed.Host, "www.")
 	}
 
 	// Favicon
-	favicon := firstGroup(reLinkIcon, body)
-	if favicon == "" {
-		favicon = firstGroup(reLinkIconAlt, body)
-	}
-	if favicon == "" {
-		favicon = "/favicon.ico"
-	}
-	pv.Favicon = resolveURL(parsed, favicon)
-
-	// Resolve relative image URL
-	if pv.Image != "" && !strings.HasPrefix(pv.Image, "http") {
-		pv.Image = resolveURL(parsed, pv.Image)
+	pv.Favicon = page.icon
+	if pv.Favicon == "" {
+		pv.Favicon = resolveURL(resp.Request.URL, "/favicon.ico")
 	}
 
 	// Truncate description
@@ -191,6 +287,47 @@ func scrapePreview(rawURL string) LinkPreview {
 	return pv
 }
 
+// ─── Preview images ──────────────────────────────────────────────────────────
+
+const (
+	previewImageMinEdge   = 16
+	previewImageMaxAspect = 10
+)
+
+// cachePreviewImages puts pv's image and favicon in the image proxy's
+// cache, so readers get them from there even once the site is gone, and
+// reads the image's size so clients can make room for it before it loads.
+// The image is dropped if the proxy wouldn't pass it on, or its size is
+// absurd: a tracking pixel, a sliver, or too many pixels to draw.
+func (h *Handler) cachePreviewImages(pv *LinkPreview) {
+	ctx, cancel := context.WithTimeout(context.Background(), previewTimeout)
+	defer cancel()
+	if pv.Favicon != "" {
+		h.proxiedImage(ctx, pv.Favicon)
+	}
+	if pv.Image == "" {
+		return
+	}
+	data, err := h.proxiedImage(ctx, pv.Image)
+	if err != nil {
+		var netErr *url.Error
+		if !errors.As(err, &netErr) && err != errImageUnavailable {
+			pv.Image = "" // not an image, or not there
+		}
+		return // otherwise the proxy will try again when it's viewed
+	}
+	width, height := imageDimensions(bytes.NewReader(data))
+	if width == 0 || height == 0 {
+		return
+	}
+	if min(width, height) < previewImageMinEdge || width*height > maxThumbnailPixels ||
+		max(width, height) > previewImageMaxAspect*min(width, height) {
+		pv.Image = ""
+		return
+	}
+	pv.ImageWidth, pv.ImageHeight = width, height
+}
+
 func resolveURL(base *url.URL, ref string) string {
 	r, err := url.Parse(ref)
 	if err != nil {
@@ -215,7 +352,10 @@ func (h *Handler) LinkPreview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pv := fetchPreview(rawURL)
+	pv := h.fetchPreview(rawURL)
+	// Readers load preview images through the proxy, not from the site.
+	pv.Image = proxiedImageURL(pv.Image)
+	pv.Favicon = proxiedImageURL(pv.Favicon)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Cache-Control", "public, max-age=3600")
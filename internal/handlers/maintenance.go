@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"chirm/internal/auth"
+	"chirm/internal/db"
+	mw "chirm/internal/middleware"
+)
+
+// ─── Maintenance mode ────────────────────────────────────────────────────────
+//
+// An admin can close the server to everyone else for a while, to take a
+// backup or try an upgrade without people writing to it meanwhile.  Other
+// users' requests are answered 503 — a page saying so for browsers, JSON
+// for the API — and their WebSockets are closed with the reason
+// "maintenance", so the app can show the page too.  Admins, and what it
+// takes to sign in, carry on as usual.  It's kept in settings, so it
+// survives a restart.
+
+// maintenanceRetry is the Retry-After given to refused clients.
+const maintenanceRetry = "120"
+
+// MaintenanceState is the body of GET and PUT /api/maintenance.
+type MaintenanceState struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message"`
+	Since   *time.Time `json:"since,omitempty"` // when it was turned on
+}
+
+// loadMaintenance reads the saved state into h.
+func (h *Handler) loadMaintenance() {
+	m := &MaintenanceState{}
+	v, _ := h.db.GetSetting("maintenance_mode")
+	m.Enabled = v == "1"
+	m.Message, _ = h.db.GetSetting("maintenance_message")
+	if v, _ := h.db.GetSetting("maintenance_since"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			m.Since = &t
+		}
+	}
+	h.maintenance.Store(m)
+}
+
+// maintenanceOpen lists what everyone may use during maintenance: the
+// login page and what it loads, and the API routes it calls.
+var maintenanceOpen = struct{ prefixes, api []string }{
+	prefixes: []string{"/css/", "/js/", "/assets/", "/sw.js", "/manifest.json", "/ca-cert", "/login", "/.well-known/"},
+	api:      []string{"/setup/status", "/public-settings", "/discovery", "/maintenance", "/auth/login", "/auth/logout"},
+}
+
+func maintenanceExempt(path string) bool {
+	for _, p := range maintenanceOpen.prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	rest, found := strings.CutPrefix(path, "/api/v1")
+	if !found {
+		rest, found = strings.CutPrefix(path, "/api")
+	}
+	if found {
+		for _, p := range maintenanceOpen.api {
+			if rest == p {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isAdminRequest reports whether r is signed in as someone with Manage
+// Server.  It runs ahead of mw.Auth, so reads the token itself.
+func (h *Handler) isAdminRequest(r *http.Request) bool {
+	var claims *auth.Claims
+	var err error
+	if bot := mw.BotToken(r); bot != "" {
+		claims, err = h.auth.ValidateBotToken(bot)
+	} else if token := mw.Token(r); token != "" {
+		claims, err = h.auth.ValidateToken(token)
+	} else {
+		return false
+	}
+	if err != nil {
+		return false
+	}
+	u, err := h.db.GetUserByID(claims.UserID)
+	return err == nil && h.db.HasPermission(u, db.PermManageServer)
+}
+
+// MaintenanceGate refuses everyone but admins while the server is in
+// maintenance.
+func (h *Handler) MaintenanceGate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := h.maintenance.Load()
+		if m == nil || !m.Enabled || maintenanceExempt(r.URL.Path) || h.isAdminRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", maintenanceRetry)
+		w.Header().Set("Cache-Control", "no-store")
+		if strings.HasPrefix(r.URL.Path, "/api") || r.URL.Path == "/ws" || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			respond(w, http.StatusServiceUnavailable, map[string]string{"error": "maintenance", "message": m.Message})
+			return
+		}
+		name, _ := h.db.GetSetting("server_name")
+		if name == "" {
+			name = "Chirm"
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		maintenancePage.Execute(w, map[string]string{"Name": name, "Message": m.Message})
+	})
+}
+
+// maintenancePage checks every so often whether maintenance is over, and
+// reloads into the app when it is.
+var maintenancePage = template.Must(template.New("maintenance").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Name}} — down for maintenance</title>
+<style>
+  body { margin: 0; min-height: 100vh; display: flex; align-items: center; justify-content: center;
+         background: #0f1117; color: #e8e9f3; font-family: system-ui, sans-serif; text-align: center; }
+  main { max-width: 420px; padding: 32px; }
+  h1 { font-size: 22px; margin: 0 0 12px; }
+  p { color: #9a9cb8; line-height: 1.5; margin: 0 0 12px; white-space: pre-wrap; }
+  a { color: #7c6af5; }
+</style>
+</head>
+<body>
+<main>
+  <h1>{{.Name}} is down for maintenance</h1>
+  {{if .Message}}<p>{{.Message}}</p>{{end}}
+  <p>It'll be back shortly; this page reloads by itself when it is.</p>
+  <p><a href="/login">Admin sign-in</a></p>
+</main>
+<script>
+setInterval(async () => {
+  try {
+    const res = await fetch('/api/v1/maintenance', { cache: 'no-store' });
+    if (res.ok && !(await res.json()).enabled) location.reload();
+  } catch {}
+}, 15000);
+</script>
+</body>
+</html>
+`))
+
+// GetMaintenance handles GET /api/maintenance.  Anyone may ask.
+func (h *Handler) GetMaintenance(w http.ResponseWriter, r *http.Request) {
+	ok(w, h.maintenance.Load())
+}
+
+// SetMaintenance handles PUT /api/maintenance (admin only).  Turning it on
+// closes the WebSocket of everyone who isn't an admin.
+func (h *Handler) SetMaintenance(w http.ResponseWriter, r *http.Request) {
+	u, isAdmin := h.requireAdmin(w, r)
+	if !isAdmin {
+		return
+	}
+	var req MaintenanceState
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if len(req.Message) > 500 {
+		errResp(w, http.StatusBadRequest, "message must be at most 500 characters")
+		return
+	}
+	prev := h.maintenance.Load()
+	m := &MaintenanceState{Enabled: req.Enabled, Message: req.Message}
+	if m.Enabled {
+		now := time.Now().UTC().Truncate(time.Second)
+		m.Since = &now
+		if prev.Enabled && prev.Since != nil {
+			m.Since = prev.Since
+		}
+	}
+
+	mode, since := "", ""
+	if m.Enabled {
+		mode, since = "1", m.Since.Format(time.RFC3339)
+	}
+	for k, v := range map[string]string{"maintenance_mode": mode, "maintenance_message": m.Message, "maintenance_since": since} {
+		if err := h.db.SetSetting(k, v); err != nil {
+			errResp(w, http.StatusInternalServerError, "failed to save maintenance mode")
+			return
+		}
+	}
+	h.maintenance.Store(m)
+
+	if m.Enabled != prev.Enabled {
+		action := "maintenance.end"
+		if m.Enabled {
+			action = "maintenance.start"
+		}
+		h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: action, Details: m.Message})
+	}
+	h.hub.Broadcast(WSEvent{Type: "maintenance", Data: m})
+	if m.Enabled {
+		h.hub.closeForMaintenance()
+	}
+	ok(w, m)
+}
+
+// closeForMaintenance closes the connections of everyone but admins, with
+// a close frame saying why so the app doesn't just reconnect.  Their read
+// loops then unregister them as usual.
+func (h *Hub) closeForMaintenance() {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "maintenance")
+	for _, client := range clients {
+		if u, err := h.db.GetUserByID(client.userID); err == nil && h.db.HasPermission(u, db.PermManageServer) {
+			continue
+		}
+		client.log.Info("ws closed for maintenance")
+		if client.conn != nil {
+			client.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		} else {
+			client.irc.send("ERROR :Closing link (the server is down for maintenance)")
+		}
+		client.close()
+	}
+}
@@ -12,6 +12,11 @@ import (
 )
 
 func (h *Handler) GetMessages(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
 	channelID := chi.URLParam(r, "id")
 	before := r.URL.Query().Get("before")
 	limit := 50
@@ -20,8 +25,7 @@ func (h *Handler) GetMessages(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verify channel exists
-	if _, err := h.db.GetChannelByID(channelID); err != nil {
-		errResp(w, http.StatusNotFound, "channel not found")
+	if _, visible := h.visibleChannel(w, u, channelID); !visible {
 		return
 	}
 
@@ -36,6 +40,16 @@ func (h *Handler) GetMessages(w http.ResponseWriter, r *http.Request) {
 	ok(w, msgs)
 }
 
+// SendMessageRequest is the body of POST /api/channels/{id}/messages.
+type SendMessageRequest struct {
+	Content     string   `json:"content"`
+	Attachments []string `json:"attachments"` // attachment IDs
+	ReplyToID   *string  `json:"reply_to_id"`
+	StickerID   string   `json:"sticker_id"`
+	// No link previews, as for links written <https://...>
+	SuppressEmbeds bool `json:"suppress_embeds"`
+}
+
 func (h *Handler) SendMessage(w http.ResponseWriter, r *http.Request) {
 	u, err := h.currentUser(r)
 	if err != nil || u == nil {
@@ -43,29 +57,31 @@ func (h *Handler) SendMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !h.db.HasPermission(u, db.PermSendMessages) {
+	channelID := chi.URLParam(r, "id")
+	ch, visible := h.visibleChannel(w, u, channelID)
+	if !visible {
+		return
+	}
+	if !h.hasChannelPermission(u, channelID, db.PermSendMessages) {
 		errResp(w, http.StatusForbidden, "no permission to send messages")
 		return
 	}
-
-	channelID := chi.URLParam(r, "id")
-	if _, err := h.db.GetChannelByID(channelID); err != nil {
-		errResp(w, http.StatusNotFound, "channel not found")
+	if h.mustAcceptRules(u) {
+		errResp(w, http.StatusForbidden, "accept the server rules before posting")
 		return
 	}
-
-	var req struct {
-		Content     string   `json:"content"`
-		Attachments []string `json:"attachments"` // attachment IDs
-		ReplyToID   *string  `json:"reply_to_id"`
+	if h.automodTimedOut(w, u, ch.GuildID) {
+		return
 	}
+
+	var req SendMessageRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errResp(w, http.StatusBadRequest, "invalid request")
 		return
 	}
 
 	req.Content = strings.TrimSpace(req.Content)
-	if req.Content == "" && len(req.Attachments) == 0 {
+	if req.Content == "" && len(req.Attachments) == 0 && req.StickerID == "" {
 		errResp(w, http.StatusBadRequest, "message cannot be empty")
 		return
 	}
@@ -73,12 +89,32 @@ func (h *Handler) SendMessage(w http.ResponseWriter, r *http.Request) {
 		errResp(w, http.StatusBadRequest, "message too long")
 		return
 	}
+	verdict := h.automodCheck(u, ch, req.Content, true)
+	if verdict != nil && verdict.Action.Delete {
+		h.applyAutomod(verdict, u, ch, req.Content, "")
+		errResp(w, http.StatusForbidden, "blocked by auto-moderation: "+verdict.Reason())
+		return
+	}
+
+	if req.StickerID != "" {
+		if _, err := h.db.GetStickerByID(req.StickerID); err != nil {
+			errResp(w, http.StatusBadRequest, "sticker not found")
+			return
+		}
+	}
 
 	msg, err := h.db.CreateMessage(channelID, u.ID, req.Content, req.ReplyToID)
 	if err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to send message")
 		return
 	}
+	if req.StickerID != "" {
+		h.db.SetMessageSticker(msg.ID, req.StickerID)
+	}
+	if req.SuppressEmbeds {
+		h.db.SetSuppressEmbeds(msg.ID, true)
+		msg.SuppressEmbeds = true
+	}
 
 	// Link any pre-uploaded attachments to this message
 	for _, attID := range req.Attachments {
@@ -87,19 +123,33 @@ func (h *Handler) SendMessage(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Re-fetch so the response includes attachment data
-	if len(req.Attachments) > 0 {
+	// Re-fetch so the response includes attachment and sticker data
+	if len(req.Attachments) > 0 || req.StickerID != "" {
 		if full, err := h.db.GetMessageByID(msg.ID); err == nil {
 			msg = full
 		}
 	}
 
+	h.publishMessage(msg, u.ID)
+	if verdict != nil {
+		h.applyAutomod(verdict, u, ch, msg.Content, msg.ID)
+	}
+	h.runAutomations(db.TriggerMessage, automationEvent{GuildID: ch.GuildID, User: u, Channel: ch, Message: msg})
+	created(w, msg)
+}
+
+// publishMessage sends a new message out: to the channel's subscribers,
+// as activity to the rest of its guild, and as push notifications, except to
+// senderID.  Its link previews follow in a message.embed_update.
+func (h *Handler) publishMessage(msg *db.Message, senderID string) {
+	h.queueEmbeds(msg)
+
 	// Broadcast to all channel subscribers (message.new is channel-scoped)
-	h.hub.BroadcastToChannel(channelID, WSEvent{Type: "message.new", Data: msg})
+	h.hub.BroadcastToChannel(msg.ChannelID, WSEvent{Type: "message.new", Data: msg})
 
 	// Resolve channel name and author for notifications
-	chObj, _ := h.db.GetChannelByID(channelID)
-	chName := channelID
+	chObj, _ := h.db.GetChannelByID(msg.ChannelID)
+	chName := msg.ChannelID
 	if chObj != nil {
 		chName = chObj.Name
 	}
@@ -113,10 +163,15 @@ func (h *Handler) SendMessage(w http.ResponseWriter, r *http.Request) {
 	}
 	authorID := msg.UserID
 
-	// Broadcast globally so ALL clients can update unread dots AND show in-app
-	// notifications — message.new only reaches the subscribed channel's clients.
-	h.hub.Broadcast(WSEvent{Type: "message.activity", Data: map[string]interface{}{
-		"channel_id":   channelID,
+	// Broadcast to the whole guild so its members' clients can update unread
+	// dots AND show in-app notifications — message.new only reaches the
+	// subscribed channel's clients.
+	guildID := db.DefaultGuild
+	if chObj != nil {
+		guildID = chObj.GuildID
+	}
+	h.hub.BroadcastToGuild(guildID, WSEvent{Type: "message.activity", Data: map[string]interface{}{
+		"channel_id":   msg.ChannelID,
 		"channel_name": chName,
 		"author_id":    authorID,
 		"author":       authorName,
@@ -125,15 +180,40 @@ func (h *Handler) SendMessage(w http.ResponseWriter, r *http.Request) {
 	}})
 
 	// Send Web Push notifications (background, non-blocking)
-	h.BroadcastPush(chName, u.ID, PushPayload{
-		Title:     authorName + " in #" + chName,
+	h.BroadcastPush(msg.ChannelID, senderID, msg.Content, PushPayload{
 		Body:      contentPreview,
-		ChannelID: channelID,
+		ChannelID: msg.ChannelID,
 		MessageID: msg.ID,
-		Tag:       "chirm-" + channelID,
+		Tag:       "chirm-" + msg.ChannelID,
+		Key:       pushKeyMessage,
+		Params:    map[string]string{"author": authorName, "channel": chName},
 	})
+	h.federate("message.new", msg)
+}
 
-	created(w, msg)
+// MarkChannelRead handles POST /api/channels/{id}/read: everything currently
+// in the channel counts as read for the caller.
+func (h *Handler) MarkChannelRead(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	channelID := chi.URLParam(r, "id")
+	if _, visible := h.visibleChannel(w, u, channelID); !visible {
+		return
+	}
+	if err := h.db.MarkChannelRead(u.ID, channelID); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to mark channel read")
+		return
+	}
+	h.pushes.markRead(u.ID, channelID)
+	ok(w, map[string]string{"status": "read"})
+}
+
+// AddReactionRequest is the body of POST /api/messages/{id}/reactions.
+type AddReactionRequest struct {
+	Emoji string `json:"emoji"`
 }
 
 func (h *Handler) AddReaction(w http.ResponseWriter, r *http.Request) {
@@ -145,14 +225,12 @@ func (h *Handler) AddReaction(w http.ResponseWriter, r *http.Request) {
 
 	msgID := chi.URLParam(r, "id")
 	msg, err := h.db.GetMessageByID(msgID)
-	if err != nil {
+	if err != nil || !h.db.CanAccessGuild(u, h.db.ChannelGuild(msg.ChannelID)) {
 		errResp(w, http.StatusNotFound, "message not found")
 		return
 	}
 
-	var req struct {
-		Emoji string `json:"emoji"`
-	}
+	var req AddReactionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Emoji == "" {
 		errResp(w, http.StatusBadRequest, "emoji required")
 		return
@@ -170,6 +248,9 @@ func (h *Handler) AddReaction(w http.ResponseWriter, r *http.Request) {
 		"reactions":  reactions,
 	}
 	h.hub.BroadcastToChannel(msg.ChannelID, WSEvent{Type: "reaction.update", Data: payload})
+	if ch, err := h.db.GetChannelByID(msg.ChannelID); err == nil {
+		h.runAutomations(db.TriggerReaction, automationEvent{GuildID: ch.GuildID, User: u, Channel: ch, Message: msg, Emoji: req.Emoji})
+	}
 	ok(w, payload)
 }
 
@@ -184,7 +265,7 @@ func (h *Handler) RemoveReaction(w http.ResponseWriter, r *http.Request) {
 	emoji := chi.URLParam(r, "emoji")
 
 	msg, err := h.db.GetMessageByID(msgID)
-	if err != nil {
+	if err != nil || !h.db.CanAccessGuild(u, h.db.ChannelGuild(msg.ChannelID)) {
 		errResp(w, http.StatusNotFound, "message not found")
 		return
 	}
@@ -204,6 +285,14 @@ func (h *Handler) RemoveReaction(w http.ResponseWriter, r *http.Request) {
 	ok(w, payload)
 }
 
+// EditMessageRequest is the body of PUT /api/messages/{id}.
+type EditMessageRequest struct {
+	Content string `json:"content"`
+	// Turns link previews off or back on; on its own, without
+	// content, it leaves the text as it is.
+	SuppressEmbeds *bool `json:"suppress_embeds"`
+}
+
 func (h *Handler) EditMessage(w http.ResponseWriter, r *http.Request) {
 	u, err := h.currentUser(r)
 	if err != nil || u == nil {
@@ -213,38 +302,75 @@ func (h *Handler) EditMessage(w http.ResponseWriter, r *http.Request) {
 
 	id := chi.URLParam(r, "id")
 	msg, err := h.db.GetMessageByID(id)
-	if err != nil {
+	if err != nil || !h.db.CanAccessGuild(u, h.db.ChannelGuild(msg.ChannelID)) {
 		errResp(w, http.StatusNotFound, "message not found")
 		return
 	}
 
 	// Author or admin can edit
-	if msg.UserID != u.ID && !h.db.HasPermission(u, db.PermManageMessages) {
+	if msg.UserID != u.ID && !h.hasChannelPermission(u, msg.ChannelID, db.PermManageMessages) {
 		errResp(w, http.StatusForbidden, "cannot edit this message")
 		return
 	}
-
-	var req struct {
-		Content string `json:"content"`
+	if msg.Type != "" {
+		errResp(w, http.StatusForbidden, "system messages cannot be edited")
+		return
+	}
+	if msg.FederationID != "" {
+		errResp(w, http.StatusForbidden, "messages from other servers cannot be edited here")
+		return
 	}
+
+	var req EditMessageRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errResp(w, http.StatusBadRequest, "invalid request")
 		return
 	}
 
 	req.Content = strings.TrimSpace(req.Content)
-	if req.Content == "" {
+	if req.Content == "" && req.SuppressEmbeds == nil {
 		errResp(w, http.StatusBadRequest, "content cannot be empty")
 		return
 	}
+	var verdict *automodVerdict
+	ch, _ := h.db.GetChannelByID(msg.ChannelID)
+	if ch != nil && req.Content != "" && req.Content != msg.Content {
+		if h.automodTimedOut(w, u, ch.GuildID) {
+			return
+		}
+		verdict = h.automodCheck(u, ch, req.Content, false)
+		if verdict != nil && verdict.Action.Delete {
+			h.applyAutomod(verdict, u, ch, req.Content, "")
+			errResp(w, http.StatusForbidden, "blocked by auto-moderation: "+verdict.Reason())
+			return
+		}
+	}
 
-	if err := h.db.EditMessage(id, req.Content); err != nil {
-		errResp(w, http.StatusInternalServerError, "failed to edit message")
-		return
+	if req.Content != "" {
+		if err := h.db.EditMessage(id, req.Content); err != nil {
+			errResp(w, http.StatusInternalServerError, "failed to edit message")
+			return
+		}
+	}
+	suppressChanged := req.SuppressEmbeds != nil && *req.SuppressEmbeds != msg.SuppressEmbeds
+	if suppressChanged {
+		if err := h.db.SetSuppressEmbeds(id, *req.SuppressEmbeds); err != nil {
+			errResp(w, http.StatusInternalServerError, "failed to edit message")
+			return
+		}
 	}
 
 	updated, _ := h.db.GetMessageByID(id)
+	if updated != nil && (updated.Content != msg.Content || suppressChanged) {
+		h.queueEmbeds(updated)
+	}
+	if updated != nil && updated.Content != msg.Content {
+		h.federate("message.edit", updated)
+	}
 	h.hub.BroadcastToChannel(msg.ChannelID, WSEvent{Type: "message.edit", Data: updated})
+	if verdict != nil {
+		h.applyAutomod(verdict, u, ch, req.Content, id)
+	}
 	ok(w, updated)
 }
 
@@ -257,12 +383,12 @@ func (h *Handler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
 
 	id := chi.URLParam(r, "id")
 	msg, err := h.db.GetMessageByID(id)
-	if err != nil {
+	if err != nil || !h.db.CanAccessGuild(u, h.db.ChannelGuild(msg.ChannelID)) {
 		errResp(w, http.StatusNotFound, "message not found")
 		return
 	}
 
-	if msg.UserID != u.ID && !h.db.HasPermission(u, db.PermManageMessages) {
+	if msg.UserID != u.ID && !h.hasChannelPermission(u, msg.ChannelID, db.PermManageMessages) {
 		errResp(w, http.StatusForbidden, "cannot delete this message")
 		return
 	}
@@ -274,5 +400,6 @@ func (h *Handler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.hub.BroadcastToChannel(channelID, WSEvent{Type: "message.delete", Data: map[string]string{"id": id, "channel_id": channelID}})
+	h.federate("message.delete", msg)
 	ok(w, map[string]string{"message": "deleted"})
 }
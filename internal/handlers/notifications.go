@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"chirm/internal/db"
+)
+
+// GetNotificationSettings handles GET /api/me/notifications.
+func (h *Handler) GetNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	s, err := h.db.GetNotificationSettings(u.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to load notification settings")
+		return
+	}
+	ok(w, s)
+}
+
+// UpdateNotificationSettings handles PUT /api/me/notifications.  The body
+// replaces the user's settings, per-channel levels included.
+func (h *Handler) UpdateNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	req := db.DefaultNotificationSettings() // fields the client omits keep their defaults
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if !db.ValidNotifyLevel(req.DefaultLevel) {
+		errResp(w, http.StatusBadRequest, "default_level must be all, mentions or none")
+		return
+	}
+	if req.Language != "" {
+		if req.Language = normalizeLanguage(req.Language); req.Language == "" {
+			errResp(w, http.StatusBadRequest, "unsupported language")
+			return
+		}
+	}
+	if !db.ValidDigest(req.Digest) {
+		errResp(w, http.StatusBadRequest, "digest must be daily, weekly or empty")
+		return
+	}
+	if (req.QuietStart == "") != (req.QuietEnd == "") {
+		errResp(w, http.StatusBadRequest, "quiet hours need both a start and an end")
+		return
+	}
+	if req.QuietStart != "" {
+		_, err1 := db.ParseClock(req.QuietStart)
+		_, err2 := db.ParseClock(req.QuietEnd)
+		if err1 != nil || err2 != nil {
+			errResp(w, http.StatusBadRequest, "quiet hours must be HH:MM")
+			return
+		}
+		if _, err := time.LoadLocation(req.QuietTZ); err != nil || req.QuietTZ == "" {
+			errResp(w, http.StatusBadRequest, "unknown timezone")
+			return
+		}
+	}
+	if req.Channels == nil {
+		req.Channels = map[string]string{}
+	}
+	for chID, level := range req.Channels {
+		if !db.ValidNotifyLevel(level) {
+			errResp(w, http.StatusBadRequest, "channel levels must be all, mentions or none")
+			return
+		}
+		if _, err := h.db.GetChannelByID(chID); err != nil {
+			delete(req.Channels, chID) // channel was deleted since the client loaded it
+		}
+	}
+	if err := h.db.SaveNotificationSettings(u.ID, req); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to save notification settings")
+		return
+	}
+	s, err := h.db.GetNotificationSettings(u.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to load notification settings")
+		return
+	}
+	ok(w, s)
+}
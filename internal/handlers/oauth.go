@@ -0,0 +1,704 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"chirm/internal/db"
+	"chirm/internal/logging"
+	mw "chirm/internal/middleware"
+)
+
+// ─── Sign in with Chirm ──────────────────────────────────────────────────────
+//
+// Chirm can be the identity provider for the rest of a homelab: any app
+// that speaks OpenID Connect (Grafana, Nextcloud, Gitea, an oauth2-proxy in
+// front of the rest) can send people here to sign in.  It's the
+// authorization code flow, with PKCE; admins register each app (see
+// oauthclients.go), and members are asked the first time an app wants to
+// know who they are.  An app can be kept to members with certain roles,
+// which is checked again every time it refreshes its tokens.
+
+const (
+	oauthCodeTTL    = 2 * time.Minute
+	oauthAccessTTL  = time.Hour
+	oauthRefreshTTL = 30 * 24 * time.Hour
+	oidcKeyBits     = 2048
+)
+
+// oauthScopes are what an app can ask for, in the order they're listed
+// on the consent page.
+var oauthScopes = []struct{ Name, Label string }{
+	{"openid", "Know who you are on this server"},
+	{"profile", "See your username and avatar"},
+	{"email", "See your email address"},
+	{"groups", "See your roles"},
+}
+
+func knownOAuthScope(s string) bool {
+	for _, sc := range oauthScopes {
+		if sc.Name == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasScope(scope, s string) bool {
+	for _, f := range strings.Fields(scope) {
+		if f == s {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeCovers reports whether granted includes everything in wanted.
+func scopeCovers(granted, wanted string) bool {
+	for _, s := range strings.Fields(wanted) {
+		if !hasScope(granted, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// oidcIssuer is the URL apps know this server by: PUBLIC_URL, or else the
+// address they reached it at.  The two need to agree, so set PUBLIC_URL.
+func (h *Handler) oidcIssuer(r *http.Request) string {
+	if h.discovery.PublicURL != "" {
+		return strings.TrimRight(h.discovery.PublicURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// OpenIDConfiguration handles GET /.well-known/openid-configuration, which
+// apps read to find everything else.
+func (h *Handler) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	iss := h.oidcIssuer(r)
+	scopes := make([]string, len(oauthScopes))
+	for i, s := range oauthScopes {
+		scopes[i] = s.Name
+	}
+	ok(w, map[string]interface{}{
+		"issuer":                                         iss,
+		"authorization_endpoint":                         iss + "/oauth/authorize",
+		"token_endpoint":                                 iss + "/oauth/token",
+		"userinfo_endpoint":                              iss + "/oauth/userinfo",
+		"jwks_uri":                                       iss + "/oauth/jwks",
+		"revocation_endpoint":                            iss + "/oauth/revoke",
+		"scopes_supported":                               scopes,
+		"response_types_supported":                       []string{"code"},
+		"response_modes_supported":                       []string{"query"},
+		"grant_types_supported":                          []string{"authorization_code", "refresh_token"},
+		"subject_types_supported":                        []string{"public"},
+		"id_token_signing_alg_values_supported":          []string{"RS256"},
+		"token_endpoint_auth_methods_supported":          []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":               []string{"S256"},
+		"claims_supported":                               []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "preferred_username", "name", "picture", "email", "groups"},
+		"authorization_response_iss_parameter_supported": true,
+	})
+}
+
+// ─── Signing keys ────────────────────────────────────────────────────────────
+
+type oidcKey struct {
+	id  string
+	key *rsa.PrivateKey
+}
+
+// oidcKeyMu keeps two first sign-ins from both making a key.
+var oidcKeyMu sync.Mutex
+
+// oidcKeys returns the keys ID tokens are signed with, the first being
+// the one to sign with; one is made the first time it's needed.
+func (h *Handler) oidcKeys() ([]oidcKey, error) {
+	oidcKeyMu.Lock()
+	defer oidcKeyMu.Unlock()
+	stored, err := h.db.ListOAuthKeys()
+	if errors.Is(err, db.ErrNoOAuthKey) {
+		key, genErr := rsa.GenerateKey(rand.Reader, oidcKeyBits)
+		if genErr != nil {
+			return nil, genErr
+		}
+		block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+		if err := h.db.AddOAuthKey(db.NewID(), string(pem.EncodeToMemory(block))); err != nil {
+			return nil, err
+		}
+		stored, err = h.db.ListOAuthKeys()
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []oidcKey
+	for _, k := range stored {
+		block, _ := pem.Decode([]byte(k.PrivateKey))
+		if block == nil {
+			continue
+		}
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, oidcKey{id: k.ID, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable ID token signing key")
+	}
+	return keys, nil
+}
+
+// OAuthJWKS handles GET /oauth/jwks: the public halves of the signing
+// keys, for apps to check ID tokens with.
+func (h *Handler) OAuthJWKS(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.oidcKeys()
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("oidc signing keys", "err", err)
+		errResp(w, http.StatusInternalServerError, "failed to load signing keys")
+		return
+	}
+	jwks := make([]map[string]string, 0, len(keys))
+	for _, k := range keys {
+		jwks = append(jwks, map[string]string{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": k.id,
+			"n":   base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+		})
+	}
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	ok(w, map[string]interface{}{"keys": jwks})
+}
+
+// ─── Authorization ───────────────────────────────────────────────────────────
+
+// oauthRequest is what an app asked /oauth/authorize for.
+type oauthRequest struct {
+	Client        *db.OAuthClient
+	RedirectURI   string
+	Scope         string
+	State         string
+	Nonce         string
+	CodeChallenge string
+	Prompt        string
+}
+
+// redirect sends the browser back to the app with params.
+func (req *oauthRequest) redirect(w http.ResponseWriter, r *http.Request, iss string, params url.Values) {
+	u, _ := url.Parse(req.RedirectURI)
+	q := u.Query()
+	for k, vs := range params {
+		q[k] = vs
+	}
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	q.Set("iss", iss)
+	u.RawQuery = q.Encode()
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// fail sends the browser back to the app with an error.
+func (req *oauthRequest) fail(w http.ResponseWriter, r *http.Request, iss, code, description string) {
+	req.redirect(w, r, iss, url.Values{"error": {code}, "error_description": {description}})
+}
+
+// parseOAuthRequest reads an authorization request from r's query or form.
+// Until the client and where to send people back to check out, there's
+// nowhere safe to send errors but the page itself, so those come back as
+// a message to show; the rest go back to the app.
+func (h *Handler) parseOAuthRequest(r *http.Request) (req *oauthRequest, pageErr, code, description string) {
+	client, err := h.db.GetOAuthClient(r.FormValue("client_id"))
+	if err != nil {
+		return nil, "This app isn't registered on this server.", "", ""
+	}
+	req = &oauthRequest{
+		Client:        client,
+		RedirectURI:   r.FormValue("redirect_uri"),
+		State:         r.FormValue("state"),
+		Nonce:         r.FormValue("nonce"),
+		CodeChallenge: r.FormValue("code_challenge"),
+		Prompt:        r.FormValue("prompt"),
+	}
+	if req.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		req.RedirectURI = client.RedirectURIs[0]
+	}
+	registered := false
+	for _, u := range client.RedirectURIs {
+		registered = registered || u == req.RedirectURI
+	}
+	if !registered {
+		return nil, "This app asked to send you back to an address it isn't registered with.", "", ""
+	}
+
+	if r.FormValue("response_type") != "code" {
+		return req, "", "unsupported_response_type", "only the code response type is supported"
+	}
+	if req.CodeChallenge != "" && r.FormValue("code_challenge_method") != "S256" {
+		return req, "", "invalid_request", "code_challenge_method must be S256"
+	}
+	if req.CodeChallenge == "" && client.Public {
+		return req, "", "invalid_request", "public clients must use PKCE"
+	}
+	var scopes []string
+	for _, s := range strings.Fields(r.FormValue("scope")) {
+		if !knownOAuthScope(s) || !hasScope(strings.Join(client.Scopes, " "), s) {
+			return req, "", "invalid_scope", "scope " + s + " is not allowed for this client"
+		}
+		if !hasScope(strings.Join(scopes, " "), s) {
+			scopes = append(scopes, s)
+		}
+	}
+	if len(scopes) == 0 {
+		return req, "", "invalid_scope", "no scope requested"
+	}
+	req.Scope = strings.Join(scopes, " ")
+	return req, "", "", ""
+}
+
+// oauthSession returns who's signed in to the browser, and when they did.
+// It's the session cookie only; tokens for scripts and bots don't count.
+func (h *Handler) oauthSession(r *http.Request) (*db.User, time.Time) {
+	token := mw.Token(r)
+	if token == "" {
+		return nil, time.Time{}
+	}
+	claims, err := h.auth.ValidateToken(token)
+	if err != nil || claims.Bot {
+		return nil, time.Time{}
+	}
+	u, err := h.db.GetUserByID(claims.UserID)
+	if err != nil || u.Bot {
+		return nil, time.Time{}
+	}
+	authTime := time.Now()
+	if claims.IssuedAt != nil {
+		authTime = claims.IssuedAt.Time
+	}
+	return u, authTime
+}
+
+// oauthAllowed reports whether u may use client: everyone may, unless the
+// client is kept to some roles.  Owners may use every app, and bots none.
+func oauthAllowed(client *db.OAuthClient, u *db.User) bool {
+	if u.Bot {
+		return false
+	}
+	if len(client.AllowedRoles) == 0 || u.IsOwner {
+		return true
+	}
+	for _, role := range u.Roles {
+		for _, id := range client.AllowedRoles {
+			if role.ID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// OAuthAuthorize handles GET and POST /oauth/authorize, where apps send
+// people to sign in.  GET asks them, unless they've said yes to the app
+// before; the consent page POSTs their answer back.
+func (h *Handler) OAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Cache-Control", "no-store")
+	iss := h.oidcIssuer(r)
+	req, pageErr, code, description := h.parseOAuthRequest(r)
+	if pageErr != "" {
+		h.oauthPage(w, http.StatusBadRequest, oauthPageData{Error: pageErr})
+		return
+	}
+	if code != "" {
+		req.fail(w, r, iss, code, description)
+		return
+	}
+
+	u, authTime := h.oauthSession(r)
+	if u == nil {
+		if req.Prompt == "none" {
+			req.fail(w, r, iss, "login_required", "not signed in")
+			return
+		}
+		next := r.URL.Path + "?" + r.URL.RawQuery
+		if r.Method == http.MethodPost {
+			next = r.URL.Path + "?" + r.PostForm.Encode()
+		}
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(next), http.StatusFound)
+		return
+	}
+	if !oauthAllowed(req.Client, u) {
+		if req.Prompt == "none" {
+			req.fail(w, r, iss, "access_denied", "not allowed to use this app")
+			return
+		}
+		h.oauthPage(w, http.StatusForbidden, oauthPageData{
+			App:   req.Client.Name,
+			User:  u.Username,
+			Error: "You don't have a role that's allowed to use " + req.Client.Name + ". Ask an admin if you think you should.",
+		})
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		// Only the consent page itself may answer for someone.
+		if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" {
+			h.oauthPage(w, http.StatusForbidden, oauthPageData{Error: "That request didn't come from this server."})
+			return
+		}
+		if r.PostFormValue("decision") != "allow" {
+			req.fail(w, r, iss, "access_denied", "the user said no")
+			return
+		}
+		if err := h.db.SaveOAuthConsent(u.ID, req.Client.ID, req.Scope); err != nil {
+			req.fail(w, r, iss, "server_error", "failed to save consent")
+			return
+		}
+	} else if req.Prompt == "consent" || !scopeCovers(h.db.OAuthConsentScope(u.ID, req.Client.ID), req.Scope) {
+		if req.Prompt == "none" {
+			req.fail(w, r, iss, "consent_required", "the user hasn't allowed this app yet")
+			return
+		}
+		data := oauthPageData{App: req.Client.Name, User: u.Username, Params: map[string]string{}}
+		for _, s := range oauthScopes {
+			if hasScope(req.Scope, s.Name) {
+				data.Scopes = append(data.Scopes, s.Label)
+			}
+		}
+		for _, k := range []string{"client_id", "redirect_uri", "response_type", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+			if v := r.FormValue(k); v != "" {
+				data.Params[k] = v
+			}
+		}
+		if redirect, err := url.Parse(req.RedirectURI); err == nil {
+			data.Host = redirect.Host
+		}
+		h.oauthPage(w, http.StatusOK, data)
+		return
+	}
+
+	code, err := h.db.CreateOAuthCode(db.OAuthGrant{
+		ClientID: req.Client.ID,
+		UserID:   u.ID,
+		Scope:    req.Scope,
+		AuthTime: authTime,
+		// As the app gave it: it has to give the same again for the
+		// tokens, or leave it out both times.
+		RedirectURI:   r.FormValue("redirect_uri"),
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+	}, oauthCodeTTL)
+	if err != nil {
+		req.fail(w, r, iss, "server_error", "failed to issue a code")
+		return
+	}
+	req.redirect(w, r, iss, url.Values{"code": {code}})
+}
+
+// oauthPageData fills oauthPageTmpl: the consent form, or an error.
+type oauthPageData struct {
+	Name   string // the server's
+	App    string
+	Host   string // the app sends people back to
+	User   string
+	Scopes []string
+	Params map[string]string // the request, to POST back
+	Error  string
+}
+
+func (h *Handler) oauthPage(w http.ResponseWriter, status int, data oauthPageData) {
+	data.Name, _ = h.db.GetSetting("server_name")
+	if data.Name == "" {
+		data.Name = "Chirm"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	oauthPageTmpl.Execute(w, data)
+}
+
+var oauthPageTmpl = template.Must(template.New("oauth").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if .App}}{{.App}} — {{end}}Sign in with {{.Name}}</title>
+<style>
+  body { margin: 0; min-height: 100vh; display: flex; align-items: center; justify-content: center;
+         background: #0f1117; color: #e8e9f3; font-family: system-ui, sans-serif; }
+  main { max-width: 420px; width: 100%; padding: 32px; box-sizing: border-box; }
+  h1 { font-size: 22px; margin: 0 0 12px; }
+  p { color: #9a9cb8; line-height: 1.5; margin: 0 0 12px; }
+  ul { margin: 0 0 20px; padding-left: 20px; line-height: 1.8; }
+  strong { color: #e8e9f3; }
+  .actions { display: flex; gap: 10px; }
+  button { flex: 1; padding: 10px; border-radius: 6px; border: 1px solid #2a2d3e; font-size: 15px; cursor: pointer;
+           background: #1a1d29; color: #e8e9f3; }
+  button.allow { background: #7c6af5; border-color: #7c6af5; color: #fff; }
+  a { color: #7c6af5; }
+</style>
+</head>
+<body>
+<main>
+{{if .Error}}
+  <h1>Can't sign in{{if .App}} to {{.App}}{{end}}</h1>
+  <p>{{.Error}}</p>
+  <p><a href="/">Back to {{.Name}}</a></p>
+{{else}}
+  <h1>Sign in to {{.App}} with {{.Name}}</h1>
+  <p>You're signed in as <strong>{{.User}}</strong>. {{.App}} would like to:</p>
+  <ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+  {{if .Host}}<p>You'll be sent back to <strong>{{.Host}}</strong>.</p>{{end}}
+  <form method="post" action="/oauth/authorize">
+    {{range $k, $v := .Params}}<input type="hidden" name="{{$k}}" value="{{$v}}">{{end}}
+    <div class="actions">
+      <button type="submit" name="decision" value="deny">Cancel</button>
+      <button type="submit" name="decision" value="allow" class="allow">Allow</button>
+    </div>
+  </form>
+  <p style="margin-top:16px">You can take this back any time in your settings, under Connected apps.</p>
+{{end}}
+</main>
+</body>
+</html>
+`))
+
+// ─── Tokens ──────────────────────────────────────────────────────────────────
+
+// oauthError answers the token, userinfo and revoke endpoints' errors the
+// way OAuth clients expect.
+func oauthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	respond(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+// oauthClient authenticates the app calling the token or revoke
+// endpoint: with its secret, in the Authorization header or the form, or
+// for a public client with its ID alone.
+func (h *Handler) oauthClient(r *http.Request) (*db.OAuthClient, bool) {
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		// The spec has them form-encoded inside the header.
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	client, err := h.db.GetOAuthClient(id)
+	if err != nil {
+		return nil, false
+	}
+	if client.Public {
+		return client, secret == ""
+	}
+	return client, client.CheckSecret(secret)
+}
+
+// OAuthToken handles POST /oauth/token, where apps trade a code, or a
+// refresh token, for tokens.
+func (h *Handler) OAuthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "invalid form")
+		return
+	}
+	client, authed := h.oauthClient(r)
+	if !authed {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+		return
+	}
+
+	var grant *db.OAuthGrant
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		g, err := h.db.TakeOAuthCode(r.PostFormValue("code"))
+		if err != nil || g.ClientID != client.ID || g.RedirectURI != r.PostFormValue("redirect_uri") {
+			oauthError(w, http.StatusBadRequest, "invalid_grant", "the code is invalid, used or expired")
+			return
+		}
+		if g.CodeChallenge != "" {
+			sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+			if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(g.CodeChallenge)) != 1 {
+				oauthError(w, http.StatusBadRequest, "invalid_grant", "code_verifier doesn't match")
+				return
+			}
+		}
+		grant = g
+	case "refresh_token":
+		refresh := r.PostFormValue("refresh_token")
+		g, err := h.db.GetOAuthToken(db.OAuthRefresh, refresh)
+		if err != nil || g.ClientID != client.ID {
+			oauthError(w, http.StatusBadRequest, "invalid_grant", "the refresh token is invalid or expired")
+			return
+		}
+		// Each refresh token is good once; the app gets a new one.
+		if found, err := h.db.DeleteOAuthToken(client.ID, refresh); err != nil || !found {
+			oauthError(w, http.StatusBadRequest, "invalid_grant", "the refresh token is invalid or expired")
+			return
+		}
+		if s := r.PostFormValue("scope"); s != "" {
+			if !scopeCovers(g.Scope, s) {
+				oauthError(w, http.StatusBadRequest, "invalid_scope", "can't ask for more than was granted")
+				return
+			}
+			g.Scope = strings.Join(strings.Fields(s), " ")
+		}
+		grant = g
+	default:
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+		return
+	}
+
+	// Roles change; someone who's lost the one an app needs is out.
+	u, err := h.db.GetUserByID(grant.UserID)
+	if err != nil || !oauthAllowed(client, u) {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the user may no longer use this app")
+		return
+	}
+
+	access, err := h.db.CreateOAuthToken(db.OAuthAccess, *grant, oauthAccessTTL)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "failed to issue tokens")
+		return
+	}
+	refresh, err := h.db.CreateOAuthToken(db.OAuthRefresh, *grant, oauthRefreshTTL)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "failed to issue tokens")
+		return
+	}
+	resp := map[string]interface{}{
+		"access_token":  access,
+		"token_type":    "Bearer",
+		"expires_in":    int(oauthAccessTTL.Seconds()),
+		"refresh_token": refresh,
+		"scope":         grant.Scope,
+	}
+	if hasScope(grant.Scope, "openid") {
+		idToken, err := h.idToken(r, client, u, grant)
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("signing id token", "err", err)
+			oauthError(w, http.StatusInternalServerError, "server_error", "failed to sign the ID token")
+			return
+		}
+		resp["id_token"] = idToken
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	ok(w, resp)
+}
+
+// oidcClaims are what scope lets an app know about u.
+func (h *Handler) oidcClaims(r *http.Request, u *db.User, scope string) map[string]interface{} {
+	claims := map[string]interface{}{"sub": u.ID}
+	if hasScope(scope, "profile") {
+		claims["preferred_username"] = u.Username
+		claims["name"] = u.Username
+		if u.Avatar != "" {
+			picture := u.Avatar
+			if strings.HasPrefix(picture, "/") {
+				picture = h.oidcIssuer(r) + picture
+			}
+			claims["picture"] = picture
+		}
+	}
+	if hasScope(scope, "email") && u.Email != "" {
+		claims["email"] = u.Email
+	}
+	if hasScope(scope, "groups") {
+		groups := []string{}
+		for _, role := range u.Roles {
+			groups = append(groups, role.Name)
+		}
+		claims["groups"] = groups
+	}
+	return claims
+}
+
+// idToken signs the ID token saying u signed in to client.
+func (h *Handler) idToken(r *http.Request, client *db.OAuthClient, u *db.User, g *db.OAuthGrant) (string, error) {
+	keys, err := h.oidcKeys()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := jwt.MapClaims(h.oidcClaims(r, u, g.Scope))
+	claims["iss"] = h.oidcIssuer(r)
+	claims["aud"] = client.ID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(oauthAccessTTL).Unix()
+	claims["auth_time"] = g.AuthTime.Unix()
+	if g.Nonce != "" {
+		claims["nonce"] = g.Nonce
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keys[0].id
+	return token.SignedString(keys[0].key)
+}
+
+// OAuthUserInfo handles GET and POST /oauth/userinfo: who an access
+// token's user is, as far as its scope says.
+func (h *Handler) OAuthUserInfo(w http.ResponseWriter, r *http.Request) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		respond(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+	g, err := h.db.GetOAuthToken(db.OAuthAccess, strings.TrimSpace(token))
+	var u *db.User
+	if err == nil {
+		u, err = h.db.GetUserByID(g.UserID)
+	}
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logging.FromContext(r.Context()).Warn("oauth userinfo", "err", err)
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		respond(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	ok(w, h.oidcClaims(r, u, g.Scope))
+}
+
+// OAuthRevoke handles POST /oauth/revoke, for apps signing someone out.
+// It says yes whether or not the token was any good, as the spec asks.
+func (h *Handler) OAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "invalid form")
+		return
+	}
+	client, authed := h.oauthClient(r)
+	if !authed {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+		return
+	}
+	if _, err := h.db.DeleteOAuthToken(client.ID, r.PostFormValue("token")); err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", "failed to revoke the token")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
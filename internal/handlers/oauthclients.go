@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+)
+
+// maxRedirectURIs is how many addresses one app may send people back to.
+const maxRedirectURIs = 10
+
+// defaultOAuthScopes are what a new app may ask for if the admin doesn't
+// say.
+var defaultOAuthScopes = []string{"openid", "profile", "email"}
+
+// OAuthClientRequest is the body of POST /api/oauth/clients and PUT
+// /api/oauth/clients/{id}.
+type OAuthClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`        // what it may ask for; openid, profile and email if left out
+	AllowedRoles []string `json:"allowed_roles"` // role IDs; everyone if left out
+	// A public client, like a single-page or mobile app, can't keep a
+	// secret and uses PKCE instead.  Only read when creating.
+	Public bool `json:"public"`
+}
+
+// validateOAuthClient tidies req, returning what's wrong with it, if
+// anything.
+func (h *Handler) validateOAuthClient(req *OAuthClientRequest) string {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 64 {
+		return "name must be 1-64 characters"
+	}
+	if len(req.RedirectURIs) == 0 || len(req.RedirectURIs) > maxRedirectURIs {
+		return "give 1-10 redirect URIs"
+	}
+	for i, raw := range req.RedirectURIs {
+		raw = strings.TrimSpace(raw)
+		u, err := url.Parse(raw)
+		if err != nil || u.Scheme == "" || u.Fragment != "" || len(raw) > 500 {
+			return "redirect URIs must be absolute URLs without a fragment"
+		}
+		switch strings.ToLower(u.Scheme) {
+		case "javascript", "data", "vbscript", "file":
+			return "redirect URIs can't use " + u.Scheme + ":"
+		case "http", "https":
+			if u.Host == "" {
+				return "redirect URIs must be absolute URLs without a fragment"
+			}
+		}
+		req.RedirectURIs[i] = raw
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = defaultOAuthScopes
+	}
+	for _, s := range req.Scopes {
+		if !knownOAuthScope(s) {
+			return "unknown scope " + s
+		}
+	}
+	if req.AllowedRoles == nil {
+		req.AllowedRoles = []string{}
+	}
+	for _, id := range req.AllowedRoles {
+		role, err := h.db.GetRoleByID(id)
+		if err != nil || role.GuildID != db.DefaultGuild {
+			return "unknown role " + id
+		}
+	}
+	return ""
+}
+
+// oauthClientCreated is a new client, or a new secret, shown this once.
+type oauthClientCreated struct {
+	db.OAuthClient
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// ListOAuthClients handles GET /api/oauth/clients (admin only).
+func (h *Handler) ListOAuthClients(w http.ResponseWriter, r *http.Request) {
+	if _, isAdmin := h.requireAdmin(w, r); !isAdmin {
+		return
+	}
+	clients, err := h.db.ListOAuthClients()
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list apps")
+		return
+	}
+	ok(w, clients)
+}
+
+// CreateOAuthClient handles POST /api/oauth/clients (admin only): a new
+// app people can sign in to, with its secret.
+func (h *Handler) CreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	u, isAdmin := h.requireAdmin(w, r)
+	if !isAdmin {
+		return
+	}
+	var req OAuthClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if msg := h.validateOAuthClient(&req); msg != "" {
+		errResp(w, http.StatusBadRequest, msg)
+		return
+	}
+	client, secret, err := h.db.CreateOAuthClient(db.OAuthClient{
+		Name:         req.Name,
+		Public:       req.Public,
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       req.Scopes,
+		AllowedRoles: req.AllowedRoles,
+		CreatedBy:    u.ID,
+	})
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to create app")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "oauth_client.create", TargetID: client.ID, Details: client.Name})
+	created(w, oauthClientCreated{OAuthClient: *client, ClientSecret: secret})
+}
+
+// UpdateOAuthClient handles PUT /api/oauth/clients/{id} (admin only).
+// Whoever signed in already keeps their tokens until they next refresh,
+// when the roles are checked again.
+func (h *Handler) UpdateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	u, isAdmin := h.requireAdmin(w, r)
+	if !isAdmin {
+		return
+	}
+	client, err := h.db.GetOAuthClient(chi.URLParam(r, "id"))
+	if err != nil {
+		errResp(w, http.StatusNotFound, "app not found")
+		return
+	}
+	var req OAuthClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if msg := h.validateOAuthClient(&req); msg != "" {
+		errResp(w, http.StatusBadRequest, msg)
+		return
+	}
+	client.Name, client.RedirectURIs, client.Scopes, client.AllowedRoles = req.Name, req.RedirectURIs, req.Scopes, req.AllowedRoles
+	client, err = h.db.UpdateOAuthClient(*client)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to save app")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "oauth_client.update", TargetID: client.ID, Details: client.Name})
+	ok(w, client)
+}
+
+// ResetOAuthClientSecret handles POST /api/oauth/clients/{id}/secret
+// (admin only): a new secret, for when the old one got out.
+func (h *Handler) ResetOAuthClientSecret(w http.ResponseWriter, r *http.Request) {
+	u, isAdmin := h.requireAdmin(w, r)
+	if !isAdmin {
+		return
+	}
+	client, err := h.db.GetOAuthClient(chi.URLParam(r, "id"))
+	if err != nil {
+		errResp(w, http.StatusNotFound, "app not found")
+		return
+	}
+	secret, err := h.db.ResetOAuthClientSecret(client.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to reset secret")
+		return
+	}
+	client.Public = false
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "oauth_client.secret", TargetID: client.ID, Details: client.Name})
+	ok(w, oauthClientCreated{OAuthClient: *client, ClientSecret: secret})
+}
+
+// DeleteOAuthClient handles DELETE /api/oauth/clients/{id} (admin only).
+// Everyone signed in to the app with Chirm is signed out of it when it
+// next checks.
+func (h *Handler) DeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	u, isAdmin := h.requireAdmin(w, r)
+	if !isAdmin {
+		return
+	}
+	client, err := h.db.GetOAuthClient(chi.URLParam(r, "id"))
+	if err != nil {
+		errResp(w, http.StatusNotFound, "app not found")
+		return
+	}
+	if err := h.db.DeleteOAuthClient(client.ID); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to delete app")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "oauth_client.delete", TargetID: client.ID, Details: client.Name})
+	ok(w, map[string]string{"message": "app deleted"})
+}
+
+// ListMyOAuthApps handles GET /api/me/oauth: the apps the caller has let
+// sign them in.
+func (h *Handler) ListMyOAuthApps(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	consents, err := h.db.ListOAuthConsents(u.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list apps")
+		return
+	}
+	ok(w, consents)
+}
+
+// RevokeMyOAuthApp handles DELETE /api/me/oauth/{clientId}: the app is
+// signed out, and has to ask again next time.
+func (h *Handler) RevokeMyOAuthApp(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	found, err := h.db.DeleteOAuthConsent(u.ID, chi.URLParam(r, "clientId"))
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to revoke app")
+		return
+	}
+	if !found {
+		errResp(w, http.StatusNotFound, "app not found")
+		return
+	}
+	ok(w, map[string]string{"message": "app revoked"})
+}
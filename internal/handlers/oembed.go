@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// ─── Rich embeds ──────────────────────────────────────────────────────────────
+//
+// Links to videos come with an Embed, so clients can play them in the chat.
+// YouTube, Vimeo and PeerTube are recognised from the URL, and their embed
+// address is built here rather than taken from the site.  Other pages are
+// asked for oEmbed, through the <link rel="alternate"> they advertise; from
+// the HTML it returns only the iframe's https address is kept.
+
+// Embed is a playable version of a link.
+type Embed struct {
+	Provider string `json:"provider"`
+	Type     string `json:"type"` // "video" or "rich", as in oEmbed
+	URL      string `json:"embed_url"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+}
+
+// oEmbed is the part of an oEmbed response we use.
+type oEmbed struct {
+	Type         string      `json:"type"`
+	Title        string      `json:"title"`
+	AuthorName   string      `json:"author_name"`
+	ProviderName string      `json:"provider_name"`
+	ThumbnailURL string      `json:"thumbnail_url"`
+	URL          string      `json:"url"` // photos
+	HTML         string      `json:"html"`
+	Width        json.Number `json:"width"` // sometimes sent as strings
+	Height       json.Number `json:"height"`
+}
+
+var (
+	reYouTubeID     = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+	rePeerTubeWatch = regexp.MustCompile(`^/(?:w|videos/watch)/([A-Za-z0-9-]{8,})$`)
+)
+
+// knownEmbed recognises links to YouTube and Vimeo, returning the embed and
+// the provider's oEmbed endpoint for the title and thumbnail.
+func knownEmbed(u *url.URL) (*Embed, string) {
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	host = strings.TrimPrefix(host, "m.")
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	switch host {
+	case "youtube.com", "youtu.be", "music.youtube.com", "youtube-nocookie.com":
+		id, short := "", false
+		switch {
+		case host == "youtu.be":
+			id = segments[0]
+		case u.Path == "/watch":
+			id = u.Query().Get("v")
+		case len(segments) == 2 && (segments[0] == "embed" || segments[0] == "live" || segments[0] == "v"):
+			id = segments[1]
+		case len(segments) == 2 && segments[0] == "shorts":
+			id, short = segments[1], true
+		}
+		if !reYouTubeID.MatchString(id) {
+			return nil, ""
+		}
+		e := &Embed{Provider: "YouTube", Type: "video", URL: "https://www.youtube-nocookie.com/embed/" + id, Width: 16, Height: 9}
+		if short {
+			e.Width, e.Height = 9, 16
+		}
+		if start := youTubeStart(u.Query().Get("t")); start > 0 {
+			e.URL += "?start=" + strconv.Itoa(start)
+		}
+		return e, "https://www.youtube.com/oembed?format=json&url="
+
+	case "vimeo.com", "player.vimeo.com":
+		// vimeo.com/123, vimeo.com/channels/x/123, vimeo.com/123/<hash>
+		// for unlisted videos, player.vimeo.com/video/123
+		for i, s := range segments {
+			if _, err := strconv.ParseUint(s, 10, 64); err != nil || s == "" {
+				continue
+			}
+			e := &Embed{Provider: "Vimeo", Type: "video", URL: "https://player.vimeo.com/video/" + s, Width: 16, Height: 9}
+			if h := u.Query().Get("h"); h != "" {
+				e.URL += "?h=" + url.QueryEscape(h)
+			} else if i+1 < len(segments) && isHex(segments[i+1]) {
+				e.URL += "?h=" + segments[i+1]
+			}
+			return e, "https://vimeo.com/api/oembed.json?url="
+		}
+	}
+	return nil, ""
+}
+
+// youTubeStart reads a t= parameter, "90", "90s" or "1m30s", in seconds.
+func youTubeStart(t string) int {
+	if n, err := strconv.Atoi(strings.TrimSuffix(t, "s")); err == nil {
+		return n
+	}
+	total, n := 0, 0
+	for _, c := range t {
+		switch {
+		case c >= '0' && c <= '9':
+			n = n*10 + int(c-'0')
+		case c == 'h':
+			total, n = total+n*3600, 0
+		case c == 'm':
+			total, n = total+n*60, 0
+		case c == 's':
+			total, n = total+n, 0
+		default:
+			return 0
+		}
+	}
+	return total + n
+}
+
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// peerTubeEmbed recognises a PeerTube video page, which can be on any
+// host, from its path and the og:platform PeerTube sets.
+func peerTubeEmbed(u *url.URL, page *pageMeta) *Embed {
+	m := rePeerTubeWatch.FindStringSubmatch(u.Path)
+	if m == nil || !strings.EqualFold(page.get("og:platform"), "PeerTube") {
+		return nil
+	}
+	return &Embed{Provider: "PeerTube", Type: "video", URL: "https://" + u.Host + "/videos/embed/" + m[1], Width: 16, Height: 9}
+}
+
+// fetchOEmbed requests an oEmbed endpoint.
+func fetchOEmbed(endpoint string) (*oEmbed, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid oEmbed endpoint")
+	}
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Chirm/1.0; +https://chirm.app) LinkPreview")
+	req.Header.Set("Accept", "application/json")
+	resp, err := previewClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oEmbed: %s", resp.Status)
+	}
+	var o oEmbed
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// applyOEmbed fills in pv from an oEmbed response.  Unless pv already has
+// an embed, one is made from the iframe in the response's HTML.
+func applyOEmbed(pv *LinkPreview, o *oEmbed) {
+	if o.Title != "" {
+		pv.Title = o.Title
+	}
+	if o.AuthorName != "" && pv.Description == "" {
+		pv.Description = o.AuthorName
+	}
+	if o.ProviderName != "" {
+		pv.SiteName = o.ProviderName
+	}
+	if o.ThumbnailURL != "" {
+		pv.Image = o.ThumbnailURL
+	} else if o.Type == "photo" && o.URL != "" {
+		pv.Image = o.URL
+	}
+	width, _ := o.Width.Int64()
+	height, _ := o.Height.Int64()
+
+	if pv.Embed != nil {
+		// Built-in embeds only take the video's real shape.
+		if width > 0 && height > 0 && pv.Embed.Provider != "YouTube" {
+			pv.Embed.Width, pv.Embed.Height = int(width), int(height)
+		}
+		return
+	}
+	if o.Type != "video" && o.Type != "rich" {
+		return
+	}
+	src := iframeSrc(o.HTML)
+	if src == "" {
+		return
+	}
+	pv.Embed = &Embed{Provider: pv.SiteName, Type: o.Type, URL: src, Width: int(width), Height: int(height)}
+}
+
+// iframeSrc returns the https address of the first iframe in an oEmbed
+// response's HTML.
+func iframeSrc(markup string) string {
+	z := html.NewTokenizer(strings.NewReader(markup))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "iframe" {
+				continue
+			}
+			for hasAttr {
+				var k, v []byte
+				k, v, hasAttr = z.TagAttr()
+				if string(k) != "src" {
+					continue
+				}
+				if u, err := url.Parse(strings.TrimSpace(string(v))); err == nil && u.Scheme == "https" && u.Host != "" {
+					return u.String()
+				}
+				return ""
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"container/list"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"chirm/internal/db"
+)
+
+// ─── Preview cache ───────────────────────────────────────────────────────────
+//
+// Fetched link previews are kept in memory, least recently used first out
+// once there are too many or they take too much room, and written to the
+// database so a restart doesn't mean fetching them all again.  Hosts that
+// can't be reached are left alone for a while, longer each time they fail,
+// rather than being tried again for every link to them.
+
+const (
+	previewCacheEntries = 5000
+	previewCacheBytes   = 8 << 20 // of preview JSON
+	previewTTL          = 2 * time.Hour
+	previewTimeout      = 6 * time.Second
+
+	hostBackoffMin  = time.Minute
+	hostBackoffMax  = time.Hour
+	maxFailingHosts = 1000
+)
+
+type previewEntry struct {
+	url       string
+	data      LinkPreview
+	size      int
+	fetchedAt time.Time
+}
+
+// hostFailure is how a failing host is doing.
+type hostFailure struct {
+	failures int
+	until    time.Time // not tried again before this
+}
+
+type previewCache struct {
+	db      *db.DB
+	mu      sync.Mutex
+	order   *list.List               // of *previewEntry, most recently used first
+	entries map[string]*list.Element // by URL
+	bytes   int
+	hosts   map[string]*hostFailure
+}
+
+// newPreviewCache makes a cache holding the previews saved in d that are
+// still fresh.
+func newPreviewCache(d *db.DB) *previewCache {
+	c := &previewCache{db: d, order: list.New(), entries: map[string]*list.Element{}, hosts: map[string]*hostFailure{}}
+	// Older ones are pruned by the link-preview-retention job; see jobs.go.
+	cutoff := time.Now().Add(-previewTTL)
+	stored, err := d.LinkPreviews(cutoff, previewCacheEntries)
+	if err != nil {
+		slog.Error("link preview cache", "err", err)
+		return c
+	}
+	// Newest first, so each goes behind the last.
+	for _, p := range stored {
+		var pv LinkPreview
+		if json.Unmarshal(p.Data, &pv) != nil {
+			continue
+		}
+		e := &previewEntry{url: p.URL, data: pv, size: len(p.Data), fetchedAt: p.FetchedAt}
+		c.entries[p.URL] = c.order.PushBack(e)
+		c.bytes += e.size
+	}
+	c.evict()
+	return c
+}
+
+// get returns the cached preview for url, if it's fresh.
+func (c *previewCache) get(url string) (LinkPreview, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[url]
+	if !ok {
+		return LinkPreview{}, false
+	}
+	e := el.Value.(*previewEntry)
+	if time.Since(e.fetchedAt) >= previewTTL {
+		return LinkPreview{}, false
+	}
+	c.order.MoveToFront(el)
+	return e.data, true
+}
+
+// put caches and saves pv for url.
+func (c *previewCache) put(url string, pv LinkPreview) {
+	data, _ := json.Marshal(pv)
+	now := time.Now()
+	c.mu.Lock()
+	if el, ok := c.entries[url]; ok {
+		c.bytes -= el.Value.(*previewEntry).size
+		c.order.Remove(el)
+	}
+	e := &previewEntry{url: url, data: pv, size: len(data), fetchedAt: now}
+	c.entries[url] = c.order.PushFront(e)
+	c.bytes += e.size
+	evicted := c.evict()
+	c.mu.Unlock()
+
+	if err := c.db.SaveLinkPreview(url, data, now); err != nil {
+		slog.Error("link preview cache", "err", err)
+	}
+	if len(evicted) > 0 {
+		c.db.DeleteLinkPreviews(evicted)
+	}
+}
+
+// evict drops the least recently used entries until the cache is within
+// its limits, returning their URLs.  c.mu must be held.
+func (c *previewCache) evict() []string {
+	var urls []string
+	for c.order.Len() > previewCacheEntries || (c.bytes > previewCacheBytes && c.order.Len() > 1) {
+		e := c.order.Remove(c.order.Back()).(*previewEntry)
+		delete(c.entries, e.url)
+		c.bytes -= e.size
+		urls = append(urls, e.url)
+	}
+	return urls
+}
+
+// hostDown reports whether host failed recently enough to leave alone.
+func (c *previewCache) hostDown(host string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.hosts[host]
+	return ok && time.Now().Before(f.until)
+}
+
+// hostResult records whether fetching from host worked.  Each failure in
+// a row doubles how long it's left alone.
+func (c *previewCache) hostResult(host string, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !failed {
+		delete(c.hosts, host)
+		return
+	}
+	f, ok := c.hosts[host]
+	if !ok {
+		if len(c.hosts) >= maxFailingHosts {
+			now := time.Now()
+			for h, f := range c.hosts {
+				if now.After(f.until) {
+					delete(c.hosts, h)
+				}
+			}
+		}
+		f = &hostFailure{}
+		c.hosts[host] = f
+	}
+	f.failures++
+	backoff := hostBackoffMin << min(f.failures-1, 6)
+	f.until = time.Now().Add(min(backoff, hostBackoffMax))
+}
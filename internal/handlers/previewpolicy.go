@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+)
+
+// ─── Preview policy ──────────────────────────────────────────────────────────
+//
+// Admins decide which links are unfurled.  "link_previews" set to "0" turns
+// previews off altogether.  "link_preview_denylist" names domains never to
+// fetch, and a non-empty "link_preview_allowlist" limits previews to the
+// domains it names; either way a domain covers its subdomains.  The lists
+// apply to every address a link redirects through, so a shortener can't
+// lead around them.
+
+var errPreviewBlocked = errors.New("link previews are blocked for this site")
+
+type previewPolicy struct {
+	off   bool
+	allow []string
+	deny  []string
+}
+
+// previewPolicy reads the current preview settings.
+func (h *Handler) previewPolicy() previewPolicy {
+	var p previewPolicy
+	if v, _ := h.db.GetSetting("link_previews"); v == "0" {
+		p.off = true
+	}
+	v, _ := h.db.GetSetting("link_preview_allowlist")
+	p.allow = domainList(v)
+	v, _ = h.db.GetSetting("link_preview_denylist")
+	p.deny = domainList(v)
+	return p
+}
+
+// allows reports whether a link to host may be previewed.
+func (p previewPolicy) allows(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if p.off || host == "" || matchesDomain(host, p.deny) {
+		return false
+	}
+	return len(p.allow) == 0 || matchesDomain(host, p.allow)
+}
+
+// domainList parses a list of domains separated by commas or whitespace,
+// as admins type them: "*.example.com" and ".example.com" both mean
+// example.com, and anything URL-like is cut down to its host.
+func domainList(v string) []string {
+	var out []string
+	for _, d := range strings.FieldsFunc(strings.ToLower(v), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t'
+	}) {
+		if i := strings.Index(d, "://"); i >= 0 {
+			d = d[i+3:]
+		}
+		if i := strings.IndexAny(d, "/:?#"); i >= 0 {
+			d = d[:i]
+		}
+		d = strings.Trim(strings.TrimPrefix(d, "*"), ".")
+		if d != "" && !containsString(out, d) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// matchesDomain reports whether host is one of domains or under one.
+func matchesDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
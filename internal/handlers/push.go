@@ -11,15 +11,19 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+
+	"chirm/internal/db"
 )
 
 // ─── VAPID Key Management ────────────────────────────────────────────────────
@@ -103,13 +107,26 @@ func (h *Handler) GetVAPIDPublicKey(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// PushSubscribeRequest is the JSON body the client sends.
+// PushSubscribeRequest is the JSON body the client sends.  Browsers send
+// their Web Push subscription; native apps send a platform ("fcm" or
+// "apns") and device token instead.  Level limits this device to
+// "mentions" or "none" ("" follows the account); leaving it out keeps the
+// device's current level.
 type PushSubscribeRequest struct {
 	Endpoint string `json:"endpoint"`
 	Keys     struct {
 		P256dh string `json:"p256dh"`
 		Auth   string `json:"auth"`
 	} `json:"keys"`
+	Platform string  `json:"platform,omitempty"`
+	Token    string  `json:"token,omitempty"`
+	Level    *string `json:"level,omitempty"`
+}
+
+// GetPushPlatforms lists the push transports this server delivers through,
+// so native wrappers know whether to register a device token.
+func (h *Handler) GetPushPlatforms(w http.ResponseWriter, r *http.Request) {
+	ok(w, map[string][]string{"platforms": pushKinds()})
 }
 
 // SavePushSubscription stores a push subscription for the current user.
@@ -121,30 +138,97 @@ func (h *Handler) SavePushSubscription(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req PushSubscribeRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Endpoint == "" {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errResp(w, http.StatusBadRequest, "invalid subscription")
 		return
 	}
 
-	raw, _ := json.Marshal(req)
-	if err := h.db.SavePushSubscription(u.ID, string(raw)); err != nil {
+	level := req.Level
+	if level != nil && *level != "" && *level != db.NotifyMentions && *level != db.NotifyNone {
+		errResp(w, http.StatusBadRequest, "level must be mentions, none or empty")
+		return
+	}
+	req.Level = nil
+
+	kind, raw := pushKindWebPush, []byte(nil)
+	if req.Platform != "" && req.Platform != pushKindWebPush {
+		if (req.Platform != pushKindFCM && req.Platform != pushKindAPNs) || req.Token == "" {
+			errResp(w, http.StatusBadRequest, "platform must be fcm or apns, with a token")
+			return
+		}
+		if pushTransportFor(req.Platform) == nil {
+			errResp(w, http.StatusServiceUnavailable, req.Platform+" push is not configured on this server")
+			return
+		}
+		kind = req.Platform
+		raw, _ = json.Marshal(nativeSubscription{Endpoint: nativeEndpoint(kind, req.Token), Token: req.Token})
+	} else {
+		if req.Endpoint == "" {
+			errResp(w, http.StatusBadRequest, "invalid subscription")
+			return
+		}
+		req.Platform, req.Token = "", ""
+		raw, _ = json.Marshal(req)
+	}
+	if err := h.db.SavePushSubscription(u.ID, kind, string(raw), level); err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to save subscription")
 		return
 	}
 	ok(w, map[string]string{"status": "subscribed"})
 }
 
-// RemovePushSubscription deletes a push subscription by endpoint.
+// pushDevice is one of the caller's subscriptions in ListPushSubscriptions.
+type pushDevice struct {
+	Kind      string    `json:"kind"`
+	Endpoint  string    `json:"endpoint"`
+	Level     string    `json:"level"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListPushSubscriptions handles GET /api/push/subscriptions: the caller's
+// devices and the notification level each has.
+func (h *Handler) ListPushSubscriptions(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	subs, err := h.db.GetUserPushSubscriptions(u.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	devices := make([]pushDevice, 0, len(subs))
+	for _, s := range subs {
+		devices = append(devices, pushDevice{Kind: s.Kind, Endpoint: s.Endpoint, Level: s.Level, CreatedAt: s.CreatedAt})
+	}
+	ok(w, devices)
+}
+
+// RemovePushSubscriptionRequest is the body of POST /api/push/unsubscribe.
+type RemovePushSubscriptionRequest struct {
+	Endpoint string `json:"endpoint"`
+	Platform string `json:"platform"`
+	Token    string `json:"token"`
+}
+
+// RemovePushSubscription deletes a push subscription by endpoint, or for
+// native apps by platform and token.
 func (h *Handler) RemovePushSubscription(w http.ResponseWriter, r *http.Request) {
 	u, err := h.currentUser(r)
 	if err != nil || u == nil {
 		errResp(w, http.StatusUnauthorized, "unauthorized")
 		return
 	}
-	var req struct {
-		Endpoint string `json:"endpoint"`
+	var req RemovePushSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "endpoint required")
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Endpoint == "" {
+	if req.Platform != "" && req.Platform != pushKindWebPush && req.Token != "" {
+		req.Endpoint = nativeEndpoint(req.Platform, req.Token)
+	}
+	if req.Endpoint == "" {
 		errResp(w, http.StatusBadRequest, "endpoint required")
 		return
 	}
@@ -152,14 +236,91 @@ func (h *Handler) RemovePushSubscription(w http.ResponseWriter, r *http.Request)
 	ok(w, map[string]string{"status": "unsubscribed"})
 }
 
-// PollUnread is called by the Service Worker's periodic background sync.
+// pollScanLimit caps how many unread messages per channel PollUnread reads
+// looking for mentions.
+const pollScanLimit = 100
+
+// ChannelUnread is one channel's entry in the PollUnread response.
+type ChannelUnread struct {
+	ChannelID   string            `json:"channel_id"`
+	ChannelName string            `json:"channel_name"`
+	Unread      int               `json:"unread"`
+	Mentions    int               `json:"mentions"`
+	LastMention *db.UnreadMessage `json:"last_mention,omitempty"`
+}
+
+// PollUnread is called by the Service Worker's periodic background sync.  It
+// returns unread counts per channel and the latest unread @mention in each,
+// so the app icon can be badged even when no push got through.  Channels the
+// user can't read or has set to "nothing" are left out; "mentions only"
+// channels count only their mentions toward the total.
 func (h *Handler) PollUnread(w http.ResponseWriter, r *http.Request) {
 	u, err := h.currentUser(r)
 	if err != nil || u == nil {
 		errResp(w, http.StatusUnauthorized, "unauthorized")
 		return
 	}
-	ok(w, map[string]interface{}{"notifications": []interface{}{}})
+	counts, err := h.db.UnreadCounts(u.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to load unread state")
+		return
+	}
+	prefs, err := h.db.GetNotificationSettings(u.ID)
+	if err != nil {
+		prefs = db.DefaultNotificationSettings()
+	}
+	quiet := prefs.QuietFor(time.Now(), true)
+
+	channels := []ChannelUnread{}
+	notifications := []PushPayload{}
+	total := 0
+	for chID, n := range counts {
+		ch, err := h.db.GetChannelByID(chID)
+		if err != nil || h.db.ChannelPermissions(u, chID)&db.PermReadMessages == 0 {
+			continue
+		}
+		level := prefs.Level(chID)
+		if level == db.NotifyNone {
+			continue
+		}
+		cu := ChannelUnread{ChannelID: chID, ChannelName: ch.Name, Unread: n}
+		msgs, _ := h.db.UnreadMessages(u.ID, chID, pollScanLimit)
+		for i, m := range msgs {
+			mentions := db.ParseMentions(m.Content)
+			if mentions.Has(u.Username) || (mentions.Everyone && !prefs.SuppressEveryone) {
+				cu.Mentions++
+				if cu.LastMention == nil {
+					cu.LastMention = &msgs[i]
+				}
+			}
+		}
+		if level == db.NotifyAll {
+			total += cu.Unread
+		} else {
+			total += cu.Mentions
+		}
+		if cu.LastMention != nil && !quiet {
+			preview := cu.LastMention.Content
+			if len(preview) > 120 {
+				preview = preview[:120] + "…"
+			}
+			notifications = append(notifications, localizePush(PushPayload{
+				Body:      preview,
+				ChannelID: chID,
+				MessageID: cu.LastMention.ID,
+				Tag:       "chirm-poll-" + chID,
+				Key:       pushKeyMention,
+				Params:    map[string]string{"author": cu.LastMention.Author, "channel": ch.Name},
+			}, prefs.Language))
+		}
+		channels = append(channels, cu)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].ChannelName < channels[j].ChannelName })
+	ok(w, map[string]interface{}{
+		"total":         total,
+		"channels":      channels,
+		"notifications": notifications,
+	})
 }
 
 // TestPush sends a test push notification to all of the current user's subscriptions.
@@ -171,26 +332,17 @@ func (h *Handler) TestPush(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	globalVAPID.mu.RLock()
-	privKey := globalVAPID.privateKey
-	globalVAPID.mu.RUnlock()
-	if privKey == nil {
-		errResp(w, http.StatusServiceUnavailable, "VAPID not initialised")
-		return
-	}
-
 	subs, err := h.db.GetChannelPushSubscriptions("")
 	if err != nil {
 		errResp(w, http.StatusInternalServerError, "db error")
 		return
 	}
 
-	payload := PushPayload{
-		Title: "🔔 Chirm test notification",
-		Body:  "Push notifications are working!",
-		Tag:   "chirm-test",
+	lang := ""
+	if s, err := h.db.GetNotificationSettings(u.ID); err == nil {
+		lang = s.Language
 	}
-	payloadBytes, _ := json.Marshal(payload)
+	payloadBytes, _ := json.Marshal(localizePush(PushPayload{Key: pushKeyTest, Tag: "chirm-test"}, lang))
 
 	sent := 0
 	var lastErr string
@@ -198,11 +350,7 @@ func (h *Handler) TestPush(w http.ResponseWriter, r *http.Request) {
 		if sub.UserID != u.ID {
 			continue
 		}
-		var subscription PushSubscribeRequest
-		if json.Unmarshal([]byte(sub.Data), &subscription) != nil {
-			continue
-		}
-		if err := sendWebPush(subscription, payloadBytes, privKey); err != nil {
+		if err := deliverPush(h.db, sub, payloadBytes); err != nil {
 			lastErr = err.Error()
 		} else {
 			sent++
@@ -219,57 +367,192 @@ func (h *Handler) TestPush(w http.ResponseWriter, r *http.Request) {
 // ─── Sending Push Notifications ──────────────────────────────────────────────
 
 // PushPayload is what we send to subscribers when a new message arrives.
+// Key and Params identify the text so it can be rendered in each
+// recipient's language (see localizePush); Title and Body hold the result.
 type PushPayload struct {
-	Title     string `json:"title"`
-	Body      string `json:"body"`
-	ChannelID string `json:"channel_id"`
-	MessageID string `json:"message_id"`
-	Tag       string `json:"tag"`
+	Title     string            `json:"title"`
+	Body      string            `json:"body"`
+	ChannelID string            `json:"channel_id"`
+	MessageID string            `json:"message_id"`
+	Tag       string            `json:"tag"`
+	Key       string            `json:"key,omitempty"`
+	Params    map[string]string `json:"params,omitempty"`
+	// Count is how many messages in the channel this notification stands
+	// for; Badge is the total across channels.  Both reset when the user
+	// reads the channel.
+	Count int `json:"count,omitempty"`
+	Badge int `json:"badge,omitempty"`
 }
 
-// BroadcastPush sends a Web Push notification to all subscribers of the
-// specified channel (except the message author).
+// BroadcastPush sends a Web Push notification about a new message in
+// channelID to every subscriber who can read the channel and whose
+// notification settings want it (never the message author).  Users the
+// message @mentions get the mention text instead of payload's key; those who
+// couldn't be reached by push or WebSocket are emailed instead.  Text is
+// rendered in each recipient's language.  Settings and permissions are
+// loaded once for everyone concerned, not per subscriber.
 // This is called non-blocking from SendMessage.
-func (h *Handler) BroadcastPush(channelName, authorUserID string, payload PushPayload) {
+func (h *Handler) BroadcastPush(channelID, authorUserID, content string, payload PushPayload) {
 	go func() {
-		subs, err := h.db.GetChannelPushSubscriptions(channelName)
-		if err != nil || len(subs) == 0 {
+		mentions := db.ParseMentions(content)
+		subs, _ := h.db.GetChannelPushSubscriptions(channelID)
+		mentioned, _ := h.db.MentionedUserIDs(mentions)
+
+		// Everyone who might hear about the message: subscribers, and
+		// those it mentions, who may be emailed instead.
+		var userIDs []string
+		concerned := map[string]bool{authorUserID: true} // don't notify the sender
+		for _, id := range mentioned {
+			if !concerned[id] {
+				concerned[id] = true
+				userIDs = append(userIDs, id)
+			}
+		}
+		for _, sub := range subs {
+			if !concerned[sub.UserID] {
+				concerned[sub.UserID] = true
+				userIDs = append(userIDs, sub.UserID)
+			}
+		}
+		if len(userIDs) == 0 {
 			return
 		}
-
-		payloadBytes, _ := json.Marshal(payload)
-
-		globalVAPID.mu.RLock()
-		privKey := globalVAPID.privateKey
-		globalVAPID.mu.RUnlock()
-
-		if privKey == nil {
+		readers, err := h.db.ChannelReaders(channelID, userIDs)
+		if err != nil {
 			return
 		}
-
+		prefs, _ := h.db.NotificationSettingsFor(userIDs)
+		pushed := map[string]bool{} // users at least one push reached
+		defer func() {
+			h.emailMissedMentions(channelID, mentioned, readers, mentions, prefs, pushed, payload)
+		}()
+
+		mentionPayload := payload
+		mentionPayload.Key = pushKeyMention
+
+		// userID + device level → what to send, or pushNone
+		targets := map[string]int{}
+		counted := map[string]bool{} // users whose channel count includes this message
 		for _, sub := range subs {
 			if sub.UserID == authorUserID {
 				continue // don't notify the sender
 			}
-			var subscription PushSubscribeRequest
-			if err := json.Unmarshal([]byte(sub.Data), &subscription); err != nil {
+			key := sub.UserID + "|" + sub.Level
+			want, seen := targets[key]
+			if !seen {
+				want = pushWanted(readers[sub.UserID], channelID, sub.Level, mentions, prefs)
+				targets[key] = want
+			}
+			if want == pushNone {
 				continue
 			}
-			sendWebPush(subscription, payloadBytes, privKey)
+			lang := ""
+			if s := prefs[sub.UserID]; s != nil {
+				lang = s.Language
+			}
+			p := payload
+			if want == pushMention {
+				p = mentionPayload
+			}
+			if h.pushes.send(sub, localizePush(p, lang), lang, counted[sub.UserID]) {
+				pushed[sub.UserID] = true
+			}
+			counted[sub.UserID] = true
 		}
 	}()
 }
 
+const (
+	pushNone = iota
+	pushMessage
+	pushMention
+)
+
+// pushWanted decides whether u gets a push for a message in channelID: they
+// must be able to read the channel (u is nil if they can't), and their
+// notification level there must cover the message.  Mentions are evaluated against the message's
+// parsed @handles, so "mentions only" users hear about nothing else.  A
+// device's own level can only narrow this: a phone set to "mentions" gets
+// mentions even in channels the account follows in full.
+func pushWanted(u *db.User, channelID, deviceLevel string, mentions db.Mentions, prefs map[string]*db.NotificationSettings) int {
+	if u == nil {
+		return pushNone
+	}
+	s := prefs[u.ID]
+	if s == nil {
+		s = db.DefaultNotificationSettings()
+	}
+	mentioned := mentions.Has(u.Username)
+	if !s.Wants(channelID, mentioned, mentions.Everyone) || s.QuietFor(time.Now(), mentioned) {
+		return pushNone
+	}
+	switch deviceLevel {
+	case db.NotifyNone:
+		return pushNone
+	case db.NotifyMentions:
+		if !mentioned && (!mentions.Everyone || s.SuppressEveryone) {
+			return pushNone
+		}
+	}
+	if mentioned {
+		return pushMention
+	}
+	return pushMessage
+}
+
+// pushToUser sends a Web Push notification to every device of one user.  It
+// is aimed at them directly, so quiet hours treat it like a mention.
+func pushToUser(database *db.DB, userID string, payload PushPayload) {
+	s, err := database.GetNotificationSettings(userID)
+	if err != nil {
+		s = db.DefaultNotificationSettings()
+	}
+	if s.QuietFor(time.Now(), true) {
+		return
+	}
+	subs, err := database.GetUserPushSubscriptions(userID)
+	if err != nil {
+		return
+	}
+	payloadBytes, _ := json.Marshal(localizePush(payload, s.Language))
+	for _, sub := range subs {
+		if sub.Level == db.NotifyNone {
+			continue // device silenced
+		}
+		deliverPush(database, sub, payloadBytes)
+	}
+}
+
+// errPushGone means the push service no longer knows the subscription —
+// the browser unsubscribed or the user cleared site data.
+var errPushGone = errors.New("push subscription expired")
+
+// deliverPush sends one push through the subscription's transport and
+// deletes the subscription if its endpoint is gone, so dead endpoints don't
+// pile up and slow every later send.  payload is a JSON PushPayload.
+func deliverPush(database *db.DB, sub db.PushSubscription, payload []byte) error {
+	t := pushTransportFor(sub.Kind)
+	if t == nil {
+		return fmt.Errorf("no %s push transport configured", sub.Kind)
+	}
+	err := t.Send(sub, payload)
+	database.RecordPushDelivery(sub.Kind, err == nil)
+	if errors.Is(err, errPushGone) {
+		database.DeletePushSubscription(sub.UserID, sub.Endpoint)
+	}
+	return err
+}
+
 // ─── RFC 8030 / RFC 8291 / RFC 8292 Web Push Implementation ─────────────────
 // Implemented using only Go's standard library.
 
 func sendWebPush(sub PushSubscribeRequest, plaintext []byte, vapidPrivKey *ecdsa.PrivateKey) error {
 	// 1. Decode subscriber's public key and auth secret
-	clientPubKeyBytes, err := base64.RawURLEncoding.DecodeString(padBase64(sub.Keys.P256dh))
+	clientPubKeyBytes, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(sub.Keys.P256dh, "="))
 	if err != nil {
 		return fmt.Errorf("decode p256dh: %w", err)
 	}
-	authSecret, err := base64.RawURLEncoding.DecodeString(padBase64(sub.Keys.Auth))
+	authSecret, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(sub.Keys.Auth, "="))
 	if err != nil {
 		return fmt.Errorf("decode auth: %w", err)
 	}
@@ -354,6 +637,9 @@ func sendWebPush(sub PushSubscribeRequest, plaintext []byte, vapidPrivKey *ecdsa
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return fmt.Errorf("push endpoint %d: %w", resp.StatusCode, errPushGone)
+	}
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
 		return fmt.Errorf("push endpoint %d: %s", resp.StatusCode, string(body))
@@ -452,13 +738,3 @@ func extractOrigin(endpoint string) string {
 	}
 	return endpoint
 }
-
-func padBase64(s string) string {
-	switch len(s) % 4 {
-	case 2:
-		return s + "=="
-	case 3:
-		return s + "="
-	}
-	return s
-}
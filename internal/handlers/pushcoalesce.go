@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"chirm/internal/db"
+)
+
+// pushCoalesceWindow is the shortest gap between two message pushes for
+// the same channel to one device.  Messages inside it are held and sent
+// together as a single notification that replaces the previous one.
+const pushCoalesceWindow = 8 * time.Second
+
+// pushThreadSweep is how often threads idle past their window are dropped.
+const pushThreadSweep = time.Minute
+
+// pushCoalescer turns bursts of messages in a channel into one updated
+// notification per device.  Every push carries the channel's tag, so the
+// newest notification replaces the older one, plus a count of messages
+// notified since the user last read the channel and a badge total across
+// channels.
+//
+// State is in memory and per instance: in cluster mode each instance only
+// coalesces the pushes it sends itself, so a burst handled by two instances
+// can reach a device as two notifications, each counting its own messages.
+type pushCoalescer struct {
+	db *db.DB
+
+	mu        sync.Mutex
+	threads   map[pushThreadKey]*pushThread
+	unread    map[string]map[string]int // userID → channelID → messages notified since read
+	lastSweep time.Time
+}
+
+type pushThreadKey struct{ endpoint, channelID string }
+
+// pushThread is one device's notification for one channel.
+type pushThread struct {
+	sub      db.PushSubscription
+	lastSent time.Time
+	ok       bool         // whether the last delivery succeeded
+	pending  *PushPayload // newest held message, localised
+	timer    *time.Timer
+}
+
+func newPushCoalescer(database *db.DB) *pushCoalescer {
+	return &pushCoalescer{
+		db:      database,
+		threads: map[pushThreadKey]*pushThread{},
+		unread:  map[string]map[string]int{},
+	}
+}
+
+// count records one more message for userID in channelID and returns the
+// channel's count and the badge total.
+func (pc *pushCoalescer) count(userID, channelID string) (int, int) {
+	chans := pc.unread[userID]
+	if chans == nil {
+		chans = map[string]int{}
+		pc.unread[userID] = chans
+	}
+	chans[channelID]++
+	badge := 0
+	for _, n := range chans {
+		badge += n
+	}
+	return chans[channelID], badge
+}
+
+// send pushes p (already localised) to sub now, or holds it if the channel
+// was pushed to this device moments ago.  Mentions are never held.  It
+// reports whether the device has been reached: for a held message, whether
+// the thread's last push got through.
+func (pc *pushCoalescer) send(sub db.PushSubscription, p PushPayload, lang string, counted bool) bool {
+	pc.mu.Lock()
+	pc.sweep()
+	key := pushThreadKey{sub.Endpoint, p.ChannelID}
+	t := pc.threads[key]
+	if t == nil {
+		t = &pushThread{}
+		pc.threads[key] = t
+	}
+	t.sub = sub
+	if !counted {
+		// first device of this user for this message
+		p.Count, p.Badge = pc.count(sub.UserID, p.ChannelID)
+	} else {
+		p.Count = pc.unread[sub.UserID][p.ChannelID]
+		for _, n := range pc.unread[sub.UserID] {
+			p.Badge += n
+		}
+	}
+	p = coalescedText(p, lang)
+
+	wait := pushCoalesceWindow - time.Since(t.lastSent)
+	if p.Key == pushKeyMention || wait <= 0 {
+		if t.timer != nil {
+			t.timer.Stop()
+			t.timer = nil
+		}
+		t.pending = nil
+		t.lastSent = time.Now()
+		pc.mu.Unlock()
+
+		body, _ := json.Marshal(p)
+		delivered := deliverPush(pc.db, sub, body) == nil
+		pc.mu.Lock()
+		t.ok = delivered
+		pc.mu.Unlock()
+		return delivered
+	}
+
+	t.pending = &p
+	if t.timer == nil {
+		t.timer = time.AfterFunc(wait, func() { pc.flush(key) })
+	}
+	ok := t.ok
+	pc.mu.Unlock()
+	return ok
+}
+
+// sweep drops threads with nothing held whose window has passed, as the
+// next message for them is sent straight away anyway.  pc.mu must be held.
+func (pc *pushCoalescer) sweep() {
+	if time.Since(pc.lastSweep) < pushThreadSweep {
+		return
+	}
+	pc.lastSweep = time.Now()
+	for key, t := range pc.threads {
+		if t.timer == nil && time.Since(t.lastSent) >= pushCoalesceWindow {
+			delete(pc.threads, key)
+		}
+	}
+}
+
+// flush sends a thread's held message once its window has passed.
+func (pc *pushCoalescer) flush(key pushThreadKey) {
+	pc.mu.Lock()
+	t := pc.threads[key]
+	if t == nil || t.pending == nil {
+		if t != nil {
+			t.timer = nil
+		}
+		pc.mu.Unlock()
+		return
+	}
+	p := *t.pending
+	t.pending, t.timer = nil, nil
+	t.lastSent = time.Now()
+	sub := t.sub
+	pc.mu.Unlock()
+
+	body, _ := json.Marshal(p)
+	delivered := deliverPush(pc.db, sub, body) == nil
+	pc.mu.Lock()
+	t.ok = delivered
+	pc.mu.Unlock()
+}
+
+// markRead resets userID's count for channelID and drops any held pushes
+// for it, since the user has now seen the messages.
+func (pc *pushCoalescer) markRead(userID, channelID string) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	delete(pc.unread[userID], channelID)
+	if len(pc.unread[userID]) == 0 {
+		delete(pc.unread, userID)
+	}
+	for key, t := range pc.threads {
+		if key.channelID == channelID && t.sub.UserID == userID {
+			if t.timer != nil {
+				t.timer.Stop()
+			}
+			delete(pc.threads, key)
+		}
+	}
+}
+
+// coalescedText retitles a message push that stands for several messages,
+// e.g. "3 new messages in #general", with the newest as the body.
+func coalescedText(p PushPayload, lang string) PushPayload {
+	if p.Key != pushKeyMessage || p.Count < 2 {
+		return p
+	}
+	preview := p.Body
+	params := map[string]string{"count": strconv.Itoa(p.Count)}
+	for k, v := range p.Params {
+		params[k] = v
+	}
+	p.Key, p.Params = pushKeyMessages, params
+	p = localizePush(p, lang)
+	if author := params["author"]; author != "" {
+		p.Body = author + ": " + preview
+	}
+	return p
+}
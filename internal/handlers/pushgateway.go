@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"chirm/internal/db"
+)
+
+// ─── Push transports ─────────────────────────────────────────────────────────
+//
+// Every push subscription names the transport that delivers to it.  Browsers
+// register Web Push subscriptions; native wrappers register FCM or APNs
+// device tokens, which only work when the server is configured as a push
+// gateway for that platform.
+
+const (
+	pushKindWebPush = "webpush"
+	pushKindFCM     = "fcm"
+	pushKindAPNs    = "apns"
+)
+
+// pushTransport delivers one JSON PushPayload to one subscription.  Send
+// returns errPushGone when the subscription no longer exists.
+type pushTransport interface {
+	Send(sub db.PushSubscription, payload []byte) error
+}
+
+var pushTransports = struct {
+	mu sync.RWMutex
+	m  map[string]pushTransport
+}{m: map[string]pushTransport{pushKindWebPush: webPushTransport{}}}
+
+func pushTransportFor(kind string) pushTransport {
+	if kind == "" {
+		kind = pushKindWebPush
+	}
+	pushTransports.mu.RLock()
+	defer pushTransports.mu.RUnlock()
+	return pushTransports.m[kind]
+}
+
+func setPushTransport(kind string, t pushTransport) {
+	pushTransports.mu.Lock()
+	pushTransports.m[kind] = t
+	pushTransports.mu.Unlock()
+}
+
+// pushKinds lists the transports this server can deliver through.
+func pushKinds() []string {
+	pushTransports.mu.RLock()
+	defer pushTransports.mu.RUnlock()
+	kinds := make([]string, 0, len(pushTransports.m))
+	for k := range pushTransports.m {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// PushGatewayConfig enables delivery to native app tokens.  Leave a
+// platform's fields empty to keep it off.
+type PushGatewayConfig struct {
+	FCMCredentialsFile string // Firebase service-account JSON
+
+	APNsKeyFile string // .p8 token-signing key
+	APNsKeyID   string
+	APNsTeamID  string
+	APNsTopic   string // the app's bundle ID
+	APNsSandbox bool   // use the development environment
+}
+
+// InitPushGateway sets up the FCM and APNs transports that cfg configures.
+func (h *Handler) InitPushGateway(cfg PushGatewayConfig) error {
+	if cfg.FCMCredentialsFile != "" {
+		t, err := newFCMTransport(cfg.FCMCredentialsFile)
+		if err != nil {
+			return fmt.Errorf("FCM: %w", err)
+		}
+		setPushTransport(pushKindFCM, t)
+		slog.Info("push gateway: FCM", "project", t.projectID)
+	}
+	if cfg.APNsKeyFile != "" {
+		if cfg.APNsKeyID == "" || cfg.APNsTeamID == "" || cfg.APNsTopic == "" {
+			return errors.New("APNs needs a key ID, team ID and topic")
+		}
+		t, err := newAPNsTransport(cfg.APNsKeyFile, cfg.APNsKeyID, cfg.APNsTeamID, cfg.APNsTopic, cfg.APNsSandbox)
+		if err != nil {
+			return fmt.Errorf("APNs: %w", err)
+		}
+		setPushTransport(pushKindAPNs, t)
+		slog.Info("push gateway: APNs", "topic", cfg.APNsTopic)
+	}
+	return nil
+}
+
+// nativeEndpoint is the endpoint stored for a native device token, unique
+// per platform so re-registering replaces the old row.
+func nativeEndpoint(kind, token string) string {
+	return kind + ":" + token
+}
+
+// nativeSubscription is the Data stored for FCM and APNs subscriptions.
+type nativeSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Token    string `json:"token"`
+}
+
+func nativeToken(sub db.PushSubscription) (string, error) {
+	var ns nativeSubscription
+	if err := json.Unmarshal([]byte(sub.Data), &ns); err != nil || ns.Token == "" {
+		return "", errors.New("invalid native push subscription")
+	}
+	return ns.Token, nil
+}
+
+// webPushTransport sends RFC 8291 encrypted pushes signed with the VAPID key.
+type webPushTransport struct{}
+
+func (webPushTransport) Send(sub db.PushSubscription, payload []byte) error {
+	globalVAPID.mu.RLock()
+	privKey := globalVAPID.privateKey
+	globalVAPID.mu.RUnlock()
+	if privKey == nil {
+		return errors.New("VAPID not initialised")
+	}
+	var subscription PushSubscribeRequest
+	if err := json.Unmarshal([]byte(sub.Data), &subscription); err != nil {
+		return err
+	}
+	return sendWebPush(subscription, payload, privKey)
+}
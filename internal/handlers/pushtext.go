@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ─── Push notification text ──────────────────────────────────────────────────
+//
+// Pushes carry a message key and parameters; the title and body are rendered
+// per recipient in their notification language (falling back to the
+// server's default, then English).  Native wrappers may ignore the rendered
+// text and localise Key/Params themselves.
+
+const (
+	pushKeyMessage    = "message"    // {author} {channel}; body is the message preview
+	pushKeyMessages   = "messages"   // {count} {channel}; several coalesced messages
+	pushKeyMention    = "mention"    // {author} {channel}; body is the message preview
+	pushKeyCallVoice  = "call.voice" // {caller}
+	pushKeyCallVideo  = "call.video" // {caller}
+	pushKeyTest       = "test"
+	pushKeyQuarantine = "quarantine" // {user} {file} {signature}; to admins
+	pushKeyEvent      = "event"      // {title} {minutes}; a server event is about to start
+)
+
+// pushText is one key's templates; an empty Body keeps the payload's own.
+type pushText struct {
+	Title string
+	Body  string
+}
+
+type pushLanguage struct {
+	Name  string // in the language itself
+	Texts map[string]pushText
+}
+
+var pushLanguages = map[string]pushLanguage{
+	"en": {"English", map[string]pushText{
+		pushKeyMessage:    {Title: "{author} in #{channel}"},
+		pushKeyMessages:   {Title: "{count} new messages in #{channel}"},
+		pushKeyMention:    {Title: "{author} mentioned you in #{channel}"},
+		pushKeyCallVoice:  {"📞 Incoming voice call", "{caller} is calling you"},
+		pushKeyCallVideo:  {"📞 Incoming video call", "{caller} is calling you"},
+		pushKeyTest:       {"🔔 Chirm test notification", "Push notifications are working!"},
+		pushKeyQuarantine: {"🛡 Upload quarantined", "{file} from {user} matched {signature}"},
+		pushKeyEvent:      {"📅 {title}", "Starts in {minutes} minutes"},
+	}},
+	"es": {"Español", map[string]pushText{
+		pushKeyMessage:    {Title: "{author} en #{channel}"},
+		pushKeyMessages:   {Title: "{count} mensajes nuevos en #{channel}"},
+		pushKeyMention:    {Title: "{author} te mencionó en #{channel}"},
+		pushKeyCallVoice:  {"📞 Llamada de voz entrante", "{caller} te está llamando"},
+		pushKeyCallVideo:  {"📞 Videollamada entrante", "{caller} te está llamando"},
+		pushKeyTest:       {"🔔 Notificación de prueba de Chirm", "¡Las notificaciones push funcionan!"},
+		pushKeyQuarantine: {"🛡 Archivo en cuarentena", "{file} de {user} coincide con {signature}"},
+		pushKeyEvent:      {"📅 {title}", "Empieza en {minutes} minutos"},
+	}},
+	"fr": {"Français", map[string]pushText{
+		pushKeyMessage:    {Title: "{author} dans #{channel}"},
+		pushKeyMessages:   {Title: "{count} nouveaux messages dans #{channel}"},
+		pushKeyMention:    {Title: "{author} vous a mentionné dans #{channel}"},
+		pushKeyCallVoice:  {"📞 Appel vocal entrant", "{caller} vous appelle"},
+		pushKeyCallVideo:  {"📞 Appel vidéo entrant", "{caller} vous appelle"},
+		pushKeyTest:       {"🔔 Notification de test Chirm", "Les notifications push fonctionnent !"},
+		pushKeyQuarantine: {"🛡 Fichier mis en quarantaine", "{file} de {user} correspond à {signature}"},
+		pushKeyEvent:      {"📅 {title}", "Commence dans {minutes} minutes"},
+	}},
+	"de": {"Deutsch", map[string]pushText{
+		pushKeyMessage:    {Title: "{author} in #{channel}"},
+		pushKeyMessages:   {Title: "{count} neue Nachrichten in #{channel}"},
+		pushKeyMention:    {Title: "{author} hat dich in #{channel} erwähnt"},
+		pushKeyCallVoice:  {"📞 Eingehender Sprachanruf", "{caller} ruft dich an"},
+		pushKeyCallVideo:  {"📞 Eingehender Videoanruf", "{caller} ruft dich an"},
+		pushKeyTest:       {"🔔 Chirm-Testbenachrichtigung", "Push-Benachrichtigungen funktionieren!"},
+		pushKeyQuarantine: {"🛡 Upload in Quarantäne", "{file} von {user} wurde als {signature} erkannt"},
+		pushKeyEvent:      {"📅 {title}", "Beginnt in {minutes} Minuten"},
+	}},
+	"pt": {"Português", map[string]pushText{
+		pushKeyMessage:    {Title: "{author} em #{channel}"},
+		pushKeyMessages:   {Title: "{count} novas mensagens em #{channel}"},
+		pushKeyMention:    {Title: "{author} mencionou você em #{channel}"},
+		pushKeyCallVoice:  {"📞 Chamada de voz recebida", "{caller} está ligando para você"},
+		pushKeyCallVideo:  {"📞 Chamada de vídeo recebida", "{caller} está ligando para você"},
+		pushKeyTest:       {"🔔 Notificação de teste do Chirm", "As notificações push estão funcionando!"},
+		pushKeyQuarantine: {"🛡 Arquivo em quarentena", "{file} de {user} corresponde a {signature}"},
+		pushKeyEvent:      {"📅 {title}", "Começa em {minutes} minutos"},
+	}},
+	"it": {"Italiano", map[string]pushText{
+		pushKeyMessage:    {Title: "{author} in #{channel}"},
+		pushKeyMessages:   {Title: "{count} nuovi messaggi in #{channel}"},
+		pushKeyMention:    {Title: "{author} ti ha menzionato in #{channel}"},
+		pushKeyCallVoice:  {"📞 Chiamata vocale in arrivo", "{caller} ti sta chiamando"},
+		pushKeyCallVideo:  {"📞 Videochiamata in arrivo", "{caller} ti sta chiamando"},
+		pushKeyTest:       {"🔔 Notifica di prova di Chirm", "Le notifiche push funzionano!"},
+		pushKeyQuarantine: {"🛡 File in quarantena", "{file} di {user} corrisponde a {signature}"},
+		pushKeyEvent:      {"📅 {title}", "Inizia tra {minutes} minuti"},
+	}},
+	"nl": {"Nederlands", map[string]pushText{
+		pushKeyMessage:    {Title: "{author} in #{channel}"},
+		pushKeyMessages:   {Title: "{count} nieuwe berichten in #{channel}"},
+		pushKeyMention:    {Title: "{author} heeft je genoemd in #{channel}"},
+		pushKeyCallVoice:  {"📞 Inkomende spraakoproep", "{caller} belt je"},
+		pushKeyCallVideo:  {"📞 Inkomend videogesprek", "{caller} belt je"},
+		pushKeyTest:       {"🔔 Chirm-testmelding", "Pushmeldingen werken!"},
+		pushKeyQuarantine: {"🛡 Upload in quarantaine", "{file} van {user} komt overeen met {signature}"},
+		pushKeyEvent:      {"📅 {title}", "Begint over {minutes} minuten"},
+	}},
+}
+
+var defaultPushLanguage = struct {
+	sync.RWMutex
+	code string
+}{code: "en"}
+
+// normalizeLanguage maps a tag like "pt-BR" to a supported code, or "".
+func normalizeLanguage(tag string) string {
+	code := strings.ToLower(tag)
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	if _, ok := pushLanguages[code]; ok {
+		return code
+	}
+	return ""
+}
+
+// SetDefaultLanguage sets the language for users who haven't picked one.
+func (h *Handler) SetDefaultLanguage(tag string) error {
+	code := normalizeLanguage(tag)
+	if code == "" {
+		return fmt.Errorf("unsupported language %q (have %s)", tag, strings.Join(pushLanguageCodes(), ", "))
+	}
+	defaultPushLanguage.Lock()
+	defaultPushLanguage.code = code
+	defaultPushLanguage.Unlock()
+	return nil
+}
+
+func pushLanguageCodes() []string {
+	codes := make([]string, 0, len(pushLanguages))
+	for c := range pushLanguages {
+		codes = append(codes, c)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// PushLanguage is a language notifications can be written in.
+type PushLanguage struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// GetPushLanguages lists the languages notifications can be written in, and
+// the server's default.
+func (h *Handler) GetPushLanguages(w http.ResponseWriter, r *http.Request) {
+	langs := []PushLanguage{}
+	for _, c := range pushLanguageCodes() {
+		langs = append(langs, PushLanguage{c, pushLanguages[c].Name})
+	}
+	defaultPushLanguage.RLock()
+	def := defaultPushLanguage.code
+	defaultPushLanguage.RUnlock()
+	ok(w, map[string]interface{}{"languages": langs, "default": def})
+}
+
+// localizePush fills in p's title and body for a reader of lang.  Payloads
+// without a key are returned as they are.
+func localizePush(p PushPayload, lang string) PushPayload {
+	if p.Key == "" {
+		return p
+	}
+	code := normalizeLanguage(lang)
+	if code == "" {
+		defaultPushLanguage.RLock()
+		code = defaultPushLanguage.code
+		defaultPushLanguage.RUnlock()
+	}
+	text, ok := pushLanguages[code].Texts[p.Key]
+	if !ok {
+		text = pushLanguages["en"].Texts[p.Key]
+	}
+	pairs := make([]string, 0, 2*len(p.Params))
+	for k, v := range p.Params {
+		pairs = append(pairs, "{"+k+"}", v)
+	}
+	r := strings.NewReplacer(pairs...)
+	p.Title = r.Replace(text.Title)
+	if text.Body != "" {
+		p.Body = r.Replace(text.Body)
+	}
+	return p
+}
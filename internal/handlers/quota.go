@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+
+	"chirm/internal/db"
+)
+
+// ─── Storage quota ───────────────────────────────────────────────────────────
+//
+// Admins can cap the total size of attachments with the "storage_quota_mb"
+// setting so uploads can't fill the host's disk.  "storage_quota_policy"
+// says what happens to an upload that would go over: "reject" it (the
+// default), or make room by evicting the oldest ("evict_oldest") or largest
+// ("evict_largest") attachments already sent.  Evicted files are deleted
+// but their records stay, so messages show what used to be there, and
+// each eviction is written to the audit log.
+
+const (
+	quotaReject       = "reject"
+	quotaEvictOldest  = "evict_oldest"
+	quotaEvictLargest = "evict_largest"
+)
+
+// evictionBatch is how many candidates are looked at per query.
+const evictionBatch = 50
+
+var errStorageFull = errors.New("the server is out of storage space for uploads")
+
+// quotaMu makes checking and freeing space one step per instance.  Uploads
+// running at the same time may still overshoot the cap by a file or so.
+var quotaMu sync.Mutex
+
+// storageQuota returns the quota in bytes, 0 for none, and the policy.
+func (h *Handler) storageQuota() (int64, string) {
+	v, _ := h.db.GetSetting("storage_quota_mb")
+	mb, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || mb <= 0 {
+		return 0, ""
+	}
+	policy, _ := h.db.GetSetting("storage_quota_policy")
+	switch policy {
+	case quotaEvictOldest, quotaEvictLargest:
+	default:
+		policy = quotaReject
+	}
+	return mb << 20, policy
+}
+
+// makeRoom checks that size more bytes of attachments fit in the quota,
+// evicting old attachments if the policy allows.
+func (h *Handler) makeRoom(size int64) error {
+	quota, policy := h.storageQuota()
+	if quota == 0 {
+		return nil
+	}
+	if size > quota {
+		return errStorageFull
+	}
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	used, err := h.db.AttachmentBytes()
+	if err != nil {
+		return err
+	}
+	if used+size <= quota {
+		return nil
+	}
+	if policy == quotaReject {
+		return errStorageFull
+	}
+
+	for used+size > quota {
+		candidates, err := h.db.EvictionCandidates(policy == quotaEvictLargest, evictionBatch)
+		if err != nil {
+			return err
+		}
+		progress := false
+		for i := range candidates {
+			if used+size <= quota {
+				break
+			}
+			a := &candidates[i]
+			if h.evictAttachment(a, policy) {
+				used -= a.Size
+				progress = true
+			}
+		}
+		if !progress {
+			return errStorageFull
+		}
+	}
+	return nil
+}
+
+// evictAttachment deletes an attachment's file to free space, telling its
+// channel and recording why.
+func (h *Handler) evictAttachment(a *db.Attachment, policy string) bool {
+	if ok, err := h.db.EvictAttachment(a.ID); err != nil || !ok {
+		return false
+	}
+	removeUpload(h.files, a.Filename)
+	slog.Info("storage quota: evicted attachment", "file", a.Filename, "name", a.OriginalName, "bytes", a.Size, "policy", policy)
+	h.db.AddAuditEntry(db.AuditEntry{
+		Action:   "attachment.evict",
+		TargetID: a.ID,
+		Details:  fmt.Sprintf("Deleted %q (%s) from message %s to stay within the storage quota (%s)", a.OriginalName, formatBytes(a.Size), a.MessageID, policy),
+	})
+	if a, err := h.db.GetAttachment(a.ID); err == nil {
+		h.broadcastAttachment(a)
+	}
+	return true
+}
+
+// formatBytes renders a size for people, e.g. "3.2 MB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
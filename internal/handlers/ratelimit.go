@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	mw "chirm/internal/middleware"
+)
+
+// userLimitIdle is how long a user's limiter may go unused before it is
+// dropped.
+const userLimitIdle = 10 * time.Minute
+
+// userLimiter rate-limits one kind of WebSocket action per user.
+type userLimiter struct {
+	mu     sync.Mutex
+	every  time.Duration
+	burst  int
+	limits map[string]*userLimit
+}
+
+type userLimit struct {
+	lim  *rate.Limiter
+	used time.Time
+}
+
+func newUserLimiter(every time.Duration, burst int) *userLimiter {
+	return &userLimiter{every: every, burst: burst, limits: make(map[string]*userLimit)}
+}
+
+// allow reports whether userID may act now.
+func (l *userLimiter) allow(userID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for id, ul := range l.limits {
+		if now.Sub(ul.used) > userLimitIdle {
+			delete(l.limits, id)
+		}
+	}
+	ul := l.limits[userID]
+	if ul == nil {
+		ul = &userLimit{lim: rate.NewLimiter(rate.Every(l.every), l.burst)}
+		l.limits[userID] = ul
+	}
+	ul.used = now
+	return ul.lim.Allow()
+}
+
+// ─── Route rate limits ───────────────────────────────────────────────────────
+//
+// Each class of HTTP route ("messages", "uploads" and so on) has its own
+// limit per client.  The server's configuration gives the defaults, and
+// admins can replace them in the settings with "rate_<class>_per_min" and
+// "rate_<class>_burst"; an empty value goes back to the default.
+
+// RateLimit registers def as class's default limit and returns a function
+// reporting its current limit, for mw.NewRateLimiter.
+func (h *Handler) RateLimit(class string, def mw.RateLimit) func() mw.RateLimit {
+	h.rateLimits[class] = def
+	return func() mw.RateLimit {
+		l := def
+		v, _ := h.db.GetSetting("rate_" + class + "_per_min")
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			l.PerMinute = n
+		}
+		v, _ = h.db.GetSetting("rate_" + class + "_burst")
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			l.Burst = n
+		}
+		return l
+	}
+}
+
+// rateLimitSetting reports whether key is one of the rate limit settings.
+func (h *Handler) rateLimitSetting(key string) bool {
+	for class := range h.rateLimits {
+		if key == "rate_"+class+"_per_min" || key == "rate_"+class+"_burst" {
+			return true
+		}
+	}
+	return false
+}
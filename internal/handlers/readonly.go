@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"chirm/internal/db"
+)
+
+// ─── Read-only mode ──────────────────────────────────────────────────────────
+//
+// During an incident or a raid, an admin can freeze the server rather than
+// close it: nobody else can send or edit messages, post through webhooks,
+// upload or register, but everyone can still read, and voice, in-call chat
+// included, carries on.  Clients hear about it in a "read_only" event and
+// disable their composers.  Like maintenance mode, it's kept in settings,
+// so it survives a restart.
+
+// ReadOnlyState is the body of GET and PUT /api/read-only.
+type ReadOnlyState struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message"`
+	Since   *time.Time `json:"since,omitempty"` // when it was turned on
+}
+
+// loadReadOnly reads the saved state into h.
+func (h *Handler) loadReadOnly() {
+	m := &ReadOnlyState{}
+	v, _ := h.db.GetSetting("read_only_mode")
+	m.Enabled = v == "1"
+	m.Message, _ = h.db.GetSetting("read_only_message")
+	if v, _ := h.db.GetSetting("read_only_since"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			m.Since = &t
+		}
+	}
+	h.readOnly.Store(m)
+}
+
+// ReadOnlyGate refuses the request with 403 while the server is read-only,
+// unless it comes from an admin.  It goes on the routes that write:
+// sending and editing messages, webhooks, uploads and registration.
+func (h *Handler) ReadOnlyGate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := h.readOnly.Load()
+		if m == nil || !m.Enabled || h.isAdminRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		respond(w, http.StatusForbidden, map[string]string{"error": "the server is read-only for now", "message": m.Message})
+	})
+}
+
+// GetReadOnly handles GET /api/read-only.  Anyone may ask.
+func (h *Handler) GetReadOnly(w http.ResponseWriter, r *http.Request) {
+	ok(w, h.readOnly.Load())
+}
+
+// SetReadOnly handles PUT /api/read-only (admin only).
+func (h *Handler) SetReadOnly(w http.ResponseWriter, r *http.Request) {
+	u, isAdmin := h.requireAdmin(w, r)
+	if !isAdmin {
+		return
+	}
+	var req ReadOnlyState
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if len(req.Message) > 500 {
+		errResp(w, http.StatusBadRequest, "message must be at most 500 characters")
+		return
+	}
+	prev := h.readOnly.Load()
+	m := &ReadOnlyState{Enabled: req.Enabled, Message: req.Message}
+	if m.Enabled {
+		now := time.Now().UTC().Truncate(time.Second)
+		m.Since = &now
+		if prev.Enabled && prev.Since != nil {
+			m.Since = prev.Since
+		}
+	}
+
+	mode, since := "", ""
+	if m.Enabled {
+		mode, since = "1", m.Since.Format(time.RFC3339)
+	}
+	for k, v := range map[string]string{"read_only_mode": mode, "read_only_message": m.Message, "read_only_since": since} {
+		if err := h.db.SetSetting(k, v); err != nil {
+			errResp(w, http.StatusInternalServerError, "failed to save read-only mode")
+			return
+		}
+	}
+	h.readOnly.Store(m)
+
+	if m.Enabled != prev.Enabled {
+		action := "read_only.end"
+		if m.Enabled {
+			action = "read_only.start"
+		}
+		h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: action, Details: m.Message})
+	}
+	h.hub.Broadcast(WSEvent{Type: "read_only", Data: m})
+	ok(w, m)
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+)
+
+// ─── Voice recording ─────────────────────────────────────────────────────────
+//
+// With VOICE_RECORDING=1 (and the SFU enabled) users holding the Record Voice
+// permission can record a voice room.  The SFU writes one Ogg/Opus file per
+// speaker to DATA_DIR/recordings/<recording id>/.  Everyone in the room is
+// told via voice.recording while it runs, and the recording stops by itself
+// when the room empties.
+
+// EnableRecording allows voice rooms to be recorded into dir.  It has no
+// effect unless the SFU is enabled.  Recordings cut short by a restart are
+// closed with whatever files they had written.
+func (h *Hub) EnableRecording(dir string) {
+	h.recordDir = dir
+	recs, _ := h.db.ListRecordings()
+	for _, rec := range recs {
+		if rec.EndedAt != nil {
+			continue
+		}
+		files, _ := filepath.Glob(filepath.Join(dir, rec.ID, "*.ogg"))
+		for i, f := range files {
+			files[i] = filepath.Base(f)
+		}
+		h.db.FinishRecording(rec.ID, files)
+	}
+}
+
+func (h *Hub) recordingAvailable() bool {
+	return h.sfu != nil && h.recordDir != ""
+}
+
+// activeRecording returns the ID of the recording running in channelID, or "".
+func (h *Hub) activeRecording(channelID string) string {
+	h.recordingsMu.Lock()
+	defer h.recordingsMu.Unlock()
+	return h.recordings[channelID]
+}
+
+func (h *Hub) startRecording(channelID, userID string) (*db.Recording, error) {
+	h.recordingsMu.Lock()
+	defer h.recordingsMu.Unlock()
+	if h.recordings[channelID] != "" {
+		return nil, errAlreadyRecording
+	}
+	rec, err := h.db.CreateRecording(channelID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.sfu.StartRecording(channelID, filepath.Join(h.recordDir, rec.ID)); err != nil {
+		h.db.DeleteRecording(rec.ID)
+		return nil, err
+	}
+	h.recordings[channelID] = rec.ID
+	h.relayToVoiceRoom(channelID, recordingEvent(channelID, rec.ID, userID), nil)
+	return rec, nil
+}
+
+// stopRecording ends channelID's recording, if any, and reports whether one
+// was running.
+func (h *Hub) stopRecording(channelID string) bool {
+	h.recordingsMu.Lock()
+	id := h.recordings[channelID]
+	delete(h.recordings, channelID)
+	h.recordingsMu.Unlock()
+	if id == "" {
+		return false
+	}
+	files := h.sfu.StopRecording(channelID)
+	if err := h.db.FinishRecording(id, files); err != nil {
+		slog.Error("recording", "id", id, "err", err)
+	}
+	h.relayToVoiceRoom(channelID, recordingEvent(channelID, "", ""), nil)
+	return true
+}
+
+// stopRecordingIfEmpty ends a recording once nobody is left in the room.
+func (h *Hub) stopRecordingIfEmpty(channelID string) {
+	h.voiceRoomsMu.RLock()
+	local := len(h.voiceRooms[channelID])
+	h.voiceRoomsMu.RUnlock()
+	if local == 0 {
+		h.stopRecording(channelID)
+	}
+}
+
+func recordingEvent(channelID, recordingID, startedBy string) WSEvent {
+	return WSEvent{
+		Type: "voice.recording",
+		Data: map[string]interface{}{
+			"channel_id":   channelID,
+			"recording":    recordingID != "",
+			"recording_id": recordingID,
+			"started_by":   startedBy,
+		},
+	}
+}
+
+var errAlreadyRecording = errors.New("this room is already being recorded")
+
+// canRecord reports whether u may record voice in channelID, and see and
+// delete its recordings: Record Voice in the channel's guild.
+func (h *Handler) canRecord(u *db.User, channelID string) bool {
+	guildID := h.db.ChannelGuild(channelID)
+	return h.db.CanAccessGuild(u, guildID) && h.db.HasGuildPermission(u, guildID, db.PermRecordVoice)
+}
+
+// requireRecordPerm returns the current user if they may record voice in
+// channelID.
+func (h *Handler) requireRecordPerm(w http.ResponseWriter, r *http.Request, channelID string) (*db.User, bool) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return nil, false
+	}
+	if !h.canRecord(u, channelID) {
+		errResp(w, http.StatusForbidden, "insufficient permissions")
+		return nil, false
+	}
+	return u, true
+}
+
+// recordingFor returns the recording named in r's URL, if the current user
+// may get at it.  Recordings in guilds they can't record in are not found.
+func (h *Handler) recordingFor(w http.ResponseWriter, r *http.Request) (*db.Recording, bool) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return nil, false
+	}
+	rec, err := h.db.GetRecording(chi.URLParam(r, "id"))
+	if err != nil || !h.canRecord(u, rec.ChannelID) {
+		errResp(w, http.StatusNotFound, "recording not found")
+		return nil, false
+	}
+	return rec, true
+}
+
+// StartRecording handles POST /api/voice/{channelId}/recording.
+func (h *Handler) StartRecording(w http.ResponseWriter, r *http.Request) {
+	channelID := chi.URLParam(r, "channelId")
+	u, allowed := h.requireRecordPerm(w, r, channelID)
+	if !allowed {
+		return
+	}
+	if !h.hub.recordingAvailable() {
+		errResp(w, http.StatusBadRequest, "recording is not enabled on this server")
+		return
+	}
+	if !h.hub.userInLocalVoiceRoom(channelID, u.ID) {
+		errResp(w, http.StatusBadRequest, "join the voice channel to record it")
+		return
+	}
+	rec, err := h.hub.startRecording(channelID, u.ID)
+	if err == errAlreadyRecording {
+		errResp(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to start recording")
+		return
+	}
+	created(w, rec)
+}
+
+// StopRecording handles DELETE /api/voice/{channelId}/recording.
+func (h *Handler) StopRecording(w http.ResponseWriter, r *http.Request) {
+	channelID := chi.URLParam(r, "channelId")
+	if _, allowed := h.requireRecordPerm(w, r, channelID); !allowed {
+		return
+	}
+	id := h.hub.activeRecording(channelID)
+	if id == "" || !h.hub.stopRecording(channelID) {
+		errResp(w, http.StatusNotFound, "this room is not being recorded")
+		return
+	}
+	rec, err := h.db.GetRecording(id)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to load recording")
+		return
+	}
+	ok(w, rec)
+}
+
+// ListRecordings handles GET /api/recordings: the recordings of the guilds
+// the caller may record in.
+func (h *Handler) ListRecordings(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	recs, err := h.db.ListRecordings()
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list recordings")
+		return
+	}
+	visible := []db.Recording{}
+	for _, rec := range recs {
+		if h.canRecord(u, rec.ChannelID) {
+			visible = append(visible, rec)
+		}
+	}
+	ok(w, visible)
+}
+
+// GetRecordingFile streams one track of a recording.
+func (h *Handler) GetRecordingFile(w http.ResponseWriter, r *http.Request) {
+	rec, found := h.recordingFor(w, r)
+	if !found {
+		return
+	}
+	name := chi.URLParam(r, "name")
+	found = false
+	for _, f := range rec.Files {
+		found = found || f == name
+	}
+	if !found || strings.ContainsAny(name, `/\`) {
+		errResp(w, http.StatusNotFound, "file not found")
+		return
+	}
+	w.Header().Set("Content-Type", "audio/ogg")
+	http.ServeFile(w, r, filepath.Join(h.dataDir, "recordings", rec.ID, name))
+}
+
+func (h *Handler) DeleteRecording(w http.ResponseWriter, r *http.Request) {
+	rec, found := h.recordingFor(w, r)
+	if !found {
+		return
+	}
+	if rec.EndedAt == nil {
+		errResp(w, http.StatusConflict, "stop the recording before deleting it")
+		return
+	}
+	if err := os.RemoveAll(filepath.Join(h.dataDir, "recordings", rec.ID)); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to delete recording files")
+		return
+	}
+	h.db.DeleteRecording(rec.ID)
+	ok(w, map[string]string{"message": "deleted"})
+}
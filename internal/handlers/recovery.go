@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"chirm/internal/db"
+)
+
+// ─── Owner recovery codes ────────────────────────────────────────────────────
+//
+// Setup hands the owner ten one-time recovery codes, and an owner can make
+// a new set later.  Signed out, any one of them sets a new password, so a
+// forgotten one doesn't mean editing the database by hand; `chirm admin
+// recover` does the same from the command line.
+
+// RecoverRequest is the body of POST /api/auth/recover.
+type RecoverRequest struct {
+	Code     string `json:"code"`
+	Password string `json:"password"` // the new one
+}
+
+// recoverResponse is what POST /api/auth/recover returns.
+type recoverResponse struct {
+	User      *db.User `json:"user"`
+	Token     string   `json:"token"`
+	CodesLeft int      `json:"codes_left"`
+}
+
+// Recover handles POST /api/auth/recover: it uses up a recovery code to set
+// its owner's password, and signs them in.
+func (h *Handler) Recover(w http.ResponseWriter, r *http.Request) {
+	var req RecoverRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if len(req.Password) < 8 {
+		errResp(w, http.StatusBadRequest, "password must be at least 8 characters")
+		return
+	}
+	userID, err := h.db.UseRecoveryCode(req.Code)
+	if err != nil {
+		errResp(w, http.StatusUnauthorized, "invalid or used recovery code")
+		return
+	}
+	u, err := h.db.GetUserByID(userID)
+	if err != nil || !u.IsOwner {
+		// Codes are only made for owners; this one has stopped being one.
+		errResp(w, http.StatusUnauthorized, "invalid or used recovery code")
+		return
+	}
+	hash, err := h.auth.HashPassword(req.Password)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to hash password")
+		return
+	}
+	if err := h.db.SetPasswordHash(u.ID, hash); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to set password")
+		return
+	}
+	left := h.db.RecoveryCodesLeft(u.ID)
+	h.db.AddAuditEntry(db.AuditEntry{
+		ActorID:  u.ID,
+		Action:   "user.password_reset",
+		TargetID: u.ID,
+		Details:  "used a recovery code; " + strconv.Itoa(left) + " left",
+	})
+
+	token, err := h.auth.GenerateToken(u.ID, u.Username, u.IsOwner)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to generate token")
+		return
+	}
+	setTokenCookie(w, r, token)
+	ok(w, recoverResponse{User: u, Token: token, CodesLeft: left})
+}
+
+// recoveryCodesStatus is the body of GET /api/me/recovery-codes.
+type recoveryCodesStatus struct {
+	Left int `json:"left"`
+}
+
+// GetRecoveryCodes handles GET /api/me/recovery-codes (owners): how many
+// unused codes they have.
+func (h *Handler) GetRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	u, isOwner := h.requireOwner(w, r)
+	if !isOwner {
+		return
+	}
+	ok(w, recoveryCodesStatus{Left: h.db.RecoveryCodesLeft(u.ID)})
+}
+
+// NewRecoveryCodesRequest is the body of POST /api/me/recovery-codes.
+type NewRecoveryCodesRequest struct {
+	Password string `json:"password"` // the owner's current one
+}
+
+// recoveryCodes is a new set of codes, shown this once.
+type recoveryCodes struct {
+	Codes []string `json:"codes"`
+}
+
+// NewRecoveryCodes handles POST /api/me/recovery-codes (owners): a new set
+// of codes in place of the old, for which the owner gives their password.
+func (h *Handler) NewRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	u, isOwner := h.requireOwner(w, r)
+	if !isOwner {
+		return
+	}
+	var req NewRecoveryCodesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if !h.auth.CheckPassword(u.PasswordHash, req.Password) {
+		errResp(w, http.StatusUnauthorized, "wrong password")
+		return
+	}
+	codes, err := h.db.NewRecoveryCodes(u.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to make recovery codes")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "user.recovery_codes", TargetID: u.ID, Details: "made new recovery codes"})
+	ok(w, recoveryCodes{Codes: codes})
+}
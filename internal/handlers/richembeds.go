@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ─── Rich embeds ─────────────────────────────────────────────────────────────
+//
+// Integrations can attach embeds of their own to a message: a card with a
+// title, description, colour bar, fields, footer and images, for status
+// updates and the like.  They're checked here and stored with the message
+// as sent, except that images are rewritten to go through the image proxy.
+// The limits are Discord's, so payloads written for it fit.
+
+const (
+	maxRichEmbeds          = 10
+	maxEmbedTitle          = 256
+	maxEmbedDescription    = 4096
+	maxEmbedFields         = 25
+	maxEmbedFieldName      = 256
+	maxEmbedFieldValue     = 1024
+	maxEmbedFooter         = 2048
+	maxEmbedAuthor         = 256
+	maxRichEmbedTotalChars = 6000 // across all of a message's embeds
+)
+
+// RichEmbed is an embed an integration attached to a message.
+type RichEmbed struct {
+	Title       string       `json:"title,omitempty"`
+	URL         string       `json:"url,omitempty"` // the title links here
+	Description string       `json:"description,omitempty"`
+	Color       int          `json:"color,omitempty"` // 0xRRGGBB
+	Author      string       `json:"author,omitempty"`
+	Fields      []EmbedField `json:"fields,omitempty"`
+	Image       string       `json:"image,omitempty"`
+	Thumbnail   string       `json:"thumbnail,omitempty"`
+	Footer      string       `json:"footer,omitempty"`
+	Timestamp   *time.Time   `json:"timestamp,omitempty"`
+}
+
+// EmbedField is a name and value shown in a rich embed; inline fields sit
+// side by side.
+type EmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+// validateRichEmbeds checks embeds against the limits, trimming space, and
+// proxies their images.  The error says what's wrong, for the integration's
+// developer.
+func validateRichEmbeds(embeds []RichEmbed) error {
+	if len(embeds) > maxRichEmbeds {
+		return fmt.Errorf("at most %d embeds per message", maxRichEmbeds)
+	}
+	total := 0
+	text := func(what string, s *string, limit int) error {
+		*s = strings.TrimSpace(*s)
+		n := utf8.RuneCountInString(*s)
+		if n > limit {
+			return fmt.Errorf("%s must be at most %d characters", what, limit)
+		}
+		total += n
+		return nil
+	}
+	link := func(what string, s *string) error {
+		*s = strings.TrimSpace(*s)
+		if *s == "" {
+			return nil
+		}
+		u, err := url.Parse(*s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s must be an http or https URL", what)
+		}
+		return nil
+	}
+
+	for i := range embeds {
+		e := &embeds[i]
+		for _, err := range []error{
+			text("title", &e.Title, maxEmbedTitle),
+			text("description", &e.Description, maxEmbedDescription),
+			text("author", &e.Author, maxEmbedAuthor),
+			text("footer", &e.Footer, maxEmbedFooter),
+			link("url", &e.URL),
+			link("image", &e.Image),
+			link("thumbnail", &e.Thumbnail),
+		} {
+			if err != nil {
+				return fmt.Errorf("embed %d: %w", i+1, err)
+			}
+		}
+		if e.Color < 0 || e.Color > 0xFFFFFF {
+			return fmt.Errorf("embed %d: color must be between 0 and 0xFFFFFF", i+1)
+		}
+		if len(e.Fields) > maxEmbedFields {
+			return fmt.Errorf("embed %d: at most %d fields", i+1, maxEmbedFields)
+		}
+		for j := range e.Fields {
+			f := &e.Fields[j]
+			if err := text("field name", &f.Name, maxEmbedFieldName); err != nil {
+				return fmt.Errorf("embed %d, field %d: %w", i+1, j+1, err)
+			}
+			if err := text("field value", &f.Value, maxEmbedFieldValue); err != nil {
+				return fmt.Errorf("embed %d, field %d: %w", i+1, j+1, err)
+			}
+			if f.Name == "" || f.Value == "" {
+				return fmt.Errorf("embed %d, field %d: needs a name and a value", i+1, j+1)
+			}
+		}
+		if e.Title == "" && e.Description == "" && e.Author == "" && len(e.Fields) == 0 && e.Image == "" && e.Thumbnail == "" {
+			return fmt.Errorf("embed %d is empty", i+1)
+		}
+		e.Image = proxiedImageURL(e.Image)
+		e.Thumbnail = proxiedImageURL(e.Thumbnail)
+	}
+	if total > maxRichEmbedTotalChars {
+		return fmt.Errorf("embeds may have at most %d characters of text in all", maxRichEmbedTotalChars)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import "net/http"
+
+// ─── Server preview ──────────────────────────────────────────────────────────
+//
+// An invite's landing page shows what you're about to join before you have
+// an account.  The name, description and icon are public already; how many
+// members the server has and how many are online are only shown if admins
+// turn on the "preview_counts" setting.
+
+// ServerPreview is the body of GET /api/preview.
+type ServerPreview struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Icon        string `json:"icon,omitempty"`
+	Members     *int   `json:"members,omitempty"` // with preview_counts on
+	Online      *int   `json:"online,omitempty"`  // likewise
+}
+
+// GetServerPreview handles GET /api/preview.  Anyone may ask; the route is
+// rate-limited, as it counts members.
+func (h *Handler) GetServerPreview(w http.ResponseWriter, r *http.Request) {
+	var p ServerPreview
+	p.Name, _ = h.db.GetSetting("server_name")
+	p.Description, _ = h.db.GetSetting("server_description")
+	p.Icon, _ = h.db.GetSetting("server_icon")
+	if v, _ := h.db.GetSetting("preview_counts"); v == "1" {
+		members, online := h.db.UserCount(), len(h.hub.connectedUsers())
+		p.Members, p.Online = &members, &online
+	}
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	ok(w, p)
+}
@@ -4,28 +4,35 @@ import (
 	"encoding/json"
 	"net/http"
 	"strings"
+
+	"chirm/internal/db"
+	mw "chirm/internal/middleware"
 )
 
 func (h *Handler) SetupStatus(w http.ResponseWriter, r *http.Request) {
 	ok(w, map[string]bool{"setup_done": h.db.IsSetupDone()})
 }
 
+// SetupRequest is the body of POST /api/setup.
+type SetupRequest struct {
+	ServerName        string `json:"server_name"`
+	ServerDescription string `json:"server_description"`
+	LoginBgColor      string `json:"login_bg_color"`
+	AgreementEnabled  string `json:"agreement_enabled"`
+	AgreementText     string `json:"agreement_text"`
+	Username          string `json:"username"`
+	Email             string `json:"email"`
+	Password          string `json:"password"`
+	Template          string `json:"template"` // from GET /api/setup/templates; "default" if empty
+}
+
 func (h *Handler) Setup(w http.ResponseWriter, r *http.Request) {
 	if h.db.IsSetupDone() {
 		errResp(w, http.StatusForbidden, "setup already complete")
 		return
 	}
 
-	var req struct {
-		ServerName        string `json:"server_name"`
-		ServerDescription string `json:"server_description"`
-		LoginBgColor      string `json:"login_bg_color"`
-		AgreementEnabled  string `json:"agreement_enabled"`
-		AgreementText     string `json:"agreement_text"`
-		Username          string `json:"username"`
-		Email             string `json:"email"`
-		Password          string `json:"password"`
-	}
+	var req SetupRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errResp(w, http.StatusBadRequest, "invalid request")
 		return
@@ -43,6 +50,11 @@ func (h *Handler) Setup(w http.ResponseWriter, r *http.Request) {
 		errResp(w, http.StatusBadRequest, "password must be at least 8 characters")
 		return
 	}
+	tmpl := findTemplate(req.Template)
+	if tmpl == nil {
+		errResp(w, http.StatusBadRequest, "unknown template")
+		return
+	}
 
 	hash, err := h.auth.HashPassword(req.Password)
 	if err != nil {
@@ -58,16 +70,15 @@ func (h *Handler) Setup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create default @everyone role
-	_, err = h.db.CreateRole("@everyone", "#99AAB5", 3) // READ | SEND
+	_, err = h.db.CreateRole(db.DefaultGuild, "@everyone", "#99AAB5", db.DefaultEveryonePermissions)
 	if err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to create default role")
 		return
 	}
 
-	// Create default channel
-	_, err = h.db.CreateChannel("general", "General discussion", "text", "", "")
-	if err != nil {
-		errResp(w, http.StatusInternalServerError, "failed to create channel")
+	// Create the template's roles and channels
+	if _, err := h.applyTemplate(db.DefaultGuild, tmpl); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to create channels")
 		return
 	}
 
@@ -87,6 +98,13 @@ func (h *Handler) Setup(w http.ResponseWriter, r *http.Request) {
 		h.db.SetSetting("agreement_text", req.AgreementText)
 	}
 
+	// Recovery codes, shown to the owner this once
+	codes, err := h.db.NewRecoveryCodes(user.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to make recovery codes")
+		return
+	}
+
 	// Issue token
 	token, err := h.auth.GenerateToken(user.ID, user.Username, user.IsOwner)
 	if err != nil {
@@ -95,21 +113,9 @@ func (h *Handler) Setup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	setTokenCookie(w, r, token)
-	created(w, map[string]interface{}{"user": user, "token": token})
+	created(w, map[string]interface{}{"user": user, "token": token, "recovery_codes": codes})
 }
 
 func setTokenCookie(w http.ResponseWriter, r *http.Request, token string) {
-	// Only set Secure flag when actually served over HTTPS.  Hardcoding
-	// Secure: true caused Chrome to silently reject the cookie over plain
-	// HTTP, making login appear completely broken on :8080.
-	isSecure := r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
-	http.SetCookie(w, &http.Cookie{
-		Name:     "chirm_token",
-		Value:    token,
-		Path:     "/",
-		MaxAge:   30 * 24 * 3600,
-		HttpOnly: true,
-		Secure:   isSecure,
-		SameSite: http.SameSiteLaxMode,
-	})
+	mw.SetTokenCookie(w, r, token)
 }
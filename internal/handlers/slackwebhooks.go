@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ─── Slack-format webhooks ───────────────────────────────────────────────────
+//
+// Plenty of tools can post to a Slack incoming webhook and nothing else, so
+// a webhook also takes Slack's payload: text in Slack's mrkdwn, the simple
+// blocks (header, section, context, divider and image) and legacy
+// attachments.  It's sent as JSON, or as a form with the JSON in
+// "payload", as Slack allows.  Text and blocks become the message's
+// content, with mrkdwn rewritten as Markdown, and attachments and image
+// blocks become rich embeds.  Other blocks, icons and interactive parts
+// are left out.  As Slack does, the webhook answers such a call with a
+// plain "ok".
+
+// SlackWebhookRequest is a Slack incoming webhook payload, as posted to
+// /api/webhooks/{id}/{token}.
+type SlackWebhookRequest struct {
+	Text        string            `json:"text"`
+	Username    string            `json:"username"`
+	Blocks      []SlackBlock      `json:"blocks"`
+	Attachments []SlackAttachment `json:"attachments"`
+}
+
+// SlackText is a text object of a Slack block.
+type SlackText struct {
+	Type string `json:"type"` // mrkdwn or plain_text
+	Text string `json:"text"`
+}
+
+// markdown is t as Chirm Markdown.
+func (t *SlackText) markdown() string {
+	if t == nil {
+		return ""
+	}
+	if t.Type == "plain_text" {
+		return t.Text
+	}
+	return slackMarkdown(t.Text)
+}
+
+// SlackBlock is one of a Slack message's layout blocks.
+type SlackBlock struct {
+	Type     string      `json:"type"`
+	Text     *SlackText  `json:"text"`     // header, section
+	Fields   []SlackText `json:"fields"`   // section
+	Elements []SlackText `json:"elements"` // context; image elements are skipped
+	ImageURL string      `json:"image_url"`
+	AltText  string      `json:"alt_text"`
+	Title    *SlackText  `json:"title"` // image
+}
+
+// SlackAttachment is a legacy Slack message attachment.
+type SlackAttachment struct {
+	Fallback   string       `json:"fallback"`
+	Color      string       `json:"color"` // good, warning, danger or #RRGGBB
+	Pretext    string       `json:"pretext"`
+	AuthorName string       `json:"author_name"`
+	Title      string       `json:"title"`
+	TitleLink  string       `json:"title_link"`
+	Text       string       `json:"text"`
+	Fields     []SlackField `json:"fields"`
+	ImageURL   string       `json:"image_url"`
+	ThumbURL   string       `json:"thumb_url"`
+	Footer     string       `json:"footer"`
+	TS         json.Number  `json:"ts"` // Unix seconds, as a number or a string
+}
+
+// SlackField is a title and value in a Slack attachment.
+type SlackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// slackColors are the colours Slack names.
+var slackColors = map[string]int{"good": 0x2EB886, "warning": 0xDAA038, "danger": 0xA30200}
+
+// readWebhookRequest reads the body of a webhook call, in Chirm's format
+// or Slack's, and reports whether it was Slack's.
+func readWebhookRequest(r *http.Request) (ExecuteWebhookRequest, bool, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return ExecuteWebhookRequest{}, false, err
+	}
+	// curl -d sends JSON labelled as a form, so only a form with a
+	// payload is taken as one.
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if form, err := url.ParseQuery(string(body)); err == nil && form.Has("payload") {
+			body = []byte(form.Get("payload"))
+		}
+	}
+	var req ExecuteWebhookRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return req, false, err
+	}
+	if strings.TrimSpace(req.Content) != "" || len(req.Embeds) > 0 {
+		return req, false, nil
+	}
+	var slack SlackWebhookRequest
+	if err := json.Unmarshal(body, &slack); err != nil {
+		return req, false, err
+	}
+	if slack.Text == "" && len(slack.Blocks) == 0 && len(slack.Attachments) == 0 {
+		return req, false, nil
+	}
+	return slack.message(), true, nil
+}
+
+// message is s as a Chirm webhook call.
+func (s *SlackWebhookRequest) message() ExecuteWebhookRequest {
+	req := ExecuteWebhookRequest{Username: s.Username}
+	var lines []string
+	// With blocks, Slack only shows the text in notifications.
+	for _, b := range s.Blocks {
+		switch b.Type {
+		case "header":
+			if t := b.Text.markdown(); t != "" {
+				lines = append(lines, "## "+t)
+			}
+		case "section":
+			if t := b.Text.markdown(); t != "" {
+				lines = append(lines, t)
+			}
+			for _, f := range b.Fields {
+				lines = append(lines, f.markdown())
+			}
+		case "context":
+			var parts []string
+			for _, e := range b.Elements {
+				if e.Type == "mrkdwn" || e.Type == "plain_text" {
+					parts = append(parts, e.markdown())
+				}
+			}
+			if len(parts) > 0 {
+				lines = append(lines, strings.Join(parts, "  "))
+			}
+		case "divider":
+			lines = append(lines, "---")
+		case "image":
+			if b.ImageURL != "" {
+				req.Embeds = append(req.Embeds, RichEmbed{Title: b.Title.markdown(), Image: b.ImageURL})
+			}
+		}
+	}
+	if len(lines) == 0 && s.Text != "" {
+		lines = append(lines, slackMarkdown(s.Text))
+	}
+	for _, a := range s.Attachments {
+		if a.Pretext != "" {
+			lines = append(lines, slackMarkdown(a.Pretext))
+		}
+		if e, ok := a.embed(); ok {
+			req.Embeds = append(req.Embeds, e)
+		}
+	}
+	req.Content = strings.Join(lines, "\n")
+	return req
+}
+
+// embed is a as a rich embed, if it has anything to show.
+func (a *SlackAttachment) embed() (RichEmbed, bool) {
+	e := RichEmbed{
+		Title:       a.Title,
+		URL:         a.TitleLink,
+		Description: slackMarkdown(a.Text),
+		Author:      a.AuthorName,
+		Image:       a.ImageURL,
+		Thumbnail:   a.ThumbURL,
+		Footer:      a.Footer,
+	}
+	if c, named := slackColors[a.Color]; named {
+		e.Color = c
+	} else if c, err := strconv.ParseUint(strings.TrimPrefix(a.Color, "#"), 16, 24); err == nil {
+		e.Color = int(c)
+	}
+	for _, f := range a.Fields {
+		// Embed fields need a name; untitled ones join the description.
+		switch {
+		case f.Title == "" && f.Value == "":
+		case f.Title == "":
+			e.Description = strings.TrimSpace(e.Description + "\n" + slackMarkdown(f.Value))
+		default:
+			e.Fields = append(e.Fields, EmbedField{Name: f.Title, Value: slackMarkdown(f.Value), Inline: f.Short})
+		}
+	}
+	if ts, err := a.TS.Float64(); err == nil && ts > 0 {
+		t := time.Unix(int64(ts), 0).UTC()
+		e.Timestamp = &t
+	}
+	if e.Title == "" && e.Description == "" && e.Author == "" && len(e.Fields) == 0 && e.Image == "" && e.Thumbnail == "" {
+		if a.Fallback == "" {
+			return e, false
+		}
+		e.Description = slackMarkdown(a.Fallback)
+	}
+	return e, true
+}
+
+var (
+	slackLink   = regexp.MustCompile(`<([^<>|]+)(?:\|([^<>]*))?>`)
+	slackBold   = regexp.MustCompile(`(^|[^\w*])\*([^*\n]+)\*`)
+	slackStrike = regexp.MustCompile(`(^|[^\w~])~([^~\n]+)~`)
+	slackCode   = regexp.MustCompile("(?s)```.*?```|`[^`\n]*`")
+)
+
+// slackMarkdown rewrites Slack mrkdwn as the Markdown Chirm shows:
+// *bold* becomes **bold**, ~struck~ ~~struck~~, <url|label> "label (url)",
+// and mentions of users, channels and groups their names.  Code is left
+// alone.
+func slackMarkdown(s string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range slackCode.FindAllStringIndex(s, -1) {
+		b.WriteString(slackMarkdownText(s[last:loc[0]]))
+		b.WriteString(slackUnescape(s[loc[0]:loc[1]]))
+		last = loc[1]
+	}
+	b.WriteString(slackMarkdownText(s[last:]))
+	return b.String()
+}
+
+func slackMarkdownText(s string) string {
+	s = slackBold.ReplaceAllString(s, "$1**$2**")
+	s = slackStrike.ReplaceAllString(s, "$1~~$2~~")
+	s = slackLink.ReplaceAllStringFunc(s, func(m string) string {
+		parts := slackLink.FindStringSubmatch(m)
+		target, label := parts[1], parts[2]
+		switch target[0] {
+		case '@', '#': // <@U024BE7LH|alice>, <#C024BE7LR|general>
+			if label != "" {
+				return target[:1] + strings.TrimPrefix(label, target[:1])
+			}
+			return target
+		case '!': // <!here>, <!subteam^ID|@team>, <!date^...|fallback>
+			if label != "" {
+				return label
+			}
+			return "@" + target[1:]
+		}
+		if label == "" || label == target {
+			return target
+		}
+		return label + " (" + target + ")"
+	})
+	return slackUnescape(s)
+}
+
+// slackUnescape undoes the escaping Slack asks for of &, < and >.
+func slackUnescape(s string) string {
+	return strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&").Replace(s)
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+	mw "chirm/internal/middleware"
+)
+
+// ─── Soundboard ──────────────────────────────────────────────────────────────
+//
+// Admins upload short clips; anyone with PermSoundboard in a voice room can
+// send voice.sound and every client in the room plays the clip locally.
+// Nothing is mixed into the call itself, so the clip is also heard by people
+// who are only listening.
+
+const (
+	maxSoundSize  = 512 * 1024
+	soundInterval = 3 * time.Second // sustained rate per user
+	soundBurst    = 2
+)
+
+// playSound handles a voice.sound request from c.
+func (c *Client) playSound(channelID, soundID string) {
+	h := c.hub
+	if !h.userInLocalVoiceRoom(channelID, c.userID) {
+		return
+	}
+	if h.voicePermissions(channelID, c.userID)&db.PermSoundboard == 0 {
+		c.sendEvent(WSEvent{Type: "voice.sound_error", Data: map[string]string{
+			"channel_id": channelID,
+			"error":      "You don't have permission to use the soundboard",
+		}})
+		return
+	}
+	if h.voiceModeration(c.userID).Muted || h.listenOnly(channelID, c.userID) {
+		return
+	}
+	sound, err := h.db.GetSoundByID(soundID)
+	if err != nil {
+		return
+	}
+	if !h.soundLimits.allow(c.userID) {
+		c.sendEvent(WSEvent{Type: "voice.sound_error", Data: map[string]string{
+			"channel_id": channelID,
+			"error":      "You're playing sounds too quickly",
+		}})
+		return
+	}
+	h.relayToVoiceRoom(channelID, WSEvent{Type: "voice.sound", Data: map[string]interface{}{
+		"channel_id": channelID,
+		"user_id":    c.userID,
+		"sound_id":   sound.ID,
+		"name":       sound.Name,
+		"url":        "/uploads/" + sound.Filename,
+	}}, nil)
+}
+
+// ListSounds returns all soundboard clips (any authenticated user).
+func (h *Handler) ListSounds(w http.ResponseWriter, r *http.Request) {
+	sounds, err := h.db.ListSounds()
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list sounds")
+		return
+	}
+	ok(w, sounds)
+}
+
+// UploadSound handles multipart clip upload (admin only).
+func (h *Handler) UploadSound(w http.ResponseWriter, r *http.Request) {
+	u, isOk := h.requireAdmin(w, r)
+	if !isOk {
+		return
+	}
+
+	mw.SetBodyLimit(r, 4<<20)
+	if err := r.ParseMultipartForm(4 << 20); err != nil {
+		errResp(w, http.StatusBadRequest, "request too large")
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		errResp(w, http.StatusBadRequest, "sound name required")
+		return
+	}
+	if len(name) > 32 {
+		errResp(w, http.StatusBadRequest, "sound name must be 32 characters or fewer")
+		return
+	}
+
+	file, header, err := r.FormFile("sound")
+	if err != nil {
+		errResp(w, http.StatusBadRequest, "sound file required")
+		return
+	}
+	defer file.Close()
+
+	mime := header.Header.Get("Content-Type")
+	if !strings.HasPrefix(mime, "audio/") {
+		errResp(w, http.StatusBadRequest, "file must be audio")
+		return
+	}
+	if header.Size > maxSoundSize {
+		errResp(w, http.StatusBadRequest, "sound must be under 512KB")
+		return
+	}
+
+	ext := filepath.Ext(header.Filename)
+	if ext == "" {
+		ext = ".ogg"
+	}
+	filename := fmt.Sprintf("sound_%s%s", db.NewID(), ext)
+
+	if err := h.files.Put(filename, file, header.Size, mime); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to save file")
+		return
+	}
+
+	sound, err := h.db.CreateSound(name, filename, u.ID)
+	if err != nil {
+		h.files.Delete(filename)
+		if strings.Contains(err.Error(), "UNIQUE") {
+			errResp(w, http.StatusConflict, "a sound with that name already exists")
+			return
+		}
+		errResp(w, http.StatusInternalServerError, "failed to create sound")
+		return
+	}
+
+	h.hub.relayToAll(WSEvent{Type: "sound.new", Data: sound})
+	created(w, sound)
+}
+
+// DeleteSound removes a soundboard clip (admin only).
+func (h *Handler) DeleteSound(w http.ResponseWriter, r *http.Request) {
+	_, isOk := h.requireAdmin(w, r)
+	if !isOk {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	filename, err := h.db.DeleteSound(id)
+	if err != nil {
+		errResp(w, http.StatusNotFound, "sound not found")
+		return
+	}
+
+	h.files.Delete(filename)
+
+	h.hub.relayToAll(WSEvent{Type: "sound.delete", Data: map[string]string{"id": id}})
+	ok(w, map[string]string{"message": "deleted"})
+}
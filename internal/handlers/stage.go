@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+)
+
+// ─── Stage channels ───────────────────────────────────────────────────────────
+//
+// A stage is a voice channel (type "stage") split into speakers and audience.
+// Moderators (Mute Members permission) join as speakers; everyone else joins
+// as audience and may only listen.  Audience members raise a hand over the
+// WebSocket and a moderator invites them to speak.  The split is enforced the
+// same way as a server mute: audience media-state is not relayed, the SFU
+// drops their audio, and mesh clients don't play it.
+
+type stageState struct {
+	speakers map[string]bool
+	hands    map[string]bool
+}
+
+func (h *Hub) isStageChannel(channelID string) bool {
+	ch, err := h.db.GetChannelByID(channelID)
+	return err == nil && ch.Type == "stage"
+}
+
+// stageJoin registers userID in a stage, as a speaker if they moderate it.
+func (h *Hub) stageJoin(channelID, userID string, moderator bool) {
+	h.stagesMu.Lock()
+	defer h.stagesMu.Unlock()
+	st := h.stages[channelID]
+	if st == nil {
+		st = &stageState{speakers: make(map[string]bool), hands: make(map[string]bool)}
+		h.stages[channelID] = st
+	}
+	if moderator {
+		st.speakers[userID] = true
+	}
+}
+
+// stageLeave forgets userID's role once they have left the stage.
+func (h *Hub) stageLeave(channelID, userID string) bool {
+	h.stagesMu.Lock()
+	defer h.stagesMu.Unlock()
+	st := h.stages[channelID]
+	if st == nil {
+		return false
+	}
+	delete(st.speakers, userID)
+	delete(st.hands, userID)
+	return true
+}
+
+// dropStageIfEmpty discards a stage's state once nobody is left in it.
+func (h *Hub) dropStageIfEmpty(channelID string) {
+	h.voiceRoomsMu.RLock()
+	local := len(h.voiceRooms[channelID])
+	h.voiceRoomsMu.RUnlock()
+	if local > 0 || len(h.remoteVoiceParticipants()[channelID]) > 0 {
+		return
+	}
+	h.stagesMu.Lock()
+	delete(h.stages, channelID)
+	h.stagesMu.Unlock()
+}
+
+// stageAudience reports whether userID is in a stage without speaking rights.
+func (h *Hub) stageAudience(channelID, userID string) bool {
+	h.stagesMu.Lock()
+	defer h.stagesMu.Unlock()
+	st := h.stages[channelID]
+	return st != nil && !st.speakers[userID]
+}
+
+func (h *Hub) stageSnapshot(channelID string) map[string]interface{} {
+	h.stagesMu.Lock()
+	defer h.stagesMu.Unlock()
+	speakers, hands := []string{}, []string{}
+	if st := h.stages[channelID]; st != nil {
+		for uid := range st.speakers {
+			speakers = append(speakers, uid)
+		}
+		for uid := range st.hands {
+			hands = append(hands, uid)
+		}
+	}
+	sort.Strings(speakers)
+	sort.Strings(hands)
+	return map[string]interface{}{"channel_id": channelID, "speakers": speakers, "hands": hands}
+}
+
+func (h *Hub) broadcastStage(channelID string) {
+	h.relayToVoiceRoom(channelID, WSEvent{Type: "stage.state", Data: h.stageSnapshot(channelID)}, nil)
+}
+
+// setStageSpeaker promotes userID to speaker or moves them to the audience.
+func (h *Hub) setStageSpeaker(channelID, userID string, speaker bool) bool {
+	h.stagesMu.Lock()
+	st := h.stages[channelID]
+	if st != nil {
+		delete(st.hands, userID)
+		if speaker {
+			st.speakers[userID] = true
+		} else {
+			delete(st.speakers, userID)
+		}
+	}
+	h.stagesMu.Unlock()
+	if st == nil {
+		return false
+	}
+	if h.sfu != nil {
+		h.sfu.Resync(channelID)
+	}
+	h.broadcastStage(channelID)
+	return true
+}
+
+func (h *Hub) setStageHand(channelID, userID string, raised bool) {
+	h.stagesMu.Lock()
+	st := h.stages[channelID]
+	changed := false
+	if st != nil && !st.speakers[userID] && st.hands[userID] != raised {
+		if raised {
+			st.hands[userID] = true
+		} else {
+			delete(st.hands, userID)
+		}
+		changed = true
+	}
+	h.stagesMu.Unlock()
+	if changed {
+		h.broadcastStage(channelID)
+	}
+}
+
+// applyStageEvent adopts a stage.state published by another instance.
+func (h *Hub) applyStageEvent(evt WSEvent) {
+	raw, err := json.Marshal(evt.Data)
+	if err != nil {
+		return
+	}
+	var d struct {
+		ChannelID string   `json:"channel_id"`
+		Speakers  []string `json:"speakers"`
+		Hands     []string `json:"hands"`
+	}
+	if json.Unmarshal(raw, &d) != nil || d.ChannelID == "" {
+		return
+	}
+	st := &stageState{speakers: make(map[string]bool), hands: make(map[string]bool)}
+	for _, uid := range d.Speakers {
+		st.speakers[uid] = true
+	}
+	for _, uid := range d.Hands {
+		st.hands[uid] = true
+	}
+	h.stagesMu.Lock()
+	h.stages[d.ChannelID] = st
+	h.stagesMu.Unlock()
+}
+
+// InviteStageSpeaker handles POST /api/voice/{channelId}/stage/speakers/{id}.
+func (h *Handler) InviteStageSpeaker(w http.ResponseWriter, r *http.Request) {
+	h.setStageSpeaker(w, r, true)
+}
+
+// RemoveStageSpeaker handles DELETE /api/voice/{channelId}/stage/speakers/{id}.
+// Speakers may always step down themselves.
+func (h *Handler) RemoveStageSpeaker(w http.ResponseWriter, r *http.Request) {
+	h.setStageSpeaker(w, r, false)
+}
+
+func (h *Handler) setStageSpeaker(w http.ResponseWriter, r *http.Request, speaker bool) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	channelID := chi.URLParam(r, "channelId")
+	targetID := chi.URLParam(r, "id")
+	self := !speaker && targetID == u.ID
+	if !self && !h.hasChannelPermission(u, channelID, db.PermMuteMembers) {
+		errResp(w, http.StatusForbidden, "insufficient permissions")
+		return
+	}
+	if !h.hub.userInLocalVoiceRoom(channelID, targetID) && !h.hub.isRemoteVoiceMember(channelID, targetID) {
+		errResp(w, http.StatusNotFound, "user is not on this stage")
+		return
+	}
+	if !h.hub.setStageSpeaker(channelID, targetID, speaker) {
+		errResp(w, http.StatusNotFound, "not a stage channel")
+		return
+	}
+	ok(w, h.hub.stageSnapshot(channelID))
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"chirm/internal/db"
+)
+
+// ─── Admin statistics ────────────────────────────────────────────────────────
+//
+// A background job (see jobs.go) keeps the stats tables (see db/stats.go) up to date, so
+// GET /api/admin/stats only reads a row per day.  Users count as active on
+// the days they had a WebSocket open.
+
+const (
+	statsInterval    = time.Hour
+	statsRetry       = time.Minute // after a failed run, e.g. with the database busy
+	statsBackfill    = 90          // days filled in when there are no stats yet
+	statsMaxDays     = 365
+	statsTopChannels = 10
+)
+
+// refreshStats recomputes yesterday's and today's stats, which may still be
+// changing, or the last statsBackfill days on the first run.
+func (h *Handler) refreshStats(now time.Time) error {
+	for _, userID := range h.hub.connectedUsers() {
+		h.db.RecordActivity(userID, now)
+	}
+	since := now.AddDate(0, 0, -1)
+	if latest, err := time.Parse(db.StatsDay, h.db.LatestStatsDay()); err != nil {
+		since = now.AddDate(0, 0, -statsBackfill)
+	} else if latest.Before(since) {
+		since = latest
+	}
+	return h.db.RefreshStats(since, now, h.storageBytes())
+}
+
+// storageBytes is what uploads and the database take up.
+func (h *Handler) storageBytes() int64 {
+	n, _ := h.db.AttachmentBytes()
+	for _, name := range []string{"chirm.db", "chirm.db-wal"} {
+		if fi, err := os.Stat(filepath.Join(h.dataDir, name)); err == nil {
+			n += fi.Size()
+		}
+	}
+	return n
+}
+
+// connectedUsers returns the IDs of users with a WebSocket open here.
+func (h *Hub) connectedUsers() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	seen := map[string]bool{}
+	var ids []string
+	for client := range h.clients {
+		if !seen[client.userID] {
+			seen[client.userID] = true
+			ids = append(ids, client.userID)
+		}
+	}
+	return ids
+}
+
+// adminStats is the body of GET /api/admin/stats.
+type adminStats struct {
+	Days        []db.DailyStats         `json:"days"`
+	TopChannels []db.ChannelStats       `json:"top_channels"`
+	Push        map[string]db.PushStats `json:"push"` // by platform, over the days
+}
+
+// AdminStats handles GET /api/admin/stats?days=<n> (admin only): figures
+// for each of the last n days (30 by default), with the busiest channels
+// and push deliveries over them.  Days are UTC, and the stats are up to an
+// hour behind.
+func (h *Handler) AdminStats(w http.ResponseWriter, r *http.Request) {
+	if _, isAdmin := h.requireAdmin(w, r); !isAdmin {
+		return
+	}
+	days := 30
+	if n, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && n > 0 && n <= statsMaxDays {
+		days = n
+	}
+	since := time.Now().AddDate(0, 0, 1-days)
+
+	var out adminStats
+	var err error
+	if out.Days, err = h.db.Stats(since); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to load stats")
+		return
+	}
+	if out.TopChannels, err = h.db.TopChannels(since, statsTopChannels); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to load stats")
+		return
+	}
+	if out.Push, err = h.db.PushStatsByKind(since); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to load stats")
+		return
+	}
+	ok(w, out)
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+	mw "chirm/internal/middleware"
+)
+
+// ─── Stickers ────────────────────────────────────────────────────────────────
+//
+// Stickers are server images sent on their own rather than inline in text,
+// so they're stored much larger than emoji.  Admins manage them like emoji;
+// anyone can send one by giving its ID as a message's sticker_id.
+
+// stickerSize is the longest edge stickers are stored at, in pixels.
+// Animated ones can't be resized and must already fit.
+const stickerSize = 320
+
+// ListStickers returns all stickers, for the sticker picker (any
+// authenticated user).
+func (h *Handler) ListStickers(w http.ResponseWriter, r *http.Request) {
+	stickers, err := h.db.ListStickers()
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list stickers")
+		return
+	}
+	ok(w, stickers)
+}
+
+// UploadSticker handles multipart sticker upload (admin only).
+func (h *Handler) UploadSticker(w http.ResponseWriter, r *http.Request) {
+	u, isOk := h.requireAdmin(w, r)
+	if !isOk {
+		return
+	}
+
+	mw.SetBodyLimit(r, 4<<20)
+	if err := r.ParseMultipartForm(4 << 20); err != nil {
+		errResp(w, http.StatusBadRequest, "request too large")
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		errResp(w, http.StatusBadRequest, "sticker name required")
+		return
+	}
+	if len(name) > 32 {
+		errResp(w, http.StatusBadRequest, "sticker name must be 32 characters or fewer")
+		return
+	}
+	description := strings.TrimSpace(r.FormValue("description"))
+	if len(description) > 100 {
+		errResp(w, http.StatusBadRequest, "description must be 100 characters or fewer")
+		return
+	}
+
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		errResp(w, http.StatusBadRequest, "image required")
+		return
+	}
+	defer file.Close()
+
+	if !strings.HasPrefix(header.Header.Get("Content-Type"), "image/") {
+		errResp(w, http.StatusBadRequest, "file must be an image")
+		return
+	}
+	if header.Size > 2*1024*1024 {
+		errResp(w, http.StatusBadRequest, "sticker image must be under 2MB")
+		return
+	}
+
+	raw, err := io.ReadAll(file)
+	if err != nil {
+		errResp(w, http.StatusBadRequest, "failed to read file")
+		return
+	}
+	img, err := prepareCustomImage(raw, stickerSize, stickerSize)
+	if err != nil {
+		errResp(w, http.StatusBadRequest, "invalid sticker image: "+err.Error())
+		return
+	}
+	s := db.Sticker{Name: name, Description: description, UploaderID: u.ID, Animated: img.animated}
+	if s.Filename, s.StaticFilename, err = h.storeCustomImage("sticker", img); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to save file")
+		return
+	}
+
+	sticker, err := h.db.CreateSticker(s)
+	if err != nil {
+		h.removeStickerFiles(&s)
+		if strings.Contains(err.Error(), "UNIQUE") {
+			errResp(w, http.StatusConflict, "a sticker with that name already exists")
+			return
+		}
+		errResp(w, http.StatusInternalServerError, "failed to create sticker")
+		return
+	}
+
+	h.hub.relayToAll(WSEvent{Type: "sticker.new", Data: sticker})
+	created(w, sticker)
+}
+
+// DeleteSticker removes a sticker (admin only).  Messages that used it
+// show it as unavailable.
+func (h *Handler) DeleteSticker(w http.ResponseWriter, r *http.Request) {
+	_, isOk := h.requireAdmin(w, r)
+	if !isOk {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	sticker, err := h.db.DeleteSticker(id)
+	if err != nil {
+		errResp(w, http.StatusNotFound, "sticker not found")
+		return
+	}
+
+	h.removeStickerFiles(sticker)
+
+	h.hub.relayToAll(WSEvent{Type: "sticker.delete", Data: map[string]string{"id": id}})
+	ok(w, map[string]string{"message": "deleted"})
+}
+
+func (h *Handler) removeStickerFiles(s *db.Sticker) {
+	h.files.Delete(s.Filename)
+	if s.StaticFilename != "" {
+		h.files.Delete(s.StaticFilename)
+	}
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+)
+
+// ─── Server templates ────────────────────────────────────────────────────────
+//
+// A template is a starting set of roles, categories and channels for a kind
+// of community.  Setup applies one in place of the lone #general, and admins
+// can apply one to a guild later.  Applying only adds: a role, category or
+// channel whose name the guild already has is reused, so applying twice
+// changes nothing.
+
+// ServerTemplate is a starting layout for a guild.
+type ServerTemplate struct {
+	ID          string             `json:"id"`
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Roles       []templateRole     `json:"roles"`
+	Categories  []templateCategory `json:"categories"`
+}
+
+type templateRole struct {
+	Name        string `json:"name"`
+	Color       string `json:"color"`
+	Permissions int    `json:"permissions"`
+}
+
+type templateCategory struct {
+	Name     string            `json:"name"` // "" for channels outside any category
+	Channels []templateChannel `json:"channels"`
+}
+
+type templateChannel struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type"`
+	Emoji       string `json:"emoji,omitempty"`
+	// PostedBy makes a channel read-only for @everyone but this role, for
+	// announcements and the like.
+	PostedBy string `json:"posted_by,omitempty"`
+}
+
+// Permissions for the roles templates make.
+const (
+	moderatorPermissions = db.DefaultEveryonePermissions | db.PermManageMessages | db.PermMuteMembers
+	organizerPermissions = moderatorPermissions | db.PermManageChannels | db.PermBroadcast | db.PermManageEvents
+)
+
+var serverTemplates = []ServerTemplate{
+	{
+		ID:          "default",
+		Name:        "Just the basics",
+		Description: "One #general channel; build the rest yourself.",
+		Categories: []templateCategory{
+			{Channels: []templateChannel{{Name: "general", Description: "General discussion", Type: "text"}}},
+		},
+	},
+	{
+		ID:          "gaming",
+		Name:        "Gaming",
+		Description: "Announcements, clips and looking-for-group chat, with voice lobbies and a stage for streams.",
+		Roles: []templateRole{
+			{Name: "Moderator", Color: "#E67E22", Permissions: moderatorPermissions},
+		},
+		Categories: []templateCategory{
+			{Name: "Info", Channels: []templateChannel{
+				{Name: "rules", Description: "Read these first", Type: "text", Emoji: "📜", PostedBy: "Moderator"},
+				{Name: "announcements", Description: "News and events", Type: "text", Emoji: "📣", PostedBy: "Moderator"},
+			}},
+			{Name: "Text", Channels: []templateChannel{
+				{Name: "general", Description: "General discussion", Type: "text"},
+				{Name: "clips", Description: "Show off your best plays", Type: "text", Emoji: "🎬"},
+				{Name: "looking-for-group", Description: "Find people to play with", Type: "text", Emoji: "🎮"},
+			}},
+			{Name: "Voice", Channels: []templateChannel{
+				{Name: "Lobby", Type: "voice"},
+				{Name: "Squad 1", Type: "voice"},
+				{Name: "Squad 2", Type: "voice"},
+				{Name: "Stream", Description: "Watch someone play", Type: "stage"},
+			}},
+		},
+	},
+	{
+		ID:          "family",
+		Name:        "Family",
+		Description: "A home for family chat, photos and plans, with a room for calls.",
+		Roles: []templateRole{
+			{Name: "Parent", Color: "#3498DB", Permissions: organizerPermissions},
+		},
+		Categories: []templateCategory{
+			{Name: "Home", Channels: []templateChannel{
+				{Name: "general", Description: "Family chat", Type: "text", Emoji: "🏠"},
+				{Name: "announcements", Description: "Important family news", Type: "text", Emoji: "📣", PostedBy: "Parent"},
+				{Name: "photos", Description: "Share pictures and videos", Type: "text", Emoji: "📷"},
+				{Name: "plans", Description: "Trips, dinners and birthdays", Type: "text", Emoji: "📅"},
+			}},
+			{Name: "Voice", Channels: []templateChannel{
+				{Name: "Living Room", Type: "voice"},
+			}},
+		},
+	},
+	{
+		ID:          "study",
+		Name:        "Study group",
+		Description: "Announcements, resources and homework help, with study rooms and a lecture stage.",
+		Roles: []templateRole{
+			{Name: "Tutor", Color: "#2ECC71", Permissions: organizerPermissions},
+		},
+		Categories: []templateCategory{
+			{Name: "Info", Channels: []templateChannel{
+				{Name: "announcements", Description: "Deadlines and schedule changes", Type: "text", Emoji: "📣", PostedBy: "Tutor"},
+				{Name: "resources", Description: "Notes, links and past papers", Type: "text", Emoji: "📚"},
+			}},
+			{Name: "Study", Channels: []templateChannel{
+				{Name: "general", Description: "General discussion", Type: "text"},
+				{Name: "homework-help", Description: "Stuck? Ask here", Type: "text", Emoji: "✏️"},
+				{Name: "off-topic", Description: "Everything else", Type: "text"},
+			}},
+			{Name: "Voice", Channels: []templateChannel{
+				{Name: "Study Room 1", Type: "voice"},
+				{Name: "Study Room 2", Type: "voice"},
+				{Name: "Lecture", Description: "Tutors speak, everyone listens", Type: "stage"},
+			}},
+		},
+	},
+}
+
+func findTemplate(id string) *ServerTemplate {
+	if id == "" {
+		id = "default"
+	}
+	for i := range serverTemplates {
+		if serverTemplates[i].ID == id {
+			return &serverTemplates[i]
+		}
+	}
+	return nil
+}
+
+// templateResult is what applying a template added.
+type templateResult struct {
+	Roles      []db.Role            `json:"roles"`
+	Categories []db.ChannelCategory `json:"categories"`
+	Channels   []db.Channel         `json:"channels"`
+}
+
+// applyTemplate adds t's roles, categories and channels to guildID, which
+// must already have its @everyone role.
+func (h *Handler) applyTemplate(guildID string, t *ServerTemplate) (*templateResult, error) {
+	res := &templateResult{Roles: []db.Role{}, Categories: []db.ChannelCategory{}, Channels: []db.Channel{}}
+
+	roles, err := h.db.ListRoles(guildID)
+	if err != nil {
+		return nil, err
+	}
+	roleIDs := map[string]string{}
+	for _, r := range roles {
+		roleIDs[r.Name] = r.ID
+	}
+	for _, tr := range t.Roles {
+		if roleIDs[tr.Name] != "" {
+			continue
+		}
+		r, err := h.db.CreateRole(guildID, tr.Name, tr.Color, tr.Permissions)
+		if err != nil {
+			return nil, err
+		}
+		roleIDs[r.Name] = r.ID
+		res.Roles = append(res.Roles, *r)
+	}
+
+	cats, err := h.db.ListCategories(guildID)
+	if err != nil {
+		return nil, err
+	}
+	catIDs := map[string]string{}
+	for _, c := range cats {
+		catIDs[c.Name] = c.ID
+	}
+	channels, err := h.db.ListChannels(guildID)
+	if err != nil {
+		return nil, err
+	}
+	haveChannel := map[string]bool{}
+	for _, c := range channels {
+		haveChannel[c.Type+"\x00"+c.Name] = true
+	}
+
+	for _, tc := range t.Categories {
+		catID := ""
+		if tc.Name != "" {
+			if catID = catIDs[tc.Name]; catID == "" {
+				c, err := h.db.CreateCategory(guildID, tc.Name)
+				if err != nil {
+					return nil, err
+				}
+				catID, catIDs[c.Name] = c.ID, c.ID
+				res.Categories = append(res.Categories, *c)
+			}
+		}
+		for _, tch := range tc.Channels {
+			if haveChannel[tch.Type+"\x00"+tch.Name] {
+				continue
+			}
+			ch, err := h.db.CreateChannel(guildID, tch.Name, tch.Description, tch.Type, tch.Emoji, catID)
+			if err != nil {
+				return nil, err
+			}
+			if tch.PostedBy != "" {
+				h.db.SetChannelOverride(ch.ID, roleIDs["@everyone"], 0, db.PermSendMessages)
+				h.db.SetChannelOverride(ch.ID, roleIDs[tch.PostedBy], db.PermSendMessages, 0)
+			}
+			haveChannel[tch.Type+"\x00"+tch.Name] = true
+			res.Channels = append(res.Channels, *ch)
+		}
+	}
+	return res, nil
+}
+
+// ListTemplates handles GET /api/setup/templates.  It needs no sign-in, as
+// the setup wizard shows them before there's anyone to sign in as.
+func (h *Handler) ListTemplates(w http.ResponseWriter, r *http.Request) {
+	ok(w, serverTemplates)
+}
+
+// ApplyTemplateRequest is the body of POST /api/guilds/{id}/template.
+type ApplyTemplateRequest struct {
+	Template string `json:"template"`
+}
+
+// ApplyTemplate handles POST /api/guilds/{id}/template: it adds a template's
+// roles, categories and channels to a guild that's already running.
+func (h *Handler) ApplyTemplate(w http.ResponseWriter, r *http.Request) {
+	guildID := chi.URLParam(r, "id")
+	u, isAdmin := h.requireGuildAdmin(w, r, guildID)
+	if !isAdmin {
+		return
+	}
+	var req ApplyTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	t := findTemplate(req.Template)
+	if t == nil {
+		errResp(w, http.StatusBadRequest, "unknown template")
+		return
+	}
+	res, err := h.applyTemplate(guildID, t)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to apply template")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{ActorID: u.ID, Action: "guild.template", TargetID: guildID, Details: t.ID})
+	for _, c := range res.Categories {
+		h.hub.BroadcastToGuild(guildID, WSEvent{Type: "category.new", Data: c})
+	}
+	for _, c := range res.Channels {
+		h.hub.BroadcastToGuild(guildID, WSEvent{Type: "channel.new", Data: c})
+	}
+	ok(w, res)
+}
@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"chirm/internal/storage"
+)
+
+// ─── Image thumbnails ────────────────────────────────────────────────────────
+//
+// Uploaded images get scaled-down copies stored beside the original, named
+// "<id>.<size>.jpg" (or .png when the image may be transparent), served as
+// /uploads/<file>?size=<size>.  A size is only generated when the original is
+// bigger than it; otherwise the original is served.  WebP can't be decoded
+// with the standard library, and GIFs are left alone so they keep animating,
+// so both only get their dimensions recorded.
+
+var thumbnailSizes = map[string]int{ // name → longest edge in pixels
+	"thumb":  256,
+	"medium": 1024,
+}
+
+// maxThumbnailPixels bounds the images we're willing to decode.
+const maxThumbnailPixels = 50_000_000
+
+var errImageTooLarge = errors.New("image dimensions too large")
+
+// imageDimensions reads an image's displayed width and height without
+// decoding it, or zeros if the format isn't supported.
+func imageDimensions(r io.ReadSeeker) (int, int) {
+	r.Seek(0, io.SeekStart)
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return webpDimensions(r)
+	}
+	if jpegOrientation(r) >= 5 {
+		return cfg.Height, cfg.Width
+	}
+	return cfg.Width, cfg.Height
+}
+
+// webpDimensions reads the canvas size from a WebP header.
+func webpDimensions(r io.ReadSeeker) (int, int) {
+	var b [30]byte
+	r.Seek(0, io.SeekStart)
+	if _, err := io.ReadFull(r, b[:]); err != nil || string(b[:4]) != "RIFF" || string(b[8:12]) != "WEBP" {
+		return 0, 0
+	}
+	le24 := func(p []byte) int { return int(p[0]) | int(p[1])<<8 | int(p[2])<<16 }
+	switch string(b[12:16]) {
+	case "VP8X": // extended: 24-bit canvas size minus one
+		return le24(b[24:]) + 1, le24(b[27:]) + 1
+	case "VP8 ": // lossy: 14-bit sizes after the frame tag and start code
+		return int(binary.LittleEndian.Uint16(b[26:]) & 0x3FFF), int(binary.LittleEndian.Uint16(b[28:]) & 0x3FFF)
+	case "VP8L": // lossless: 14-bit sizes minus one, packed after the signature
+		bits := binary.LittleEndian.Uint32(b[21:])
+		return int(bits&0x3FFF) + 1, int(bits>>14&0x3FFF) + 1
+	}
+	return 0, 0
+}
+
+// generateThumbnails stores the scaled copies of the image uploaded as name,
+// reading it from src.  Errors are not fatal to the upload, which simply
+// keeps serving the original.
+func generateThumbnails(store storage.Store, name, mimeType string, src io.ReadSeeker) error {
+	if mimeType != "image/jpeg" && mimeType != "image/png" {
+		return nil
+	}
+	rgba, err := decodeImage(src)
+	if err == errImageTooLarge {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for size, edge := range thumbnailSizes {
+		w, h, smaller := fitWithin(rgba.Rect.Dx(), rgba.Rect.Dy(), edge)
+		if !smaller {
+			continue
+		}
+		if err := writeThumbnail(store, name, size, mimeType, downscale(rgba, w, h)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fitWithin scales w×h down to fit an edge×edge box, keeping the aspect
+// ratio.  It reports false, with the size unchanged, if it already fits.
+func fitWithin(w, h, edge int) (int, int, bool) {
+	switch {
+	case w <= edge && h <= edge:
+		return w, h, false
+	case w >= h:
+		return edge, max(1, h*edge/w), true
+	default:
+		return max(1, w*edge/h), edge, true
+	}
+}
+
+// decodeImage decodes a JPEG, PNG or GIF (its first frame) the way up
+// browsers show it.  Images over maxThumbnailPixels are refused before
+// decoding, so a small file can't claim a huge canvas.
+func decodeImage(r io.ReadSeeker) (*image.RGBA, error) {
+	r.Seek(0, io.SeekStart)
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxThumbnailPixels {
+		return nil, errImageTooLarge
+	}
+	orientation := jpegOrientation(r)
+	r.Seek(0, io.SeekStart)
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, src.Bounds().Min, draw.Src)
+	return orient(rgba, orientation), nil
+}
+
+func writeThumbnail(store storage.Store, name, size, mimeType string, img *image.RGBA) error {
+	var buf bytes.Buffer
+	ext, contentType := ".png", "image/png"
+	if mimeType == "image/jpeg" {
+		ext, contentType = ".jpg", "image/jpeg"
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 82}); err != nil {
+			return err
+		}
+	} else if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	return store.Put(thumbnailBase(name, size)+ext, &buf, int64(buf.Len()), contentType)
+}
+
+func thumbnailBase(name, size string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + "." + size
+}
+
+// isThumbnailName reports whether name looks like one of the scaled copies
+// generateThumbnails stores: <base>.<size>.jpg or .png.
+func isThumbnailName(name string) bool {
+	ext := filepath.Ext(name)
+	if ext != ".jpg" && ext != ".png" {
+		return false
+	}
+	_, ok := thumbnailSizes[strings.TrimPrefix(filepath.Ext(strings.TrimSuffix(name, ext)), ".")]
+	return ok
+}
+
+// thumbnailName returns the stored copy of the upload name for size, or ""
+// if there isn't one.
+func thumbnailName(store storage.Store, name, size string) string {
+	if _, ok := thumbnailSizes[size]; !ok {
+		return ""
+	}
+	for _, ext := range []string{".jpg", ".png"} {
+		if t := thumbnailBase(name, size) + ext; store.Exists(t) {
+			return t
+		}
+	}
+	return ""
+}
+
+// ─── Avatars and emoji ───────────────────────────────────────────────────────
+
+// shrinkImage re-encodes an uploaded picture so its longest edge is at most
+// edge pixels, returning the new file's bytes and extension: PNG when the
+// picture has any transparency, JPEG otherwise.  This also drops EXIF data
+// and anything trailing the image.  Animated GIFs that already fit, and
+// WebP (which the standard library can't decode) that fits, are kept as
+// they are; bigger ones of either are refused or flattened.
+func shrinkImage(r io.ReadSeeker, edge int) ([]byte, string, error) {
+	r.Seek(0, io.SeekStart)
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		w, h := webpDimensions(r)
+		if w == 0 {
+			return nil, "", errors.New("unsupported image format")
+		}
+		if _, _, smaller := fitWithin(w, h, edge); smaller {
+			return nil, "", fmt.Errorf("WebP images must be at most %dx%d", edge, edge)
+		}
+		r.Seek(0, io.SeekStart)
+		data, err := io.ReadAll(r)
+		return stripImageMetadata(data, "image/webp"), ".webp", err
+	}
+	if cfg.Width*cfg.Height > maxThumbnailPixels {
+		return nil, "", errImageTooLarge
+	}
+	if format == "gif" {
+		if _, _, smaller := fitWithin(cfg.Width, cfg.Height, edge); !smaller {
+			r.Seek(0, io.SeekStart)
+			data, err := io.ReadAll(r)
+			return data, ".gif", err
+		}
+	}
+
+	rgba, err := decodeImage(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if w, h, smaller := fitWithin(rgba.Rect.Dx(), rgba.Rect.Dy(), edge); smaller {
+		rgba = downscale(rgba, w, h)
+	}
+	var buf bytes.Buffer
+	if rgba.Opaque() {
+		err = jpeg.Encode(&buf, rgba, &jpeg.Options{Quality: 88})
+		return buf.Bytes(), ".jpg", err
+	}
+	err = png.Encode(&buf, rgba)
+	return buf.Bytes(), ".png", err
+}
+
+// removeUpload deletes an uploaded file along with its thumbnails and
+// video poster.
+func removeUpload(store storage.Store, name string) {
+	store.Delete(name)
+	store.Delete(thumbnailBase(name, "poster") + ".jpg")
+	for size := range thumbnailSizes {
+		for _, ext := range []string{".jpg", ".png"} {
+			store.Delete(thumbnailBase(name, size) + ext)
+		}
+	}
+}
+
+// downscale shrinks src to w×h by averaging the source pixels under each
+// destination pixel, which is accurate for the large reductions thumbnails
+// need.  Pixels are premultiplied, so transparent edges don't darken.
+func downscale(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for dy := 0; dy < h; dy++ {
+		y0, y1 := dy*sh/h, max((dy+1)*sh/h, dy*sh/h+1)
+		for dx := 0; dx < w; dx++ {
+			x0, x1 := dx*sw/w, max((dx+1)*sw/w, dx*sw/w+1)
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride+x0*4 : y*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					r += uint64(row[i])
+					g += uint64(row[i+1])
+					b += uint64(row[i+2])
+					a += uint64(row[i+3])
+					n++
+				}
+			}
+			o := dy*dst.Stride + dx*4
+			dst.Pix[o], dst.Pix[o+1], dst.Pix[o+2], dst.Pix[o+3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// orient applies an EXIF orientation (1–8) so thumbnails, which carry no
+// EXIF data, come out the same way up as browsers show the original.
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var nx, ny int
+			switch orientation {
+			case 2: // mirrored
+				nx, ny = w-1-x, y
+			case 3: // rotated 180°
+				nx, ny = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				nx, ny = x, h-1-y
+			case 5: // transposed
+				nx, ny = y, x
+			case 6: // rotated 90° clockwise
+				nx, ny = h-1-y, x
+			case 7: // transversed
+				nx, ny = h-1-y, w-1-x
+			case 8: // rotated 90° anticlockwise
+				nx, ny = y, w-1-x
+			}
+			copy(dst.Pix[ny*dst.Stride+nx*4:ny*dst.Stride+nx*4+4], src.Pix[y*src.Stride+x*4:y*src.Stride+x*4+4])
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG, or 0 if r isn't a
+// JPEG or has none.  Only the segments before the image data are read.
+func jpegOrientation(r io.ReadSeeker) int {
+	r.Seek(0, io.SeekStart)
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return 0
+	}
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil || hdr[0] != 0xFF {
+			return 0
+		}
+		marker, length := hdr[1], int(binary.BigEndian.Uint16(hdr[2:]))
+		if marker == 0xDA || length < 2 { // start of scan: no EXIF before the image
+			return 0
+		}
+		if marker != 0xE1 {
+			if _, err := r.Seek(int64(length-2), io.SeekCurrent); err != nil {
+				return 0
+			}
+			continue
+		}
+		seg := make([]byte, length-2)
+		if _, err := io.ReadFull(r, seg); err != nil {
+			return 0
+		}
+		if o := exifOrientation(seg); o != 0 {
+			return o
+		}
+	}
+}
+
+// exifOrientation finds tag 0x0112 in IFD0 of an APP1 "Exif" segment.
+func exifOrientation(seg []byte) int {
+	if len(seg) < 14 || string(seg[:6]) != "Exif\x00\x00" {
+		return 0
+	}
+	tiff := seg[6:]
+	var bo binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(bo.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	n := int(bo.Uint16(tiff[ifd:]))
+	for i := 0; i < n; i++ {
+		e := ifd + 2 + i*12
+		if e+12 > len(tiff) {
+			return 0
+		}
+		if bo.Uint16(tiff[e:]) == 0x0112 {
+			return int(bo.Uint16(tiff[e+8:]))
+		}
+	}
+	return 0
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+)
+
+// maxPersonalTokens is how many tokens one person may have.
+const maxPersonalTokens = 20
+
+// ListPersonalTokens handles GET /api/me/tokens: the caller's tokens.
+func (h *Handler) ListPersonalTokens(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	tokens, err := h.db.ListPersonalTokens(u.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list tokens")
+		return
+	}
+	ok(w, tokens)
+}
+
+// CreatePersonalTokenRequest is the body of POST /api/me/tokens.
+type CreatePersonalTokenRequest struct {
+	Name string `json:"name"` // to tell it apart, e.g. the client it's for
+}
+
+// personalTokenCreated is a new token, shown this once.
+type personalTokenCreated struct {
+	db.PersonalToken
+	Token string `json:"token"`
+}
+
+// CreatePersonalToken handles POST /api/me/tokens: a new token for the
+// caller.  Bots have theirs already.
+func (h *Handler) CreatePersonalToken(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if u.Bot {
+		errResp(w, http.StatusForbidden, "bots can't make tokens")
+		return
+	}
+	var req CreatePersonalTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 64 {
+		errResp(w, http.StatusBadRequest, "name must be 1-64 characters")
+		return
+	}
+	if tokens, err := h.db.ListPersonalTokens(u.ID); err == nil && len(tokens) >= maxPersonalTokens {
+		errResp(w, http.StatusBadRequest, "you have too many tokens; delete one first")
+		return
+	}
+	t, token, err := h.db.CreatePersonalToken(u.ID, req.Name)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to create token")
+		return
+	}
+	created(w, personalTokenCreated{PersonalToken: *t, Token: token})
+}
+
+// DeletePersonalToken handles DELETE /api/me/tokens/{id}.  IRC connections
+// signed in with it are closed.
+func (h *Handler) DeletePersonalToken(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	id := chi.URLParam(r, "id")
+	found, err := h.db.DeletePersonalToken(u.ID, id)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to delete token")
+		return
+	}
+	if !found {
+		errResp(w, http.StatusNotFound, "token not found")
+		return
+	}
+	h.hub.disconnectToken(id)
+	ok(w, map[string]string{"message": "token deleted"})
+}
@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"chirm/internal/db"
+)
+
+// ─── Transcoding ─────────────────────────────────────────────────────────────
+//
+// Phones record HEVC video and desktop tools export WAV, neither of which
+// every browser plays.  With transcoding enabled, each video and audio
+// upload is queued for a background worker that hands it to a Transcoder;
+// if that converts it, the attachment is switched to the new file and the
+// original is deleted.  Progress is kept in the attachment's status
+// ("queued", "processing", "done" or "failed") and pushed to the channel as
+// attachment.update events.  The original stays playable where it can be
+// until then, and for good if conversion fails.
+
+// A Transcoder converts uploads into formats browsers can play.
+type Transcoder interface {
+	// Target decides what the file at path, uploaded as mimeType, should
+	// become.  ok is false if it can be served as it is.
+	Target(ctx context.Context, path, mimeType string) (outMime, outExt string, ok bool, err error)
+	// Transcode converts src into dst, whose name ends in the extension
+	// Target returned.
+	Transcode(ctx context.Context, src, dst, outMime string) error
+}
+
+// transcodeTimeout bounds a single conversion.
+const transcodeTimeout = 30 * time.Minute
+
+type transcodeQueue struct {
+	t    Transcoder
+	jobs chan string // attachment IDs
+}
+
+// EnableTranscoding starts workers that run video and audio uploads
+// through t.  Jobs left unfinished by a previous run are picked up again.
+func (h *Handler) EnableTranscoding(t Transcoder, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	h.transcode = &transcodeQueue{t: t, jobs: make(chan string, 256)}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for id := range h.transcode.jobs {
+				h.transcodeAttachment(id)
+			}
+		}()
+	}
+
+	// A restart interrupts whatever was running; start those again too.
+	if ids, err := h.db.AttachmentIDsWithStatus("processing"); err == nil {
+		for _, id := range ids {
+			h.db.ClaimAttachment(id, "processing", "queued")
+		}
+	}
+	if ids, err := h.db.AttachmentIDsWithStatus("queued"); err == nil {
+		for _, id := range ids {
+			h.queueTranscode(id)
+		}
+	}
+}
+
+// transcodes reports whether uploads of mimeType go through the queue.
+func (h *Handler) transcodes(mimeType string) bool {
+	return h.transcode != nil &&
+		(strings.HasPrefix(mimeType, "video/") || strings.HasPrefix(mimeType, "audio/"))
+}
+
+func (h *Handler) queueTranscode(id string) {
+	select {
+	case h.transcode.jobs <- id:
+	default:
+		// Don't hold up the upload while the workers catch up.
+		go func() { h.transcode.jobs <- id }()
+	}
+}
+
+func (h *Handler) transcodeAttachment(id string) {
+	// Another instance of a cluster may have taken it already.
+	if claimed, err := h.db.ClaimAttachment(id, "queued", "processing"); err != nil || !claimed {
+		return
+	}
+	a, err := h.db.GetAttachment(id)
+	if err != nil {
+		return
+	}
+	h.broadcastAttachment(a)
+
+	// A converted file's row is updated along with its filename.
+	if replaced, err := h.transcodeFile(a); !replaced {
+		status := "" // already playable
+		if err != nil {
+			slog.Warn("transcode", "file", a.Filename, "err", err)
+			status = "failed"
+		}
+		h.db.ClaimAttachment(id, "processing", status)
+	}
+	if a, err = h.db.GetAttachment(id); err == nil {
+		h.broadcastAttachment(a)
+	}
+}
+
+// transcodeFile converts a's file if its Transcoder wants to, storing the
+// result and pointing the attachment at it.
+func (h *Handler) transcodeFile(a *db.Attachment) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), transcodeTimeout)
+	defer cancel()
+
+	src, err := h.files.Open(a.Filename)
+	if err != nil {
+		return false, err
+	}
+	in, err := os.CreateTemp("", "chirm-transcode-*")
+	if err != nil {
+		src.Close()
+		return false, err
+	}
+	defer os.Remove(in.Name())
+	_, err = io.Copy(in, src)
+	src.Close()
+	in.Close()
+	if err != nil {
+		return false, err
+	}
+
+	outMime, outExt, ok, err := h.transcode.t.Target(ctx, in.Name(), a.MimeType)
+	if err != nil || !ok {
+		return false, err
+	}
+	out, err := os.CreateTemp("", "chirm-transcode-*"+outExt)
+	if err != nil {
+		return false, err
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+	if err := h.transcode.t.Transcode(ctx, in.Name(), out.Name(), outMime); err != nil {
+		return false, err
+	}
+
+	f, err := os.Open(out.Name())
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	next := *a
+	next.Filename = newID() + outExt
+	next.MimeType = outMime
+	next.Size = fi.Size()
+	next.Poster = ""
+	next.Status = "done"
+	if err := h.files.Put(next.Filename, f, next.Size, outMime); err != nil {
+		return false, err
+	}
+	if strings.HasPrefix(outMime, "video/") && h.video != nil {
+		if info, err := h.video.process(h.files, next.Filename, f); err == nil {
+			next.Width, next.Height, next.Duration, next.Poster = info.Width, info.Height, info.Duration, info.Poster
+		}
+	}
+
+	// The attachment may have been deleted, or converted by another
+	// instance, in the meantime.
+	if ok, err := h.db.ReplaceAttachmentFile(a.Filename, next); err != nil || !ok {
+		removeUpload(h.files, next.Filename)
+		if err == nil {
+			err = fmt.Errorf("attachment changed during transcoding")
+		}
+		return false, err
+	}
+	removeUpload(h.files, a.Filename)
+	return true, nil
+}
+
+// broadcastAttachment tells the channel an attachment's file or status has
+// changed.  Uploads not yet sent in a message have nobody to tell.
+func (h *Handler) broadcastAttachment(a *db.Attachment) {
+	if a.MessageID == "" {
+		return
+	}
+	msg, err := h.db.GetMessageByID(a.MessageID)
+	if err != nil {
+		return
+	}
+	h.hub.BroadcastToChannel(msg.ChannelID, WSEvent{Type: "attachment.update", Data: map[string]interface{}{
+		"channel_id": msg.ChannelID,
+		"message_id": msg.ID,
+		"attachment": a,
+	}})
+}
+
+// ─── ffmpeg ──────────────────────────────────────────────────────────────────
+
+// FFmpegTranscoder converts video that isn't H.264 in MP4 or VP8/VP9/AV1 in
+// WebM to H.264 MP4, and audio other than Opus, Vorbis, MP3 or AAC (WAV,
+// FLAC) to Opus.
+type FFmpegTranscoder struct {
+	ffmpeg, ffprobe string
+}
+
+// NewFFmpegTranscoder looks up the ffmpeg and ffprobe binaries on PATH.
+func NewFFmpegTranscoder(ffmpeg, ffprobe string) (*FFmpegTranscoder, error) {
+	ff, err := exec.LookPath(ffmpeg)
+	if err != nil {
+		return nil, err
+	}
+	fp, err := exec.LookPath(ffprobe)
+	if err != nil {
+		return nil, err
+	}
+	return &FFmpegTranscoder{ffmpeg: ff, ffprobe: fp}, nil
+}
+
+// webCodecs lists the codecs browsers play in each container.  "" stands
+// for a missing stream.
+var webCodecs = map[string]struct{ video, audio []string }{
+	"video/mp4":  {[]string{"h264"}, []string{"", "aac", "mp3"}},
+	"video/webm": {[]string{"vp8", "vp9", "av1"}, []string{"", "opus", "vorbis"}},
+}
+
+func (t *FFmpegTranscoder) Target(ctx context.Context, path, mimeType string) (string, string, bool, error) {
+	out, err := exec.CommandContext(ctx, t.ffprobe, "-v", "error",
+		"-show_entries", "stream=codec_type,codec_name",
+		"-of", "json", path).Output()
+	if err != nil {
+		return "", "", false, fmt.Errorf("ffprobe: %w", err)
+	}
+	var res struct {
+		Streams []struct {
+			Type  string `json:"codec_type"`
+			Codec string `json:"codec_name"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return "", "", false, fmt.Errorf("ffprobe output: %w", err)
+	}
+	var video, audio string
+	for _, s := range res.Streams {
+		switch {
+		case s.Type == "video" && video == "":
+			video = s.Codec
+		case s.Type == "audio" && audio == "":
+			audio = s.Codec
+		}
+	}
+
+	if strings.HasPrefix(mimeType, "audio/") {
+		switch audio {
+		case "opus", "vorbis", "mp3", "aac":
+			return "", "", false, nil
+		case "":
+			return "", "", false, fmt.Errorf("no audio stream")
+		}
+		return "audio/ogg", ".ogg", true, nil
+	}
+	if video == "" {
+		return "", "", false, fmt.Errorf("no video stream")
+	}
+	if web, ok := webCodecs[mimeType]; ok &&
+		containsString(web.video, video) && containsString(web.audio, audio) {
+		return "", "", false, nil
+	}
+	return "video/mp4", ".mp4", true, nil
+}
+
+func (t *FFmpegTranscoder) Transcode(ctx context.Context, src, dst, outMime string) error {
+	args := []string{"-v", "error", "-y", "-i", src}
+	if outMime == "audio/ogg" {
+		args = append(args, "-vn", "-c:a", "libopus", "-b:a", "96k")
+	} else {
+		args = append(args, "-map", "0:v:0", "-map", "0:a:0?",
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+			"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2", // yuv420p needs even sizes
+			"-c:a", "aac", "-b:a", "128k", "-movflags", "+faststart")
+	}
+	var stderr strings.Builder
+	cmd := exec.CommandContext(ctx, t.ffmpeg, append(args, dst)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
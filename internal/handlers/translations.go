@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+	"chirm/internal/logging"
+)
+
+// ─── Machine translation ─────────────────────────────────────────────────────
+//
+// Members can ask for a message in their own language, translated by a
+// LibreTranslate server or DeepL, whichever an admin set up in the settings
+// with its URL and key.  The key stays on the server, and the service sees
+// only the text, not who asked.  Translations are saved (see
+// db/translations.go), so a message goes to the service once per language
+// until it's edited.
+
+const (
+	translateProviderLibre = "libretranslate"
+	translateProviderDeepL = "deepl"
+
+	translateTimeout = 15 * time.Second
+	translationTTL   = 30 * 24 * time.Hour
+)
+
+// DeepL's APIs: the free one for keys ending :fx, and the paid one.
+var (
+	deeplFreeAPI = "https://api-free.deepl.com"
+	deeplProAPI  = "https://api.deepl.com"
+)
+
+var translateClient = &http.Client{Timeout: translateTimeout}
+
+var errTranslateProvider = errors.New("the translation service refused the request")
+
+// translateLanguage is a language tag: "de", "pt-BR", "zh-Hans".
+var translateLanguage = regexp.MustCompile(`^[a-zA-Z]{2,3}(?:[-_][a-zA-Z0-9]{2,4})?$`)
+
+// translateConfig is the service admins set: its provider, the URL to
+// send requests to and the key, or "" if translation is off.
+// LibreTranslate needs a URL, its key being optional; DeepL needs a key,
+// and its URL is only for going through a proxy.
+func (h *Handler) translateConfig() (provider, endpoint, key string) {
+	provider, _ = h.db.GetSetting("translate_provider")
+	endpoint, _ = h.db.GetSetting("translate_url")
+	key, _ = h.db.GetSetting("translate_api_key")
+	endpoint = strings.TrimRight(endpoint, "/")
+	switch provider {
+	case translateProviderLibre:
+		if endpoint == "" {
+			return "", "", ""
+		}
+	case translateProviderDeepL:
+		if key == "" {
+			return "", "", ""
+		}
+		if endpoint == "" {
+			endpoint = deeplProAPI
+			if strings.HasSuffix(key, ":fx") {
+				endpoint = deeplFreeAPI
+			}
+		}
+	default:
+		return "", "", ""
+	}
+	return provider, endpoint, key
+}
+
+// postTranslateJSON posts body to a translation service and decodes its
+// answer into v.
+func postTranslateJSON(endpoint string, header http.Header, body, v interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := translateClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w (HTTP %d)", errTranslateProvider, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// translateLibre translates text into target with LibreTranslate, returning
+// the translation and the language it guessed text was in.
+func translateLibre(endpoint, key, text, target string) (string, string, error) {
+	var resp struct {
+		TranslatedText   string `json:"translatedText"`
+		DetectedLanguage struct {
+			Language string `json:"language"`
+		} `json:"detectedLanguage"`
+	}
+	body := map[string]string{"q": text, "source": "auto", "target": target, "format": "text"}
+	if key != "" {
+		body["api_key"] = key
+	}
+	if err := postTranslateJSON(endpoint+"/translate", nil, body, &resp); err != nil {
+		return "", "", err
+	}
+	return resp.TranslatedText, resp.DetectedLanguage.Language, nil
+}
+
+// translateDeepL translates text into target with DeepL, returning the
+// translation and the language DeepL found text was in.
+func translateDeepL(endpoint, key, text, target string) (string, string, error) {
+	var resp struct {
+		Translations []struct {
+			DetectedSourceLanguage string `json:"detected_source_language"`
+			Text                   string `json:"text"`
+		} `json:"translations"`
+	}
+	header := http.Header{"Authorization": {"DeepL-Auth-Key " + key}}
+	body := map[string]interface{}{"text": []string{text}, "target_lang": deeplTarget(target)}
+	if err := postTranslateJSON(endpoint+"/v2/translate", header, body, &resp); err != nil {
+		return "", "", err
+	}
+	if len(resp.Translations) == 0 {
+		return "", "", errTranslateProvider
+	}
+	t := resp.Translations[0]
+	return t.Text, strings.ToLower(t.DetectedSourceLanguage), nil
+}
+
+// deeplTarget is target as DeepL names it: upper case, and with a variant
+// for English and Portuguese, which DeepL wants one for.
+func deeplTarget(target string) string {
+	switch t := strings.ToUpper(target); t {
+	case "EN":
+		return "EN-US"
+	case "PT":
+		return "PT-BR"
+	default:
+		return t
+	}
+}
+
+// TranslateMessageRequest is the body of POST /api/messages/{id}/translate.
+type TranslateMessageRequest struct {
+	// The language to translate into, like "de" or "pt-BR"; the first of
+	// the browser's Accept-Language if left out.
+	Target string `json:"target"`
+}
+
+// TranslateMessage handles POST /api/messages/{id}/translate: the message's
+// text in another language, from the cache if it's been asked for before.
+func (h *Handler) TranslateMessage(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	provider, endpoint, key := h.translateConfig()
+	if provider == "" {
+		errResp(w, http.StatusServiceUnavailable, "translation is not configured on this server")
+		return
+	}
+
+	msg, err := h.db.GetMessageByID(chi.URLParam(r, "id"))
+	if err != nil || !h.db.CanAccessGuild(u, h.db.ChannelGuild(msg.ChannelID)) ||
+		!h.hasChannelPermission(u, msg.ChannelID, db.PermReadMessages) {
+		errResp(w, http.StatusNotFound, "message not found")
+		return
+	}
+	if strings.TrimSpace(msg.Content) == "" {
+		errResp(w, http.StatusBadRequest, "message has no text to translate")
+		return
+	}
+
+	var req TranslateMessageRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errResp(w, http.StatusBadRequest, "invalid request")
+			return
+		}
+	}
+	target := strings.TrimSpace(req.Target)
+	if target == "" {
+		target, _, _ = strings.Cut(r.Header.Get("Accept-Language"), ",")
+		target, _, _ = strings.Cut(strings.TrimSpace(target), ";")
+	}
+	if target == "" {
+		target = "en"
+	}
+	if !translateLanguage.MatchString(target) {
+		errResp(w, http.StatusBadRequest, "target must be a language code, like de or pt-BR")
+		return
+	}
+	target = strings.ReplaceAll(target, "_", "-")
+	// LibreTranslate only knows languages, not regions.
+	if provider == translateProviderLibre {
+		target, _, _ = strings.Cut(target, "-")
+	}
+	target = strings.ToLower(target)
+
+	if t, err := h.db.GetTranslation(msg.ID, target, msg.Content); err == nil {
+		ok(w, t)
+		return
+	}
+	var text, source string
+	if provider == translateProviderDeepL {
+		text, source, err = translateDeepL(endpoint, key, msg.Content, target)
+	} else {
+		text, source, err = translateLibre(endpoint, key, msg.Content, target)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("translation failed", "provider", provider, "err", err)
+		errResp(w, http.StatusBadGateway, "translation failed")
+		return
+	}
+	t := db.Translation{
+		MessageID:  msg.ID,
+		Target:     target,
+		SourceLang: source,
+		Content:    text,
+		Provider:   provider,
+		CreatedAt:  time.Now(),
+	}
+	if err := h.db.SaveTranslation(t, msg.Content); err != nil {
+		logging.FromContext(r.Context()).Warn("saving translation", "err", err)
+	}
+	ok(w, t)
+}
@@ -1,17 +1,25 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+	"chirm/internal/logging"
+	mw "chirm/internal/middleware"
+	"chirm/internal/storage"
 )
 
 var allowedMimeTypes = map[string]bool{
@@ -31,6 +39,32 @@ var allowedMimeTypes = map[string]bool{
 	"application/zip":  true,
 }
 
+// maxUploadBatch is how many files UploadBatch accepts in one request.
+const maxUploadBatch = 10
+
+// uploadError is why one file of an upload was refused, with the status
+// to report it under.
+type uploadError struct {
+	status int
+	msg    string
+}
+
+// SetDefaultUploadLimit sets the per-file upload limit, in MB, used until
+// admins choose one in the settings.
+func (h *Handler) SetDefaultUploadLimit(mb int64) {
+	h.uploadMB = mb
+}
+
+// maxUploadBytes returns the per-file limit from the max_upload_mb setting.
+func (h *Handler) maxUploadBytes() (int64, int64) {
+	maxMBStr, _ := h.db.GetSetting("max_upload_mb")
+	maxMB := h.uploadMB
+	if n, err := strconv.ParseInt(maxMBStr, 10, 64); err == nil && n > 0 {
+		maxMB = n
+	}
+	return maxMB * 1024 * 1024, maxMB
+}
+
 func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 	u, err := h.currentUser(r)
 	if err != nil || u == nil {
@@ -39,14 +73,9 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get max upload size from settings
-	maxMBStr, _ := h.db.GetSetting("max_upload_mb")
-	maxMB := int64(25)
-	if n, err := strconv.ParseInt(maxMBStr, 10, 64); err == nil && n > 0 {
-		maxMB = n
-	}
-	maxBytes := maxMB * 1024 * 1024
+	maxBytes, maxMB := h.maxUploadBytes()
 
-	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	mw.SetBodyLimit(r, maxBytes)
 	if err := r.ParseMultipartForm(maxBytes); err != nil {
 		errResp(w, http.StatusBadRequest, fmt.Sprintf("file too large (max %dMB)", maxMB))
 		return
@@ -59,6 +88,110 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
+	att, uerr := h.storeUpload(r.Context(), u, file, header, r.FormValue("kind"))
+	if uerr != nil {
+		errResp(w, uerr.status, uerr.msg)
+		return
+	}
+	created(w, uploadResponse(att))
+}
+
+// UploadBatch stores several files sent as "files" parts of one multipart
+// request, so sharing an album doesn't take a round-trip per photo.  Each
+// file is held to max_upload_mb and checked on its own; one being refused
+// doesn't stop the rest.
+//
+// With "Accept: application/x-ndjson" a line of JSON is streamed as each
+// file is stored, {index, total, name} plus "attachment" or "error" and
+// "status", so clients can show progress, followed by a summary line.
+// Otherwise the response is {"attachments": [...], "errors": [...]}.
+func (h *Handler) UploadBatch(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	maxBytes, maxMB := h.maxUploadBytes()
+	mw.SetBodyLimit(r, maxBytes*maxUploadBatch+1<<20)
+	// Parts past the first 32MB go to temporary files.
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		errResp(w, http.StatusBadRequest, fmt.Sprintf("upload too large (max %d files of %dMB)", maxUploadBatch, maxMB))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	headers := append(r.MultipartForm.File["files"], r.MultipartForm.File["file"]...)
+	if len(headers) == 0 {
+		errResp(w, http.StatusBadRequest, "no files provided")
+		return
+	}
+	if len(headers) > maxUploadBatch {
+		errResp(w, http.StatusBadRequest, fmt.Sprintf("at most %d files per upload", maxUploadBatch))
+		return
+	}
+
+	stream := strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+	var enc *json.Encoder
+	flusher, _ := w.(http.Flusher)
+	if stream {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusCreated)
+		enc = json.NewEncoder(w)
+	}
+
+	attachments := []map[string]interface{}{}
+	errs := []map[string]interface{}{}
+	for i, header := range headers {
+		line := map[string]interface{}{"index": i, "total": len(headers), "name": header.Filename}
+		att, uerr := h.storeHeader(r.Context(), u, header, maxBytes, maxMB)
+		if uerr != nil {
+			line["error"], line["status"] = uerr.msg, uerr.status
+			errs = append(errs, line)
+		} else {
+			line["attachment"] = uploadResponse(att)
+			attachments = append(attachments, line["attachment"].(map[string]interface{}))
+		}
+		if stream {
+			enc.Encode(line)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+
+	if stream {
+		enc.Encode(map[string]interface{}{"done": true, "stored": len(attachments), "failed": len(errs)})
+		return
+	}
+	if len(attachments) == 0 {
+		errResp(w, errs[0]["status"].(int), errs[0]["error"].(string))
+		return
+	}
+	created(w, map[string]interface{}{"attachments": attachments, "errors": errs})
+}
+
+// storeHeader opens and stores one file of a batch.
+func (h *Handler) storeHeader(ctx context.Context, u *db.User, header *multipart.FileHeader, maxBytes, maxMB int64) (*db.Attachment, *uploadError) {
+	if header.Size > maxBytes {
+		return nil, &uploadError{http.StatusBadRequest, fmt.Sprintf("file too large (max %dMB)", maxMB)}
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, &uploadError{http.StatusBadRequest, "failed to read file"}
+	}
+	defer file.Close()
+	return h.storeUpload(ctx, u, file, header, "")
+}
+
+// storeUpload checks, stores and records one uploaded file.  kind is "" for
+// plain files or "voice" for voice notes.
+func (h *Handler) storeUpload(ctx context.Context, u *db.User, file multipart.File, header *multipart.FileHeader, kind string) (*db.Attachment, *uploadError) {
+	if kind != "" && kind != attachmentKindVoice {
+		return nil, &uploadError{http.StatusBadRequest, "unknown attachment kind"}
+	}
+
 	// Detect MIME type from first 512 bytes
 	buf := make([]byte, 512)
 	n, _ := file.Read(buf)
@@ -73,6 +206,7 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 			".zip":  "application/zip",
 			".mp3":  "audio/mpeg",
 			".ogg":  "audio/ogg",
+			".opus": "audio/ogg",
 			".wav":  "audio/wav",
 			".mp4":  "video/mp4",
 			".webm": "video/webm",
@@ -80,49 +214,122 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 		if m, ok := extMimes[ext]; ok {
 			mimeType = m
 		} else {
-			errResp(w, http.StatusBadRequest, "file type not allowed")
-			return
+			return nil, &uploadError{http.StatusBadRequest, "file type not allowed"}
 		}
 	}
 
 	// Seek back to start
 	file.Seek(0, io.SeekStart)
 
+	if err := h.scanUpload(ctx, u, header.Filename, file); err != nil {
+		status := http.StatusUnprocessableEntity
+		if err == errScannerDown {
+			status = http.StatusServiceUnavailable
+		}
+		return nil, &uploadError{status, err.Error()}
+	}
+
+	body, size, err := h.stripUploadMetadata(file, header.Size, mimeType)
+	if err != nil {
+		return nil, &uploadError{http.StatusBadRequest, "failed to read file"}
+	}
+	var voice voiceNote
+	if kind == attachmentKindVoice {
+		var uerr *uploadError
+		if voice, uerr = h.analyzeVoiceNote(body, mimeType); uerr != nil {
+			return nil, uerr
+		}
+		mimeType = voice.mimeType
+	}
+	if err := h.makeRoom(size); err != nil {
+		if err != errStorageFull {
+			logging.FromContext(ctx).Error("storage quota", "file", header.Filename, "err", err)
+		}
+		return nil, &uploadError{http.StatusInsufficientStorage, errStorageFull.Error()}
+	}
+
 	// Generate safe filename
 	ext := filepath.Ext(header.Filename)
 	filename := fmt.Sprintf("%s%s", newID(), ext)
-	destPath := filepath.Join(h.dataDir, "uploads", filename)
-
-	dest, err := os.Create(destPath)
-	if err != nil {
-		errResp(w, http.StatusInternalServerError, "failed to save file")
-		return
+	if err := h.files.Put(filename, body, size, mimeType); err != nil {
+		logging.FromContext(ctx).Error("saving upload", "file", filename, "err", err)
+		return nil, &uploadError{http.StatusInternalServerError, "failed to save file"}
 	}
-	defer dest.Close()
 
-	size, err := io.Copy(dest, file)
-	if err != nil {
-		os.Remove(destPath)
-		errResp(w, http.StatusInternalServerError, "failed to write file")
-		return
+	a := db.Attachment{Filename: filename, OriginalName: header.Filename, MimeType: mimeType, Size: size}
+
+	// Record image dimensions so clients can reserve space before loading,
+	// and generate the smaller copies served with ?size=.
+	if strings.HasPrefix(mimeType, "image/") {
+		a.Width, a.Height = imageDimensions(body)
+		if err := generateThumbnails(h.files, filename, mimeType, body); err != nil {
+			logging.FromContext(ctx).Warn("upload thumbnails", "file", filename, "err", err)
+		}
+	}
+	if strings.HasPrefix(mimeType, "video/") && h.video != nil {
+		info, err := h.video.process(h.files, filename, body)
+		if err != nil {
+			logging.FromContext(ctx).Warn("upload poster frame", "file", filename, "err", err)
+		}
+		a.Width, a.Height, a.Duration, a.Poster = info.Width, info.Height, info.Duration, info.Poster
+	}
+	a.Preview = documentPreview(body, size, mimeType)
+	if kind == attachmentKindVoice {
+		// Voice notes are Opus already, so they're never transcoded.
+		a.Kind, a.Duration, a.Waveform = kind, voice.duration, voice.peaks
+	} else if h.transcodes(mimeType) {
+		a.Status = "queued"
 	}
 
 	// Create attachment record (message_id will be "" until attached to a message)
-	att, err := h.db.CreateAttachment("", filename, header.Filename, mimeType, size)
+	att, err := h.db.CreateAttachment(a)
 	if err != nil {
-		os.Remove(destPath)
-		errResp(w, http.StatusInternalServerError, "failed to record upload")
-		return
+		removeUpload(h.files, filename)
+		return nil, &uploadError{http.StatusInternalServerError, "failed to record upload"}
+	}
+	if att.Status == "queued" {
+		h.queueTranscode(att.ID)
 	}
 
-	created(w, map[string]interface{}{
+	h.db.FillAttachmentURLs(att)
+	return att, nil
+}
+
+// uploadResponse is what the upload endpoints return for a stored file.
+func uploadResponse(att *db.Attachment) map[string]interface{} {
+	return map[string]interface{}{
 		"id":            att.ID,
-		"filename":      filename,
-		"original_name": header.Filename,
-		"mime_type":     mimeType,
-		"size":          size,
-		"url":           "/uploads/" + filename,
-	})
+		"filename":      att.Filename,
+		"original_name": att.OriginalName,
+		"mime_type":     att.MimeType,
+		"size":          att.Size,
+		"width":         att.Width,
+		"height":        att.Height,
+		"duration":      att.Duration,
+		"poster":        att.Poster,
+		"status":        att.Status,
+		"url":           att.URL,
+		"poster_url":    att.PosterURL,
+		"kind":          att.Kind,
+		"waveform":      att.Waveform,
+		"preview":       att.Preview,
+	}
+}
+
+// inlineTypes are the extensions ServeUpload lets browsers display, with
+// the Content-Type each is served as.  None of them can run script.
+var inlineTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".mp3":  "audio/mpeg",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
 }
 
 func (h *Handler) ServeUpload(w http.ResponseWriter, r *http.Request) {
@@ -133,13 +340,54 @@ func (h *Handler) ServeUpload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid filename", http.StatusBadRequest)
 		return
 	}
-	path := filepath.Join(h.dataDir, "uploads", filename)
+	// Attachments are only served on URLs the API signed; avatars, emoji
+	// and the like stay public.  Thumbnails are only made of attachments,
+	// so asking for one by name needs a signature too.
+	q := r.URL.Query()
+	if (isThumbnailName(filename) || h.db.IsAttachmentFile(filename)) && !h.auth.VerifyURL("/uploads/"+filename, q.Get("exp"), q.Get("sig")) {
+		http.Error(w, "link expired or invalid", http.StatusForbidden)
+		return
+	}
+	if size := q.Get("size"); size != "" {
+		if thumb := thumbnailName(h.files, filename, size); thumb != "" {
+			filename = thumb
+		}
+	}
 
 	// Fix #2: Force download and prevent MIME-sniffing so browsers never
 	// execute content (especially important for any future edge-case types).
-	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	// Images, video and audio are shown in the page, so they're served
+	// inline with a Content-Type fixed by extension rather than guessed.
+	if ct, ok := inlineTypes[strings.ToLower(filepath.Ext(filename))]; ok {
+		w.Header().Set("Content-Type", ct)
+		w.Header().Set("Content-Disposition", "inline; filename=\""+filename+"\"")
+	} else {
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	}
 	w.Header().Set("X-Content-Type-Options", "nosniff")
-	http.ServeFile(w, r, path)
+	h.files.Serve(w, r, filename)
+}
+
+// attachmentURLTTL is how long a signed attachment URL stays valid at
+// least.  Expiry times are rounded up to the hour so the same file keeps
+// the same URL for a while and browsers can cache it.
+const attachmentURLTTL = 24 * time.Hour
+
+// attachmentURL signs the URL of an attached file.
+func (h *Handler) attachmentURL(filename string) string {
+	expires := time.Now().Add(attachmentURLTTL).Truncate(time.Hour).Add(time.Hour)
+	return h.auth.SignURL("/uploads/"+filename, expires)
+}
+
+// SetStorage moves uploads to store, e.g. an S3 bucket.  Files already
+// stored elsewhere are not copied over.
+func (h *Handler) SetStorage(store storage.Store) {
+	h.files = store
+}
+
+// RemoveUpload deletes an uploaded file and any thumbnails of it.
+func (h *Handler) RemoveUpload(filename string) {
+	removeUpload(h.files, filename)
 }
 
 // newID generates a random hex ID for filenames
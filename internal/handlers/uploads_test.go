@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/auth"
+	"chirm/internal/db"
+)
+
+// newTestHandler returns a Handler on a fresh database in a temporary
+// directory.
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	dir := t.TempDir()
+	database, err := db.Open(filepath.Join(dir, "chirm.db"), db.PoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return New(database, auth.New("0123456789abcdef0123456789abcdef"), NewHub(database, ""), dir)
+}
+
+// getUpload requests /uploads/{filename}, with query, from h.
+func getUpload(h *Handler, filename, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/uploads/"+filename+query, nil)
+	rc := chi.NewRouteContext()
+	rc.URLParams.Add("filename", filename)
+	r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rc))
+	w := httptest.NewRecorder()
+	h.ServeUpload(w, r)
+	return w
+}
+
+func TestServeUploadThumbnailNeedsSignature(t *testing.T) {
+	h := newTestHandler(t)
+	uploads := filepath.Join(h.dataDir, "uploads")
+	if err := os.MkdirAll(uploads, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"0123456789abcdef.medium.jpg", "0123456789abcdef.thumb.png", "avatar.png"} {
+		if err := os.WriteFile(filepath.Join(uploads, name), []byte("image"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"0123456789abcdef.medium.jpg", "0123456789abcdef.thumb.png"} {
+		if w := getUpload(h, name, ""); w.Code != http.StatusForbidden {
+			t.Errorf("%s without a signature: got %d, want 403", name, w.Code)
+		}
+		if w := getUpload(h, name, "?exp=1&sig=bogus"); w.Code != http.StatusForbidden {
+			t.Errorf("%s with a bad signature: got %d, want 403", name, w.Code)
+		}
+	}
+	if w := getUpload(h, "avatar.png", ""); w.Code != http.StatusOK {
+		t.Errorf("avatar.png: got %d, want 200", w.Code)
+	}
+}
+
+func TestIsThumbnailName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"abc.thumb.jpg":  true,
+		"abc.medium.png": true,
+		"abc.medium.gif": false,
+		"abc.large.jpg":  false,
+		"abc.jpg":        false,
+		"thumb.jpg":      false,
+	} {
+		if got := isThumbnailName(name); got != want {
+			t.Errorf("isThumbnailName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
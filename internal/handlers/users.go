@@ -2,15 +2,18 @@ package handlers
 
 import (
 	"encoding/json"
-	"io"
+	"maps"
 	"net/http"
-	"os"
+	"net/url"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
 	"chirm/internal/db"
+	mw "chirm/internal/middleware"
 )
 
 // --- Users ---
@@ -31,20 +34,28 @@ func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
 	ok(w, users)
 }
 
+// PublicUser is the part of an account every member may see.
+type PublicUser struct {
+	ID       string    `json:"id"`
+	Username string    `json:"username"`
+	Avatar   string    `json:"avatar"`
+	IsOwner  bool      `json:"is_owner"`
+	Roles    []db.Role `json:"roles"`
+}
+
+// ListMembers lists the members of the guild in ?guild=, with their roles
+// there.
 func (h *Handler) ListMembers(w http.ResponseWriter, r *http.Request) {
-	users, err := h.db.ListUsers()
+	guildID := guildParam(r)
+	if _, isMember := h.requireGuildMember(w, r, guildID); !isMember {
+		return
+	}
+	users, err := h.db.ListGuildMembers(guildID)
 	if err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to list members")
 		return
 	}
 	// Return only public fields
-	type PublicUser struct {
-		ID       string   `json:"id"`
-		Username string   `json:"username"`
-		Avatar   string   `json:"avatar"`
-		IsOwner  bool     `json:"is_owner"`
-		Roles    []db.Role `json:"roles"`
-	}
 	var members []PublicUser
 	for _, u := range users {
 		members = append(members, PublicUser{
@@ -61,16 +72,19 @@ func (h *Handler) ListMembers(w http.ResponseWriter, r *http.Request) {
 	ok(w, members)
 }
 
+// UpdateUserRequest is the body of PUT /api/users/{id}.
+type UpdateUserRequest struct {
+	Username string `json:"username"`
+	Avatar   string `json:"avatar"`
+}
+
 func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	_, isAdmin := h.requireAdmin(w, r)
 	if !isAdmin {
 		return
 	}
 	id := chi.URLParam(r, "id")
-	var req struct {
-		Username string `json:"username"`
-		Avatar   string `json:"avatar"`
-	}
+	var req UpdateUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errResp(w, http.StatusBadRequest, "invalid request")
 		return
@@ -112,7 +126,11 @@ func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 // --- Roles ---
 
 func (h *Handler) ListRoles(w http.ResponseWriter, r *http.Request) {
-	roles, err := h.db.ListRoles()
+	guildID := guildParam(r)
+	if _, isMember := h.requireGuildMember(w, r, guildID); !isMember {
+		return
+	}
+	roles, err := h.db.ListRoles(guildID)
 	if err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to list roles")
 		return
@@ -123,18 +141,25 @@ func (h *Handler) ListRoles(w http.ResponseWriter, r *http.Request) {
 	ok(w, roles)
 }
 
+// CreateRoleRequest is the body of POST /api/roles.
+type CreateRoleRequest struct {
+	Name        string `json:"name"`
+	Color       string `json:"color"`
+	Permissions int    `json:"permissions"`
+	GuildID     string `json:"guild_id"` // the default guild if empty
+}
+
 func (h *Handler) CreateRole(w http.ResponseWriter, r *http.Request) {
-	_, isAdmin := h.requireAdmin(w, r)
-	if !isAdmin {
+	var req CreateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
 		return
 	}
-	var req struct {
-		Name        string `json:"name"`
-		Color       string `json:"color"`
-		Permissions int    `json:"permissions"`
+	if req.GuildID == "" {
+		req.GuildID = db.DefaultGuild
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		errResp(w, http.StatusBadRequest, "invalid request")
+	_, isAdmin := h.requireGuildAdmin(w, r, req.GuildID)
+	if !isAdmin {
 		return
 	}
 	if req.Name == "" {
@@ -144,7 +169,7 @@ func (h *Handler) CreateRole(w http.ResponseWriter, r *http.Request) {
 	if req.Color == "" {
 		req.Color = "#99AAB5"
 	}
-	role, err := h.db.CreateRole(req.Name, req.Color, req.Permissions)
+	role, err := h.db.CreateRole(req.GuildID, req.Name, req.Color, req.Permissions)
 	if err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to create role")
 		return
@@ -152,17 +177,20 @@ func (h *Handler) CreateRole(w http.ResponseWriter, r *http.Request) {
 	created(w, role)
 }
 
+// UpdateRoleRequest is the body of PUT /api/roles/{id}.
+type UpdateRoleRequest struct {
+	Name        string `json:"name"`
+	Color       string `json:"color"`
+	Permissions int    `json:"permissions"`
+}
+
 func (h *Handler) UpdateRole(w http.ResponseWriter, r *http.Request) {
-	_, isAdmin := h.requireAdmin(w, r)
+	id := chi.URLParam(r, "id")
+	_, isAdmin := h.requireGuildAdmin(w, r, h.roleGuild(id))
 	if !isAdmin {
 		return
 	}
-	id := chi.URLParam(r, "id")
-	var req struct {
-		Name        string `json:"name"`
-		Color       string `json:"color"`
-		Permissions int    `json:"permissions"`
-	}
+	var req UpdateRoleRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errResp(w, http.StatusBadRequest, "invalid request")
 		return
@@ -176,11 +204,11 @@ func (h *Handler) UpdateRole(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) DeleteRole(w http.ResponseWriter, r *http.Request) {
-	_, isAdmin := h.requireAdmin(w, r)
+	id := chi.URLParam(r, "id")
+	_, isAdmin := h.requireGuildAdmin(w, r, h.roleGuild(id))
 	if !isAdmin {
 		return
 	}
-	id := chi.URLParam(r, "id")
 	if err := h.db.DeleteRole(id); err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to delete role")
 		return
@@ -189,12 +217,17 @@ func (h *Handler) DeleteRole(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) AssignRole(w http.ResponseWriter, r *http.Request) {
-	_, isAdmin := h.requireAdmin(w, r)
+	userID := chi.URLParam(r, "id")
+	roleID := chi.URLParam(r, "roleId")
+	guildID := h.roleGuild(roleID)
+	_, isAdmin := h.requireGuildAdmin(w, r, guildID)
 	if !isAdmin {
 		return
 	}
-	userID := chi.URLParam(r, "id")
-	roleID := chi.URLParam(r, "roleId")
+	if !h.db.IsGuildMember(guildID, userID) {
+		errResp(w, http.StatusBadRequest, "user is not a member of the role's guild")
+		return
+	}
 	if err := h.db.AssignRole(userID, roleID); err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to assign role")
 		return
@@ -203,12 +236,12 @@ func (h *Handler) AssignRole(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) RemoveRole(w http.ResponseWriter, r *http.Request) {
-	_, isAdmin := h.requireAdmin(w, r)
+	userID := chi.URLParam(r, "id")
+	roleID := chi.URLParam(r, "roleId")
+	_, isAdmin := h.requireGuildAdmin(w, r, h.roleGuild(roleID))
 	if !isAdmin {
 		return
 	}
-	userID := chi.URLParam(r, "id")
-	roleID := chi.URLParam(r, "roleId")
 	if err := h.db.RemoveRole(userID, roleID); err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to remove role")
 		return
@@ -216,14 +249,24 @@ func (h *Handler) RemoveRole(w http.ResponseWriter, r *http.Request) {
 	ok(w, map[string]string{"message": "removed"})
 }
 
+// roleGuild returns the guild role id is in, or the default guild if
+// there's no such role.
+func (h *Handler) roleGuild(id string) string {
+	if role, err := h.db.GetRoleByID(id); err == nil {
+		return role.GuildID
+	}
+	return db.DefaultGuild
+}
+
 // --- Invites ---
 
 func (h *Handler) ListInvites(w http.ResponseWriter, r *http.Request) {
-	_, isAdmin := h.requireAdmin(w, r)
+	guildID := guildParam(r)
+	_, isAdmin := h.requireGuildAdmin(w, r, guildID)
 	if !isAdmin {
 		return
 	}
-	invites, err := h.db.ListInvites()
+	invites, err := h.db.ListInvites(guildID)
 	if err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to list invites")
 		return
@@ -234,18 +277,25 @@ func (h *Handler) ListInvites(w http.ResponseWriter, r *http.Request) {
 	ok(w, invites)
 }
 
+// CreateInviteRequest is the body of POST /api/invites.
+type CreateInviteRequest struct {
+	MaxUses int    `json:"max_uses"`
+	GuildID string `json:"guild_id"` // the default guild if empty
+}
+
+// CreateInvite makes an invite to a guild; any of its members may.
 func (h *Handler) CreateInvite(w http.ResponseWriter, r *http.Request) {
-	u, err := h.currentUser(r)
-	if err != nil || u == nil {
-		errResp(w, http.StatusUnauthorized, "unauthorized")
-		return
+	var req CreateInviteRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	if req.GuildID == "" {
+		req.GuildID = db.DefaultGuild
 	}
-	var req struct {
-		MaxUses int `json:"max_uses"`
+	u, isMember := h.requireGuildMember(w, r, req.GuildID)
+	if !isMember {
+		return
 	}
-	json.NewDecoder(r.Body).Decode(&req)
 
-	inv, err := h.db.CreateInvite(u.ID, req.MaxUses, nil)
+	inv, err := h.db.CreateInvite(req.GuildID, u.ID, req.MaxUses, nil)
 	if err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to create invite")
 		return
@@ -254,11 +304,15 @@ func (h *Handler) CreateInvite(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) DeleteInvite(w http.ResponseWriter, r *http.Request) {
-	_, isAdmin := h.requireAdmin(w, r)
+	code := chi.URLParam(r, "code")
+	guildID := db.DefaultGuild
+	if inv, err := h.db.GetInviteByCode(code); err == nil {
+		guildID = inv.GuildID
+	}
+	_, isAdmin := h.requireGuildAdmin(w, r, guildID)
 	if !isAdmin {
 		return
 	}
-	code := chi.URLParam(r, "code")
 	if err := h.db.DeleteInvite(code); err != nil {
 		errResp(w, http.StatusInternalServerError, "failed to delete invite")
 		return
@@ -266,6 +320,45 @@ func (h *Handler) DeleteInvite(w http.ResponseWriter, r *http.Request) {
 	ok(w, map[string]string{"message": "deleted"})
 }
 
+// inviteJoinsMaxDays is how far back GET /api/invites/{code}/joins counts.
+const inviteJoinsMaxDays = 365
+
+// InviteJoinsResponse is the body of GET /api/invites/{code}/joins.
+type InviteJoinsResponse struct {
+	Invite *db.Invite      `json:"invite"`
+	Joins  []db.InviteJoin `json:"joins"`  // newest first
+	ByDay  []db.InviteDay  `json:"by_day"` // oldest first
+}
+
+// InviteJoins handles GET /api/invites/{code}/joins?days=<n> (admins of the
+// invite's guild): who came in through the invite, and how many did on
+// each of the last n UTC days (30 by default).
+func (h *Handler) InviteJoins(w http.ResponseWriter, r *http.Request) {
+	inv, err := h.db.GetInviteByCode(chi.URLParam(r, "code"))
+	if err != nil {
+		errResp(w, http.StatusNotFound, "invite not found")
+		return
+	}
+	if _, isAdmin := h.requireGuildAdmin(w, r, inv.GuildID); !isAdmin {
+		return
+	}
+	days := 30
+	if n, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && n > 0 && n <= inviteJoinsMaxDays {
+		days = n
+	}
+	now := time.Now()
+	resp := InviteJoinsResponse{Invite: inv}
+	if resp.Joins, err = h.db.InviteJoins(inv.Code); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to load joins")
+		return
+	}
+	if resp.ByDay, err = h.db.InviteJoinsByDay(inv.Code, now.AddDate(0, 0, 1-days), now); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to load joins")
+		return
+	}
+	ok(w, resp)
+}
+
 func (h *Handler) JoinWithInvite(w http.ResponseWriter, r *http.Request) {
 	code := chi.URLParam(r, "code")
 	inv, err := h.db.GetInviteByCode(code)
@@ -280,11 +373,18 @@ func (h *Handler) JoinWithInvite(w http.ResponseWriter, r *http.Request) {
 	}
 	// Return invite info so frontend can show register form
 	serverName, _ := h.db.GetSetting("server_name")
-	ok(w, map[string]interface{}{
+	info := map[string]interface{}{
 		"valid":       true,
 		"code":        code,
 		"server_name": serverName,
-	})
+	}
+	if inv.GuildID != db.DefaultGuild {
+		if g, err := h.db.GetGuild(inv.GuildID); err == nil {
+			info["guild_id"] = g.ID
+			info["guild_name"] = g.Name
+		}
+	}
+	ok(w, info)
 }
 
 // --- Settings ---
@@ -292,6 +392,12 @@ func (h *Handler) JoinWithInvite(w http.ResponseWriter, r *http.Request) {
 // GetPublicSettings returns non-sensitive settings accessible without authentication.
 // Used by login page and mobile sidebar to show server branding.
 func (h *Handler) GetPublicSettings(w http.ResponseWriter, r *http.Request) {
+	ok(w, h.publicSettings())
+}
+
+// publicSettings is what GET /api/public-settings and the settings.update
+// event show of the settings.
+func (h *Handler) publicSettings() map[string]string {
 	publicKeys := []string{
 		"server_name", "server_description", "server_icon",
 		"login_bg_color", "login_bg_image", "login_bg_overlay",
@@ -304,7 +410,31 @@ func (h *Handler) GetPublicSettings(w http.ResponseWriter, r *http.Request) {
 			result[k] = v
 		}
 	}
-	ok(w, result)
+	_, maxMB := h.maxUploadBytes()
+	result["max_upload_mb"] = strconv.FormatInt(maxMB, 10)
+	h.publicBannerSettings(result)
+	if provider, _ := h.gifConfig(); provider != "" {
+		result["gifs"] = provider
+	}
+	if provider, _, _ := h.translateConfig(); provider != "" {
+		result["translate"] = provider
+	}
+	if h.ircPort != "" {
+		result["irc_port"] = h.ircPort
+	}
+	if h.ircTLSPort != "" {
+		result["irc_tls_port"] = h.ircTLSPort
+	}
+	if h.federation != "" {
+		result["federation"] = h.federation
+	}
+	return result
+}
+
+// broadcastPublicSettings sends everyone the public settings, so open apps
+// show a change at once.
+func (h *Handler) broadcastPublicSettings() {
+	h.hub.Broadcast(WSEvent{Type: "settings.update", Data: h.publicSettings()})
 }
 
 func (h *Handler) GetSettings(w http.ResponseWriter, r *http.Request) {
@@ -320,6 +450,26 @@ func (h *Handler) GetSettings(w http.ResponseWriter, r *http.Request) {
 	}
 	// Remove internal keys
 	delete(settings, "setup_done")
+	// Not a stored setting: whether the scan_uploads choice does anything
+	if h.scanner != nil {
+		settings["upload_scanner"] = "1"
+	}
+	// Until admins pick an upload limit, show the one in effect
+	if settings["max_upload_mb"] == "" {
+		settings["max_upload_mb"] = strconv.FormatInt(h.uploadMB, 10)
+	}
+	// and likewise the rate limits
+	for class, l := range h.rateLimits {
+		if settings["rate_"+class+"_per_min"] == "" {
+			settings["rate_"+class+"_per_min"] = strconv.Itoa(l.PerMinute)
+		}
+		if settings["rate_"+class+"_burst"] == "" {
+			settings["rate_"+class+"_burst"] = strconv.Itoa(l.Burst)
+		}
+	}
+	if used, err := h.db.AttachmentBytes(); err == nil {
+		settings["storage_used_bytes"] = strconv.FormatInt(used, 10)
+	}
 	ok(w, settings)
 }
 
@@ -334,29 +484,114 @@ func (h *Handler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	allowed := map[string]bool{
-		"server_name":        true,
-		"allow_registration": true,
-		"require_invite":     true,
-		"server_description": true,
-		"max_upload_mb":      true,
-		"server_icon":        true,
-		"login_bg_color":     true,
-		"login_bg_image":     true,
-		"login_bg_overlay":   true,
-		"agreement_enabled":  true,
-		"agreement_text":     true,
-	}
+		"server_name":             true,
+		"allow_registration":      true,
+		"require_invite":          true,
+		"preview_counts":          true,
+		"server_description":      true,
+		"max_upload_mb":           true,
+		"strip_image_metadata":    true,
+		"scan_uploads":            true,
+		"storage_quota_mb":        true,
+		"storage_quota_policy":    true,
+		"link_previews":           true,
+		"link_preview_allowlist":  true,
+		"link_preview_denylist":   true,
+		"server_icon":             true,
+		"login_bg_color":          true,
+		"login_bg_image":          true,
+		"login_bg_overlay":        true,
+		"agreement_enabled":       true,
+		"agreement_text":          true,
+		"banner_text":             true,
+		"banner_severity":         true,
+		"banner_expires_at":       true,
+		"welcome_channel_id":      true,
+		"welcome_message":         true,
+		"welcome_private_message": true,
+		"rules_gate":              true,
+		"gif_provider":            true,
+		"gif_api_key":             true,
+		"translate_provider":      true,
+		"translate_url":           true,
+		"translate_api_key":       true,
+	}
+	before := h.publicSettings()
+	bannerChanged := false
 	for k, v := range req {
-		if allowed[k] {
+		if allowed[k] || h.rateLimitSetting(k) {
 			// Validate numeric fields
 			if k == "max_upload_mb" {
 				if n, err := strconv.Atoi(v); err != nil || n <= 0 {
 					continue
 				}
 			}
+			if k == "strip_image_metadata" && v != "0" && v != "1" {
+				continue
+			}
+			if k == "scan_uploads" && v != "on" && v != "off" && v != "strict" {
+				continue
+			}
+			if k == "storage_quota_mb" {
+				if n, err := strconv.Atoi(v); err != nil || n < 0 {
+					continue
+				}
+			}
+			if k == "storage_quota_policy" && v != quotaReject && v != quotaEvictOldest && v != quotaEvictLargest {
+				continue
+			}
+			if h.rateLimitSetting(k) && v != "" {
+				if n, err := strconv.Atoi(v); err != nil || n <= 0 {
+					continue
+				}
+			}
+			if (k == "link_previews" || k == "preview_counts") && v != "0" && v != "1" {
+				continue
+			}
+			if k == "link_preview_allowlist" || k == "link_preview_denylist" {
+				v = strings.Join(domainList(v), "\n")
+			}
+			if strings.HasPrefix(k, "banner_") {
+				v = strings.TrimSpace(v)
+				if !validBannerSetting(k, v) {
+					continue
+				}
+				if old, _ := h.db.GetSetting(k); old != v {
+					bannerChanged = true
+				}
+			}
+			if k == "gif_provider" && v != "" && v != gifProviderTenor && v != gifProviderGiphy {
+				continue
+			}
+			if k == "gif_api_key" {
+				v = strings.TrimSpace(v)
+			}
+			if k == "translate_provider" && v != "" && v != translateProviderLibre && v != translateProviderDeepL {
+				continue
+			}
+			if k == "translate_url" {
+				v = strings.TrimSpace(v)
+				if u, err := url.Parse(v); v != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+					continue
+				}
+			}
+			if k == "translate_api_key" {
+				v = strings.TrimSpace(v)
+			}
+			if strings.HasPrefix(k, "welcome_") || k == "rules_gate" {
+				if !h.validWelcomeSetting(k, v) {
+					continue
+				}
+			}
 			h.db.SetSetting(k, v)
 		}
 	}
+	if bannerChanged {
+		h.hub.Broadcast(WSEvent{Type: "settings.banner", Data: h.currentBanner()})
+	}
+	if !maps.Equal(before, h.publicSettings()) {
+		h.broadcastPublicSettings()
+	}
 	ok(w, map[string]string{"message": "settings updated"})
 }
 
@@ -367,7 +602,7 @@ func (h *Handler) UploadServerIcon(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, 5*1024*1024) // 5 MB cap
+	mw.SetBodyLimit(r, 5*1024*1024) // 5 MB cap
 	if err := r.ParseMultipartForm(5 * 1024 * 1024); err != nil {
 		errResp(w, http.StatusBadRequest, "file too large (max 5MB)")
 		return
@@ -395,22 +630,19 @@ func (h *Handler) UploadServerIcon(w http.ResponseWriter, r *http.Request) {
 		ext = ".png"
 	}
 	filename := "server_icon_" + newID() + ext
-	destPath := filepath.Join(h.dataDir, "uploads", filename)
-
-	dest, err := os.Create(destPath)
+	body, size, err := h.stripUploadMetadata(file, header.Size, mimeType)
 	if err != nil {
-		errResp(w, http.StatusInternalServerError, "failed to save icon")
+		errResp(w, http.StatusBadRequest, "failed to read file")
 		return
 	}
-	defer dest.Close()
-	if _, err := io.Copy(dest, file); err != nil {
-		os.Remove(destPath)
-		errResp(w, http.StatusInternalServerError, "failed to write icon")
+	if err := h.files.Put(filename, body, size, mimeType); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to save icon")
 		return
 	}
 
 	iconURL := "/uploads/" + filename
 	h.db.SetSetting("server_icon", iconURL)
+	h.broadcastPublicSettings()
 	ok(w, map[string]string{"icon": iconURL})
 }
 
@@ -421,7 +653,7 @@ func (h *Handler) UploadLoginBg(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, 10*1024*1024) // 10 MB cap
+	mw.SetBodyLimit(r, 10*1024*1024) // 10 MB cap
 	if err := r.ParseMultipartForm(10 * 1024 * 1024); err != nil {
 		errResp(w, http.StatusBadRequest, "file too large (max 10MB)")
 		return
@@ -449,21 +681,18 @@ func (h *Handler) UploadLoginBg(w http.ResponseWriter, r *http.Request) {
 		ext = ".jpg"
 	}
 	filename := "login_bg_" + newID() + ext
-	destPath := filepath.Join(h.dataDir, "uploads", filename)
-
-	dest, err := os.Create(destPath)
+	body, size, err := h.stripUploadMetadata(file, header.Size, mimeType)
 	if err != nil {
-		errResp(w, http.StatusInternalServerError, "failed to save background")
+		errResp(w, http.StatusBadRequest, "failed to read file")
 		return
 	}
-	defer dest.Close()
-	if _, err := io.Copy(dest, file); err != nil {
-		os.Remove(destPath)
-		errResp(w, http.StatusInternalServerError, "failed to write background")
+	if err := h.files.Put(filename, body, size, mimeType); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to save background")
 		return
 	}
 
 	bgURL := "/uploads/" + filename
 	h.db.SetSetting("login_bg_image", bgURL)
+	h.broadcastPublicSettings()
 	ok(w, map[string]string{"bg": bgURL})
 }
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"chirm/internal/storage"
+)
+
+// ─── Video posters ───────────────────────────────────────────────────────────
+//
+// With ffmpeg installed, video uploads get their dimensions and duration
+// read by ffprobe and a preview frame stored as "<id>.poster.jpg", so the
+// chat can show the video without downloading it.  Without ffmpeg, videos
+// are stored as before.
+
+// videoToolTimeout bounds each ffprobe/ffmpeg run.
+const videoToolTimeout = 30 * time.Second
+
+// posterEdge is the longest edge of a poster frame, matching "medium".
+const posterEdge = 1024
+
+type videoTools struct {
+	ffmpeg, ffprobe string
+}
+
+// EnableVideoPosters turns on poster extraction using the given ffmpeg and
+// ffprobe binaries (names are looked up on PATH).
+func (h *Handler) EnableVideoPosters(ffmpeg, ffprobe string) error {
+	ff, err := exec.LookPath(ffmpeg)
+	if err != nil {
+		return err
+	}
+	fp, err := exec.LookPath(ffprobe)
+	if err != nil {
+		return err
+	}
+	h.video = &videoTools{ffmpeg: ff, ffprobe: fp}
+	return nil
+}
+
+// videoInfo is what we learn about an uploaded video.
+type videoInfo struct {
+	Width, Height int
+	Duration      float64
+	Poster        string // stored filename, or "" if no frame could be taken
+}
+
+// process probes the video in src and stores its poster beside name.
+func (v *videoTools) process(store storage.Store, name string, src io.ReadSeeker) (videoInfo, error) {
+	var info videoInfo
+	// ffmpeg needs to seek (an MP4's index is often at the end), so give it
+	// a real file.
+	tmp, err := os.CreateTemp("", "chirm-video-*")
+	if err != nil {
+		return info, err
+	}
+	defer os.Remove(tmp.Name())
+	src.Seek(0, io.SeekStart)
+	_, err = io.Copy(tmp, src)
+	tmp.Close()
+	if err != nil {
+		return info, err
+	}
+
+	if info.Width, info.Height, info.Duration, err = v.probe(tmp.Name()); err != nil {
+		return info, err
+	}
+	frame, err := v.frame(tmp.Name(), info.Duration)
+	if err != nil {
+		return info, err
+	}
+	// ffmpeg applies the rotation phones record, so trust the frame's shape
+	if cfg, err := jpeg.DecodeConfig(bytes.NewReader(frame)); err == nil &&
+		(cfg.Width > cfg.Height) != (info.Width > info.Height) {
+		info.Width, info.Height = info.Height, info.Width
+	}
+	poster := thumbnailBase(name, "poster") + ".jpg"
+	if err := store.Put(poster, bytes.NewReader(frame), int64(len(frame)), "image/jpeg"); err != nil {
+		return info, err
+	}
+	info.Poster = poster
+	return info, nil
+}
+
+// probe reads the first video stream's size and the container's duration.
+func (v *videoTools) probe(path string) (int, int, float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), videoToolTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, v.ffprobe, "-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration",
+		"-of", "json", path).Output()
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("ffprobe: %w", err)
+	}
+	var res struct {
+		Streams []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return 0, 0, 0, fmt.Errorf("ffprobe output: %w", err)
+	}
+	if len(res.Streams) == 0 {
+		return 0, 0, 0, fmt.Errorf("no video stream")
+	}
+	duration, _ := strconv.ParseFloat(res.Format.Duration, 64)
+	return res.Streams[0].Width, res.Streams[0].Height, duration, nil
+}
+
+// frame grabs one JPEG frame a second in (or halfway through shorter
+// videos), scaled to fit posterEdge.
+func (v *videoTools) frame(path string, duration float64) ([]byte, error) {
+	at := 1.0
+	if duration > 0 && duration < 2 {
+		at = duration / 2
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), videoToolTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, v.ffmpeg, "-v", "error",
+		"-ss", strconv.FormatFloat(at, 'f', 3, 64),
+		"-i", path,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=w='min(%d,iw)':h='min(%d,ih)':force_original_aspect_ratio=decrease", posterEdge, posterEdge),
+		"-f", "image2pipe", "-c:v", "mjpeg", "-q:v", "4", "-")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("ffmpeg produced no frame")
+	}
+	return out, nil
+}
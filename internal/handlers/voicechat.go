@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"chirm/internal/db"
+)
+
+// ─── In-call chat ─────────────────────────────────────────────────────────────
+//
+// voice.chat lets people in a voice room drop links and short notes without
+// switching channels.  Messages are relayed to whoever is in the room right
+// now and never stored: someone who joins later doesn't see earlier ones, and
+// they're gone once the room empties.
+
+const (
+	maxVoiceChatLen   = 2000
+	voiceChatInterval = time.Second // sustained rate per user
+	voiceChatBurst    = 5
+)
+
+// sendVoiceChat handles a voice.chat message from c.
+func (c *Client) sendVoiceChat(channelID, content string) {
+	h := c.hub
+	content = strings.TrimSpace(content)
+	if content == "" || !h.userInLocalVoiceRoom(channelID, c.userID) {
+		return
+	}
+	fail := func(msg string) {
+		c.sendEvent(WSEvent{Type: "voice.chat_error", Data: map[string]string{
+			"channel_id": channelID,
+			"error":      msg,
+		}})
+	}
+	if len(content) > maxVoiceChatLen {
+		fail("Message is too long")
+		return
+	}
+	if h.voicePermissions(channelID, c.userID)&db.PermSendMessages == 0 {
+		fail("You don't have permission to chat in this channel")
+		return
+	}
+	if !h.chatLimits.allow(c.userID) {
+		fail("You're sending messages too quickly")
+		return
+	}
+	u, err := h.db.GetUserByID(c.userID)
+	if err != nil {
+		return
+	}
+	h.relayToVoiceRoom(channelID, WSEvent{Type: "voice.chat", Data: map[string]interface{}{
+		"id":         db.NewID(),
+		"channel_id": channelID,
+		"user_id":    u.ID,
+		"username":   u.Username,
+		"content":    content,
+		"created_at": time.Now().UTC(),
+	}}, nil)
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import "time"
+
+// ─── Voice reconnect grace ───────────────────────────────────────────────────
+//
+// When a client's WebSocket drops while it is in a voice room, its slot is
+// held for a short grace period instead of being released at once.  The room
+// is told voice.reconnecting; if the user comes back in time and sends
+// voice.join with "resume": true they slip back in with voice.resumed and
+// everyone keeps their peer connections.  Otherwise the usual voice.left is
+// sent when the timer runs out.
+
+// DefaultVoiceReconnectGrace is used unless SetVoiceReconnectGrace is called.
+const DefaultVoiceReconnectGrace = 15 * time.Second
+
+// SetVoiceReconnectGrace sets how long a dropped user's voice slot is held;
+// zero releases it immediately.  It must be called before Run.
+func (h *Hub) SetVoiceReconnectGrace(d time.Duration) {
+	h.voiceGrace = d
+}
+
+// holdVoiceSlot starts the grace period for userID in channelID.  It reports
+// false when grace is disabled and the user should leave straight away.
+func (h *Hub) holdVoiceSlot(channelID, userID string) bool {
+	if h.voiceGrace <= 0 {
+		return false
+	}
+	h.heldMu.Lock()
+	defer h.heldMu.Unlock()
+	if h.held[channelID] == nil {
+		h.held[channelID] = make(map[string]*time.Timer)
+	}
+	if t := h.held[channelID][userID]; t != nil {
+		t.Stop()
+	}
+	var t *time.Timer
+	t = time.AfterFunc(h.voiceGrace, func() {
+		h.heldMu.Lock()
+		mine := h.held[channelID][userID] == t
+		if mine {
+			h.dropHeld(channelID, userID)
+		}
+		h.heldMu.Unlock()
+		if mine && !h.userInLocalVoiceRoom(channelID, userID) && !h.isRemoteVoiceMember(channelID, userID) {
+			h.finishVoiceLeave(channelID, userID)
+		}
+	})
+	h.held[channelID][userID] = t
+	return true
+}
+
+// resumeVoiceSlot cancels userID's grace period in channelID and reports
+// whether one was running.
+func (h *Hub) resumeVoiceSlot(channelID, userID string) bool {
+	h.heldMu.Lock()
+	defer h.heldMu.Unlock()
+	t := h.held[channelID][userID]
+	if t == nil {
+		return false
+	}
+	t.Stop()
+	h.dropHeld(channelID, userID)
+	return true
+}
+
+// dropHeld forgets a held slot.  heldMu must be held.
+func (h *Hub) dropHeld(channelID, userID string) {
+	delete(h.held[channelID], userID)
+	if len(h.held[channelID]) == 0 {
+		delete(h.held, channelID)
+	}
+}
+
+// heldVoiceSlots returns channelID → users currently inside their grace period.
+func (h *Hub) heldVoiceSlots() map[string][]string {
+	h.heldMu.Lock()
+	defer h.heldMu.Unlock()
+	out := make(map[string][]string, len(h.held))
+	for channelID, users := range h.held {
+		for uid := range users {
+			out[channelID] = append(out[channelID], uid)
+		}
+	}
+	return out
+}
+
+// finishVoiceLeave releases userID's room resources and tells everyone they
+// have left.
+func (h *Hub) finishVoiceLeave(channelID, userID string) {
+	h.onVoiceLeave(channelID, userID)
+	evt := WSEvent{
+		Type: "voice.left",
+		Data: map[string]string{
+			"channel_id": channelID,
+			"user_id":    userID,
+		},
+	}
+	h.relayToVoiceRoom(channelID, evt, nil)
+	h.relayToAll(evt)
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import "log/slog"
+
+// ─── Voice activity log ──────────────────────────────────────────────────────
+//
+// A voice channel may name a text channel (voice_log_channel_id) that gets a
+// system message whenever someone joins or leaves the call, so people who
+// weren't online can still see that a call happened.  Only the first tab to
+// join and the last to leave are logged.
+
+// postVoiceActivity writes a join/leave message for userID if channelID has a
+// voice log channel configured.
+func (h *Hub) postVoiceActivity(channelID, userID string, joined bool) {
+	ch, err := h.db.GetChannelByID(channelID)
+	if err != nil || ch.VoiceLogChannelID == "" {
+		return
+	}
+	verb := "left"
+	if joined {
+		verb = "joined"
+	}
+	msg, err := h.db.CreateSystemMessage(ch.VoiceLogChannelID, userID, verb+" "+ch.Name)
+	if err != nil {
+		slog.Error("voice log", "err", err)
+		return
+	}
+	h.BroadcastToChannel(ch.VoiceLogChannelID, WSEvent{Type: "message.new", Data: msg})
+}
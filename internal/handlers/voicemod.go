@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+)
+
+// ─── Voice moderation (server mute / deafen) ─────────────────────────────────
+//
+// Moderator-imposed mute and deafen are tracked per user in the Hub and
+// follow the user into any voice room until lifted.  Clients are told via
+// voice.moderation and silence the affected audio themselves; the hub
+// additionally stops relaying a muted user's media-state and offers, and in
+// SFU mode the server stops forwarding the audio outright.
+
+type voiceModState struct {
+	Muted    bool `json:"muted"`
+	Deafened bool `json:"deafened"`
+}
+
+func (h *Hub) voiceModeration(userID string) voiceModState {
+	h.voiceModMu.RLock()
+	defer h.voiceModMu.RUnlock()
+	return h.voiceMod[userID]
+}
+
+// setVoiceModeration records a user's moderation state locally.
+func (h *Hub) setVoiceModeration(userID string, st voiceModState) {
+	h.voiceModMu.Lock()
+	if st == (voiceModState{}) {
+		delete(h.voiceMod, userID)
+	} else {
+		h.voiceMod[userID] = st
+	}
+	h.voiceModMu.Unlock()
+}
+
+// roomModeration returns the moderation state of everyone in userIDs who is
+// currently muted or deafened.
+func (h *Hub) roomModeration(userIDs []string) map[string]voiceModState {
+	out := make(map[string]voiceModState)
+	h.voiceModMu.RLock()
+	defer h.voiceModMu.RUnlock()
+	for _, uid := range userIDs {
+		if st, ok := h.voiceMod[uid]; ok {
+			out[uid] = st
+		}
+	}
+	return out
+}
+
+// moderationEvent builds the voice.moderation event for a user.
+func moderationEvent(channelID, userID string, st voiceModState) WSEvent {
+	return WSEvent{
+		Type: "voice.moderation",
+		Data: map[string]interface{}{
+			"channel_id": channelID,
+			"user_id":    userID,
+			"muted":      st.Muted,
+			"deafened":   st.Deafened,
+		},
+	}
+}
+
+// applyModerationEvent keeps the local state in sync with moderation done on
+// another instance.
+func (h *Hub) applyModerationEvent(evt WSEvent) {
+	raw, err := json.Marshal(evt.Data)
+	if err != nil {
+		return
+	}
+	var d struct {
+		ChannelID string `json:"channel_id"`
+		UserID    string `json:"user_id"`
+		voiceModState
+	}
+	if json.Unmarshal(raw, &d) != nil || d.UserID == "" {
+		return
+	}
+	h.setVoiceModeration(d.UserID, d.voiceModState)
+	if h.sfu != nil {
+		h.sfu.Resync(d.ChannelID)
+	}
+}
+
+// MuteVoiceUser handles POST /api/voice/{channelId}/users/{id}/mute.
+func (h *Handler) MuteVoiceUser(w http.ResponseWriter, r *http.Request) {
+	h.moderateVoiceUser(w, r, func(st *voiceModState, on bool) { st.Muted = on }, "muted")
+}
+
+// DeafenVoiceUser handles POST /api/voice/{channelId}/users/{id}/deafen.
+func (h *Handler) DeafenVoiceUser(w http.ResponseWriter, r *http.Request) {
+	h.moderateVoiceUser(w, r, func(st *voiceModState, on bool) { st.Deafened = on }, "deafened")
+}
+
+func (h *Handler) moderateVoiceUser(w http.ResponseWriter, r *http.Request, apply func(*voiceModState, bool), field string) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	channelID := chi.URLParam(r, "channelId")
+	targetID := chi.URLParam(r, "id")
+	if !h.hasChannelPermission(u, channelID, db.PermMuteMembers) {
+		errResp(w, http.StatusForbidden, "insufficient permissions")
+		return
+	}
+
+	// Body: {"muted": true} / {"deafened": false}; an empty body means true.
+	on := true
+	var req map[string]bool
+	if json.NewDecoder(r.Body).Decode(&req) == nil {
+		if v, present := req[field]; present {
+			on = v
+		}
+	}
+
+	target, err := h.db.GetUserByID(targetID)
+	if err != nil {
+		errResp(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if target.IsOwner && !u.IsOwner {
+		errResp(w, http.StatusForbidden, "cannot moderate the server owner")
+		return
+	}
+	if !h.hub.userInLocalVoiceRoom(channelID, targetID) && !h.hub.isRemoteVoiceMember(channelID, targetID) {
+		errResp(w, http.StatusNotFound, "user is not in this voice channel")
+		return
+	}
+
+	st := h.hub.voiceModeration(targetID)
+	apply(&st, on)
+	h.hub.setVoiceModeration(targetID, st)
+	if h.hub.sfu != nil {
+		h.hub.sfu.Resync(channelID)
+	}
+	h.hub.relayToVoiceRoom(channelID, moderationEvent(channelID, targetID, st), nil)
+	ok(w, st)
+}
@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"net/http"
+	"os/exec"
+	"slices"
+)
+
+// ─── Voice notes ─────────────────────────────────────────────────────────────
+//
+// A voice note is a short Opus recording uploaded with kind=voice, in WebM
+// (what browsers' MediaRecorder makes) or Ogg.  The server reads its length
+// and a waveform of loudness peaks from it, so clients can draw an inline
+// player before loading any audio.  With ffmpeg the waveform comes from the
+// decoded samples; without, it's estimated from the size of each Opus
+// packet, which follows loudness closely enough to draw.
+
+const attachmentKindVoice = "voice"
+
+// maxVoiceNoteSeconds is the longest voice note accepted.
+const maxVoiceNoteSeconds = 5 * 60
+
+// waveformPeaks is how many bars a voice note's waveform has.
+const waveformPeaks = 64
+
+var errNotOpus = errors.New("voice notes must be Opus audio in WebM or Ogg")
+
+// voiceNote is what we learn about an uploaded voice note.
+type voiceNote struct {
+	mimeType string
+	duration float64 // seconds
+	peaks    []int   // 0-100
+}
+
+// analyzeVoiceNote checks that body is a short Opus recording and works
+// out its length and waveform.
+func (h *Handler) analyzeVoiceNote(body io.ReadSeeker, mimeType string) (voiceNote, *uploadError) {
+	var v voiceNote
+	body.Seek(0, io.SeekStart)
+	data, err := io.ReadAll(body)
+	body.Seek(0, io.SeekStart)
+	if err != nil {
+		return v, &uploadError{http.StatusBadRequest, "failed to read file"}
+	}
+
+	var packets []int
+	switch mimeType {
+	case "video/webm", "audio/webm":
+		v.mimeType = "audio/webm"
+		packets, v.duration, err = probeWebMOpus(data)
+	case "audio/ogg":
+		v.mimeType = "audio/ogg"
+		packets, v.duration, err = probeOggOpus(data)
+	default:
+		err = errNotOpus
+	}
+	if err != nil {
+		return v, &uploadError{http.StatusBadRequest, err.Error()}
+	}
+	if len(packets) == 0 || v.duration <= 0 {
+		return v, &uploadError{http.StatusBadRequest, "the recording is empty"}
+	}
+	if v.duration > maxVoiceNoteSeconds {
+		return v, &uploadError{http.StatusBadRequest, fmt.Sprintf("voice notes can be at most %d minutes long", maxVoiceNoteSeconds/60)}
+	}
+
+	if h.video != nil {
+		if v.peaks, err = h.video.waveform(data); err == nil {
+			return v, nil
+		}
+	}
+	sizes := make([]float64, len(packets))
+	for i, n := range packets {
+		sizes[i] = float64(n)
+	}
+	v.peaks = peaks(sizes, false)
+	return v, nil
+}
+
+// waveform decodes audio with ffmpeg and returns its peak levels.
+func (v *videoTools) waveform(data []byte) ([]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), videoToolTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, v.ffmpeg, "-v", "error", "-i", "-",
+		"-ac", "1", "-ar", "8000", "-f", "s16le", "-")
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	samples := make([]float64, len(out)/2)
+	for i := range samples {
+		samples[i] = math.Abs(float64(int16(binary.LittleEndian.Uint16(out[2*i:]))))
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("ffmpeg produced no audio")
+	}
+	return peaks(samples, true), nil
+}
+
+// peaks reduces levels to waveformPeaks bars scaled to 0-100, taking each
+// bar's loudest sample, or for packet sizes their mean measured up from
+// the smallest, since even silence takes a few bytes.
+func peaks(levels []float64, loudest bool) []int {
+	n := min(waveformPeaks, len(levels))
+	bars := make([]float64, n)
+	for i := range bars {
+		bucket := levels[i*len(levels)/n : (i+1)*len(levels)/n]
+		for _, l := range bucket {
+			if loudest {
+				bars[i] = max(bars[i], l)
+			} else {
+				bars[i] += l / float64(len(bucket))
+			}
+		}
+	}
+	lo, hi := 0.0, 0.0
+	if !loudest {
+		lo = slices.Min(bars)
+	}
+	for _, b := range bars {
+		hi = max(hi, b)
+	}
+	out := make([]int, n)
+	for i, b := range bars {
+		if hi > lo {
+			out[i] = int(math.Round((b - lo) / (hi - lo) * 100))
+		}
+	}
+	return out
+}
+
+// ebmlVint reads an EBML variable-length integer, keeping the length
+// marker for element IDs.  unknown is set for sizes with every bit set.
+func ebmlVint(b []byte, marker bool) (v uint64, n int, unknown bool, ok bool) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0, false, false
+	}
+	n = bits.LeadingZeros8(b[0]) + 1
+	if len(b) < n {
+		return 0, 0, false, false
+	}
+	mask := byte(0xFF >> n)
+	v = uint64(b[0])
+	if !marker {
+		v = uint64(b[0] & mask)
+	}
+	unknown = b[0]&mask == mask
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+		unknown = unknown && c == 0xFF
+	}
+	return v, n, unknown, true
+}
+
+// EBML element IDs used by probeWebMOpus.
+const (
+	ebmlHeader        = 0x1A45DFA3
+	ebmlSegment       = 0x18538067
+	ebmlInfo          = 0x1549A966
+	ebmlTimecodeScale = 0x2AD7B1
+	ebmlDuration      = 0x4489
+	ebmlTracks        = 0x1654AE6B
+	ebmlTrackEntry    = 0xAE
+	ebmlTrackNumber   = 0xD7
+	ebmlTrackType     = 0x83
+	ebmlCodecID       = 0x86
+	ebmlCluster       = 0x1F43B675
+	ebmlTimecode      = 0xE7
+	ebmlBlockGroup    = 0xA0
+	ebmlBlock         = 0xA1
+	ebmlSimpleBlock   = 0xA3
+)
+
+// probeWebMOpus returns the sizes of the Opus packets in a WebM file and
+// its duration.  Elements are walked in order rather than as a tree, since
+// MediaRecorder writes segments and clusters of unknown size.
+func probeWebMOpus(data []byte) ([]int, float64, error) {
+	if len(data) < 4 || binary.BigEndian.Uint32(data) != ebmlHeader {
+		return nil, 0, errNotOpus
+	}
+	type track struct {
+		number uint64
+		kind   uint64
+		codec  string
+	}
+	var tracks []track
+	type block struct {
+		track uint64
+		at    int64 // in timecode units
+		size  int
+	}
+	var blocks []block
+	scale, duration := uint64(1000000), 0.0
+	var cluster int64
+
+	for i := 0; i < len(data); {
+		id, idLen, _, ok := ebmlVint(data[i:], true)
+		if !ok {
+			break
+		}
+		size, sizeLen, unknown, ok := ebmlVint(data[i+idLen:], false)
+		if !ok {
+			break
+		}
+		start := i + idLen + sizeLen
+		switch id {
+		case ebmlSegment, ebmlInfo, ebmlTracks, ebmlCluster, ebmlBlockGroup:
+			i = start // step inside
+			continue
+		case ebmlTrackEntry:
+			tracks = append(tracks, track{})
+			i = start
+			continue
+		}
+		if unknown || size > uint64(len(data)-start) {
+			break // cut short; keep what we have
+		}
+		payload := data[start : start+int(size)]
+		i = start + int(size)
+
+		switch id {
+		case ebmlTimecodeScale:
+			if s := ebmlUint(payload); s > 0 {
+				scale = s
+			}
+		case ebmlDuration:
+			switch len(payload) {
+			case 4:
+				duration = float64(math.Float32frombits(binary.BigEndian.Uint32(payload)))
+			case 8:
+				duration = math.Float64frombits(binary.BigEndian.Uint64(payload))
+			}
+		case ebmlTrackNumber, ebmlTrackType, ebmlCodecID:
+			if len(tracks) == 0 {
+				continue
+			}
+			t := &tracks[len(tracks)-1]
+			switch id {
+			case ebmlTrackNumber:
+				t.number = ebmlUint(payload)
+			case ebmlTrackType:
+				t.kind = ebmlUint(payload)
+			default:
+				t.codec = string(bytes.TrimRight(payload, "\x00"))
+			}
+		case ebmlTimecode:
+			cluster = int64(ebmlUint(payload))
+		case ebmlSimpleBlock, ebmlBlock:
+			num, n, _, ok := ebmlVint(payload, false)
+			if !ok || len(payload) < n+3 {
+				return nil, 0, errNotOpus
+			}
+			at := cluster + int64(int16(binary.BigEndian.Uint16(payload[n:])))
+			blocks = append(blocks, block{num, at, len(payload) - n - 3})
+		}
+	}
+
+	var opus uint64
+	for _, t := range tracks {
+		switch {
+		case t.kind == 1:
+			return nil, 0, errors.New("voice notes can't contain video")
+		case t.codec == "A_OPUS" && opus == 0:
+			opus = t.number
+		}
+	}
+	if opus == 0 {
+		return nil, 0, errNotOpus
+	}
+	var packets []int
+	var last int64
+	for _, b := range blocks {
+		if b.track == opus {
+			packets = append(packets, b.size)
+			last = max(last, b.at)
+		}
+	}
+	if duration == 0 && len(packets) > 0 {
+		// Recordings from MediaRecorder don't say how long they are;
+		// the last packet's time is close, plus a typical 20ms frame.
+		duration = float64(last) + 0.02*1e9/float64(scale)
+	}
+	return packets, duration * float64(scale) / 1e9, nil
+}
+
+// ebmlUint reads a big-endian unsigned integer element.
+func ebmlUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// probeOggOpus returns the sizes of the audio packets in the first stream
+// of an Ogg Opus file and its duration, from the last granule position.
+func probeOggOpus(data []byte) ([]int, float64, error) {
+	var packets []int
+	var serial uint32
+	haveSerial := false
+	var preSkip, granule int64 = 0, -1
+	cur, first := 0, true
+	for i := 0; i+27 <= len(data); {
+		if string(data[i:i+4]) != "OggS" {
+			return nil, 0, errNotOpus
+		}
+		segs := int(data[i+26])
+		body := i + 27 + segs
+		if body > len(data) {
+			break
+		}
+		s := binary.LittleEndian.Uint32(data[i+14:])
+		if !haveSerial {
+			serial, haveSerial = s, true
+		}
+		pageEnd := body
+		for _, l := range data[i+27 : body] {
+			pageEnd += int(l)
+		}
+		if pageEnd > len(data) {
+			break // cut short
+		}
+		if s != serial {
+			i = pageEnd
+			continue
+		}
+		if g := int64(binary.LittleEndian.Uint64(data[i+6:])); g >= 0 {
+			granule = g
+		}
+
+		at := body
+		for _, l := range data[i+27 : body] {
+			cur += int(l)
+			at += int(l)
+			if l == 255 {
+				continue // the packet goes on
+			}
+			if first {
+				// The first packet is the OpusHead header.
+				head := data[at-cur : at]
+				if cur < 19 || string(head[:8]) != "OpusHead" {
+					return nil, 0, errNotOpus
+				}
+				preSkip = int64(binary.LittleEndian.Uint16(head[10:]))
+				first = false
+			} else {
+				packets = append(packets, cur)
+			}
+			cur = 0
+		}
+		i = pageEnd
+	}
+	if first {
+		return nil, 0, errNotOpus
+	}
+	// The second packet is OpusTags.
+	if len(packets) > 0 {
+		packets = packets[1:]
+	}
+	if granule <= preSkip {
+		return packets, 0, nil
+	}
+	return packets, float64(granule-preSkip) / 48000, nil
+}
@@ -0,0 +1,52 @@
+package handlers
+
+// ─── Per-channel voice quality ───────────────────────────────────────────────
+//
+// Admins can cap a voice channel's Opus bitrate and video resolution.  The
+// caps reach clients in voice.room_state (and voice.quality when changed
+// mid-call); clients apply them to what they send.  With the SFU the server
+// also advertises matching bitrate limits during negotiation.
+
+// roomQuality returns the quality caps of channelID as sent to clients.
+func (h *Hub) roomQuality(channelID string) map[string]int {
+	q := map[string]int{"audio_bitrate": 0, "video_height": 0}
+	if ch, err := h.db.GetChannelByID(channelID); err == nil {
+		q["audio_bitrate"] = ch.AudioBitrate
+		q["video_height"] = ch.VideoHeight
+	}
+	return q
+}
+
+// sfuQuality converts channelID's caps into SFU bitrate limits in kbit/s.
+func (h *Hub) sfuQuality(channelID string) (audioKbps, videoKbps int) {
+	ch, err := h.db.GetChannelByID(channelID)
+	if err != nil {
+		return 0, 0
+	}
+	return ch.AudioBitrate, videoKbpsForHeight(ch.VideoHeight)
+}
+
+// videoKbpsForHeight is a rough bitrate budget for a given resolution.
+func videoKbpsForHeight(height int) int {
+	switch {
+	case height <= 0:
+		return 0
+	case height <= 180:
+		return 250
+	case height <= 360:
+		return 600
+	case height <= 480:
+		return 1000
+	case height <= 720:
+		return 2000
+	default:
+		return 4000
+	}
+}
+
+// validVoiceQuality reports whether the caps are within what clients accept.
+func validVoiceQuality(audioBitrate, videoHeight int) bool {
+	audioOK := audioBitrate == 0 || (audioBitrate >= 6 && audioBitrate <= 510)
+	videoOK := videoHeight == 0 || (videoHeight >= 144 && videoHeight <= 2160)
+	return audioOK && videoOK
+}
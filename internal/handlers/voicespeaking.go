@@ -0,0 +1,76 @@
+package handlers
+
+import "time"
+
+// ─── Speaking activity ───────────────────────────────────────────────────────
+//
+// Each client measures only its own microphone and reports voice.speaking
+// when it starts or stops talking; the hub relays that to the room so nobody
+// has to run level detection on every remote stream.  Relays are limited to
+// one per speakingInterval per client.  Changes arriving faster are coalesced
+// and the latest state is sent when the interval ends, so a "stopped" is
+// never lost.
+
+const speakingInterval = 200 * time.Millisecond
+
+type speakingState struct {
+	channelID string
+	speaking  bool // last state relayed
+	want      bool // latest state reported by the client
+	sentAt    time.Time
+	timer     *time.Timer
+}
+
+// reportSpeaking records the client's speaking state and relays it to the
+// room, subject to the rate limit.
+func (c *Client) reportSpeaking(channelID string, speaking bool) {
+	c.mu.Lock()
+	st := &c.speaking
+	if st.channelID != channelID {
+		*st = speakingState{channelID: channelID}
+	}
+	st.want = speaking
+	var evt *WSEvent
+	switch {
+	case st.timer != nil || st.want == st.speaking:
+		// a flush is already scheduled, or nothing changed
+	case time.Since(st.sentAt) < speakingInterval:
+		st.timer = time.AfterFunc(speakingInterval-time.Since(st.sentAt), c.flushSpeaking)
+	default:
+		evt = c.nextSpeakingEvent()
+	}
+	c.mu.Unlock()
+	if evt != nil {
+		c.hub.relayToVoiceRoom(channelID, *evt, c)
+	}
+}
+
+func (c *Client) flushSpeaking() {
+	c.mu.Lock()
+	c.speaking.timer = nil
+	var evt *WSEvent
+	if c.speaking.want != c.speaking.speaking {
+		evt = c.nextSpeakingEvent()
+	}
+	channelID := c.speaking.channelID
+	c.mu.Unlock()
+	if evt != nil {
+		c.hub.relayToVoiceRoom(channelID, *evt, c)
+	}
+}
+
+// nextSpeakingEvent marks the wanted state as sent and returns the event to
+// relay.  c.mu must be held.
+func (c *Client) nextSpeakingEvent() *WSEvent {
+	st := &c.speaking
+	st.speaking = st.want
+	st.sentAt = time.Now()
+	return &WSEvent{
+		Type: "voice.speaking",
+		Data: map[string]interface{}{
+			"channel_id": st.channelID,
+			"user_id":    c.userID,
+			"speaking":   st.speaking,
+		},
+	}
+}
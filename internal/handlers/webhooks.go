@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"chirm/internal/db"
+	mw "chirm/internal/middleware"
+)
+
+// ─── Webhooks ────────────────────────────────────────────────────────────────
+//
+// Admins give a channel webhooks; an integration posts to
+// /api/webhooks/{id}/{token} with content, rich embeds or both, and the
+// message appears under the webhook's name (or the username it sends).
+// Slack's payload works too; see slackwebhooks.go.
+
+// maxWebhookBody is the most a webhook call may send.
+const maxWebhookBody = 256 << 10
+
+// ListWebhooks handles GET /api/channels/{id}/webhooks (guild admins only).
+func (h *Handler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	if _, isAdmin := h.requireGuildAdmin(w, r, h.db.ChannelGuild(chi.URLParam(r, "id"))); !isAdmin {
+		return
+	}
+	hooks, err := h.db.ListWebhooks(chi.URLParam(r, "id"))
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to list webhooks")
+		return
+	}
+	ok(w, hooks)
+}
+
+// CreateWebhookRequest is the body of POST /api/channels/{id}/webhooks.
+type CreateWebhookRequest struct {
+	Name string `json:"name"`
+}
+
+// CreateWebhook handles POST /api/channels/{id}/webhooks (guild admins only).  The
+// response has the webhook's token and URL, which aren't shown again.
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	u, isAdmin := h.requireGuildAdmin(w, r, h.db.ChannelGuild(chi.URLParam(r, "id")))
+	if !isAdmin {
+		return
+	}
+	ch, err := h.db.GetChannelByID(chi.URLParam(r, "id"))
+	if err != nil {
+		errResp(w, http.StatusNotFound, "channel not found")
+		return
+	}
+	var req CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if len(req.Name) < 2 || len(req.Name) > 32 {
+		errResp(w, http.StatusBadRequest, "webhook name must be 2-32 characters")
+		return
+	}
+
+	hook, token, err := h.db.CreateWebhook(ch.ID, req.Name, u.ID)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to create webhook")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{
+		ActorID:  u.ID,
+		Action:   "webhook.create",
+		TargetID: hook.ID,
+		Details:  "created webhook " + hook.Name + " in #" + ch.Name,
+	})
+	created(w, map[string]interface{}{
+		"webhook": hook,
+		"token":   token,
+		"url":     "/api/v1/webhooks/" + hook.ID + "/" + token,
+	})
+}
+
+// DeleteWebhook handles DELETE /api/webhooks/{id} (guild admins only).  Messages it
+// posted stay.
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	guildID := db.DefaultGuild
+	hook, err := h.db.GetWebhook(chi.URLParam(r, "id"))
+	if err == nil {
+		guildID = h.db.ChannelGuild(hook.ChannelID)
+	}
+	u, isAdmin := h.requireGuildAdmin(w, r, guildID)
+	if !isAdmin {
+		return
+	}
+	if err != nil {
+		errResp(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	if err := h.db.DeleteWebhook(hook.ID); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to delete webhook")
+		return
+	}
+	h.db.AddAuditEntry(db.AuditEntry{
+		ActorID:  u.ID,
+		Action:   "webhook.delete",
+		TargetID: hook.ID,
+		Details:  "deleted webhook " + hook.Name,
+	})
+	ok(w, map[string]string{"message": "webhook deleted"})
+}
+
+// ExecuteWebhookRequest is the body of POST /api/webhooks/{id}/{token}.
+type ExecuteWebhookRequest struct {
+	Content  string      `json:"content"`
+	Username string      `json:"username"` // instead of the webhook's name
+	Embeds   []RichEmbed `json:"embeds"`
+}
+
+// ExecuteWebhook handles POST /api/webhooks/{id}/{token}, posting a message
+// into the webhook's channel.  No login is needed; the token is the key.
+// The body may be Slack's instead of an ExecuteWebhookRequest.
+func (h *Handler) ExecuteWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, err := h.db.WebhookByToken(chi.URLParam(r, "id"), chi.URLParam(r, "token"))
+	if err != nil {
+		errResp(w, http.StatusNotFound, "unknown webhook")
+		return
+	}
+
+	mw.SetBodyLimit(r, maxWebhookBody)
+	req, slack, err := readWebhookRequest(r)
+	if err != nil {
+		errResp(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	req.Content = strings.TrimSpace(req.Content)
+	if req.Content == "" && len(req.Embeds) == 0 {
+		errResp(w, http.StatusBadRequest, "message cannot be empty")
+		return
+	}
+	if len(req.Content) > 4000 {
+		errResp(w, http.StatusBadRequest, "message too long")
+		return
+	}
+	name := hook.Name
+	if req.Username = strings.TrimSpace(req.Username); req.Username != "" {
+		if len(req.Username) > 32 {
+			errResp(w, http.StatusBadRequest, "username must be at most 32 characters")
+			return
+		}
+		name = req.Username
+	}
+	var embeds json.RawMessage
+	if len(req.Embeds) > 0 {
+		if err := validateRichEmbeds(req.Embeds); err != nil {
+			errResp(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		embeds, _ = json.Marshal(req.Embeds)
+	}
+
+	msg, err := h.db.CreateWebhookMessage(hook, name, req.Content, embeds)
+	if err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to send message")
+		return
+	}
+	h.publishMessage(msg, "")
+	if slack {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok"))
+		return
+	}
+	created(w, msg)
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chirm/internal/db"
+)
+
+// ─── Welcome automation ──────────────────────────────────────────────────────
+//
+// Admins can have each new member greeted: welcome_message is posted to
+// welcome_channel_id, and welcome_private_message is shown to the newcomer
+// alone when they first open the app.  Both are templates, see
+// renderWelcome.  With rules_gate on, members must also accept the server
+// rules (agreement_text) before they can post.
+
+// maxWelcomeText caps the welcome templates, in characters.
+const maxWelcomeText = 2000
+
+// renderWelcome fills in a welcome template's placeholders: {user} is the
+// new member's name, {mention} the same as an @mention, {server} the server's
+// name and {members} how many members it now has.
+func (h *Handler) renderWelcome(tmpl string, u *db.User) string {
+	server, _ := h.db.GetSetting("server_name")
+	if server == "" {
+		server = "Chirm"
+	}
+	return strings.NewReplacer(
+		"{user}", u.Username,
+		"{mention}", "@"+u.Username,
+		"{server}", server,
+		"{members}", strconv.Itoa(h.db.UserCount()),
+	).Replace(tmpl)
+}
+
+// validWelcomeSetting reports whether v may be saved as welcome setting k.
+func (h *Handler) validWelcomeSetting(k, v string) bool {
+	switch k {
+	case "welcome_message", "welcome_private_message":
+		return len([]rune(v)) <= maxWelcomeText
+	case "welcome_channel_id":
+		if v == "" {
+			return true
+		}
+		ch, err := h.db.GetChannelByID(v)
+		return err == nil && ch.Type == "text"
+	case "rules_gate":
+		return v == "0" || v == "1"
+	}
+	return false
+}
+
+// welcomeNewMember greets u, who has just registered, in the welcome
+// channel, and returns the private welcome for them ("" for none).
+func (h *Handler) welcomeNewMember(u *db.User) string {
+	channelID, _ := h.db.GetSetting("welcome_channel_id")
+	tmpl, _ := h.db.GetSetting("welcome_message")
+	if channelID != "" && strings.TrimSpace(tmpl) != "" {
+		msg, err := h.db.CreateWelcomeMessage(channelID, u.ID, h.renderWelcome(tmpl, u))
+		if err != nil {
+			slog.Error("welcome message", "channel", channelID, "err", err)
+		} else {
+			h.hub.BroadcastToChannel(channelID, WSEvent{Type: "message.new", Data: msg})
+		}
+	}
+	private, _ := h.db.GetSetting("welcome_private_message")
+	if strings.TrimSpace(private) == "" {
+		return ""
+	}
+	return h.renderWelcome(private, u)
+}
+
+// rulesText returns the rules members must accept, or "" when the gate is
+// off or there are no rules to accept.
+func (h *Handler) rulesText() string {
+	if v, _ := h.db.GetSetting("rules_gate"); v != "1" {
+		return ""
+	}
+	text, _ := h.db.GetSetting("agreement_text")
+	return strings.TrimSpace(text)
+}
+
+// mustAcceptRules reports whether u may not post until they accept the
+// rules.  Admins never have to.
+func (h *Handler) mustAcceptRules(u *db.User) bool {
+	if h.rulesText() == "" || h.db.HasPermission(u, db.PermManageServer) {
+		return false
+	}
+	return h.db.RulesAcceptedAt(u.ID) == nil
+}
+
+// RulesStatus is the body of GET /api/me/rules.
+type RulesStatus struct {
+	Required   bool       `json:"required"` // must accept before posting
+	Text       string     `json:"text,omitempty"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+}
+
+// GetMyRules handles GET /api/me/rules: whether the current user still has
+// to accept the server rules, and what they are.
+func (h *Handler) GetMyRules(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	ok(w, RulesStatus{
+		Required:   h.mustAcceptRules(u),
+		Text:       h.rulesText(),
+		AcceptedAt: h.db.RulesAcceptedAt(u.ID),
+	})
+}
+
+// AcceptMyRules handles POST /api/me/rules/accept.
+func (h *Handler) AcceptMyRules(w http.ResponseWriter, r *http.Request) {
+	u, err := h.currentUser(r)
+	if err != nil || u == nil {
+		errResp(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if err := h.db.AcceptRules(u.ID); err != nil {
+		errResp(w, http.StatusInternalServerError, "failed to accept rules")
+		return
+	}
+	ok(w, RulesStatus{Text: h.rulesText(), AcceptedAt: h.db.RulesAcceptedAt(u.ID)})
+}
@@ -0,0 +1,307 @@
+// Package jobs runs Chirm's background work on a schedule: cleanups,
+// retention, digests, backups and certificate renewal.  Each job runs every
+// so often, a random bit later each time so instances sharing a database
+// don't all reach for it at once, and can be run at once on demand.  A
+// shared job runs on one instance at a time, which claims it in the
+// database first; its schedule counts from the last run on any instance,
+// so restarts don't put it off.  The rest run on every instance.
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+
+	"chirm/internal/db"
+)
+
+// lease is how long a claim on a shared job lasts unless renewed; a
+// running job's is renewed every lease/5.
+const lease = 5 * time.Minute
+
+// claimRetry is how long after failing to claim a shared job it's tried
+// again.
+const claimRetry = 10 * time.Second
+
+// ErrUnknownJob is returned by RunNow for a job that isn't scheduled.
+var ErrUnknownJob = errors.New("no such job")
+
+// Job is some background work.
+type Job struct {
+	Name   string
+	Every  time.Duration // 0 to only run it on demand
+	Retry  time.Duration // after a failed run, if sooner than Every
+	Delay  time.Duration // before its first run, when it has never run
+	Jitter time.Duration // most a run is put off by, at random
+	Shared bool          // one instance at a time, with its state in the database
+	Run    func() error
+}
+
+// Status is how a job stands, for admins.
+type Status struct {
+	Name      string     `json:"name"`
+	Shared    bool       `json:"shared"`
+	Every     int64      `json:"every_seconds"` // 0 if only run on demand
+	Running   bool       `json:"running"`
+	RunningOn string     `json:"running_on,omitempty"` // instance, for shared jobs
+	Runs      int64      `json:"runs"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	LastTook  int64      `json:"last_took_ms"`
+	LastError string     `json:"last_error,omitempty"`
+	LastOn    string     `json:"last_instance,omitempty"`
+	NextRun   *time.Time `json:"next_run,omitempty"` // as this instance sees it
+}
+
+type entry struct {
+	Job
+	trigger chan struct{}
+
+	mu      sync.Mutex
+	state   db.JobRun // this instance's runs, for jobs that aren't shared
+	running bool
+	next    time.Time
+}
+
+// Scheduler runs jobs.  Add them, then Start it.
+type Scheduler struct {
+	db       *db.DB
+	instance string
+	started  time.Time
+
+	mu   sync.Mutex
+	jobs map[string]*entry
+}
+
+// New returns a scheduler that keeps shared jobs' state in d, claiming them
+// as instance, which should be unique among the instances sharing d.
+func New(d *db.DB, instance string) *Scheduler {
+	return &Scheduler{db: d, instance: instance, jobs: map[string]*entry{}}
+}
+
+// Add schedules job; after Start, it starts at once.
+func (s *Scheduler) Add(job Job) {
+	if job.Name == "" || job.Run == nil {
+		panic("jobs: a job needs a name and something to run")
+	}
+	e := &entry{Job: job, trigger: make(chan struct{}, 1)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, dup := s.jobs[job.Name]; dup {
+		panic("jobs: " + job.Name + " added twice")
+	}
+	s.jobs[job.Name] = e
+	if !s.started.IsZero() {
+		go s.loop(e)
+	}
+}
+
+// Start runs the jobs added so far, and those added later.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.started.IsZero() {
+		return
+	}
+	s.started = time.Now()
+	for _, e := range s.jobs {
+		go s.loop(e)
+	}
+}
+
+// RunNow has job name run as soon as it can, which for a shared job running
+// elsewhere is not at all.
+func (s *Scheduler) RunNow(name string) error {
+	s.mu.Lock()
+	e := s.jobs[name]
+	s.mu.Unlock()
+	if e == nil {
+		return ErrUnknownJob
+	}
+	select {
+	case e.trigger <- struct{}{}:
+	default: // already asked
+	}
+	return nil
+}
+
+// Statuses describes every job, by name.
+func (s *Scheduler) Statuses() []Status {
+	s.mu.Lock()
+	entries := make([]*entry, 0, len(s.jobs))
+	for _, e := range s.jobs {
+		entries = append(entries, e)
+	}
+	s.mu.Unlock()
+	shared, err := s.db.JobRuns()
+	if err != nil {
+		slog.Error("jobs: reading shared job state", "err", err)
+	}
+
+	out := make([]Status, 0, len(entries))
+	for _, e := range entries {
+		e.mu.Lock()
+		st := Status{Name: e.Name, Shared: e.Shared, Every: int64(e.Every.Seconds()), Running: e.running}
+		state, next := e.state, e.next
+		e.mu.Unlock()
+		if e.Shared {
+			state = shared[e.Name]
+			if state.LockedBy != "" && time.Now().Before(state.LockedUntil) {
+				st.Running, st.RunningOn = true, state.LockedBy
+			}
+			st.LastOn = state.Instance
+		}
+		st.Runs, st.LastTook, st.LastError = state.Runs, state.Took.Milliseconds(), state.Error
+		if !state.LastRun.IsZero() {
+			st.LastRun = &state.LastRun
+		}
+		if !next.IsZero() {
+			st.NextRun = &next
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// lastRun is how e last ran, on any instance if it's shared.
+func (s *Scheduler) lastRun(e *entry) (db.JobRun, error) {
+	if e.Shared {
+		return s.db.GetJobRun(e.Name)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.state, nil
+}
+
+// due is when e should next run after last, or zero if only on demand.
+func (s *Scheduler) due(e *entry, last db.JobRun) time.Time {
+	var at time.Time
+	switch {
+	case e.Every == 0:
+		return time.Time{}
+	case last.LastRun.IsZero():
+		at = s.started.Add(e.Delay)
+	case last.Error != "" && e.Retry > 0 && e.Retry < e.Every:
+		at = last.LastRun.Add(e.Retry)
+	default:
+		at = last.LastRun.Add(e.Every)
+	}
+	// Running elsewhere: look again once its claim would have run out.
+	if e.Shared && last.LockedBy != "" && last.LockedBy != s.instance && at.Before(last.LockedUntil) {
+		at = last.LockedUntil
+	}
+	if e.Jitter > 0 {
+		at = at.Add(rand.N(e.Jitter))
+	}
+	return at
+}
+
+func (s *Scheduler) loop(e *entry) {
+	for {
+		last, err := s.lastRun(e)
+		if err != nil {
+			slog.Error("jobs: reading job state", "job", e.Name, "err", err)
+			time.Sleep(time.Minute)
+			continue
+		}
+		next := s.due(e, last)
+		e.mu.Lock()
+		e.next = next
+		e.mu.Unlock()
+
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if !next.IsZero() {
+			timer = time.NewTimer(max(time.Until(next), 0))
+			fire = timer.C
+		}
+		runs := last.Runs
+		select {
+		case <-fire:
+		case <-e.trigger:
+			runs = -1
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		s.run(e, runs)
+	}
+}
+
+// run runs e if it can have it: a shared job's claim needs it to have run
+// runs times, or runs < 0 for any number.
+func (s *Scheduler) run(e *entry, runs int64) {
+	if e.Shared {
+		got, err := s.db.ClaimJob(e.Name, s.instance, runs, lease)
+		if err != nil {
+			// Most likely the database was busy; try again shortly,
+			// keeping an admin's request to run it now.
+			slog.Warn("jobs: claiming job", "job", e.Name, "err", err)
+			time.Sleep(claimRetry)
+			if runs < 0 {
+				s.RunNow(e.Name)
+			}
+			return
+		}
+		if !got {
+			return // another instance has it, or just had it
+		}
+	}
+	e.mu.Lock()
+	e.running = true
+	e.mu.Unlock()
+
+	done := make(chan struct{})
+	if e.Shared {
+		go func() {
+			tick := time.NewTicker(lease / 5)
+			defer tick.Stop()
+			for {
+				select {
+				case <-tick.C:
+					s.db.RenewJobClaim(e.Name, s.instance, lease)
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+	err := runJob(e)
+	took := time.Since(start)
+	close(done)
+	var errText string
+	if err != nil {
+		errText = err.Error()
+		slog.Error("job failed", "job", e.Name, "took", took.Round(time.Millisecond), "err", err)
+	} else {
+		slog.Debug("job ran", "job", e.Name, "took", took.Round(time.Millisecond))
+	}
+
+	e.mu.Lock()
+	e.running = false
+	e.state.Runs++
+	e.state.LastRun, e.state.Took, e.state.Error = start, took, errText
+	e.mu.Unlock()
+	if e.Shared {
+		if err := s.db.FinishJob(e.Name, s.instance, start, took, errText); err != nil {
+			slog.Error("jobs: recording job run", "job", e.Name, "err", err)
+		}
+	}
+}
+
+// runJob runs e's work, turning a panic into an error so one bad run
+// doesn't take the server down with it.
+func runJob(e *entry) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return e.Run()
+}
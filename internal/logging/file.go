@@ -0,0 +1,238 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ─── Log files ───────────────────────────────────────────────────────────────
+//
+// Without journald to keep history, the server can also write its log to a
+// file.  Once the file grows past its size limit, or has been written to for
+// longer than its age limit, it's renamed with the time (chirm.log becomes
+// chirm-20240102-150405.log), gzipped if asked, and a new one started; only
+// the newest few old files are kept.
+
+// stampLayout is the time in an old log file's name, when it was rotated.
+const stampLayout = "20060102-150405"
+
+// FileConfig describes a rotating log file.
+type FileConfig struct {
+	Path     string
+	MaxBytes int64         // rotate past this size; 0 for no limit
+	MaxAge   time.Duration // rotate once the file is this old; 0 for no limit
+	Keep     int           // old files to keep
+	Compress bool          // gzip old files
+}
+
+// File is a log file that rotates itself.  It's safe for concurrent use.
+type File struct {
+	cfg FileConfig
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	started time.Time // when the current file was begun
+
+	cleanMu sync.Mutex // one compress-and-prune at a time
+}
+
+// OpenFile opens the log file cfg describes, appending to it if it's there.
+func OpenFile(cfg FileConfig) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o750); err != nil {
+		return nil, err
+	}
+	lf := &File{cfg: cfg, started: time.Now()}
+	if err := lf.open(); err != nil {
+		return nil, err
+	}
+	// The current file was begun when the last one was rotated, so a
+	// restart doesn't put off rotating by age.
+	if old := lf.oldFiles(); len(old) > 0 {
+		lf.started = old[0].at
+	}
+	return lf, nil
+}
+
+func (lf *File) open() error {
+	f, err := os.OpenFile(lf.cfg.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	lf.f, lf.size = f, fi.Size()
+	return nil
+}
+
+// Write writes p to the file, first rotating it if it's due.
+func (lf *File) Write(p []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.due(len(p)) {
+		if err := lf.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "rotating log file: %v\n", err)
+		}
+	}
+	if lf.f == nil {
+		return 0, os.ErrClosed
+	}
+	n, err := lf.f.Write(p)
+	lf.size += int64(n)
+	return n, err
+}
+
+func (lf *File) due(n int) bool {
+	if lf.size == 0 {
+		return false
+	}
+	if lf.cfg.MaxBytes > 0 && lf.size+int64(n) > lf.cfg.MaxBytes {
+		return true
+	}
+	return lf.cfg.MaxAge > 0 && time.Since(lf.started) >= lf.cfg.MaxAge
+}
+
+// rotate renames the current file out of the way and starts a new one,
+// then compresses and prunes the old ones in the background.
+func (lf *File) rotate() error {
+	now := time.Now()
+	old := lf.oldName(now)
+	if _, err := os.Stat(old); err == nil {
+		return nil // rotated already this second; try again on a later write
+	}
+	if _, err := os.Stat(old + ".gz"); err == nil {
+		return nil
+	}
+	lf.f.Close()
+	lf.f = nil
+	renameErr := os.Rename(lf.cfg.Path, old)
+	if err := lf.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	lf.started = now
+	go lf.clean(old)
+	return nil
+}
+
+// Close closes the file.
+func (lf *File) Close() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.f == nil {
+		return nil
+	}
+	err := lf.f.Close()
+	lf.f = nil
+	return err
+}
+
+// oldName is what the current file is renamed to when rotated at t.
+func (lf *File) oldName(t time.Time) string {
+	prefix, ext := lf.nameParts()
+	return prefix + t.UTC().Format(stampLayout) + ext
+}
+
+// nameParts splits the path into what comes before an old file's time and
+// what comes after it.
+func (lf *File) nameParts() (prefix, ext string) {
+	ext = filepath.Ext(lf.cfg.Path)
+	return strings.TrimSuffix(lf.cfg.Path, ext) + "-", ext
+}
+
+type oldFile struct {
+	path string
+	at   time.Time
+}
+
+// oldFiles lists the rotated files, newest first.
+func (lf *File) oldFiles() []oldFile {
+	prefix, ext := lf.nameParts()
+	dir := filepath.Dir(lf.cfg.Path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []oldFile
+	for _, e := range entries {
+		p := filepath.Join(dir, e.Name())
+		stamp, found := strings.CutPrefix(p, prefix)
+		if !found {
+			continue
+		}
+		stamp = strings.TrimSuffix(stamp, ".gz")
+		stamp, found = strings.CutSuffix(stamp, ext)
+		if !found {
+			continue
+		}
+		at, err := time.Parse(stampLayout, stamp)
+		if err != nil {
+			continue
+		}
+		files = append(files, oldFile{path: p, at: at})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].at.After(files[j].at) })
+	return files
+}
+
+// clean compresses the file just rotated to old, if asked, and removes all
+// but the newest Keep old files.
+func (lf *File) clean(old string) {
+	lf.cleanMu.Lock()
+	defer lf.cleanMu.Unlock()
+	if lf.cfg.Compress {
+		if err := compress(old); err != nil {
+			fmt.Fprintf(os.Stderr, "compressing old log file: %v\n", err)
+		}
+	}
+	kept := 0
+	var last time.Time
+	for _, f := range lf.oldFiles() {
+		// A file left both plain and compressed by a crash counts once.
+		if !f.at.Equal(last) {
+			kept++
+			last = f.at
+		}
+		if kept > lf.cfg.Keep {
+			os.Remove(f.path)
+		}
+	}
+}
+
+// compress gzips path into path.gz and removes it.
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,103 @@
+// Package logging sets up Chirm's structured logs and carries a request ID
+// through each request, so every line a request (or a WebSocket connection)
+// logs can be picked out of journald together.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Setup makes slog's default logger write to w in format ("text" or
+// "json") at level ("debug", "info", "warn" or "error").  The standard
+// log package goes through it too, at info.
+func Setup(w io.Writer, format, level string) error {
+	var lvl slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		lvl = slog.LevelDebug
+	case "", "info":
+		lvl = slog.LevelInfo
+	case "warn", "warning":
+		lvl = slog.LevelWarn
+	case "error":
+		lvl = slog.LevelError
+	default:
+		return fmt.Errorf("unknown level %q (want debug, info, warn or error)", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown format %q (want text or json)", format)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+type contextKey struct{}
+
+// request is what's known about the request a context belongs to.  The
+// user is filled in once authentication has run, further down the chain.
+type request struct {
+	id     string
+	userID string
+}
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns ctx carrying request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, &request{id: id})
+}
+
+// RequestID returns the ID of ctx's request, or "".
+func RequestID(ctx context.Context) string {
+	if req, _ := ctx.Value(contextKey{}).(*request); req != nil {
+		return req.id
+	}
+	return ""
+}
+
+// SetUser records who ctx's request is from, for its log lines.
+func SetUser(ctx context.Context, userID string) {
+	if req, _ := ctx.Value(contextKey{}).(*request); req != nil {
+		req.userID = userID
+	}
+}
+
+// UserID returns who ctx's request is from, if known.
+func UserID(ctx context.Context) string {
+	if req, _ := ctx.Value(contextKey{}).(*request); req != nil {
+		return req.userID
+	}
+	return ""
+}
+
+// FromContext returns the default logger with ctx's request ID and user
+// attached.
+func FromContext(ctx context.Context) *slog.Logger {
+	req, _ := ctx.Value(contextKey{}).(*request)
+	if req == nil {
+		return slog.Default()
+	}
+	if req.userID == "" {
+		return slog.Default().With("request_id", req.id)
+	}
+	return slog.Default().With("request_id", req.id, "user_id", req.userID)
+}
@@ -0,0 +1,120 @@
+// Package mail sends Chirm's outgoing email over SMTP.
+//
+// Port 465 uses implicit TLS; any other port connects in plain text and
+// upgrades with STARTTLS when the server offers it, as net/smtp does.
+package mail
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Config describes the SMTP server.
+type Config struct {
+	Host     string
+	Port     int
+	Username string // empty for servers that don't need auth
+	Password string
+	From     string // e.g. "Chirm <chirm@example.com>"
+}
+
+// sendTimeout bounds a whole conversation with the server, so a stalled one
+// can't hold up whoever is sending.
+const sendTimeout = time.Minute
+
+// Mailer sends plain-text messages through one SMTP server.
+type Mailer struct {
+	cfg Config
+}
+
+func New(cfg Config) *Mailer {
+	return &Mailer{cfg: cfg}
+}
+
+// Send delivers a plain-text message to one recipient.  It gives up if the
+// server hasn't finished with it within sendTimeout.
+func (m *Mailer) Send(to, subject, body string) error {
+	addr := net.JoinHostPort(m.cfg.Host, fmt.Sprint(m.cfg.Port))
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+	msg := m.build(to, subject, body)
+
+	dialer := &net.Dialer{Timeout: 15 * time.Second}
+	var conn net.Conn
+	var err error
+	if m.cfg.Port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: m.cfg.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(sendTimeout))
+	c, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if m.cfg.Port != 465 {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(&tls.Config{ServerName: m.cfg.Host}); err != nil {
+				return err
+			}
+		}
+	}
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(envelopeAddr(m.cfg.From)); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// headerSafe keeps user-supplied text (channel names, usernames) from
+// injecting extra headers.
+var headerSafe = strings.NewReplacer("\r", "", "\n", "")
+
+func (m *Mailer) build(to, subject, body string) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + headerSafe.Replace(m.cfg.From) + "\r\n")
+	b.WriteString("To: " + headerSafe.Replace(to) + "\r\n")
+	b.WriteString("Subject: " + headerSafe.Replace(subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// envelopeAddr pulls the bare address out of "Name <addr>".
+func envelopeAddr(from string) string {
+	if i := strings.LastIndex(from, "<"); i >= 0 {
+		return strings.TrimSuffix(from[i+1:], ">")
+	}
+	return from
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// BodyLimit returns middleware that caps request bodies at limit bytes, so a
+// client can't have a handler buffer gigabytes of JSON.  A body over the cap
+// is refused with 413: at the first read if Content-Length gives it away,
+// or as soon as the handler reads past the cap.  Handlers that take uploads
+// raise the cap for their request with SetBodyLimit.
+func BodyLimit(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body := &limitedBody{rc: r.Body, limit: limit, length: r.ContentLength}
+			r.Body = body
+			next.ServeHTTP(&limitWriter{ResponseWriter: w, body: body}, r)
+		})
+	}
+}
+
+// SetBodyLimit changes the cap BodyLimit put on r's body, before it's read.
+func SetBodyLimit(r *http.Request, limit int64) {
+	if b, ok := r.Body.(*limitedBody); ok {
+		b.limit = limit
+	}
+}
+
+type limitedBody struct {
+	rc       io.ReadCloser
+	limit    int64
+	length   int64 // Content-Length, or -1 if unknown
+	read     int64
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded || b.length > b.limit {
+		b.exceeded = true
+		return 0, &http.MaxBytesError{Limit: b.limit}
+	}
+	// Read one byte past the cap, to tell a body that ends there from one
+	// that goes on.
+	if left := b.limit - b.read + 1; int64(len(p)) > left {
+		p = p[:left]
+	}
+	n, err := b.rc.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		b.exceeded = true
+		return n - int(b.read-b.limit), &http.MaxBytesError{Limit: b.limit}
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.rc.Close()
+}
+
+// limitWriter turns the handler's error response to an oversized body into
+// a 413 that says what happened; handlers only see a failed read, and most
+// would call it a bad request.
+type limitWriter struct {
+	http.ResponseWriter
+	body    *limitedBody
+	refused bool
+}
+
+func (w *limitWriter) WriteHeader(code int) {
+	if w.refused {
+		return
+	}
+	if code >= 400 && w.body.exceeded {
+		w.refused = true
+		h := w.ResponseWriter.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Type", "application/json")
+		h.Set("Connection", "close")
+		w.ResponseWriter.WriteHeader(http.StatusRequestEntityTooLarge)
+		fmt.Fprintf(w.ResponseWriter, "{\"error\":\"request body too large (the limit is %s)\"}\n", sizeText(w.body.limit))
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if w.refused {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush passes through, so handlers that stream, like the batch upload's
+// progress, still can.
+func (w *limitWriter) Flush() {
+	if w.refused {
+		return
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *limitWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// sizeText writes n bytes in MB if it's a whole number of them, else in KB.
+func sizeText(n int64) string {
+	if n >= 1<<20 && n%(1<<20) == 0 {
+		return fmt.Sprintf("%d MB", n>>20)
+	}
+	return fmt.Sprintf("%d KB", n>>10)
+}
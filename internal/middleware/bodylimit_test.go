@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyLimitKeepsStreaming(t *testing.T) {
+	var flushable bool
+	h := BodyLimit(1 << 10)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		var f http.Flusher
+		f, flushable = w.(http.Flusher)
+		w.Write([]byte("{\"progress\":1}\n"))
+		if flushable {
+			f.Flush()
+		}
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("body")))
+	if !flushable {
+		t.Fatal("the ResponseWriter BodyLimit passes on isn't an http.Flusher")
+	}
+	if !w.Flushed {
+		t.Error("Flush didn't reach the underlying ResponseWriter")
+	}
+}
+
+func TestBodyLimitRefusesOversizedBody(t *testing.T) {
+	h := BodyLimit(8)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+		}
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("much more than eight bytes")))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got %d, want 413", w.Code)
+	}
+}
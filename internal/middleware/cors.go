@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORSConfig says which other sites' pages may call the API.
+type CORSConfig struct {
+	Origins     []string // full origins such as https://app.example.com, or "*"
+	Credentials bool     // let browsers send cookies along
+	Headers     []string // request headers allowed besides Authorization and Content-Type
+	MaxAge      int      // seconds a preflight answer may be cached
+}
+
+// CORS returns middleware answering cross-origin requests from cfg.Origins.
+// With no origins it does nothing, so browsers keep other sites out.
+// Requests from origins that aren't listed get no CORS headers, which the
+// browser takes as a refusal.
+func CORS(cfg CORSConfig) (func(http.Handler) http.Handler, error) {
+	anyOrigin := false
+	allowed := map[string]bool{}
+	for _, o := range cfg.Origins {
+		if o == "*" {
+			anyOrigin = true
+			continue
+		}
+		o = strings.TrimSuffix(o, "/")
+		if !strings.HasPrefix(o, "http://") && !strings.HasPrefix(o, "https://") &&
+			!strings.Contains(o, "-extension://") {
+			return nil, errors.New("origin " + o + " needs its scheme, e.g. https://" + o)
+		}
+		allowed[strings.ToLower(o)] = true
+	}
+	if anyOrigin && cfg.Credentials {
+		// Any site could then act as a signed-in user.
+		return nil, errors.New("credentials can't be allowed for every origin (*); list the origins")
+	}
+	headers := strings.Join(append([]string{"Authorization", "Content-Type"}, cfg.Headers...), ", ")
+	maxAge := strconv.Itoa(cfg.MaxAge)
+
+	return func(next http.Handler) http.Handler {
+		if !anyOrigin && len(allowed) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || !(anyOrigin || allowed[strings.ToLower(origin)]) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if anyOrigin {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.Credentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+				h.Set("Access-Control-Allow-Headers", headers)
+				h.Set("Access-Control-Max-Age", maxAge)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			h.Set("Access-Control-Expose-Headers", "X-Request-ID, Deprecation, Sunset, Link, "+
+				"RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After")
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chirm/internal/logging"
+)
+
+// Deprecated marks responses under the old path prefix as deprecated, for
+// an API version that still works but is on its way out.  Each response
+// gets a Deprecation header with the date it was deprecated (RFC 9745) and
+// a Link to the same route under successor.  Once a removal date is known
+// it goes in sunset, which adds a Sunset header (RFC 8594); zero leaves it
+// out.  Requests are logged at debug, so the clients still using the old
+// paths can be found before they're removed.
+func Deprecated(prefix, successor string, since, sunset time.Time) func(http.Handler) http.Handler {
+	deprecation := "@" + strconv.FormatInt(since.Unix(), 10)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set("Deprecation", deprecation)
+			if !sunset.IsZero() {
+				h.Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			}
+			if rest, found := strings.CutPrefix(r.URL.Path, prefix); found {
+				h.Add("Link", "<"+successor+rest+`>; rel="successor-version"`)
+			}
+			logging.FromContext(r.Context()).Debug("deprecated API path",
+				"path", r.URL.Path, "user_agent", r.UserAgent())
+			next.ServeHTTP(w, r)
+		})
+	}
+}
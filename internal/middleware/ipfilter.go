@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ParseNetwork parses an IP address or CIDR network; an address becomes a
+// network of just itself.
+func ParseNetwork(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("%q is not an IP address", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a CIDR network", s)
+	}
+	return n, nil
+}
+
+// IPFilter turns clients away by address.  When there are allow rules only
+// the addresses they cover get in, and deny rules keep addresses out either
+// way.  Loopback addresses always get in, so whoever runs the server can't
+// be locked out of it.
+type IPFilter struct {
+	mu    sync.RWMutex
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// Set replaces the rules, taking effect for the next request.
+func (f *IPFilter) Set(allow, deny []*net.IPNet) {
+	f.mu.Lock()
+	f.allow, f.deny = allow, deny
+	f.mu.Unlock()
+}
+
+// Allows reports whether ip may connect.
+func (f *IPFilter) Allows(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return IPAllowed(ip, f.allow, f.deny)
+}
+
+// IPAllowed reports whether ip gets past allow and deny, as an IPFilter
+// with those rules would decide.
+func IPAllowed(ip net.IP, allow, deny []*net.IPNet) bool {
+	for _, n := range deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(allow) == 0 {
+		return true
+	}
+	for _, n := range allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler refuses requests from addresses the filter doesn't allow with
+// 403.  It goes after TrustedProxies, so it sees clients rather than the
+// proxy.
+func (f *IPFilter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := RemoteIP(r); ip != nil && !f.Allows(ip) {
+			http.Error(w, `{"error":"forbidden"}`, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RemoteIP returns the client's address from r.RemoteAddr, or nil if it
+// isn't one.
+func RemoteIP(r *http.Request) net.IP {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return net.ParseIP(host)
+}
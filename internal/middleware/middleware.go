@@ -2,10 +2,15 @@ package middleware
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
+
+	chimw "github.com/go-chi/chi/v5/middleware"
 
 	"chirm/internal/auth"
+	"chirm/internal/logging"
 )
 
 type contextKey string
@@ -15,20 +20,18 @@ const UserClaimsKey contextKey = "user_claims"
 func Auth(svc *auth.Service) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			tokenStr := ""
-
-			// Try cookie first
-			if cookie, err := r.Cookie("chirm_token"); err == nil {
-				tokenStr = cookie.Value
-			}
-
-			// Try Authorization header
-			if tokenStr == "" {
-				if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
-					tokenStr = strings.TrimPrefix(auth, "Bearer ")
+			if bot := BotToken(r); bot != "" {
+				claims, err := svc.ValidateBotToken(bot)
+				if err != nil {
+					http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+					return
 				}
+				logging.SetUser(r.Context(), claims.UserID)
+				ctx := context.WithValue(r.Context(), UserClaimsKey, claims)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
 			}
-
+			tokenStr := Token(r)
 			if tokenStr == "" {
 				http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
 				return
@@ -39,14 +42,116 @@ func Auth(svc *auth.Service) func(http.Handler) http.Handler {
 				http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
 				return
 			}
+			// A session signed with a key that's since been replaced moves
+			// to the new one, so the old key can be retired without
+			// signing anyone out.  Bearer tokens are the client's to keep.
+			if claims.KeyID() != svc.SigningKeyID() {
+				if c, err := r.Cookie("chirm_token"); err == nil && c.Value == tokenStr {
+					if token, err := svc.GenerateToken(claims.UserID, claims.Username, claims.IsOwner); err == nil {
+						SetTokenCookie(w, r, token)
+					}
+				}
+			}
 
+			logging.SetUser(r.Context(), claims.UserID)
 			ctx := context.WithValue(r.Context(), UserClaimsKey, claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// Token returns the session token r carries: the chirm_token cookie, or
+// else an Authorization: Bearer header.
+func Token(r *http.Request) string {
+	if cookie, err := r.Cookie("chirm_token"); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// BotToken returns the bot token r carries in an Authorization: Bot
+// header, or "".
+func BotToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bot ") {
+		return strings.TrimPrefix(auth, "Bot ")
+	}
+	return ""
+}
+
+// SetTokenCookie gives the browser a session token.
+func SetTokenCookie(w http.ResponseWriter, r *http.Request, token string) {
+	// Only set Secure flag when actually served over HTTPS.  Hardcoding
+	// Secure: true caused Chrome to silently reject the cookie over plain
+	// HTTP, making login appear completely broken on :8080.
+	isSecure := r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+	http.SetCookie(w, &http.Cookie{
+		Name:     "chirm_token",
+		Value:    token,
+		Path:     "/",
+		MaxAge:   30 * 24 * 3600,
+		HttpOnly: true,
+		Secure:   isSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
 func GetClaims(r *http.Request) *auth.Claims {
 	claims, _ := r.Context().Value(UserClaimsKey).(*auth.Claims)
 	return claims
 }
+
+// RequestLog gives each request an ID, taken from an X-Request-ID header a
+// proxy in front set or else made up, and sends it back in the response.
+// Handlers' log lines carry it, and when the request is done a line is
+// logged for it: at error level for a 5xx response, and otherwise at info.
+func RequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := logging.WithRequestID(r.Context(), id)
+
+		ww := chimw.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			// A WebSocket's 101 is written on the hijacked connection.
+			status = http.StatusOK
+			if r.Header.Get("Upgrade") != "" {
+				status = http.StatusSwitchingProtocols
+			}
+		}
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		logging.FromContext(ctx).Log(ctx, level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(start).Round(time.Microsecond),
+			"remote", r.RemoteAddr,
+		)
+	})
+}
+
+// validRequestID reports whether id, from a client, is fit to log.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies returns middleware that sets r.RemoteAddr to the client's
+// address for requests that come through one of the trusted proxies, given
+// as IPs or CIDR networks.  The client is the last address in
+// X-Forwarded-For that isn't a trusted proxy, or X-Real-IP when there's no
+// X-Forwarded-For.  Headers from anyone else are ignored, so they can't be
+// used to dodge rate limits or bans.
+//
+// Whatever connects over a Unix socket (see UnixSocketContext) counts as a
+// trusted proxy too: only local processes allowed to open the socket can.
+func TrustedProxies(trusted []string) (func(http.Handler) http.Handler, error) {
+	var nets []*net.IPNet
+	for _, t := range trusted {
+		n, err := ParseNetwork(t)
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy: %w", err)
+		}
+		nets = append(nets, n)
+	}
+	isTrusted := func(ip net.IP) bool {
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := RemoteIP(r); (ip != nil && isTrusted(ip)) || r.Context().Value(unixSocketKey{}) != nil {
+				if client := forwardedFor(r, isTrusted); client != "" {
+					r.RemoteAddr = client
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+type unixSocketKey struct{}
+
+// UnixSocketContext is an http.Server ConnContext that marks requests
+// arriving over a Unix socket, which have no client address of their own.
+func UnixSocketContext(ctx context.Context, c net.Conn) context.Context {
+	if _, ok := c.LocalAddr().(*net.UnixAddr); ok {
+		return context.WithValue(ctx, unixSocketKey{}, true)
+	}
+	return ctx
+}
+
+// forwardedFor returns the client address a trusted proxy passed on, or ""
+// if there's none.  X-Forwarded-For is read from the right, past the
+// proxies' own entries, since anything further left came from the client.
+func forwardedFor(r *http.Request, isTrusted func(net.IP) bool) string {
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	if len(hops) == 0 {
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+		return ""
+	}
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break // garbage from the client; stop at the last good hop
+		}
+		client = ip.String()
+		if !isTrusted(ip) {
+			break
+		}
+	}
+	return client
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit is how often a client may make a kind of request: PerMinute on
+// average, with up to Burst at once.
+type RateLimit struct {
+	PerMinute int
+	Burst     int
+}
+
+// RateLimiter holds each client to a RateLimit.  A client is the signed-in
+// user when there is one, so people sharing an address don't share their
+// allowance, and the IP address otherwise.  Only the maxClients clients
+// seen most recently are remembered; one pushed out starts over with a full
+// allowance, which after that long it would mostly have had anyway.
+type RateLimiter struct {
+	limit      func() RateLimit
+	maxClients int
+
+	mu      sync.Mutex
+	clients map[string]*list.Element
+	recent  *list.List // of *rateClient, most recently seen first
+}
+
+type rateClient struct {
+	key string
+	lim *rate.Limiter
+}
+
+// NewRateLimiter returns a limiter that asks limit for the current limit on
+// every request, so a change takes effect straight away.
+func NewRateLimiter(maxClients int, limit func() RateLimit) *RateLimiter {
+	return &RateLimiter{
+		limit:      limit,
+		maxClients: maxClients,
+		clients:    make(map[string]*list.Element),
+		recent:     list.New(),
+	}
+}
+
+// Handler refuses requests over the limit with 429.  Every response carries
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers (the
+// IETF httpapi draft's) saying where the client stands, and a refusal adds
+// Retry-After.
+func (l *RateLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := "ip:" + r.RemoteAddr
+		if h, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			key = "ip:" + h
+		}
+		if claims := GetClaims(r); claims != nil {
+			key = "user:" + claims.UserID
+		}
+
+		limit := l.limit()
+		perSecond := float64(limit.PerMinute) / 60
+		now := time.Now()
+		lim := l.client(key, limit, now)
+		allowed := lim.AllowN(now, 1)
+		tokens := lim.TokensAt(now)
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(int(math.Max(0, tokens))))
+		h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil((float64(limit.Burst)-tokens)/perSecond))))
+		if !allowed {
+			h.Set("Retry-After", strconv.Itoa(int(math.Ceil((1-tokens)/perSecond))))
+			http.Error(w, `{"error":"too many requests"}`, http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// client returns key's limiter, set to limit, making it if need be.
+func (l *RateLimiter) client(key string, limit RateLimit, now time.Time) *rate.Limiter {
+	every := rate.Every(time.Minute / time.Duration(limit.PerMinute))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.clients[key]; ok {
+		l.recent.MoveToFront(e)
+		lim := e.Value.(*rateClient).lim
+		if lim.Limit() != every {
+			lim.SetLimitAt(now, every)
+		}
+		if lim.Burst() != limit.Burst {
+			lim.SetBurstAt(now, limit.Burst)
+		}
+		return lim
+	}
+	c := &rateClient{key: key, lim: rate.NewLimiter(every, limit.Burst)}
+	l.clients[key] = l.recent.PushFront(c)
+	for l.recent.Len() > l.maxClients {
+		oldest := l.recent.Back()
+		l.recent.Remove(oldest)
+		delete(l.clients, oldest.Value.(*rateClient).key)
+	}
+	return c.lim
+}
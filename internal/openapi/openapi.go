@@ -0,0 +1,341 @@
+// Package openapi builds an OpenAPI 3 description of Chirm's HTTP API from
+// the router's routes and the Go types handlers read and write, so the spec
+// can't list a route that doesn't exist or a field a struct doesn't have.
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Operation describes one route.  Request and Response are zero values of
+// the types the handler decodes and encodes; their JSON schemas come from
+// the types' json tags.
+type Operation struct {
+	Summary     string
+	Description string
+	Tag         string
+	Public      bool              // callable without signing in
+	Query       map[string]string // query parameter → description
+	Request     interface{}       // JSON body
+	Form        map[string]string // multipart/form-data field → description
+	Files       []string          // the Form fields that are files
+	Status      int               // success status; 200 if 0
+	Response    interface{}       // JSON response body
+	ContentType string            // response type when it isn't JSON
+}
+
+// Info is the spec's title, version and description, and the path the
+// routes are served under.
+type Info struct {
+	Title       string
+	Version     string
+	Description string
+	BasePath    string
+}
+
+// Build returns the spec, as JSON, for the routes in routes, with the
+// entries in ops (keyed "METHOD /path") filling in their details.  It also
+// returns the routes with no entry and the entries with no route, so docs
+// that fall behind the router can be noticed.
+func Build(routes chi.Routes, info Info, ops map[string]Operation) (spec []byte, undocumented, unknown []string, err error) {
+	g := &generator{schemas: map[string]interface{}{}, types: map[string]reflect.Type{}}
+	paths := map[string]map[string]interface{}{}
+	seen := map[string]bool{}
+
+	walkErr := chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		route = strings.TrimSuffix(route, "/")
+		key := method + " " + route
+		op, documented := ops[key]
+		if !documented {
+			undocumented = append(undocumented, key)
+		}
+		seen[key] = true
+		if paths[route] == nil {
+			paths[route] = map[string]interface{}{}
+		}
+		paths[route][strings.ToLower(method)] = g.operation(route, op)
+		return nil
+	})
+	if walkErr != nil {
+		return nil, nil, nil, walkErr
+	}
+	for key := range ops {
+		if !seen[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(undocumented)
+	sort.Strings(unknown)
+
+	g.schemas["Error"] = map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"error": map[string]interface{}{"type": "string"}},
+	}
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       info.Title,
+			"version":     info.Version,
+			"description": info.Description,
+		},
+		"servers": []interface{}{map[string]interface{}{"url": info.BasePath}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{
+					"type": "http", "scheme": "bearer", "bearerFormat": "JWT",
+					"description": "The token from /auth/login or /auth/register.",
+				},
+				"cookie": map[string]interface{}{
+					"type": "apiKey", "in": "cookie", "name": "chirm_token",
+					"description": "Set by /auth/login for the web app.",
+				},
+				"bot": map[string]interface{}{
+					"type": "apiKey", "in": "header", "name": "Authorization",
+					"description": "\"Bot \" and a bot's token, from POST /bots.",
+				},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"bearer": []string{}},
+			map[string]interface{}{"cookie": []string{}},
+			map[string]interface{}{"bot": []string{}},
+		},
+	}
+	spec, err = json.MarshalIndent(doc, "", "  ")
+	return spec, undocumented, unknown, err
+}
+
+type generator struct {
+	schemas map[string]interface{}  // component name → schema
+	types   map[string]reflect.Type // component name → the type it was made from
+}
+
+func (g *generator) operation(route string, op Operation) map[string]interface{} {
+	out := map[string]interface{}{}
+	if op.Summary != "" {
+		out["summary"] = op.Summary
+	}
+	if op.Description != "" {
+		out["description"] = op.Description
+	}
+	if op.Tag != "" {
+		out["tags"] = []string{op.Tag}
+	}
+	if op.Public {
+		out["security"] = []interface{}{}
+	}
+
+	var params []interface{}
+	for _, seg := range strings.Split(route, "/") {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			params = append(params, map[string]interface{}{
+				"name": seg[1 : len(seg)-1], "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	for _, name := range sortedKeys(op.Query) {
+		params = append(params, map[string]interface{}{
+			"name": name, "in": "query", "description": op.Query[name],
+			"schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	if len(params) > 0 {
+		out["parameters"] = params
+	}
+
+	switch {
+	case op.Request != nil:
+		out["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(op.Request))},
+			},
+		}
+	case len(op.Form) > 0:
+		props := map[string]interface{}{}
+		for name, desc := range op.Form {
+			p := map[string]interface{}{"type": "string", "description": desc}
+			for _, f := range op.Files {
+				if f == name {
+					p["format"] = "binary"
+				}
+			}
+			props[name] = p
+		}
+		out["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"multipart/form-data": map[string]interface{}{
+					"schema": map[string]interface{}{"type": "object", "properties": props},
+				},
+			},
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	switch {
+	case op.ContentType != "":
+		success["content"] = map[string]interface{}{
+			op.ContentType: map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}},
+		}
+	case op.Response != nil:
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(op.Response))},
+		}
+	}
+	out["responses"] = map[string]interface{}{
+		strconv.Itoa(status): success,
+		"default": map[string]interface{}{
+			"description": "Error",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": ref("Error")},
+			},
+		},
+	}
+	return out
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var rawType = reflect.TypeOf(json.RawMessage{})
+
+// schema returns the JSON schema for values of t as encoding/json writes
+// them.  Named structs become components, referred to by name.
+func (g *generator) schema(t reflect.Type) map[string]interface{} {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t, nullable = t.Elem(), true
+	}
+	s := g.plainSchema(t)
+	if nullable && s["$ref"] == nil {
+		s["nullable"] = true
+	}
+	return s
+}
+
+func (g *generator) plainSchema(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawType:
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := g.componentName(t)
+		if _, done := g.schemas[name]; !done {
+			g.schemas[name] = nil // placeholder, so a type that contains itself ends
+			g.schemas[name] = g.object(t)
+		}
+		return ref(name)
+	}
+	return map[string]interface{}{} // interface{} and the like: anything
+}
+
+// componentName names t's component after the type, adding its package's
+// name if another package's type already has the plain name.
+func (g *generator) componentName(t reflect.Type) string {
+	name := upperFirst(t.Name())
+	if other, taken := g.types[name]; taken && other != t {
+		pkg := t.PkgPath()
+		name = upperFirst(pkg[strings.LastIndex(pkg, "/")+1:]) + name
+	}
+	g.types[name] = t
+	return name
+}
+
+func (g *generator) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	g.fields(t, props)
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// fields adds t's JSON fields to props, including those of embedded structs.
+func (g *generator) fields(t reflect.Type, props map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.fields(ft, props)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s := g.schema(f.Type)
+		if strings.Contains(opts, "string") {
+			s = map[string]interface{}{"type": "string"}
+		}
+		props[name] = s
+	}
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
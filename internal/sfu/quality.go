@@ -0,0 +1,78 @@
+package sfu
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// ─── Per-room bitrate caps ───────────────────────────────────────────────────
+//
+// The SFU forwards packets untouched, so it can't re-encode a stream that is
+// too large.  Instead it advertises the room's caps in every description it
+// sends: b=AS on video sections and maxaveragebitrate on Opus, which browsers
+// honour when choosing how much to send to us.
+
+// limit rewrites sdp to carry the room's caps (in kbit/s; 0 = no cap).
+func (s *Server) limit(channelID string, sdp webrtc.SessionDescription) webrtc.SessionDescription {
+	if s.quality == nil {
+		return sdp
+	}
+	audio, video := s.quality(channelID)
+	if audio <= 0 && video <= 0 {
+		return sdp
+	}
+	sdp.SDP = limitSDP(sdp.SDP, audio, video)
+	return sdp
+}
+
+func limitSDP(sdp string, audioKbps, videoKbps int) string {
+	lines := strings.Split(sdp, "\r\n")
+	out := make([]string, 0, len(lines)+4)
+	opus := map[string]bool{}
+	for _, l := range lines {
+		if rest, ok := strings.CutPrefix(l, "a=rtpmap:"); ok {
+			pt, codec, _ := strings.Cut(rest, " ")
+			if strings.HasPrefix(strings.ToLower(codec), "opus/") {
+				opus[pt] = true
+			}
+		}
+	}
+
+	section := ""
+	for _, l := range lines {
+		switch {
+		case strings.HasPrefix(l, "m="):
+			section = strings.Fields(l[2:])[0]
+		case strings.HasPrefix(l, "b=AS:") && section == "video" && videoKbps > 0:
+			continue // replaced below
+		case strings.HasPrefix(l, "a=fmtp:") && audioKbps > 0:
+			pt, params, _ := strings.Cut(strings.TrimPrefix(l, "a=fmtp:"), " ")
+			if opus[pt] {
+				l = "a=fmtp:" + pt + " " + setParam(params, "maxaveragebitrate", audioKbps*1000)
+			}
+		}
+		out = append(out, l)
+		// b= lines belong right after the section's c= line.
+		if strings.HasPrefix(l, "c=") && section == "video" && videoKbps > 0 {
+			out = append(out, fmt.Sprintf("b=AS:%d", videoKbps))
+		}
+	}
+	return strings.Join(out, "\r\n")
+}
+
+func setParam(params, key string, value int) string {
+	kv := fmt.Sprintf("%s=%d", key, value)
+	parts := strings.Split(params, ";")
+	for i, p := range parts {
+		if k, _, _ := strings.Cut(p, "="); strings.TrimSpace(k) == key {
+			parts[i] = kv
+			return strings.Join(parts, ";")
+		}
+	}
+	if params == "" {
+		return kv
+	}
+	return params + ";" + kv
+}
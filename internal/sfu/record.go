@@ -0,0 +1,116 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+)
+
+// ─── Recording ───────────────────────────────────────────────────────────────
+//
+// A room can record every published audio track to its own Ogg/Opus file.
+// Packets are written exactly as received, so recording costs no decoding;
+// mixing the per-speaker files into one is left to the listener's tools.
+// Audio from muted users is not recorded, just as it is not forwarded.
+
+type recording struct {
+	dir string
+
+	mu      sync.Mutex
+	writers map[string]*oggwriter.OggWriter // by track ID
+	files   []string
+}
+
+var errRecording = errors.New("sfu: room is already being recorded")
+
+// StartRecording records the audio of channelID into dir until
+// StopRecording is called.
+func (s *Server) StartRecording(channelID, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	r := s.room(channelID, true)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rec != nil {
+		return errRecording
+	}
+	r.rec = &recording{dir: dir, writers: make(map[string]*oggwriter.OggWriter)}
+	return nil
+}
+
+// StopRecording finishes the recording of channelID and returns the names of
+// the files written (relative to the recording's directory).
+func (s *Server) StopRecording(channelID string) []string {
+	r := s.room(channelID, false)
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	rec := r.rec
+	r.rec = nil
+	r.mu.Unlock()
+	s.dropRoomIfEmpty(r)
+	if rec == nil {
+		return nil
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for id, w := range rec.writers {
+		w.Close()
+		delete(rec.writers, id)
+	}
+	return rec.files
+}
+
+// record appends an audio packet from userID's track to the room recording,
+// opening the track's file on its first packet.
+func (r *room) record(trackID, userID string, pkt *rtp.Packet) {
+	r.mu.Lock()
+	rec := r.rec
+	r.mu.Unlock()
+	if rec == nil {
+		return
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	w := rec.writers[trackID]
+	if w == nil {
+		name := fmt.Sprintf("%s-%d.ogg", userID, len(rec.files)+1)
+		var err error
+		w, err = oggwriter.New(filepath.Join(rec.dir, name), 48000, 2)
+		if err != nil {
+			slog.Error("sfu: recording", "file", name, "err", err)
+			return
+		}
+		rec.writers[trackID] = w
+		rec.files = append(rec.files, name)
+	}
+	if err := w.WriteRTP(pkt); err != nil {
+		slog.Error("sfu: recording write", "err", err)
+	}
+}
+
+// endTrackRecording closes the file of a track that has stopped.
+func (r *room) endTrackRecording(trackID string) {
+	r.mu.Lock()
+	rec := r.rec
+	r.mu.Unlock()
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	if w := rec.writers[trackID]; w != nil {
+		w.Close()
+		delete(rec.writers, trackID)
+	}
+	rec.mu.Unlock()
+}
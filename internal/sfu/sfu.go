@@ -0,0 +1,484 @@
+// Package sfu implements Chirm's optional selective forwarding unit.
+//
+// In the default mesh topology every participant sends their media to every
+// other participant, which costs each client N-1 uplinks.  With the SFU
+// enabled each client keeps a single peer connection to the server; the server
+// receives every published track once and forwards the RTP packets to all
+// other members of the room without decoding them.
+//
+// Signaling still travels over the existing WebSocket: the hub hands client
+// offers/answers/candidates to Server and Server calls the Signal callback
+// when it needs to send something back.
+package sfu
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Signal delivers a server → client signaling message (voice.sfu.offer,
+// voice.sfu.answer or voice.sfu.ice) to a user.
+type Signal func(userID, eventType string, data map[string]interface{})
+
+// Config controls how the SFU binds and advertises its media ports.
+type Config struct {
+	// UDPPortMin/UDPPortMax restrict the ephemeral ports used for media so
+	// they can be opened in a firewall.  Zero means any port.
+	UDPPortMin uint16
+	UDPPortMax uint16
+	// PublicIPs are advertised as host candidates instead of the interface
+	// addresses (for servers behind 1:1 NAT, e.g. a cloud VM).
+	PublicIPs []string
+	// ICEServers are handed to the server-side peer connections.
+	ICEServers []webrtc.ICEServer
+	// AllowTrack, if set, decides whether a track a user publishes ("audio"
+	// or "video") is forwarded to the rest of the room.
+	AllowTrack func(channelID, userID, kind string) bool
+	// Muted and Deafened, if set, report moderator-imposed state: a muted
+	// user's audio is not forwarded and a deafened user receives no audio.
+	// Call Resync after Deafened changes.
+	Muted    func(channelID, userID string) bool
+	Deafened func(channelID, userID string) bool
+	// Quality, if set, returns a room's audio and video bitrate caps in
+	// kbit/s (0 = uncapped); see quality.go.
+	Quality func(channelID string) (audioKbps, videoKbps int)
+}
+
+// Server owns every SFU room on this instance.
+type Server struct {
+	api     *webrtc.API
+	config  webrtc.Configuration
+	signal  Signal
+	allow   func(channelID, userID, kind string) bool
+	muted   func(channelID, userID string) bool
+	deaf    func(channelID, userID string) bool
+	quality func(channelID string) (audioKbps, videoKbps int)
+
+	mu    sync.Mutex
+	rooms map[string]*room
+}
+
+var errNotInRoom = errors.New("sfu: no session for user")
+
+// New creates an SFU.  signal is used for all server-initiated messages.
+func New(cfg Config, signal Signal) (*Server, error) {
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		return nil, err
+	}
+	ir := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, ir); err != nil {
+		return nil, err
+	}
+
+	se := webrtc.SettingEngine{}
+	if cfg.UDPPortMin > 0 && cfg.UDPPortMax >= cfg.UDPPortMin {
+		if err := se.SetEphemeralUDPPortRange(cfg.UDPPortMin, cfg.UDPPortMax); err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.PublicIPs) > 0 {
+		se.SetNAT1To1IPs(cfg.PublicIPs, webrtc.ICECandidateTypeHost)
+	}
+
+	s := &Server{
+		api: webrtc.NewAPI(
+			webrtc.WithMediaEngine(m),
+			webrtc.WithInterceptorRegistry(ir),
+			webrtc.WithSettingEngine(se),
+		),
+		config:  webrtc.Configuration{ICEServers: cfg.ICEServers},
+		signal:  signal,
+		allow:   cfg.AllowTrack,
+		muted:   cfg.Muted,
+		deaf:    cfg.Deafened,
+		quality: cfg.Quality,
+		rooms:   make(map[string]*room),
+	}
+	go s.keyframeLoop()
+	return s, nil
+}
+
+// ─── Rooms ────────────────────────────────────────────────────────────────────
+
+type room struct {
+	id     string
+	server *Server
+
+	mu     sync.Mutex
+	peers  map[string]*peer
+	tracks map[string]*forwardedTrack // by track ID
+	rec    *recording                 // nil unless the room is being recorded
+}
+
+type peer struct {
+	userID string
+	pc     *webrtc.PeerConnection
+	// dirty is set when the track set changed while an offer to this peer
+	// was still outstanding; the sync is retried once the answer arrives.
+	dirty bool
+}
+
+type forwardedTrack struct {
+	owner string
+	kind  webrtc.RTPCodecType
+	local *webrtc.TrackLocalStaticRTP
+}
+
+func (s *Server) room(channelID string, create bool) *room {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.rooms[channelID]
+	if r == nil && create {
+		r = &room{
+			id:     channelID,
+			server: s,
+			peers:  make(map[string]*peer),
+			tracks: make(map[string]*forwardedTrack),
+		}
+		s.rooms[channelID] = r
+	}
+	return r
+}
+
+func (s *Server) dropRoomIfEmpty(r *room) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.mu.Lock()
+	empty := len(r.peers) == 0 && r.rec == nil
+	r.mu.Unlock()
+	if empty && s.rooms[r.id] == r {
+		delete(s.rooms, r.id)
+	}
+}
+
+// ─── Signaling entry points ──────────────────────────────────────────────────
+
+// HandleOffer processes an offer from a client.  The first offer creates the
+// user's server-side peer connection; later offers renegotiate it (e.g. when
+// the client starts screen sharing).
+func (s *Server) HandleOffer(channelID, userID string, sdp webrtc.SessionDescription) error {
+	r := s.room(channelID, true)
+	r.mu.Lock()
+	p := r.peers[userID]
+	if p == nil {
+		pc, err := s.newPeerConnection(r, userID)
+		if err != nil {
+			r.mu.Unlock()
+			return err
+		}
+		p = &peer{userID: userID, pc: pc}
+		r.peers[userID] = p
+	}
+
+	// The server is the "impolite" side of perfect negotiation: if it has
+	// an offer of its own outstanding it ignores the client's, and the
+	// client rolls back and answers ours instead.
+	if p.pc.SignalingState() != webrtc.SignalingStateStable {
+		r.mu.Unlock()
+		return nil
+	}
+	if err := p.pc.SetRemoteDescription(sdp); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	answer, err := p.pc.CreateAnswer(nil)
+	if err == nil {
+		err = p.pc.SetLocalDescription(s.limit(channelID, answer))
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.signal(userID, "voice.sfu.answer", map[string]interface{}{
+		"channel_id": channelID,
+		"payload":    p.pc.LocalDescription(),
+	})
+	// Make sure the newcomer receives everyone else's tracks.
+	r.sync()
+	return nil
+}
+
+// HandleAnswer applies a client's answer to a server-initiated offer.
+func (s *Server) HandleAnswer(channelID, userID string, sdp webrtc.SessionDescription) error {
+	r := s.room(channelID, false)
+	if r == nil {
+		return errNotInRoom
+	}
+	r.mu.Lock()
+	p := r.peers[userID]
+	if p == nil {
+		r.mu.Unlock()
+		return errNotInRoom
+	}
+	err := p.pc.SetRemoteDescription(sdp)
+	retry := p.dirty
+	p.dirty = false
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if retry {
+		r.sync()
+	}
+	return nil
+}
+
+// HandleCandidate adds a trickled ICE candidate from a client.
+func (s *Server) HandleCandidate(channelID, userID string, raw json.RawMessage) error {
+	r := s.room(channelID, false)
+	if r == nil {
+		return errNotInRoom
+	}
+	var c webrtc.ICECandidateInit
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	p := r.peers[userID]
+	r.mu.Unlock()
+	if p == nil {
+		return errNotInRoom
+	}
+	return p.pc.AddICECandidate(c)
+}
+
+// Leave tears down a user's session in a room and stops forwarding their tracks.
+func (s *Server) Leave(channelID, userID string) {
+	r := s.room(channelID, false)
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	p := r.peers[userID]
+	delete(r.peers, userID)
+	for id, t := range r.tracks {
+		if t.owner == userID {
+			delete(r.tracks, id)
+		}
+	}
+	r.mu.Unlock()
+	if p != nil {
+		p.pc.Close()
+	}
+	r.sync()
+	s.dropRoomIfEmpty(r)
+}
+
+// ─── Peer connections ────────────────────────────────────────────────────────
+
+func (s *Server) newPeerConnection(r *room, userID string) (*webrtc.PeerConnection, error) {
+	pc, err := s.api.NewPeerConnection(s.config)
+	if err != nil {
+		return nil, err
+	}
+
+	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c == nil {
+			return
+		}
+		s.signal(userID, "voice.sfu.ice", map[string]interface{}{
+			"channel_id": r.id,
+			"payload":    c.ToJSON(),
+		})
+	})
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateFailed:
+			pc.Close()
+		case webrtc.PeerConnectionStateClosed:
+			r.mu.Lock()
+			if p := r.peers[userID]; p != nil && p.pc == pc {
+				delete(r.peers, userID)
+				for id, t := range r.tracks {
+					if t.owner == userID {
+						delete(r.tracks, id)
+					}
+				}
+			}
+			r.mu.Unlock()
+			r.sync()
+		}
+	})
+
+	pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		if s.allow != nil && !s.allow(r.id, userID, remote.Kind().String()) {
+			slog.Warn("sfu: dropping track (not permitted)", "kind", remote.Kind(), "user_id", userID, "channel_id", r.id)
+			return
+		}
+		// The stream ID carries the owner so clients can attribute the
+		// track; the original stream ID is kept so camera and screen
+		// share (separate MediaStreams on the sender) stay distinguishable.
+		local, err := webrtc.NewTrackLocalStaticRTP(
+			remote.Codec().RTPCodecCapability,
+			remote.ID(),
+			userID+":"+remote.StreamID(),
+		)
+		if err != nil {
+			slog.Error("sfu: new local track", "err", err)
+			return
+		}
+
+		r.mu.Lock()
+		r.tracks[remote.ID()] = &forwardedTrack{owner: userID, kind: remote.Kind(), local: local}
+		r.mu.Unlock()
+		r.sync()
+
+		defer func() {
+			r.mu.Lock()
+			delete(r.tracks, remote.ID())
+			r.mu.Unlock()
+			r.endTrackRecording(remote.ID())
+			r.sync()
+		}()
+
+		isAudio := remote.Kind() == webrtc.RTPCodecTypeAudio
+		for {
+			pkt, _, err := remote.ReadRTP()
+			if err != nil {
+				return
+			}
+			if isAudio && s.muted != nil && s.muted(r.id, userID) {
+				continue
+			}
+			if isAudio {
+				r.record(remote.ID(), userID, pkt)
+			}
+			// io.ErrClosedPipe only means a subscriber went away mid-write;
+			// keep forwarding to the others.
+			if err := local.WriteRTP(pkt); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+				return
+			}
+		}
+	})
+
+	return pc, nil
+}
+
+// sync brings every peer's senders in line with the room's track set and
+// sends a fresh offer to each peer whose senders changed.
+func (r *room) sync() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, p := range r.peers {
+		if p.pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+			continue
+		}
+		if p.pc.SignalingState() != webrtc.SignalingStateStable {
+			p.dirty = true
+			continue
+		}
+
+		deafened := r.server.deaf != nil && r.server.deaf(r.id, p.userID)
+		wanted := func(t *forwardedTrack) bool {
+			return t != nil && !(deafened && t.kind == webrtc.RTPCodecTypeAudio)
+		}
+
+		changed := false
+		have := make(map[string]bool)
+		for _, sender := range p.pc.GetSenders() {
+			t := sender.Track()
+			if t == nil {
+				continue
+			}
+			have[t.ID()] = true
+			if !wanted(r.tracks[t.ID()]) {
+				if err := p.pc.RemoveTrack(sender); err == nil {
+					changed = true
+				}
+			}
+		}
+		// Never echo a user's own tracks back to them.
+		for _, recv := range p.pc.GetReceivers() {
+			if t := recv.Track(); t != nil {
+				have[t.ID()] = true
+			}
+		}
+		for id, t := range r.tracks {
+			if have[id] || t.owner == p.userID || !wanted(t) {
+				continue
+			}
+			if _, err := p.pc.AddTrack(t.local); err == nil {
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+
+		offer, err := p.pc.CreateOffer(nil)
+		if err != nil {
+			continue
+		}
+		if err := p.pc.SetLocalDescription(r.server.limit(r.id, offer)); err != nil {
+			continue
+		}
+		r.server.signal(p.userID, "voice.sfu.offer", map[string]interface{}{
+			"channel_id": r.id,
+			"payload":    p.pc.LocalDescription(),
+		})
+	}
+}
+
+// Resync re-evaluates which tracks every peer in channelID should receive.
+func (s *Server) Resync(channelID string) {
+	if r := s.room(channelID, false); r != nil {
+		r.sync()
+	}
+}
+
+// keyframeLoop periodically asks every publisher for a keyframe so that
+// subscribers who joined mid-stream get a decodable picture quickly.
+func (s *Server) keyframeLoop() {
+	ticker := time.NewTicker(3 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.mu.Lock()
+		rooms := make([]*room, 0, len(s.rooms))
+		for _, r := range s.rooms {
+			rooms = append(rooms, r)
+		}
+		s.mu.Unlock()
+
+		for _, r := range rooms {
+			r.mu.Lock()
+			for _, p := range r.peers {
+				for _, recv := range p.pc.GetReceivers() {
+					t := recv.Track()
+					if t == nil || t.Kind() != webrtc.RTPCodecTypeVideo {
+						continue
+					}
+					p.pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(t.SSRC())}})
+				}
+			}
+			r.mu.Unlock()
+		}
+	}
+}
+
+// ParsePortRange parses "min-max" (e.g. "50000-50200").
+func ParsePortRange(s string) (uint16, uint16, bool) {
+	lo, hi, found := strings.Cut(s, "-")
+	if !found {
+		return 0, 0, false
+	}
+	min, err1 := strconv.ParseUint(strings.TrimSpace(lo), 10, 16)
+	max, err2 := strconv.ParseUint(strings.TrimSpace(hi), 10, 16)
+	if err1 != nil || err2 != nil || min == 0 || min > max {
+		return 0, 0, false
+	}
+	return uint16(min), uint16(max), true
+}
@@ -0,0 +1,427 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ─── S3-compatible bucket ────────────────────────────────────────────────────
+//
+// Requests are signed with AWS Signature Version 4.  Uploads are streamed
+// with an unsigned payload, which S3 and MinIO accept over HTTPS.
+
+// S3Config describes the bucket.
+type S3Config struct {
+	Endpoint  string // e.g. "https://s3.eu-west-1.amazonaws.com" or "http://minio:9000"
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string // prepended to every object key, e.g. "chirm/"
+
+	// VirtualHosted addresses the bucket as <bucket>.<endpoint host>
+	// instead of <endpoint>/<bucket>.  MinIO usually needs path style.
+	VirtualHosted bool
+
+	// Presign makes Serve redirect clients to a short-lived signed URL so
+	// downloads go straight to the bucket, rather than through this server.
+	Presign bool
+
+	// PutTimeout bounds an upload to the bucket; 0 means putTimeout.
+	PutTimeout time.Duration
+}
+
+// presignTTL is how long redirect URLs stay valid.
+const presignTTL = time.Hour
+
+// putTimeout bounds an upload to the bucket.  Downloads have no overall
+// limit, since a player may stream a long recording for as long as it
+// plays.
+const putTimeout = 5 * time.Minute
+
+// S3 stores files in a bucket.
+type S3 struct {
+	cfg    S3Config
+	base   *url.URL // the bucket's URL, without a trailing slash
+	client *http.Client
+}
+
+// NewS3 returns a Store for the bucket described by cfg.
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("S3 needs a bucket, access key and secret key")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	if cfg.PutTimeout <= 0 {
+		cfg.PutTimeout = putTimeout
+	}
+	base, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.VirtualHosted {
+		base.Host = cfg.Bucket + "." + base.Host
+	} else {
+		base.Path += "/" + cfg.Bucket
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = time.Minute
+	return &S3{cfg: cfg, base: base, client: &http.Client{Transport: transport}}, nil
+}
+
+// Describe names the bucket for logs.
+func (s *S3) Describe() string {
+	return s.base.String() + "/" + s.cfg.Prefix
+}
+
+func (s *S3) objectURL(name string) *url.URL {
+	u := *s.base
+	key := s.cfg.Prefix + name
+	u.Path = s.base.Path + "/" + key
+	u.RawPath = s.base.EscapedPath() + "/" + awsEscape(key, false)
+	return &u
+}
+
+func (s *S3) do(ctx context.Context, method, name string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	if !validName(name) {
+		return nil, fmt.Errorf("invalid file name")
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(name).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req, time.Now())
+	return s.client.Do(req)
+}
+
+func (s *S3) Put(name string, r io.Reader, size int64, contentType string) error {
+	h := http.Header{}
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.PutTimeout)
+	defer cancel()
+	resp, err := s.do(ctx, "PUT", name, r, size, h)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (s *S3) Open(name string) (io.ReadCloser, error) {
+	resp, err := s.do(context.Background(), "GET", name, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotExist
+	}
+	defer resp.Body.Close()
+	return nil, s3Error(resp)
+}
+
+func (s *S3) Exists(name string) bool {
+	resp, err := s.do(context.Background(), "HEAD", name, nil, 0, nil)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+func (s *S3) Delete(name string) error {
+	resp, err := s.do(context.Background(), "DELETE", name, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// List returns the names of the files under the prefix, for callers that
+// keep more than uploads in a bucket.  Keys in "subdirectories" of the
+// prefix are left out.
+func (s *S3) List() ([]string, error) {
+	var names []string
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u := *s.base
+		u.Path, u.RawPath = s.base.Path+"/", s.base.EscapedPath()+"/"
+		u.RawQuery = canonicalQuery(q)
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		s.sign(req, time.Now())
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error(resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range page.Contents {
+			name := strings.TrimPrefix(c.Key, s.cfg.Prefix)
+			if validName(name) {
+				names = append(names, name)
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return names, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// proxiedHeaders are passed between the client and the bucket when Serve
+// proxies a download.
+var (
+	proxiedRequestHeaders  = []string{"Range", "If-None-Match", "If-Modified-Since", "If-Range"}
+	proxiedResponseHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"}
+)
+
+func (s *S3) Serve(w http.ResponseWriter, r *http.Request, name string) {
+	if s.cfg.Presign {
+		if !validName(name) {
+			http.NotFound(w, r)
+			return
+		}
+		// The redirect target can't carry our headers, so ask S3 to send
+		// the same Content-Disposition and Content-Type back itself.
+		extra := url.Values{}
+		if cd := w.Header().Get("Content-Disposition"); cd != "" {
+			extra.Set("response-content-disposition", cd)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "" {
+			extra.Set("response-content-type", ct)
+		}
+		http.Redirect(w, r, s.presign("GET", name, extra, time.Now()), http.StatusFound)
+		return
+	}
+
+	h := http.Header{}
+	for _, k := range proxiedRequestHeaders {
+		if v := r.Header.Get(k); v != "" {
+			h.Set(k, v)
+		}
+	}
+	method := "GET"
+	if r.Method == http.MethodHead {
+		method = "HEAD"
+	}
+	// Players drop a request as soon as they seek elsewhere; stop reading
+	// from the bucket when they do.
+	resp, err := s.do(r.Context(), method, name, nil, 0, h)
+	if err != nil {
+		http.Error(w, "storage unavailable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusNotModified, http.StatusRequestedRangeNotSatisfiable:
+	case http.StatusNotFound:
+		http.NotFound(w, r)
+		return
+	default:
+		http.Error(w, "storage unavailable", http.StatusBadGateway)
+		return
+	}
+	for _, k := range proxiedResponseHeaders {
+		if v := resp.Header.Get(k); v != "" && w.Header().Get(k) == "" {
+			w.Header().Set(k, v)
+		}
+	}
+	// S3 serves ranges of any object, but not every compatible server says
+	// so; without it some players won't seek.
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// s3Error turns an unexpected response into an error, including S3's code.
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	var code string
+	if i := strings.Index(string(body), "<Code>"); i >= 0 {
+		code = string(body[i+6:])
+		if j := strings.Index(code, "<"); j >= 0 {
+			code = code[:j]
+		}
+	}
+	return fmt.Errorf("s3 %s: %d %s", resp.Request.Method, resp.StatusCode, code)
+}
+
+// ─── Signature Version 4 ─────────────────────────────────────────────────────
+
+const (
+	sigAlgorithm    = "AWS4-HMAC-SHA256"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	amzDateFormat   = "20060102T150405Z"
+)
+
+// sign adds SigV4 headers to req.  The payload is left unsigned.
+func (s *S3) sign(req *http.Request, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	names := []string{"host"}
+	values := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "content-type" || strings.HasPrefix(lk, "x-amz-") || lk == "range" {
+			names = append(names, lk)
+			values[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, n := range names {
+		canonHeaders.WriteString(n + ":" + values[n] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	scope, sig := s.signature(req.Method, req.URL, canonHeaders.String(), signed, unsignedPayload, now)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigAlgorithm, s.cfg.AccessKey, scope, signed, sig))
+}
+
+// presign returns a URL that performs method on name without credentials
+// until presignTTL has passed.
+func (s *S3) presign(method, name string, extra url.Values, now time.Time) string {
+	u := s.objectURL(name)
+	amzDate := now.UTC().Format(amzDateFormat)
+	q := url.Values{}
+	for k, v := range extra {
+		q[k] = v
+	}
+	q.Set("X-Amz-Algorithm", sigAlgorithm)
+	q.Set("X-Amz-Credential", s.cfg.AccessKey+"/"+s.scope(now))
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", fmt.Sprint(int(presignTTL.Seconds())))
+	q.Set("X-Amz-SignedHeaders", "host")
+	u.RawQuery = canonicalQuery(q)
+
+	_, sig := s.signature(method, u, "host:"+u.Host+"\n", "host", unsignedPayload, now)
+	u.RawQuery += "&X-Amz-Signature=" + sig
+	return u.String()
+}
+
+func (s *S3) scope(now time.Time) string {
+	return now.UTC().Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+// signature computes the SigV4 signature of a request, returning its
+// credential scope too.
+func (s *S3) signature(method string, u *url.URL, canonHeaders, signedHeaders, payloadHash string, now time.Time) (string, string) {
+	canonical := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		canonicalQuery(u.Query()),
+		canonHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := s.scope(now)
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := strings.Join([]string{sigAlgorithm, now.UTC().Format(amzDateFormat), scope, hex.EncodeToString(sum[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), now.UTC().Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return scope, hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// canonicalQuery sorts and encodes q the way SigV4 expects.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes everything but unreserved characters, and '/'
+// too unless it's in a path.
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
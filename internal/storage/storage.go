@@ -0,0 +1,112 @@
+// Package storage keeps Chirm's uploaded files, either in a local directory
+// or in an S3-compatible bucket (AWS S3, MinIO, R2, …).
+//
+// Files are addressed by a flat name such as "0597d0179bf46046.jpg"; the
+// handlers choose names and never pass paths.
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotExist is returned by Open for names that aren't stored.
+var ErrNotExist = os.ErrNotExist
+
+// Store holds uploaded files.
+type Store interface {
+	// Put stores size bytes from r under name, replacing any existing file.
+	Put(name string, r io.Reader, size int64, contentType string) error
+	// Open returns the file's contents.
+	Open(name string) (io.ReadCloser, error)
+	// Exists reports whether name is stored.
+	Exists(name string) bool
+	// Delete removes name; deleting a missing file is not an error.
+	Delete(name string) error
+	// Serve answers a download request for name, supporting ranges and
+	// conditional requests.  Headers already set on w are kept.
+	Serve(w http.ResponseWriter, r *http.Request, name string)
+}
+
+// validName rejects names that could escape the store's namespace.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, `/\`) && filepath.Base(name) == name
+}
+
+// ─── Local directory ─────────────────────────────────────────────────────────
+
+// Local stores files in a directory on this server's disk.
+type Local struct {
+	dir string
+}
+
+// NewLocal returns a Store in dir, which must exist.
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+func (l *Local) path(name string) (string, error) {
+	if !validName(name) {
+		return "", errors.New("invalid file name")
+	}
+	return filepath.Join(l.dir, name), nil
+}
+
+func (l *Local) Put(name string, r io.Reader, size int64, contentType string) error {
+	p, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(p)
+		return err
+	}
+	return f.Close()
+}
+
+func (l *Local) Open(name string) (io.ReadCloser, error) {
+	p, err := l.path(name)
+	if err != nil {
+		return nil, ErrNotExist
+	}
+	return os.Open(p)
+}
+
+func (l *Local) Exists(name string) bool {
+	p, err := l.path(name)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+func (l *Local) Delete(name string) error {
+	p, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) Serve(w http.ResponseWriter, r *http.Request, name string) {
+	p, err := l.path(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, p)
+}
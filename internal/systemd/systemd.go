@@ -0,0 +1,120 @@
+// Package systemd lets Chirm run as a systemd service that's started by
+// its sockets (sd_listen_fds) and tells systemd how it's doing (sd_notify),
+// speaking both protocols directly rather than through libsystemd.  Outside
+// systemd, none of the variables these read are set and everything here
+// does nothing.
+package systemd
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor systemd passes (SD_LISTEN_FDS_START).
+const listenFDsStart = 3
+
+// Listener is a socket systemd passed in.
+type Listener struct {
+	net.Listener
+	Name string // FileDescriptorName= in the .socket unit; the unit's name by default
+}
+
+// Listeners returns the sockets systemd passed to this process, in the
+// order the .socket units list them, or nil when the process wasn't
+// socket-activated.  The variables are cleared, so processes Chirm starts
+// don't think the sockets are theirs.
+func Listeners() ([]Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	var listeners []Listener
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		ln, err := net.FileListener(f)
+		f.Close() // the listener has its own copy, not inherited by children
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, Listener{Listener: ln, Name: name})
+	}
+	return listeners, nil
+}
+
+// Notify sends state, such as "READY=1" or "STATUS=…", to systemd when it
+// asked for notifications (Type=notify) and reports whether it was sent.
+func Notify(state string) bool {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false
+	}
+	addr := &net.UnixAddr{Name: socket, Net: "unixgram"}
+	if strings.HasPrefix(socket, "@") {
+		addr.Name = "\x00" + socket[1:] // abstract socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		slog.Debug("systemd: notify failed", "err", err)
+		return false
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Debug("systemd: notify failed", "err", err)
+		return false
+	}
+	return true
+}
+
+// Watchdog pets systemd's watchdog (WatchdogSec=) at half the interval
+// systemd expects, whenever healthy says the process is well.  If it stays
+// unwell for the whole interval, systemd restarts Chirm.  Without a
+// watchdog it does nothing.
+func Watchdog(healthy func() error) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	interval := time.Duration(usec) * time.Microsecond / 2
+	slog.Info("systemd: watchdog on", "interval", interval)
+	go func() {
+		wasHealthy := true
+		for range time.Tick(interval) {
+			err := healthy()
+			if err != nil {
+				if wasHealthy {
+					slog.Error("systemd: unhealthy, holding back the watchdog", "err", err)
+					Notify("STATUS=Unhealthy: " + err.Error())
+				}
+				wasHealthy = false
+				continue
+			}
+			if !wasHealthy {
+				slog.Info("systemd: healthy again")
+				Notify("STATUS=Running")
+			}
+			wasHealthy = true
+			Notify("WATCHDOG=1")
+		}
+	}()
+}
@@ -0,0 +1,94 @@
+// Package tailnet runs Chirm as a machine of its own on a Tailscale network,
+// using tsnet, so it needs neither the tailscale daemon on the host nor any
+// ports opened to the LAN.  The tailnet gives it a stable MagicDNS name and,
+// with HTTPS enabled in the tailnet's admin console, a certificate browsers
+// already trust, which voice and video need.
+package tailnet
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"tailscale.com/tsnet"
+)
+
+// Config describes the node Chirm joins the tailnet as.
+type Config struct {
+	Hostname   string // machine name on the tailnet, e.g. "chirm"
+	Dir        string // where the node's keys and state are kept between runs
+	AuthKey    string // only needed until the node has logged in once
+	ControlURL string // coordination server; "" for Tailscale's, or a Headscale URL
+
+	MaxHeaderBytes int // cap on request headers; 0 for net/http's default
+}
+
+// Node is Chirm's machine on the tailnet.
+type Node struct {
+	srv       *tsnet.Server
+	name      string // MagicDNS name, e.g. "chirm.example.ts.net"
+	maxHeader int
+}
+
+// Start joins the tailnet and waits until the node is up.
+func Start(ctx context.Context, cfg Config) (*Node, error) {
+	srv := &tsnet.Server{
+		Hostname:   cfg.Hostname,
+		Dir:        cfg.Dir,
+		AuthKey:    cfg.AuthKey,
+		ControlURL: cfg.ControlURL,
+		// tsnet's own logging is a firehose; keep just what's meant for
+		// people, such as a login URL when the auth key has run out.
+		Logf: func(string, ...any) {},
+		UserLogf: func(format string, args ...any) {
+			slog.Info("tailnet: " + fmt.Sprintf(format, args...))
+		},
+	}
+	st, err := srv.Up(ctx)
+	if err != nil {
+		srv.Close()
+		return nil, err
+	}
+	name := cfg.Hostname
+	if st.Self != nil && st.Self.DNSName != "" {
+		name = strings.TrimSuffix(st.Self.DNSName, ".")
+	}
+	return &Node{srv: srv, name: name, maxHeader: cfg.MaxHeaderBytes}, nil
+}
+
+// Name returns the node's MagicDNS name.
+func (n *Node) Name() string {
+	return n.name
+}
+
+// Serve serves app to the tailnet until it fails: over HTTPS on port 443
+// with the tailnet's certificate, and on port 80 as a redirect there.  If
+// the tailnet can't issue certificates, app is served on port 80 as it is.
+func (n *Node) Serve(app http.Handler) error {
+	tlsLn, err := n.srv.ListenTLS("tcp", ":443")
+	if err != nil {
+		slog.Warn("tailnet: no HTTPS, serving plain HTTP only; browsers won't allow voice or video", "err", err)
+		slog.Info("tailnet: Chirm is up", "url", "http://"+n.name)
+		return n.serveHTTP(app)
+	}
+	slog.Info("tailnet: Chirm is up", "url", "https://"+n.name)
+	go n.serveHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://"+n.name+r.URL.RequestURI(), http.StatusMovedPermanently)
+	}))
+	return (&http.Server{Handler: app, MaxHeaderBytes: n.maxHeader}).Serve(tlsLn)
+}
+
+func (n *Node) serveHTTP(h http.Handler) error {
+	ln, err := n.srv.Listen("tcp", ":80")
+	if err != nil {
+		return err
+	}
+	return (&http.Server{Handler: h, MaxHeaderBytes: n.maxHeader}).Serve(ln)
+}
+
+// Close takes the node off the tailnet.
+func (n *Node) Close() error {
+	return n.srv.Close()
+}
@@ -0,0 +1,108 @@
+// Package turn runs Chirm's optional embedded TURN relay and issues the
+// time-limited credentials clients use to reach it (or an external coturn).
+//
+// Credentials follow the "TURN REST API" convention understood by coturn's
+// use-auth-secret mode: the username is "<expiry unix time>:<user id>" and
+// the password is base64(HMAC-SHA1(secret, username)).  The relay therefore
+// never needs to look anything up — it recomputes the password from the
+// shared secret and rejects usernames whose expiry has passed.
+package turn
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	pionturn "github.com/pion/turn/v2"
+)
+
+// Credentials returns a username/password pair for userID that stays valid
+// for ttl.
+func Credentials(secret, userID string, ttl time.Duration) (username, password string) {
+	username = fmt.Sprintf("%d:%s", time.Now().Add(ttl).Unix(), userID)
+	return username, sign(secret, username)
+}
+
+func sign(secret, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Config describes the embedded relay.
+type Config struct {
+	Port         int    // UDP listen port (STUN/TURN), usually 3478
+	PublicIP     string // address advertised for relayed candidates
+	Realm        string
+	Secret       string
+	RelayPortMin uint16 // optional range for relay allocations
+	RelayPortMax uint16
+}
+
+// Server is a running embedded TURN relay.
+type Server struct {
+	srv *pionturn.Server
+}
+
+// Start listens on cfg.Port and serves TURN allocations to clients holding
+// valid credentials for cfg.Secret.
+func Start(cfg Config) (*Server, error) {
+	ip := net.ParseIP(cfg.PublicIP)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid public IP %q", cfg.PublicIP)
+	}
+	conn, err := net.ListenPacket("udp4", fmt.Sprintf("0.0.0.0:%d", cfg.Port))
+	if err != nil {
+		return nil, err
+	}
+
+	var gen pionturn.RelayAddressGenerator = &pionturn.RelayAddressGeneratorStatic{
+		RelayAddress: ip,
+		Address:      "0.0.0.0",
+	}
+	if cfg.RelayPortMin > 0 && cfg.RelayPortMax >= cfg.RelayPortMin {
+		gen = &pionturn.RelayAddressGeneratorPortRange{
+			RelayAddress: ip,
+			Address:      "0.0.0.0",
+			MinPort:      cfg.RelayPortMin,
+			MaxPort:      cfg.RelayPortMax,
+		}
+	}
+
+	srv, err := pionturn.NewServer(pionturn.ServerConfig{
+		Realm:       cfg.Realm,
+		AuthHandler: authHandler(cfg.Secret),
+		PacketConnConfigs: []pionturn.PacketConnConfig{{
+			PacketConn:            conn,
+			RelayAddressGenerator: gen,
+		}},
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Server{srv: srv}, nil
+}
+
+// Close stops the relay and releases every allocation.
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
+
+func authHandler(secret string) pionturn.AuthHandler {
+	return func(username, realm string, src net.Addr) ([]byte, bool) {
+		expiry, _, _ := strings.Cut(username, ":")
+		t, err := strconv.ParseInt(expiry, 10, 64)
+		if err != nil || time.Now().Unix() > t {
+			slog.Warn("turn: rejected credentials", "from", src)
+			return nil, false
+		}
+		return pionturn.GenerateAuthKey(username, realm, sign(secret, username)), true
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -10,38 +11,92 @@ import (
 	"crypto/x509/pkix"
 	"embed"
 	"encoding/pem"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/fs"
-	"log"
+	"log/slog"
 	"math/big"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
-
-	"golang.org/x/time/rate"
+	_ "time/tzdata" // quiet hours need IANA zones even where the OS has none
 
 	"github.com/go-chi/chi/v5"
 	chimw "github.com/go-chi/chi/v5/middleware"
+	"github.com/pion/webrtc/v3"
 
 	"chirm/internal/auth"
+	"chirm/internal/certs"
+	"chirm/internal/config"
 	"chirm/internal/db"
+	"chirm/internal/discovery"
 	"chirm/internal/handlers"
+	"chirm/internal/jobs"
+	"chirm/internal/logging"
+	"chirm/internal/mail"
 	mw "chirm/internal/middleware"
+	"chirm/internal/openapi"
+	"chirm/internal/sfu"
+	"chirm/internal/storage"
+	"chirm/internal/systemd"
+	"chirm/internal/tailnet"
+	"chirm/internal/turn"
 )
 
 //go:embed static
 var staticFiles embed.FS
 
+// legacyAPISince is when the unversioned /api/ paths gave way to /api/v1.
+// They'll keep working until a Sunset date is announced for them.
+var legacyAPISince = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+
 func main() {
+	configPath := flag.String("config", "", "config file (chirm.yaml or chirm.toml); by default one in the working directory is used if present")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: chirm [--config file]            run the server\n"+
+			"       chirm [--config file] admin ...  maintenance commands (chirm admin help)\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
 	// Load .env file if present (does not override existing env vars).
 	loadDotenv(".env")
 
+	// Then the config file, which fills in whatever the environment doesn't.
+	var cfgFile *config.File
+	if path := config.Find(*configPath); path != "" {
+		f, err := config.Load(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid config:\n%v\n", err)
+			os.Exit(1)
+		}
+		f.Apply()
+		cfgFile = f
+	}
+	if err := logging.Setup(os.Stderr, getEnv("LOG_FORMAT", "text"), getEnv("LOG_LEVEL", "info")); err != nil {
+		fatal("LOG_FORMAT / LOG_LEVEL", "err", err)
+	}
+	if flag.Arg(0) == "admin" {
+		os.Exit(runAdmin(flag.Args()[1:]))
+	} else if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if cfgFile != nil {
+		slog.Info("config file loaded", "path", cfgFile.Path)
+	}
+
 	port := getEnv("PORT", "8080")
 	dataDir := getEnv("DATA_DIR", "./data")
+	logFileFromEnv(dataDir)
 
 	// Refuse to start with a missing or default JWT secret.
 	jwtSecret := os.Getenv("JWT_SECRET")
@@ -49,128 +104,460 @@ func main() {
 		jwtSecret == "change-this-secret-in-production" ||
 		jwtSecret == "change-me-use-a-long-random-string-here" ||
 		jwtSecret == "change-me-use-a-long-random-string" {
-		log.Fatal("FATAL: JWT_SECRET is not set or is using the insecure default value.\n" +
-			"Generate one with:  openssl rand -hex 32\n" +
-			"Then set it in your environment or .env file before starting Chirm.")
+		fatal("JWT_SECRET is not set or is using the insecure default value",
+			"hint", "generate one with `openssl rand -hex 32` and set it in your environment, .env or config file")
 	}
 
 	if err := os.MkdirAll(dataDir+"/uploads", 0755); err != nil {
-		log.Fatal("Failed to create data directory:", err)
+		fatal("creating data directory", "err", err)
 	}
 
-	database, err := db.Init(dataDir + "/chirm.db")
+	database, err := db.Open(dataDir+"/chirm.db", dbPoolFromEnv())
 	if err != nil {
-		log.Fatal("Failed to init database:", err)
+		fatal("opening database", "err", err)
 	}
 	defer database.Close()
 
 	authSvc := auth.New(jwtSecret)
-	hub := handlers.NewHub(getEnv("ALLOWED_ORIGIN", ""))
-	go hub.Run()
+	hub := handlers.NewHub(database, getEnv("ALLOWED_ORIGIN", ""))
+
+	// Several Chirm processes can share one DATA_DIR; each names itself so
+	// they take turns at shared background jobs.
+	instanceID := getEnv("INSTANCE_ID", "")
+	if instanceID == "" {
+		host, _ := os.Hostname()
+		instanceID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	scheduler := jobs.New(database, instanceID)
 
-	// Fix #9: Periodically clean up orphaned attachments (uploaded but never sent).
-	go func() {
-		ticker := time.NewTicker(1 * time.Hour)
-		defer ticker.Stop()
-		for range ticker.C {
-			if err := database.CleanOrphanedAttachments(dataDir+"/uploads", 1*time.Hour); err != nil {
-				log.Printf("attachment cleanup error: %v", err)
+	// In cluster mode, voice rooms are coordinated through the database so
+	// peers on different instances can still signal each other.
+	if os.Getenv("CLUSTER_MODE") == "1" {
+		hub.EnableCluster(database, instanceID)
+		slog.Info("cluster mode", "instance", instanceID)
+
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sig
+			systemd.Notify("STOPPING=1")
+			hub.Shutdown()
+			os.Exit(0)
+		}()
+	} else {
+		// Calls can't outlive the process that was relaying them.
+		database.EndStaleCalls()
+	}
+	ice := iceConfigFromEnv()
+
+	if os.Getenv("VOICE_SFU") == "1" {
+		cfg := sfu.Config{}
+		if len(ice.STUNURLs) > 0 {
+			cfg.ICEServers = []webrtc.ICEServer{{URLs: ice.STUNURLs}}
+		}
+		if ports := getEnv("VOICE_UDP_PORTS", ""); ports != "" {
+			min, max, ok := sfu.ParsePortRange(ports)
+			if !ok {
+				fatal("invalid VOICE_UDP_PORTS (want e.g. 50000-50200)", "value", ports)
 			}
+			cfg.UDPPortMin, cfg.UDPPortMax = min, max
+		}
+		cfg.PublicIPs = splitList(getEnv("VOICE_PUBLIC_IP", ""))
+		if err := hub.EnableSFU(cfg); err != nil {
+			fatal("sfu", "err", err)
+		}
+		slog.Info("voice: built-in SFU enabled")
+		if os.Getenv("VOICE_RECORDING") == "1" {
+			hub.EnableRecording(filepath.Join(dataDir, "recordings"))
+			slog.Info("voice: recording enabled")
 		}
-	}()
+	} else if os.Getenv("VOICE_RECORDING") == "1" {
+		slog.Warn("VOICE_RECORDING needs VOICE_SFU=1; recording disabled")
+	}
+	if v := getEnv("VOICE_RECONNECT_GRACE", ""); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			fatal("invalid VOICE_RECONNECT_GRACE (want e.g. 15s, or 0 to disable)", "value", v)
+		}
+		hub.SetVoiceReconnectGrace(d)
+	}
+	go hub.Run()
 
 	h := handlers.New(database, authSvc, hub, dataDir)
+	h.SetICEConfig(ice)
+	h.SetDefaultUploadLimit(int64(envInt("MAX_UPLOAD_MB", 25)))
+	if bucket := os.Getenv("S3_BUCKET"); bucket != "" {
+		store, err := storage.NewS3(storage.S3Config{
+			Endpoint:      os.Getenv("S3_ENDPOINT"),
+			Region:        os.Getenv("S3_REGION"),
+			Bucket:        bucket,
+			AccessKey:     os.Getenv("S3_ACCESS_KEY"),
+			SecretKey:     os.Getenv("S3_SECRET_KEY"),
+			Prefix:        os.Getenv("S3_PREFIX"),
+			VirtualHosted: os.Getenv("S3_VIRTUAL_HOSTED") == "1",
+			Presign:       os.Getenv("S3_PRESIGN") == "1",
+		})
+		if err != nil {
+			fatal("S3 storage", "err", err)
+		}
+		h.SetStorage(store)
+		slog.Info("uploads: stored in S3", "location", store.Describe())
+	}
+
+	// Video posters need ffmpeg; without it videos are stored as they are.
+	if err := h.EnableVideoPosters(getEnv("FFMPEG_PATH", "ffmpeg"), getEnv("FFPROBE_PATH", "ffprobe")); err == nil {
+		slog.Info("video: poster frames via ffmpeg")
+	}
+
+	// Upload scanning: clamd if given an address, otherwise a command.
+	if addr := os.Getenv("CLAMD_ADDRESS"); addr != "" {
+		scanner := handlers.NewClamdScanner(addr)
+		h.SetScanner(scanner)
+		slog.Info("uploads: scanned for malware", "scanner", scanner.Describe())
+	} else if command := os.Getenv("AV_SCAN_COMMAND"); command != "" {
+		scanner, err := handlers.NewCommandScanner(command)
+		if err != nil {
+			fatal("upload scanning", "err", err)
+		}
+		h.SetScanner(scanner)
+		slog.Info("uploads: scanned for malware", "scanner", scanner.Describe())
+	}
+
+	// Transcoding is opt-in: it can keep a CPU busy for minutes per video.
+	if os.Getenv("TRANSCODE") == "1" {
+		t, err := handlers.NewFFmpegTranscoder(getEnv("FFMPEG_PATH", "ffmpeg"), getEnv("FFPROBE_PATH", "ffprobe"))
+		if err != nil {
+			fatal("transcoding", "err", err)
+		}
+		workers, _ := strconv.Atoi(getEnv("TRANSCODE_WORKERS", "1"))
+		h.EnableTranscoding(t, workers)
+		slog.Info("transcoding: video to H.264, audio to Opus", "workers", max(workers, 1))
+	}
+
+	if remote, err := backupRemoteFromEnv(); err != nil {
+		fatal("off-site backups", "err", err)
+	} else if remote != nil {
+		cfg, err := backupConfig(database, dataDir, remote)
+		if err != nil {
+			fatal("off-site backups", "err", err)
+		}
+		startBackups(scheduler, database, cfg)
+	}
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		mcfg, delay := mailConfigFromEnv(host)
+		h.SetMailer(mail.New(mcfg), delay, getEnv("PUBLIC_URL", os.Getenv("ALLOWED_ORIGIN")))
+		slog.Info("email: mention notifications", "smtp", fmt.Sprintf("%s:%d", mcfg.Host, mcfg.Port), "delay", delay)
+	}
 
 	// Initialise VAPID keys for Web Push notifications (non-fatal if it fails)
 	if err := h.InitVAPID(); err != nil {
-		log.Printf("⚠ VAPID init error (push notifications disabled): %v", err)
+		slog.Warn("VAPID init failed; push notifications disabled", "err", err)
+	}
+	if lang := os.Getenv("DEFAULT_LANGUAGE"); lang != "" {
+		if err := h.SetDefaultLanguage(lang); err != nil {
+			fatal("DEFAULT_LANGUAGE", "err", err)
+		}
+	}
+	if err := h.InitPushGateway(handlers.PushGatewayConfig{
+		FCMCredentialsFile: os.Getenv("FCM_CREDENTIALS"),
+		APNsKeyFile:        os.Getenv("APNS_KEY_FILE"),
+		APNsKeyID:          os.Getenv("APNS_KEY_ID"),
+		APNsTeamID:         os.Getenv("APNS_TEAM_ID"),
+		APNsTopic:          os.Getenv("APNS_TOPIC"),
+		APNsSandbox:        os.Getenv("APNS_SANDBOX") == "1",
+	}); err != nil {
+		fatal("push gateway", "err", err)
+	}
+	// Cleanups, retention, digests and stats; backups and certificate
+	// renewal are added where they're set up.
+	h.ScheduleJobs(scheduler)
+	scheduler.Start()
+
+	// Admins' IP allow and deny lists, checked before anything else
+	ipFilter := &mw.IPFilter{}
+	if err := h.SetIPFilter(ipFilter); err != nil {
+		fatal("failed to load ip rules", "err", err)
+	}
+
+	// Behind nginx, Caddy or Cloudflare every request comes from the proxy;
+	// TRUSTED_PROXIES lets the client's own address through from its headers.
+	realIP, err := mw.TrustedProxies(splitList(os.Getenv("TRUSTED_PROXIES")))
+	if err != nil {
+		fatal("invalid TRUSTED_PROXIES", "err", err)
+	}
+
+	// CORS_ORIGINS lets web clients on other sites, and browser extensions,
+	// call the API; by default only Chirm's own pages can.
+	cors, err := mw.CORS(mw.CORSConfig{
+		Origins:     splitList(os.Getenv("CORS_ORIGINS")),
+		Credentials: os.Getenv("CORS_CREDENTIALS") == "1",
+		Headers:     splitList(os.Getenv("CORS_HEADERS")),
+		MaxAge:      envInt("CORS_MAX_AGE", 600),
+	})
+	if err != nil {
+		fatal("invalid CORS settings", "err", err)
 	}
 
 	r := chi.NewRouter()
-	r.Use(chimw.Logger)
+	r.Use(realIP)
+	r.Use(mw.RequestLog)
+	r.Use(ipFilter.Handler)
+	r.Use(h.MaintenanceGate)
+	r.Use(cors)
 	r.Use(chimw.Recoverer)
 	r.Use(chimw.CleanPath)
-
-	// Fix #3: Per-IP rate limiter for auth endpoints (10 req/min, burst 5).
-	authLimiter := newIPRateLimiter(rate.Every(time.Minute/10), 5)
+	// Bodies are capped at MAX_BODY_KB; handlers that take uploads raise
+	// the cap for their own requests.
+	r.Use(mw.BodyLimit(int64(envInt("MAX_BODY_KB", 1024)) << 10))
+
+	// Rate limits for each class of route, per user or else per IP.  The
+	// numbers here are the defaults; <ENV>_RATE_PER_MIN and _RATE_BURST
+	// change them, and admins can change them again in the settings.
+	rateLimitClients := envInt("RATE_LIMIT_CLIENTS", 10000)
+	rateLimiter := func(class, env string, perMin, burst int) func(http.Handler) http.Handler {
+		def := mw.RateLimit{PerMinute: envInt(env+"_RATE_PER_MIN", perMin), Burst: envInt(env+"_RATE_BURST", burst)}
+		return mw.NewRateLimiter(rateLimitClients, h.RateLimit(class, def)).Handler
+	}
+	authLimiter := rateLimiter("auth", "AUTH", 10, 5)
+	webhookLimiter := rateLimiter("webhooks", "WEBHOOK", 60, 10)
+	messageLimiter := rateLimiter("messages", "MESSAGE", 30, 10)
+	uploadLimiter := rateLimiter("uploads", "UPLOAD", 20, 10)
+	previewLimiter := rateLimiter("previews", "PREVIEW", 60, 20)
+	imageProxyLimiter := rateLimiter("image_proxy", "IMAGE_PROXY", 600, 200)
+	serverPreviewLimiter := rateLimiter("server_preview", "SERVER_PREVIEW", 30, 10)
+	gifLimiter := rateLimiter("gifs", "GIF", 30, 10)
+	translateLimiter := rateLimiter("translations", "TRANSLATE", 30, 10)
+	oauthLimiter := rateLimiter("oauth", "OAUTH", 120, 30)
+	federationLimiter := rateLimiter("federation", "FEDERATION", 600, 100)
+
+	// The API lives under /api/v1.  The same routes answer at plain /api/
+	// too, for PWA installs and bots from before versioning, with headers
+	// pointing at /api/v1; a future /api/v2 can change what it likes.
+	api := chi.NewRouter()
+	api.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+	})
 
 	// Public API
-	r.Get("/api/setup/status", h.SetupStatus)
-	r.Post("/api/setup", h.Setup)
-	r.With(authLimiter).Post("/api/auth/login", h.Login)
-	r.With(authLimiter).Post("/api/auth/register", h.Register)
-	r.Post("/api/auth/logout", h.Logout)
-	r.Get("/api/join/{code}", h.JoinWithInvite)
-	r.Get("/api/public-settings", h.GetPublicSettings)
+	api.Get("/setup/status", h.SetupStatus)
+	api.Get("/setup/templates", h.ListTemplates)
+	api.Post("/setup", h.Setup)
+	api.With(authLimiter).Post("/auth/login", h.Login)
+	api.With(h.ReadOnlyGate, authLimiter).Post("/auth/register", h.Register)
+	api.Post("/auth/logout", h.Logout)
+	api.With(authLimiter).Post("/auth/recover", h.Recover)
+	api.Get("/join/{code}", h.JoinWithInvite)
+	api.Get("/public-settings", h.GetPublicSettings)
+	api.Get("/discovery", h.GetDiscovery)
+	api.With(serverPreviewLimiter).Get("/preview", h.GetServerPreview)
+	api.Get("/maintenance", h.GetMaintenance)
+	api.Get("/read-only", h.GetReadOnly)
+	api.Get("/client-version", h.GetClientVersion)
+	apiDocs := os.Getenv("API_DOCS") != "0"
+	if apiDocs {
+		api.Get("/openapi.json", h.OpenAPISpec)
+	}
+
+	// Incoming webhooks authenticate with the token in their URL.
+	api.With(h.ReadOnlyGate, webhookLimiter).Post("/webhooks/{id}/{token}", h.ExecuteWebhook)
+	api.Get("/federation", h.GetFederationInfo)
+	api.With(h.ReadOnlyGate, federationLimiter).Post("/federation/inbox", h.FederationInbox)
+	// Calendar feeds too, for calendars that can't sign in.
+	api.Get("/calendar/{token}", h.CalendarFeed)
 
 	// Authenticated API
-	r.Group(func(r chi.Router) {
+	api.Group(func(r chi.Router) {
 		r.Use(mw.Auth(authSvc))
 
-		r.Get("/ws", h.WebSocket)
-
-		r.Get("/api/me", h.GetMe)
-		r.Put("/api/me", h.UpdateMe)
-		r.Post("/api/me/avatar", h.UploadAvatar)
-
-		r.Get("/api/channels", h.ListChannels)
-		r.Post("/api/channels", h.CreateChannel)
-		r.Put("/api/channels/{id}", h.UpdateChannel)
-		r.Delete("/api/channels/{id}", h.DeleteChannel)
-		r.Post("/api/channels/reorder", h.ReorderChannels)
-
-		r.Get("/api/channel-categories", h.ListCategories)
-		r.Post("/api/channel-categories", h.CreateCategory)
-		r.Post("/api/channel-categories/reorder", h.ReorderCategories)
-		r.Put("/api/channel-categories/{id}", h.UpdateCategory)
-		r.Delete("/api/channel-categories/{id}", h.DeleteCategory)
-
-		r.Get("/api/channels/{id}/messages", h.GetMessages)
-		r.Post("/api/channels/{id}/messages", h.SendMessage)
-		r.Put("/api/messages/{id}", h.EditMessage)
-		r.Delete("/api/messages/{id}", h.DeleteMessage)
-		r.Post("/api/messages/{id}/reactions", h.AddReaction)
-		r.Delete("/api/messages/{id}/reactions/{emoji}", h.RemoveReaction)
-
-		r.Get("/api/emojis", h.ListCustomEmojis)
-		r.Post("/api/emojis", h.UploadCustomEmoji)
-		r.Delete("/api/emojis/{id}", h.DeleteCustomEmoji)
+		r.Put("/maintenance", h.SetMaintenance)
+		r.Put("/read-only", h.SetReadOnly)
+
+		r.Get("/admin/jwt-keys", h.ListJWTKeys)
+		r.Post("/admin/jwt-keys", h.AddJWTKey)
+		r.Delete("/admin/jwt-keys/{id}", h.RetireJWTKey)
+
+		r.Get("/me", h.GetMe)
+		r.Put("/me", h.UpdateMe)
+		r.With(h.ReadOnlyGate).Post("/me/avatar", h.UploadAvatar)
+		r.Get("/me/rules", h.GetMyRules)
+		r.Post("/me/rules/accept", h.AcceptMyRules)
+		r.Get("/me/notifications", h.GetNotificationSettings)
+		r.Put("/me/notifications", h.UpdateNotificationSettings)
+		r.Get("/me/recovery-codes", h.GetRecoveryCodes)
+		r.Post("/me/recovery-codes", h.NewRecoveryCodes)
+		r.Get("/me/tokens", h.ListPersonalTokens)
+		r.Post("/me/tokens", h.CreatePersonalToken)
+		r.Delete("/me/tokens/{id}", h.DeletePersonalToken)
+		r.Get("/me/calendar", h.GetCalendarFeed)
+		r.Post("/me/calendar", h.CreateCalendarFeed)
+		r.Delete("/me/calendar", h.DeleteCalendarFeed)
+		r.Get("/me/oauth", h.ListMyOAuthApps)
+		r.Delete("/me/oauth/{clientId}", h.RevokeMyOAuthApp)
+		r.Get("/calls", h.ListCalls)
+
+		r.Get("/guilds", h.ListGuilds)
+		r.Post("/guilds", h.CreateGuild)
+		r.Put("/guilds/{id}", h.UpdateGuild)
+		r.Delete("/guilds/{id}", h.DeleteGuild)
+		r.Delete("/guilds/{id}/members/{userId}", h.RemoveGuildMember)
+		r.Post("/guilds/{id}/template", h.ApplyTemplate)
+		r.Post("/guilds/join/{code}", h.JoinGuild)
+
+		r.Get("/channels", h.ListChannels)
+		r.Post("/channels", h.CreateChannel)
+		r.Put("/channels/{id}", h.UpdateChannel)
+		r.Delete("/channels/{id}", h.DeleteChannel)
+		r.Post("/channels/reorder", h.ReorderChannels)
+		r.Get("/channels/{id}/overrides", h.ListChannelOverrides)
+		r.Put("/channels/{id}/overrides/{roleId}", h.SetChannelOverride)
+		r.Delete("/channels/{id}/overrides/{roleId}", h.DeleteChannelOverride)
+		r.Get("/channels/{id}/webhooks", h.ListWebhooks)
+		r.Post("/channels/{id}/webhooks", h.CreateWebhook)
+		r.Delete("/webhooks/{id}", h.DeleteWebhook)
+		r.Get("/channels/{id}/feeds", h.ListFeeds)
+		r.Post("/channels/{id}/feeds", h.CreateFeed)
+		r.Delete("/feeds/{id}", h.DeleteFeed)
+		r.Get("/channels/{id}/federation", h.ListFederationLinks)
+		r.Post("/channels/{id}/federation", h.CreateFederationLink)
+		r.Delete("/federation/links/{id}", h.DeleteFederationLink)
+
+		r.Get("/events", h.ListEvents)
+		r.Post("/events", h.CreateEvent)
+		r.Get("/events/{id}", h.GetEvent)
+		r.Put("/events/{id}", h.UpdateEvent)
+		r.Delete("/events/{id}", h.DeleteEvent)
+		r.Put("/events/{id}/rsvp", h.SetEventRSVP)
+		r.Get("/events/{id}/rsvps", h.ListEventRSVPs)
+
+		r.Get("/automations", h.ListAutomationRules)
+		r.Post("/automations", h.CreateAutomationRule)
+		r.Put("/automations/{id}", h.UpdateAutomationRule)
+		r.Delete("/automations/{id}", h.DeleteAutomationRule)
+
+		r.Get("/automod", h.GetAutomodConfig)
+		r.Put("/automod", h.SetAutomodConfig)
+		r.Get("/automod/flags", h.ListAutomodFlags)
+		r.Post("/automod/flags/{id}/review", h.ReviewAutomodFlag)
+		r.Get("/automod/timeouts", h.ListAutomodTimeouts)
+		r.Delete("/automod/timeouts/{userId}", h.EndAutomodTimeout)
+		r.Get("/oauth/clients", h.ListOAuthClients)
+		r.Post("/oauth/clients", h.CreateOAuthClient)
+		r.Put("/oauth/clients/{id}", h.UpdateOAuthClient)
+		r.Delete("/oauth/clients/{id}", h.DeleteOAuthClient)
+		r.Post("/oauth/clients/{id}/secret", h.ResetOAuthClientSecret)
+
+		r.Get("/channel-categories", h.ListCategories)
+		r.Post("/channel-categories", h.CreateCategory)
+		r.Post("/channel-categories/reorder", h.ReorderCategories)
+		r.Put("/channel-categories/{id}", h.UpdateCategory)
+		r.Delete("/channel-categories/{id}", h.DeleteCategory)
+
+		r.Get("/channels/{id}/messages", h.GetMessages)
+		r.With(h.ReadOnlyGate, messageLimiter).Post("/channels/{id}/messages", h.SendMessage)
+		r.Post("/channels/{id}/read", h.MarkChannelRead)
+		r.With(h.ReadOnlyGate, messageLimiter).Put("/messages/{id}", h.EditMessage)
+		r.Delete("/messages/{id}", h.DeleteMessage)
+		r.Post("/messages/{id}/reactions", h.AddReaction)
+		r.Delete("/messages/{id}/reactions/{emoji}", h.RemoveReaction)
+		r.With(translateLimiter).Post("/messages/{id}/translate", h.TranslateMessage)
+
+		r.Get("/emojis", h.ListCustomEmojis)
+		r.With(h.ReadOnlyGate).Post("/emojis", h.UploadCustomEmoji)
+		r.Get("/emojis/export", h.ExportCustomEmojis)
+		r.With(h.ReadOnlyGate).Post("/emojis/import", h.ImportCustomEmojis)
+		r.Delete("/emojis/{id}", h.DeleteCustomEmoji)
+		r.Get("/stickers", h.ListStickers)
+		r.With(h.ReadOnlyGate).Post("/stickers", h.UploadSticker)
+		r.Delete("/stickers/{id}", h.DeleteSticker)
+		r.Get("/audit-log", h.ListAuditLog)
+		r.Get("/admin/stats", h.AdminStats)
+		r.Post("/admin/client-reload", h.ReloadClients)
+		r.Get("/admin/jobs", h.ListJobs)
+		r.Post("/admin/jobs/{name}/run", h.RunJob)
+		r.Get("/ip-rules", h.ListIPRules)
+		r.Post("/ip-rules", h.CreateIPRule)
+		r.Delete("/ip-rules/{id}", h.DeleteIPRule)
+		r.Get("/bots", h.ListBots)
+		r.Post("/bots", h.CreateBot)
+		r.Post("/bots/{id}/token", h.ResetBotToken)
+		r.Delete("/bots/{id}", h.DeleteBot)
+
+		// Soundboard
+		r.Get("/sounds", h.ListSounds)
+		r.With(h.ReadOnlyGate).Post("/sounds", h.UploadSound)
+		r.Delete("/sounds/{id}", h.DeleteSound)
+
+		r.With(previewLimiter).Get("/link-preview", h.LinkPreview)
+		r.With(imageProxyLimiter).Get("/image-proxy", h.ImageProxy)
+		r.With(gifLimiter).Get("/gifs/search", h.SearchGIFs)
+
+		r.With(h.ReadOnlyGate, uploadLimiter).Post("/upload", h.Upload)
+		r.With(h.ReadOnlyGate, uploadLimiter).Post("/uploads", h.UploadBatch)
+
+		r.Get("/users", h.ListUsers)
+		r.Put("/users/{id}", h.UpdateUser)
+		r.Delete("/users/{id}", h.DeleteUser)
+
+		r.Get("/roles", h.ListRoles)
+		r.Post("/roles", h.CreateRole)
+		r.Put("/roles/{id}", h.UpdateRole)
+		r.Delete("/roles/{id}", h.DeleteRole)
+		r.Post("/users/{id}/roles/{roleId}", h.AssignRole)
+		r.Delete("/users/{id}/roles/{roleId}", h.RemoveRole)
+
+		r.Get("/invites", h.ListInvites)
+		r.Post("/invites", h.CreateInvite)
+		r.Delete("/invites/{code}", h.DeleteInvite)
+		r.Get("/invites/{code}/joins", h.InviteJoins)
+		r.Get("/invites/{code}/qr.png", h.InviteQR)
+
+		r.Get("/settings", h.GetSettings)
+		r.Put("/settings", h.UpdateSettings)
+		r.Post("/settings/icon", h.UploadServerIcon)
+		r.Post("/settings/login-bg", h.UploadLoginBg)
+
+		r.Get("/members", h.ListMembers)
+
+		r.Get("/voice/rooms", h.VoiceRooms)
+		r.Get("/voice/ice-servers", h.GetICEServers)
+		r.Post("/voice/{channelId}/users/{id}/mute", h.MuteVoiceUser)
+		r.Post("/voice/{channelId}/users/{id}/deafen", h.DeafenVoiceUser)
+		r.Post("/voice/{channelId}/stage/speakers/{id}", h.InviteStageSpeaker)
+		r.Delete("/voice/{channelId}/stage/speakers/{id}", h.RemoveStageSpeaker)
+		r.Post("/voice/{channelId}/recording", h.StartRecording)
+		r.Delete("/voice/{channelId}/recording", h.StopRecording)
+		r.Post("/voice/{channelId}/broadcast", h.GoLive)
+		r.Delete("/voice/{channelId}/broadcast", h.EndLive)
+		r.Get("/recordings", h.ListRecordings)
+		r.Get("/recordings/{id}/files/{name}", h.GetRecordingFile)
+		r.Delete("/recordings/{id}", h.DeleteRecording)
 
-		r.Get("/api/link-preview", h.LinkPreview)
-
-		r.Post("/api/upload", h.Upload)
-
-		r.Get("/api/users", h.ListUsers)
-		r.Put("/api/users/{id}", h.UpdateUser)
-		r.Delete("/api/users/{id}", h.DeleteUser)
-
-		r.Get("/api/roles", h.ListRoles)
-		r.Post("/api/roles", h.CreateRole)
-		r.Put("/api/roles/{id}", h.UpdateRole)
-		r.Delete("/api/roles/{id}", h.DeleteRole)
-		r.Post("/api/users/{id}/roles/{roleId}", h.AssignRole)
-		r.Delete("/api/users/{id}/roles/{roleId}", h.RemoveRole)
-
-		r.Get("/api/invites", h.ListInvites)
-		r.Post("/api/invites", h.CreateInvite)
-		r.Delete("/api/invites/{code}", h.DeleteInvite)
-
-		r.Get("/api/settings", h.GetSettings)
-		r.Put("/api/settings", h.UpdateSettings)
-		r.Post("/api/settings/icon", h.UploadServerIcon)
-		r.Post("/api/settings/login-bg", h.UploadLoginBg)
+		// Web Push / PWA notifications
+		r.Get("/push/vapid-public-key", h.GetVAPIDPublicKey)
+		r.Get("/push/platforms", h.GetPushPlatforms)
+		r.Get("/push/languages", h.GetPushLanguages)
+		r.Post("/push/subscribe", h.SavePushSubscription)
+		r.Post("/push/unsubscribe", h.RemovePushSubscription)
+		r.Get("/push/subscriptions", h.ListPushSubscriptions)
+		r.Get("/push/poll", h.PollUnread)
+		r.Post("/push/test", h.TestPush)
+	})
 
-		r.Get("/api/members", h.ListMembers)
+	r.Mount("/api/v1", api)
+	r.Mount("/api", mw.Deprecated("/api", "/api/v1", legacyAPISince, time.Time{})(api))
 
-		r.Get("/api/voice/rooms", h.VoiceRooms)
+	r.With(mw.Auth(authSvc)).Get("/ws", h.WebSocket)
 
-		// Web Push / PWA notifications
-		r.Get("/api/push/vapid-public-key", h.GetVAPIDPublicKey)
-		r.Post("/api/push/subscribe", h.SavePushSubscription)
-		r.Post("/api/push/unsubscribe", h.RemovePushSubscription)
-		r.Get("/api/push/poll", h.PollUnread)
-		r.Post("/api/push/test", h.TestPush)
-	})
+	// Sign in with Chirm: OpenID Connect for other apps.  They live at the
+	// root, where apps expect them, and answer in OAuth's terms.
+	r.Get("/.well-known/openid-configuration", h.OpenIDConfiguration)
+	r.Get("/oauth/jwks", h.OAuthJWKS)
+	r.Get("/oauth/authorize", h.OAuthAuthorize)
+	r.With(authLimiter).Post("/oauth/authorize", h.OAuthAuthorize)
+	r.With(oauthLimiter).Post("/oauth/token", h.OAuthToken)
+	r.With(oauthLimiter).Get("/oauth/userinfo", h.OAuthUserInfo)
+	r.With(oauthLimiter).Post("/oauth/userinfo", h.OAuthUserInfo)
+	r.With(oauthLimiter).Post("/oauth/revoke", h.OAuthRevoke)
 
 	// Uploaded files
 	r.Get("/uploads/{filename}", h.ServeUpload)
@@ -203,14 +590,31 @@ func main() {
 	// Static SPA — serve embedded files, fallback to index.html
 	staticFS, err := fs.Sub(staticFiles, "static")
 	if err != nil {
-		log.Fatal(err)
+		fatal("static files", "err", err)
 	}
+	h.SetClientFiles(staticFS)
 	fileServer := http.FileServer(http.FS(staticFS))
 	r.Handle("/assets/*", fileServer)
 	r.Handle("/css/*", fileServer)
 	r.Handle("/js/*", fileServer)
 	r.Handle("/sw.js", fileServer)
 	r.Handle("/manifest.json", fileServer)
+	if apiDocs {
+		r.Get("/api/docs", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFileFS(w, r, staticFS, "api-docs.html")
+		})
+	}
+
+	// pprof and expvar, for profiling a live server: admins only, or with
+	// DEBUG_ENDPOINTS=local connections from this machine too.
+	switch debug := getEnv("DEBUG_ENDPOINTS", "admin"); debug {
+	case "off":
+	case "admin", "local":
+		h.PublishDebugVars()
+		r.With(h.DebugAccess(debug == "local")).Mount("/debug", handlers.DebugRoutes())
+	default:
+		fatal("invalid DEBUG_ENDPOINTS", "value", debug)
+	}
 	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
 		// Determine which page to serve based on path
 		path := r.URL.Path
@@ -224,6 +628,26 @@ func main() {
 		}
 	})
 
+	// The API reference is built from the routes above, so it can't list
+	// one that isn't there; routes the docs table doesn't cover yet still
+	// appear, without schemas.
+	if apiDocs {
+		spec, undocumented, unknown, err := openapi.Build(api, openapi.Info{
+			Title:   "Chirm API",
+			Version: "1",
+			Description: "The HTTP API the Chirm web app uses. Sign in with /api/v1/auth/login and send the token it returns " +
+				"as a Bearer token. Live events arrive over a WebSocket at /ws.",
+			BasePath: "/api/v1",
+		}, handlers.APIDocs())
+		if err != nil {
+			fatal("API docs", "err", err)
+		}
+		if len(undocumented) > 0 || len(unknown) > 0 {
+			slog.Debug("API docs out of step with routes", "undocumented", undocumented, "no_route", unknown)
+		}
+		h.SetAPISpec(spec)
+	}
+
 	// ── TLS / HTTPS startup ────────────────────────────────────────────────────
 	// Priority order for certs:
 	//   1. CHIRM_TLS_CERT / CHIRM_TLS_KEY env vars  (e.g. Let's Encrypt / Tailscale)
@@ -233,66 +657,445 @@ func main() {
 	//      the CA cert at /ca-cert so users can install it once and be done.
 	httpsPort := getEnv("HTTPS_PORT", "8443")
 
+	// LISTEN and HTTPS_LISTEN take lists of addresses to listen on instead:
+	// host:port, :port or unix:/path, or "off" for none.
+	httpAddrs := listenAddrs("LISTEN", port)
+	httpsAddrs := listenAddrs("HTTPS_LISTEN", httpsPort)
+	port = addrPort(httpAddrs)
+	httpsPort = addrPort(httpsAddrs)
+
+	// Started by a systemd .socket unit, Chirm serves on the sockets it was
+	// handed: named "http" and "https" with FileDescriptorName=, or else the
+	// first for HTTP and the second for HTTPS.  They stand in for LISTEN and
+	// HTTPS_LISTEN.
+	activated, err := systemd.Listeners()
+	if err != nil {
+		fatal("systemd sockets", "err", err)
+	}
+	var httpLn, httpsLn net.Listener
+	for _, ln := range activated {
+		switch ln.Name {
+		case "http":
+			httpLn = ln
+		case "https":
+			httpsLn = ln
+		}
+	}
+	if httpLn == nil && httpsLn == nil && len(activated) > 0 {
+		httpLn = activated[0]
+		if len(activated) > 1 {
+			httpsLn = activated[1]
+		}
+	}
+	var httpLns, httpsLns []net.Listener
+	if httpLn != nil {
+		httpLns, port = []net.Listener{httpLn}, listenerPort(httpLn)
+	}
+	if httpsLn != nil {
+		httpsLns, httpsPort = []net.Listener{httpsLn}, listenerPort(httpsLn)
+	}
+	if len(httpLns) == 0 && len(httpAddrs) == 0 && len(httpsLns) == 0 && len(httpsAddrs) == 0 {
+		fatal("LISTEN and HTTPS_LISTEN are both off; there'd be nothing to serve on")
+	}
+
 	certFile := getEnv("CHIRM_TLS_CERT", "")
-	keyFile  := getEnv("CHIRM_TLS_KEY",  "")
+	keyFile := getEnv("CHIRM_TLS_KEY", "")
 
 	if certFile == "" {
 		if _, err := os.Stat("certs/cert.pem"); err == nil {
 			certFile = "certs/cert.pem"
-			keyFile  = "certs/key.pem"
+			keyFile = "certs/key.pem"
+		}
+	}
+
+	// TLS_SNI_CERTS are served to clients asking for a name they cover,
+	// e.g. a public domain's certificate alongside the LAN one.
+	var sniCerts []certs.Source
+	for _, pair := range splitList(getEnv("TLS_SNI_CERTS", "")) {
+		cert, key, found := strings.Cut(pair, ":")
+		if !found {
+			fatal("invalid TLS_SNI_CERTS entry (want cert.pem:key.pem)", "value", pair)
 		}
+		sniCerts = append(sniCerts, certs.Files(cert, key))
 	}
 
-	var tlsCert      tls.Certificate
-	var tlsErr       error
+	var tlsCerts *certs.Store
+	var tlsErr error
 	usingRealCert := false
 
-	if certFile != "" && keyFile != "" {
-		tlsCert, tlsErr = tls.LoadX509KeyPair(certFile, keyFile)
+	// With HTTPS_LISTEN=off there's no certificate to load.
+	httpsWanted := len(httpsLns) > 0 || len(httpsAddrs) > 0
+	if !httpsWanted {
+		tlsErr = errors.New("HTTPS_LISTEN is off")
+	}
+
+	if httpsWanted && certFile != "" && keyFile != "" {
+		tlsCerts, tlsErr = certs.NewStore(certs.Files(certFile, keyFile), sniCerts...)
 		if tlsErr != nil {
-			log.Printf("⚠ Could not load TLS cert from %s / %s: %v — falling back to built-in CA", certFile, keyFile, tlsErr)
+			slog.Warn("could not load TLS cert; falling back to built-in CA", "cert", certFile, "key", keyFile, "err", tlsErr)
 		} else {
 			usingRealCert = true
-			log.Printf("✦ TLS: using cert from %s", certFile)
+			slog.Info("TLS: using cert", "cert", certFile)
 		}
 	}
 
-	if !usingRealCert {
-		tlsCert, tlsErr = ensurePersistentCert("certs")
+	if httpsWanted && !usingRealCert {
+		tlsCerts, tlsErr = certs.NewStore(certs.Source{
+			Name:  "built-in",
+			Files: []string{"certs/chirm-cert.pem", "certs/chirm-key.pem"},
+			Load: func() (tls.Certificate, error) {
+				return ensurePersistentCert("certs")
+			},
+		}, sniCerts...)
 		if tlsErr != nil {
-			log.Printf("⚠ Could not generate TLS cert: %v", tlsErr)
+			slog.Warn("could not generate TLS cert", "err", tlsErr)
 		} else {
-			lanIP := getLANIP()
-			log.Println("✦ TLS: using built-in self-signed CA (persistent).")
-			log.Printf("  Install the CA cert on each device to remove browser warnings:")
-			log.Printf("  ► Open http://%s:%s/ca-cert on each device and follow the OS prompts.", lanIP, port)
-			log.Println("  After installing, navigate to https://" + lanIP + ":" + httpsPort + " — no warnings.")
+			// Re-signed when a reload finds it near expiry; the daily
+			// reload sees to that for a server that stays up for months.
+			scheduler.Add(jobs.Job{Name: "cert-renewal", Every: 24 * time.Hour, Delay: 24 * time.Hour, Run: tlsCerts.Reload})
+			if port != "" && httpsPort != "" {
+				lanIP := getLANIP()
+				slog.Info("TLS: using built-in self-signed CA; install its cert on each device to remove browser warnings",
+					"ca_cert", "http://"+lanIP+":"+port+"/ca-cert",
+					"then_open", "https://"+lanIP+":"+httpsPort)
+			}
 		}
 	}
 
+	// Certificates are reloaded when their files change, e.g. on renewal,
+	// or on SIGHUP.
 	if tlsErr == nil {
+		scheduler.Add(jobs.Job{Name: "cert-watch", Every: 30 * time.Second, Delay: 30 * time.Second, Run: tlsCerts.ReloadChanged})
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
 		go func() {
-			tlsServer := &http.Server{
-				Addr:    ":" + httpsPort,
-				Handler: r,
-				TLSConfig: &tls.Config{
-					Certificates: []tls.Certificate{tlsCert},
-				},
+			for range hup {
+				slog.Info("SIGHUP: reloading TLS certificates")
+				tlsCerts.Reload()
 			}
-			if usingRealCert {
-				log.Printf("✦ Chirm HTTPS at https://%s:%s", getLANIP(), httpsPort)
+		}()
+	}
+
+	if tlsErr == nil && len(httpsLns) == 0 && len(httpsAddrs) > 0 {
+		if httpsLns, err = listenAll(httpsAddrs); err != nil {
+			slog.Error("HTTPS server", "err", err)
+		}
+	}
+	httpsOn := tlsErr == nil && len(httpsLns) > 0
+
+	// HTTPS_REDIRECT=1 turns the plain-HTTP port into a redirect to HTTPS,
+	// apart from /ca-cert and ACME challenges, which have to work before a
+	// device trusts the certificate or before there is one.
+	hstsMaxAge := envNonNegative("HSTS_MAX_AGE", -1)
+	// Requests with more headers than this get a 431.
+	maxHeaderBytes := envInt("MAX_HEADER_KB", 64) << 10
+	httpHandler := http.Handler(r)
+	if os.Getenv("HTTPS_REDIRECT") == "1" {
+		if !httpsOn || httpsPort == "" {
+			slog.Warn("HTTPS_REDIRECT is set but HTTPS isn't running on a TCP port; serving the app over HTTP")
+		} else {
+			httpHandler = httpsRedirect(r, httpsPort, getEnv("ACME_WEBROOT", ""))
+		}
+	}
+
+	// IRC_LISTEN and IRC_TLS_LISTEN run the IRC gateway, for the channels
+	// admins open to it; both are off unless set, e.g. to :6667 and :6697.
+	// The TLS one uses the HTTPS certificate.
+	if addr := getEnv("IRC_LISTEN", ""); addr != "" && addr != "off" {
+		if ln, err := net.Listen("tcp", addr); err != nil {
+			slog.Error("IRC gateway", "err", err)
+		} else {
+			h.ServeIRC(ln, false)
+			slog.Info("IRC gateway", "listen", ln.Addr().String())
+		}
+	}
+	if addr := getEnv("IRC_TLS_LISTEN", ""); addr != "" && addr != "off" {
+		if tlsErr != nil {
+			slog.Error("IRC gateway: no TLS certificate", "err", tlsErr)
+		} else if ln, err := tls.Listen("tcp", addr, &tls.Config{GetCertificate: tlsCerts.GetCertificate}); err != nil {
+			slog.Error("IRC gateway", "err", err)
+		} else {
+			h.ServeIRC(ln, true)
+			slog.Info("IRC gateway (TLS)", "listen", ln.Addr().String())
+		}
+	}
+
+	if httpsOn {
+		tlsServer := &http.Server{
+			Handler: hsts(r, hstsMaxAge),
+			TLSConfig: &tls.Config{
+				GetCertificate: tlsCerts.GetCertificate,
+			},
+			ConnContext:    mw.UnixSocketContext,
+			MaxHeaderBytes: maxHeaderBytes,
+		}
+		msg := "Chirm HTTPS"
+		if !usingRealCert {
+			msg = "Chirm HTTPS (self-signed CA)"
+		}
+		for _, ln := range httpsLns {
+			if httpsPort != "" && ln.Addr().Network() == "tcp" {
+				slog.Info(msg, "url", "https://"+getLANIP()+":"+listenerPort(ln))
 			} else {
-				log.Printf("✦ Chirm HTTPS (self-signed CA) at https://%s:%s", getLANIP(), httpsPort)
+				slog.Info(msg, "listen", ln.Addr().Network()+":"+ln.Addr().String())
 			}
-			if err := tlsServer.ListenAndServeTLS("", ""); err != nil {
-				log.Printf("HTTPS server error: %v", err)
+			go func(ln net.Listener) {
+				if err := tlsServer.ServeTLS(ln, "", ""); err != nil {
+					if len(httpAddrs) == 0 && len(httpLns) == 0 {
+						fatal("HTTPS server", "err", err)
+					}
+					slog.Error("HTTPS server", "err", err)
+				}
+			}(ln)
+		}
+	}
+
+	// With TS_AUTHKEY, Chirm also joins a tailnet as a machine of its own.
+	// Joining can take a while, or wait on a login, so it happens on the
+	// side.
+	if authKey := os.Getenv("TS_AUTHKEY"); authKey != "" {
+		go func() {
+			node, err := tailnet.Start(context.Background(), tailnet.Config{
+				Hostname:       getEnv("TS_HOSTNAME", "chirm"),
+				Dir:            filepath.Join(dataDir, "tailscale"),
+				AuthKey:        authKey,
+				ControlURL:     getEnv("TS_CONTROL_URL", ""),
+				MaxHeaderBytes: maxHeaderBytes,
+			})
+			if err != nil {
+				slog.Error("tailnet: could not join", "err", err)
+				return
 			}
+			slog.Error("tailnet server", "err", node.Serve(hsts(r, hstsMaxAge)))
 		}()
 	}
 
-	log.Printf("✦ Chirm running at http://localhost:%s", port)
-	log.Printf("  CA cert for device trust: http://%s:%s/ca-cert", getLANIP(), port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	// Advertise the server on the LAN, pointing at HTTPS when it's running.
+	disc := handlers.DiscoveryConfig{
+		HTTPPort:   atoi(port),
+		SelfSigned: tlsErr == nil && !usingRealCert,
+		PublicURL:  getEnv("PUBLIC_URL", ""),
+	}
+	if httpsOn {
+		disc.HTTPSPort = atoi(httpsPort)
+	}
+	if os.Getenv("MDNS") != "0" && (disc.HTTPPort != 0 || disc.HTTPSPort != 0) {
+		host := getEnv("MDNS_HOSTNAME", "")
+		if host == "" {
+			name, _ := os.Hostname()
+			host = discovery.HostLabel(name)
+		}
+		svcPort := disc.HTTPSPort
+		if svcPort == 0 {
+			svcPort = disc.HTTPPort
+		}
+		responder, err := discovery.Start(discovery.Service{
+			Name: func() string {
+				name, _ := database.GetSetting("server_name")
+				return name
+			},
+			Host: host,
+			Port: svcPort,
+			TXT: func() []string {
+				txt := []string{"path=/api/v1/discovery", "http_port=" + strconv.Itoa(disc.HTTPPort)}
+				if disc.HTTPSPort != 0 {
+					txt = append(txt, "tls=1")
+				}
+				return txt
+			},
+		})
+		if err != nil {
+			slog.Warn("mDNS: not advertising on the LAN", "err", err)
+		} else {
+			disc.MDNSHost = responder.Host()
+			slog.Info("mDNS: advertising", "service", discovery.ServiceType, "host", disc.MDNSHost, "port", svcPort)
+		}
+	}
+	h.SetDiscovery(disc)
+
+	// FEDERATION=1 lets admins share channels with channels on other Chirm
+	// servers, which know this one by PUBLIC_URL.
+	if os.Getenv("FEDERATION") == "1" {
+		if err := h.SetFederation(true); err != nil {
+			slog.Error("federation: staying off", "err", err)
+		} else {
+			slog.Info("federation: on")
+		}
+	}
+
+	if len(httpLns) == 0 && len(httpAddrs) > 0 {
+		if httpLns, err = listenAll(httpAddrs); err != nil {
+			fatal("HTTP server", "err", err)
+		}
+	}
+
+	var listening []string
+	for _, ln := range append(httpLns, httpsLns...) {
+		listening = append(listening, ln.Addr().Network()+":"+ln.Addr().String())
+	}
+	if port != "" {
+		slog.Info("Chirm running", "url", "http://localhost:"+port, "ca_cert", "http://"+getLANIP()+":"+port+"/ca-cert")
+	} else {
+		slog.Info("Chirm running", "listen", strings.Join(listening, ","))
+	}
+	// Under Type=notify, systemd holds back units that depend on Chirm until
+	// it's listening, and with WatchdogSec= restarts it if the database stops
+	// answering.
+	systemd.Notify("READY=1\nSTATUS=Serving on " + strings.Join(listening, ", "))
+	systemd.Watchdog(database.Ping)
+	if len(httpLns) == 0 {
+		select {} // HTTPS only; its servers run on their own
+	}
+	plain := &http.Server{Handler: httpHandler, ConnContext: mw.UnixSocketContext, MaxHeaderBytes: maxHeaderBytes}
+	for _, ln := range httpLns[1:] {
+		go func(ln net.Listener) {
+			fatal("HTTP server", "err", plain.Serve(ln))
+		}(ln)
+	}
+	fatal("HTTP server", "err", plain.Serve(httpLns[0]))
+}
+
+// listenAddrs returns the addresses the environment variable key lists,
+// ":"+port if it's unset, or nil if it's "off".
+func listenAddrs(key, port string) []string {
+	v := getEnv(key, ":"+port)
+	if v == "off" {
+		return nil
+	}
+	return splitList(v)
+}
+
+// addrPort returns the port of the first TCP address in addrs, or "" if
+// they're all Unix sockets.
+func addrPort(addrs []string) string {
+	for _, a := range addrs {
+		if strings.HasPrefix(a, "unix:") {
+			continue
+		}
+		if _, p, err := net.SplitHostPort(a); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// listenAll listens on each of addrs: host:port or :port for TCP, or
+// unix:/path for a Unix socket, made with UNIX_SOCKET_MODE permissions.
+func listenAll(addrs []string) ([]net.Listener, error) {
+	var lns []net.Listener
+	for _, a := range addrs {
+		ln, err := listenOn(a)
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+func listenOn(addr string) (net.Listener, error) {
+	path, isUnix := strings.CutPrefix(addr, "unix:")
+	if !isUnix {
+		return net.Listen("tcp", addr)
+	}
+	mode, err := strconv.ParseUint(getEnv("UNIX_SOCKET_MODE", "0660"), 8, 32)
+	if err != nil || mode > 0o777 {
+		return nil, fmt.Errorf("invalid UNIX_SOCKET_MODE %q (want e.g. 0660)", os.Getenv("UNIX_SOCKET_MODE"))
+	}
+	// A socket left behind by a Chirm that was killed is in the way; one
+	// that still answers belongs to a Chirm that's running.
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// listenerPort returns the TCP port ln listens on, or "" for none.
+func listenerPort(ln net.Listener) string {
+	if ln == nil {
+		return ""
+	}
+	if addr, ok := ln.Addr().(*net.TCPAddr); ok {
+		return strconv.Itoa(addr.Port)
+	}
+	return ""
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// httpsRedirect sends every request to the same path on the HTTPS port,
+// except /ca-cert, which app serves, and ACME HTTP-01 challenges, which are
+// served from webroot (the directory given to e.g. certbot --webroot).
+func httpsRedirect(app http.Handler, httpsPort, webroot string) http.Handler {
+	const acmePrefix = "/.well-known/acme-challenge/"
+	var acme http.Handler = http.NotFoundHandler()
+	if webroot != "" {
+		acme = http.FileServer(http.Dir(webroot))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/ca-cert":
+			app.ServeHTTP(w, r)
+			return
+		case strings.HasPrefix(r.URL.Path, acmePrefix):
+			acme.ServeHTTP(w, r)
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6 literal
+		}
+		if httpsPort != "443" {
+			host += ":" + httpsPort
+		}
+		code := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+}
+
+// hsts adds a Strict-Transport-Security header with maxAge seconds to
+// responses from next, or returns next unchanged when maxAge is below 0.
+// A maxAge of 0 still sends the header, which makes browsers forget an
+// earlier one.
+func hsts(next http.Handler, maxAge int) http.Handler {
+	if maxAge < 0 {
+		return next
+	}
+	value := "max-age=" + strconv.Itoa(maxAge)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 // ensurePersistentCert generates a local CA + server certificate on first run,
@@ -307,15 +1110,15 @@ func ensurePersistentCert(certsDir string) (tls.Certificate, error) {
 		return tls.Certificate{}, fmt.Errorf("create certs dir: %w", err)
 	}
 
-	caKeyPath   := filepath.Join(certsDir, "chirm-ca-key.pem")
-	caCertPath  := filepath.Join(certsDir, "chirm-ca.pem")
-	srvKeyPath  := filepath.Join(certsDir, "chirm-key.pem")
+	caKeyPath := filepath.Join(certsDir, "chirm-ca-key.pem")
+	caCertPath := filepath.Join(certsDir, "chirm-ca.pem")
+	srvKeyPath := filepath.Join(certsDir, "chirm-key.pem")
 	srvCertPath := filepath.Join(certsDir, "chirm-cert.pem")
 
 	// ── Try to load existing CA ──────────────────────────────────────────────
-	var caKey  *ecdsa.PrivateKey
+	var caKey *ecdsa.PrivateKey
 	var caCert *x509.Certificate
-	var caDER  []byte
+	var caDER []byte
 
 	if fileExists(caKeyPath) && fileExists(caCertPath) {
 		caKey, caCert, caDER = loadCA(caCertPath, caKeyPath)
@@ -353,7 +1156,7 @@ func ensurePersistentCert(certsDir string) (tls.Certificate, error) {
 		if err := writePEM(caKeyPath, "EC PRIVATE KEY", caKeyBytes, 0600); err != nil {
 			return tls.Certificate{}, fmt.Errorf("write CA key: %w", err)
 		}
-		log.Printf("✦ TLS: generated new CA in %s/", certsDir)
+		slog.Info("TLS: generated new CA", "dir", certsDir)
 	}
 
 	// ── Try to load existing server cert ─────────────────────────────────────
@@ -368,7 +1171,7 @@ func ensurePersistentCert(certsDir string) (tls.Certificate, error) {
 				// generated with 10-year validity need to be re-signed.
 				totalDays := leaf.NotAfter.Sub(leaf.NotBefore).Hours() / 24
 				if totalDays > 400 {
-					log.Printf("⚠ Server cert validity is %.0f days (max 398) — regenerating", totalDays)
+					slog.Warn("server cert validity is over 398 days; regenerating", "days", int(totalDays))
 				} else {
 					// Cert is still good.  Make sure the CA cert is in the chain
 					// (older versions wrote only the leaf to the PEM file).
@@ -377,15 +1180,14 @@ func ensurePersistentCert(certsDir string) (tls.Certificate, error) {
 						// Re-write the PEM so next load also picks up the chain.
 						rewriteServerCertPEM(srvCertPath, cert.Certificate)
 					}
-					log.Printf("✦ TLS: loaded persistent certs from %s (expires %s)",
-						certsDir, leaf.NotAfter.Format("2006-01-02"))
+					slog.Info("TLS: loaded persistent certs", "dir", certsDir, "expires", leaf.NotAfter.Format("2006-01-02"))
 					return cert, nil
 				}
 			} else if parseErr == nil {
-				log.Printf("⚠ Server cert expires %s — regenerating", leaf.NotAfter.Format("2006-01-02"))
+				slog.Warn("server cert expires soon; regenerating", "expires", leaf.NotAfter.Format("2006-01-02"))
 			}
 		} else {
-			log.Printf("⚠ Could not load existing server cert (%v) — regenerating", err)
+			slog.Warn("could not load existing server cert; regenerating", "err", err)
 		}
 	}
 
@@ -436,8 +1238,8 @@ func ensurePersistentCert(certsDir string) (tls.Certificate, error) {
 		return tls.Certificate{}, fmt.Errorf("write server cert chain: %w", err)
 	}
 
-	log.Printf("✦ TLS: generated new server cert in %s/ (expires %s)",
-		certsDir, time.Now().Add(397*24*time.Hour).Format("2006-01-02"))
+	slog.Info("TLS: generated new server cert", "dir", certsDir,
+		"expires", time.Now().Add(397*24*time.Hour).Format("2006-01-02"))
 
 	// Build tls.Certificate with full chain in memory.
 	return tls.Certificate{
@@ -553,6 +1355,158 @@ func getLANIP() string {
 	return "localhost"
 }
 
+// mailConfigFromEnv reads the SMTP settings used for missed-mention emails
+// and how long to wait before sending one.
+func mailConfigFromEnv(host string) (mail.Config, time.Duration) {
+	cfg := mail.Config{
+		Host:     host,
+		Port:     587,
+		Username: os.Getenv("SMTP_USER"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     getEnv("SMTP_FROM", "Chirm <chirm@"+host+">"),
+	}
+	if v := os.Getenv("SMTP_PORT"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil || p <= 0 || p > 65535 {
+			fatal("invalid SMTP_PORT", "value", v)
+		}
+		cfg.Port = p
+	}
+	delay, err := time.ParseDuration(getEnv("EMAIL_NOTIFY_DELAY", "15m"))
+	if err != nil || delay < 0 {
+		fatal("invalid EMAIL_NOTIFY_DELAY (want e.g. 15m)", "value", os.Getenv("EMAIL_NOTIFY_DELAY"))
+	}
+	return cfg, delay
+}
+
+// dbPoolFromEnv reads the database connection pool settings; those left
+// unset take the db package's defaults.
+func dbPoolFromEnv() db.PoolConfig {
+	cfg := db.PoolConfig{
+		MaxOpen: envInt("DB_MAX_OPEN_CONNS", 0),
+		MaxIdle: envInt("DB_MAX_IDLE_CONNS", 0),
+	}
+	for key, d := range map[string]*time.Duration{
+		"DB_CONN_MAX_IDLE_TIME": &cfg.MaxIdleTime,
+		"DB_CONN_MAX_LIFETIME":  &cfg.MaxLifetime,
+		"DB_BUSY_TIMEOUT":       &cfg.BusyTimeout,
+	} {
+		if v := os.Getenv(key); v != "" {
+			var err error
+			if *d, err = time.ParseDuration(v); err != nil || *d < 0 {
+				fatal("invalid "+key+" (want e.g. 5m)", "value", v)
+			}
+		}
+	}
+	return cfg
+}
+
+// logFileFromEnv has the log written to LOG_FILE as well as stderr, when
+// it's set.  A relative path is under dataDir.
+func logFileFromEnv(dataDir string) {
+	path := os.Getenv("LOG_FILE")
+	if path == "" {
+		return
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dataDir, path)
+	}
+	maxAge, err := time.ParseDuration(getEnv("LOG_FILE_MAX_AGE", "24h"))
+	if err != nil || maxAge < 0 {
+		fatal("invalid LOG_FILE_MAX_AGE (want e.g. 24h, or 0 to rotate by size only)", "value", os.Getenv("LOG_FILE_MAX_AGE"))
+	}
+	f, err := logging.OpenFile(logging.FileConfig{
+		Path:     path,
+		MaxBytes: int64(envInt("LOG_FILE_MAX_MB", 100)) << 20,
+		MaxAge:   maxAge,
+		Keep:     envInt("LOG_FILE_KEEP", 7),
+		Compress: os.Getenv("LOG_FILE_COMPRESS") != "0",
+	})
+	if err != nil {
+		fatal("opening LOG_FILE", "path", path, "err", err)
+	}
+	logging.Setup(io.MultiWriter(os.Stderr, f), getEnv("LOG_FORMAT", "text"), getEnv("LOG_LEVEL", "info"))
+	slog.Info("logging to file", "path", path)
+}
+
+// iceConfigFromEnv builds the STUN/TURN list handed to voice clients and, with
+// TURN_EMBEDDED=1, starts the built-in relay.
+func iceConfigFromEnv() handlers.ICEConfig {
+	cfg := handlers.ICEConfig{
+		STUNURLs:   splitList(getEnv("STUN_URLS", "stun:stun.l.google.com:19302,stun:stun1.l.google.com:19302")),
+		TURNURLs:   splitList(getEnv("TURN_URLS", "")),
+		TURNSecret: getEnv("TURN_SECRET", ""),
+		TURNTTL:    12 * time.Hour,
+	}
+	if os.Getenv("STUN_URLS") == "none" {
+		cfg.STUNURLs = nil
+	}
+	if v := getEnv("TURN_TTL", ""); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			fatal("invalid TURN_TTL (want e.g. 12h)", "value", v)
+		}
+		cfg.TURNTTL = d
+	}
+
+	if os.Getenv("TURN_EMBEDDED") != "1" {
+		if len(cfg.TURNURLs) > 0 && cfg.TURNSecret == "" {
+			slog.Warn("TURN_URLS is set but TURN_SECRET is empty; TURN disabled")
+		}
+		return cfg
+	}
+
+	publicIP := getEnv("TURN_PUBLIC_IP", "")
+	if publicIP == "" {
+		fatal("TURN_EMBEDDED=1 requires TURN_PUBLIC_IP")
+	}
+	if cfg.TURNSecret == "" {
+		// Credentials are only checked by this process, so a per-run secret is fine.
+		buf := make([]byte, 32)
+		rand.Read(buf)
+		cfg.TURNSecret = fmt.Sprintf("%x", buf)
+	}
+	port := 3478
+	if v := getEnv("TURN_PORT", ""); v != "" {
+		if _, err := fmt.Sscanf(v, "%d", &port); err != nil {
+			fatal("invalid TURN_PORT", "value", v)
+		}
+	}
+	tcfg := turn.Config{
+		Port:     port,
+		PublicIP: publicIP,
+		Realm:    getEnv("TURN_REALM", "chirm"),
+		Secret:   cfg.TURNSecret,
+	}
+	if v := getEnv("TURN_RELAY_PORTS", ""); v != "" {
+		min, max, ok := sfu.ParsePortRange(v)
+		if !ok {
+			fatal("invalid TURN_RELAY_PORTS (want e.g. 49160-49200)", "value", v)
+		}
+		tcfg.RelayPortMin, tcfg.RelayPortMax = min, max
+	}
+	if _, err := turn.Start(tcfg); err != nil {
+		fatal("turn", "err", err)
+	}
+	if len(cfg.TURNURLs) == 0 {
+		hostPort := net.JoinHostPort(publicIP, fmt.Sprint(port))
+		cfg.TURNURLs = []string{"turn:" + hostPort + "?transport=udp"}
+	}
+	slog.Info("TURN relay listening", "port", port, "public_ip", publicIP)
+	return cfg
+}
+
+// splitList splits a comma-separated env value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
 func getEnv(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -560,6 +1514,34 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// envInt returns the whole number above 0 in env var key, or fallback if
+// it's unset, and stops Chirm if it's anything else.
+func envInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		fatal("invalid "+key+" (want a whole number above 0)", "value", v)
+	}
+	return n
+}
+
+// envNonNegative is envInt for settings where 0 means something, returning
+// the whole number 0 or above in env var key.
+func envNonNegative(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		fatal("invalid "+key+" (want a whole number, 0 or above)", "value", v)
+	}
+	return n
+}
+
 // loadDotenv reads a .env file and sets any environment variables that are not
 // already present in the environment.  It silently does nothing if the file
 // doesn't exist.  This keeps the "zero external dependencies" philosophy — no
@@ -602,45 +1584,3 @@ func loadDotenv(path string) {
 		}
 	}
 }
-
-// --- Per-IP rate limiter ---
-
-type ipRateLimiter struct {
-	mu       sync.Mutex
-	limiters map[string]*rate.Limiter
-	r        rate.Limit
-	b        int
-}
-
-func newIPRateLimiter(r rate.Limit, b int) func(http.Handler) http.Handler {
-	rl := &ipRateLimiter{
-		limiters: make(map[string]*rate.Limiter),
-		r:        r,
-		b:        b,
-	}
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ip := r.RemoteAddr
-			// Strip port if present
-			if h, _, err := net.SplitHostPort(ip); err == nil {
-				ip = h
-			}
-			if !rl.get(ip).Allow() {
-				http.Error(w, `{"error":"too many requests"}`, http.StatusTooManyRequests)
-				return
-			}
-			next.ServeHTTP(w, r)
-		})
-	}
-}
-
-func (rl *ipRateLimiter) get(ip string) *rate.Limiter {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-	if l, ok := rl.limiters[ip]; ok {
-		return l
-	}
-	l := rate.NewLimiter(rl.r, rl.b)
-	rl.limiters[ip] = l
-	return l
-}
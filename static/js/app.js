@@ -18,6 +18,12 @@ const App = {
   serverInfoCollapsed: false,
   channelEditMode: false,
   customEmojis: [],      // [{id, name, filename, ...}]
+  stickers: [],          // [{id, name, description, filename, ...}]
+  guilds: [],            // [{id, name, description, owner_id, permissions}]
+  guild: localStorage.getItem('chirm_guild') || 'default',  // the guild being shown
+  readOnly: null,        // {enabled, message, since} while admins have frozen the server
+  clientBuild: 0,        // build of the web app this tab loaded
+  publicSettings: {},    // from /api/v1/public-settings, kept up to date by settings.update
 };
 
 // ─── PERSISTENCE HELPERS ───────────────────────────────────────────────────────
@@ -28,6 +34,18 @@ function _persistUnread() {
   } catch {}
 }
 
+// Tell the server the channel has been read so the service worker's
+// background poll (/api/push/poll) doesn't count it.  Debounced because
+// messages can stream in while the channel is open.
+let _markReadTimer = null;
+function markChannelRead(channelId) {
+  clearTimeout(_markReadTimer);
+  _markReadTimer = setTimeout(() => {
+    api.post(`/api/v1/channels/${channelId}/read`).catch(() => {});
+    if (!App.unread.size) navigator.clearAppBadge?.().catch(() => {});
+  }, 1000);
+}
+
 function _saveLastChannel(channelId) {
   try {
     localStorage.setItem('chirm_last_channel', channelId);
@@ -49,6 +67,7 @@ const api = {
       ...opts,
     });
     const data = await res.json().catch(() => ({}));
+    if (res.status === 503 && data.error === 'maintenance') location.reload();
     if (!res.ok) throw new Error(data.error || `HTTP ${res.status}`);
     return data;
   },
@@ -88,6 +107,11 @@ function esc(s) {
   return String(s).replace(/&/g,'&amp;').replace(/</g,'&lt;').replace(/>/g,'&gt;').replace(/"/g,'&quot;');
 }
 
+// Undo esc(), for text pulled back out of already-escaped markup.
+function unesc(s) {
+  return String(s).replace(/&quot;/g,'"').replace(/&gt;/g,'>').replace(/&lt;/g,'<').replace(/&amp;/g,'&');
+}
+
 function formatTime(dateStr) {
   const d = new Date(dateStr);
   const now = new Date();
@@ -105,6 +129,12 @@ function formatSize(bytes) {
   return `${(bytes/1048576).toFixed(1)} MB`;
 }
 
+function formatDuration(seconds) {
+  const s = Math.round(seconds);
+  const h = Math.floor(s / 3600), m = Math.floor(s / 60) % 60, sec = String(s % 60).padStart(2, '0');
+  return h ? `${h}:${String(m).padStart(2, '0')}:${sec}` : `${m}:${sec}`;
+}
+
 function renderContent(content) {
   // ── Step 0: extract fenced code blocks to protect them from other transforms
   const codeBlocks = [];
@@ -125,7 +155,7 @@ function renderContent(content) {
     // Check custom server emojis
     const custom = App.customEmojis?.find(e => e.name === name.toLowerCase());
     if (custom) {
-      return `<img class="custom-emoji" src="/uploads/${esc(custom.filename)}" alt=":${esc(name)}:" title=":${esc(name)}:">`;
+      return `<img class="custom-emoji" src="${esc(customImageSrc(custom))}" alt=":${esc(name)}:" title=":${esc(name)}:">`;
     }
     // Check standard shortcodes
     const std = EMOJI_SHORTCODES[name] || EMOJI_SHORTCODES[name.toLowerCase()];
@@ -134,8 +164,9 @@ function renderContent(content) {
   });
 
   // ── Step 3: inline images  ![alt](url)
+  // Loaded through the server's image proxy so the host never sees readers.
   s = s.replace(/!\[([^\]]*)\]\((https?:\/\/[^\s)]+)\)/g,
-    (_, alt, url) => `<img class="msg-inline-img" src="${url}" alt="${esc(alt)}" loading="lazy" onclick="openImageViewer('${url}')">`);
+    (_, alt, url) => `<img class="msg-inline-img" src="/api/v1/image-proxy?url=${encodeURIComponent(unesc(url))}" alt="${esc(alt)}" loading="lazy" onclick="openImageViewer(this.src)">`);
 
   // ── Step 4: blockquotes
   s = s.replace(/^&gt; ?(.*)$/gm, '<div class="msg-blockquote">$1</div>');
@@ -205,10 +236,12 @@ function renderContent(content) {
   s = s.replace(/~~(.+?)~~/g, '<del>$1</del>');
 
   // ── Step 14: URLs — match https?:// and bare www. addresses
-  // Track which URLs appear for preview generation (stored on rendered element via data attr)
+  // Track which URLs appear for preview generation (stored on rendered element via data attr).
+  // <https://...> links without a preview.
   const foundURLs = [];
-  s = s.replace(/(?<!href="|src="|">|:\/\/)(https?:\/\/[^\s<>"')\]]+|www\.[a-zA-Z0-9-]+\.[a-zA-Z]{2,}[^\s<>"')\]]*)/g,
-    (match) => {
+  s = s.replace(/(?<!">)&lt;(https?:\/\/[^\s<>"]+?)&gt;|(?<!href="|src="|">|:\/\/|&lt;)(https?:\/\/[^\s<>"')\]]+|www\.[a-zA-Z0-9-]+\.[a-zA-Z]{2,}[^\s<>"')\]]*)/g,
+    (whole, suppressed, match) => {
+      if (suppressed) return `<a href="${suppressed}" target="_blank" rel="noopener" class="msg-link">${suppressed}</a>`;
       const href = match.startsWith('http') ? match : `https://${match}`;
       // Only collect first 2 unique http(s) URLs for previews
       if (foundURLs.length < 2 && href.startsWith('http') && !foundURLs.includes(href)) {
@@ -236,6 +269,11 @@ function renderContent(content) {
   return s;
 }
 
+// Voice and stage channels both join a voice room rather than showing messages.
+function isVoiceChannel(ch) {
+  return ch?.type === 'voice' || ch?.type === 'stage' || ch?.type === 'broadcast';
+}
+
 function isAdmin(user) {
   if (!user) return false;
   if (user.is_owner) return true;
@@ -244,26 +282,47 @@ function isAdmin(user) {
   return (user.permissions & PERM_ADMIN) !== 0 || (user.permissions & PERM_MANAGE_SERVER) !== 0;
 }
 
+// canManageGuild reports whether the user can change the current guild's
+// channels: Administrator or Manage Server there.
+function canManageGuild() {
+  if (App.guild === 'default') return isAdmin(App.user);
+  const g = App.guilds.find(g => g.id === App.guild);
+  return ((g?.permissions || 0) & (64 | 32)) !== 0;
+}
+
 // ─── INIT ─────────────────────────────────────────────────────────────────────
 async function init() {
   // Check setup
-  const status = await api.get('/api/setup/status').catch(() => null);
+  const status = await api.get('/api/v1/setup/status').catch(() => null);
   if (status && !status.setup_done) {
     window.location.href = '/setup';
     return;
   }
 
+  const version = await api.get('/api/v1/client-version').catch(() => null);
+  App.clientBuild = version?.build || 0;
+
   // Check auth
-  App.user = await api.get('/api/me').catch(() => null);
+  App.user = await api.get('/api/v1/me').catch(() => null);
   if (!App.user) {
     window.location.href = '/login';
     return;
   }
 
+  // An invite link opened while signed in joins its guild.
+  const joinCode = new URLSearchParams(location.search).get('join');
+  if (joinCode) {
+    history.replaceState(null, '', '/');
+    const g = await api.post(`/api/v1/guilds/join/${encodeURIComponent(joinCode)}`).catch(e => { toast(e.message, 'error'); return null; });
+    if (g) _setGuild(g.id);
+  }
+
   // Load data
-  await Promise.all([loadChannels(), loadMembers(), loadRoles(), loadVoiceRooms(), loadCustomEmojis()]);
+  await loadGuilds();
+  await Promise.all([loadChannels(), loadMembers(), loadRoles(), loadVoiceRooms(), loadCustomEmojis(), loadStickers(), loadReadOnly(), ChirmSettings.load()]);
 
   // Render UI
+  renderGuildBar();
   renderServerHeader();
   renderChannelList();
   renderUserPanel();
@@ -272,7 +331,12 @@ async function init() {
   // Connect WebSocket
   WS.connect();
   setupWSHandlers();
+  showWelcome();
   Voice.init();
+  Calls.init();
+  window.addEventListener('focus', () => {
+    if (App.currentChannel) markChannelRead(App.currentChannel.id);
+  });
 
   // Init @mention autocomplete
   const msgInput = document.getElementById('message-input');
@@ -281,8 +345,8 @@ async function init() {
   // Restore the channel the user was in before the page refreshed.
   // Fall back to the first text channel if the saved one no longer exists.
   const lastChannelId = _loadLastChannel();
-  const lastChannel   = lastChannelId ? App.channels.find(c => c.id === lastChannelId && c.type !== 'voice') : null;
-  const firstText     = App.channels.find(c => c.type !== 'voice') || App.channels[0];
+  const lastChannel   = lastChannelId ? App.channels.find(c => c.id === lastChannelId && !isVoiceChannel(c)) : null;
+  const firstText     = App.channels.find(c => !isVoiceChannel(c)) || App.channels[0];
   const channelToOpen = lastChannel || firstText;
   if (channelToOpen) {
     openChannel(channelToOpen);
@@ -307,23 +371,33 @@ async function init() {
 }
 
 // ─── DATA LOADING ─────────────────────────────────────────────────────────────
+// guildQuery is the ?guild= for routes that list the current guild's things.
+function guildQuery() {
+  return `?guild=${encodeURIComponent(App.guild)}`;
+}
+
+async function loadGuilds() {
+  App.guilds = await api.get('/api/v1/guilds').catch(() => []);
+  if (!App.guilds.some(g => g.id === App.guild)) _setGuild('default');
+}
+
 async function loadChannels() {
   [App.channels, App.categories] = await Promise.all([
-    api.get('/api/channels').catch(() => []),
-    api.get('/api/channel-categories').catch(() => []),
+    api.get('/api/v1/channels' + guildQuery()).catch(() => []),
+    api.get('/api/v1/channel-categories' + guildQuery()).catch(() => []),
   ]);
 }
 
 async function loadMembers() {
-  App.members = await api.get('/api/members').catch(() => []);
+  App.members = await api.get('/api/v1/members' + guildQuery()).catch(() => []);
 }
 
 async function loadRoles() {
-  App.roles = await api.get('/api/roles').catch(() => []);
+  App.roles = await api.get('/api/v1/roles' + guildQuery()).catch(() => []);
 }
 
 async function loadVoiceRooms() {
-  const data = await api.get('/api/voice/rooms').catch(() => null);
+  const data = await api.get('/api/v1/voice/rooms').catch(() => null);
   if (!data || !data.rooms) return;
   // Populate App.voiceParticipants from the server snapshot
   App.voiceParticipants = {};
@@ -333,37 +407,145 @@ async function loadVoiceRooms() {
 }
 
 async function loadCustomEmojis() {
-  App.customEmojis = await api.get('/api/emojis').catch(() => []);
+  App.customEmojis = await api.get('/api/v1/emojis').catch(() => []);
+}
+
+async function loadStickers() {
+  App.stickers = await api.get('/api/v1/stickers').catch(() => []);
+}
+
+async function loadReadOnly() {
+  App.readOnly = await api.get('/api/v1/read-only').catch(() => null);
 }
 
 async function loadMessages(channelId, before = null) {
-  const url = `/api/channels/${channelId}/messages${before ? `?before=${before}` : ''}`;
+  const url = `/api/v1/channels/${channelId}/messages${before ? `?before=${before}` : ''}`;
   return api.get(url).catch(() => []);
 }
 
 // ─── RENDER ───────────────────────────────────────────────────────────────────
-function renderServerHeader() {
-  api.get('/api/public-settings').then(s => {
-    const name = s.server_name || 'Chirm';
-    const desc = s.server_description || '';
-    const icon = s.server_icon || '';
-
-    document.getElementById('server-name').textContent = name;
-    document.title = name;
-    const descEl = document.getElementById('server-description');
-    descEl.textContent = desc;
-    descEl.style.display = desc ? '' : 'none';
-
-    const iconWrap = document.getElementById('server-icon-display');
-    if (icon) {
-      iconWrap.innerHTML = `<img src="${esc(icon)}" alt="${esc(name)}">`;
-      iconWrap.className = 'server-icon-img';
-    } else {
-      iconWrap.textContent = name[0]?.toUpperCase() || 'C';
-      iconWrap.className = 'server-icon-letter';
-      iconWrap.style.background = stringToColor(name);
-    }
-  }).catch(() => {});
+// renderServerHeader shows the server's name and icon, from s or, without
+// it, fresh public settings.
+function renderServerHeader(s) {
+  if (!s) {
+    api.get('/api/v1/public-settings').then(renderServerHeader).catch(() => {});
+    return;
+  }
+  App.publicSettings = s;
+  document.getElementById('gif-btn').style.display = s.gifs ? '' : 'none';
+  const guild = App.guild !== 'default' && App.guilds.find(g => g.id === App.guild);
+  const name = (guild ? guild.name : s.server_name) || 'Chirm';
+  const desc = (guild ? guild.description : s.server_description) || '';
+  const icon = guild ? '' : s.server_icon || '';
+  renderBanner(s.banner_id ? { id: s.banner_id, text: s.banner_text, severity: s.banner_severity, expires_at: s.banner_expires_at } : null);
+
+  document.getElementById('server-name').textContent = name;
+  document.title = name;
+  const descEl = document.getElementById('server-description');
+  descEl.textContent = desc;
+  descEl.style.display = desc ? '' : 'none';
+
+  const iconWrap = document.getElementById('server-icon-display');
+  if (icon) {
+    iconWrap.innerHTML = `<img src="${esc(icon)}" alt="${esc(name)}">`;
+    iconWrap.className = 'server-icon-img';
+  } else {
+    iconWrap.textContent = name[0]?.toUpperCase() || 'C';
+    iconWrap.className = 'server-icon-letter';
+    iconWrap.style.background = stringToColor(name);
+  }
+}
+
+// The admins' announcement, until it expires or this browser dismisses it.
+let bannerExpiryTimer = null;
+function renderBanner(b) {
+  const el = document.getElementById('announcement-banner');
+  if (!el) return;
+  clearTimeout(bannerExpiryTimer);
+  if (!b || localStorage.getItem('chirm_banner_dismissed') === b.id) {
+    el.style.display = 'none';
+    return;
+  }
+  el.className = `announcement-banner ${b.severity || 'info'}`;
+  el.innerHTML = `
+    <span class="announcement-text">${esc(b.text)}</span>
+    <button class="announcement-dismiss" title="Dismiss" onclick="dismissBanner('${esc(b.id)}')">✕</button>`;
+  el.style.display = '';
+  if (b.expires_at) {
+    const left = new Date(b.expires_at) - Date.now();
+    if (left <= 0) el.style.display = 'none';
+    else if (left < 2 ** 31) bannerExpiryTimer = setTimeout(() => { el.style.display = 'none'; }, left);
+  }
+}
+
+// checkClientVersion reloads the tab if the server says its build of the app
+// no longer works, once any call is over and after a moment's jitter, so
+// every tab doesn't reload at once.  The cached files go first; otherwise
+// the service worker would hand back the old ones.
+let clientReloading = false;
+function checkClientVersion(v) {
+  if (clientReloading || !App.clientBuild || !v || v.min_build <= App.clientBuild) return;
+  clientReloading = true;
+  toast('Chirm has been updated. Reloading…', 'info');
+  const reload = async () => {
+    if (Voice.inCall()) { setTimeout(reload, 5000); return; }
+    try {
+      const keys = await caches.keys();
+      await Promise.all(keys.map(k => caches.delete(k)));
+    } catch {}
+    location.reload();
+  };
+  setTimeout(reload, 1000 + Math.random() * 9000);
+}
+
+// While the server is read-only, everyone but admins gets a disabled
+// composer saying why.
+function renderReadOnly() {
+  const frozen = !!App.readOnly?.enabled && !isAdmin(App.user);
+  const form = document.getElementById('message-form');
+  if (!form) return;
+  form.classList.toggle('read-only', frozen);
+  form.querySelectorAll('textarea, button').forEach(el => { el.disabled = frozen; });
+  const input = document.getElementById('message-input');
+  if (frozen) {
+    input.placeholder = App.readOnly.message
+      ? `The server is read-only: ${App.readOnly.message}`
+      : 'The server is read-only for now';
+  } else if (App.currentChannel) {
+    input.placeholder = `Message #${App.currentChannel.name}`;
+  }
+}
+
+function dismissBanner(id) {
+  localStorage.setItem('chirm_banner_dismissed', id);
+  renderBanner(null);
+}
+
+// showWelcome shows the private welcome the server gave this account when it
+// signed up, then whether the rules still need accepting.
+function showWelcome() {
+  const text = sessionStorage.getItem('chirm_welcome');
+  sessionStorage.removeItem('chirm_welcome');
+  if (!text) { checkRules(); return; }
+  showSimpleModal('Welcome!', `<div style="font-size:14px;line-height:1.6;color:var(--text-secondary)">${renderContent(text)}</div>`, null);
+  document.querySelector('#simple-modal .modal-footer .btn')?.addEventListener('click', checkRules);
+  document.querySelector('#simple-modal .modal-close')?.addEventListener('click', checkRules);
+}
+
+// checkRules asks the user to accept the server rules when they must before
+// posting.
+async function checkRules() {
+  const rules = await api.get('/api/v1/me/rules').catch(() => null);
+  if (!rules?.required || document.getElementById('simple-modal')) return;
+  showSimpleModal('Server Rules',
+    `<div style="max-height:50vh;overflow-y:auto;font-size:14px;line-height:1.6;color:var(--text-secondary)">${renderContent(rules.text)}</div>
+     <p class="text-muted" style="font-size:13px;margin-top:12px">Accept the rules to start posting.</p>`,
+    async () => {
+      await api.post('/api/v1/me/rules/accept', {});
+      toast('Thanks! You can post now.', 'success');
+    });
+  const confirm = document.getElementById('simple-modal-confirm');
+  if (confirm) confirm.textContent = 'I Accept';
 }
 
 function toggleServerInfo() {
@@ -382,7 +564,7 @@ function toggleServerInfo() {
 }
 
 function openServerRules() {
-  api.get('/api/public-settings').then(s => {
+  api.get('/api/v1/public-settings').then(s => {
     const text = (s.agreement_enabled === '1' && s.agreement_text)
       ? s.agreement_text
       : (s.server_description || 'No information set.');
@@ -390,11 +572,182 @@ function openServerRules() {
   });
 }
 
+// ─── SERVER EVENTS ────────────────────────────────────────────────────────────
+const EVENT_RECURRENCE = [['', 'Once'], ['daily', 'Every day'], ['weekly', 'Every week'], ['biweekly', 'Every two weeks'], ['monthly', 'Every month']];
+const EVENT_RSVPS = [['going', 'Going'], ['interested', 'Interested'], ['not_going', 'Can\'t go']];
+
+// canManageEvents reports whether the user can schedule events in the
+// current guild: Administrator or Manage Events there.
+function canManageEvents() {
+  const perms = 64 | 32768;
+  if (App.guild === 'default') return !!App.user?.is_owner || ((App.user?.permissions || 0) & perms) !== 0;
+  const g = App.guilds.find(g => g.id === App.guild);
+  return ((g?.permissions || 0) & perms) !== 0;
+}
+
+async function openEvents() {
+  App.events = await api.get(`/api/v1/events?guild=${encodeURIComponent(App.guild)}`).catch(() => []);
+  showSimpleModal('Events', `
+    ${canManageEvents() ? '<div style="margin-bottom:12px"><button class="btn btn-primary btn-sm" onclick="openEventForm()">＋ New Event</button></div>' : ''}
+    <div id="events-list"></div>
+    ${App.user?.bot ? '' : calendarFeedFields()}`, null);
+  renderEventList();
+}
+
+// The calendar feed, for following the events you're coming to in Google
+// or Apple Calendar.  Its URL is only shown when it's made.
+function calendarFeedFields() {
+  api.get('/api/v1/me/calendar').then(renderCalendarFeed).catch(() => {});
+  return `<div class="form-group" style="margin-top:16px"><label>Calendar Feed</label>
+    <p style="font-size:12px;color:var(--text-muted);margin:0 0 8px">Subscribe to the events you're going to or interested in from Google Calendar, Apple Calendar or Outlook. They update there as events change.</p>
+    <div id="calendar-feed"></div></div>`;
+}
+
+function renderCalendarFeed(feed, url) {
+  const el = document.getElementById('calendar-feed');
+  if (!el) return;
+  const used = feed.last_used ? `last fetched ${new Date(feed.last_used).toLocaleString()}` : 'not fetched yet';
+  el.innerHTML = `
+    ${url ? `<p style="font-size:12px;color:var(--text-muted);margin:0 0 4px">Copy this URL now — it won't be shown again. Anyone with it can see these events.</p>
+      <input type="text" readonly value="${escAttr(url)}" onclick="this.select()">
+      <div style="margin:6px 0"><a href="${escAttr(url.replace(/^https?:/, 'webcal:'))}" class="btn btn-secondary btn-sm">📆 Open in Calendar</a></div>`
+      : feed.enabled ? `<p style="font-size:12px;margin:0 0 6px">📆 Feed on · ${used}</p>` : ''}
+    <div style="display:flex;gap:6px">
+      <button type="button" class="btn btn-secondary btn-sm" onclick="createCalendarFeed(${feed.enabled})">${feed.enabled ? 'New URL' : 'Get Calendar URL'}</button>
+      ${feed.enabled ? '<button type="button" class="btn btn-danger btn-sm" onclick="deleteCalendarFeed()">Turn Off</button>' : ''}
+    </div>`;
+}
+
+async function createCalendarFeed(replacing) {
+  if (replacing && !confirm('Make a new calendar URL? Calendars subscribed to the old one will stop updating.')) return;
+  try {
+    const res = await api.post('/api/v1/me/calendar', {});
+    renderCalendarFeed(res, res.url);
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
+async function deleteCalendarFeed() {
+  if (!confirm('Turn off your calendar feed? Calendars subscribed to it will stop updating.')) return;
+  try {
+    await api.del('/api/v1/me/calendar');
+    renderCalendarFeed({ enabled: false });
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
+function renderEventList() {
+  const el = document.getElementById('events-list');
+  if (!el) return;
+  const events = (App.events || []).filter(e => e.next_start)
+    .sort((a, b) => new Date(a.next_start) - new Date(b.next_start));
+  el.innerHTML = events.length
+    ? events.map(eventCard).join('')
+    : '<p class="text-muted" style="font-size:13px">Nothing planned yet.</p>';
+}
+
+function eventCard(e) {
+  const start = new Date(e.next_start);
+  const when = start <= Date.now()
+    ? '<span style="color:var(--success)">Happening now</span>'
+    : esc(start.toLocaleString([], { weekday: 'short', month: 'short', day: 'numeric', hour: 'numeric', minute: '2-digit' }));
+  const repeat = e.recurrence ? ` · ${esc(EVENT_RECURRENCE.find(([v]) => v === e.recurrence)?.[1] || e.recurrence)}` : '';
+  const ch = e.channel_id && App.channels.find(c => c.id === e.channel_id);
+  const rsvps = EVENT_RSVPS.map(([status, label]) =>
+    `<button class="btn btn-sm ${e.rsvp === status ? 'btn-primary' : 'btn-secondary'}" onclick="rsvpEvent('${e.id}','${status}')">${label}</button>`).join('');
+  return `<div class="event-card" style="border:1px solid var(--border);border-radius:var(--radius);padding:12px;margin-bottom:10px">
+    <div style="display:flex;align-items:flex-start;gap:8px">
+      <div style="flex:1;min-width:0">
+        <div style="font-size:12px;color:var(--text-muted)">${when}${repeat}</div>
+        <div style="font-weight:600;font-size:15px;margin:2px 0">${esc(e.title)}</div>
+        ${ch ? `<div style="font-size:12px;color:var(--text-muted)">${isVoiceChannel(ch) ? '🔊' : '#'} ${esc(ch.name)}</div>` : ''}
+      </div>
+      ${canManageEvents() ? `<button class="btn btn-secondary btn-sm" onclick="openEventForm('${e.id}')">Edit</button>
+        <button class="btn btn-danger btn-sm" onclick="deleteEvent('${e.id}')">Delete</button>` : ''}
+    </div>
+    ${e.description ? `<div style="white-space:pre-wrap;font-size:13px;color:var(--text-secondary);margin:8px 0">${esc(e.description)}</div>` : ''}
+    <div style="display:flex;align-items:center;gap:6px;flex-wrap:wrap;margin-top:8px">
+      ${rsvps}
+      <span style="font-size:12px;color:var(--text-muted);margin-left:auto">${e.going} going${e.interested ? `, ${e.interested} interested` : ''}</span>
+    </div>
+  </div>`;
+}
+
+async function rsvpEvent(id, status) {
+  const ev = App.events?.find(e => e.id === id);
+  try {
+    const updated = await api.put(`/api/v1/events/${id}/rsvp`, { status: ev?.rsvp === status ? '' : status });
+    App.events = (App.events || []).map(e => e.id === id ? updated : e);
+    renderEventList();
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
+// A <select> of [value, label] options, with current added if it's missing.
+function _eventSelect(id, current, options) {
+  if (!options.some(([v]) => v === current)) options.push([current, String(current)]);
+  return `<select id="${id}" style="width:100%;padding:8px 10px;background:var(--bg-input);color:var(--text-primary);border:1px solid var(--border-strong);border-radius:var(--radius-sm);font-family:inherit;font-size:14px">
+    ${options.map(([v, label]) => `<option value="${v}" ${v === current ? 'selected' : ''}>${label}</option>`).join('')}
+  </select>`;
+}
+
+function openEventForm(id) {
+  const e = id ? App.events?.find(x => x.id === id) : null;
+  const start = e ? new Date(e.starts_at) : new Date(Math.ceil(Date.now() / 3600000) * 3600000);
+  const local = new Date(start - start.getTimezoneOffset() * 60000).toISOString().slice(0, 16);
+  const channels = [['', 'None'], ...App.channels.map(c => [c.id, `${isVoiceChannel(c) ? '🔊' : '#'} ${esc(c.name)}`])];
+  const durations = [[0, 'No set end'], [30, '30 minutes'], [60, '1 hour'], [90, '1½ hours'], [120, '2 hours'], [180, '3 hours'], [240, '4 hours'], [480, '8 hours'], [1440, 'All day']];
+  const reminders = [[0, 'No reminder'], [5, '5 minutes before'], [15, '15 minutes before'], [30, '30 minutes before'], [60, '1 hour before'], [1440, '1 day before']];
+  const form = `
+    <div class="form-group"><label>Title</label><input type="text" id="event-title" maxlength="100" value="${escAttr(e?.title || '')}" placeholder="e.g. Game night"></div>
+    <div class="form-group"><label>Description</label><textarea id="event-desc" rows="3" maxlength="2000" style="width:100%">${esc(e?.description || '')}</textarea></div>
+    <div class="form-group" style="display:grid;grid-template-columns:1fr 1fr;gap:8px">
+      <div><label>Starts</label><input type="datetime-local" id="event-start" value="${local}"></div>
+      <div><label>Length</label>${_eventSelect('event-duration', e?.duration ?? 120, durations)}</div>
+    </div>
+    <div class="form-group" style="display:grid;grid-template-columns:1fr 1fr;gap:8px">
+      <div><label>Repeats</label>${_eventSelect('event-recurrence', e?.recurrence || '', EVENT_RECURRENCE.slice())}</div>
+      <div><label>Reminder</label>${_eventSelect('event-reminder', e?.reminder_minutes ?? 15, reminders)}</div>
+    </div>
+    <div class="form-group"><label>Channel</label>${_eventSelect('event-channel', e?.channel_id || '', channels)}</div>`;
+  showSimpleModal(e ? 'Edit Event' : 'New Event', form, async () => {
+    const title = document.getElementById('event-title').value.trim();
+    const startValue = document.getElementById('event-start').value;
+    if (!title) { toast('Title required', 'error'); return false; }
+    if (!startValue) { toast('Start time required', 'error'); return false; }
+    const body = {
+      title,
+      description: document.getElementById('event-desc').value,
+      starts_at: new Date(startValue).toISOString(),
+      duration: parseInt(document.getElementById('event-duration').value),
+      recurrence: document.getElementById('event-recurrence').value,
+      reminder_minutes: parseInt(document.getElementById('event-reminder').value),
+      channel_id: document.getElementById('event-channel').value,
+      timezone: Intl.DateTimeFormat().resolvedOptions().timeZone || 'UTC',
+    };
+    if (e) await api.put(`/api/v1/events/${e.id}`, body);
+    else await api.post('/api/v1/events', { ...body, guild_id: App.guild });
+  });
+}
+
+async function deleteEvent(id) {
+  const ev = App.events?.find(e => e.id === id);
+  if (!confirm(`Cancel ${ev ? `"${ev.title}"` : 'this event'}? Everyone's RSVPs go with it.`)) return;
+  try {
+    await api.del(`/api/v1/events/${id}`);
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
 function renderChannelList() {
   const list = document.getElementById('channels-list');
   list.innerHTML = '';
 
-  const admin = isAdmin(App.user);
+  const admin = canManageGuild();
 
   // Build category map
   const catMap = {};
@@ -414,7 +767,7 @@ function renderChannelList() {
 
   // Helper: render a single channel item
   function makeChannelItem(ch) {
-    const isVoice = ch.type === 'voice';
+    const isVoice = isVoiceChannel(ch);
     const participants = isVoice ? (App.voiceParticipants[ch.id] || new Set()) : null;
     const pCount = participants ? participants.size : 0;
     const inRoom = isVoice && Voice.isInChannel(ch.id);
@@ -424,7 +777,7 @@ function renderChannelList() {
     item.dataset.channelId = ch.id;
     item.dataset.categoryId = ch.category_id || '';
 
-    const defaultIcon = isVoice ? '🔊' : '#';
+    const defaultIcon = ch.type === 'stage' ? '🎙️' : ch.type === 'broadcast' ? '📻' : isVoice ? '🔊' : '#';
     const iconHtml = ch.emoji
       ? `<span class="ch-icon ch-emoji${isVoice ? ' ch-voice-emoji' : ''}">${ch.emoji}${isVoice ? '<span class="voice-badge">🔊</span>' : ''}</span>`
       : `<span class="ch-icon ch-hash">${defaultIcon}</span>`;
@@ -651,7 +1004,7 @@ function onChannelDrop(e) {
   App.channels = [...others, ...inCat];
   renderChannelList();
   const orders = inCat.map((c, i) => ({ id: c.id, position: i, category_id: catId }));
-  api.post('/api/channels/reorder', orders).catch(() => {
+  api.post('/api/v1/channels/reorder', orders).catch(() => {
     toast('Failed to save order', 'error');
     loadChannels().then(renderChannelList);
   });
@@ -669,7 +1022,7 @@ function moveChannelToCategory(chId, newCatId) {
   const pos = App.channels.filter(c => (c.category_id || '') === newCatId && c.id !== chId).length;
   ch.position = pos;
   renderChannelList();
-  api.post('/api/channels/reorder', [{ id: chId, position: pos, category_id: newCatId }]).catch(() => {
+  api.post('/api/v1/channels/reorder', [{ id: chId, position: pos, category_id: newCatId }]).catch(() => {
     toast('Failed to move channel', 'error');
     loadChannels().then(renderChannelList);
   });
@@ -685,9 +1038,9 @@ function onCategoryDrop(fromCatId, toCatId) {
   App.categories = cats;
   renderChannelList();
   const orders = cats.map((c, i) => ({ id: c.id, position: i }));
-  api.post('/api/channel-categories/reorder', orders).catch(() => {
+  api.post('/api/v1/channel-categories/reorder', orders).catch(() => {
     toast('Failed to save category order', 'error');
-    api.get('/api/channel-categories').then(cats => { App.categories = cats; renderChannelList(); });
+    api.get('/api/v1/channel-categories').then(cats => { App.categories = cats; renderChannelList(); });
   });
 }
 
@@ -729,9 +1082,12 @@ function renderMembersList() {
     div.innerHTML = `
       ${avatar(m, 'avatar-sm')}
       <div style="flex:1;min-width:0">
-        <div class="member-name">${esc(m.username)}</div>
+        <div class="member-name">${esc(m.username)}${m.bot ? ' <span class="msg-bot-tag">BOT</span>' : ''}</div>
         ${roleBadge}
       </div>
+      ${m.id !== App.user.id && !m.bot ? `
+        <button class="member-call-btn" onclick="Calls.start('${m.id}')" title="Voice call">📞</button>
+        <button class="member-call-btn" onclick="Calls.start('${m.id}', true)" title="Video call">📹</button>` : ''}
     `;
     return div;
   };
@@ -755,7 +1111,7 @@ function renderMembersList() {
 // ─── CHANNELS ─────────────────────────────────────────────────────────────────
 async function openChannel(ch) {
   // ── Voice channel: join/toggle voice room ──────────────────────────────
-  if (ch.type === 'voice') {
+  if (isVoiceChannel(ch)) {
     if (Voice.isInChannel(ch.id)) {
       // Already in this room — navigate to the full voice view without disconnecting.
       Voice.showFullView();
@@ -764,7 +1120,7 @@ async function openChannel(ch) {
       // Joining a new room: loading screen + getUserMedia will be shown by Voice.join().
       // Update header
       document.getElementById('ch-title').textContent = ch.name;
-      document.getElementById('ch-desc').textContent = ch.description || 'Voice Channel';
+      document.getElementById('ch-desc').textContent = ch.description || (ch.type === 'stage' ? 'Stage Channel' : ch.type === 'broadcast' ? 'Broadcast Channel' : 'Voice Channel');
       // Remove split-view class in case we were in split mode from a prior call
       document.getElementById('main').classList.remove('split-voice');
 
@@ -805,6 +1161,7 @@ async function openChannel(ch) {
   App.currentChannel = ch;
   App.unread.delete(ch.id);
   _persistUnread();
+  markChannelRead(ch.id);
   _saveLastChannel(ch.id);
 
   // Close mobile sidebar when channel selected
@@ -822,6 +1179,7 @@ async function openChannel(ch) {
   document.getElementById('ch-title').textContent = (isMuted ? '🔕 ' : '') + ch.name;
   document.getElementById('ch-desc').textContent = ch.description || '';
   document.getElementById('message-input').placeholder = `Message #${ch.name}`;
+  renderReadOnly();
 
   // Subscribe via WebSocket
   WS.subscribe(ch.id);
@@ -902,16 +1260,74 @@ function renderMessages(channelId) {
   msgs.forEach((msg, i) => {
     const ts = new Date(msg.created_at).getTime();
     const timeDiff = lastTimestamp ? ts - lastTimestamp : Infinity;
-    const isContinued = msg.user_id === lastUserId && timeDiff < 5 * 60 * 1000;
+    const isContinued = messageAuthorKey(msg) === lastUserId && timeDiff < 5 * 60 * 1000;
 
     list.appendChild(renderMessage(msg, isContinued));
 
-    lastUserId = msg.user_id;
+    lastUserId = msg.type ? null : messageAuthorKey(msg);
     lastTimestamp = ts;
   });
 }
 
+// One message attachment.  Videos and audio still being transcoded say so;
+// the original plays in the meantime if the browser can manage it.  Files
+// are fetched from the signed URLs the server hands out.
+function renderAttachment(att) {
+  const url = escInline(att.url || `/uploads/${att.filename}`);
+  const wrap = inner => {
+    const note = att.status === 'queued' || att.status === 'processing'
+      ? '<div class="text-muted text-sm">Processing…</div>' : '';
+    return `<div class="msg-attachment" data-attachment-id="${escInline(att.id)}">${inner}${note}</div>`;
+  };
+  if (att.status === 'evicted') {
+    return wrap(`<div class="msg-file-attachment text-muted">📎 ${escInline(att.original_name)} <span class="text-sm">— deleted to free up server storage</span></div>`);
+  }
+  if (att.kind === 'voice') return wrap(renderVoiceNote(att, url));
+  if (att.mime_type.startsWith('image/')) {
+    // Preview the server's medium copy at its final size so the list
+    // doesn't jump as images load; the viewer opens the original.
+    const medium = url + (url.includes('?') ? '&amp;' : '?') + 'size=medium';
+    let size = '';
+    if (att.width && att.height) {
+      const scale = Math.min(1, 400 / att.width, 300 / att.height);
+      size = ` width="${Math.max(1, Math.round(att.width * scale))}" height="${Math.max(1, Math.round(att.height * scale))}"`;
+    }
+    return wrap(`<img src="${medium}"${size} alt="${escInline(att.original_name)}" data-full="${url}" onclick="openImageViewer(this.dataset.full)" loading="lazy">`);
+  }
+  if (att.mime_type.startsWith('video/')) {
+    // With a poster frame the player needs nothing from the video
+    // itself until it's played.
+    let extra = ' preload="metadata"';
+    if (att.poster) extra = ` poster="${escInline(att.poster_url || `/uploads/${att.poster}`)}" preload="none"`;
+    if (att.width && att.height) {
+      const scale = Math.min(1, 400 / att.width, 300 / att.height);
+      extra += ` width="${Math.max(1, Math.round(att.width * scale))}" height="${Math.max(1, Math.round(att.height * scale))}"`;
+    }
+    if (att.duration) extra += ` title="${formatDuration(att.duration)}"`;
+    return wrap(`<video src="${url}" controls${extra} style="max-width:400px;max-height:300px;border-radius:var(--radius)"></video>`);
+  }
+  // Text and PDFs come with their first lines, so there's a peek at
+  // what's inside before downloading.
+  const preview = att.preview ? `<pre class="msg-file-preview">${esc(att.preview)}</pre>` : '';
+  return wrap(`<a class="msg-file-attachment" href="${url}" target="_blank" download="${escInline(att.original_name)}">📎 ${escInline(att.original_name)} <span class="text-muted text-sm">${formatSize(att.size)}</span></a>${preview}`);
+}
+
+// A message's sticker, if it has one.  Deleted stickers leave a note.
+function renderSticker(msg) {
+  if (!msg.sticker_id) return '';
+  const s = msg.sticker;
+  if (!s) return '<div class="msg-sticker-missing text-muted text-sm">Sticker unavailable</div>';
+  return `<div class="msg-sticker"><img src="${escInline(customImageSrc(s))}" alt="${escInline(s.name)}" title="${escInline(s.description || s.name)}" loading="lazy"></div>`;
+}
+
+// Who a message is from, for grouping: a webhook's messages group by the
+// name they were posted under.
+function messageAuthorKey(msg) {
+  return msg.webhook_id ? `${msg.webhook_id}:${msg.author?.username || ''}` : msg.user_id;
+}
+
 function renderMessage(msg, continued = false) {
+  if (msg.type === 'system' || msg.type === 'welcome') return renderSystemMessage(msg);
   const el = document.createElement('div');
   el.className = `message-group${continued ? ' continued' : ' first-in-group'}`;
   el.dataset.messageId = msg.id;
@@ -919,7 +1335,7 @@ function renderMessage(msg, continued = false) {
   const authorName = msg.author?.username || 'Deleted User';
   const authorColor = stringToColor(msg.author?.username || '');
   const canEdit = msg.user_id === App.user?.id;
-  const canDelete = msg.user_id === App.user?.id || isAdmin(App.user);
+  const canDelete = msg.user_id === App.user?.id || canManageGuild();
 
   // Reply reference
   let replyHtml = '';
@@ -932,18 +1348,7 @@ function renderMessage(msg, continued = false) {
   }
 
   // Attachments
-  let attachmentsHtml = '';
-  if (msg.attachments?.length) {
-    attachmentsHtml = msg.attachments.map(att => {
-      if (att.mime_type.startsWith('image/')) {
-        return `<div class="msg-attachment"><img src="/uploads/${escInline(att.filename)}" alt="${escInline(att.original_name)}" onclick="openImageViewer(this.src)" loading="lazy"></div>`;
-      }
-      if (att.mime_type.startsWith('video/')) {
-        return `<div class="msg-attachment"><video src="/uploads/${escInline(att.filename)}" controls preload="metadata" style="max-width:400px;max-height:300px;border-radius:var(--radius)"></video></div>`;
-      }
-      return `<div class="msg-attachment"><a class="msg-file-attachment" href="/uploads/${escInline(att.filename)}" target="_blank" download="${escInline(att.original_name)}">📎 ${escInline(att.original_name)} <span class="text-muted text-sm">${formatSize(att.size)}</span></a></div>`;
-    }).join('');
-  }
+  const attachmentsHtml = (msg.attachments || []).map(renderAttachment).join('');
 
   // Reactions
   const reactionsHtml = renderReactions(msg);
@@ -955,6 +1360,7 @@ function renderMessage(msg, continued = false) {
   const toolbar = `<div class="msg-toolbar">
     <button class="msg-toolbar-btn" title="React" onclick="openEmojiPicker(event, '${msgIdSafe}')">😊</button>
     <button class="msg-toolbar-btn" title="Reply" onclick="setReply('${msgIdSafe}', '${authorNameEsc}', '${contentPreview}')">↩</button>
+    ${App.publicSettings?.translate && msg.content ? `<button class="msg-toolbar-btn" title="Translate" onclick="translateMessage('${msgIdSafe}')">🌐</button>` : ''}
     ${canEdit ? `<button class="msg-toolbar-btn" title="Edit" onclick="editMessage('${msgIdSafe}')">✎</button>` : ''}
     ${canDelete ? `<button class="msg-toolbar-btn danger" title="Delete" onclick="deleteMessage('${msgIdSafe}')">🗑</button>` : ''}
   </div>`;
@@ -966,18 +1372,76 @@ function renderMessage(msg, continued = false) {
       ${replyHtml}
       ${!continued ? `<div class="msg-header">
         <span class="msg-author" style="color:${authorColor}">${escInline(authorName)}</span>
+        ${msg.author?.bot ? '<span class="msg-bot-tag">BOT</span>' : ''}
         <span class="msg-timestamp">${formatTime(msg.created_at)}</span>
         ${msg.edited_at ? '<span class="msg-edited">(edited)</span>' : ''}
       </div>` : ''}
       <div class="msg-content">${renderContent(msg.content)}</div>
+      ${renderSticker(msg)}
       ${attachmentsHtml}
+      ${renderRichEmbeds(msg)}
       ${reactionsHtml}
     </div>
   `;
 
-  // Async: inject link preview cards for any URLs found during render
-  requestAnimationFrame(() => scheduleLinePreviews(el));
+  // Link preview cards: stored with the message, or for older messages
+  // fetched for the URLs found during render
+  requestAnimationFrame(() => showMessageEmbeds(el, msg));
+
+  return el;
+}
+
+// Embeds an integration attached to its message: a card with a colour bar,
+// author, title, description, fields, images and footer.
+function renderRichEmbeds(msg) {
+  return (msg.rich_embeds || []).map(e => {
+    const color = e.color ? `#${e.color.toString(16).padStart(6, '0')}` : 'var(--border)';
+    const title = e.title
+      ? (e.url
+        ? `<a class="re-title" href="${escAttr(e.url)}" target="_blank" rel="noopener noreferrer">${escInline(e.title)}</a>`
+        : `<div class="re-title">${escInline(e.title)}</div>`)
+      : '';
+    const fields = (e.fields || []).length
+      ? `<div class="re-fields">${e.fields.map(f => `
+          <div class="re-field${f.inline ? ' inline' : ''}">
+            <div class="re-field-name">${escInline(f.name)}</div>
+            <div class="re-field-value">${renderContent(f.value)}</div>
+          </div>`).join('')}</div>`
+      : '';
+    const footer = [e.footer ? escInline(e.footer) : '', e.timestamp ? formatTime(e.timestamp) : '']
+      .filter(Boolean).join(' • ');
+    return `<div class="rich-embed" style="border-left-color:${color}">
+      <div class="re-main">
+        ${e.author ? `<div class="re-author">${escInline(e.author)}</div>` : ''}
+        ${title}
+        ${e.description ? `<div class="re-description">${renderContent(e.description)}</div>` : ''}
+        ${fields}
+        ${e.image ? `<img class="re-image" src="${escAttr(e.image)}" alt="" loading="lazy">` : ''}
+        ${footer ? `<div class="re-footer">${footer}</div>` : ''}
+      </div>
+      ${e.thumbnail ? `<img class="re-thumbnail" src="${escAttr(e.thumbnail)}" alt="" loading="lazy">` : ''}
+    </div>`;
+  }).join('');
+}
 
+// Server-generated notices such as "alice joined General" (voice activity).
+function renderSystemMessage(msg) {
+  const el = document.createElement('div');
+  el.className = 'message-group system-message first-in-group';
+  el.dataset.messageId = msg.id;
+  const canDelete = canManageGuild();
+  // A welcome is the admins' own wording, so it's shown as written rather
+  // than after the member's name.
+  const welcome = msg.type === 'welcome';
+  el.innerHTML = `
+    ${canDelete ? `<div class="msg-toolbar"><button class="msg-toolbar-btn danger" title="Delete" onclick="deleteMessage('${msg.id}')">🗑</button></div>` : ''}
+    <div class="msg-avatar-col"><span class="system-message-icon">${welcome ? '👋' : '🔊'}</span></div>
+    <div class="msg-body">
+      ${welcome ? '' : `<span class="msg-author">${escInline(msg.author?.username || 'Deleted User')}</span>`}
+      <span class="system-message-text">${welcome ? renderContent(msg.content) : escInline(msg.content)}</span>
+      <span class="msg-timestamp">${formatTime(msg.created_at)}</span>
+    </div>
+  `;
   return el;
 }
 
@@ -992,10 +1456,10 @@ async function fetchLinkPreview(url) {
   if (_previewCache.has(url)) return _previewCache.get(url);
   if (_previewInFlight.has(url)) return _previewInFlight.get(url);
 
-  const promise = api.get(`/api/link-preview?url=${encodeURIComponent(url)}`)
+  const promise = api.get(`/api/v1/link-preview?url=${encodeURIComponent(url)}`)
     .then(data => {
       // Only store if it has meaningful content
-      const result = (data.title || data.description) ? data : null;
+      const result = (data.title || data.description || data.embed) ? data : null;
       _previewCache.set(url, result);
       _previewInFlight.delete(url);
       return result;
@@ -1010,6 +1474,41 @@ async function fetchLinkPreview(url) {
   return promise;
 }
 
+// The server fetches previews when a message is sent and stores them on
+// it; until they arrive embeds_pending is set and a message.embed_update
+// brings them.  Messages from before then have no embeds at all.
+function showMessageEmbeds(msgEl, msg) {
+  const body = msgEl.querySelector('.msg-body');
+  if (!body || msg.embeds_pending) return;
+  if (!msg.embeds) {
+    scheduleLinePreviews(msgEl);
+    return;
+  }
+  body.querySelectorAll('.link-preview-card').forEach(c => c.remove());
+  const reactions = body.querySelector('.msg-reactions');
+  for (const data of msg.embeds) {
+    const card = buildPreviewCard(data);
+    // The sender can take the previews off their message.
+    if (msg.id && msg.user_id === App.user?.id) {
+      const dismiss = document.createElement('button');
+      dismiss.className = 'lp-dismiss';
+      dismiss.title = 'Remove preview';
+      dismiss.textContent = '✕';
+      dismiss.onclick = (e) => { e.preventDefault(); e.stopPropagation(); suppressEmbeds(msg.id); };
+      card.appendChild(dismiss);
+    }
+    body.insertBefore(card, reactions);
+  }
+}
+
+async function suppressEmbeds(id) {
+  try {
+    await api.put(`/api/v1/messages/${id}`, { suppress_embeds: true });
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
 function scheduleLinePreviews(msgEl) {
   const trigger = msgEl.querySelector('.link-preview-trigger');
   if (!trigger) return;
@@ -1035,7 +1534,7 @@ async function tryNextPreview(urls, idx, body) {
   }
 
   const data = await fetchLinkPreview(url);
-  if (!data || (!data.title && !data.description)) {
+  if (!data) {
     // Nothing useful — try next URL
     tryNextPreview(urls, idx + 1, body);
     return;
@@ -1058,6 +1557,7 @@ async function tryNextPreview(urls, idx, body) {
 }
 
 function buildPreviewCard(data) {
+  if (data.embed) return buildEmbedCard(data);
   const card = document.createElement('a');
   card.className = 'link-preview-card';
   card.href = data.url;
@@ -1065,6 +1565,11 @@ function buildPreviewCard(data) {
   card.rel = 'noopener';
 
   const hasImage = data.image && !data.image.includes('favicon');
+  // Wide images the server has measured go full width under the text, with
+  // their space kept while they load; others are a thumbnail at the side.
+  const w = data.image_width, h = data.image_height;
+  const large = hasImage && w >= 400 && w / h >= 1.3;
+  if (large) card.classList.add('lp-large');
   const siteLine = data.site_name ? `<span class="lp-site">${escInline(data.site_name)}</span>` : '';
 
   // Favicon
@@ -1079,12 +1584,49 @@ function buildPreviewCard(data) {
       ${data.description ? `<div class="lp-desc">${escInline(data.description)}</div>` : ''}
       <div class="lp-url">${escInline(data.url.replace(/^https?:\/\//, '').slice(0, 60))}</div>
     </div>
-    ${hasImage ? `<div class="lp-image"><img src="${escInline(data.image)}" alt="" loading="lazy" onerror="this.closest('.lp-image').remove()"></div>` : ''}
+    ${hasImage ? `<div class="lp-image"${large ? ` style="aspect-ratio:${w} / ${h}"` : ''}><img src="${escInline(data.image)}" alt="" loading="lazy" onerror="this.closest('.lp-image').remove()"></div>` : ''}
   `;
 
   return card;
 }
 
+// Videos show their thumbnail until played, so the site only hears about
+// people who actually watch.
+function buildEmbedCard(data) {
+  const e = data.embed;
+  const card = document.createElement('div');
+  card.className = 'link-preview-card link-embed';
+  const w = e.width || 16, h = e.height || 9;
+  const site = e.provider || data.site_name;
+  card.innerHTML = `
+    <div class="lp-content">
+      ${site ? `<div class="lp-meta"><span class="lp-site">${escInline(site)}</span></div>` : ''}
+      <a class="lp-title" href="${escInline(data.url)}" target="_blank" rel="noopener">${escInline(data.title || data.url)}</a>
+      ${data.description ? `<div class="lp-desc">${escInline(data.description)}</div>` : ''}
+      <div class="embed-player" style="aspect-ratio:${w} / ${h};max-width:${Math.round(360 * w / h)}px" title="Play">
+        ${data.image ? `<img src="${escInline(data.image)}" alt="" loading="lazy" onerror="this.remove()">` : ''}
+        <button class="embed-play" aria-label="Play">▶</button>
+      </div>
+    </div>
+  `;
+  card.querySelector('.embed-player').addEventListener('click', function () {
+    const src = e.embed_url + (e.embed_url.includes('?') ? '&' : '?') + 'autoplay=1';
+    this.innerHTML = `<iframe src="${escInline(src)}" allow="autoplay; encrypted-media; picture-in-picture; fullscreen" allowfullscreen
+      sandbox="allow-scripts allow-same-origin allow-presentation allow-popups" referrerpolicy="strict-origin-when-cross-origin"></iframe>`;
+  }, { once: true });
+  return card;
+}
+
+// Where to load a custom emoji or sticker from.  Animated ones stay still,
+// on their first frame, for people who've asked their system for reduced
+// motion.
+function customImageSrc(e) {
+  if (e.animated && e.static_filename && window.matchMedia?.('(prefers-reduced-motion: reduce)').matches) {
+    return `/uploads/${e.static_filename}`;
+  }
+  return `/uploads/${e.filename}`;
+}
+
 // Safe inline escaping for use inside HTML attributes within template literals
 function escInline(s) {
   return String(s || '').replace(/&/g,'&amp;').replace(/</g,'&lt;').replace(/>/g,'&gt;').replace(/"/g,'&quot;').replace(/'/g,'&#39;');
@@ -1167,13 +1709,13 @@ function isNearBottom() {
 }
 
 // ─── SEND MESSAGE ─────────────────────────────────────────────────────────────
-let pendingUpload = null;
+let pendingUploads = [];
 
 async function sendMessage() {
   if (!App.currentChannel) return;
   const input = document.getElementById('message-input');
   const content = input.value.trim();
-  if (!content && !pendingUpload) return;
+  if (!content && !pendingUploads.length) return;
 
   input.value = '';
   resizeInput(input);
@@ -1183,14 +1725,15 @@ async function sendMessage() {
 
   try {
     const body = { content, reply_to_id: replyToId };
-    if (pendingUpload) {
-      body.attachments = [pendingUpload.id];
+    if (pendingUploads.length) {
+      body.attachments = pendingUploads.map(a => a.id);
       clearUploadPreview();
     }
-    await api.post(`/api/channels/${App.currentChannel.id}/messages`, body);
+    await api.post(`/api/v1/channels/${App.currentChannel.id}/messages`, body);
   } catch (e) {
     toast(e.message, 'error');
     input.value = content;
+    if (e.message === 'accept the server rules before posting') checkRules();
   }
 }
 
@@ -1218,9 +1761,9 @@ async function toggleReaction(messageId, emoji) {
 
   try {
     if (alreadyReacted) {
-      await api.del(`/api/messages/${messageId}/reactions/${encodeURIComponent(emoji)}`);
+      await api.del(`/api/v1/messages/${messageId}/reactions/${encodeURIComponent(emoji)}`);
     } else {
-      await api.post(`/api/messages/${messageId}/reactions`, { emoji });
+      await api.post(`/api/v1/messages/${messageId}/reactions`, { emoji });
     }
   } catch (e) {
     toast(e.message, 'error');
@@ -1287,7 +1830,7 @@ function buildEmojiPicker(mode, targetMsgId, callback) {
       // Custom emoji grid with image thumbnails
       panel.innerHTML = customEmojis.map(e =>
         `<button class="emoji-btn emoji-btn-custom" onclick="event.stopPropagation(); selectEmoji(':${e.name}:');" title=":${e.name}:">
-          <img src="/uploads/${e.filename}" alt="${e.name}">
+          <img src="${customImageSrc(e)}" alt="${e.name}">
           <span>${e.name}</span>
         </button>`
       ).join('');
@@ -1327,6 +1870,120 @@ function openInputEmojiPicker(event, callback) {
   positionPicker(picker, event.currentTarget, true);
 }
 
+// The sticker picker shares the emoji picker's popup, so opening either
+// closes the other.  Picking a sticker sends it straight away.
+function openStickerPicker(event) {
+  event.stopPropagation();
+  closeEmojiPicker();
+  if (!App.currentChannel) return;
+  const picker = document.createElement('div');
+  picker.id = 'emoji-picker';
+  picker.className = 'emoji-picker';
+  const stickers = App.stickers || [];
+  picker.innerHTML = stickers.length
+    ? `<div class="sticker-picker-grid">${stickers.map(s =>
+        `<button class="sticker-btn" onclick="event.stopPropagation(); sendSticker('${s.id}')" title="${escInline(s.description || s.name)}">
+          <img src="${escInline(customImageSrc(s))}" alt="${escInline(s.name)}" loading="lazy">
+        </button>`).join('')}</div>`
+    : '<p class="text-muted" style="padding:16px;font-size:13px">No stickers yet. Admins can add some under Server Administration.</p>';
+  document.body.appendChild(picker);
+  activeEmojiPickerEl = picker;
+  positionPicker(picker, event.currentTarget, true);
+  setTimeout(() => document.addEventListener('click', closeEmojiPicker, { once: true }), 10);
+}
+
+async function sendSticker(stickerId) {
+  closeEmojiPicker();
+  if (!App.currentChannel) return;
+  const replyToId = App.replyTo?.id || null;
+  clearReply();
+  try {
+    await api.post(`/api/v1/channels/${App.currentChannel.id}/messages`, { content: '', reply_to_id: replyToId, sticker_id: stickerId });
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
+// The GIF picker searches through the server, which asks Tenor or Giphy,
+// and shows their popular GIFs until something is typed.  Picking one sends
+// it as an inline image.
+let gifSearchTimer = null;
+
+function openGifPicker(event) {
+  event.stopPropagation();
+  closeEmojiPicker();
+  if (!App.currentChannel) return;
+  const picker = document.createElement('div');
+  picker.id = 'emoji-picker';
+  picker.className = 'emoji-picker';
+  const provider = App.publicSettings.gifs === 'giphy' ? 'GIPHY' : 'Tenor';
+  picker.innerHTML = `
+    <div class="emoji-search-wrap"><input class="emoji-search" id="gif-search" placeholder="Search ${provider}" autocomplete="off"></div>
+    <div class="gif-picker-grid" id="gif-results"></div>
+    <div class="gif-picker-credit">Powered by ${provider}</div>`;
+  picker.addEventListener('click', e => e.stopPropagation());
+  document.body.appendChild(picker);
+  activeEmojiPickerEl = picker;
+  positionPicker(picker, event.currentTarget, true);
+  const input = picker.querySelector('#gif-search');
+  input.addEventListener('input', () => {
+    clearTimeout(gifSearchTimer);
+    gifSearchTimer = setTimeout(() => searchGifs(input.value), 350);
+  });
+  input.focus();
+  searchGifs('');
+  setTimeout(() => document.addEventListener('click', closeEmojiPicker, { once: true }), 10);
+}
+
+async function searchGifs(query, pos = '') {
+  const grid = document.getElementById('gif-results');
+  if (!grid) return;
+  if (!pos) grid.innerHTML = '<p class="text-muted" style="padding:8px;font-size:13px">Searching…</p>';
+  let page;
+  try {
+    page = await api.get(`/api/v1/gifs/search?q=${encodeURIComponent(query.trim())}${pos ? `&pos=${encodeURIComponent(pos)}` : ''}`);
+  } catch (e) {
+    grid.innerHTML = `<p class="text-muted" style="padding:8px;font-size:13px">${esc(e.message)}</p>`;
+    return;
+  }
+  if (document.getElementById('gif-search')?.value !== query) return; // typed on since
+  if (!pos) grid.innerHTML = '';
+  grid.querySelector('.gif-more')?.remove();
+  if (!page.results.length && !pos) {
+    grid.innerHTML = '<p class="text-muted" style="padding:8px;font-size:13px">No GIFs found.</p>';
+    return;
+  }
+  for (const g of page.results) {
+    const btn = document.createElement('button');
+    btn.className = 'gif-btn';
+    btn.title = g.title || '';
+    btn.innerHTML = `<img src="${escInline(g.preview)}" alt="${escInline(g.title || 'GIF')}" loading="lazy">`;
+    btn.onclick = () => sendGif(g);
+    grid.appendChild(btn);
+  }
+  if (page.next) {
+    const more = document.createElement('button');
+    more.className = 'btn btn-sm btn-secondary gif-more';
+    more.style.gridColumn = '1 / -1';
+    more.textContent = 'More';
+    more.onclick = () => searchGifs(query, page.next);
+    grid.appendChild(more);
+  }
+}
+
+async function sendGif(gif) {
+  closeEmojiPicker();
+  if (!App.currentChannel) return;
+  const replyToId = App.replyTo?.id || null;
+  clearReply();
+  const alt = (gif.title || 'GIF').replace(/[\[\]]/g, '');
+  try {
+    await api.post(`/api/v1/channels/${App.currentChannel.id}/messages`, { content: `![${alt}](${gif.url})`, reply_to_id: replyToId });
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
 function positionPicker(picker, anchor, preferLeft) {
   const rect = anchor.getBoundingClientRect();
   const pickerW = 300, pickerH = 300;
@@ -1379,7 +2036,7 @@ function filterEmojis(query) {
   (App.customEmojis || []).forEach(e => {
     if (e.name.includes(q)) {
       hits.push(`<button class="emoji-btn emoji-btn-custom" onclick="event.stopPropagation(); selectEmoji(':${e.name}:');" title=":${e.name}:">
-        <img src="/uploads/${e.filename}" alt="${e.name}"><span>${e.name}</span></button>`);
+        <img src="${customImageSrc(e)}" alt="${e.name}"><span>${e.name}</span></button>`);
     }
   });
 
@@ -1469,7 +2126,7 @@ function editMessage(id) {
       el.removeEventListener('keydown', handler);
       if (newContent && newContent !== original.content) {
         try {
-          await api.put(`/api/messages/${id}`, { content: newContent });
+          await api.put(`/api/v1/messages/${id}`, { content: newContent });
         } catch (err) {
           toast(err.message, 'error');
           el.textContent = renderContent(original.content);
@@ -1486,81 +2143,281 @@ function editMessage(id) {
   });
 }
 
+// translateMessage shows a message's text in the reader's language under
+// it, or hides it again.
+async function translateMessage(id) {
+  const el = document.querySelector(`.message-group[data-message-id="${id}"]`);
+  if (!el) return;
+  const shown = el.querySelector('.msg-translation');
+  if (shown) { shown.remove(); return; }
+  try {
+    const t = await api.post(`/api/v1/messages/${id}/translate`, { target: navigator.language || 'en' });
+    const from = t.source_lang ? ` from ${esc(t.source_lang.toUpperCase())}` : '';
+    el.querySelector('.msg-content')?.insertAdjacentHTML('afterend', `<div class="msg-translation">
+      <div class="msg-translation-label">🌐 Translated${from}</div>
+      <div>${renderContent(t.content)}</div>
+    </div>`);
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
 async function deleteMessage(id) {
   if (!confirm('Delete this message?')) return;
   try {
-    await api.del(`/api/messages/${id}`);
+    await api.del(`/api/v1/messages/${id}`);
   } catch (e) {
     toast(e.message, 'error');
   }
 }
 
 // ─── FILE UPLOAD ──────────────────────────────────────────────────────────────
-async function handleFileUpload(file) {
-  if (!file) return;
+// Files are sent together to /api/uploads, which streams a line of JSON as
+// each one is stored, so the toast can show how far along an album is.
+const MAX_UPLOAD_BATCH = 10;
+
+function handleFileUpload(files) {
+  files = Array.from(files || []);
+  if (!files.length) return;
+  if (pendingUploads.length + files.length > MAX_UPLOAD_BATCH) {
+    toast(`You can attach up to ${MAX_UPLOAD_BATCH} files at once`, 'error');
+    return;
+  }
+  const maxMB = Number(App.publicSettings.max_upload_mb) || 0;
+  const tooBig = maxMB && files.find(f => f.size > maxMB * 1024 * 1024);
+  if (tooBig) {
+    toast(`${tooBig.name} is too large (the limit is ${maxMB} MB)`, 'error');
+    return;
+  }
 
   const formData = new FormData();
-  formData.append('file', file);
+  files.forEach(f => formData.append('files', f));
 
   const toast_el = document.createElement('div');
   toast_el.className = 'toast info';
-  toast_el.textContent = `Uploading ${file.name}…`;
+  const label = files.length === 1 ? files[0].name : `${files.length} files`;
+  toast_el.textContent = `Uploading ${label}…`;
   document.getElementById('toast-container').appendChild(toast_el);
 
-  try {
-    const res = await fetch('/api/upload', {
-      method: 'POST',
-      credentials: 'include',
-      body: formData,
-    });
+  const xhr = new XMLHttpRequest();
+  xhr.open('POST', '/api/v1/uploads');
+  xhr.withCredentials = true;
+  xhr.setRequestHeader('Accept', 'application/x-ndjson');
+
+  let read = 0;
+  let stored = 0;
+  // readLines handles each complete line of the response seen so far.
+  const readLines = () => {
+    const text = xhr.responseText;
+    let nl;
+    while ((nl = text.indexOf('\n', read)) !== -1) {
+      const line = text.slice(read, nl);
+      read = nl + 1;
+      if (!line.trim()) continue;
+      let ev;
+      try { ev = JSON.parse(line); } catch { continue; }
+      if (ev.done) continue;
+      if (ev.attachment) {
+        stored++;
+        pendingUploads.push(ev.attachment);
+        showUploadPreview(ev.attachment, files[ev.index]);
+      } else if (ev.error) {
+        toast(`${ev.name}: ${ev.error}`, 'error');
+      }
+      toast_el.textContent = `Processed ${ev.index + 1} of ${ev.total}…`;
+    }
+  };
+
+  xhr.upload.onprogress = (e) => {
+    if (!e.lengthComputable) return;
+    const pct = Math.round(e.loaded / e.total * 100);
+    toast_el.textContent = pct < 100 ? `Uploading ${label}… ${pct}%` : `Processing ${label}…`;
+  };
+  xhr.onprogress = readLines;
+  xhr.onload = () => {
     toast_el.remove();
-    if (!res.ok) {
-      const data = await res.json();
-      throw new Error(data.error);
+    if (xhr.status >= 400) {
+      let msg = `HTTP ${xhr.status}`;
+      try { msg = JSON.parse(xhr.responseText).error || msg; } catch {}
+      toast(msg, 'error');
+      return;
     }
-    const att = await res.json();
-    pendingUpload = att;
-    showUploadPreview(att, file);
-  } catch (e) {
+    readLines();
+    if (files.length > 1 && stored) toast(`Attached ${stored} of ${files.length} files`, stored === files.length ? 'success' : 'info');
+  };
+  xhr.onerror = () => {
     toast_el.remove();
-    toast(e.message, 'error');
-  }
+    toast('Upload failed', 'error');
+  };
+  xhr.send(formData);
 }
 
 function showUploadPreview(att, file) {
   const preview = document.getElementById('upload-preview');
   preview.style.display = 'flex';
-  if (att.mime_type.startsWith('image/')) {
+  const item = document.createElement('div');
+  item.className = 'upload-preview-item';
+  item.dataset.id = att.id;
+  const remove = `<button onclick="removePendingUpload('${att.id}')" title="Remove" style="background:none;border:none;cursor:pointer;color:var(--text-muted);font-size:18px">✕</button>`;
+  if (att.kind === 'voice') {
+    item.innerHTML = `
+      <span>🎤</span>
+      <span style="font-size:13px;color:var(--text-secondary)">Voice note (${formatDuration(att.duration || 0)})</span>
+      ${remove}
+    `;
+  } else if (att.mime_type.startsWith('image/') && file) {
     const reader = new FileReader();
     reader.onload = (e) => {
-      preview.innerHTML = `
+      item.innerHTML = `
         <img src="${e.target.result}" style="max-height:80px;border-radius:6px">
-        <span style="font-size:13px;color:var(--text-secondary)">${esc(file.name)}</span>
-        <button onclick="clearUploadPreview()" style="margin-left:auto;background:none;border:none;cursor:pointer;color:var(--text-muted);font-size:18px">✕</button>
+        <span style="font-size:13px;color:var(--text-secondary)">${esc(att.original_name)}</span>
+        ${remove}
       `;
     };
     reader.readAsDataURL(file);
   } else {
-    preview.innerHTML = `
+    item.innerHTML = `
       <span>📎</span>
-      <span style="font-size:13px;color:var(--text-secondary)">${esc(file.name)} (${formatSize(att.size)})</span>
-      <button onclick="clearUploadPreview()" style="margin-left:auto;background:none;border:none;cursor:pointer;color:var(--text-muted);font-size:18px">✕</button>
+      <span style="font-size:13px;color:var(--text-secondary)">${esc(att.original_name)} (${formatSize(att.size)})</span>
+      ${remove}
     `;
   }
+  preview.appendChild(item);
+}
+
+function removePendingUpload(id) {
+  pendingUploads = pendingUploads.filter(a => a.id !== id);
+  document.querySelector(`#upload-preview .upload-preview-item[data-id="${id}"]`)?.remove();
+  if (!pendingUploads.length) clearUploadPreview();
 }
 
 function clearUploadPreview() {
-  pendingUpload = null;
+  pendingUploads = [];
   const preview = document.getElementById('upload-preview');
   preview.style.display = 'none';
   preview.innerHTML = '';
 }
 
-function openImageViewer(src) {
-  // Close any open sidebars first so their overlay doesn't conflict
-  closeAllPanels();
+// ─── VOICE NOTES ──────────────────────────────────────────────────────────────
+// The mic button records a voice note with MediaRecorder; it's uploaded
+// with kind=voice and waits in the preview strip like any attachment.
+let voiceRecorder = null;
 
-  const viewer = document.createElement('div');
+async function toggleVoiceRecording() {
+  const btn = document.getElementById('voice-btn');
+  if (voiceRecorder) {
+    voiceRecorder.stop();
+    return;
+  }
+  if (!window.MediaRecorder) { toast('Recording is not supported in this browser', 'error'); return; }
+  if (pendingUploads.length >= MAX_UPLOAD_BATCH) {
+    toast(`You can attach up to ${MAX_UPLOAD_BATCH} files at once`, 'error');
+    return;
+  }
+  let stream;
+  try {
+    stream = await navigator.mediaDevices.getUserMedia({ audio: true });
+  } catch {
+    toast('Microphone access was denied', 'error');
+    return;
+  }
+  const type = ['audio/webm;codecs=opus', 'audio/ogg;codecs=opus'].find(t => MediaRecorder.isTypeSupported(t));
+  if (!type) {
+    stream.getTracks().forEach(t => t.stop());
+    toast('This browser cannot record Opus audio', 'error');
+    return;
+  }
+  const chunks = [];
+  const recorder = new MediaRecorder(stream, { mimeType: type });
+  recorder.ondataavailable = (e) => { if (e.data.size) chunks.push(e.data); };
+  recorder.onstop = () => {
+    stream.getTracks().forEach(t => t.stop());
+    clearTimeout(recorder.limit);
+    voiceRecorder = null;
+    btn.classList.remove('recording');
+    btn.title = 'Record a voice note';
+    const ext = type.startsWith('audio/ogg') ? 'ogg' : 'webm';
+    uploadVoiceNote(new File(chunks, `voice-note.${ext}`, { type: type.split(';')[0] }));
+  };
+  recorder.start();
+  // The server refuses voice notes over five minutes.
+  recorder.limit = setTimeout(() => recorder.state === 'recording' && recorder.stop(), 5 * 60 * 1000);
+  voiceRecorder = recorder;
+  btn.classList.add('recording');
+  btn.title = 'Stop recording';
+}
+
+async function uploadVoiceNote(file) {
+  const formData = new FormData();
+  formData.append('file', file);
+  formData.append('kind', 'voice');
+  try {
+    const res = await fetch('/api/v1/upload', { method: 'POST', credentials: 'include', body: formData });
+    const data = await res.json().catch(() => ({}));
+    if (!res.ok) throw new Error(data.error || `HTTP ${res.status}`);
+    pendingUploads.push(data);
+    showUploadPreview(data, file);
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
+// Voice notes play inline over the waveform the server measured.
+function renderVoiceNote(att, url) {
+  const bars = (att.waveform || []).map(p => `<span style="height:${Math.max(8, p)}%"></span>`).join('');
+  return `<div class="voice-note" data-duration="${att.duration || 0}">
+    <button class="voice-note-play" onclick="toggleVoiceNote(this)" title="Play">▶</button>
+    <div class="voice-note-wave" onclick="seekVoiceNote(event, this)">${bars}</div>
+    <span class="voice-note-time">${formatDuration(att.duration || 0)}</span>
+    <audio src="${url}" preload="none"></audio>
+  </div>`;
+}
+
+function voiceNoteAudio(note) {
+  const audio = note.querySelector('audio');
+  if (audio.dataset.bound) return audio;
+  audio.dataset.bound = '1';
+  const btn = note.querySelector('.voice-note-play');
+  const bars = note.querySelectorAll('.voice-note-wave span');
+  const time = note.querySelector('.voice-note-time');
+  const total = parseFloat(note.dataset.duration) || 0;
+  audio.addEventListener('play', () => { btn.textContent = '❚❚'; btn.title = 'Pause'; });
+  audio.addEventListener('pause', () => { btn.textContent = '▶'; btn.title = 'Play'; });
+  audio.addEventListener('timeupdate', () => {
+    const length = isFinite(audio.duration) ? audio.duration : total;
+    const played = length ? audio.currentTime / length : 0;
+    bars.forEach((b, i) => b.classList.toggle('played', i < played * bars.length));
+    time.textContent = formatDuration(audio.currentTime);
+  });
+  audio.addEventListener('ended', () => {
+    bars.forEach(b => b.classList.remove('played'));
+    time.textContent = formatDuration(total);
+  });
+  return audio;
+}
+
+function toggleVoiceNote(btn) {
+  const audio = voiceNoteAudio(btn.closest('.voice-note'));
+  if (!audio.paused) { audio.pause(); return; }
+  document.querySelectorAll('.voice-note audio').forEach(a => { if (a !== audio) a.pause(); });
+  audio.play().catch(() => toast('Could not play voice note', 'error'));
+}
+
+function seekVoiceNote(e, wave) {
+  const note = wave.closest('.voice-note');
+  const audio = voiceNoteAudio(note);
+  const rect = wave.getBoundingClientRect();
+  const frac = Math.min(1, Math.max(0, (e.clientX - rect.left) / rect.width));
+  const length = isFinite(audio.duration) ? audio.duration : parseFloat(note.dataset.duration) || 0;
+  audio.currentTime = frac * length;
+  if (audio.paused) toggleVoiceNote(note.querySelector('.voice-note-play'));
+}
+
+function openImageViewer(src) {
+  // Close any open sidebars first so their overlay doesn't conflict
+  closeAllPanels();
+
+  const viewer = document.createElement('div');
   viewer.id = 'img-viewer';
   viewer.innerHTML = `
     <div id="img-viewer-bg"></div>
@@ -1823,11 +2680,12 @@ function setupWSHandlers() {
 
     if (isCurrentChannel && pageVisible && pageHasFocus) {
       // User is actively watching this channel — just render the message
+      markChannelRead(channelId);
       const nearBottom = isNearBottom();
       const list = document.getElementById('messages-list');
       const ts = new Date(msg.created_at).getTime();
       const prevTs = prev ? new Date(prev.created_at).getTime() : 0;
-      const continued = !!prev && prev.user_id === msg.user_id && ts - prevTs < 5 * 60 * 1000;
+      const continued = !!prev && !prev.type && messageAuthorKey(prev) === messageAuthorKey(msg) && ts - prevTs < 5 * 60 * 1000;
       list.appendChild(renderMessage(msg, continued));
       if (nearBottom) scrollToBottom();
     } else {
@@ -1842,13 +2700,13 @@ function setupWSHandlers() {
         const list = document.getElementById('messages-list');
         const ts = new Date(msg.created_at).getTime();
         const prevTs = prev ? new Date(prev.created_at).getTime() : 0;
-        const continued = !!prev && prev.user_id === msg.user_id && ts - prevTs < 5 * 60 * 1000;
+        const continued = !!prev && !prev.type && messageAuthorKey(prev) === messageAuthorKey(msg) && ts - prevTs < 5 * 60 * 1000;
         list.appendChild(renderMessage(msg, continued));
         if (nearBottom) scrollToBottom();
       }
 
       // Trigger notification (handles mute / mention / visibility logic internally)
-      if (typeof ChirmNotifs !== 'undefined') {
+      if (typeof ChirmNotifs !== 'undefined' && !msg.type) {
         const ch = App.channels.find(c => c.id === channelId);
         ChirmNotifs.onNewMessage(msg, ch?.name || 'channel');
       }
@@ -1871,10 +2729,42 @@ function setupWSHandlers() {
         if (header && !header.querySelector('.msg-edited')) {
           header.innerHTML += '<span class="msg-edited">(edited)</span>';
         }
+        if (msg.embeds_pending || msg.embeds) {
+          el.querySelectorAll('.link-preview-card').forEach(c => c.remove());
+          showMessageEmbeds(el, msg);
+        }
       }
     }
   });
 
+  // A message's link previews are ready.
+  WS.on('message.embed_update', ({ id, channel_id, embeds }) => {
+    const msg = App.messages[channel_id]?.find(m => m.id === id);
+    if (msg) {
+      msg.embeds = embeds;
+      delete msg.embeds_pending;
+      if (typeof ChirmCache !== 'undefined') ChirmCache.updateMessage(channel_id, msg);
+    }
+    const el = document.querySelector(`[data-message-id="${id}"]`);
+    if (el) showMessageEmbeds(el, msg || { embeds });
+  });
+
+  // A transcoded attachment has a new file, or its progress changed.
+  WS.on('attachment.update', ({ channel_id, message_id, attachment }) => {
+    const msg = App.messages[channel_id]?.find(m => m.id === message_id);
+    if (msg?.attachments) {
+      const idx = msg.attachments.findIndex(a => a.id === attachment.id);
+      if (idx >= 0) msg.attachments[idx] = attachment;
+      if (typeof ChirmCache !== 'undefined') ChirmCache.updateMessage(channel_id, msg);
+    }
+    const el = document.querySelector(`[data-message-id="${message_id}"] [data-attachment-id="${attachment.id}"]`);
+    if (el) el.outerHTML = renderAttachment(attachment);
+  });
+
+  WS.on('upload.quarantined', ({ username, original_name, signature }) => {
+    toast(`🛡 ${username}'s upload "${original_name}" was quarantined: ${signature}`, 'error');
+  });
+
   WS.on('message.delete', ({ id, channel_id }) => {
     if (App.messages[channel_id]) {
       App.messages[channel_id] = App.messages[channel_id].filter(m => m.id !== id);
@@ -1905,7 +2795,50 @@ function setupWSHandlers() {
     App.customEmojis = App.customEmojis.filter(e => e.id !== id);
   });
 
+  WS.on('sticker.new', (sticker) => {
+    if (!App.stickers.find(s => s.id === sticker.id)) {
+      App.stickers.push(sticker);
+      App.stickers.sort((a, b) => a.name.localeCompare(b.name));
+    }
+  });
+
+  WS.on('sticker.delete', ({ id }) => {
+    App.stickers = App.stickers.filter(s => s.id !== id);
+  });
+
+  WS.on('event.new', (ev) => {
+    if (!App.events || ev.guild_id !== App.guild) return;
+    if (!App.events.find(e => e.id === ev.id)) App.events.push(ev);
+    renderEventList();
+  });
+
+  WS.on('event.update', (ev) => {
+    if (!App.events) return;
+    App.events = App.events.map(e => e.id === ev.id ? { ...ev, rsvp: e.rsvp } : e);
+    renderEventList();
+  });
+
+  WS.on('event.delete', ({ id }) => {
+    if (!App.events) return;
+    App.events = App.events.filter(e => e.id !== id);
+    renderEventList();
+  });
+
+  WS.on('event.rsvp', ({ event_id, user_id, status, going, interested }) => {
+    const ev = App.events?.find(e => e.id === event_id);
+    if (!ev) return;
+    Object.assign(ev, { going, interested });
+    if (user_id === App.user?.id) ev.rsvp = status;
+    renderEventList();
+  });
+
+  WS.on('event.reminder', (ev) => {
+    const mins = Math.max(0, Math.round((new Date(ev.next_start) - Date.now()) / 60000));
+    toast(`📅 ${ev.title} starts ${mins ? `in ${mins} minute${mins !== 1 ? 's' : ''}` : 'now'}`);
+  });
+
   WS.on('channel.new', (ch) => {
+    if (!inCurrentGuild(ch)) return;
     App.channels.push(ch);
     renderChannelList();
   });
@@ -1932,21 +2865,25 @@ function setupWSHandlers() {
   });
 
   WS.on('channels.reorder', (channels) => {
+    if (channels.length && !inCurrentGuild(channels[0])) return;
     App.channels = channels;
     renderChannelList();
   });
 
   WS.on('category.new', (cat) => {
+    if (!inCurrentGuild(cat)) return;
     App.categories.push(cat);
     renderChannelList();
   });
 
   WS.on('categories.update', (cats) => {
+    if (cats.length && !inCurrentGuild(cats[0])) return;
     App.categories = cats;
     renderChannelList();
   });
 
-  WS.on('category.delete', ({ id, channels }) => {
+  WS.on('category.delete', ({ id, guild_id, channels }) => {
+    if (!inCurrentGuild({ guild_id })) return;
     App.categories = App.categories.filter(c => c.id !== id);
     if (channels) App.channels = channels;
     renderChannelList();
@@ -1956,11 +2893,60 @@ function setupWSHandlers() {
   // ── Live member list updates ─────────────────────────────────────────────
   WS.on('member.new', (member) => {
     // Ignore if we already have this member (e.g. our own registration echo)
-    if (App.members.find(m => m.id === member.id)) return;
+    if (!inCurrentGuild(member) || App.members.find(m => m.id === member.id)) return;
     App.members.push(member);
     renderMembersList();
   });
 
+  WS.on('member.leave', ({ guild_id, id }) => {
+    if (!inCurrentGuild({ guild_id })) return;
+    App.members = App.members.filter(m => m.id !== id);
+    renderMembersList();
+  });
+
+  WS.on('guild.new', () => loadGuilds().then(renderGuildBar));
+
+  WS.on('guild.update', (g) => {
+    const idx = App.guilds.findIndex(x => x.id === g.id);
+    if (idx >= 0) App.guilds[idx] = { ...App.guilds[idx], ...g };
+    renderGuildBar();
+    if (g.id === App.guild) renderServerHeader();
+  });
+
+  WS.on('guild.delete', ({ id }) => {
+    App.guilds = App.guilds.filter(g => g.id !== id);
+    if (id === App.guild) switchGuild('default');
+    else renderGuildBar();
+  });
+
+  WS.on('settings.banner', (b) => renderBanner(b));
+  WS.on('settings.update', (s) => renderServerHeader(s));
+
+  WS.on('maintenance', ({ enabled }) => {
+    toast(enabled ? 'Maintenance mode is on: only admins can use the server' : 'Maintenance mode is off', enabled ? 'error' : 'info');
+    if (document.getElementById('maintenance-enabled')) renderAdminAccess();
+  });
+
+  WS.on('automod.timeout', ({ until, reason }) => {
+    if (until) toast(`You're timed out until ${formatTime(until)}: ${reason}`, 'error');
+    else toast('Your timeout has ended', 'info');
+  });
+
+  WS.on('client.reload', checkClientVersion);
+  WS.on('ws.connected', () => {
+    api.get('/api/v1/client-version').then(checkClientVersion).catch(() => {});
+  });
+
+  WS.on('read_only', (m) => {
+    const was = !!App.readOnly?.enabled;
+    App.readOnly = m;
+    renderReadOnly();
+    if (m.enabled !== was) {
+      toast(m.enabled ? 'The server is read-only for now' : 'The server is open again', m.enabled ? 'error' : 'info');
+    }
+    if (document.getElementById('read-only-enabled')) renderAdminAccess();
+  });
+
   WS.on('typing', ({ user_id, channel_id }) => {
     if (user_id === App.user.id) return;
     if (!App.typingUsers[channel_id]) App.typingUsers[channel_id] = {};
@@ -2031,6 +3017,97 @@ function resizeInput(el) {
   el.style.height = Math.min(el.scrollHeight, 200) + 'px';
 }
 
+// ─── GUILDS ───────────────────────────────────────────────────────────────────
+function _setGuild(id) {
+  App.guild = id;
+  try { localStorage.setItem('chirm_guild', id); } catch {}
+}
+
+// inCurrentGuild reports whether a channel, category or member event is for
+// the guild being shown.  Events from before guilds have no guild_id.
+function inCurrentGuild(obj) {
+  return (obj?.guild_id || 'default') === App.guild;
+}
+
+// The guild bar only shows once there's more than one guild to pick from,
+// or for admins, who can make one.
+function renderGuildBar() {
+  const bar = document.getElementById('guild-bar');
+  if (!bar) return;
+  const canCreate = isAdmin(App.user);
+  if (App.guilds.length < 2 && !canCreate) { bar.style.display = 'none'; return; }
+  bar.style.display = '';
+  const current = App.guilds.find(g => g.id === App.guild);
+  bar.innerHTML = App.guilds.map(g => {
+    const name = g.name || 'Chirm';
+    return `<button class="guild-pill${g.id === App.guild ? ' active' : ''}" title="${esc(name)}"
+      style="background:${stringToColor(name)}" onclick="switchGuild('${esc(g.id)}')">${esc(name[0].toUpperCase())}</button>`;
+  }).join('') +
+    (canCreate ? `<button class="guild-pill guild-action" title="Create a guild" onclick="openCreateGuild()">+</button>` : '') +
+    (App.guild !== 'default' ? `<span class="guild-bar-spacer"></span>
+      <button class="guild-pill guild-action" title="Copy an invite link" onclick="copyGuildInvite()">🔗</button>
+      <button class="guild-pill guild-action" title="${current?.owner_id === App.user.id ? 'Delete this guild' : 'Leave this guild'}" onclick="leaveGuild()">⎋</button>` : '');
+}
+
+async function switchGuild(id) {
+  if (id === App.guild) return;
+  _setGuild(id);
+  App.currentChannel = null;
+  document.getElementById('messages-list').innerHTML = '';
+  await Promise.all([loadChannels(), loadMembers(), loadRoles()]);
+  renderGuildBar();
+  renderServerHeader();
+  renderChannelList();
+  renderMembersList();
+  const first = App.channels.find(c => !isVoiceChannel(c)) || App.channels[0];
+  if (first) openChannel(first);
+}
+
+function openCreateGuild() {
+  const form = `
+    <div class="form-group"><label>Name</label><input type="text" id="new-guild-name" maxlength="100" placeholder="e.g. Book Club"></div>
+    <div class="form-group"><label>Description</label><input type="text" id="new-guild-desc" placeholder="Optional"></div>
+    <div class="form-group"><label>Starting Layout</label><select id="new-guild-template"><option value="default">Just the basics</option></select></div>
+  `;
+  showSimpleModal('Create Guild', form, async () => {
+    const name = document.getElementById('new-guild-name').value.trim();
+    if (!name) { toast('Name required', 'error'); return false; }
+    const g = await api.post('/api/v1/guilds', { name, description: document.getElementById('new-guild-desc').value });
+    const template = document.getElementById('new-guild-template').value;
+    if (template !== 'default') await api.post(`/api/v1/guilds/${g.id}/template`, { template }).catch(e => toast(e.message, 'error'));
+    await loadGuilds();
+    switchGuild(g.id);
+  });
+  fillTemplateSelect('new-guild-template');
+}
+
+// fillTemplateSelect lists the server templates in the select with id.
+async function fillTemplateSelect(id) {
+  const templates = await api.get('/api/v1/setup/templates').catch(() => []);
+  const sel = document.getElementById(id);
+  if (sel && templates.length) sel.replaceChildren(...templates.map(t => new Option(`${t.name} — ${t.description}`, t.id)));
+}
+
+async function copyGuildInvite() {
+  try {
+    const inv = await api.post('/api/v1/invites', { max_uses: 0, guild_id: App.guild });
+    copyInvite(`${window.location.origin}/login?invite=${inv.code}`);
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+async function leaveGuild() {
+  const g = App.guilds.find(g => g.id === App.guild);
+  if (!g) return;
+  const owner = g.owner_id === App.user.id;
+  if (!confirm(owner ? `Delete ${g.name} and everything in it?` : `Leave ${g.name}?`)) return;
+  try {
+    if (owner) await api.del(`/api/v1/guilds/${g.id}`);
+    else await api.del(`/api/v1/guilds/${g.id}/members/${App.user.id}`);
+    App.guilds = App.guilds.filter(x => x.id !== g.id);
+    switchGuild('default');
+  } catch (e) { toast(e.message, 'error'); }
+}
+
 // ─── ADMIN PANEL ──────────────────────────────────────────────────────────────
 function openAdmin() {
   openModal('admin-modal');
@@ -2039,10 +3116,10 @@ function openAdmin() {
 
 async function loadAdminUsers() {
   const [users, roles, invites, settings] = await Promise.all([
-    api.get('/api/users'),
-    api.get('/api/roles'),
-    api.get('/api/invites'),
-    api.get('/api/settings'),
+    api.get('/api/v1/users'),
+    api.get('/api/v1/roles'),
+    api.get('/api/v1/invites'),
+    api.get('/api/v1/settings'),
   ]);
   renderAdminUsers(users);
   renderAdminRoles(roles);
@@ -2111,7 +3188,7 @@ function renderAdminInvites(invites, settings) {
   el.innerHTML = `
     <button class="btn btn-primary btn-sm mb-16" onclick="createInvite()">+ Create Invite</button>
     ${invites.length ? `<table class="data-table">
-      <thead><tr><th>Code</th><th>Created By</th><th>Uses</th><th>Actions</th></tr></thead>
+      <thead><tr><th>Code</th><th>Created By</th><th>Uses</th><th>Joined</th><th>Actions</th></tr></thead>
       <tbody>${invites.map(inv => `
         <tr>
           <td>
@@ -2122,8 +3199,13 @@ function renderAdminInvites(invites, settings) {
           </td>
           <td>${esc(inv.creator?.username || 'Unknown')}</td>
           <td>${inv.uses}${inv.max_uses > 0 ? ` / ${inv.max_uses}` : ''}</td>
-          <td><button class="btn btn-sm btn-danger" onclick="adminDeleteInvite('${inv.code}')">Delete</button></td>
-        </tr>`).join('')}
+          <td>${inv.joins ? `<a href="#" onclick="toggleInviteJoins('${inv.code}');return false">${inv.joins}</a>` : '0'}</td>
+          <td>
+            <button class="btn btn-sm btn-secondary" onclick="showInviteQR('${inv.code}')">QR</button>
+            <button class="btn btn-sm btn-danger" onclick="adminDeleteInvite('${inv.code}')">Delete</button>
+          </td>
+        </tr>
+        <tr id="invite-joins-${inv.code}" style="display:none"><td colspan="5"></td></tr>`).join('')}
       </tbody>
     </table>` : '<p class="text-muted">No active invites.</p>'}`;
 }
@@ -2164,10 +3246,99 @@ function renderAdminSettings(settings) {
         <option value="1" ${settings.require_invite==='1'?'selected':''}>Yes</option>
       </select>
     </div>
+    <div class="form-group">
+      <label>Invite Page Counts</label>
+      <select id="setting-preview-counts">
+        <option value="0" ${settings.preview_counts!=='1'?'selected':''}>Hidden</option>
+        <option value="1" ${settings.preview_counts==='1'?'selected':''}>Show members and who's online to people with an invite</option>
+      </select>
+    </div>
     <div class="form-group">
       <label>Max Upload Size (MB)</label>
       <input type="number" id="setting-max-upload" value="${settings.max_upload_mb||25}" min="1" max="500">
     </div>
+    <div class="form-group">
+      <label>Strip Photo Metadata</label>
+      <select id="setting-strip-metadata">
+        <option value="1" ${settings.strip_image_metadata!=='0'?'selected':''}>Yes — remove location, camera and other EXIF data</option>
+        <option value="0" ${settings.strip_image_metadata==='0'?'selected':''}>No — keep images exactly as uploaded</option>
+      </select>
+    </div>
+    <div class="form-group">
+      <label>Scan Uploads for Malware${settings.upload_scanner ? '' : ' <span style="font-weight:400;color:var(--text-muted)">(no scanner configured on the server)</span>'}</label>
+      <select id="setting-scan-uploads" ${settings.upload_scanner ? '' : 'disabled'}>
+        <option value="on" ${!['off','strict'].includes(settings.scan_uploads)?'selected':''}>On — quarantine infected files</option>
+        <option value="strict" ${settings.scan_uploads==='strict'?'selected':''}>Strict — also refuse uploads while the scanner is down</option>
+        <option value="off" ${settings.scan_uploads==='off'?'selected':''}>Off</option>
+      </select>
+    </div>
+    <div class="form-group">
+      <label>Storage Quota (MB) <span style="font-weight:400;color:var(--text-muted)">(0 = unlimited; attachments use ${formatSize(+settings.storage_used_bytes || 0)})</span></label>
+      <input type="number" id="setting-storage-quota" value="${settings.storage_quota_mb||0}" min="0">
+    </div>
+    <div class="form-group">
+      <label>When the Quota Is Full</label>
+      <select id="setting-storage-policy">
+        <option value="reject" ${!['evict_oldest','evict_largest'].includes(settings.storage_quota_policy)?'selected':''}>Refuse new uploads</option>
+        <option value="evict_oldest" ${settings.storage_quota_policy==='evict_oldest'?'selected':''}>Delete the oldest attachments to make room</option>
+        <option value="evict_largest" ${settings.storage_quota_policy==='evict_largest'?'selected':''}>Delete the largest attachments to make room</option>
+      </select>
+    </div>
+    <div class="form-group">
+      <label>Link Previews</label>
+      <select id="setting-link-previews">
+        <option value="1" ${settings.link_previews!=='0'?'selected':''}>On — show a card for links in messages</option>
+        <option value="0" ${settings.link_previews==='0'?'selected':''}>Off — never fetch linked pages</option>
+      </select>
+    </div>
+    <div class="form-group">
+      <label>Never Preview <span style="font-weight:400;color:var(--text-muted)">(domains, one per line; subdomains included)</span></label>
+      <textarea id="setting-preview-deny" rows="3" placeholder="tracker.example">${esc(settings.link_preview_denylist||'')}</textarea>
+    </div>
+    <div class="form-group">
+      <label>Only Preview <span style="font-weight:400;color:var(--text-muted)">(leave empty to allow all other domains)</span></label>
+      <textarea id="setting-preview-allow" rows="3" placeholder="youtube.com">${esc(settings.link_preview_allowlist||'')}</textarea>
+    </div>
+    <div class="form-group">
+      <label>GIF Search</label>
+      <select id="setting-gif-provider">
+        <option value="" ${!settings.gif_provider?'selected':''}>Off</option>
+        <option value="tenor" ${settings.gif_provider==='tenor'?'selected':''}>Tenor</option>
+        <option value="giphy" ${settings.gif_provider==='giphy'?'selected':''}>GIPHY</option>
+      </select>
+    </div>
+    <div class="form-group">
+      <label>GIF API Key <span style="font-weight:400;color:var(--text-muted)">(kept on the server; searches are made from it, not from members' browsers)</span></label>
+      <input type="password" id="setting-gif-key" value="${esc(settings.gif_api_key||'')}" autocomplete="off">
+    </div>
+    <div class="form-group">
+      <label>Message Translation</label>
+      <select id="setting-translate-provider">
+        <option value="" ${!settings.translate_provider?'selected':''}>Off</option>
+        <option value="libretranslate" ${settings.translate_provider==='libretranslate'?'selected':''}>LibreTranslate</option>
+        <option value="deepl" ${settings.translate_provider==='deepl'?'selected':''}>DeepL</option>
+      </select>
+    </div>
+    <div class="form-group">
+      <label>Translation URL <span style="font-weight:400;color:var(--text-muted)">(your LibreTranslate server; for DeepL, leave empty)</span></label>
+      <input type="text" id="setting-translate-url" value="${esc(settings.translate_url||'')}" placeholder="https://libretranslate.example.com">
+    </div>
+    <div class="form-group">
+      <label>Translation API Key <span style="font-weight:400;color:var(--text-muted)">(kept on the server; optional for LibreTranslate)</span></label>
+      <input type="password" id="setting-translate-key" value="${esc(settings.translate_api_key||'')}" autocomplete="off">
+    </div>
+    <div style="border-top:1px solid var(--border);margin:20px 0;padding-top:20px">
+      <h4 style="margin-bottom:16px;font-size:14px;color:var(--text-secondary);text-transform:uppercase;letter-spacing:0.05em">Rate Limits</h4>
+      <p style="font-size:12px;color:var(--text-muted);margin:-8px 0 12px">Per user, or per IP before signing in. Leave a box empty to use the server's default.</p>
+      ${RATE_LIMIT_CLASSES.map(([cls, label]) => `
+      <div class="form-group">
+        <label>${label} <span style="font-weight:400;color:var(--text-muted)">(per minute, and at once)</span></label>
+        <div style="display:flex;gap:8px">
+          <input type="number" id="setting-rate-${cls}-per-min" value="${esc(settings['rate_'+cls+'_per_min']||'')}" min="1" style="flex:1">
+          <input type="number" id="setting-rate-${cls}-burst" value="${esc(settings['rate_'+cls+'_burst']||'')}" min="1" style="flex:1">
+        </div>
+      </div>`).join('')}
+    </div>
     <div style="border-top:1px solid var(--border);margin:20px 0;padding-top:20px">
       <h4 style="margin-bottom:16px;font-size:14px;color:var(--text-secondary);text-transform:uppercase;letter-spacing:0.05em">Login Page Appearance</h4>
       <div class="form-group">
@@ -2207,8 +3378,84 @@ function renderAdminSettings(settings) {
         <textarea id="setting-agreement-text" style="min-height:140px;font-family:monospace;font-size:13px;resize:vertical" placeholder="## Community Rules&#10;&#10;By joining, you agree to...&#10;&#10;1. Be respectful&#10;2. No spam">${esc(settings.agreement_text||'')}</textarea>
       </div>
     </div>
+    <div style="border-top:1px solid var(--border);margin:20px 0;padding-top:20px">
+      <h4 style="margin-bottom:16px;font-size:14px;color:var(--text-secondary);text-transform:uppercase;letter-spacing:0.05em">Templates</h4>
+      <div class="form-group">
+        <label>Add a Template's Roles and Channels to This Guild</label>
+        <div style="display:flex;gap:8px">
+          <select id="setting-template" style="flex:1"><option value="default">Just the basics</option></select>
+          <button class="btn btn-secondary btn-sm" onclick="applyGuildTemplate()">Apply</button>
+        </div>
+        <p style="font-size:12px;color:var(--text-muted);margin-top:4px">Only adds: roles, categories and channels this guild already has by name are left alone.</p>
+      </div>
+    </div>
+    <div style="border-top:1px solid var(--border);margin:20px 0;padding-top:20px">
+      <h4 style="margin-bottom:16px;font-size:14px;color:var(--text-secondary);text-transform:uppercase;letter-spacing:0.05em">Welcoming New Members</h4>
+      <p style="font-size:12px;color:var(--text-muted);margin-bottom:12px">Messages can use <code>{user}</code>, <code>{mention}</code>, <code>{server}</code> and <code>{members}</code>.</p>
+      <div class="form-group">
+        <label>Welcome Channel</label>
+        <select id="setting-welcome-channel">
+          <option value="">None</option>
+          ${settings.welcome_channel_id && !App.channels.some(c => c.id === settings.welcome_channel_id) ? `<option value="${esc(settings.welcome_channel_id)}" selected>(a channel in another guild)</option>` : ''}
+          ${App.channels.filter(c => c.type === 'text').map(c => `<option value="${c.id}" ${settings.welcome_channel_id===c.id?'selected':''}>#${esc(c.name)}</option>`).join('')}
+        </select>
+      </div>
+      <div class="form-group">
+        <label>Welcome Message <span style="font-weight:400;color:var(--text-muted)">(posted in the welcome channel)</span></label>
+        <textarea id="setting-welcome-message" maxlength="2000" style="min-height:60px;resize:vertical" placeholder="Everyone say hi to {mention}, member number {members}!">${esc(settings.welcome_message||'')}</textarea>
+      </div>
+      <div class="form-group">
+        <label>Private Welcome <span style="font-weight:400;color:var(--text-muted)">(shown only to the new member when they first open the app)</span></label>
+        <textarea id="setting-welcome-private" maxlength="2000" style="min-height:60px;resize:vertical" placeholder="Welcome to {server}, {user}! Start with #general.">${esc(settings.welcome_private_message||'')}</textarea>
+      </div>
+      <div class="form-group">
+        <label>Require Accepting the Rules Before Posting</label>
+        <select id="setting-rules-gate">
+          <option value="0" ${settings.rules_gate!=='1'?'selected':''}>Disabled</option>
+          <option value="1" ${settings.rules_gate==='1'?'selected':''}>Enabled</option>
+        </select>
+        <p style="font-size:12px;color:var(--text-muted);margin-top:4px">The rules are the agreement text above. Members who haven't accepted them, new or not, are asked to before they can send messages; admins never are.</p>
+      </div>
+    </div>
+    <div style="border-top:1px solid var(--border);margin:20px 0;padding-top:20px">
+      <h4 style="margin-bottom:16px;font-size:14px;color:var(--text-secondary);text-transform:uppercase;letter-spacing:0.05em">Announcement Banner</h4>
+      <div class="form-group">
+        <label>Text <span style="font-weight:400;color:var(--text-muted)">(shown across the top of the app; leave empty for none)</span></label>
+        <textarea id="setting-banner-text" maxlength="500" style="min-height:60px;resize:vertical" placeholder="Chirm will be down for an upgrade on Saturday from 10:00 to 11:00">${esc(settings.banner_text||'')}</textarea>
+      </div>
+      <div style="display:flex;gap:12px;flex-wrap:wrap">
+        <div class="form-group" style="flex:1;min-width:140px">
+          <label>Severity</label>
+          <select id="setting-banner-severity">
+            ${['info', 'warning', 'critical'].map(s => `<option value="${s}" ${(settings.banner_severity||'info')===s?'selected':''}>${s[0].toUpperCase() + s.slice(1)}</option>`).join('')}
+          </select>
+        </div>
+        <div class="form-group" style="flex:1;min-width:200px">
+          <label>Expires <span style="font-weight:400;color:var(--text-muted)">(optional)</span></label>
+          <input type="datetime-local" id="setting-banner-expires" value="${localDateTimeInput(settings.banner_expires_at)}">
+        </div>
+      </div>
+    </div>
     <button class="btn btn-primary" onclick="saveSettings()">Save Settings</button>
   `;
+  fillTemplateSelect('setting-template');
+}
+
+async function applyGuildTemplate() {
+  const template = document.getElementById('setting-template')?.value;
+  try {
+    const res = await api.post(`/api/v1/guilds/${App.guild}/template`, { template });
+    toast(`Added ${res.channels.length} channels and ${res.roles.length} roles`, 'success');
+    await loadRoles();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+// localDateTimeInput turns an RFC 3339 time into a datetime-local input's value.
+function localDateTimeInput(iso) {
+  if (!iso) return '';
+  const d = new Date(iso);
+  if (isNaN(d)) return '';
+  return new Date(d - d.getTimezoneOffset() * 60000).toISOString().slice(0, 16);
 }
 
 async function uploadServerIcon() {
@@ -2217,7 +3464,7 @@ async function uploadServerIcon() {
   const form = new FormData();
   form.append('icon', file);
   try {
-    await fetch('/api/settings/icon', { method: 'POST', credentials: 'include', body: form });
+    await fetch('/api/v1/settings/icon', { method: 'POST', credentials: 'include', body: form });
     toast('Server icon updated', 'success');
     loadAdminUsers();
   } catch (e) { toast('Failed to upload icon', 'error'); }
@@ -2225,7 +3472,7 @@ async function uploadServerIcon() {
 
 async function clearServerIcon() {
   try {
-    await api.put('/api/settings', { server_icon: '' });
+    await api.put('/api/v1/settings', { server_icon: '' });
     toast('Server icon removed', 'success');
     loadAdminUsers();
   } catch (e) { toast(e.message, 'error'); }
@@ -2237,7 +3484,7 @@ async function uploadLoginBg() {
   const form = new FormData();
   form.append('bg', file);
   try {
-    await fetch('/api/settings/login-bg', { method: 'POST', credentials: 'include', body: form });
+    await fetch('/api/v1/settings/login-bg', { method: 'POST', credentials: 'include', body: form });
     toast('Login background updated', 'success');
     loadAdminUsers();
   } catch (e) { toast('Failed to upload background', 'error'); }
@@ -2245,26 +3492,66 @@ async function uploadLoginBg() {
 
 async function clearLoginBg() {
   try {
-    await api.put('/api/settings', { login_bg_image: '' });
+    await api.put('/api/v1/settings', { login_bg_image: '' });
     toast('Background removed', 'success');
     loadAdminUsers();
   } catch (e) { toast(e.message, 'error'); }
 }
 
+// The route classes with their own rate limit, as the server names them.
+const RATE_LIMIT_CLASSES = [
+  ['auth', 'Logins and Sign-ups'],
+  ['messages', 'Messages Sent or Edited'],
+  ['uploads', 'Uploads'],
+  ['previews', 'Link Previews'],
+  ['image_proxy', 'Proxied Images'],
+  ['server_preview', 'Server Previews'],
+  ['gifs', 'GIF Searches'],
+  ['translations', 'Translations'],
+  ['oauth', 'Sign in with Chirm'],
+  ['webhooks', 'Webhook Posts'],
+  ['federation', 'Federation Events'],
+];
+
 async function saveSettings() {
   const settings = {
     server_name: document.getElementById('setting-server-name')?.value,
     server_description: document.getElementById('setting-server-desc')?.value,
     allow_registration: document.getElementById('setting-allow-reg')?.value,
     require_invite: document.getElementById('setting-require-invite')?.value,
+    preview_counts: document.getElementById('setting-preview-counts')?.value,
     max_upload_mb: document.getElementById('setting-max-upload')?.value,
+    strip_image_metadata: document.getElementById('setting-strip-metadata')?.value,
+    scan_uploads: document.getElementById('setting-scan-uploads')?.value,
+    storage_quota_mb: document.getElementById('setting-storage-quota')?.value,
+    storage_quota_policy: document.getElementById('setting-storage-policy')?.value,
+    link_previews: document.getElementById('setting-link-previews')?.value,
+    link_preview_denylist: document.getElementById('setting-preview-deny')?.value,
+    gif_provider: document.getElementById('setting-gif-provider')?.value,
+    gif_api_key: document.getElementById('setting-gif-key')?.value,
+    translate_provider: document.getElementById('setting-translate-provider')?.value,
+    translate_url: document.getElementById('setting-translate-url')?.value,
+    translate_api_key: document.getElementById('setting-translate-key')?.value,
+    link_preview_allowlist: document.getElementById('setting-preview-allow')?.value,
     login_bg_color: document.getElementById('setting-bg-color')?.value,
     login_bg_overlay: document.getElementById('setting-bg-overlay')?.value,
     agreement_enabled: document.getElementById('setting-agreement-enabled')?.value,
     agreement_text: document.getElementById('setting-agreement-text')?.value,
+    welcome_channel_id: document.getElementById('setting-welcome-channel')?.value,
+    welcome_message: document.getElementById('setting-welcome-message')?.value,
+    welcome_private_message: document.getElementById('setting-welcome-private')?.value,
+    rules_gate: document.getElementById('setting-rules-gate')?.value,
+    banner_text: document.getElementById('setting-banner-text')?.value,
+    banner_severity: document.getElementById('setting-banner-severity')?.value,
   };
+  const bannerExpires = document.getElementById('setting-banner-expires');
+  if (bannerExpires) settings.banner_expires_at = bannerExpires.value ? new Date(bannerExpires.value).toISOString() : '';
+  for (const [cls] of RATE_LIMIT_CLASSES) {
+    settings['rate_' + cls + '_per_min'] = document.getElementById('setting-rate-' + cls + '-per-min')?.value;
+    settings['rate_' + cls + '_burst'] = document.getElementById('setting-rate-' + cls + '-burst')?.value;
+  }
   try {
-    await api.put('/api/settings', settings);
+    await api.put('/api/v1/settings', settings);
     toast('Settings saved', 'success');
     renderServerHeader();
   } catch (e) {
@@ -2275,7 +3562,7 @@ async function saveSettings() {
 async function adminDeleteUser(id, name) {
   if (!confirm(`Ban/delete user "${name}"? This cannot be undone.`)) return;
   try {
-    await api.del(`/api/users/${id}`);
+    await api.del(`/api/v1/users/${id}`);
     toast(`${name} deleted`, 'success');
     loadAdminUsers();
     loadMembers().then(renderMembersList);
@@ -2285,15 +3572,45 @@ async function adminDeleteUser(id, name) {
 async function adminDeleteRole(id) {
   if (!confirm('Delete this role?')) return;
   try {
-    await api.del(`/api/roles/${id}`);
+    await api.del(`/api/v1/roles/${id}`);
     toast('Role deleted', 'success');
     loadAdminUsers();
   } catch (e) { toast(e.message, 'error'); }
 }
 
+// toggleInviteJoins shows who came in through an invite, under its row,
+// with how many did on each of the last 30 days.
+async function toggleInviteJoins(code) {
+  const row = document.getElementById(`invite-joins-${code}`);
+  if (!row) return;
+  if (row.style.display !== 'none') { row.style.display = 'none'; return; }
+  let data;
+  try { data = await api.get(`/api/v1/invites/${code}/joins`); } catch (e) { toast(e.message, 'error'); return; }
+  const peak = Math.max(1, ...data.by_day.map(d => d.joins));
+  row.firstElementChild.innerHTML = `
+    <div style="display:flex;align-items:flex-end;gap:2px;height:40px;margin-bottom:10px" title="Joins over the last 30 days">
+      ${data.by_day.map(d => `<div title="${d.day}: ${d.joins}" style="flex:1;min-height:2px;height:${Math.round(d.joins / peak * 100)}%;background:var(--accent);border-radius:2px"></div>`).join('')}
+    </div>
+    <div class="text-sm">${data.joins.map(j => `
+      <div style="display:flex;justify-content:space-between;gap:12px;padding:2px 0">
+        <span>${esc(j.username)}${j.registered ? '' : ' <span class="text-muted">(joined the guild)</span>'}</span>
+        <span class="text-muted">${formatTime(j.joined_at)}</span>
+      </div>`).join('')}
+    </div>`;
+  row.style.display = '';
+}
+
+// showInviteQR shows an invite as a QR code, for a phone to scan.
+function showInviteQR(code) {
+  showSimpleModal('Scan to join', `
+    <div style="text-align:center">
+      <img src="/api/v1/invites/${code}/qr.png?size=320" width="320" height="320" alt="Invite QR code" style="max-width:100%;height:auto;background:#fff;border-radius:8px">
+    </div>`);
+}
+
 async function adminDeleteInvite(code) {
   try {
-    await api.del(`/api/invites/${code}`);
+    await api.del(`/api/v1/invites/${code}`);
     toast('Invite deleted', 'success');
     loadAdminUsers();
   } catch (e) { toast(e.message, 'error'); }
@@ -2301,7 +3618,7 @@ async function adminDeleteInvite(code) {
 
 async function createInvite() {
   try {
-    await api.post('/api/invites', { max_uses: 0 });
+    await api.post('/api/v1/invites', { max_uses: 0 });
     toast('Invite created', 'success');
     loadAdminUsers();
   } catch (e) { toast(e.message, 'error'); }
@@ -2366,6 +3683,8 @@ function openCreateChannel(defaultCategoryId = '') {
       <select id="new-ch-type" style="width:100%;padding:8px 10px;background:var(--bg-input);color:var(--text-primary);border:1px solid var(--border-strong);border-radius:var(--radius-sm);font-family:inherit;font-size:14px">
         <option value="text">💬 Text Channel</option>
         <option value="voice">🔊 Voice Channel</option>
+        <option value="stage">🎙️ Stage Channel</option>
+        <option value="broadcast">📻 Broadcast Channel</option>
       </select>
     </div>
     ${catSelect}
@@ -2376,15 +3695,21 @@ function openCreateChannel(defaultCategoryId = '') {
     const type = document.getElementById('new-ch-type').value;
     const emoji = document.getElementById('ch-emoji-value')?.value || '';
     const category_id = document.getElementById('new-ch-cat')?.value || defaultCategoryId || '';
-    await api.post('/api/channels', { name, description: document.getElementById('new-ch-desc').value, type, emoji, category_id });
+    await api.post('/api/v1/channels', { name, description: document.getElementById('new-ch-desc').value, type, emoji, category_id, guild_id: App.guild });
     await loadChannels();
     renderChannelList();
   });
 }
 
-function openEditChannel(id) {
+async function openEditChannel(id) {
   const ch = App.channels.find(c => c.id === id);
   if (!ch) return;
+  const isVoice = isVoiceChannel(ch);
+  const overrides = isVoice ? await api.get(`/api/v1/channels/${id}/overrides`).catch(() => []) : [];
+  const webhooks = ch.type === 'text' ? await api.get(`/api/v1/channels/${id}/webhooks`).catch(() => []) : [];
+  const feeds = ch.type === 'text' ? await api.get(`/api/v1/channels/${id}/feeds`).catch(() => []) : [];
+  const federated = ch.type === 'text' && App.publicSettings?.federation;
+  const links = federated ? await api.get(`/api/v1/channels/${id}/federation`).catch(() => []) : [];
   const catSelect = App.categories.length > 0 ? `
     <div class="form-group">
       <label>Category</label>
@@ -2398,93 +3723,331 @@ function openEditChannel(id) {
     <div class="form-group"><label>Channel Name</label><input type="text" id="edit-ch-name" value="${esc(ch.name)}"></div>
     <div class="form-group"><label>Description</label><input type="text" id="edit-ch-desc" value="${esc(ch.description)}"></div>
     ${catSelect}
+    ${isVoice ? voiceLogField(ch.voice_log_channel_id || '') : ''}
+    ${isVoice ? voiceQualityFields(ch) : ''}
+    ${isVoice ? voiceOverrideFields(overrides) : ''}
+    ${ch.type === 'text' ? webhookFields(id, webhooks) : ''}
+    ${ch.type === 'text' ? feedFields(id, feeds) : ''}
+    ${federated ? federationFields(id, links) : ''}
+    ${ch.type === 'text' && (ircPorts() || ch.irc) ? ircField(ch) : ''}
   `;
   showSimpleModal('Edit Channel', form, async () => {
     const name = document.getElementById('edit-ch-name').value.trim();
     if (!name) { toast('Name required', 'error'); return false; }
     const emoji = document.getElementById('ch-emoji-value')?.value || '';
     const category_id = document.getElementById('edit-ch-cat')?.value || '';
-    await api.put(`/api/channels/${id}`, { name, description: document.getElementById('edit-ch-desc').value, emoji, category_id });
+    const body = { name, description: document.getElementById('edit-ch-desc').value, emoji, category_id };
+    if (isVoice) {
+      body.voice_log_channel_id = document.getElementById('edit-ch-voice-log').value;
+      body.audio_bitrate = parseInt(document.getElementById('edit-ch-bitrate').value);
+      body.video_height = parseInt(document.getElementById('edit-ch-video-height').value);
+    }
+    const irc = document.getElementById('edit-ch-irc');
+    if (irc) body.irc = irc.checked;
+    await api.put(`/api/v1/channels/${id}`, body);
+    if (isVoice) await saveVoiceOverrides(id);
     await loadChannels();
     renderChannelList();
   });
 }
 
-async function confirmDeleteChannel(id) {
-  const ch = App.channels.find(c => c.id === id);
-  if (!confirm(`Delete #${ch?.name}? All messages will be lost.`)) return;
-  await api.del(`/api/channels/${id}`);
-  await loadChannels();
-  renderChannelList();
+// Text channel that receives "joined"/"left" messages for a voice channel.
+function voiceLogField(selected) {
+  const opts = App.channels.filter(c => c.type === 'text').map(c =>
+    `<option value="${c.id}" ${c.id === selected ? 'selected' : ''}># ${esc(c.name)}</option>`).join('');
+  return `<div class="form-group"><label>Post Join/Leave Messages To</label>
+    <select id="edit-ch-voice-log" style="width:100%;padding:8px 10px;background:var(--bg-input);color:var(--text-primary);border:1px solid var(--border-strong);border-radius:var(--radius-sm);font-family:inherit;font-size:14px">
+      <option value="">Nowhere</option>${opts}
+    </select></div>`;
 }
 
-// ─── CATEGORY MANAGEMENT ──────────────────────────────────────────────────────
-function openCreateCategory() {
-  const form = `<div class="form-group"><label>Category Name</label><input type="text" id="new-cat-name" placeholder="e.g. General, Gaming, Info"></div>`;
-  showSimpleModal('New Category', form, async () => {
-    const name = document.getElementById('new-cat-name').value.trim();
-    if (!name) { toast('Name required', 'error'); return false; }
-    await api.post('/api/channel-categories', { name });
-    await loadChannels();
-    renderChannelList();
-  });
+// Audio bitrate / video resolution caps for a voice channel (0 = default).
+function voiceQualityFields(ch) {
+  const sel = (id, current, options) => {
+    if (!options.some(([v]) => v === current)) options.push([current, String(current)]);
+    return `<select id="${id}" style="width:100%;padding:8px 10px;background:var(--bg-input);color:var(--text-primary);border:1px solid var(--border-strong);border-radius:var(--radius-sm);font-family:inherit;font-size:14px">
+    ${options.map(([v, label]) => `<option value="${v}" ${v === current ? 'selected' : ''}>${label}</option>`).join('')}
+  </select>`;
+  };
+  const bitrates = [[0, 'Default (128 kbps)'], [8, '8 kbps'], [16, '16 kbps'], [32, '32 kbps'], [64, '64 kbps'], [96, '96 kbps'], [128, '128 kbps'], [256, '256 kbps'], [384, '384 kbps']];
+  const heights = [[0, 'Unlimited'], [360, '360p'], [480, '480p'], [720, '720p'], [1080, '1080p']];
+  return `<div class="form-group" style="display:grid;grid-template-columns:1fr 1fr;gap:8px">
+    <div><label>Audio Bitrate</label>${sel('edit-ch-bitrate', ch.audio_bitrate || 0, bitrates)}</div>
+    <div><label>Video Quality</label>${sel('edit-ch-video-height', ch.video_height || 0, heights)}</div>
+  </div>`;
 }
 
-function openEditCategory(id) {
-  const cat = App.categories.find(c => c.id === id);
-  if (!cat) return;
-  const form = `<div class="form-group"><label>Category Name</label><input type="text" id="edit-cat-name" value="${esc(cat.name)}"></div>`;
-  showSimpleModal('Rename Category', form, async () => {
-    const name = document.getElementById('edit-cat-name').value.trim();
-    if (!name) { toast('Name required', 'error'); return false; }
-    await api.put(`/api/channel-categories/${id}`, { name });
-    await loadChannels();
-    renderChannelList();
-  });
+// Per-role voice overrides: each permission is inherited, allowed or denied.
+function voiceOverrideFields(overrides) {
+  const byRole = Object.fromEntries(overrides.map(o => [o.role_id, o]));
+  const rows = (App.roles || []).map(r => {
+    const o = byRole[r.id] || { allow: 0, deny: 0 };
+    const selects = VOICE_PERMS.map(p => {
+      const v = (o.allow & p.bit) ? 'allow' : (o.deny & p.bit) ? 'deny' : '';
+      return `<label style="display:flex;flex-direction:column;font-size:11px;font-weight:400;text-transform:none;letter-spacing:0;gap:2px">
+        ${esc(p.label.replace(' (voice)', ''))}
+        <select data-role="${r.id}" data-bit="${p.bit}" style="padding:4px;background:var(--bg-input);color:var(--text-primary);border:1px solid var(--border-strong);border-radius:var(--radius-sm);font-size:12px">
+          <option value="" ${v === '' ? 'selected' : ''}>Inherit</option>
+          <option value="allow" ${v === 'allow' ? 'selected' : ''}>Allow</option>
+          <option value="deny" ${v === 'deny' ? 'selected' : ''}>Deny</option>
+        </select>
+      </label>`;
+    }).join('');
+    return `<div style="margin-bottom:8px"><div style="font-size:13px;color:${r.color};margin-bottom:4px">${esc(r.name)}</div>
+      <div style="display:grid;grid-template-columns:repeat(3,1fr);gap:6px">${selects}</div></div>`;
+  }).join('');
+  return `<div class="form-group"><label>Voice Permissions</label>
+    <p style="font-size:12px;color:var(--text-muted);margin:0 0 8px">Deny Connect for @everyone and allow it for a role to make a staff-only room; deny Speak for a listen-only room.</p>
+    <div id="voice-overrides">${rows}</div></div>`;
 }
 
-async function confirmDeleteCategory(id) {
-  const cat = App.categories.find(c => c.id === id);
-  const count = App.channels.filter(c => c.category_id === id).length;
-  const msg = count > 0
-    ? `Delete category "${cat?.name}"? ${count} channel(s) will become uncategorized.`
-    : `Delete category "${cat?.name}"?`;
-  if (!confirm(msg)) return;
-  await api.del(`/api/channel-categories/${id}`);
-  await loadChannels();
-  renderChannelList();
+async function saveVoiceOverrides(channelId) {
+  const perRole = {};
+  document.querySelectorAll('#voice-overrides select[data-role]').forEach(sel => {
+    const o = perRole[sel.dataset.role] ||= { allow: 0, deny: 0 };
+    const bit = parseInt(sel.dataset.bit);
+    if (sel.value === 'allow') o.allow |= bit;
+    else if (sel.value === 'deny') o.deny |= bit;
+  });
+  for (const [roleId, o] of Object.entries(perRole)) {
+    await api.put(`/api/v1/channels/${channelId}/overrides/${roleId}`, o);
+  }
 }
 
-// ─── ROLE MANAGEMENT ──────────────────────────────────────────────────────────
-const PERMS = [
-  { bit: 1, label: 'Read Messages' },
-  { bit: 2, label: 'Send Messages' },
-  { bit: 4, label: 'Manage Messages' },
-  { bit: 8, label: 'Manage Channels' },
-  { bit: 16, label: 'Manage Roles' },
-  { bit: 32, label: 'Manage Server' },
-  { bit: 64, label: 'Administrator' },
-];
-
-function permCheckboxes(current = 0) {
-  return PERMS.map(p => `
-    <label style="display:flex;align-items:center;gap:8px;font-size:13.5px;font-weight:400;text-transform:none;letter-spacing:0;margin-bottom:6px;cursor:pointer">
-      <input type="checkbox" data-perm="${p.bit}" ${(current & p.bit) ? 'checked' : ''}>
-      ${p.label}
-    </label>`).join('');
+// Incoming webhooks for a text channel. A new webhook's URL is shown once.
+function webhookFields(channelId, hooks) {
+  return `<div class="form-group"><label>Webhooks</label>
+    <p style="font-size:12px;color:var(--text-muted);margin:0 0 8px">Integrations POST JSON with content and embeds to a webhook's URL to post here.</p>
+    <div id="webhook-list">${hooks.map(webhookRow).join('')}</div>
+    <div id="webhook-new-url"></div>
+    <div style="display:flex;gap:6px;margin-top:6px">
+      <input type="text" id="webhook-name" placeholder="Webhook name" style="flex:1">
+      <button type="button" class="btn btn-secondary" onclick="createWebhook('${channelId}')">Create</button>
+    </div></div>`;
 }
 
-function getPermValue(container) {
-  let val = 0;
-  container.querySelectorAll('[data-perm]').forEach(cb => {
-    if (cb.checked) val |= parseInt(cb.dataset.perm);
-  });
-  return val;
+function webhookRow(w) {
+  return `<div class="webhook-row" data-webhook-id="${w.id}" style="display:flex;align-items:center;gap:8px;padding:4px 0">
+    <span style="flex:1;font-size:13px">🤖 ${esc(w.name)}</span>
+    <button type="button" class="btn btn-danger btn-sm" onclick="deleteWebhook('${w.id}')">Delete</button>
+  </div>`;
 }
 
-function openCreateRole() {
-  const form = `
-    <div class="form-group"><label>Role Name</label><input type="text" id="new-role-name" placeholder="Moderator"></div>
+async function createWebhook(channelId) {
+  const input = document.getElementById('webhook-name');
+  const name = input.value.trim();
+  if (!name) { toast('Name required', 'error'); return; }
+  try {
+    const res = await api.post(`/api/v1/channels/${channelId}/webhooks`, { name });
+    input.value = '';
+    document.getElementById('webhook-list').insertAdjacentHTML('beforeend', webhookRow(res.webhook));
+    document.getElementById('webhook-new-url').innerHTML = `
+      <p style="font-size:12px;color:var(--text-muted);margin:6px 0 4px">Copy this URL now — it won't be shown again.</p>
+      <input type="text" readonly value="${escAttr(location.origin + res.url)}" onclick="this.select()">`;
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
+async function deleteWebhook(id) {
+  if (!confirm('Delete this webhook? Integrations using it will stop working.')) return;
+  try {
+    await api.del(`/api/v1/webhooks/${id}`);
+    document.querySelector(`.webhook-row[data-webhook-id="${id}"]`)?.remove();
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
+// The IRC gateway's ports, as "6667" or "6667, 6697 (TLS)", or '' if it's off.
+function ircPorts() {
+  const s = App.publicSettings || {};
+  return [s.irc_port, s.irc_tls_port && `${s.irc_tls_port} (TLS)`].filter(Boolean).join(', ');
+}
+
+// Whether a text channel is open to the IRC gateway.
+function ircField(ch) {
+  return `<div class="form-group">
+    <label style="display:flex;align-items:center;gap:8px;font-size:13.5px;font-weight:400;text-transform:none;letter-spacing:0;cursor:pointer">
+      <input type="checkbox" id="edit-ch-irc" ${ch.irc ? 'checked' : ''}> Open to IRC clients
+    </label>
+    <p style="font-size:12px;color:var(--text-muted);margin:4px 0 0">Members can join it as #${esc(ch.name.toLowerCase().replace(/[ ,]/g, '-'))} with an IRC client${ircPorts() ? ` on port ${ircPorts()}` : ''}, signing in with a personal token.</p>
+  </div>`;
+}
+
+// RSS and Atom feeds a text channel follows.
+function feedFields(channelId, feeds) {
+  return `<div class="form-group"><label>Feeds</label>
+    <p style="font-size:12px;color:var(--text-muted);margin:0 0 8px">New items from these RSS or Atom feeds are posted here, checked every 10 minutes.</p>
+    <div id="feed-list">${feeds.map(feedRow).join('')}</div>
+    <div style="display:flex;gap:6px;margin-top:6px">
+      <input type="text" id="feed-url" placeholder="https://example.com/feed.xml" style="flex:1">
+      <button type="button" class="btn btn-secondary" onclick="createFeed('${channelId}')">Add</button>
+    </div></div>`;
+}
+
+function feedRow(f) {
+  return `<div class="feed-row" data-feed-id="${f.id}" style="display:flex;align-items:center;gap:8px;padding:4px 0">
+    <span style="flex:1;font-size:13px;min-width:0;overflow:hidden;text-overflow:ellipsis;white-space:nowrap" title="${escAttr(f.url)}">📰 ${esc(f.title)}
+      ${f.last_error ? `<span style="color:var(--danger)" title="${escAttr(f.last_error)}">· failing</span>` : ''}</span>
+    <button type="button" class="btn btn-danger btn-sm" onclick="deleteFeed('${f.id}')">Remove</button>
+  </div>`;
+}
+
+async function createFeed(channelId) {
+  const input = document.getElementById('feed-url');
+  const url = input.value.trim();
+  if (!url) { toast('Enter the feed\'s URL', 'error'); return; }
+  try {
+    const feed = await api.post(`/api/v1/channels/${channelId}/feeds`, { url });
+    input.value = '';
+    document.getElementById('feed-list').insertAdjacentHTML('beforeend', feedRow(feed));
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
+async function deleteFeed(id) {
+  if (!confirm('Stop following this feed? What it posted stays.')) return;
+  try {
+    await api.del(`/api/v1/feeds/${id}`);
+    document.querySelector(`.feed-row[data-feed-id="${id}"]`)?.remove();
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
+// Links from a text channel to channels on other Chirm servers.
+function federationFields(channelId, links) {
+  return `<div class="form-group"><label>Federation</label>
+    <p style="font-size:12px;color:var(--text-muted);margin:0 0 8px">Share this channel with a channel on another Chirm server. Its admins link theirs to
+      <code>${esc(App.publicSettings.federation)}</code>, channel <code>${esc(channelId)}</code>; messages go both ways once both sides are linked.</p>
+    <div id="federation-list">${links.map(federationRow).join('')}</div>
+    <div style="display:flex;gap:6px;margin-top:6px">
+      <input type="text" id="federation-server" placeholder="https://chat.example.org" style="flex:1">
+      <input type="text" id="federation-channel" placeholder="Their channel ID" style="flex:1">
+      <button type="button" class="btn btn-secondary" onclick="createFederationLink('${channelId}')">Link</button>
+    </div></div>`;
+}
+
+function federationRow(l) {
+  const status = l.last_error
+    ? `<span style="color:var(--danger)" title="${escAttr(l.last_error)}">· failing${l.pending ? `, ${l.pending} waiting` : ''}</span>`
+    : l.pending ? `<span style="color:var(--text-muted)">· ${l.pending} waiting</span>` : '';
+  return `<div class="federation-row" data-link-id="${l.id}" style="display:flex;align-items:center;gap:8px;padding:4px 0">
+    <span style="flex:1;font-size:13px;min-width:0;overflow:hidden;text-overflow:ellipsis;white-space:nowrap" title="${escAttr(l.server + ' · ' + l.remote_channel_id)}">🌐 ${esc(l.server.replace(/^https?:\/\//, ''))} ${status}</span>
+    <button type="button" class="btn btn-danger btn-sm" onclick="deleteFederationLink('${l.id}')">Remove</button>
+  </div>`;
+}
+
+async function createFederationLink(channelId) {
+  const server = document.getElementById('federation-server');
+  const channel = document.getElementById('federation-channel');
+  if (!server.value.trim() || !channel.value.trim()) { toast('Enter the server\'s URL and its channel\'s ID', 'error'); return; }
+  try {
+    const link = await api.post(`/api/v1/channels/${channelId}/federation`, { server: server.value.trim(), channel_id: channel.value.trim() });
+    server.value = channel.value = '';
+    document.getElementById('federation-list').insertAdjacentHTML('beforeend', federationRow(link));
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
+async function deleteFederationLink(id) {
+  if (!confirm('Unlink this channel? Messages already shared stay on both servers.')) return;
+  try {
+    await api.del(`/api/v1/federation/links/${id}`);
+    document.querySelector(`.federation-row[data-link-id="${id}"]`)?.remove();
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
+async function confirmDeleteChannel(id) {
+  const ch = App.channels.find(c => c.id === id);
+  if (!confirm(`Delete #${ch?.name}? All messages will be lost.`)) return;
+  await api.del(`/api/v1/channels/${id}`);
+  await loadChannels();
+  renderChannelList();
+}
+
+// ─── CATEGORY MANAGEMENT ──────────────────────────────────────────────────────
+function openCreateCategory() {
+  const form = `<div class="form-group"><label>Category Name</label><input type="text" id="new-cat-name" placeholder="e.g. General, Gaming, Info"></div>`;
+  showSimpleModal('New Category', form, async () => {
+    const name = document.getElementById('new-cat-name').value.trim();
+    if (!name) { toast('Name required', 'error'); return false; }
+    await api.post('/api/v1/channel-categories', { name, guild_id: App.guild });
+    await loadChannels();
+    renderChannelList();
+  });
+}
+
+function openEditCategory(id) {
+  const cat = App.categories.find(c => c.id === id);
+  if (!cat) return;
+  const form = `<div class="form-group"><label>Category Name</label><input type="text" id="edit-cat-name" value="${esc(cat.name)}"></div>`;
+  showSimpleModal('Rename Category', form, async () => {
+    const name = document.getElementById('edit-cat-name').value.trim();
+    if (!name) { toast('Name required', 'error'); return false; }
+    await api.put(`/api/v1/channel-categories/${id}`, { name });
+    await loadChannels();
+    renderChannelList();
+  });
+}
+
+async function confirmDeleteCategory(id) {
+  const cat = App.categories.find(c => c.id === id);
+  const count = App.channels.filter(c => c.category_id === id).length;
+  const msg = count > 0
+    ? `Delete category "${cat?.name}"? ${count} channel(s) will become uncategorized.`
+    : `Delete category "${cat?.name}"?`;
+  if (!confirm(msg)) return;
+  await api.del(`/api/v1/channel-categories/${id}`);
+  await loadChannels();
+  renderChannelList();
+}
+
+// ─── ROLE MANAGEMENT ──────────────────────────────────────────────────────────
+const PERMS = [
+  { bit: 1, label: 'Read Messages' },
+  { bit: 2, label: 'Send Messages' },
+  { bit: 4, label: 'Manage Messages' },
+  { bit: 8, label: 'Manage Channels' },
+  { bit: 16, label: 'Manage Roles' },
+  { bit: 32, label: 'Manage Server' },
+  { bit: 64, label: 'Administrator' },
+  { bit: 128, label: 'Connect (voice)' },
+  { bit: 256, label: 'Speak (voice)' },
+  { bit: 512, label: 'Video (voice)' },
+  { bit: 1024, label: 'Screen Share (voice)' },
+  { bit: 2048, label: 'Mute Members (voice)' },
+  { bit: 4096, label: 'Record Voice' },
+  { bit: 8192, label: 'Soundboard (voice)' },
+  { bit: 16384, label: 'Broadcast (voice)' },
+  { bit: 32768, label: 'Manage Events' },
+];
+const VOICE_PERMS = PERMS.filter(p => (p.bit >= 128 && p.bit <= 1024) || p.bit === 8192 || p.bit === 16384);
+
+function permCheckboxes(current = 0) {
+  return PERMS.map(p => `
+    <label style="display:flex;align-items:center;gap:8px;font-size:13.5px;font-weight:400;text-transform:none;letter-spacing:0;margin-bottom:6px;cursor:pointer">
+      <input type="checkbox" data-perm="${p.bit}" ${(current & p.bit) ? 'checked' : ''}>
+      ${p.label}
+    </label>`).join('');
+}
+
+function getPermValue(container) {
+  let val = 0;
+  container.querySelectorAll('[data-perm]').forEach(cb => {
+    if (cb.checked) val |= parseInt(cb.dataset.perm);
+  });
+  return val;
+}
+
+function openCreateRole() {
+  const form = `
+    <div class="form-group"><label>Role Name</label><input type="text" id="new-role-name" placeholder="Moderator"></div>
     <div class="form-group"><label>Color</label><input type="color" id="new-role-color" value="#7c6af5" style="height:38px;cursor:pointer"></div>
     <div class="form-group"><label>Permissions</label><div id="role-perms">${permCheckboxes(3)}</div></div>
   `;
@@ -2492,7 +4055,7 @@ function openCreateRole() {
     const name = document.getElementById('new-role-name').value.trim();
     if (!name) { toast('Name required', 'error'); return false; }
     const perms = getPermValue(document.getElementById('role-perms'));
-    await api.post('/api/roles', { name, color: document.getElementById('new-role-color').value, permissions: perms });
+    await api.post('/api/v1/roles', { name, color: document.getElementById('new-role-color').value, permissions: perms });
     toast('Role created', 'success');
     loadAdminUsers();
   });
@@ -2508,7 +4071,7 @@ function openEditRole(id) {
   `;
   showSimpleModal('Edit Role', form, async () => {
     const perms = getPermValue(document.getElementById('edit-role-perms'));
-    await api.put(`/api/roles/${id}`, {
+    await api.put(`/api/v1/roles/${id}`, {
       name: document.getElementById('edit-role-name').value,
       color: document.getElementById('edit-role-color').value,
       permissions: perms,
@@ -2520,9 +4083,9 @@ function openEditRole(id) {
 }
 
 async function openAssignRole(userId) {
-  const roles = await api.get('/api/roles');
-  const user = await api.get(`/api/me`); // we can only get current user easily; use admin list
-  const allUsers = await api.get('/api/users');
+  const roles = await api.get('/api/v1/roles');
+  const user = await api.get(`/api/v1/me`); // we can only get current user easily; use admin list
+  const allUsers = await api.get('/api/v1/users');
   const u = allUsers.find(x => x.id === userId);
   const assignedIds = new Set((u?.roles||[]).map(r => r.id));
 
@@ -2539,8 +4102,8 @@ async function openAssignRole(userId) {
     for (const cb of checkboxes) {
       const roleId = cb.dataset.roleId;
       const wasAssigned = assignedIds.has(roleId);
-      if (cb.checked && !wasAssigned) await api.post(`/api/users/${userId}/roles/${roleId}`, {});
-      if (!cb.checked && wasAssigned) await api.del(`/api/users/${userId}/roles/${roleId}`);
+      if (cb.checked && !wasAssigned) await api.post(`/api/v1/users/${userId}/roles/${roleId}`, {});
+      if (!cb.checked && wasAssigned) await api.del(`/api/v1/users/${userId}/roles/${roleId}`);
     }
     toast('Roles updated', 'success');
     loadAdminUsers();
@@ -2568,6 +4131,8 @@ function openProfile() {
     </div>
     <div class="form-group"><label>Username</label><input type="text" id="profile-username" value="${esc(App.user.username)}"></div>
     <div id="avatar-upload-status" style="font-size:12px;color:var(--text-muted);margin-top:-8px;margin-bottom:8px"></div>
+    ${ircPorts() && !App.user.bot ? personalTokenFields() : ''}
+    ${App.user.bot ? '' : connectedAppsFields()}
   `;
 
   showSimpleModal('Edit Profile', form, async () => {
@@ -2584,7 +4149,7 @@ function openProfile() {
       const statusEl = document.getElementById('avatar-upload-status');
       if (statusEl) statusEl.textContent = 'Uploading avatar…';
       try {
-        const res = await fetch('/api/me/avatar', {
+        const res = await fetch('/api/v1/me/avatar', {
           method: 'POST',
           credentials: 'include',
           body: formData,
@@ -2606,7 +4171,7 @@ function openProfile() {
     }
 
     try {
-      App.user = await api.put('/api/me', { username, avatar: avatarUrl });
+      App.user = await api.put('/api/v1/me', { username, avatar: avatarUrl });
       renderUserPanel();
       toast('Profile updated', 'success');
     } catch (e) { toast(e.message, 'error'); return false; }
@@ -2631,9 +4196,84 @@ function openProfile() {
   }, 50);
 }
 
+// Personal tokens, for signing in to the IRC gateway.
+function personalTokenFields() {
+  api.get('/api/v1/me/tokens').then(tokens => {
+    const list = document.getElementById('token-list');
+    if (list) list.innerHTML = tokens.map(personalTokenRow).join('');
+  }).catch(() => {});
+  return `<div class="form-group"><label>Personal Tokens</label>
+    <p style="font-size:12px;color:var(--text-muted);margin:0 0 8px">Use one as the server password to connect an IRC client to ${esc(location.hostname)}, port ${ircPorts()}.</p>
+    <div id="token-list"></div>
+    <div id="token-new"></div>
+    <div style="display:flex;gap:6px;margin-top:6px">
+      <input type="text" id="token-name" placeholder="Token name, e.g. weechat" style="flex:1">
+      <button type="button" class="btn btn-secondary" onclick="createPersonalToken()">Create</button>
+    </div></div>`;
+}
+
+function personalTokenRow(t) {
+  const used = t.last_used ? `last used ${new Date(t.last_used).toLocaleDateString()}` : 'never used';
+  return `<div class="token-row" data-token-id="${t.id}" style="display:flex;align-items:center;gap:8px;padding:4px 0">
+    <span style="flex:1;font-size:13px">🔑 ${esc(t.name)} <span style="color:var(--text-muted)">· ${used}</span></span>
+    <button type="button" class="btn btn-danger btn-sm" onclick="deletePersonalToken('${t.id}')">Delete</button>
+  </div>`;
+}
+
+async function createPersonalToken() {
+  const input = document.getElementById('token-name');
+  const name = input.value.trim();
+  if (!name) { toast('Name required', 'error'); return; }
+  try {
+    const res = await api.post('/api/v1/me/tokens', { name });
+    input.value = '';
+    document.getElementById('token-list').insertAdjacentHTML('beforeend', personalTokenRow(res));
+    document.getElementById('token-new').innerHTML = `
+      <p style="font-size:12px;color:var(--text-muted);margin:6px 0 4px">Copy this token now — it won't be shown again.</p>
+      <input type="text" readonly value="${escAttr(res.token)}" onclick="this.select()">`;
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
+async function deletePersonalToken(id) {
+  if (!confirm('Delete this token? Clients signed in with it will be disconnected.')) return;
+  try {
+    await api.del(`/api/v1/me/tokens/${id}`);
+    document.querySelector(`.token-row[data-token-id="${id}"]`)?.remove();
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
+// Apps the user let sign them in with Chirm; only shown once there are some.
+function connectedAppsFields() {
+  api.get('/api/v1/me/oauth').then(apps => {
+    const el = document.getElementById('oauth-apps');
+    if (!el || !apps.length) return;
+    el.innerHTML = `<div class="form-group"><label>Connected Apps</label>
+      <p style="font-size:12px;color:var(--text-muted);margin:0 0 8px">Apps you've signed in to with this account. Revoking one signs you out of it.</p>
+      ${apps.map(a => `<div class="oauth-app-row" data-client-id="${escAttr(a.client_id)}" style="display:flex;align-items:center;gap:8px;padding:4px 0">
+        <span style="flex:1;font-size:13px">🔗 ${esc(a.client_name)} <span style="color:var(--text-muted)">· since ${new Date(a.created_at).toLocaleDateString()}</span></span>
+        <button type="button" class="btn btn-danger btn-sm" onclick="revokeOAuthApp('${escAttr(a.client_id)}')">Revoke</button>
+      </div>`).join('')}</div>`;
+  }).catch(() => {});
+  return '<div id="oauth-apps"></div>';
+}
+
+async function revokeOAuthApp(clientId) {
+  if (!confirm('Revoke this app? You\'ll be signed out of it, and it will ask again next time.')) return;
+  try {
+    await api.del(`/api/v1/me/oauth/${clientId}`);
+    document.querySelector(`.oauth-app-row[data-client-id="${clientId}"]`)?.remove();
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
 async function clearAvatar() {
   try {
-    App.user = await api.put('/api/me', { username: App.user.username, avatar: '' });
+    App.user = await api.put('/api/v1/me', { username: App.user.username, avatar: '' });
     renderUserPanel();
     toast('Avatar removed', 'success');
     document.querySelector('.modal-overlay')?.remove();
@@ -2685,7 +4325,7 @@ async function logout() {
   if (typeof ChirmNotifs !== 'undefined') {
     await ChirmNotifs.unsubscribePush().catch(() => {});
   }
-  await api.post('/api/auth/logout', {});
+  await api.post('/api/v1/auth/logout', {});
   window.location.href = '/login';
 }
 
@@ -2693,17 +4333,27 @@ async function renderAdminEmojis() {
   const el = document.getElementById('admin-emojis-list');
   if (!el) return;
 
-  const emojis = await api.get('/api/emojis').catch(() => []);
+  const emojis = await api.get('/api/v1/emojis').catch(() => []);
   App.customEmojis = emojis;
 
   const used = emojis.length;
 
   el.innerHTML = `
-    <div style="margin-bottom:16px">
+    <div style="margin-bottom:16px;display:flex;gap:8px;flex-wrap:wrap;align-items:center">
       <label class="btn btn-primary btn-sm" style="cursor:pointer;display:inline-flex;align-items:center;gap:8px">
         📤 Upload Emoji
         <input type="file" id="emoji-upload-file" accept="image/png,image/gif,image/webp,image/jpeg" style="display:none" onchange="adminUploadEmojiSelect(this)">
       </label>
+      <label class="btn btn-secondary btn-sm" style="cursor:pointer;display:inline-flex;align-items:center;gap:8px">
+        📦 Import Pack
+        <input type="file" accept=".zip,application/zip" style="display:none" onchange="adminImportEmojiPack(this)">
+      </label>
+      <select id="emoji-import-conflict" title="When an emoji's name is taken" style="padding:4px 8px;background:var(--bg-input);color:var(--text-primary);border:1px solid var(--border-strong);border-radius:var(--radius-sm);font-size:12px">
+        <option value="skip">Skip taken names</option>
+        <option value="rename">Rename if taken</option>
+        <option value="replace">Replace if taken</option>
+      </select>
+      ${emojis.length ? '<a class="btn btn-secondary btn-sm" href="/api/v1/emojis/export" download>📥 Export Pack</a>' : ''}
     </div>
     <div id="emoji-upload-form" style="display:none;background:var(--bg-elevated);border:1px solid var(--border);border-radius:var(--radius);padding:16px;margin-bottom:16px">
       <div class="form-group">
@@ -2725,7 +4375,7 @@ async function renderAdminEmojis() {
       <tbody>${emojis.map(e => `
         <tr>
           <td><img src="/uploads/${esc(e.filename)}" style="width:32px;height:32px;object-fit:contain;border-radius:4px"></td>
-          <td><code style="font-family:'Space Mono',monospace;font-size:13px">:${esc(e.name)}:</code></td>
+          <td><code style="font-family:'Space Mono',monospace;font-size:13px">:${esc(e.name)}:</code>${e.animated ? ' <span class="text-muted text-sm">animated</span>' : ''}</td>
           <td>${esc(e.uploader?.username || 'Unknown')}</td>
           <td><button class="btn btn-sm btn-danger" onclick="adminDeleteEmoji('${e.id}','${esc(e.name)}')">Delete</button></td>
         </tr>`).join('')}
@@ -2738,7 +4388,7 @@ let pendingEmojiFile = null;
 function adminUploadEmojiSelect(input) {
   const file = input.files[0];
   if (!file) return;
-  if (file.size > 256 * 1024) { toast('Emoji image must be under 256KB', 'error'); return; }
+  if (file.size > 2 * 1024 * 1024) { toast('Emoji image must be under 2MB', 'error'); return; }
   pendingEmojiFile = file;
   document.getElementById('emoji-upload-form').style.display = 'block';
   const reader = new FileReader();
@@ -2765,7 +4415,7 @@ async function adminDoUploadEmoji() {
   formData.append('name', name);
 
   try {
-    const res = await fetch('/api/emojis', { method: 'POST', credentials: 'include', body: formData });
+    const res = await fetch('/api/v1/emojis', { method: 'POST', credentials: 'include', body: formData });
     const data = await res.json();
     if (!res.ok) throw new Error(data.error || `HTTP ${res.status}`);
     toast(`Emoji :${name}: uploaded!`, 'success');
@@ -2776,15 +4426,824 @@ async function adminDoUploadEmoji() {
   }
 }
 
+// Imports a ZIP of emoji, reporting what was added and what wasn't.
+async function adminImportEmojiPack(input) {
+  const file = input.files[0];
+  input.value = '';
+  if (!file) return;
+  if (file.size > 50 * 1024 * 1024) { toast('Emoji packs must be under 50MB', 'error'); return; }
+  const formData = new FormData();
+  formData.append('pack', file);
+  formData.append('conflict', document.getElementById('emoji-import-conflict')?.value || 'skip');
+  toast(`Importing ${file.name}…`);
+  try {
+    const res = await fetch('/api/v1/emojis/import', { method: 'POST', credentials: 'include', body: formData });
+    const data = await res.json();
+    if (!res.ok) throw new Error(data.error || `HTTP ${res.status}`);
+    const parts = [`${data.added.length} added`];
+    if (data.replaced.length) parts.push(`${data.replaced.length} replaced`);
+    if (data.skipped.length) parts.push(`${data.skipped.length} skipped`);
+    toast(`Emoji pack imported: ${parts.join(', ')}`, data.added.length ? 'success' : 'error');
+    await renderAdminEmojis();
+    if (data.skipped.length) {
+      document.getElementById('emoji-upload-form')?.insertAdjacentHTML('beforebegin', `
+        <div style="background:var(--bg-elevated);border:1px solid var(--border);border-radius:var(--radius);padding:12px 16px;margin-bottom:16px;font-size:13px">
+          <div style="margin-bottom:6px;color:var(--text-secondary)">Not imported from ${esc(file.name)}:</div>
+          ${data.skipped.map(s => `<div><code>:${esc(s.name)}:</code> <span class="text-muted">${esc(s.reason)}</span></div>`).join('')}
+        </div>`);
+    }
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
 async function adminDeleteEmoji(id, name) {
   if (!confirm(`Delete emoji :${name}:? It will stop rendering in messages.`)) return;
   try {
-    await api.del(`/api/emojis/${id}`);
+    await api.del(`/api/v1/emojis/${id}`);
     toast(`Emoji :${name}: deleted`, 'success');
     await renderAdminEmojis();
   } catch (e) { toast(e.message, 'error'); }
 }
 
+async function renderAdminStickers() {
+  const el = document.getElementById('admin-stickers-list');
+  if (!el) return;
+
+  const stickers = await api.get('/api/v1/stickers').catch(() => []);
+  App.stickers = stickers;
+
+  el.innerHTML = `
+    <div style="margin-bottom:16px">
+      <label class="btn btn-primary btn-sm" style="cursor:pointer;display:inline-flex;align-items:center;gap:8px">
+        📤 Upload Sticker
+        <input type="file" id="sticker-upload-file" accept="image/png,image/gif,image/webp,image/jpeg" style="display:none" onchange="adminUploadStickerSelect(this)">
+      </label>
+    </div>
+    <div id="sticker-upload-form" style="display:none;background:var(--bg-elevated);border:1px solid var(--border);border-radius:var(--radius);padding:16px;margin-bottom:16px">
+      <div class="form-group">
+        <label>Preview</label>
+        <img id="sticker-upload-preview" style="max-width:160px;max-height:160px;border-radius:var(--radius-sm);border:1px solid var(--border)" alt="preview">
+      </div>
+      <div class="form-group">
+        <label>Sticker Name</label>
+        <input type="text" id="sticker-upload-name" placeholder="e.g. thumbs up cat" maxlength="32">
+      </div>
+      <div class="form-group">
+        <label>Description <span style="color:var(--text-muted);font-size:12px">(optional, shown on hover)</span></label>
+        <input type="text" id="sticker-upload-description" maxlength="100">
+      </div>
+      <div style="display:flex;gap:8px">
+        <button class="btn btn-primary btn-sm" onclick="adminDoUploadSticker()">Upload</button>
+        <button class="btn btn-secondary btn-sm" onclick="document.getElementById('sticker-upload-form').style.display='none'">Cancel</button>
+      </div>
+    </div>
+    <h4 style="margin-bottom:8px;color:var(--text-secondary);font-size:13px">${stickers.length} sticker${stickers.length !== 1 ? 's' : ''}</h4>
+    ${stickers.length ? `<table class="data-table">
+      <thead><tr><th>Image</th><th>Name</th><th>Description</th><th>Actions</th></tr></thead>
+      <tbody>${stickers.map(s => `
+        <tr>
+          <td><img src="/uploads/${esc(s.filename)}" style="width:48px;height:48px;object-fit:contain;border-radius:4px"></td>
+          <td>${esc(s.name)}${s.animated ? ' <span class="text-muted text-sm">animated</span>' : ''}</td>
+          <td class="text-muted text-sm">${esc(s.description || '')}</td>
+          <td><button class="btn btn-sm btn-danger" onclick="adminDeleteSticker('${s.id}')">Delete</button></td>
+        </tr>`).join('')}
+      </tbody>
+    </table>` : '<p class="text-muted" style="font-size:13px">No stickers yet. Images are stored at up to 320px; animated GIF, APNG and WebP must already fit.</p>'}
+  `;
+}
+
+let pendingStickerFile = null;
+function adminUploadStickerSelect(input) {
+  const file = input.files[0];
+  if (!file) return;
+  if (file.size > 2 * 1024 * 1024) { toast('Sticker image must be under 2MB', 'error'); return; }
+  pendingStickerFile = file;
+  document.getElementById('sticker-upload-form').style.display = 'block';
+  const preview = document.getElementById('sticker-upload-preview');
+  if (preview) preview.src = URL.createObjectURL(file);
+  const nameInput = document.getElementById('sticker-upload-name');
+  if (nameInput && !nameInput.value) nameInput.value = file.name.replace(/\.[^.]+$/, '').slice(0, 32);
+}
+
+async function adminDoUploadSticker() {
+  if (!pendingStickerFile) { toast('No file selected', 'error'); return; }
+  const name = document.getElementById('sticker-upload-name')?.value?.trim();
+  if (!name) { toast('Name required', 'error'); return; }
+
+  const formData = new FormData();
+  formData.append('image', pendingStickerFile);
+  formData.append('name', name);
+  formData.append('description', document.getElementById('sticker-upload-description')?.value?.trim() || '');
+
+  try {
+    const res = await fetch('/api/v1/stickers', { method: 'POST', credentials: 'include', body: formData });
+    const data = await res.json();
+    if (!res.ok) throw new Error(data.error || `HTTP ${res.status}`);
+    toast(`Sticker "${name}" uploaded!`, 'success');
+    pendingStickerFile = null;
+    await renderAdminStickers();
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
+async function adminDeleteSticker(id) {
+  if (!confirm('Delete this sticker? Messages that used it will show it as unavailable.')) return;
+  try {
+    await api.del(`/api/v1/stickers/${id}`);
+    toast('Sticker deleted', 'success');
+    await renderAdminStickers();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+async function renderAdminAudit() {
+  const el = document.getElementById('admin-audit-list');
+  if (!el) return;
+
+  const entries = await api.get('/api/v1/audit-log?limit=100').catch(() => []);
+  el.innerHTML = entries.length ? `<table class="data-table">
+      <thead><tr><th>When</th><th>By</th><th>Action</th><th>Details</th></tr></thead>
+      <tbody>${entries.map(e => `
+        <tr>
+          <td class="text-sm" style="white-space:nowrap">${formatTime(e.created_at)}</td>
+          <td>${e.actor_id ? esc(e.actor_name || 'Deleted User') : '<span class="text-muted">Chirm</span>'}</td>
+          <td><code style="font-family:'Space Mono',monospace;font-size:12px">${esc(e.action)}</code></td>
+          <td class="text-sm">${esc(e.details || '')}</td>
+        </tr>`).join('')}
+      </tbody>
+    </table>` : '<p class="text-muted" style="font-size:13px">Nothing has been logged yet.</p>';
+}
+
+// IP allow and deny rules. With any allow rules, only those networks get in.
+async function renderAdminAccess() {
+  const el = document.getElementById('admin-access-list');
+  if (!el) return;
+
+  const [rules, maint, frozen, version, recovery, jwtKeys, jobs, bots] = await Promise.all([
+    api.get('/api/v1/ip-rules').catch(() => []),
+    api.get('/api/v1/maintenance').catch(() => ({})),
+    api.get('/api/v1/read-only').catch(() => ({})),
+    api.get('/api/v1/client-version').catch(() => ({})),
+    App.user?.is_owner ? api.get('/api/v1/me/recovery-codes').catch(() => null) : null,
+    App.user?.is_owner ? api.get('/api/v1/admin/jwt-keys').catch(() => null) : null,
+    api.get('/api/v1/admin/jobs').catch(() => []),
+    api.get('/api/v1/bots').catch(() => []),
+  ]);
+  const allowing = rules.some(r => r.action === 'allow');
+  el.innerHTML = `
+    <div style="background:var(--bg-elevated);border:1px solid var(--border);border-radius:var(--radius);padding:16px;margin-bottom:20px">
+      <label style="display:flex;align-items:center;gap:8px;font-weight:600">
+        <input type="checkbox" id="maintenance-enabled" ${maint.enabled ? 'checked' : ''}> Maintenance mode
+      </label>
+      <p class="text-muted" style="font-size:13px;margin:8px 0 12px">
+        ${maint.enabled ? `On since ${formatTime(maint.since)}. Only admins can use the server.` : 'Closes the server to everyone but admins, for backups and upgrades. Everyone else is disconnected and sees a notice until it is turned off.'}
+      </p>
+      <div style="display:flex;gap:8px;flex-wrap:wrap">
+        <input type="text" id="maintenance-message" placeholder="Message to show (optional)" maxlength="500" value="${esc(maint.message || '')}" style="flex:1;min-width:200px">
+        <button class="btn btn-primary btn-sm" onclick="adminSaveMaintenance()">Save</button>
+      </div>
+    </div>
+    <div style="background:var(--bg-elevated);border:1px solid var(--border);border-radius:var(--radius);padding:16px;margin-bottom:20px">
+      <label style="display:flex;align-items:center;gap:8px;font-weight:600">
+        <input type="checkbox" id="read-only-enabled" ${frozen.enabled ? 'checked' : ''}> Read-only mode
+      </label>
+      <p class="text-muted" style="font-size:13px;margin:8px 0 12px">
+        ${frozen.enabled ? `On since ${formatTime(frozen.since)}. Only admins can post, upload or sign people up.` : 'Freezes the server during an incident: nobody but admins can send messages, upload or register, while everyone can still read and talk in voice.'}
+      </p>
+      <div style="display:flex;gap:8px;flex-wrap:wrap">
+        <input type="text" id="read-only-message" placeholder="Reason to show (optional)" maxlength="500" value="${esc(frozen.message || '')}" style="flex:1;min-width:200px">
+        <button class="btn btn-primary btn-sm" onclick="adminSaveReadOnly()">Save</button>
+      </div>
+    </div>
+    <div style="background:var(--bg-elevated);border:1px solid var(--border);border-radius:var(--radius);padding:16px;margin-bottom:20px;display:flex;align-items:center;gap:12px;flex-wrap:wrap">
+      <p class="text-muted" style="font-size:13px;margin:0;flex:1;min-width:200px">
+        This server has web app build ${version.build || '?'}${version.min_build ? `, and tabs before build ${version.min_build} reload` : ''}. After an upgrade, have open tabs reload so they don't run the old app against the new server.
+      </p>
+      <button class="btn btn-sm" onclick="adminReloadClients()">Reload Open Tabs</button>
+    </div>
+    ${jobs.length ? `<div style="background:var(--bg-elevated);border:1px solid var(--border);border-radius:var(--radius);padding:16px;margin-bottom:20px">
+      <p class="text-muted" style="font-size:13px;margin:0 0 8px">
+        Background jobs. Shared ones run on one instance at a time when several share this server's data.
+      </p>
+      <table class="data-table">
+        <thead><tr><th>Job</th><th>Last run</th><th>Next run</th><th>Status</th><th></th></tr></thead>
+        <tbody>${jobs.map(j => `
+          <tr>
+            <td><code style="font-family:'Space Mono',monospace;font-size:12px">${esc(j.name)}</code>${j.shared ? ' <span class="text-muted text-sm">shared</span>' : ''}</td>
+            <td class="text-sm" style="white-space:nowrap">${j.last_run ? formatTime(j.last_run) : 'Never'}</td>
+            <td class="text-sm" style="white-space:nowrap">${j.next_run ? formatTime(j.next_run) : 'On demand'}</td>
+            <td class="text-sm">${j.running ? `Running${j.running_on ? ` on ${esc(j.running_on)}` : ''}` : j.last_error ? `<span style="color:var(--danger)" title="${esc(j.last_error)}">Failed</span>` : j.last_run ? `OK, ${j.last_took_ms} ms` : ''}</td>
+            <td><button class="btn btn-sm" onclick="adminRunJob('${esc(j.name)}')" ${j.running ? 'disabled' : ''}>Run Now</button></td>
+          </tr>`).join('')}
+        </tbody>
+      </table>
+    </div>` : ''}
+    <div style="background:var(--bg-elevated);border:1px solid var(--border);border-radius:var(--radius);padding:16px;margin-bottom:20px">
+      <p class="text-muted" style="font-size:13px;margin:0 0 12px">
+        Bots are accounts for moderation and utility bots, which sign in with a token instead of a password. Give them roles like anyone else; see the README for the gateway they connect to.
+      </p>
+      <div style="display:flex;gap:8px;flex-wrap:wrap${bots.length ? ';margin-bottom:12px' : ''}">
+        <input type="text" id="bot-username" placeholder="Bot name" maxlength="32" style="flex:1;min-width:180px">
+        <button class="btn btn-primary btn-sm" onclick="adminCreateBot()">Add Bot</button>
+      </div>
+      ${bots.map(b => `
+        <div style="display:flex;align-items:center;gap:12px;padding:4px 0;font-size:13px">
+          <span style="flex:1">${esc(b.user.username)} <span class="msg-bot-tag">BOT</span></span>
+          <span class="text-muted">Added ${formatTime(b.created_at)}</span>
+          <button class="btn btn-sm" onclick="adminResetBotToken('${b.user.id}', '${esc(b.user.username)}')">New Token</button>
+          <button class="btn btn-sm btn-danger" onclick="adminDeleteBot('${b.user.id}', '${esc(b.user.username)}')">Delete</button>
+        </div>`).join('')}
+    </div>
+    ${recovery ? `<div style="background:var(--bg-elevated);border:1px solid var(--border);border-radius:var(--radius);padding:16px;margin-bottom:20px;display:flex;align-items:center;gap:12px;flex-wrap:wrap">
+      <p class="text-muted" style="font-size:13px;margin:0;flex:1;min-width:200px">
+        You have ${recovery.left} unused recovery code${recovery.left === 1 ? '' : 's'}, for setting a new password from the sign-in page if you forget yours. Making new ones stops the old ones working.
+      </p>
+      <button class="btn btn-sm" onclick="newRecoveryCodes()">New Recovery Codes</button>
+    </div>` : ''}
+    ${jwtKeys ? `<div style="background:var(--bg-elevated);border:1px solid var(--border);border-radius:var(--radius);padding:16px;margin-bottom:20px">
+      <div style="display:flex;align-items:center;gap:12px;flex-wrap:wrap;margin-bottom:8px">
+        <p class="text-muted" style="font-size:13px;margin:0;flex:1;min-width:200px">
+          Session keys sign everyone's sign-ins. To rotate, add a key, give sessions a few days to move over to it, then retire the old one; anyone still on a retired key is signed out.
+        </p>
+        <button class="btn btn-sm" onclick="addJWTKey()">Add Key</button>
+      </div>
+      ${jwtKeys.map(k => `
+        <div style="display:flex;align-items:center;gap:12px;padding:4px 0;font-size:13px">
+          <code style="font-family:'Space Mono',monospace;font-size:12px;flex:1">${esc(k.id)}${k.id === 'env' ? ' (JWT_SECRET)' : ''}</code>
+          <span class="text-muted">${k.retired_at ? `Retired ${formatTime(k.retired_at)}` : k.signing ? 'Signing' : 'Accepted'}</span>
+          ${k.retired_at ? '' : `<button class="btn btn-sm btn-danger" onclick="retireJWTKey('${esc(k.id)}')">Retire</button>`}
+        </div>`).join('')}
+    </div>` : ''}
+    <p class="text-muted" style="font-size:13px;margin-bottom:12px">
+      ${allowing ? 'Only the allowed networks below can reach this server.' : 'Anyone can reach this server apart from denied networks.'}
+      Changes apply at once, and disconnect anyone who is now blocked. This computer (localhost) is never blocked.
+    </p>
+    <div style="display:flex;gap:8px;margin-bottom:16px;flex-wrap:wrap">
+      <select id="ip-rule-action" style="width:auto">
+        <option value="deny">Deny</option>
+        <option value="allow">Allow only</option>
+      </select>
+      <input type="text" id="ip-rule-network" placeholder="203.0.113.7 or 192.168.1.0/24" style="flex:1;min-width:180px">
+      <input type="text" id="ip-rule-note" placeholder="Note (optional)" maxlength="200" style="flex:1;min-width:140px">
+      <button class="btn btn-primary btn-sm" onclick="adminAddIPRule()">Add Rule</button>
+    </div>
+    ${rules.length ? `<table class="data-table">
+      <thead><tr><th>Rule</th><th>Network</th><th>Note</th><th>Added</th><th></th></tr></thead>
+      <tbody>${rules.map(r => `
+        <tr>
+          <td>${r.action === 'allow' ? 'Allow' : 'Deny'}</td>
+          <td><code style="font-family:'Space Mono',monospace;font-size:12px">${esc(r.network)}</code></td>
+          <td class="text-sm">${esc(r.note || '')}</td>
+          <td class="text-sm" style="white-space:nowrap">${formatTime(r.created_at)}</td>
+          <td><button class="btn btn-sm btn-danger" onclick="adminDeleteIPRule('${r.id}')">Remove</button></td>
+        </tr>`).join('')}
+      </tbody>
+    </table>` : '<p class="text-muted" style="font-size:13px">No rules yet.</p>'}
+  `;
+}
+
+async function adminSaveMaintenance() {
+  const enabled = !!document.getElementById('maintenance-enabled')?.checked;
+  try {
+    await api.put('/api/v1/maintenance', {
+      enabled,
+      message: document.getElementById('maintenance-message')?.value?.trim() || '',
+    });
+    toast(enabled ? 'Maintenance mode on' : 'Maintenance mode off', 'success');
+    await renderAdminAccess();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+async function adminSaveReadOnly() {
+  const enabled = !!document.getElementById('read-only-enabled')?.checked;
+  try {
+    await api.put('/api/v1/read-only', {
+      enabled,
+      message: document.getElementById('read-only-message')?.value?.trim() || '',
+    });
+    toast(enabled ? 'Read-only mode on' : 'Read-only mode off', 'success');
+    await renderAdminAccess();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+async function adminReloadClients() {
+  try {
+    const v = await api.post('/api/v1/admin/client-reload', {});
+    toast(`Tabs before build ${v.min_build} will reload`, 'success');
+    await renderAdminAccess();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+async function adminRunJob(name) {
+  try {
+    await api.post(`/api/v1/admin/jobs/${encodeURIComponent(name)}/run`, {});
+    toast(`Running ${name}`, 'success');
+    setTimeout(renderAdminAccess, 1000);
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+// newRecoveryCodes replaces the owner's recovery codes, after asking for
+// their password, and shows the new ones this once.
+async function newRecoveryCodes() {
+  const password = prompt('Your password, to make new recovery codes:');
+  if (!password) return;
+  try {
+    const { codes } = await api.post('/api/v1/me/recovery-codes', { password });
+    showSimpleModal('New recovery codes', `
+      <p class="text-muted" style="font-size:13px;margin-bottom:12px">Each works once. Keep them somewhere safe — they won't be shown again, and your old codes no longer work.</p>
+      <pre style="font-family:'Space Mono',monospace;font-size:14px;line-height:1.8;user-select:all">${codes.map(esc).join('\n')}</pre>`);
+    await renderAdminAccess();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+// showBotToken shows a bot's token this once.
+function showBotToken(name, token) {
+  showSimpleModal(`Token for ${name}`, `
+    <p class="text-muted" style="font-size:13px;margin-bottom:12px">The bot sends this as <code>Authorization: Bot &lt;token&gt;</code>. It won't be shown again; if it's lost, make a new one.</p>
+    <pre style="font-family:'Space Mono',monospace;font-size:13px;white-space:pre-wrap;word-break:break-all;user-select:all">${esc(token)}</pre>`);
+}
+
+async function adminCreateBot() {
+  const username = document.getElementById('bot-username')?.value?.trim();
+  if (!username) { toast('Enter a name for the bot', 'error'); return; }
+  try {
+    const { bot, token } = await api.post('/api/v1/bots', { username });
+    showBotToken(bot.username, token);
+    await renderAdminAccess();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+async function adminResetBotToken(id, name) {
+  if (!confirm(`Give ${name} a new token? The old one stops working at once.`)) return;
+  try {
+    const { token } = await api.post(`/api/v1/bots/${id}/token`, {});
+    showBotToken(name, token);
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+async function adminDeleteBot(id, name) {
+  if (!confirm(`Delete the bot ${name}?`)) return;
+  try {
+    await api.del(`/api/v1/bots/${id}`);
+    toast('Bot deleted', 'success');
+    await renderAdminAccess();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+async function addJWTKey() {
+  try {
+    await api.post('/api/v1/admin/jwt-keys', {});
+    toast('New sign-ins use the new key', 'success');
+    await renderAdminAccess();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+async function retireJWTKey(id) {
+  if (!confirm(`Retire key ${id}? Anyone whose session still uses it is signed out.`)) return;
+  try {
+    await api.del(`/api/v1/admin/jwt-keys/${id}`);
+    toast('Key retired', 'success');
+    await renderAdminAccess();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+async function adminAddIPRule() {
+  const network = document.getElementById('ip-rule-network')?.value?.trim();
+  if (!network) { toast('Enter an address or network', 'error'); return; }
+  try {
+    await api.post('/api/v1/ip-rules', {
+      network,
+      action: document.getElementById('ip-rule-action')?.value,
+      note: document.getElementById('ip-rule-note')?.value?.trim() || '',
+    });
+    toast('Rule added', 'success');
+    await renderAdminAccess();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+async function adminDeleteIPRule(id) {
+  try {
+    await api.del(`/api/v1/ip-rules/${id}`);
+    toast('Rule removed', 'success');
+    await renderAdminAccess();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+async function renderAdminSounds() {
+  const el = document.getElementById('admin-sounds-list');
+  if (!el) return;
+
+  const sounds = await api.get('/api/v1/sounds').catch(() => []);
+
+  el.innerHTML = `
+    <div style="margin-bottom:16px">
+      <label class="btn btn-primary btn-sm" style="cursor:pointer;display:inline-flex;align-items:center;gap:8px">
+        📤 Upload Sound
+        <input type="file" id="sound-upload-file" accept="audio/*" style="display:none" onchange="adminUploadSoundSelect(this)">
+      </label>
+    </div>
+    <div id="sound-upload-form" style="display:none;background:var(--bg-elevated);border:1px solid var(--border);border-radius:var(--radius);padding:16px;margin-bottom:16px">
+      <div class="form-group">
+        <label>Preview</label>
+        <audio id="sound-upload-preview" controls></audio>
+      </div>
+      <div class="form-group">
+        <label>Sound Name</label>
+        <input type="text" id="sound-upload-name" placeholder="e.g. airhorn" maxlength="32">
+      </div>
+      <div style="display:flex;gap:8px">
+        <button class="btn btn-primary btn-sm" onclick="adminDoUploadSound()">Upload</button>
+        <button class="btn btn-secondary btn-sm" onclick="document.getElementById('sound-upload-form').style.display='none'">Cancel</button>
+      </div>
+    </div>
+    <h4 style="margin-bottom:8px;color:var(--text-secondary);font-size:13px">${sounds.length} sound${sounds.length !== 1 ? 's' : ''}</h4>
+    ${sounds.length ? `<table class="data-table">
+      <thead><tr><th>Name</th><th>Preview</th><th>Actions</th></tr></thead>
+      <tbody>${sounds.map(s => `
+        <tr>
+          <td>${esc(s.name)}</td>
+          <td><audio controls preload="none" src="/uploads/${esc(s.filename)}" style="height:32px"></audio></td>
+          <td><button class="btn btn-sm btn-danger" onclick="adminDeleteSound('${s.id}')">Delete</button></td>
+        </tr>`).join('')}
+      </tbody>
+    </table>` : '<p class="text-muted" style="font-size:13px">No sounds yet. Clips under 512KB can be played in voice rooms.</p>'}
+  `;
+}
+
+let pendingSoundFile = null;
+function adminUploadSoundSelect(input) {
+  const file = input.files[0];
+  if (!file) return;
+  if (file.size > 512 * 1024) { toast('Sound must be under 512KB', 'error'); return; }
+  pendingSoundFile = file;
+  document.getElementById('sound-upload-form').style.display = 'block';
+  const preview = document.getElementById('sound-upload-preview');
+  if (preview) preview.src = URL.createObjectURL(file);
+  const nameInput = document.getElementById('sound-upload-name');
+  if (nameInput && !nameInput.value) nameInput.value = file.name.replace(/\.[^.]+$/, '').slice(0, 32);
+}
+
+async function adminDoUploadSound() {
+  if (!pendingSoundFile) { toast('No file selected', 'error'); return; }
+  const name = document.getElementById('sound-upload-name')?.value?.trim();
+  if (!name) { toast('Name required', 'error'); return; }
+
+  const formData = new FormData();
+  formData.append('sound', pendingSoundFile);
+  formData.append('name', name);
+
+  try {
+    const res = await fetch('/api/v1/sounds', { method: 'POST', credentials: 'include', body: formData });
+    const data = await res.json();
+    if (!res.ok) throw new Error(data.error || `HTTP ${res.status}`);
+    toast(`Sound "${name}" uploaded!`, 'success');
+    pendingSoundFile = null;
+    await renderAdminSounds();
+  } catch (e) {
+    toast(e.message, 'error');
+  }
+}
+
+async function adminDeleteSound(id) {
+  if (!confirm('Delete this sound from the soundboard?')) return;
+  try {
+    await api.del(`/api/v1/sounds/${id}`);
+    toast('Sound deleted', 'success');
+    await renderAdminSounds();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+// ─── AUTOMATIONS ──────────────────────────────────────────────────────────────
+const AUTOMATION_TRIGGERS = [['message', 'A message matches'], ['join', 'Someone joins'], ['reaction', 'Someone reacts']];
+const AUTOMATION_ACTIONS = [['reply', 'Reply'], ['add_role', 'Give a role'], ['delete_message', 'Delete the message'], ['notify_channel', 'Post in a channel']];
+
+async function renderAdminAutomations() {
+  const el = document.getElementById('admin-automations-list');
+  if (!el) return;
+  const [rules, channels, roles] = await Promise.all([
+    api.get('/api/v1/automations').catch(() => []),
+    api.get('/api/v1/channels').catch(() => []),
+    api.get('/api/v1/roles').catch(() => []),
+  ]);
+  App.automations = { rules, channels: channels.filter(c => c.type === 'text'), roles: roles.filter(r => r.name !== '@everyone') };
+  const label = (list, v) => list.find(([k]) => k === v)?.[1] || v;
+  el.innerHTML = `
+    <p class="text-muted" style="font-size:13px;margin:0 0 12px">
+      When something happens, do something about it. Replies and posts go out under the rule's name, and can use
+      <code>{user}</code>, <code>{mention}</code>, <code>{channel}</code>, <code>{server}</code>, <code>{message}</code> and <code>{emoji}</code>.
+    </p>
+    <button class="btn btn-primary btn-sm mb-16" onclick="openAutomationForm()">+ New Rule</button>
+    ${rules.length ? `<table class="data-table">
+      <thead><tr><th>Rule</th><th>When</th><th>Then</th><th>Runs</th><th>Actions</th></tr></thead>
+      <tbody>${rules.map(a => `
+        <tr style="${a.enabled ? '' : 'opacity:.55'}">
+          <td>${esc(a.name)}${a.enabled ? '' : ' <span class="text-muted text-sm">off</span>'}</td>
+          <td>${esc(label(AUTOMATION_TRIGGERS, a.trigger))}${a.pattern ? ` <code class="mono" style="font-size:11px">${esc(a.pattern)}</code>` : ''}</td>
+          <td>${a.actions.map(x => esc(label(AUTOMATION_ACTIONS, x.type))).join(', ')}</td>
+          <td title="${a.last_run ? esc(formatTime(a.last_run)) : ''}">${a.runs}</td>
+          <td>
+            <button class="btn btn-sm btn-secondary" onclick="openAutomationForm('${a.id}')">Edit</button>
+            <button class="btn btn-sm btn-danger" onclick="adminDeleteAutomation('${a.id}')">Delete</button>
+          </td>
+        </tr>`).join('')}
+      </tbody>
+    </table>` : '<p class="text-muted" style="font-size:13px">No rules yet.</p>'}`;
+}
+
+function _automationOptions(list, current, blank) {
+  return (blank ? `<option value="">${blank}</option>` : '') +
+    list.map(([v, label]) => `<option value="${escAttr(v)}" ${v === current ? 'selected' : ''}>${esc(label)}</option>`).join('');
+}
+
+function automationActionRow(act = { type: 'reply' }) {
+  const { channels, roles } = App.automations;
+  return `<div class="automation-action" style="display:flex;gap:6px;flex-wrap:wrap;margin-bottom:6px">
+    <select class="aa-type" onchange="this.parentElement.outerHTML = automationActionRow({ type: this.value })" style="width:auto">${_automationOptions(AUTOMATION_ACTIONS, act.type)}</select>
+    ${act.type === 'add_role' ? `<select class="aa-role" style="flex:1">${_automationOptions(roles.map(r => [r.id, r.name]), act.role_id)}</select>` : ''}
+    ${act.type === 'reply' || act.type === 'notify_channel' ? `<select class="aa-channel" style="width:auto">${_automationOptions(channels.map(c => [c.id, '#' + c.name]), act.channel_id, act.type === 'reply' ? 'Same channel' : '')}</select>
+      <input type="text" class="aa-content" placeholder="Text" maxlength="2000" value="${escAttr(act.content || '')}" style="flex:1;min-width:160px">` : ''}
+    <button type="button" class="btn btn-sm btn-secondary" onclick="this.parentElement.remove()">✕</button>
+  </div>`;
+}
+
+function openAutomationForm(id) {
+  const a = id ? App.automations.rules.find(r => r.id === id) : null;
+  const { channels } = App.automations;
+  showSimpleModal(a ? 'Edit Rule' : 'New Rule', `
+    <div class="form-group"><label>Name</label><input type="text" id="automation-name" maxlength="32" value="${escAttr(a?.name || '')}" placeholder="e.g. FAQ bot"></div>
+    <div class="form-group"><label>When</label>
+      <div style="display:flex;gap:6px">
+        <select id="automation-trigger" style="width:auto">${_automationOptions(AUTOMATION_TRIGGERS, a?.trigger || 'message')}</select>
+        <input type="text" id="automation-pattern" value="${escAttr(a?.pattern || '')}" placeholder="Regular expression, or emoji for reactions" style="flex:1">
+      </div></div>
+    <div class="form-group"><label>In</label>
+      <select id="automation-channel">${_automationOptions(channels.map(c => [c.id, '#' + c.name]), a?.channel_id || '', 'Any channel')}</select></div>
+    <div class="form-group"><label>Then</label>
+      <div id="automation-actions">${(a?.actions || [{ type: 'reply' }]).map(automationActionRow).join('')}</div>
+      <button type="button" class="btn btn-sm btn-secondary" onclick="document.getElementById('automation-actions').insertAdjacentHTML('beforeend', automationActionRow())">+ Action</button></div>
+    <label style="display:flex;align-items:center;gap:8px"><input type="checkbox" id="automation-enabled" ${!a || a.enabled ? 'checked' : ''}> Enabled</label>`,
+  async () => {
+    const body = {
+      name: document.getElementById('automation-name').value.trim(),
+      trigger: document.getElementById('automation-trigger').value,
+      pattern: document.getElementById('automation-pattern').value,
+      channel_id: document.getElementById('automation-channel').value,
+      enabled: document.getElementById('automation-enabled').checked,
+      actions: [...document.querySelectorAll('#automation-actions .automation-action')].map(row => ({
+        type: row.querySelector('.aa-type').value,
+        role_id: row.querySelector('.aa-role')?.value || '',
+        channel_id: row.querySelector('.aa-channel')?.value || '',
+        content: row.querySelector('.aa-content')?.value || '',
+      })),
+    };
+    if (a) await api.put(`/api/v1/automations/${a.id}`, body);
+    else await api.post('/api/v1/automations', body);
+    toast(a ? 'Rule saved' : 'Rule added', 'success');
+    await renderAdminAutomations();
+  });
+}
+
+async function adminDeleteAutomation(id) {
+  if (!confirm('Delete this rule? What it posted stays.')) return;
+  try {
+    await api.del(`/api/v1/automations/${id}`);
+    toast('Rule deleted', 'success');
+    await renderAdminAutomations();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+// ─── AUTO-MODERATION ──────────────────────────────────────────────────────────
+const AUTOMOD_FILTERS = [
+  ['banned_words', 'Banned words', 'Words and phrases, one per line; * stands for any letters.'],
+  ['links', 'Links', 'Links anywhere but these domains, one per line, and their subdomains.'],
+  ['invites', 'Invites', 'Invites to other servers.'],
+  ['repeats', 'Repeats', 'The same message over and over.'],
+  ['mentions', 'Mention spam', 'Too many @mentions in one message.'],
+];
+
+async function renderAdminAutomod() {
+  const el = document.getElementById('admin-automod-list');
+  if (!el) return;
+  const [config, flags, timeouts, channels, roles] = await Promise.all([
+    api.get('/api/v1/automod'),
+    api.get('/api/v1/automod/flags').catch(() => []),
+    api.get('/api/v1/automod/timeouts').catch(() => []),
+    api.get('/api/v1/channels').catch(() => []),
+    api.get('/api/v1/roles').catch(() => []),
+  ]).catch(e => { el.innerHTML = `<p class="text-muted">${esc(e.message)}</p>`; return []; });
+  if (!config) return;
+  const channelName = id => channels.find(c => c.id === id)?.name || 'deleted-channel';
+  const extra = (key, f) => ({
+    banned_words: `<textarea id="automod-words" rows="4" placeholder="one per line">${esc(f.words.join('\n'))}</textarea>`,
+    links: `<textarea id="automod-allow" rows="3" placeholder="example.com">${esc(f.allow.join('\n'))}</textarea>`,
+    repeats: `<label style="font-size:13px"><input type="number" id="automod-repeat-count" min="2" max="20" value="${f.count}" style="width:64px"> times within
+      <input type="number" id="automod-repeat-seconds" min="5" max="3600" value="${f.seconds}" style="width:72px"> seconds</label>`,
+    mentions: `<label style="font-size:13px">More than <input type="number" id="automod-mention-max" min="1" max="50" value="${f.max}" style="width:64px"> mentions</label>`,
+  }[key] || '');
+  const checks = (cls, list, selected) => list.map(([id, name]) =>
+    `<label style="display:inline-flex;align-items:center;gap:4px;margin-right:10px;font-size:13px">
+      <input type="checkbox" class="${cls}" value="${escAttr(id)}" ${selected.includes(id) ? 'checked' : ''}> ${esc(name)}</label>`).join('');
+  el.innerHTML = `
+    <p class="text-muted" style="font-size:13px;margin:0 0 12px">
+      Filters for what members post. Anyone with Manage Messages is left alone. What the filters do goes in the audit log.
+    </p>
+    ${AUTOMOD_FILTERS.map(([key, name, hint]) => {
+      const f = config[key];
+      return `<div class="automod-filter" data-filter="${key}" style="border:1px solid var(--border);border-radius:6px;padding:10px;margin-bottom:10px">
+        <label style="display:flex;align-items:center;gap:8px;font-weight:600"><input type="checkbox" class="af-enabled" ${f.enabled ? 'checked' : ''}> ${esc(name)}</label>
+        <p class="text-muted" style="font-size:12px;margin:4px 0 6px">${esc(hint)}</p>
+        ${extra(key, f)}
+        <div style="display:flex;gap:12px;align-items:center;flex-wrap:wrap;margin-top:6px;font-size:13px">
+          <label><input type="checkbox" class="af-delete" ${f.delete ? 'checked' : ''}> Delete</label>
+          <label><input type="checkbox" class="af-flag" ${f.flag ? 'checked' : ''}> Flag for review</label>
+          <label>Time out for <input type="number" class="af-timeout" min="0" max="10080" value="${f.timeout}" style="width:72px"> minutes</label>
+        </div>
+      </div>`;
+    }).join('')}
+    <div class="form-group"><label>Roles left alone</label>
+      <div>${checks('automod-exempt-role', roles.filter(r => r.name !== '@everyone').map(r => [r.id, r.name]), config.exempt_roles) || '<span class="text-muted text-sm">No roles</span>'}</div></div>
+    <div class="form-group"><label>Channels left alone</label>
+      <div>${checks('automod-exempt-channel', channels.filter(c => c.type === 'text').map(c => [c.id, '#' + c.name]), config.exempt_channels)}</div></div>
+    <button class="btn btn-primary btn-sm mb-16" onclick="saveAutomod()">Save</button>
+
+    <h3 style="margin:16px 0 8px">Flagged messages</h3>
+    ${flags.length ? `<table class="data-table">
+      <thead><tr><th>When</th><th>Who</th><th>Where</th><th>Message</th><th>Why</th><th>Actions</th></tr></thead>
+      <tbody>${flags.map(f => `
+        <tr>
+          <td>${esc(formatTime(f.created_at))}</td>
+          <td>${esc(f.username || 'deleted user')}</td>
+          <td>#${esc(channelName(f.channel_id))}</td>
+          <td style="max-width:260px;overflow-wrap:anywhere">${esc(f.content)}${f.message_id ? '' : ' <span class="text-muted text-sm">(not posted)</span>'}</td>
+          <td>${esc(f.reason)}</td>
+          <td>
+            <button class="btn btn-sm btn-secondary" onclick="reviewAutomodFlag('${f.id}', 'dismissed')">Dismiss</button>
+            ${f.message_id ? `<button class="btn btn-sm btn-danger" onclick="reviewAutomodFlag('${f.id}', 'deleted')">Delete</button>` : ''}
+          </td>
+        </tr>`).join('')}
+      </tbody>
+    </table>` : '<p class="text-muted" style="font-size:13px">Nothing waiting for review.</p>'}
+
+    <h3 style="margin:16px 0 8px">Timed out</h3>
+    ${timeouts.length ? `<table class="data-table">
+      <thead><tr><th>Who</th><th>Until</th><th>Why</th><th>Actions</th></tr></thead>
+      <tbody>${timeouts.map(t => `
+        <tr>
+          <td>${esc(t.username || 'deleted user')}</td>
+          <td>${esc(formatTime(t.until))}</td>
+          <td>${esc(t.reason)}</td>
+          <td><button class="btn btn-sm btn-secondary" onclick="endAutomodTimeout('${t.user_id}')">End</button></td>
+        </tr>`).join('')}
+      </tbody>
+    </table>` : '<p class="text-muted" style="font-size:13px">Nobody is timed out.</p>'}`;
+}
+
+async function saveAutomod() {
+  const lines = id => document.getElementById(id).value.split('\n').map(l => l.trim()).filter(Boolean);
+  const number = id => parseInt(document.getElementById(id).value, 10) || 0;
+  const body = {};
+  document.querySelectorAll('#admin-automod-list .automod-filter').forEach(row => {
+    body[row.dataset.filter] = {
+      enabled: row.querySelector('.af-enabled').checked,
+      delete: row.querySelector('.af-delete').checked,
+      flag: row.querySelector('.af-flag').checked,
+      timeout: parseInt(row.querySelector('.af-timeout').value, 10) || 0,
+    };
+  });
+  body.banned_words.words = lines('automod-words');
+  body.links.allow = lines('automod-allow');
+  body.repeats.count = number('automod-repeat-count');
+  body.repeats.seconds = number('automod-repeat-seconds');
+  body.mentions.max = number('automod-mention-max');
+  body.exempt_roles = [...document.querySelectorAll('.automod-exempt-role:checked')].map(c => c.value);
+  body.exempt_channels = [...document.querySelectorAll('.automod-exempt-channel:checked')].map(c => c.value);
+  try {
+    await api.put('/api/v1/automod', body);
+    toast('Auto-moderation saved', 'success');
+    await renderAdminAutomod();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+async function reviewAutomodFlag(id, outcome) {
+  if (outcome === 'deleted' && !confirm('Delete this message?')) return;
+  try {
+    await api.post(`/api/v1/automod/flags/${id}/review`, { outcome });
+    toast(outcome === 'deleted' ? 'Message deleted' : 'Flag dismissed', 'success');
+    await renderAdminAutomod();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+async function endAutomodTimeout(userId) {
+  try {
+    await api.del(`/api/v1/automod/timeouts/${userId}`);
+    toast('Timeout ended', 'success');
+    await renderAdminAutomod();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+// Apps that sign people in with Chirm, over OpenID Connect.
+const OAUTH_SCOPES = [
+  ['openid', 'Who they are'],
+  ['profile', 'Username and avatar'],
+  ['email', 'Email address'],
+  ['groups', 'Role names'],
+];
+
+async function renderAdminApps(editing, secret) {
+  const el = document.getElementById('admin-apps-list');
+  if (!el) return;
+  const [clients, roles] = await Promise.all([
+    api.get('/api/v1/oauth/clients'),
+    api.get('/api/v1/roles').catch(() => []),
+  ]).catch(e => { el.innerHTML = `<p class="text-muted">${esc(e.message)}</p>`; return []; });
+  if (!clients) return;
+  const c = clients.find(c => c.id === editing) || { name: '', redirect_uris: [], scopes: ['openid', 'profile', 'email'], allowed_roles: [], public: false };
+  const roleName = id => roles.find(r => r.id === id)?.name || 'deleted role';
+  const checks = (cls, list, selected) => list.map(([id, name]) =>
+    `<label style="display:inline-flex;align-items:center;gap:4px;margin-right:10px;font-size:13px">
+      <input type="checkbox" class="${cls}" value="${escAttr(id)}" ${selected.includes(id) ? 'checked' : ''}> ${esc(name)}</label>`).join('');
+  el.innerHTML = `
+    <p class="text-muted" style="font-size:13px;margin:0 0 12px">
+      Other apps on your network can sign people in with their accounts here. Point them at
+      <code>${esc(location.origin)}/.well-known/openid-configuration</code>.
+    </p>
+    ${secret ? `<div style="border:1px solid var(--accent);border-radius:6px;padding:10px;margin-bottom:12px">
+      <p style="font-size:13px;margin:0 0 4px">Client ID</p>
+      <input type="text" readonly value="${escAttr(secret.id)}" onclick="this.select()">
+      <p style="font-size:13px;margin:8px 0 4px">Client secret — copy it now, it won't be shown again.</p>
+      <input type="text" readonly value="${escAttr(secret.client_secret)}" onclick="this.select()">
+    </div>` : ''}
+    ${clients.length ? `<table class="data-table mb-16">
+      <thead><tr><th>App</th><th>Client ID</th><th>Redirect URIs</th><th>Who</th><th>Actions</th></tr></thead>
+      <tbody>${clients.map(a => `
+        <tr>
+          <td>${esc(a.name)}${a.public ? ' <span class="text-muted text-sm">(public)</span>' : ''}</td>
+          <td><code style="font-size:12px">${esc(a.id)}</code></td>
+          <td style="max-width:240px;overflow-wrap:anywhere;font-size:12px">${a.redirect_uris.map(esc).join('<br>')}</td>
+          <td>${a.allowed_roles.length ? a.allowed_roles.map(id => esc(roleName(id))).join(', ') : 'Everyone'}</td>
+          <td>
+            <button class="btn btn-sm btn-secondary" onclick="renderAdminApps('${a.id}')">Edit</button>
+            <button class="btn btn-sm btn-secondary" onclick="resetOAuthSecret('${a.id}')">New Secret</button>
+            <button class="btn btn-sm btn-danger" onclick="deleteOAuthClient('${a.id}')">Delete</button>
+          </td>
+        </tr>`).join('')}
+      </tbody>
+    </table>` : '<p class="text-muted" style="font-size:13px">No apps yet.</p>'}
+
+    <h3 style="margin:16px 0 8px">${editing ? `Edit ${esc(c.name)}` : 'Add an app'}</h3>
+    <div class="form-group"><label>Name</label><input type="text" id="oauth-name" maxlength="64" value="${escAttr(c.name)}" placeholder="Grafana"></div>
+    <div class="form-group"><label>Redirect URIs</label>
+      <textarea id="oauth-redirects" rows="3" placeholder="https://grafana.home/login/generic_oauth">${esc(c.redirect_uris.join('\n'))}</textarea></div>
+    <div class="form-group"><label>What it may ask for</label>
+      <div>${checks('oauth-scope', OAUTH_SCOPES, c.scopes)}</div></div>
+    <div class="form-group"><label>Only members with these roles</label>
+      <div>${checks('oauth-role', roles.filter(r => r.name !== '@everyone').map(r => [r.id, r.name]), c.allowed_roles) || '<span class="text-muted text-sm">No roles</span>'}</div>
+      <p class="text-muted" style="font-size:12px;margin:4px 0 0">Leave all unticked to let everyone in.</p></div>
+    ${editing ? '' : `<div class="form-group"><label style="display:flex;align-items:center;gap:8px">
+      <input type="checkbox" id="oauth-public"> Public client (a single-page or mobile app that can't keep a secret; uses PKCE)</label></div>`}
+    <button class="btn btn-primary btn-sm" onclick="saveOAuthClient(${editing ? `'${editing}'` : ''})">${editing ? 'Save' : 'Add App'}</button>
+    ${editing ? '<button class="btn btn-secondary btn-sm" onclick="renderAdminApps()">Cancel</button>' : ''}`;
+}
+
+async function saveOAuthClient(id) {
+  const body = {
+    name: document.getElementById('oauth-name').value.trim(),
+    redirect_uris: document.getElementById('oauth-redirects').value.split('\n').map(l => l.trim()).filter(Boolean),
+    scopes: [...document.querySelectorAll('.oauth-scope:checked')].map(c => c.value),
+    allowed_roles: [...document.querySelectorAll('.oauth-role:checked')].map(c => c.value),
+  };
+  try {
+    if (id) {
+      await api.put(`/api/v1/oauth/clients/${id}`, body);
+      toast('App saved', 'success');
+      await renderAdminApps();
+    } else {
+      body.public = document.getElementById('oauth-public').checked;
+      const res = await api.post('/api/v1/oauth/clients', body);
+      toast('App added', 'success');
+      await renderAdminApps(null, res.client_secret ? res : null);
+    }
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+async function resetOAuthSecret(id) {
+  if (!confirm('Give this app a new secret? The old one stops working straight away.')) return;
+  try {
+    const res = await api.post(`/api/v1/oauth/clients/${id}/secret`, {});
+    await renderAdminApps(null, res);
+  } catch (e) { toast(e.message, 'error'); }
+}
+
+async function deleteOAuthClient(id) {
+  if (!confirm('Delete this app? Everyone signed in to it with this server will be signed out.')) return;
+  try {
+    await api.del(`/api/v1/oauth/clients/${id}`);
+    toast('App deleted', 'success');
+    await renderAdminApps();
+  } catch (e) { toast(e.message, 'error'); }
+}
+
 // ─── ADMIN TAB SWITCHING ──────────────────────────────────────────────────────
 function switchAdminTab(tab) {
   document.querySelectorAll('.admin-tab').forEach(el => el.classList.remove('active'));
@@ -2792,6 +5251,13 @@ function switchAdminTab(tab) {
   document.querySelector(`.admin-tab[data-tab="${tab}"]`).classList.add('active');
   document.getElementById(`admin-pane-${tab}`).classList.add('active');
   if (tab === 'emojis') renderAdminEmojis();
+  if (tab === 'stickers') renderAdminStickers();
+  if (tab === 'audit') renderAdminAudit();
+  if (tab === 'access') renderAdminAccess();
+  if (tab === 'sounds') renderAdminSounds();
+  if (tab === 'automations') renderAdminAutomations();
+  if (tab === 'automod') renderAdminAutomod();
+  if (tab === 'apps') renderAdminApps();
 }
 
 // ─── PANEL MANAGER ────────────────────────────────────────────────────────────
@@ -2982,7 +5448,7 @@ document.addEventListener('DOMContentLoaded', () => {
   // File input
   const fileInput = document.getElementById('file-input');
   fileInput.addEventListener('change', () => {
-    if (fileInput.files[0]) handleFileUpload(fileInput.files[0]);
+    handleFileUpload(fileInput.files);
     fileInput.value = '';
   });
 
@@ -2993,8 +5459,7 @@ document.addEventListener('DOMContentLoaded', () => {
   mc.addEventListener('drop', (e) => {
     e.preventDefault();
     mc.style.outline = '';
-    const file = e.dataTransfer.files[0];
-    if (file) handleFileUpload(file);
+    handleFileUpload(e.dataTransfer.files);
   });
 
   // Close modal on overlay click
@@ -0,0 +1,368 @@
+// calls.js — direct 1:1 voice/video calls for Chirm
+// The server rings the callee (call.incoming, plus a push notification) and,
+// once the call is answered, relays offer/answer/ICE between the two parties
+// as call.signal.  The caller makes the offer; media flows peer-to-peer.
+
+const Calls = (() => {
+  // ── State ─────────────────────────────────────────────────────────────────
+  // The call this tab is part of: { id, peerId, video, outgoing, answered, startedAt }
+  let call = null;
+  let pc = null;
+  let localStream = null;
+  let iceServers = [];
+  let micOn = true;
+  let camOn = false;
+  let incoming = null;        // call.incoming shown in this tab, not yet answered
+  let cancelPending = false;  // hung up before the server confirmed the call
+  let ringTone = null;
+  let timerInterval = null;
+
+  const RING_TIMEOUT_MS = 45000;
+
+  function member(uid) {
+    return App.members.find(m => m.id === uid) || { id: uid, username: 'Unknown' };
+  }
+
+  function active() { return !!call; }
+
+  // ── Placing and answering ─────────────────────────────────────────────────
+  async function start(userId, video = false) {
+    if (!userId || userId === App.user.id) return;
+    if (call) { toast("You're already in a call", 'error'); return; }
+    if (Voice.inCall()) await Voice.leave();
+    call = { id: null, peerId: userId, video, outgoing: true, answered: false };
+    cancelPending = false;
+    if (!await openMedia(video)) { call = null; return; }
+    renderPanel();
+    WS.send('call.start', { user_id: userId, video });
+  }
+
+  function onRinging(data) {
+    if (cancelPending && data.caller_id === App.user.id) {
+      cancelPending = false;
+      WS.send('call.hangup', { call_id: data.id });
+      return;
+    }
+    if (!call?.outgoing || call.id || data.callee_id !== call.peerId) return;
+    call.id = data.id;
+    startTone('outgoing');
+    renderPanel();
+  }
+
+  function onIncoming(data) {
+    if (data.callee_id !== App.user.id || call || incoming) return;
+    showIncoming(data);
+  }
+
+  function showIncoming(data) {
+    incoming = data;
+    const caller = member(data.caller_id);
+    document.getElementById('call-incoming')?.remove();
+    const el = document.createElement('div');
+    el.id = 'call-incoming';
+    el.className = 'call-incoming';
+    el.innerHTML = `
+      ${avatar(caller)}
+      <div class="call-incoming-info">
+        <strong>${esc(caller.username)}</strong>
+        <span>Incoming ${data.video ? 'video' : 'voice'} call…</span>
+      </div>
+      <div class="call-actions">
+        <button class="call-btn call-accept" onclick="Calls.accept()" title="Accept">📞</button>
+        <button class="call-btn call-hangup" onclick="Calls.decline()" title="Decline">✕</button>
+      </div>`;
+    document.body.appendChild(el);
+    startTone('incoming');
+  }
+
+  function hideIncoming() {
+    incoming = null;
+    document.getElementById('call-incoming')?.remove();
+    if (!call) stopTone();
+  }
+
+  async function accept() {
+    if (!incoming) return;
+    const data = incoming;
+    hideIncoming();
+    if (Voice.inCall()) await Voice.leave();
+    call = { id: data.id, peerId: data.caller_id, video: data.video, outgoing: false, answered: false };
+    if (!await openMedia(data.video)) {
+      WS.send('call.decline', { call_id: data.id });
+      call = null;
+      return;
+    }
+    renderPanel();
+    WS.send('call.accept', { call_id: data.id });
+  }
+
+  function decline() {
+    if (!incoming) return;
+    WS.send('call.decline', { call_id: incoming.id });
+    hideIncoming();
+  }
+
+  function hangup() {
+    if (!call) return;
+    if (call.id) WS.send('call.hangup', { call_id: call.id });
+    else cancelPending = true;
+    cleanup();
+  }
+
+  function onAccepted(data) {
+    // Answered on another of our devices.
+    if (incoming?.id === data.id) { hideIncoming(); return; }
+    if (!call || call.id !== data.id) return;
+    call.answered = true;
+    call.startedAt = Date.now();
+    stopTone();
+    createPeer();
+    if (call.outgoing) makeOffer();
+    renderPanel();
+    timerInterval = setInterval(updateStatus, 1000);
+  }
+
+  function onEnded(data) {
+    if (incoming?.id === data.id) {
+      hideIncoming();
+      if (data.status === 'missed') toast(`Missed call from ${member(data.caller_id).username}`, 'info');
+      return;
+    }
+    if (!call || call.id !== data.id) return;
+    const reasons = { declined: 'Call declined', missed: 'No answer' };
+    if (call.outgoing && reasons[data.status]) toast(reasons[data.status], 'info');
+    cleanup();
+  }
+
+  function onError(data) {
+    if (!call?.outgoing || data.user_id !== call.peerId) return;
+    toast(data.error, 'error');
+    cleanup();
+  }
+
+  // A push notification can bring the callee here after the ring started, and
+  // a reconnect can miss call.incoming; pick up a call that is still ringing.
+  async function checkRinging() {
+    if (call || incoming) return;
+    const calls = await api.get('/api/v1/calls').catch(() => []);
+    const ringing = calls.find(c => c.status === 'ringing' && c.callee_id === App.user.id &&
+      Date.now() - new Date(c.created_at).getTime() < RING_TIMEOUT_MS);
+    if (ringing) showIncoming(ringing);
+  }
+
+  // ── Media and signaling ───────────────────────────────────────────────────
+  async function openMedia(video) {
+    try {
+      localStream = await navigator.mediaDevices.getUserMedia({ audio: true, video });
+    } catch (e) {
+      if (video) localStream = await navigator.mediaDevices.getUserMedia({ audio: true }).catch(() => null);
+      if (!localStream) {
+        toast('Could not access microphone: ' + e.message, 'error');
+        return false;
+      }
+    }
+    micOn = true;
+    camOn = localStream.getVideoTracks().length > 0;
+    iceServers = await Voice.getIceServers();
+    return true;
+  }
+
+  function createPeer() {
+    if (pc) return;
+    pc = new RTCPeerConnection({ iceServers });
+    localStream.getTracks().forEach(t => pc.addTrack(t, localStream));
+    pc.onicecandidate = e => { if (e.candidate) signal({ candidate: e.candidate }); };
+    pc.ontrack = e => attachRemote(e.streams[0]);
+    pc.onconnectionstatechange = () => {
+      if (pc?.connectionState === 'failed' && call?.outgoing) makeOffer(true);
+    };
+  }
+
+  async function makeOffer(iceRestart = false) {
+    try {
+      const offer = await pc.createOffer({ iceRestart });
+      await pc.setLocalDescription({ type: 'offer', sdp: Voice.tuneOpus(offer.sdp) });
+      signal({ description: pc.localDescription });
+    } catch (e) { console.warn('[calls] offer failed:', e); }
+  }
+
+  function signal(payload) {
+    if (call?.id) WS.send('call.signal', { call_id: call.id, payload });
+  }
+
+  async function onSignal(data) {
+    if (!call?.answered || data.call_id !== call.id) return;
+    createPeer();
+    const p = data.payload || {};
+    try {
+      if (p.description) {
+        await pc.setRemoteDescription(p.description);
+        if (p.description.type === 'offer') {
+          const answer = await pc.createAnswer();
+          await pc.setLocalDescription({ type: 'answer', sdp: Voice.tuneOpus(answer.sdp) });
+          signal({ description: pc.localDescription });
+        }
+      } else if (p.candidate) {
+        await pc.addIceCandidate(p.candidate);
+      }
+    } catch (e) { console.warn('[calls] signal error:', e); }
+  }
+
+  function attachRemote(stream) {
+    const video = document.getElementById('call-remote-video');
+    if (!video || !stream) return;
+    video.srcObject = stream;
+    const refresh = () => {
+      const live = stream.getVideoTracks().some(t => !t.muted);
+      document.getElementById('call-panel')?.classList.toggle('has-remote-video', live);
+    };
+    stream.getVideoTracks().forEach(t => { t.onmute = refresh; t.onunmute = refresh; });
+    refresh();
+  }
+
+  // ── Controls ──────────────────────────────────────────────────────────────
+  function toggleMic() {
+    if (!localStream) return;
+    micOn = !micOn;
+    localStream.getAudioTracks().forEach(t => { t.enabled = micOn; });
+    renderControls();
+  }
+
+  function toggleCam() {
+    const tracks = localStream?.getVideoTracks() || [];
+    if (!tracks.length) { toast('No camera in this call', 'info'); return; }
+    camOn = !camOn;
+    tracks.forEach(t => { t.enabled = camOn; });
+    renderControls();
+  }
+
+  function cleanup() {
+    stopTone();
+    clearInterval(timerInterval);
+    timerInterval = null;
+    pc?.close();
+    pc = null;
+    localStream?.getTracks().forEach(t => t.stop());
+    localStream = null;
+    call = null;
+    document.getElementById('call-panel')?.remove();
+  }
+
+  // ── UI ────────────────────────────────────────────────────────────────────
+  function renderPanel() {
+    let panel = document.getElementById('call-panel');
+    if (!panel) {
+      const peer = member(call.peerId);
+      panel = document.createElement('div');
+      panel.id = 'call-panel';
+      panel.className = 'call-panel';
+      panel.innerHTML = `
+        <div class="call-videos">
+          <div class="call-peer">${avatar(peer)}<strong>${esc(peer.username)}</strong></div>
+          <video id="call-remote-video" autoplay playsinline></video>
+          <video id="call-local-video" autoplay playsinline muted></video>
+        </div>
+        <div class="call-status" id="call-status"></div>
+        <div class="call-actions" id="call-controls"></div>`;
+      document.body.appendChild(panel);
+      const local = panel.querySelector('#call-local-video');
+      local.srcObject = localStream;
+    }
+    updateStatus();
+    renderControls();
+  }
+
+  function renderControls() {
+    const el = document.getElementById('call-controls');
+    if (!el) return;
+    const hasCam = localStream?.getVideoTracks().length > 0;
+    document.getElementById('call-local-video').style.display = hasCam && camOn ? '' : 'none';
+    el.innerHTML = `
+      <button class="call-btn ${micOn ? '' : 'off'}" onclick="Calls.toggleMic()" title="${micOn ? 'Mute' : 'Unmute'}">${micOn ? '🎙' : '🔇'}</button>
+      ${hasCam ? `<button class="call-btn ${camOn ? '' : 'off'}" onclick="Calls.toggleCam()" title="${camOn ? 'Camera off' : 'Camera on'}">📹</button>` : ''}
+      <button class="call-btn call-hangup" onclick="Calls.hangup()" title="Hang up">✕</button>`;
+  }
+
+  function updateStatus() {
+    const el = document.getElementById('call-status');
+    if (!el || !call) return;
+    if (call.answered) {
+      el.textContent = formatDuration(Date.now() - call.startedAt);
+    } else {
+      el.textContent = call.outgoing ? (call.id ? 'Ringing…' : 'Calling…') : 'Connecting…';
+    }
+  }
+
+  function formatDuration(ms) {
+    const s = Math.max(0, Math.floor(ms / 1000));
+    const h = Math.floor(s / 3600);
+    const mm = String(Math.floor(s / 60) % 60).padStart(h ? 2 : 1, '0');
+    const ss = String(s % 60).padStart(2, '0');
+    return h ? `${h}:${mm}:${ss}` : `${mm}:${ss}`;
+  }
+
+  // Ring tones are synthesised so there's no audio asset to ship.
+  function startTone(kind) {
+    stopTone();
+    try {
+      const ctx = new (window.AudioContext || window.webkitAudioContext)();
+      const beep = () => {
+        const osc = ctx.createOscillator();
+        const gain = ctx.createGain();
+        osc.frequency.value = kind === 'incoming' ? 660 : 440;
+        gain.gain.value = 0.08;
+        osc.connect(gain).connect(ctx.destination);
+        osc.start();
+        osc.stop(ctx.currentTime + 0.4);
+      };
+      beep();
+      ringTone = { ctx, timer: setInterval(beep, kind === 'incoming' ? 1500 : 3000) };
+    } catch { /* no audio output — the UI still shows the call */ }
+  }
+
+  function stopTone() {
+    if (!ringTone) return;
+    clearInterval(ringTone.timer);
+    ringTone.ctx.close().catch(() => {});
+    ringTone = null;
+  }
+
+  async function showHistory() {
+    let calls;
+    try { calls = await api.get('/api/v1/calls'); } catch (e) { toast(e.message, 'error'); return; }
+    const rows = calls.map(c => {
+      const outgoing = c.caller_id === App.user.id;
+      const peer = member(outgoing ? c.callee_id : c.caller_id);
+      const dir = outgoing ? '↗ Outgoing' : c.answered_at ? '↙ Incoming' : '↙ Missed';
+      const detail = c.answered_at && c.ended_at
+        ? formatDuration(new Date(c.ended_at) - new Date(c.answered_at))
+        : c.status;
+      return `
+        <div class="call-history-item">
+          ${avatar(peer, 'avatar-sm')}
+          <div class="call-history-meta">
+            <strong>${esc(peer.username)}</strong>
+            <span class="${!outgoing && !c.answered_at ? 'call-missed' : ''}">${dir}${c.video ? ' video' : ''} · ${new Date(c.created_at).toLocaleString()} · ${esc(detail)}</span>
+          </div>
+          <button class="btn btn-sm btn-secondary" onclick="this.closest('.modal-overlay').remove(); Calls.start('${peer.id}', ${c.video})" title="Call back">📞</button>
+        </div>`;
+    }).join('');
+    showSimpleModal('Recent Calls', rows || '<p class="text-muted">No calls yet.</p>');
+  }
+
+  // ── Init ──────────────────────────────────────────────────────────────────
+  function init() {
+    WS.on('call.ringing', onRinging);
+    WS.on('call.incoming', onIncoming);
+    WS.on('call.accepted', onAccepted);
+    WS.on('call.signal', onSignal);
+    WS.on('call.ended', onEnded);
+    WS.on('call.error', onError);
+    WS.on('ws.connected', checkRinging);
+  }
+
+  return {
+    init, start, accept, decline, hangup, toggleMic, toggleCam,
+    showHistory, active,
+  };
+})();
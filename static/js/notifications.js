@@ -83,7 +83,7 @@ const ChirmNotifs = (() => {
       return;
     }
     try {
-      const res = await fetch('/api/push/vapid-public-key', { credentials: 'include' });
+      const res = await fetch('/api/v1/push/vapid-public-key', { credentials: 'include' });
       if (!res.ok) {
         console.warn('[Chirm Notifs] Could not fetch VAPID key:', res.status);
         return;
@@ -108,7 +108,7 @@ const ChirmNotifs = (() => {
       }
 
       // Send subscription to server
-      const saveRes = await fetch('/api/push/subscribe', {
+      const saveRes = await fetch('/api/v1/push/subscribe', {
         method: 'POST',
         credentials: 'include',
         headers: { 'Content-Type': 'application/json' },
@@ -146,7 +146,7 @@ const ChirmNotifs = (() => {
     try {
       const sub = await _swReg.pushManager.getSubscription();
       if (sub) {
-        await fetch('/api/push/unsubscribe', {
+        await fetch('/api/v1/push/unsubscribe', {
           method: 'POST',
           credentials: 'include',
           headers: { 'Content-Type': 'application/json' },
@@ -160,6 +160,22 @@ const ChirmNotifs = (() => {
     }
   }
 
+  // This device's own push level ('' follows the account, 'mentions' or
+  // 'none'), or null when the browser has no push subscription.
+  async function getDeviceLevel() {
+    const sub = await _swReg?.pushManager.getSubscription();
+    if (!sub) return null;
+    const devices = await api.get('/api/v1/push/subscriptions');
+    const mine = devices.find(d => d.endpoint === sub.endpoint);
+    return mine ? mine.level : null;
+  }
+
+  async function setDeviceLevel(level) {
+    const sub = await _swReg?.pushManager.getSubscription();
+    if (!sub) throw new Error('This device has no push subscription');
+    await api.post('/api/v1/push/subscribe', { ...sub.toJSON(), level });
+  }
+
   // ── Notification routing ─────────────────────────────────────────────────────
 
   /**
@@ -168,21 +184,16 @@ const ChirmNotifs = (() => {
    * Decides whether to show a notification based on settings.
    */
   function onNewMessage(msg, channelName) {
-    const settings = typeof ChirmSettings !== 'undefined' ? ChirmSettings.get() : {};
-
     // Never self-notify
     if (msg.user_id === App.user?.id) return;
 
     const channelId = msg.channel_id;
-    const isMuted = settings.mutedChannels?.includes(channelId);
     const isMention = _isMentioned(msg.content);
-    const pingsDisabled = settings.disablePings;
-
-    // Muted channels only get through if it's a mention AND pings aren't globally disabled
-    if (isMuted && (!isMention || pingsDisabled)) return;
+    const isEveryone = /@(everyone|here)\b/i.test(msg.content || '');
 
-    // If pings are globally off and it's only a mention, skip
-    if (!isMuted && isMention && pingsDisabled) return;
+    // Same per-channel levels the server applies to push notifications
+    if (typeof ChirmSettings !== 'undefined' &&
+        !ChirmSettings.wantsNotification(channelId, isMention, isEveryone)) return;
 
     const authorName = msg.author?.username || 'Someone';
     const isCurrentChannel = App.currentChannel?.id === channelId;
@@ -362,6 +373,8 @@ const ChirmNotifs = (() => {
     init,
     requestPermission,
     unsubscribePush,
+    getDeviceLevel,
+    setDeviceLevel,
     onNewMessage,
     isPermissionGranted,
     isPermissionDenied,
@@ -1,21 +1,30 @@
 // user-settings.js — Chirm User Settings
-// Locally persisted settings (localStorage).
-// Settings schema:
-//   disablePings: bool       — suppress all @mention notifications
-//   mutedChannels: string[]  — channel IDs where ALL notifications are muted
+// Device settings are persisted locally (localStorage):
 //   notifyGranted: bool      — whether user has been asked about notifications
 //   inBrowserOnly: bool      — suppress OS/push notifications; in-app toasts only
+// Notification levels live on the server (/api/me/notifications) so push
+// delivery can honour them:
+//   default_level: 'all' | 'mentions' | 'none'
+//   channels: { channelId: level } — per-channel overrides
+//   suppress_everyone: bool  — @everyone/@here don't count as mentions
+//   quiet_start/quiet_end: 'HH:MM' in quiet_tz — no pushes in this window
+//   quiet_allow_mentions: bool — direct @mentions still push during quiet hours
+//   email_mentions: bool     — email @mentions missed while offline (if the server has SMTP)
+//   digest: '' | 'daily' | 'weekly' — emailed summary of unread activity
+//   language: ''|code      — language of push notification text ('' = server default)
 
 const ChirmSettings = (() => {
   const STORAGE_KEY = 'chirm_user_settings';
 
   const DEFAULTS = {
-    disablePings: false,
-    mutedChannels: [],
     notifyGranted: false,
     inBrowserOnly: false,
   };
 
+  const LEVELS = { all: 'All messages', mentions: 'Only @mentions', none: 'Nothing' };
+
+  let notif = { default_level: 'all', suppress_everyone: false, email_mentions: true, digest: '', language: '', channels: {} };
+
   // ── Read / Write ────────────────────────────────────────────────────────────
 
   function get() {
@@ -40,42 +49,57 @@ const ChirmSettings = (() => {
     _save(s);
   }
 
-  // ── Convenience helpers ─────────────────────────────────────────────────────
+  // ── Server-side notification levels ─────────────────────────────────────────
 
-  function isChannelMuted(channelId) {
-    return get().mutedChannels.includes(channelId);
+  async function load() {
+    try {
+      notif = await api.get('/api/v1/me/notifications');
+    } catch { return; }
+    // Mutes used to be kept in localStorage; carry them over once.
+    const legacy = get();
+    if (!notif.saved && (legacy.mutedChannels?.length || legacy.disablePings)) {
+      for (const id of legacy.mutedChannels || []) {
+        notif.channels[id] = legacy.disablePings ? 'none' : 'mentions';
+      }
+      await saveNotif().catch(() => {});
+    }
+    if ('mutedChannels' in legacy || 'disablePings' in legacy) {
+      delete legacy.mutedChannels;
+      delete legacy.disablePings;
+      _save(legacy);
+    }
   }
 
-  function muteChannel(channelId) {
-    const s = get();
-    if (!s.mutedChannels.includes(channelId)) {
-      s.mutedChannels.push(channelId);
-      _save(s);
-    }
+  async function saveNotif() {
+    notif = await api.put('/api/v1/me/notifications', notif);
   }
 
-  function unmuteChannel(channelId) {
-    const s = get();
-    s.mutedChannels = s.mutedChannels.filter(id => id !== channelId);
-    _save(s);
+  function channelLevel(channelId) {
+    return notif.channels?.[channelId] || notif.default_level;
   }
 
-  function toggleMuteChannel(channelId) {
-    if (isChannelMuted(channelId)) {
-      unmuteChannel(channelId);
-      return false;
-    } else {
-      muteChannel(channelId);
-      return true;
+  // Whether a message should notify at all; the server applies the same rule
+  // before sending Web Push.
+  function wantsNotification(channelId, isMention, isEveryone) {
+    switch (channelLevel(channelId)) {
+      case 'all': return true;
+      case 'mentions': return isMention || (isEveryone && !notif.suppress_everyone);
+      default: return false;
     }
   }
 
-  function setDisablePings(value) {
-    set('disablePings', !!value);
+  function localTZ() {
+    try { return Intl.DateTimeFormat().resolvedOptions().timeZone || 'UTC'; } catch { return 'UTC'; }
+  }
+
+  function isChannelMuted(channelId) {
+    return channelLevel(channelId) !== 'all';
   }
 
-  function isPingsDisabled() {
-    return !!get().disablePings;
+  async function setChannelLevel(channelId, level) {
+    if (level) notif.channels[channelId] = level;
+    else delete notif.channels[channelId];
+    await saveNotif();
   }
 
   function setInBrowserOnly(value) {
@@ -94,17 +118,18 @@ const ChirmSettings = (() => {
     const notifGranted = currentPerm === 'granted';
     const notifDenied  = currentPerm === 'denied';
 
+    const levelOptions = (selected, withDefault) =>
+      (withDefault ? `<option value="" ${selected ? '' : 'selected'}>Default</option>` : '') +
+      Object.entries(LEVELS).map(([v, label]) =>
+        `<option value="${v}" ${selected === v ? 'selected' : ''}>${label}</option>`).join('');
+
     const channelRows = (App.channels || [])
-      .filter(c => c.type !== 'voice')
+      .filter(c => !isVoiceChannel(c))
       .map(ch => {
-        const muted = s.mutedChannels.includes(ch.id);
         const icon = ch.emoji ? ch.emoji : '#';
         return `<label class="settings-ch-row">
           <span class="settings-ch-name">${icon} ${esc(ch.name)}</span>
-          <span class="settings-toggle-wrap">
-            <input type="checkbox" class="ch-mute-cb" data-ch-id="${ch.id}" ${muted ? 'checked' : ''}>
-            <span class="settings-toggle-label">${muted ? 'Muted' : 'Active'}</span>
-          </span>
+          <select class="ch-level-select" data-ch-id="${ch.id}">${levelOptions(notif.channels[ch.id] || '', true)}</select>
         </label>`;
       }).join('');
 
@@ -131,10 +156,30 @@ const ChirmSettings = (() => {
 
         <label class="settings-toggle-row">
           <div>
-            <div class="settings-row-label">Disable @mention pings</div>
-            <div class="settings-row-hint">You won't receive alerts when someone @mentions you</div>
+            <div class="settings-row-label">Notify me about</div>
+            <div class="settings-row-hint">Applies to every device, including push notifications</div>
           </div>
-          <input type="checkbox" id="settings-disable-pings" ${s.disablePings ? 'checked' : ''}>
+          <select id="settings-default-level">${levelOptions(notif.default_level, false)}</select>
+        </label>
+
+        <label class="settings-toggle-row" id="settings-device-level-row" style="display:none">
+          <div>
+            <div class="settings-row-label">Push to this device</div>
+            <div class="settings-row-hint">Limit this device, e.g. only @mentions on your phone</div>
+          </div>
+          <select id="settings-device-level">
+            <option value="">Same as above</option>
+            <option value="mentions">Only @mentions</option>
+            <option value="none">Nothing</option>
+          </select>
+        </label>
+
+        <label class="settings-toggle-row">
+          <div>
+            <div class="settings-row-label">Suppress @everyone and @here</div>
+            <div class="settings-row-hint">They won't count as mentions in channels set to mentions only</div>
+          </div>
+          <input type="checkbox" id="settings-suppress-everyone" ${notif.suppress_everyone ? 'checked' : ''}>
         </label>
 
         <label class="settings-toggle-row">
@@ -144,12 +189,62 @@ const ChirmSettings = (() => {
           </div>
           <input type="checkbox" id="settings-in-browser-only" ${s.inBrowserOnly ? 'checked' : ''}>
         </label>
+
+        <label class="settings-toggle-row">
+          <div>
+            <div class="settings-row-label">Notification language</div>
+            <div class="settings-row-hint">Used for the text of push notifications</div>
+          </div>
+          <select id="settings-language"><option value="">Server default</option></select>
+        </label>
+
+        <label class="settings-toggle-row">
+          <div>
+            <div class="settings-row-label">Email me about mentions I miss</div>
+            <div class="settings-row-hint">When you're offline and no device got a push — if this server sends email</div>
+          </div>
+          <input type="checkbox" id="settings-email-mentions" ${notif.email_mentions ? 'checked' : ''}>
+        </label>
+
+        <label class="settings-toggle-row">
+          <div>
+            <div class="settings-row-label">Email digest</div>
+            <div class="settings-row-hint">A summary of unread channels, top messages and mentions</div>
+          </div>
+          <select id="settings-digest">
+            <option value="" ${!notif.digest ? 'selected' : ''}>Off</option>
+            <option value="daily" ${notif.digest === 'daily' ? 'selected' : ''}>Daily</option>
+            <option value="weekly" ${notif.digest === 'weekly' ? 'selected' : ''}>Weekly</option>
+          </select>
+        </label>
+      </div>
+
+      <div class="settings-section">
+        <h4 class="settings-section-title">Quiet Hours</h4>
+        <label class="settings-toggle-row">
+          <div>
+            <div class="settings-row-label">Pause push notifications</div>
+            <div class="settings-row-hint">Every day in ${esc(notif.quiet_tz || localTZ())}</div>
+          </div>
+          <input type="checkbox" id="settings-quiet-enabled" ${notif.quiet_start ? 'checked' : ''}>
+        </label>
+        <div class="settings-quiet-times" id="settings-quiet-times" style="${notif.quiet_start ? '' : 'display:none'}">
+          <label>From <input type="time" id="settings-quiet-start" value="${notif.quiet_start || '22:00'}"></label>
+          <label>to <input type="time" id="settings-quiet-end" value="${notif.quiet_end || '07:00'}"></label>
+        </div>
+        <label class="settings-toggle-row" id="settings-quiet-mentions-row" style="${notif.quiet_start ? '' : 'display:none'}">
+          <div>
+            <div class="settings-row-label">Let direct @mentions through</div>
+            <div class="settings-row-hint">Also lets incoming calls ring</div>
+          </div>
+          <input type="checkbox" id="settings-quiet-mentions" ${notif.quiet_allow_mentions ? 'checked' : ''}>
+        </label>
       </div>
 
       <div class="settings-section">
         <h4 class="settings-section-title">Channel Notifications</h4>
         <div class="settings-row-hint" style="margin-bottom:10px">
-          Muted channels won't show notifications unless someone @mentions you.
+          Override the default for individual channels.
         </div>
         <div class="settings-ch-list">
           ${channelRows || '<p class="text-muted" style="font-size:13px">No text channels available.</p>'}
@@ -187,7 +282,7 @@ const ChirmSettings = (() => {
         btn.disabled = true;
         btn.textContent = 'Sending…';
         try {
-          const res = await fetch('/api/push/test', { method: 'POST', credentials: 'include' });
+          const res = await fetch('/api/v1/push/test', { method: 'POST', credentials: 'include' });
           const data = await res.json();
           if (data.sent > 0) {
             toast(`Test notification sent to ${data.sent} device(s)`, 'success');
@@ -204,10 +299,56 @@ const ChirmSettings = (() => {
         btn.textContent = 'Send test';
       });
 
-      // Disable pings toggle
-      document.getElementById('settings-disable-pings')?.addEventListener('change', (e) => {
-        setDisablePings(e.target.checked);
-        toast(e.target.checked ? 'Pings muted' : 'Pings enabled', 'info');
+      document.getElementById('settings-default-level')?.addEventListener('change', async (e) => {
+        notif.default_level = e.target.value;
+        try { await saveNotif(); } catch (err) { toast(err.message, 'error'); return; }
+        if (typeof renderChannelList === 'function') renderChannelList();
+        toast('Notification settings saved', 'info');
+      });
+
+      if (typeof ChirmNotifs !== 'undefined' && !isInBrowserOnly()) {
+        ChirmNotifs.getDeviceLevel().then(level => {
+          const sel = document.getElementById('settings-device-level');
+          if (level === null || !sel) return;
+          sel.value = level;
+          document.getElementById('settings-device-level-row').style.display = '';
+        }).catch(() => {});
+      }
+      document.getElementById('settings-device-level')?.addEventListener('change', async (e) => {
+        try { await ChirmNotifs.setDeviceLevel(e.target.value); } catch (err) { toast(err.message, 'error'); return; }
+        toast('This device\'s notifications updated', 'info');
+      });
+
+      document.getElementById('settings-suppress-everyone')?.addEventListener('change', async (e) => {
+        notif.suppress_everyone = e.target.checked;
+        try { await saveNotif(); } catch (err) { toast(err.message, 'error'); return; }
+        toast(e.target.checked ? '@everyone suppressed' : '@everyone enabled', 'info');
+      });
+
+      api.get('/api/v1/push/languages').then(({ languages, default: def }) => {
+        const sel = document.getElementById('settings-language');
+        if (!sel) return;
+        const defName = languages.find(l => l.code === def)?.name || def;
+        sel.innerHTML = `<option value="">Server default (${esc(defName)})</option>` +
+          languages.map(l => `<option value="${l.code}">${esc(l.name)}</option>`).join('');
+        sel.value = notif.language || '';
+      }).catch(() => {});
+      document.getElementById('settings-language')?.addEventListener('change', async (e) => {
+        notif.language = e.target.value;
+        try { await saveNotif(); } catch (err) { toast(err.message, 'error'); return; }
+        toast('Notification language saved', 'info');
+      });
+
+      document.getElementById('settings-email-mentions')?.addEventListener('change', async (e) => {
+        notif.email_mentions = e.target.checked;
+        try { await saveNotif(); } catch (err) { toast(err.message, 'error'); return; }
+        toast(e.target.checked ? 'Mention emails on' : 'Mention emails off', 'info');
+      });
+
+      document.getElementById('settings-digest')?.addEventListener('change', async (e) => {
+        notif.digest = e.target.value;
+        try { await saveNotif(); } catch (err) { toast(err.message, 'error'); return; }
+        toast(notif.digest ? `${notif.digest[0].toUpperCase() + notif.digest.slice(1)} digest on` : 'Digest off', 'info');
       });
 
       // In-browser-only toggle
@@ -230,16 +371,29 @@ const ChirmSettings = (() => {
         }
       });
 
-      // Per-channel mute checkboxes
-      document.querySelectorAll('.ch-mute-cb').forEach(cb => {
-        cb.addEventListener('change', (e) => {
-          const chId = e.target.dataset.chId;
-          const nowMuted = toggleMuteChannel(chId);
-          const label = e.target.nextElementSibling;
-          if (label) label.textContent = nowMuted ? 'Muted' : 'Active';
+      // Quiet hours
+      const saveQuiet = async () => {
+        const on = document.getElementById('settings-quiet-enabled').checked;
+        document.getElementById('settings-quiet-times').style.display = on ? '' : 'none';
+        document.getElementById('settings-quiet-mentions-row').style.display = on ? '' : 'none';
+        notif.quiet_start = on ? document.getElementById('settings-quiet-start').value : '';
+        notif.quiet_end = on ? document.getElementById('settings-quiet-end').value : '';
+        notif.quiet_tz = on ? localTZ() : '';
+        notif.quiet_allow_mentions = document.getElementById('settings-quiet-mentions').checked;
+        try { await saveNotif(); } catch (err) { toast(err.message, 'error'); return; }
+        toast(on ? `Quiet hours ${notif.quiet_start}–${notif.quiet_end}` : 'Quiet hours off', 'info');
+      };
+      ['settings-quiet-enabled', 'settings-quiet-start', 'settings-quiet-end', 'settings-quiet-mentions']
+        .forEach(id => document.getElementById(id)?.addEventListener('change', saveQuiet));
+
+      // Per-channel levels
+      document.querySelectorAll('.ch-level-select').forEach(sel => {
+        sel.addEventListener('change', async (e) => {
+          try { await setChannelLevel(e.target.dataset.chId, e.target.value); }
+          catch (err) { toast(err.message, 'error'); return; }
           // Refresh channel list to show mute indicator
           if (typeof renderChannelList === 'function') renderChannelList();
-          toast(nowMuted ? 'Channel muted' : 'Channel unmuted', 'info');
+          toast('Channel notifications updated', 'info');
         });
       });
 
@@ -254,12 +408,11 @@ const ChirmSettings = (() => {
   return {
     get,
     set,
+    load,
+    channelLevel,
+    wantsNotification,
     isChannelMuted,
-    muteChannel,
-    unmuteChannel,
-    toggleMuteChannel,
-    setDisablePings,
-    isPingsDisabled,
+    setChannelLevel,
     openSettingsModal,
   };
 })();
@@ -1,5 +1,8 @@
 // voice.js — WebRTC voice/video room manager for Chirm
-// Mesh P2P topology. Server relays signaling only.
+// Mesh P2P topology by default; server relays signaling only.  When the
+// server runs its built-in SFU (voice.room_state carries sfu: true) each
+// client instead keeps one peer connection to the server, which forwards
+// everyone's tracks.
 // V14: Opus codec tuning, per-user controls, focus mode, speaking indicators, screen sharing.
 
 const Voice = (() => {
@@ -18,10 +21,49 @@ const Voice = (() => {
   // Focus / spotlight state
   let focusedTileId = null;       // user-id or 'local' or 'screen-local' / 'screen-<uid>'
   let autoFocusSpeaker = false;   // auto-focus whoever is speaking
+  const SPEAKING_HANGOVER_MS = 400;
+  let localSpeaking = false;
+  let lastLoudAt = 0;
+  const remoteSpeaking = new Set(); // user IDs the server says are talking
 
   // peers: userId → { pc, initiator }
   const peers = {};
 
+  // SFU mode: single connection to the server instead of one per peer
+  let sfuMode = false;
+  let sfuPc = null;
+
+  // What the server lets us send in the current room (from voice.room_state)
+  let voicePerms = { speak: true, video: true, screen_share: true };
+
+  // In-call chat (voice.chat): only lives as long as we're in the room
+  let chatLog = [];
+  let chatOpen = false;
+  let chatUnread = 0;
+  const CHAT_LOG_MAX = 200;
+
+  // Moderator-imposed state: userId → { muted, deafened }
+  const modState = {};
+  const PERM_MUTE_MEMBERS = 2048;
+
+  // Stage channels: null outside a stage, else { speakers: Set, hands: Set }
+  let stage = null;
+
+  // Broadcast channels: null outside one, else { streamer, canStream }
+  let broadcast = null;
+
+  // Admin-set caps for the current room (0 = no cap)
+  let roomQuality = { audio_bitrate: 0, video_height: 0 };
+
+  // Server-side recording (SFU only)
+  let recording = false;
+  let recordingAvailable = false;
+  const PERM_RECORD_VOICE = 4096;
+
+  // Soundboard clips play locally in every client in the room
+  const PERM_SOUNDBOARD = 8192;
+  const SOUND_VOLUME = 0.5;
+
   // camStateByPeer: userId → bool
   const camStateByPeer = {};
   // screenStateByPeer: userId → bool
@@ -35,10 +77,26 @@ const Voice = (() => {
   let speakingCheckInterval = null;
   const SPEAKING_THRESHOLD = 25;
 
-  const ICE_SERVERS = [
+  // Used until (or if) /api/voice/ice-servers answers.
+  const DEFAULT_ICE_SERVERS = [
     { urls: 'stun:stun.l.google.com:19302' },
     { urls: 'stun:stun1.l.google.com:19302' },
   ];
+  let iceServers = DEFAULT_ICE_SERVERS;
+  let iceServersExpireAt = 0;
+
+  // Fetch the server's STUN/TURN list.  TURN credentials are time-limited, so
+  // refetch once half their lifetime has passed.
+  async function refreshIceServers() {
+    if (Date.now() < iceServersExpireAt) return;
+    try {
+      const res = await api.get('/api/v1/voice/ice-servers');
+      if (Array.isArray(res.ice_servers)) iceServers = res.ice_servers;
+      iceServersExpireAt = res.ttl ? Date.now() + res.ttl * 500 : 0;
+    } catch (e) {
+      console.warn('[voice] could not load ICE servers, using defaults:', e);
+    }
+  }
 
   // ── Opus codec tuning ───────────────────────────────────────────────────
   // Prefer Opus and set higher bitrate for richer, less "tinny" audio.
@@ -75,7 +133,7 @@ const Voice = (() => {
         if (fm && opusPTs.includes(fm[1])) {
           let params = fm[2];
           const hq = {
-            'maxaveragebitrate': '128000',
+            'maxaveragebitrate': String((roomQuality.audio_bitrate || 128) * 1000),
             'stereo': '1',
             'sprop-stereo': '1',
             'useinbandfec': '1',
@@ -121,7 +179,19 @@ const Voice = (() => {
     const aud = tile.querySelector('audio');
     if (!aud) return;
     aud.volume = pref.muted ? 0 : Math.min(pref.volume / 100, 2.0);
-    aud.muted = deafened || pref.muted;
+    aud.muted = isSilenced(uid);
+  }
+
+  // Whether a peer's audio must not be played: local deafen/mute, or a
+  // moderator has server-muted them or server-deafened us.
+  function isSilenced(uid) {
+    return deafened || getPeerPref(uid).muted || !!modState[uid]?.muted || !!modState[App.user.id]?.deafened
+      || (!!stage && !stage.speakers.has(uid));
+  }
+
+  function canModerate() {
+    const u = App.user;
+    return !!u && (u.is_owner || (u.permissions & 64) !== 0 || (u.permissions & PERM_MUTE_MEMBERS) !== 0);
   }
 
   function applyPeerVideoHiddenPref(uid) {
@@ -218,6 +288,7 @@ const Voice = (() => {
   async function join(channelId) {
     if (currentChannelId) await leave();
     if (!checkSecureContext()) return false;
+    if (typeof Calls !== 'undefined' && Calls.active()) Calls.hangup();
 
     currentChannelId = channelId;
     videoTrackAvailable = false;
@@ -226,25 +297,33 @@ const Voice = (() => {
     autoFocusSpeaker = false;
 
     showVoiceLoadingUI(channelId);
+    await refreshIceServers();
 
-    try {
-      localStream = await navigator.mediaDevices.getUserMedia({ audio: true, video: true });
-      videoTrackAvailable = true;
-      localStream.getVideoTracks().forEach(t => { t.enabled = false; });
-    } catch {
+    // Broadcast listeners never send anything, so the mic is only
+    // requested if they go live.
+    const ch = App.channels?.find(c => c.id === channelId);
+    if (ch?.type === 'broadcast') {
+      localStream = null;
+    } else {
       try {
-        localStream = await navigator.mediaDevices.getUserMedia({ audio: true, video: false });
-      } catch (aErr) {
-        const msg = aErr.name === 'NotAllowedError'
-          ? 'Microphone access denied. Allow microphone in browser/system settings.'
-          : 'Could not access microphone: ' + aErr.message;
-        toast(msg, 'error');
-        currentChannelId = null;
-        return false;
+        localStream = await navigator.mediaDevices.getUserMedia({ audio: true, video: true });
+        videoTrackAvailable = true;
+        localStream.getVideoTracks().forEach(t => { t.enabled = false; });
+      } catch {
+        try {
+          localStream = await navigator.mediaDevices.getUserMedia({ audio: true, video: false });
+        } catch (aErr) {
+          const msg = aErr.name === 'NotAllowedError'
+            ? 'Microphone access denied. Allow microphone in browser/system settings.'
+            : 'Could not access microphone: ' + aErr.message;
+          toast(msg, 'error');
+          currentChannelId = null;
+          return false;
+        }
       }
     }
 
-    micEnabled = true;
+    micEnabled = !!localStream;
     camEnabled = false;
 
     const subEl = document.querySelector('.voice-loading-sub');
@@ -269,6 +348,16 @@ const Voice = (() => {
     WS.send('voice.leave', { channel_id: chId });
 
     for (const uid of Object.keys(peers)) destroyPeer(uid);
+    destroySfuPeer();
+    sfuMode = false;
+    stage = null;
+    broadcast = null;
+    recording = false;
+    recordingAvailable = false;
+    chatLog = [];
+    chatOpen = false;
+    chatUnread = 0;
+    roomQuality = { audio_bitrate: 0, video_height: 0 };
     for (const uid of Object.keys(camStateByPeer)) delete camStateByPeer[uid];
     for (const uid of Object.keys(screenStateByPeer)) delete screenStateByPeer[uid];
 
@@ -292,6 +381,14 @@ const Voice = (() => {
 
   function toggleMic() {
     if (!localStream) return;
+    if (!voicePerms.speak) {
+      toast("You don't have permission to speak in this channel.", 'error');
+      return;
+    }
+    if (!micEnabled && modState[App.user.id]?.muted) {
+      toast('A moderator has muted you.', 'error');
+      return;
+    }
     micEnabled = !micEnabled;
     localStream.getAudioTracks().forEach(t => { t.enabled = micEnabled; });
     updateVoiceControls();
@@ -304,13 +401,17 @@ const Voice = (() => {
       toast('Camera not available — it was denied when joining. Rejoin to grant camera access.', 'error');
       return;
     }
+    if (!camEnabled && !voicePerms.video) {
+      toast("You don't have permission to use video in this channel.", 'error');
+      return;
+    }
     camEnabled = !camEnabled;
     localStream.getVideoTracks().forEach(t => { t.enabled = camEnabled; });
     if (camEnabled) {
       const vt = localStream.getVideoTracks()[0];
       if (vt) {
-        for (const uid of Object.keys(peers)) {
-          const s = peers[uid].pc.getSenders().find(s => s.track?.kind === 'video');
+        for (const pc of allPeerConnections()) {
+          const s = pc.getSenders().find(s => s.track?.kind === 'video');
           if (s) s.replaceTrack(vt).catch(() => {});
         }
       }
@@ -322,12 +423,15 @@ const Voice = (() => {
   }
 
   function toggleDeafen() {
+    if (deafened && modState[App.user.id]?.deafened) {
+      toast('A moderator has deafened you.', 'error');
+      return;
+    }
     deafened = !deafened;
     document.querySelectorAll('#voice-grid .vc-tile:not(#voice-tile-local) audio').forEach(a => {
       const uid = a.closest('.vc-tile')?.id?.replace('voice-tile-', '');
       if (uid) {
-        const pref = getPeerPref(uid);
-        a.muted = deafened || pref.muted;
+        a.muted = isSilenced(uid);
       } else {
         a.muted = deafened;
       }
@@ -353,6 +457,10 @@ const Voice = (() => {
 
   async function startScreenShare() {
     if (!currentChannelId || screenSharing) return;
+    if (!voicePerms.screen_share) {
+      toast("You don't have permission to share your screen in this channel.", 'error');
+      return;
+    }
     if (!navigator.mediaDevices?.getDisplayMedia) {
       toast('Screen sharing is not supported on this device or browser.', 'error');
       return;
@@ -368,13 +476,13 @@ const Voice = (() => {
     }
 
     screenSharing = true;
+    applyRoomQuality();
 
     // Browser stop-sharing button
     screenStream.getVideoTracks()[0].addEventListener('ended', () => stopScreenShare());
 
     // Add screen tracks to all peer connections
-    for (const uid of Object.keys(peers)) {
-      const pc = peers[uid].pc;
+    for (const pc of allPeerConnections()) {
       for (const track of screenStream.getTracks()) pc.addTrack(track, screenStream);
     }
 
@@ -400,8 +508,7 @@ const Voice = (() => {
       const screenTrackIds = new Set(screenStream.getTracks().map(t => t.id));
 
       // Remove senders from peer connections BEFORE stopping tracks
-      for (const uid of Object.keys(peers)) {
-        const pc = peers[uid].pc;
+      for (const pc of allPeerConnections()) {
         for (const sender of pc.getSenders()) {
           if (sender.track && screenTrackIds.has(sender.track.id)) {
             try { pc.removeTrack(sender); } catch {}
@@ -426,6 +533,13 @@ const Voice = (() => {
     }
   }
 
+  // Every connection local tracks are sent on — mesh peers or the SFU.
+  function allPeerConnections() {
+    const pcs = Object.values(peers).map(p => p.pc);
+    if (sfuPc) pcs.push(sfuPc);
+    return pcs;
+  }
+
   function triggerRenegotiation(uid) {
     const pc = peers[uid]?.pc;
     if (!pc) return;
@@ -451,21 +565,495 @@ const Voice = (() => {
   function onRoomState(data) {
     if (data.channel_id !== currentChannelId) return;
     const participants = data.participants || [];
-    for (const uid of participants) {
-      if (uid !== App.user.id) createPeer(uid, true);
+    voicePerms = { speak: true, video: true, screen_share: true, ...(data.permissions || {}) };
+    if (!voicePerms.speak && localStream) {
+      // Listen-only room: keep the mic track but never let it carry audio.
+      micEnabled = false;
+      localStream.getAudioTracks().forEach(t => { t.enabled = false; });
+      updateVoiceControls();
+    }
+    stage = data.stage ? { speakers: new Set(data.stage.speakers), hands: new Set(data.stage.hands) } : null;
+    applyStageToTiles();
+    renderStageBar();
+    broadcast = data.broadcast
+      ? { streamer: data.broadcast.streamer_id || '', canStream: !!voicePerms.broadcast }
+      : null;
+    // Our broadcast ended while we were disconnected.
+    if (broadcast && broadcast.streamer !== App.user.id) stopLiveAudio();
+    renderBroadcastBar();
+    recordingAvailable = !!data.recording_available;
+    if (data.recording && !recording) toast('🔴 This call is being recorded.', 'info');
+    recording = !!data.recording;
+    updateRecordingUI();
+    for (const uid of Object.keys(modState)) delete modState[uid];
+    Object.assign(modState, data.moderation || {});
+    const selfMod = modState[App.user.id];
+    if (selfMod) applySelfModeration(selfMod);
+    // Anyone we still have a connection to who isn't listed left while our
+    // WebSocket was down.  After a resume the remaining connections carry on;
+    // otherwise the others have dropped theirs and we start over.
+    for (const uid of Object.keys(peers)) {
+      if (!participants.includes(uid)) onUserLeft({ user_id: uid });
+      else if (!data.resumed || peerBroken(peers[uid].pc)) destroyPeer(uid);
+    }
+    sfuMode = !!data.sfu;
+    if (sfuMode) {
+      // (Re)connect to the server; it forwards everyone else's tracks.
+      if (!data.resumed || peerBroken(sfuPc)) {
+        destroySfuPeer();
+        createSfuPeer();
+      }
+    } else {
+      // The hub drops offers from server-muted users, so they wait for the
+      // others to call them instead (see onModeration).
+      const initiate = !selfMod?.muted;
+      for (const uid of participants) {
+        if (uid !== App.user.id) createPeer(uid, initiate);
+      }
     }
     if (participants.length > 0) sendMediaState();
+    roomQuality = { audio_bitrate: 0, video_height: 0, ...(data.quality || {}) };
+    applyRoomQuality();
+  }
+
+  // ── Room quality caps ───────────────────────────────────────────────────
+  function onQuality(data) {
+    if (data.channel_id !== currentChannelId) return;
+    roomQuality = { audio_bitrate: data.audio_bitrate || 0, video_height: data.video_height || 0 };
+    applyRoomQuality();
+  }
+
+  // Cap outgoing video resolution and audio bitrate to the room's settings.
+  // The Opus cap is also written into our SDP (preferOpusHighQuality) so it
+  // holds for connections negotiated later.
+  function applyRoomQuality() {
+    const h = roomQuality.video_height;
+    const cap = t => t.applyConstraints(h ? { height: { max: h } } : {}).catch(() => {});
+    localStream?.getVideoTracks().forEach(cap);
+    screenStream?.getVideoTracks().forEach(cap);
+
+    const maxBitrate = roomQuality.audio_bitrate * 1000;
+    for (const pc of allPeerConnections()) {
+      for (const sender of pc.getSenders()) {
+        if (sender.track?.kind !== 'audio') continue;
+        const params = sender.getParameters();
+        if (!params.encodings?.length) continue;
+        for (const e of params.encodings) {
+          if (maxBitrate) e.maxBitrate = maxBitrate;
+          else delete e.maxBitrate;
+        }
+        sender.setParameters(params).catch(() => {});
+      }
+    }
+  }
+
+  // ── Stage channels ──────────────────────────────────────────────────────
+  function onStageState(data) {
+    if (data.channel_id !== currentChannelId) return;
+    const wasSpeaker = !!stage?.speakers.has(App.user.id);
+    stage = { speakers: new Set(data.speakers || []), hands: new Set(data.hands || []) };
+    const isSpeaker = stage.speakers.has(App.user.id);
+
+    if (isSpeaker && !wasSpeaker) {
+      voicePerms = { speak: true, video: true, screen_share: true };
+      toast("You're now a speaker — unmute your mic to talk.", 'info');
+    } else if (!isSpeaker && wasSpeaker) {
+      voicePerms = { speak: false, video: false, screen_share: false };
+      micEnabled = false;
+      localStream?.getAudioTracks().forEach(t => { t.enabled = false; });
+      if (camEnabled) toggleCamOff();
+      if (screenSharing) stopScreenShare();
+      toast('You were moved to the audience.', 'info');
+    }
+
+    applyStageToTiles();
+    renderStageBar();
+    updateVoiceControls();
+  }
+
+  // Dim audience tiles and silence their audio.
+  function applyStageToTiles() {
+    document.querySelectorAll('#voice-grid .vc-tile').forEach(tile => {
+      const uid = tile.id === 'voice-tile-local' ? App.user.id : tile.id.replace('voice-tile-', '');
+      if (uid.startsWith('screen-')) return;
+      tile.classList.toggle('vc-stage-audience', !!stage && !stage.speakers.has(uid));
+      const a = tile.querySelector('audio');
+      if (a && uid !== App.user.id) a.muted = isSilenced(uid);
+    });
+  }
+
+  function toggleCamOff() {
+    camEnabled = false;
+    localStream?.getVideoTracks().forEach(t => { t.enabled = false; });
+    sendMediaState();
+    attachLocalVideo();
+  }
+
+  function renderStageBar() {
+    const bar = document.getElementById('stage-bar');
+    if (!bar) return;
+    if (!stage) { bar.style.display = 'none'; bar.innerHTML = ''; return; }
+    bar.style.display = '';
+    const me = App.user.id;
+    const memberName = uid => esc(App.members.find(m => m.id === uid)?.username || uid.slice(0, 8));
+    const mine = stage.speakers.has(me)
+      ? `<span class="stage-role">🎤 You're a speaker</span>
+         <button class="btn-sm" onclick="Voice.stageStepDown()">Move to audience</button>`
+      : `<span class="stage-role">🎧 You're in the audience</span>
+         <button class="btn-sm" onclick="Voice.toggleHand()">${stage.hands.has(me) ? 'Lower hand' : '✋ Raise hand'}</button>`;
+    const hands = canModerate() && stage.hands.size > 0
+      ? `<div class="stage-hands">${[...stage.hands].map(uid => `
+          <span class="stage-hand">✋ ${memberName(uid)}
+            <button class="btn-sm" onclick="Voice.inviteToSpeak('${esc(uid)}')">Invite to speak</button>
+          </span>`).join('')}</div>`
+      : '';
+    bar.innerHTML = `<div class="stage-me">${mine}</div>${hands}`;
+  }
+
+  function toggleHand() {
+    if (!stage || !currentChannelId) return;
+    WS.send('stage.hand', { channel_id: currentChannelId, raised: !stage.hands.has(App.user.id) });
+  }
+
+  async function inviteToSpeak(uid) {
+    try { await api.post(`/api/v1/voice/${currentChannelId}/stage/speakers/${uid}`, {}); }
+    catch (e) { toast(e.message, 'error'); }
+  }
+
+  async function stageStepDown() {
+    try { await api.del(`/api/v1/voice/${currentChannelId}/stage/speakers/${App.user.id}`); }
+    catch (e) { toast(e.message, 'error'); }
+  }
+
+  function applySelfModeration(st) {
+    if (st.muted && localStream) {
+      micEnabled = false;
+      localStream.getAudioTracks().forEach(t => { t.enabled = false; });
+    }
+    if (st.deafened) deafened = true;
+    document.querySelectorAll('#voice-grid .vc-tile:not(#voice-tile-local) audio').forEach(a => {
+      const uid = a.closest('.vc-tile')?.id?.replace('voice-tile-', '');
+      if (uid) a.muted = isSilenced(uid);
+    });
+    updateVoiceControls();
+  }
+
+  function onModeration(data) {
+    if (data.channel_id !== currentChannelId) return;
+    const uid = data.user_id;
+    const prev = modState[uid] || {};
+    const st = { muted: !!data.muted, deafened: !!data.deafened };
+    if (st.muted || st.deafened) modState[uid] = st;
+    else delete modState[uid];
+
+    if (uid === App.user.id) {
+      if (st.muted && !prev.muted) toast('A moderator muted you.', 'info');
+      if (!st.muted && prev.muted) toast('A moderator unmuted you — unmute your mic to talk.', 'info');
+      if (st.deafened && !prev.deafened) toast('A moderator deafened you.', 'info');
+      if (!st.deafened && prev.deafened) { deafened = false; toast('A moderator undeafened you.', 'info'); }
+      applySelfModeration(st);
+      return;
+    }
+
+    applyPeerAudioPrefs(uid);
+    const tile = document.getElementById(`voice-tile-${uid}`);
+    if (tile) updatePeerControlState(tile, uid);
+
+    // A muted user can't send offers, so call them if we were waiting on them.
+    const p = peers[uid];
+    if (!sfuMode && st.muted && p && !p.initiator && !p.pc.remoteDescription) {
+      destroyPeer(uid);
+      createPeer(uid, true);
+    }
+  }
+
+  // Server mute/deafen another user (moderators only).
+  async function moderate(uid, action) {
+    if (!currentChannelId) return;
+    const st = modState[uid] || {};
+    const field = action === 'mute' ? 'muted' : 'deafened';
+    try {
+      await api.post(`/api/v1/voice/${currentChannelId}/users/${uid}/${action}`, { [field]: !st[field] });
+    } catch (e) { toast(e.message, 'error'); }
+  }
+
+  // ── Broadcast channels ──────────────────────────────────────────────────
+  function onBroadcastState(data) {
+    if (!broadcast || data.channel_id !== currentChannelId) return;
+    const me = App.user.id;
+    const was = broadcast.streamer;
+    broadcast.streamer = data.streamer_id || '';
+    if (was === me && broadcast.streamer !== me) {
+      stopLiveAudio();
+      toast('Your broadcast has ended.', 'info');
+    } else if (broadcast.streamer && broadcast.streamer !== was && broadcast.streamer !== me) {
+      const name = App.members.find(m => m.id === broadcast.streamer)?.username || 'Someone';
+      toast(`📻 ${name} is live.`, 'info');
+    }
+    renderBroadcastBar();
+  }
+
+  function renderBroadcastBar() {
+    const bar = document.getElementById('broadcast-bar');
+    if (!bar) return;
+    if (!broadcast) { bar.style.display = 'none'; bar.innerHTML = ''; return; }
+    bar.style.display = '';
+    const me = App.user.id;
+    const s = broadcast.streamer;
+    const name = esc(App.members.find(m => m.id === s)?.username || s.slice(0, 8));
+    const endBtn = '<button class="btn-sm" onclick="Voice.endLive()">End broadcast</button>';
+    let status, action = '';
+    if (s === me) {
+      status = `<span class="broadcast-live">● LIVE</span> You're broadcasting`;
+      action = endBtn;
+    } else if (s) {
+      status = `<span class="broadcast-live">● LIVE</span> 📻 ${name}`;
+      if (canModerate()) action = endBtn;
+    } else {
+      status = '📻 Nobody is live right now';
+      if (broadcast.canStream) action = '<button class="btn-sm" onclick="Voice.goLive()">🔴 Go live</button>';
+    }
+    bar.innerHTML = `<div class="broadcast-status">${status}</div>${action}`;
+  }
+
+  async function goLive() {
+    if (!broadcast || !currentChannelId) return;
+    // Music needs the raw signal; voice processing would gate and pump it.
+    let stream;
+    try {
+      stream = await navigator.mediaDevices.getUserMedia({
+        audio: { echoCancellation: false, noiseSuppression: false, autoGainControl: false, channelCount: 2 },
+      });
+    } catch (e) {
+      toast('Could not access microphone: ' + e.message, 'error');
+      return;
+    }
+    try {
+      await api.post(`/api/v1/voice/${currentChannelId}/broadcast`, {});
+    } catch (e) {
+      stream.getTracks().forEach(t => t.stop());
+      toast(e.message, 'error');
+      return;
+    }
+    localStream = stream;
+    micEnabled = true;
+    voicePerms.speak = true;
+    if (sfuPc) stream.getTracks().forEach(t => sfuPc.addTrack(t, stream));
+    setupLocalAudioAnalyser();
+    renderBroadcastBar();
+    updateVoiceControls();
+  }
+
+  async function endLive() {
+    if (!currentChannelId) return;
+    try { await api.del(`/api/v1/voice/${currentChannelId}/broadcast`); }
+    catch (e) { toast(e.message, 'error'); }
+  }
+
+  // Stop sending after our broadcast ends; we stay on as a listener.
+  function stopLiveAudio() {
+    if (!localStream) return;
+    const tracks = localStream.getTracks();
+    for (const s of sfuPc?.getSenders() || []) {
+      if (s.track && tracks.includes(s.track)) sfuPc.removeTrack(s);
+    }
+    tracks.forEach(t => t.stop());
+    localStream = null;
+    micEnabled = false;
+    voicePerms.speak = false;
+    destroyAudioAnalyser('local');
+    updateVoiceControls();
+  }
+
+  // ── Recording ───────────────────────────────────────────────────────────
+  // Record Voice, or Administrator, in the call's guild.
+  function canRecord() {
+    const u = App.user;
+    if (!u) return false;
+    const perms = 64 | PERM_RECORD_VOICE;
+    const guild = App.channels.find(c => c.id === currentChannelId)?.guild_id || App.guild;
+    if (guild === 'default') return u.is_owner || (u.permissions & perms) !== 0;
+    const g = App.guilds.find(g => g.id === guild);
+    return ((g?.permissions || 0) & perms) !== 0;
+  }
+
+  function onRecording(data) {
+    if (data.channel_id !== currentChannelId) return;
+    const was = recording;
+    recording = !!data.recording;
+    if (recording && !was) toast('🔴 This call is now being recorded.', 'info');
+    if (!recording && was) toast('Recording stopped.', 'info');
+    updateRecordingUI();
+  }
+
+  function updateRecordingUI() {
+    const badge = document.getElementById('vp-rec-indicator');
+    if (badge) badge.style.display = recording ? '' : 'none';
+    const btn = document.getElementById('vp-record-btn');
+    if (btn) {
+      btn.style.display = recordingAvailable && canRecord() ? '' : 'none';
+      btn.title = recording ? 'Stop recording' : 'Start recording';
+      btn.classList.toggle('active', recording);
+    }
+    const list = document.getElementById('vp-recordings-btn');
+    if (list) list.style.display = recordingAvailable && canRecord() ? '' : 'none';
+  }
+
+  async function toggleRecording() {
+    if (!currentChannelId) return;
+    try {
+      if (recording) await api.del(`/api/v1/voice/${currentChannelId}/recording`);
+      else await api.post(`/api/v1/voice/${currentChannelId}/recording`, {});
+    } catch (e) { toast(e.message, 'error'); }
+  }
+
+  async function showRecordings() {
+    let recs;
+    try { recs = await api.get('/api/v1/recordings'); }
+    catch (e) { toast(e.message, 'error'); return; }
+    const chName = id => esc(App.channels.find(c => c.id === id)?.name || 'deleted channel');
+    const userName = id => esc(App.members.find(m => m.id === id)?.username || id.slice(0, 8));
+    const rows = recs.map(r => `
+      <div class="recording-item">
+        <div class="recording-meta">
+          <strong>🔊 ${chName(r.channel_id)}</strong>
+          <span class="text-muted text-sm">${new Date(r.started_at).toLocaleString()} · by ${userName(r.started_by)}${r.ended_at ? '' : ' · recording…'}</span>
+        </div>
+        ${r.files.map(f => `<div class="recording-track">
+          <span class="text-sm">${userName(f.replace(/-\d+\.ogg$/, ''))}</span>
+          <audio controls preload="none" src="/api/v1/recordings/${r.id}/files/${encodeURIComponent(f)}"></audio>
+        </div>`).join('')}
+      </div>`).join('');
+    showSimpleModal('Recordings', rows || '<p class="text-muted">No recordings yet.</p>');
+  }
+
+  // ── Soundboard ──────────────────────────────────────────────────────────
+  function canUseSoundboard() {
+    const u = App.user;
+    return !!u && (u.is_owner || (u.permissions & 64) !== 0 || (u.permissions & PERM_SOUNDBOARD) !== 0);
+  }
+
+  async function showSoundboard() {
+    let sounds;
+    try { sounds = await api.get('/api/v1/sounds'); }
+    catch (e) { toast(e.message, 'error'); return; }
+    const btns = sounds.map(s =>
+      `<button class="btn btn-secondary soundboard-btn" onclick="Voice.playSound('${s.id}')">${esc(s.name)}</button>`
+    ).join('');
+    showSimpleModal('Soundboard', btns
+      ? `<div class="soundboard-grid">${btns}</div>`
+      : '<p class="text-muted">No sounds yet. Admins can upload them in Server Settings.</p>');
+  }
+
+  function playSound(soundId) {
+    if (!currentChannelId) return;
+    WS.send('voice.sound', { channel_id: currentChannelId, sound_id: soundId });
+  }
+
+  function onSound(data) {
+    if (data.channel_id !== currentChannelId) return;
+    if (isSilenced(data.user_id)) return;
+    const a = new Audio(data.url);
+    a.volume = SOUND_VOLUME;
+    a.play().catch(() => {});
+  }
+
+  function onSoundError(data) {
+    if (data.channel_id !== currentChannelId) return;
+    toast(data.error, 'error');
+  }
+
+  // ── In-call chat ────────────────────────────────────────────────────────
+  function toggleChat() {
+    chatOpen = !chatOpen;
+    if (chatOpen) chatUnread = 0;
+    renderChat();
+    if (chatOpen) document.getElementById('voice-chat-input')?.focus();
+  }
+
+  function sendChat(e) {
+    e.preventDefault();
+    const input = document.getElementById('voice-chat-input');
+    const content = input?.value.trim();
+    if (!content || !currentChannelId) return;
+    WS.send('voice.chat', { channel_id: currentChannelId, content });
+    input.value = '';
+  }
+
+  function onChat(data) {
+    if (data.channel_id !== currentChannelId) return;
+    chatLog.push(data);
+    if (chatLog.length > CHAT_LOG_MAX) chatLog.shift();
+    if (!chatOpen && data.user_id !== App.user.id) chatUnread++;
+    renderChat();
+  }
+
+  function onChatError(data) {
+    if (data.channel_id !== currentChannelId) return;
+    toast(data.error, 'error');
+  }
+
+  function renderChat() {
+    const pane = document.getElementById('voice-chat');
+    if (!pane) return;
+    pane.style.display = chatOpen ? '' : 'none';
+    document.getElementById('vp-chat-btn')?.classList.toggle('active', chatOpen);
+    const badge = document.getElementById('vp-chat-badge');
+    if (badge) {
+      badge.textContent = chatUnread > 9 ? '9+' : chatUnread;
+      badge.style.display = chatUnread ? '' : 'none';
+    }
+    if (!chatOpen) return;
+    const log = document.getElementById('voice-chat-log');
+    log.innerHTML = chatLog.length
+      ? chatLog.map(m => `
+        <div class="voice-chat-msg">
+          <span class="voice-chat-author">${esc(m.username)}</span>
+          <span class="voice-chat-time">${new Date(m.created_at).toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' })}</span>
+          <div class="voice-chat-text">${renderContent(m.content)}</div>
+        </div>`).join('')
+      : '<div class="voice-chat-empty">Messages here are only seen by people in the call and aren\'t saved.</div>';
+    log.scrollTop = log.scrollHeight;
+  }
+
+  function onVoiceError(data) {
+    if (data.channel_id !== currentChannelId) return;
+    toast(data.error || 'Could not join voice channel', 'error');
+    leave();
   }
 
   function onUserJoined(data) {
     if (data.channel_id !== currentChannelId) return;
     if (data.user_id === App.user.id) return;
-    createPeer(data.user_id, false);
+    if (!sfuMode) createPeer(data.user_id, false);
     sendMediaState();
   }
 
+  // Another participant's WebSocket dropped; the server holds their slot for
+  // a grace period and we keep their connection in case they come back.
+  function onUserReconnecting(data) {
+    if (data.channel_id !== currentChannelId || data.user_id === App.user.id) return;
+    document.getElementById(`voice-tile-${data.user_id}`)?.classList.add('vc-reconnecting');
+  }
+
+  function onUserResumed(data) {
+    if (data.channel_id !== currentChannelId || data.user_id === App.user.id) return;
+    const uid = data.user_id;
+    document.getElementById(`voice-tile-${uid}`)?.classList.remove('vc-reconnecting');
+    if (!sfuMode && peerBroken(peers[uid]?.pc)) {
+      destroyPeer(uid);
+      createPeer(uid, false);
+    }
+    sendMediaState();
+  }
+
+  function peerBroken(pc) {
+    return !pc || pc.connectionState === 'failed' || pc.connectionState === 'closed';
+  }
+
   function onUserLeft(data) {
     if (data.user_id === App.user.id) return;
+    remoteSpeaking.delete(data.user_id);
     destroyPeer(data.user_id);
     removePeerTile(data.user_id);
     removeScreenTile(data.user_id);
@@ -531,12 +1119,48 @@ const Voice = (() => {
     try { await pc.addIceCandidate(new RTCIceCandidate(data.payload)); } catch {}
   }
 
+  async function onSfuOffer(data) {
+    if (data.channel_id !== currentChannelId || !sfuPc) return;
+    const pc = sfuPc;
+    try {
+      // The server yields to nobody; drop our own pending offer instead.
+      if (pc.signalingState !== 'stable') await pc.setLocalDescription({ type: 'rollback' });
+      await pc.setRemoteDescription(new RTCSessionDescription({
+        type: data.payload.type,
+        sdp: preferOpusHighQuality(data.payload.sdp),
+      }));
+      const answer = await pc.createAnswer();
+      const sdp = preferOpusHighQuality(answer.sdp);
+      await pc.setLocalDescription({ type: answer.type, sdp });
+      WS.send('voice.sfu.answer', {
+        channel_id: currentChannelId,
+        payload: { type: pc.localDescription.type, sdp: pc.localDescription.sdp },
+      });
+    } catch (e) { console.warn('sfu offer error:', e); }
+  }
+
+  async function onSfuAnswer(data) {
+    if (data.channel_id !== currentChannelId || !sfuPc) return;
+    if (sfuPc.signalingState !== 'have-local-offer') return;
+    try {
+      await sfuPc.setRemoteDescription(new RTCSessionDescription({
+        type: data.payload.type,
+        sdp: preferOpusHighQuality(data.payload.sdp),
+      }));
+    } catch (e) { console.warn('sfu answer error:', e); }
+  }
+
+  async function onSfuIce(data) {
+    if (data.channel_id !== currentChannelId || !sfuPc || !data.payload) return;
+    try { await sfuPc.addIceCandidate(new RTCIceCandidate(data.payload)); } catch {}
+  }
+
   // ── Peer lifecycle ────────────────────────────────────────────────────────
 
   function createPeer(uid, initiator) {
     if (peers[uid]) return peers[uid];
 
-    const pc = new RTCPeerConnection({ iceServers: ICE_SERVERS });
+    const pc = new RTCPeerConnection({ iceServers });
     peers[uid] = { pc, initiator };
 
     if (localStream) {
@@ -558,46 +1182,7 @@ const Voice = (() => {
       }
     };
 
-    // Track incoming media — distinguish camera from screen share
-    // We store the original stream ID on elements so we can reliably
-    // detect when a second, different stream arrives.
-    pc.ontrack = (e) => {
-      const incomingStreamId = e.streams[0]?.id || null;
-
-      if (e.track.kind === 'audio') {
-        const existingTile = document.getElementById(`voice-tile-${uid}`);
-        const existingAud = existingTile?.querySelector('audio');
-        const origStreamId = existingAud?.dataset?.origStreamId;
-
-        if (origStreamId && incomingStreamId && incomingStreamId !== origStreamId) {
-          // Second audio stream → screen share audio
-          upsertScreenAudio(uid, e.track);
-        } else {
-          upsertPeerAudio(uid, e.track);
-          // Tag the audio element with the original stream ID
-          const tile = document.getElementById(`voice-tile-${uid}`);
-          const aud = tile?.querySelector('audio');
-          if (aud && incomingStreamId) aud.dataset.origStreamId = incomingStreamId;
-          setupAudioAnalyser(uid, e.track);
-        }
-      } else {
-        const existingTile = document.getElementById(`voice-tile-${uid}`);
-        const existingVid = existingTile?.querySelector('video');
-        const origStreamId = existingVid?.dataset?.origStreamId;
-
-        if (origStreamId && incomingStreamId && incomingStreamId !== origStreamId) {
-          // Second video stream → screen share
-          screenStateByPeer[uid] = true;
-          upsertScreenTile(uid, null, e.streams[0]);
-        } else {
-          upsertPeerTile(uid, e.streams[0]);
-          // Tag the video element with the original stream ID
-          const tile = document.getElementById(`voice-tile-${uid}`);
-          const vid = tile?.querySelector('video');
-          if (vid && incomingStreamId) vid.dataset.origStreamId = incomingStreamId;
-        }
-      }
-    };
+    pc.ontrack = (e) => handleRemoteTrack(uid, e.track, e.streams[0]);
 
     pc.onconnectionstatechange = () => {
       const state = pc.connectionState;
@@ -606,7 +1191,8 @@ const Voice = (() => {
         const wasInit = peers[uid]?.initiator;
         destroyPeer(uid);
         if (currentChannelId) {
-          setTimeout(() => {
+          setTimeout(async () => {
+            await refreshIceServers();
             if (currentChannelId) createPeer(uid, wasInit);
           }, 1500);
         }
@@ -634,6 +1220,114 @@ const Voice = (() => {
     return peers[uid];
   }
 
+  // Track incoming media — distinguish camera from screen share
+  // We store the original stream ID on elements so we can reliably
+  // detect when a second, different stream arrives.
+  function handleRemoteTrack(uid, track, stream) {
+    const incomingStreamId = stream?.id || null;
+
+    if (track.kind === 'audio') {
+      const existingTile = document.getElementById(`voice-tile-${uid}`);
+      const existingAud = existingTile?.querySelector('audio');
+      const origStreamId = existingAud?.dataset?.origStreamId;
+
+      if (origStreamId && incomingStreamId && incomingStreamId !== origStreamId) {
+        // Second audio stream → screen share audio
+        upsertScreenAudio(uid, track);
+      } else {
+        upsertPeerAudio(uid, track);
+        // Tag the audio element with the original stream ID
+        const tile = document.getElementById(`voice-tile-${uid}`);
+        const aud = tile?.querySelector('audio');
+        if (aud && incomingStreamId) aud.dataset.origStreamId = incomingStreamId;
+      }
+    } else {
+      const existingTile = document.getElementById(`voice-tile-${uid}`);
+      const existingVid = existingTile?.querySelector('video');
+      const origStreamId = existingVid?.dataset?.origStreamId;
+
+      if (origStreamId && incomingStreamId && incomingStreamId !== origStreamId) {
+        // Second video stream → screen share
+        screenStateByPeer[uid] = true;
+        upsertScreenTile(uid, null, stream);
+      } else {
+        upsertPeerTile(uid, stream);
+        // Tag the video element with the original stream ID
+        const tile = document.getElementById(`voice-tile-${uid}`);
+        const vid = tile?.querySelector('video');
+        if (vid && incomingStreamId) vid.dataset.origStreamId = incomingStreamId;
+      }
+    }
+  }
+
+  function createSfuPeer() {
+    const pc = new RTCPeerConnection({ iceServers });
+    sfuPc = pc;
+
+    if (localStream) {
+      localStream.getTracks().forEach(track => pc.addTrack(track, localStream));
+    }
+    if (screenSharing && screenStream) {
+      screenStream.getTracks().forEach(track => pc.addTrack(track, screenStream));
+    }
+    // A broadcast listener has nothing to send but still needs a session.
+    if (!pc.getSenders().length) pc.addTransceiver('audio', { direction: 'recvonly' });
+
+    pc.onicecandidate = (e) => {
+      if (e.candidate) {
+        WS.send('voice.sfu.ice', { channel_id: currentChannelId, payload: e.candidate });
+      }
+    };
+
+    // The server labels each forwarded stream "<user id>:<original stream id>".
+    pc.ontrack = (e) => {
+      const stream = e.streams[0];
+      const uid = stream?.id?.split(':')[0];
+      if (!uid || uid === App.user.id) return;
+      handleRemoteTrack(uid, e.track, stream);
+    };
+
+    pc.onnegotiationneeded = async () => {
+      try {
+        const offer = await pc.createOffer();
+        if (pc.signalingState !== 'stable') return;
+        const sdp = preferOpusHighQuality(offer.sdp);
+        await pc.setLocalDescription({ type: offer.type, sdp });
+        WS.send('voice.sfu.offer', {
+          channel_id: currentChannelId,
+          payload: { type: pc.localDescription.type, sdp: pc.localDescription.sdp },
+        });
+      } catch (e) { console.warn('sfu offer create error:', e); }
+    };
+
+    pc.onconnectionstatechange = () => {
+      if (pc !== sfuPc) return;
+      if (pc.connectionState === 'failed') {
+        console.warn('[voice] SFU connection failed — scheduling reconnect');
+        destroySfuPeer();
+        setTimeout(async () => {
+          await refreshIceServers();
+          if (currentChannelId && sfuMode && !sfuPc) createSfuPeer();
+        }, 1500);
+      }
+    };
+
+    return pc;
+  }
+
+  function destroySfuPeer() {
+    if (!sfuPc) return;
+    const pc = sfuPc;
+    sfuPc = null;
+    pc.close();
+
+    // Every remote tile came through this connection — forget their stream
+    // tags so a fresh connection isn't mistaken for screen shares.
+    document.querySelectorAll('#voice-grid .vc-tile video, #voice-grid .vc-tile audio').forEach(el => {
+      delete el.dataset.origStreamId;
+    });
+  }
+
   function destroyPeer(uid) {
     if (!peers[uid]) return;
     peers[uid].pc.close();
@@ -652,19 +1346,8 @@ const Voice = (() => {
   }
 
   // ── Speaking Detection ──────────────────────────────────────────────────
-  function setupAudioAnalyser(uid, audioTrack) {
-    destroyAudioAnalyser(uid);
-    try {
-      const ctx = new (window.AudioContext || window.webkitAudioContext)();
-      const src = ctx.createMediaStreamSource(new MediaStream([audioTrack]));
-      const an = ctx.createAnalyser();
-      an.fftSize = 256;
-      an.smoothingTimeConstant = 0.5;
-      src.connect(an);
-      audioAnalysers[uid] = { analyser: an, dataArray: new Uint8Array(an.frequencyBinCount), audioCtx: ctx, source: src };
-    } catch {}
-  }
-
+  // Only our own mic is measured; everyone else's speaking state arrives as
+  // voice.speaking from the server.
   function setupLocalAudioAnalyser() {
     destroyAudioAnalyser('local');
     if (!localStream) return;
@@ -699,35 +1382,37 @@ const Voice = (() => {
     if (speakingCheckInterval) { clearInterval(speakingCheckInterval); speakingCheckInterval = null; }
     for (const uid of Object.keys(audioAnalysers)) destroyAudioAnalyser(uid);
     document.querySelectorAll('.vc-tile.vc-speaking').forEach(t => t.classList.remove('vc-speaking'));
+    localSpeaking = false;
+    remoteSpeaking.clear();
   }
 
   function checkSpeaking() {
-    let loudestUid = null, loudestLevel = 0;
-
-    for (const [uid, a] of Object.entries(audioAnalysers)) {
+    const a = audioAnalysers['local'];
+    let loud = false;
+    if (a && micEnabled && !deafened) {
       a.analyser.getByteFrequencyData(a.dataArray);
       let sum = 0;
       for (let i = 0; i < a.dataArray.length; i++) sum += a.dataArray[i];
-      const avg = sum / a.dataArray.length;
-
-      const tile = document.getElementById(`voice-tile-${uid}`);
-      if (tile) {
-        const speaking = avg > SPEAKING_THRESHOLD;
-        if (uid === 'local' && (!micEnabled || deafened)) {
-          tile.classList.remove('vc-speaking');
-        } else {
-          tile.classList.toggle('vc-speaking', speaking);
-        }
-        if (speaking && avg > loudestLevel) {
-          loudestLevel = avg;
-          loudestUid = uid;
-        }
-      }
+      loud = sum / a.dataArray.length > SPEAKING_THRESHOLD;
     }
+    // Hold "speaking" briefly through pauses so we don't flap between words.
+    const now = Date.now();
+    if (loud) lastLoudAt = now;
+    const speaking = loud || (localSpeaking && now - lastLoudAt < SPEAKING_HANGOVER_MS);
+    if (speaking === localSpeaking) return;
+    localSpeaking = speaking;
+    document.getElementById('voice-tile-local')?.classList.toggle('vc-speaking', speaking);
+    if (currentChannelId) WS.send('voice.speaking', { channel_id: currentChannelId, speaking });
+  }
 
-    if (autoFocusSpeaker && loudestUid && loudestLevel > SPEAKING_THRESHOLD * 1.5) {
-      if (focusedTileId !== loudestUid) setFocus(loudestUid);
-    }
+  function onSpeaking(data) {
+    if (data.channel_id !== currentChannelId || data.user_id === App.user.id) return;
+    const uid = data.user_id;
+    if (data.speaking) remoteSpeaking.add(uid);
+    else remoteSpeaking.delete(uid);
+    const audible = data.speaking && !isSilenced(uid);
+    document.getElementById(`voice-tile-${uid}`)?.classList.toggle('vc-speaking', audible);
+    if (autoFocusSpeaker && audible && focusedTileId !== uid) setFocus(uid);
   }
 
   // ── Focus / Spotlight ───────────────────────────────────────────────────
@@ -785,14 +1470,28 @@ const Voice = (() => {
       <div id="voice-panel-header">
         <div class="vp-channel-name">&#x1F50A; ${esc(name)}</div>
         <div class="vp-header-actions">
+          <span id="vp-rec-indicator" style="display:none" title="This call is being recorded">● REC</span>
+          <button class="vp-hdr-btn" id="vp-record-btn" style="display:none" onclick="Voice.toggleRecording()" title="Start recording">&#x23FA;</button>
+          <button class="vp-hdr-btn" id="vp-recordings-btn" style="display:none" onclick="Voice.showRecordings()" title="Recordings">&#x1F4FC;</button>
+          <button class="vp-hdr-btn" id="vp-chat-btn" onclick="Voice.toggleChat()" title="Call chat">&#x1F4AC;<span id="vp-chat-badge" class="vp-chat-badge" style="display:none"></span></button>
+          <button class="vp-hdr-btn" id="vp-soundboard-btn" style="display:${canUseSoundboard() ? '' : 'none'}" onclick="Voice.showSoundboard()" title="Soundboard">&#x1F3B5;</button>
           <button class="vp-hdr-btn" id="vp-autofocus-btn" onclick="Voice.toggleAutoFocus()" title="Auto-focus: OFF">&#x1F50D;</button>
           <button class="vp-hdr-btn vp-fullscreen-btn" onclick="Voice.showFullView()" title="Expand to full view">&#x2922;</button>
           <button class="vp-hdr-btn" id="vp-collapse-btn" onclick="Voice.collapsePanel()" title="Collapse voice panel">&#x25BC;</button>
         </div>
       </div>
-      <div id="voice-grid"></div>`;
+      <div id="stage-bar" style="display:none"></div>
+      <div id="broadcast-bar" style="display:none"></div>
+      <div id="voice-grid"></div>
+      <div id="voice-chat" style="display:none">
+        <div id="voice-chat-log"></div>
+        <form id="voice-chat-form" onsubmit="Voice.sendChat(event)">
+          <input id="voice-chat-input" type="text" maxlength="2000" autocomplete="off" placeholder="Message the call — not saved">
+        </form>
+      </div>`;
 
     upsertLocalTile();
+    renderChat();
   }
 
   function hideVoiceUI() {
@@ -840,6 +1539,7 @@ const Voice = (() => {
     if (!tile) {
       const member = App.members.find(m => m.id === uid) || { id: uid, username: uid.slice(0, 8) };
       tile = makeTile(uid, member);
+      if (remoteSpeaking.has(uid)) tile.classList.add('vc-speaking');
       grid.appendChild(tile);
       if (focusedTileId) {
         tile.classList.add(uid === focusedTileId ? 'vc-focused' : 'vc-unfocused');
@@ -885,7 +1585,7 @@ const Voice = (() => {
     }
 
     const pref = getPeerPref(uid);
-    aud.muted = deafened || pref.muted;
+    aud.muted = isSilenced(uid);
     aud.volume = pref.muted ? 0 : Math.min(pref.volume / 100, 2.0);
 
     aud.pause();
@@ -992,6 +1692,9 @@ const Voice = (() => {
       <div class="vc-peer-controls" data-uid="${esc(id)}">
         <button class="vc-peer-btn vc-peer-vol-btn" onclick="event.stopPropagation(); Voice.showPeerVolume('${esc(id)}')" title="Volume">🔊</button>
         <button class="vc-peer-btn vc-peer-vidhide-btn" onclick="event.stopPropagation(); Voice.togglePeerVideoHide('${esc(id)}')" title="Hide Video">📹</button>
+        ${canModerate() ? `
+        <button class="vc-peer-btn vc-peer-smute-btn" onclick="event.stopPropagation(); Voice.moderate('${esc(id)}', 'mute')" title="Server Mute">🎙</button>
+        <button class="vc-peer-btn vc-peer-sdeaf-btn" onclick="event.stopPropagation(); Voice.moderate('${esc(id)}', 'deafen')" title="Server Deafen">🎧</button>` : ''}
       </div>`;
 
     tile.innerHTML = `
@@ -1003,6 +1706,11 @@ const Voice = (() => {
 
     tile.addEventListener('click', () => setFocus(id));
 
+    const stageUid = isLocal ? App.user.id : id;
+    if (stage && !stageUid.startsWith('screen-') && !stage.speakers.has(stageUid)) {
+      tile.classList.add('vc-stage-audience');
+    }
+
     if (!isLocal) updatePeerControlState(tile, id);
 
     return tile;
@@ -1015,6 +1723,18 @@ const Voice = (() => {
       vb.classList.toggle('vc-peer-btn-active', pref.videoHidden);
       vb.title = pref.videoHidden ? 'Show Video' : 'Hide Video';
     }
+    const st = modState[uid] || {};
+    const mb = tile.querySelector('.vc-peer-smute-btn');
+    if (mb) {
+      mb.classList.toggle('vc-peer-btn-active', !!st.muted);
+      mb.title = st.muted ? 'Remove Server Mute' : 'Server Mute';
+    }
+    const db = tile.querySelector('.vc-peer-sdeaf-btn');
+    if (db) {
+      db.classList.toggle('vc-peer-btn-active', !!st.deafened);
+      db.title = st.deafened ? 'Remove Server Deafen' : 'Server Deafen';
+    }
+    tile.classList.toggle('vc-server-muted', !!st.muted);
   }
 
   // ── Per-user volume popup ─────────────────────────────────────────────
@@ -1126,23 +1846,46 @@ const Voice = (() => {
 
   function init() {
     WS.on('voice.room_state',  onRoomState);
+    WS.on('voice.error',       onVoiceError);
+    WS.on('voice.moderation',  onModeration);
+    WS.on('stage.state',       onStageState);
+    WS.on('broadcast.state',   onBroadcastState);
+    WS.on('voice.recording',   onRecording);
+    WS.on('voice.quality',     onQuality);
+    WS.on('voice.speaking',    onSpeaking);
+    WS.on('voice.sound',       onSound);
+    WS.on('voice.sound_error', onSoundError);
+    WS.on('voice.chat',        onChat);
+    WS.on('voice.chat_error',  onChatError);
     WS.on('voice.joined',      onUserJoined);
     WS.on('voice.left',        onUserLeft);
+    WS.on('voice.reconnecting', onUserReconnecting);
+    WS.on('voice.resumed',     onUserResumed);
     WS.on('voice.media_state', onMediaState);
     WS.on('voice.offer',       onOffer);
     WS.on('voice.answer',      onAnswer);
     WS.on('voice.ice',         onIce);
+    WS.on('voice.sfu.offer',   onSfuOffer);
+    WS.on('voice.sfu.answer',  onSfuAnswer);
+    WS.on('voice.sfu.ice',     onSfuIce);
 
     WS.on('ws.connected', () => {
       if (!currentChannelId) return;
-      console.log('[voice] WS reconnected — rejoining voice channel', currentChannelId);
-      for (const uid of Object.keys(peers)) destroyPeer(uid);
+      console.log('[voice] WS reconnected — resuming voice channel', currentChannelId);
+      // Peer connections usually survive a WebSocket blip; the server tells
+      // us in voice.room_state whether our slot was still held.
       setTimeout(() => {
-        if (currentChannelId) WS.send('voice.join', { channel_id: currentChannelId });
+        if (currentChannelId) WS.send('voice.join', { channel_id: currentChannelId, resume: true });
       }, 300);
     });
   }
 
+  // Direct calls (calls.js) share the ICE servers and Opus tuning.
+  async function getIceServers() {
+    await refreshIceServers();
+    return iceServers;
+  }
+
   function isInChannel(channelId) { return currentChannelId === channelId; }
   function inCall() { return currentChannelId !== null; }
 
@@ -1150,6 +1893,11 @@ const Voice = (() => {
     init, join, leave, toggleMic, toggleCam, toggleDeafen,
     toggleScreenShare, isInChannel, collapsePanel, showFullView, inCall,
     showPeerVolume, setPeerVolume, togglePeerMute, togglePeerVideoHide,
-    setFocus, toggleAutoFocus,
+    setFocus, toggleAutoFocus, moderate, toggleHand, inviteToSpeak, stageStepDown,
+    toggleRecording, showRecordings,
+    showSoundboard, playSound,
+    toggleChat, sendChat,
+    goLive, endLive,
+    getIceServers, tuneOpus: preferOpusHighQuality,
   };
 })();
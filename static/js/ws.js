@@ -28,8 +28,10 @@ const WS = (() => {
       } catch {}
     };
 
-    ws.onclose = () => {
+    ws.onclose = (e) => {
       isConnected = false;
+      // Closed for maintenance: reloading shows the maintenance page.
+      if (e.reason === 'maintenance') { location.reload(); return; }
       dispatch('ws.disconnected', {});
       scheduleReconnect();
     };
@@ -30,6 +30,7 @@ const STATIC_ASSETS = [
   '/js/app.js',
   '/js/emoji-data.js',
   '/js/voice.js',
+  '/js/calls.js',
   '/js/cache.js',
   '/js/notifications.js',
   '/js/mentions.js',
@@ -120,6 +121,11 @@ self.addEventListener('push', (event) => {
   try { data = event.data?.json() ?? {}; } catch { data = { body: event.data?.text() }; }
 
   const title = data.title || 'Chirm';
+  // Bursts in a channel arrive as one notification under the channel's tag,
+  // replaced each time with the running count; badge is the total.
+  if (data.badge && self.navigator.setAppBadge) {
+    self.navigator.setAppBadge(data.badge).catch(() => {});
+  }
   const options = {
     body: data.body || 'New message',
     icon: '/assets/jenn-circle.png',
@@ -155,16 +161,22 @@ self.addEventListener('notificationclick', (event) => {
 self.addEventListener('periodicsync', (event) => {
   if (event.tag === 'chirm-check-messages') {
     event.waitUntil(
-      fetch('/api/push/poll', { credentials: 'include' })
+      fetch('/api/v1/push/poll', { credentials: 'include' })
         .then(r => r.json())
-        .then(data => Promise.all((data.notifications || []).map(n =>
-          self.registration.showNotification(n.title || 'Chirm', {
-            body: n.body,
-            icon: '/assets/jenn-circle.png',
-            tag: `chirm-poll-${n.channel_id}`,
-            data: { url: '/', channel_id: n.channel_id },
-          })
-        )))
+        .then(data => {
+          // Badge the installed app with the unread total
+          if (self.navigator.setAppBadge) {
+            (data.total ? self.navigator.setAppBadge(data.total) : self.navigator.clearAppBadge()).catch(() => {});
+          }
+          return Promise.all((data.notifications || []).map(n =>
+            self.registration.showNotification(n.title || 'Chirm', {
+              body: n.body,
+              icon: '/assets/jenn-circle.png',
+              tag: n.tag || `chirm-poll-${n.channel_id}`,
+              data: { url: '/', channel_id: n.channel_id },
+            })
+          ));
+        })
         .catch(() => {})
     );
   }